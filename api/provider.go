@@ -73,13 +73,18 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 	}
 
 	// 添加 web 中间件
-	resolver.MustResolve(func(tk *token.Token, userSrv *service.UserService, limiter *redis_rate.Limiter, translater youdao.Translater) {
+	resolver.MustResolve(func(tk *token.Token, apiKeySrv *service.APIKeyService, limiter *redis_rate.Limiter, translater youdao.Translater) {
 		mws = append(mws, mw.BeforeInterceptor(func(webCtx web.Context) web.Response {
 			// 跨域请求处理，OPTIONS 请求直接返回
 			if webCtx.Method() == http.MethodOptions {
 				return webCtx.JSON(web.M{})
 			}
 
+			// Anthropic SDK 使用 x-api-key 请求头传递 API Key，转换为 Authorization 请求头
+			if apiKey := webCtx.Header("X-Api-Key"); apiKey != "" && webCtx.Header("Authorization") == "" {
+				webCtx.Request().Raw().Header.Set("Authorization", "Bearer "+apiKey)
+			}
+
 			// 基于客户端 IP 的限流
 			clientIP := webCtx.Header("X-Real-IP")
 			if clientIP == "" {
@@ -136,7 +141,7 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 
 				// 查询用户信息
 				var user *auth.User
-				if u, err := userSrv.GetUserByAPIKey(ctx, credential); err != nil {
+				if u, key, err := apiKeySrv.Authenticate(ctx, credential); err != nil {
 					if errors.Is(err, repo2.ErrNotFound) {
 						return errors.New("invalid auth credential, user not found")
 					}
@@ -148,6 +153,8 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 					}
 
 					user = auth.CreateAuthUserFromModel(u)
+					// 附带 API Key 的模型与频率限制
+					user.APIKey = key
 				}
 
 				if user == nil {
@@ -170,6 +177,7 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 		"/v1",
 		controllers.NewOpenAIController(resolver, conf, true),
 		openai.NewOpenAICompatibleController(resolver),
+		controllers.NewAnthropicController(resolver, conf),
 	)
 
 	r.Controllers(
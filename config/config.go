@@ -26,6 +26,8 @@ type Config struct {
 	EnableWebsocket bool `json:"enable_websocket" yaml:"enable_websocket"`
 	// 是否启用 SQL 调试
 	DebugWithSQL bool `json:"debug_with_sql" yaml:"debug_with_sql"`
+	// 录制请求服务提供商的请求和响应的目录，用于编写测试用例，为空时不录制，生产环境不可用
+	DebugRecordProviderDir string `json:"debug_record_provider_dir" yaml:"debug_record_provider_dir"`
 	// 是否启用 API Keys 功能
 	EnableAPIKeys bool `json:"enable_api_keys" yaml:"enable_api_keys"`
 	// 是否是生产环境
@@ -191,6 +193,8 @@ type Config struct {
 
 	// DBURI 数据库连接地址
 	DBURI string `json:"db_uri" yaml:"db_uri"`
+	// ChannelSecretMasterKey 渠道密钥加密存储使用的主密钥，为空时渠道密钥明文存储
+	ChannelSecretMasterKey string `json:"-" yaml:"channel_secret_master_key"`
 	// Redis
 	RedisHost     string `json:"redis_host" yaml:"redis_host"`
 	RedisPort     int    `json:"redis_port" yaml:"redis_port"`
@@ -300,6 +304,156 @@ type Config struct {
 	// Stripe 支付
 	Stripe StripeConfig `json:"stripe" yaml:"stripe"`
 
+	// 默认聊天模型，当请求中未指定模型时使用，留空则不启用
+	DefaultChatModel string `json:"default_chat_model" yaml:"default_chat_model"`
+	// 模型探测消耗的智慧果计入的内部账号 ID，不配置则无法使用模型探测
+	ModelProbeUserID int64 `json:"model_probe_user_id" yaml:"model_probe_user_id"`
+	// 单次聊天请求允许的最大消息数量，超过后直接拒绝
+	MaxChatMessages int `json:"max_chat_messages" yaml:"max_chat_messages"`
+	// 请求内容大小（主要是图片）超过服务提供商限制时的处理策略：reject/downscale
+	ChatPayloadPolicy string `json:"chat_payload_policy" yaml:"chat_payload_policy"`
+	// 聊天输出的平滑速率（Tokens/秒），按照固定的速率向客户端输出内容，为 0 时不启用
+	ChatOutputPacingRate int `json:"chat_output_pacing_rate" yaml:"chat_output_pacing_rate"`
+	// 是否允许内部用户查看实际发送给上游的请求内容（调试使用），默认关闭
+	ChatDebugRequest bool `json:"chat_debug_request" yaml:"chat_debug_request"`
+	// 聊天请求参数的部署级默认值，优先级最低：请求 > 房间（数字人） > 模型（models.meta） > 部署配置
+	// 默认采样温度，为 0 时不指定，使用服务提供商的默认值
+	ChatDefaultTemperature float64 `json:"chat_default_temperature" yaml:"chat_default_temperature"`
+	// 默认核采样概率，为 0 时不指定
+	ChatDefaultTopP float64 `json:"chat_default_top_p" yaml:"chat_default_top_p"`
+	// 默认最大输出 Token 数量，为 0 时不指定
+	ChatDefaultMaxTokens int `json:"chat_default_max_tokens" yaml:"chat_default_max_tokens"`
+	// 默认的图片识别精度：low/high/auto，留空时 OpenAI 系列的服务提供商使用 low
+	ChatDefaultImageDetail string `json:"chat_default_image_detail" yaml:"chat_default_image_detail"`
+	// 默认的回复语言，如 zh-CN，留空则不指定
+	ChatDefaultReplyLanguage string `json:"chat_default_reply_language" yaml:"chat_default_reply_language"`
+	// 长输入压缩默认使用的辅助模型，模型配置（models.meta.compression）中未指定时使用
+	ChatCompressionModel string `json:"chat_compression_model" yaml:"chat_compression_model"`
+	// 保存聊天记录时，是否同时保存实际生效的系统提示语全文（默认只保存哈希），系统提示语中可能包含用户的隐私信息
+	ChatRecordSystemPrompt bool `json:"chat_record_system_prompt" yaml:"chat_record_system_prompt"`
+	// 为输出内容预留的最小 Token 数量，上下文缩减后剩余长度仍低于该值时拒绝请求，为 0 时不预留
+	ChatMinOutputTokens int `json:"chat_min_output_tokens" yaml:"chat_min_output_tokens"`
+	// 是否在请求分发之前，将请求中引用的远程文件（图片）重新存储到对象存储中，避免服务提供商获取时临时地址已经失效
+	ChatFileRehost bool `json:"chat_file_rehost" yaml:"chat_file_rehost"`
+	// 重新存储时单个文件的最大大小（MB）
+	ChatFileMaxSize int `json:"chat_file_max_size" yaml:"chat_file_max_size"`
+	// 是否在启动时检测模型配置（上下文长度、图片输入等）与上游报告的能力是否一致，不一致时只记录警告日志
+	ChatStartupDriftCheck bool `json:"chat_startup_drift_check" yaml:"chat_startup_drift_check"`
+	// 服务提供商非流式响应的最大大小（MB），超过时中断读取并返回错误，渠道配置中可以单独指定
+	ChatMaxResponseSize int `json:"chat_max_response_size" yaml:"chat_max_response_size"`
+	// 流式输出的 Token 数量上限系数，输出超过 max(请求的 max_tokens, 模型的 max_output) 的该倍数时强制终止，为 0 时不限制
+	ChatOutputCapFactor float64 `json:"chat_output_cap_factor" yaml:"chat_output_cap_factor"`
+	// 流式输出的 Token 数量绝对上限，与模型和请求的 max_tokens 无关，超过时强制终止，房间配置中可以指定更小的值，为 0 时不限制
+	ChatMaxOutputTokens int `json:"chat_max_output_tokens" yaml:"chat_max_output_tokens"`
+	// 历史消息中助手消息末尾空白字符的处理策略：off/all/prose
+	ChatAssistantTrim string `json:"chat_assistant_trim" yaml:"chat_assistant_trim"`
+	// 历史消息中因为输出长度限制被截断在代码块中间的助手消息，与“继续”之后的助手消息合并为一条
+	ChatMergeSplitCodeBlocks bool `json:"chat_merge_split_code_blocks" yaml:"chat_merge_split_code_blocks"`
+	// 可以重试的错误信息匹配规则，格式为 "服务提供商类型:匹配规则"，支持通配符 * 和 ?，服务提供商类型为 * 时对所有服务提供商生效
+	ChatRetryErrorPatterns []string `json:"chat_retry_error_patterns" yaml:"chat_retry_error_patterns"`
+	// 所有角色的单条消息内容的最大字符数量，作为没有单独配置限制的角色的默认值，为 0 时不限制
+	ChatMaxContentRunes int `json:"chat_max_content_runes" yaml:"chat_max_content_runes"`
+	// 每种角色的单条消息内容的最大字符数量，为 0 时不限制，默认值足够大，只用于拦截异常的系统提示语和历史消息
+	ChatMaxSystemContentRunes    int `json:"chat_max_system_content_runes" yaml:"chat_max_system_content_runes"`
+	ChatMaxUserContentRunes      int `json:"chat_max_user_content_runes" yaml:"chat_max_user_content_runes"`
+	ChatMaxAssistantContentRunes int `json:"chat_max_assistant_content_runes" yaml:"chat_max_assistant_content_runes"`
+	// 房间消息摘要使用的模型，为空时不生成摘要
+	RoomDigestModel string `json:"room_digest_model" yaml:"room_digest_model"`
+	// 房间消息摘要的最大输入 Token 数量
+	RoomDigestMaxInputTokens int `json:"room_digest_max_input_tokens" yaml:"room_digest_max_input_tokens"`
+	// 房间消息摘要生成后的通知地址（POST JSON），为空时不通知
+	RoomDigestWebhook string `json:"room_digest_webhook" yaml:"room_digest_webhook"`
+	// 用量通知地址，每次请求完成后异步发送签名的用量事件（不包含请求和回答的内容），为空时不通知
+	UsageWebhookURLs []string `json:"usage_webhook_urls" yaml:"usage_webhook_urls"`
+	// 用量通知的签名密钥（HMAC-SHA256）
+	UsageWebhookSecret string `json:"-" yaml:"-"`
+	// 用量通知发送失败时的最大尝试次数（包括第一次），超过之后写入死信表
+	UsageWebhookMaxAttempts int `json:"usage_webhook_max_attempts" yaml:"usage_webhook_max_attempts"`
+	// 用量通知第一次重试前的等待时间（秒），之后每次重试等待时间加倍
+	UsageWebhookBackoff int `json:"usage_webhook_backoff" yaml:"usage_webhook_backoff"`
+	// 流式输出的合并间隔（毫秒），缓存服务提供商返回的片段，间隔时间到达、累积字节数达到 ChatOutputCoalesceBytes
+	// 或者遇到换行时一起输出，减少 SSE 事件的数量，为 0 时不启用（WebSocket 客户端参考 ChatWebSocketAggregateInterval）
+	ChatOutputCoalesceInterval int `json:"chat_output_coalesce_interval" yaml:"chat_output_coalesce_interval"`
+	// 流式输出合并时，累积的最大字节数
+	ChatOutputCoalesceBytes int `json:"chat_output_coalesce_bytes" yaml:"chat_output_coalesce_bytes"`
+	// WebSocket 客户端流式输出的合并间隔（毫秒），间隔时间到达或者累积的 Token 数量达到 ChatWebSocketAggregateTokens 时一起输出，
+	// 减少 WebSocket 帧的数量，为 0 时不启用
+	ChatWebSocketAggregateInterval int `json:"chat_websocket_aggregate_interval" yaml:"chat_websocket_aggregate_interval"`
+	// WebSocket 客户端流式输出合并时，累积的最大 Token 数量（近似值）
+	ChatWebSocketAggregateTokens int `json:"chat_websocket_aggregate_tokens" yaml:"chat_websocket_aggregate_tokens"`
+	// 输出内容中数学公式的分隔符：dollar（$...$ 和 $$...$$）/latex（\(...\) 和 \[...\]），为空时不转换
+	ChatMathDelimiters string `json:"chat_math_delimiters" yaml:"chat_math_delimiters"`
+	// 输出内容中代码块之外的 HTML 标签的处理策略：escape（转义）/strip（去掉），同时替换链接和图片中危险的地址，为空时不处理
+	ChatOutputSanitize string `json:"chat_output_sanitize" yaml:"chat_output_sanitize"`
+	// 处理输出内容时链接文本的最大字符数，为 0 时不限制
+	ChatOutputSanitizeLinkText int `json:"chat_output_sanitize_link_text" yaml:"chat_output_sanitize_link_text"`
+	// 统计渠道请求耗时的时间范围（分钟），为 0 时不统计
+	ChatLatencyWindow int `json:"chat_latency_window" yaml:"chat_latency_window"`
+	// 同时处理的聊天请求的最大数量，达到上限时按照优先级排队，为 0 时不限制
+	ChatMaxConcurrency int `json:"chat_max_concurrency" yaml:"chat_max_concurrency"`
+	// 排队的请求最长的等待时间（秒），超过之后不再按照优先级排序，避免低优先级的请求一直等待
+	ChatQueueMaxWait int `json:"chat_queue_max_wait" yaml:"chat_queue_max_wait"`
+	// 按照用户类型（users.user_type）指定请求的优先级，格式为 "用户类型:优先级"，优先级越大越先处理，未指定的用户类型为 0
+	ChatPriorityTiers []string `json:"chat_priority_tiers" yaml:"chat_priority_tiers"`
+	// 聊天记录的保留天数，超过时由定时任务清理（包括关联的文件），为 0 时永久保留
+	ChatHistoryRetentionDays int `json:"chat_history_retention_days" yaml:"chat_history_retention_days"`
+	// 按照用户类型（users.user_type）覆盖聊天记录的保留天数，格式为 "用户类型:天数"
+	ChatHistoryRetentionTierDays []string `json:"chat_history_retention_tier_days" yaml:"chat_history_retention_tier_days"`
+	// 清理聊天记录时每批删除的最大记录数量
+	ChatHistoryPurgeBatchSize int `json:"chat_history_purge_batch_size" yaml:"chat_history_purge_batch_size"`
+	// 清理聊天记录时每批之间的间隔（毫秒），避免对数据库和对象存储造成压力
+	ChatHistoryPurgeInterval int `json:"chat_history_purge_interval" yaml:"chat_history_purge_interval"`
+	// 聊天中服务端执行的图片生成工具（generate_image）使用的模型，如 dall-e-3、dall-e-3:hd，为空时不启用
+	ChatImageToolModel string `json:"chat_image_tool_model" yaml:"chat_image_tool_model"`
+	// 每个对话中最多可以通过图片生成工具生成的图片数量
+	ChatImageToolLimit int `json:"chat_image_tool_limit" yaml:"chat_image_tool_limit"`
+	// 按照用户类型（users.user_type）覆盖每个对话中最多可以生成的图片数量，格式为 "用户类型:数量"
+	ChatImageToolTierLimits []string `json:"chat_image_tool_tier_limits" yaml:"chat_image_tool_tier_limits"`
+	// 图片生成工具每张图片生成的超时时间（秒）
+	ChatImageToolTimeout int `json:"chat_image_tool_timeout" yaml:"chat_image_tool_timeout"`
+	// 聊天中服务端执行的网页搜索工具（web_search）使用的搜索服务：bing/serper/searxng，为空时不启用
+	ChatWebSearchBackend string `json:"chat_web_search_backend" yaml:"chat_web_search_backend"`
+	// 搜索服务的地址，Bing 和 Serper 为空时使用官方地址，SearXNG 为自部署服务的地址
+	ChatWebSearchServer string `json:"chat_web_search_server" yaml:"chat_web_search_server"`
+	// 搜索服务的 API Key，SearXNG 不需要
+	ChatWebSearchKey string `json:"chat_web_search_key" yaml:"chat_web_search_key"`
+	// 每次搜索返回给模型的结果数量
+	ChatWebSearchResults int `json:"chat_web_search_results" yaml:"chat_web_search_results"`
+	// 读取排名靠前的网页正文的数量，为 0 时只返回搜索结果的摘要
+	ChatWebSearchFetchPages int `json:"chat_web_search_fetch_pages" yaml:"chat_web_search_fetch_pages"`
+	// 每个网页正文的最大长度（字符数）
+	ChatWebSearchPageMaxRunes int `json:"chat_web_search_page_max_runes" yaml:"chat_web_search_page_max_runes"`
+	// 每次搜索（包括读取网页）的超时时间（秒）
+	ChatWebSearchTimeout int `json:"chat_web_search_timeout" yaml:"chat_web_search_timeout"`
+	// 每个请求中最多搜索的次数
+	ChatWebSearchLimit int `json:"chat_web_search_limit" yaml:"chat_web_search_limit"`
+	// 按照用户类型（users.user_type）覆盖每个请求中最多搜索的次数，格式为 "用户类型:次数"，次数为 0 时不允许使用
+	ChatWebSearchTierLimits []string `json:"chat_web_search_tier_limits" yaml:"chat_web_search_tier_limits"`
+	// 允许使用网页搜索的模型（models.model_id），支持通配符（* 匹配任意字符，? 匹配单个字符），为空时允许所有模型
+	ChatWebSearchModels []string `json:"chat_web_search_models" yaml:"chat_web_search_models"`
+	// 服务端工具调用循环的耗时预算（秒），预算即将耗尽时不再调用工具，要求模型直接回答，为 0 时不限制
+	ChatToolLoopBudget int `json:"chat_tool_loop_budget" yaml:"chat_tool_loop_budget"`
+	// 按照用户类型（users.user_type）覆盖工具调用循环的耗时预算，格式为 "用户类型:秒数"
+	ChatToolLoopBudgetTiers []string `json:"chat_tool_loop_budget_tiers" yaml:"chat_tool_loop_budget_tiers"`
+	// 每个房间最多可以固定的消息数量，固定的消息始终包含在上下文中
+	ChatMaxPinnedMessages int `json:"chat_max_pinned_messages" yaml:"chat_max_pinned_messages"`
+	// 用户对回答点踩或者重新生成时，房间中记录的最近的渠道数量，之后的请求尽量避开这些渠道，为 0 时不记录
+	ChatAvoidChannelTurns int `json:"chat_avoid_channel_turns" yaml:"chat_avoid_channel_turns"`
+	// 房间中记录的需要避开的渠道的有效时间（分钟）
+	ChatAvoidChannelTTL int `json:"chat_avoid_channel_ttl" yaml:"chat_avoid_channel_ttl"`
+	// 演示用户（沙箱模式）的预置回答文件（YAML），为空时使用内置的预置回答
+	ChatSandboxResponses string `json:"chat_sandbox_responses" yaml:"chat_sandbox_responses"`
+	// 演示用户流式输出时每个分片之间的间隔（毫秒）
+	ChatSandboxStreamInterval int `json:"chat_sandbox_stream_interval" yaml:"chat_sandbox_stream_interval"`
+	// 按照任务类型调整采样参数的配置文件（YAML），为空时不调整
+	ChatTaskProfiles string `json:"chat_task_profiles" yaml:"chat_task_profiles"`
+	// 增量输入会话未提交时的过期时间（秒）
+	ChatInputSessionTTL int `json:"chat_input_session_ttl" yaml:"chat_input_session_ttl"`
+	// 每个用户最多同时打开的增量输入会话数量
+	ChatInputSessionMaxCount int `json:"chat_input_session_max_count" yaml:"chat_input_session_max_count"`
+	// 增量输入会话中最后一条用户消息的最大字符数量
+	ChatInputSessionMaxRunes int `json:"chat_input_session_max_runes" yaml:"chat_input_session_max_runes"`
+
 	// 首页默认常用模型
 	DefaultHomeModels    []string `json:"default_home_models" yaml:"default_home_models"`
 	DefaultHomeModelsIOS []string `json:"default_home_models_ios" yaml:"default_home_models_ios"`
@@ -321,6 +475,11 @@ func (conf *Config) SupportProxy() bool {
 	return conf.Socks5Proxy != "" || conf.ProxyURL != ""
 }
 
+// ChatMaxResponseSizeBytes 服务提供商非流式响应的最大字节数
+func (conf *Config) ChatMaxResponseSizeBytes() int64 {
+	return int64(conf.ChatMaxResponseSize) * 1024 * 1024
+}
+
 type Mail struct {
 	From         string `json:"from" yaml:"from"`
 	SMTPHost     string `json:"smtp_host" yaml:"smtp_host"`
@@ -381,16 +540,18 @@ func Register(ins *app.App) {
 		stripe.Init()
 
 		return &Config{
-			Listen:              ctx.String("listen"),
-			DBURI:               ctx.String("db-uri"),
-			SessionSecret:       ctx.String("session-secret"),
-			PrometheusToken:     ctx.String("prometheus-token"),
-			EnableRecordChat:    ctx.Bool("enable-recordchat"),
-			EnableCORS:          ctx.Bool("enable-cors"),
-			EnableWebsocket:     ctx.Bool("enable-websocket"),
-			DebugWithSQL:        ctx.Bool("debug-with-sql"),
-			UniversalLinkConfig: strings.TrimSpace(ctx.String("universal-link-config")),
-			ShouldBindPhone:     ctx.Bool("should-bind-phone"),
+			Listen:                 ctx.String("listen"),
+			DBURI:                  ctx.String("db-uri"),
+			ChannelSecretMasterKey: ctx.String("channel-secret-master-key"),
+			SessionSecret:          ctx.String("session-secret"),
+			PrometheusToken:        ctx.String("prometheus-token"),
+			EnableRecordChat:       ctx.Bool("enable-recordchat"),
+			EnableCORS:             ctx.Bool("enable-cors"),
+			EnableWebsocket:        ctx.Bool("enable-websocket"),
+			DebugWithSQL:           ctx.Bool("debug-with-sql"),
+			DebugRecordProviderDir: ctx.String("debug-record-provider-dir"),
+			UniversalLinkConfig:    strings.TrimSpace(ctx.String("universal-link-config")),
+			ShouldBindPhone:        ctx.Bool("should-bind-phone"),
 
 			BaseURL:      strings.TrimSuffix(ctx.String("base-url"), "/"),
 			IsProduction: ctx.Bool("production"),
@@ -607,8 +768,92 @@ func Register(ins *app.App) {
 
 			Stripe: stripe,
 
-			DefaultHomeModels:    ctx.StringSlice("default-home-models"),
-			DefaultHomeModelsIOS: ctx.StringSlice("default-home-models-ios"),
+			DefaultChatModel:         ctx.String("default-chat-model"),
+			ModelProbeUserID:         int64(ctx.Int("model-probe-user-id")),
+			MaxChatMessages:          ctx.Int("max-chat-messages"),
+			ChatPayloadPolicy:        ctx.String("chat-payload-policy"),
+			ChatOutputPacingRate:     ctx.Int("chat-output-pacing-rate"),
+			ChatDebugRequest:         ctx.Bool("chat-debug-request"),
+			ChatDefaultTemperature:   ctx.Float64("chat-default-temperature"),
+			ChatDefaultTopP:          ctx.Float64("chat-default-top-p"),
+			ChatDefaultMaxTokens:     ctx.Int("chat-default-max-tokens"),
+			ChatDefaultImageDetail:   ctx.String("chat-default-image-detail"),
+			ChatDefaultReplyLanguage: ctx.String("chat-default-reply-language"),
+			ChatCompressionModel:     ctx.String("chat-compression-model"),
+			ChatRecordSystemPrompt:   ctx.Bool("chat-record-system-prompt"),
+			ChatMinOutputTokens:      ctx.Int("chat-min-output-tokens"),
+			ChatFileRehost:           ctx.Bool("chat-file-rehost"),
+			ChatFileMaxSize:          ctx.Int("chat-file-max-size"),
+			ChatStartupDriftCheck:    ctx.Bool("chat-startup-drift-check"),
+			ChatMaxResponseSize:      ctx.Int("chat-max-response-size"),
+			ChatOutputCapFactor:      ctx.Float64("chat-output-cap-factor"),
+			ChatMaxOutputTokens:      ctx.Int("chat-max-output-tokens"),
+			ChatAssistantTrim:        ctx.String("chat-assistant-trim"),
+			ChatMergeSplitCodeBlocks: ctx.Bool("chat-merge-split-code-blocks"),
+			ChatRetryErrorPatterns:   ctx.StringSlice("chat-retry-error-patterns"),
+			ChatMaxContentRunes:      ctx.Int("chat-max-content-runes"),
+			DefaultHomeModels:        ctx.StringSlice("default-home-models"),
+			DefaultHomeModelsIOS:     ctx.StringSlice("default-home-models-ios"),
+
+			ChatMaxSystemContentRunes:    ctx.Int("chat-max-system-content-runes"),
+			ChatMaxUserContentRunes:      ctx.Int("chat-max-user-content-runes"),
+			ChatMaxAssistantContentRunes: ctx.Int("chat-max-assistant-content-runes"),
+
+			RoomDigestModel:          ctx.String("room-digest-model"),
+			RoomDigestMaxInputTokens: ctx.Int("room-digest-max-input-tokens"),
+			RoomDigestWebhook:        ctx.String("room-digest-webhook"),
+
+			UsageWebhookURLs:        ctx.StringSlice("usage-webhook-urls"),
+			UsageWebhookSecret:      ctx.String("usage-webhook-secret"),
+			UsageWebhookMaxAttempts: ctx.Int("usage-webhook-max-attempts"),
+			UsageWebhookBackoff:     ctx.Int("usage-webhook-backoff"),
+
+			ChatOutputCoalesceInterval: ctx.Int("chat-output-coalesce-interval"),
+			ChatOutputCoalesceBytes:    ctx.Int("chat-output-coalesce-bytes"),
+
+			ChatWebSocketAggregateInterval: ctx.Int("chat-websocket-aggregate-interval"),
+			ChatWebSocketAggregateTokens:   ctx.Int("chat-websocket-aggregate-tokens"),
+
+			ChatMathDelimiters:         ctx.String("chat-math-delimiters"),
+			ChatOutputSanitize:         ctx.String("chat-output-sanitize"),
+			ChatOutputSanitizeLinkText: ctx.Int("chat-output-sanitize-link-text"),
+			ChatLatencyWindow:          ctx.Int("chat-latency-window"),
+			ChatMaxConcurrency:         ctx.Int("chat-max-concurrency"),
+			ChatQueueMaxWait:           ctx.Int("chat-queue-max-wait"),
+			ChatPriorityTiers:          ctx.StringSlice("chat-priority-tiers"),
+
+			ChatHistoryRetentionDays:     ctx.Int("chat-history-retention-days"),
+			ChatHistoryRetentionTierDays: ctx.StringSlice("chat-history-retention-tier-days"),
+			ChatHistoryPurgeBatchSize:    ctx.Int("chat-history-purge-batch-size"),
+			ChatHistoryPurgeInterval:     ctx.Int("chat-history-purge-interval"),
+
+			ChatImageToolModel:      ctx.String("chat-image-tool-model"),
+			ChatImageToolLimit:      ctx.Int("chat-image-tool-limit"),
+			ChatImageToolTierLimits: ctx.StringSlice("chat-image-tool-tier-limits"),
+			ChatImageToolTimeout:    ctx.Int("chat-image-tool-timeout"),
+
+			ChatWebSearchBackend:      ctx.String("chat-web-search-backend"),
+			ChatWebSearchServer:       ctx.String("chat-web-search-server"),
+			ChatWebSearchKey:          ctx.String("chat-web-search-key"),
+			ChatWebSearchResults:      ctx.Int("chat-web-search-results"),
+			ChatWebSearchFetchPages:   ctx.Int("chat-web-search-fetch-pages"),
+			ChatWebSearchPageMaxRunes: ctx.Int("chat-web-search-page-max-runes"),
+			ChatWebSearchTimeout:      ctx.Int("chat-web-search-timeout"),
+			ChatWebSearchLimit:        ctx.Int("chat-web-search-limit"),
+			ChatWebSearchTierLimits:   ctx.StringSlice("chat-web-search-tier-limits"),
+			ChatWebSearchModels:       ctx.StringSlice("chat-web-search-models"),
+			ChatToolLoopBudget:        ctx.Int("chat-tool-loop-budget"),
+			ChatToolLoopBudgetTiers:   ctx.StringSlice("chat-tool-loop-budget-tiers"),
+
+			ChatMaxPinnedMessages:     ctx.Int("chat-max-pinned-messages"),
+			ChatAvoidChannelTurns:     ctx.Int("chat-avoid-channel-turns"),
+			ChatAvoidChannelTTL:       ctx.Int("chat-avoid-channel-ttl"),
+			ChatSandboxResponses:      ctx.String("chat-sandbox-responses"),
+			ChatSandboxStreamInterval: ctx.Int("chat-sandbox-stream-interval"),
+			ChatTaskProfiles:          ctx.String("chat-task-profiles"),
+			ChatInputSessionTTL:       ctx.Int("chat-input-session-ttl"),
+			ChatInputSessionMaxCount:  ctx.Int("chat-input-session-max-count"),
+			ChatInputSessionMaxRunes:  ctx.Int("chat-input-session-max-runes"),
 
 			TextToVoiceEngine:      ctx.String("text-to-voice-engine"),
 			TextToVoiceAzureRegion: ctx.String("text-to-voice-azure-region"),
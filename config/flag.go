@@ -18,11 +18,13 @@ func initCmdFlags(ins *app.App) {
 	ins.AddStringFlag("socks5-proxy", "", "socks5 proxy")
 	ins.AddStringFlag("proxy-url", "", "HTTP 代理放置，支持 http、https、socks5，代理类型由 URL schema 决定，如果 scheme 为空，则默认为 http")
 	ins.AddStringFlag("db-uri", "root:12345@tcp(127.0.0.1:3306)/aiserver?charset=utf8mb4&parseTime=True&loc=Local", "database url")
+	ins.AddStringFlag("channel-secret-master-key", "", "渠道密钥加密存储使用的主密钥，配置后新增和更新的渠道密钥将加密存储，已有的渠道密钥需要执行 encrypt-channel-secrets 命令加密")
 	ins.AddStringFlag("session-secret", "aidea-secret", "用户会话加密密钥")
 	ins.AddBoolFlag("enable-recordchat", "是否记录聊天历史记录（目前只做记录，没有实际作用，只是为后期增加多端聊天记录同步做准备）")
 	ins.AddBoolFlag("enable-cors", "是否启用跨域请求支持")
 	ins.AddBoolFlag("enable-websocket", "是否启用 WebSocket 支持")
 	ins.AddBoolFlag("debug-with-sql", "是否在日志中输出 SQL 语句")
+	ins.AddStringFlag("debug-record-provider-dir", "", "录制请求服务提供商的请求（已脱敏）和原始响应的目录，用于编写服务提供商适配的测试用例，为空时不录制，生产环境（production）下不生效。只录制 OpenAI 兼容渠道、Anthropic 以及 Gemini 的请求")
 	ins.AddBoolFlag("enable-api-keys", "是否启用 API Keys 功能")
 	ins.AddBoolFlag("enable-model-rate-limit", "是否启用模型请求频率限制，当前限制只支持每分钟 5 次/用户")
 	ins.AddStringFlag("universal-link-config", "", "universal link 配置文件路径，留空则使用默认的 universal link，配置文件格式参考 https://developer.apple.com/documentation/xcode/supporting-associated-domains")
@@ -240,6 +242,80 @@ func initCmdFlags(ins *app.App) {
 	ins.AddStringFlag("stripe-secret-key", "", "stripe secret key")
 	ins.AddStringFlag("stripe-webhook-secret", "", "stripe webhook secret")
 
+	ins.AddStringFlag("default-chat-model", "", "默认的聊天模型，请求中未指定模型时使用，值取自数据表 models.model_id，留空则不启用")
+	ins.AddIntFlag("model-probe-user-id", 0, "模型探测消耗的智慧果计入的内部账号 ID，不配置则无法使用模型探测")
+	ins.AddIntFlag("max-chat-messages", 1000, "单次聊天请求允许的最大消息数量，超过后直接拒绝")
+	ins.AddStringFlag("chat-payload-policy", "reject", "请求内容大小超过服务提供商限制时的处理策略：reject（直接拒绝）/downscale（缩小图片后重试）")
+	ins.AddIntFlag("chat-output-pacing-rate", 0, "聊天输出的平滑速率（Tokens/秒），按照固定的速率向客户端输出内容，为 0 时不启用")
+	ins.AddBoolFlag("chat-debug-request", "是否允许内部用户查看实际发送给上游的请求内容（调试使用）")
+	ins.AddFloat64Flag("chat-default-temperature", 0, "聊天请求默认的采样温度，请求、房间和模型均未指定时使用，为 0 时不指定")
+	ins.AddFloat64Flag("chat-default-top-p", 0, "聊天请求默认的核采样概率，请求、房间和模型均未指定时使用，为 0 时不指定")
+	ins.AddIntFlag("chat-default-max-tokens", 0, "聊天请求默认的最大输出 Token 数量，请求、房间和模型均未指定时使用，为 0 时不指定")
+	ins.AddStringFlag("chat-default-image-detail", "", "聊天请求默认的图片识别精度：low/high/auto，留空时 OpenAI 系列的服务提供商使用 low")
+	ins.AddStringFlag("chat-default-reply-language", "", "聊天请求默认的回复语言，如 zh-CN，留空则不指定")
+	ins.AddStringFlag("chat-compression-model", "", "长输入压缩默认使用的辅助模型（价格较低的模型），模型配置中未指定时使用，值取自数据表 models.model_id")
+	ins.AddBoolFlag("chat-record-system-prompt", "保存聊天记录时，是否同时保存实际生效的系统提示语全文（默认只保存哈希，用于排查问题）")
+	ins.AddIntFlag("chat-min-output-tokens", 256, "为输出内容预留的最小 Token 数量，上下文缩减后剩余长度仍低于该值时拒绝请求（避免生成过短的回复），为 0 时不预留")
+	ins.AddBoolFlag("chat-file-rehost", "是否在请求分发之前，将请求中引用的远程文件（图片）重新存储到对象存储中，避免服务提供商获取时临时地址已经失效")
+	ins.AddIntFlag("chat-file-max-size", 10, "重新存储时单个文件的最大大小（MB），超过时拒绝请求")
+	ins.AddBoolFlag("chat-startup-drift-check", "是否在启动时检测模型配置（上下文长度、图片输入等）与上游元数据接口报告的能力是否一致，不一致时只记录警告日志，不影响启动")
+	ins.AddIntFlag("chat-max-response-size", 32, "服务提供商非流式响应的最大大小（MB），超过时中断读取并返回错误，避免上游异常时占用大量内存，渠道配置中可以单独指定（meta.max_response_size）")
+	ins.AddFloat64Flag("chat-output-cap-factor", 2, "流式输出的 Token 数量上限系数，输出超过 max(请求的 max_tokens, 模型的 max_output) 的该倍数时强制终止（结束原因为 length），避免上游异常时无限输出，为 0 时不限制")
+	ins.AddIntFlag("chat-max-output-tokens", 0, "流式输出的 Token 数量绝对上限，与模型的上下文长度和请求的 max_tokens 无关，超过时强制终止（结束原因为 length），作为防止费用失控的最后一道防线，房间配置中可以指定更小的值（max_output_tokens），为 0 时不限制")
+	ins.AddStringFlag("chat-assistant-trim", "prose", "历史消息中助手消息末尾空白字符的处理策略：off（不处理）/all（去掉所有行末和消息末尾的空白）/prose（代码块中的内容保持不变）")
+	ins.AddBoolFlag("chat-merge-split-code-blocks", "是否将历史消息中截断在代码块中间的助手消息（结束原因为 length）与用户“继续”之后的助手消息合并为一条完整的消息，避免同一个代码块被拆分到两条消息中")
+	ins.AddStringSliceFlag("chat-retry-error-patterns", []string{}, "可以重试的错误信息匹配规则，格式为 服务提供商类型:匹配规则（如 openai:*server is busy*），支持通配符 * 和 ?，不区分大小写，服务提供商类型为 * 时对所有服务提供商生效。为空时不重试，配置后上游返回 408/500/502/503/504 状态码时同样重试")
+	ins.AddIntFlag("chat-max-content-runes", 0, "所有角色的单条消息内容的最大字符数量（多模态消息包括所有文本部分），没有单独配置限制的角色（如 tool）使用该值，单独配置的限制更大时同样以该值为准，超过时拒绝请求，为 0 时不限制")
+	ins.AddIntFlag("chat-max-system-content-runes", 200000, "单条 system 消息内容的最大字符数量，超过时拒绝请求，为 0 时不限制")
+	ins.AddIntFlag("chat-max-user-content-runes", 1000000, "单条 user 消息内容的最大字符数量（多模态消息包括所有文本部分），超过时拒绝请求，为 0 时不限制")
+	ins.AddIntFlag("chat-max-assistant-content-runes", 1000000, "单条 assistant 消息（历史消息）内容的最大字符数量，超过时拒绝请求，为 0 时不限制")
+	ins.AddStringFlag("room-digest-model", "", "房间消息摘要使用的模型（建议使用价格较低的模型），值取自数据表 models.model_id，为空时不生成摘要，需要启用定时任务（enable-scheduler）")
+	ins.AddIntFlag("room-digest-max-input-tokens", 8000, "房间消息摘要的最大输入 Token 数量，超过时只保留最近的消息")
+	ins.AddStringFlag("room-digest-webhook", "", "房间消息摘要生成后的通知地址，使用 POST 请求发送 JSON 格式的摘要内容，为空时只保存到房间中")
+	ins.AddStringSliceFlag("usage-webhook-urls", []string{}, "用量通知地址，每次请求完成后异步发送签名的用量事件（请求 ID、用户、模型、服务提供商、Token 数量、消耗的智慧果、时间、结束原因，不包含请求和回答的内容），为空时不通知")
+	ins.AddStringFlag("usage-webhook-secret", "", "用量通知的签名密钥，请求头 X-Aidea-Signature 为 sha256=HMAC-SHA256(密钥, 请求头 X-Aidea-Timestamp + \".\" + 请求体) 的十六进制编码")
+	ins.AddIntFlag("usage-webhook-max-attempts", 5, "用量通知发送失败时的最大尝试次数（包括第一次），超过之后写入死信表，可以在管理后台按照时间范围重新发送")
+	ins.AddIntFlag("usage-webhook-backoff", 2, "用量通知第一次重试前的等待时间（秒），之后每次重试等待时间加倍")
+	ins.AddIntFlag("chat-output-coalesce-interval", 0, "流式输出的合并间隔（毫秒），间隔时间到达、累积字节数达到 chat-output-coalesce-bytes 或者遇到换行时一起输出，减少 SSE 事件的数量，为 0 时不启用，WebSocket 客户端参考 chat-websocket-aggregate-interval")
+	ins.AddIntFlag("chat-output-coalesce-bytes", 256, "流式输出合并时累积的最大字节数，达到后立即输出")
+	ins.AddIntFlag("chat-websocket-aggregate-interval", 0, "WebSocket 客户端流式输出的合并间隔（毫秒），间隔时间到达或者累积的 Token 数量达到 chat-websocket-aggregate-tokens 时一起输出，减少 WebSocket 帧的数量，为 0 时不启用")
+	ins.AddIntFlag("chat-websocket-aggregate-tokens", 16, "WebSocket 客户端流式输出合并时累积的最大 Token 数量（近似值），达到后立即输出")
+	ins.AddStringFlag("chat-math-delimiters", "", "将输出内容中数学公式的分隔符统一转换为客户端渲染器支持的格式：dollar（$...$ 和 $$...$$）/latex（\\(...\\) 和 \\[...\\]），代码块中的内容不转换，为空时不转换")
+	ins.AddStringFlag("chat-output-sanitize", "", "客户端将输出内容作为 Markdown（包括 HTML）渲染时，防止被提示词注入的回答中包含脚本：escape（转义代码块之外的 HTML 标签）/strip（去掉代码块之外的 HTML 标签），同时将链接和图片中的 javascript:/vbscript:/data: 地址替换为 #，代码块和行内代码保持不变，为空时不处理")
+	ins.AddIntFlag("chat-output-sanitize-link-text", 200, "处理输出内容时链接文本的最大字符数，超过时截断，为 0 时不限制")
+	ins.AddIntFlag("chat-latency-window", 10, "统计渠道请求耗时（p50/p90/p99）的时间范围，单位为分钟，只在当前实例的内存中统计，管理后台可以通过 /v1/admin/channels/{channel_id}/latency 查看，为 0 时不统计")
+	ins.AddIntFlag("chat-max-concurrency", 0, "当前实例同时处理的聊天请求的最大数量，达到上限时新的请求排队等待，有请求完成时优先处理优先级高的请求，为 0 时不限制")
+	ins.AddIntFlag("chat-queue-max-wait", 10, "排队的聊天请求最长的等待时间，单位为秒，超过之后按照排队的先后顺序优先处理，避免低优先级的请求一直等待，为 0 时只按照优先级处理")
+	ins.AddStringSliceFlag("chat-priority-tiers", []string{}, "按照用户类型（users.user_type）指定聊天请求的优先级，格式为 用户类型:优先级，如 1:10，优先级越大越先处理，未指定的用户类型为 0，只在 chat-max-concurrency 大于 0 时生效")
+	ins.AddIntFlag("chat-history-retention-days", 0, "聊天记录的保留天数，超过时每天由定时任务清理（包括聊天记录关联的文件，智慧果消耗等统计数据不受影响），为 0 时永久保留，需要启用定时任务（enable-scheduler）")
+	ins.AddStringSliceFlag("chat-history-retention-tier-days", []string{}, "按照用户类型（users.user_type）覆盖聊天记录的保留天数，格式为 用户类型:天数（如 1:0 表示内部用户永久保留）")
+	ins.AddIntFlag("chat-history-purge-batch-size", 500, "清理聊天记录时每批删除的最大记录数量")
+	ins.AddIntFlag("chat-history-purge-interval", 1000, "清理聊天记录时每批之间的间隔（毫秒），避免对数据库和对象存储造成压力")
+	ins.AddStringFlag("chat-image-tool-model", "", "聊天中服务端执行的图片生成工具（generate_image）使用的模型，如 dall-e-3、dall-e-3:hd，按照该模型的价格计费，为空时不启用")
+	ins.AddIntFlag("chat-image-tool-limit", 4, "每个对话中最多可以通过图片生成工具生成的图片数量，为 0 时不允许生成")
+	ins.AddStringSliceFlag("chat-image-tool-tier-limits", []string{}, "按照用户类型（users.user_type）覆盖每个对话中最多可以生成的图片数量，格式为 用户类型:数量（如 1:20）")
+	ins.AddIntFlag("chat-image-tool-timeout", 90, "图片生成工具每张图片生成的超时时间（秒），超时后模型会告知用户生成失败，不会阻塞聊天输出")
+	ins.AddStringFlag("chat-web-search-backend", "", "聊天中服务端执行的网页搜索工具（web_search）使用的搜索服务：bing/serper/searxng，为空时不启用")
+	ins.AddStringFlag("chat-web-search-server", "", "搜索服务的地址，Bing 和 Serper 为空时使用官方地址，SearXNG 为自部署服务的地址（需要开启 json 格式）")
+	ins.AddStringFlag("chat-web-search-key", "", "搜索服务的 API Key，SearXNG 不需要")
+	ins.AddIntFlag("chat-web-search-results", 5, "每次搜索返回给模型的结果数量")
+	ins.AddIntFlag("chat-web-search-fetch-pages", 0, "读取排名靠前的网页正文的数量（只允许访问公网地址），为 0 时只返回搜索结果的摘要")
+	ins.AddIntFlag("chat-web-search-page-max-runes", 3000, "每个网页正文的最大长度（字符数）")
+	ins.AddIntFlag("chat-web-search-timeout", 20, "每次搜索（包括读取网页）的超时时间（秒）")
+	ins.AddIntFlag("chat-web-search-limit", 3, "每个请求中最多搜索的次数，为 0 时不允许使用")
+	ins.AddStringSliceFlag("chat-web-search-tier-limits", []string{}, "按照用户类型（users.user_type）覆盖每个请求中最多搜索的次数，格式为 用户类型:次数（如 0:0 表示普通用户不允许使用）")
+	ins.AddStringSliceFlag("chat-web-search-models", []string{}, "允许使用网页搜索的模型（models.model_id），支持通配符（* 匹配任意字符，? 匹配单个字符），为空时允许所有模型")
+	ins.AddIntFlag("chat-tool-loop-budget", 45, "服务端工具（generate_image、web_search）调用循环的耗时预算（秒），在两次循环之间检查，预算即将耗尽时不再调用工具，要求模型根据已有的信息直接回答，为 0 时不限制")
+	ins.AddStringSliceFlag("chat-tool-loop-budget-tiers", []string{}, "按照用户类型（users.user_type）覆盖工具调用循环的耗时预算，格式为 用户类型:秒数（如 1:90）")
+	ins.AddIntFlag("chat-max-pinned-messages", 5, "每个房间最多可以固定的消息数量，固定的消息始终包含在上下文中，不会因为上下文缩减被丢弃")
+	ins.AddIntFlag("chat-avoid-channel-turns", 3, "用户对回答点踩或者重新生成时（POST /v1/messages/{id}/feedback），房间中记录最近的几次反馈对应的渠道，之后的请求在有其它健康的渠道时避开这些渠道，为 0 时不记录")
+	ins.AddIntFlag("chat-avoid-channel-ttl", 30, "房间中记录的需要避开的渠道的有效时间，单位为分钟")
+	ins.AddStringFlag("chat-sandbox-responses", "", "演示用户（users.user_type 为 4）的预置回答文件（YAML），内容为 {models: [模型通配符], responses: [回答]} 的列表，按照顺序使用第一个匹配模型的规则，{model} 替换为请求的模型，为空时使用内置的预置回答")
+	ins.AddIntFlag("chat-sandbox-stream-interval", 40, "演示用户流式输出时每个分片之间的间隔，单位为毫秒，用于模拟真实的输出速度")
+	ins.AddStringFlag("chat-task-profiles", "", "按照任务类型调整采样参数的配置文件（YAML），内容为 {name, keywords, patterns, temperature, top_p} 的列表，根据最后一条用户消息命中的关键词和正则选择得分最高的配置，只在请求和房间都没有指定 temperature/top_p 时生效，参考 task-profiles.yaml，为空时不调整")
+	ins.AddIntFlag("chat-input-session-ttl", 30, "增量输入会话（POST /v1/chat/sessions）未提交时的过期时间，单位为秒，每次追加内容时刷新")
+	ins.AddIntFlag("chat-input-session-max-count", 5, "每个用户最多同时打开的增量输入会话数量")
+	ins.AddIntFlag("chat-input-session-max-runes", 20000, "增量输入会话中最后一条用户消息（包括追加的内容）的最大字符数量")
 	ins.AddStringSliceFlag("default-home-models", []string{"gpt-3.5-turbo", "gpt-4"}, "默认的首页模型，值取自数据表 models.model_id")
 	ins.AddStringSliceFlag("default-home-models-ios", []string{"chat-3.5", "chat-4"}, "默认的首页模型（IOS），值取自数据表 models.model_id")
 
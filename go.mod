@@ -35,9 +35,12 @@ require (
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/aiart v1.0.727
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/hunyuan v1.0.857
 	github.com/tideland/gorest v2.15.5+incompatible
+	github.com/urfave/cli/v2 v2.23.7
 	github.com/wagslane/go-password-validator v0.3.0
 	github.com/wechatpay-apiv3/wechatpay-go v0.2.18
+	golang.org/x/image v0.14.0
 	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 	gopkg.in/guregu/null.v3 v3.5.0
 	gopkg.in/resty.v1 v1.12.0
@@ -90,10 +93,8 @@ require (
 	github.com/tideland/golib v4.24.2+incompatible // indirect
 	github.com/tink-ab/tempfile v0.0.0-20180226111222-33beb0518f1a // indirect
 	github.com/tjfoc/gmsm v1.3.2 // indirect
-	golang.org/x/image v0.14.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
 	golang.org/x/tools v0.20.0 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
@@ -121,7 +122,6 @@ require (
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/asr v1.0.665
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.0.857
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/sms v1.0.671
-	github.com/urfave/cli/v2 v2.23.7 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	golang.org/x/crypto v0.22.0
 	gopkg.in/yaml.v3 v3.0.1
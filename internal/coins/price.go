@@ -143,6 +143,14 @@ var coinTables = map[string]CoinTable{
 		"tts-1-hd": 30,
 	},
 
+	"embedding": {
+		// 1000 Token 计费
+		"default":                1,
+		"text-embedding-ada-002": 1, // $0.0001/1K tokens
+		"text-embedding-3-small": 1, // $0.00002/1K tokens
+		"text-embedding-3-large": 1, // $0.00013/1K tokens
+	},
+
 	"translate": {
 		"youdao": 0,
 	},
@@ -0,0 +1,114 @@
+package coins
+
+import "math"
+
+// Capability 计费的能力类型
+type Capability string
+
+const (
+	CapabilityChat      Capability = "chat"
+	CapabilityEmbedding Capability = "embedding"
+	CapabilitySpeech    Capability = "speech"
+	CapabilityImage     Capability = "image"
+)
+
+// Usage 一次调用的用量，不同能力的用量形式不同（Token 数量、字符数量、图片数量），
+// 由各自的实现按照价格表折算为智慧果，所有能力统一通过 ComputeFee 计费
+type Usage interface {
+	// Capability 用量所属的能力类型
+	Capability() Capability
+	// Coins 按照价格表折算的智慧果数量
+	Coins() int64
+}
+
+// ComputeFee 计算用量需要消耗的智慧果数量，多个用量（如聊天过程中生成了图片）合并计费，nil 不计费
+func ComputeFee(usages ...Usage) int64 {
+	var total int64
+	for _, usage := range usages {
+		if usage == nil {
+			continue
+		}
+
+		if coins := usage.Coins(); coins > 0 {
+			total += coins
+		}
+	}
+
+	return total
+}
+
+// ChatUsage 聊天的用量，输入和输出的 Token 分开计费
+type ChatUsage struct {
+	Model        ModelInfo
+	InputTokens  int64
+	OutputTokens int64
+}
+
+func (u ChatUsage) Capability() Capability { return CapabilityChat }
+
+func (u ChatUsage) Coins() int64 {
+	_, _, total := u.Detail()
+	return total
+}
+
+// Detail 输入和输出部分的价格以及总价
+func (u ChatUsage) Detail() (inputPrice float64, outputPrice float64, totalPrice int64) {
+	return GetTextModelCoinsDetail(u.Model, u.InputTokens, u.OutputTokens)
+}
+
+// EmbeddingUsage 向量化（Embedding）的用量，按照输入的 Token 数量计费
+type EmbeddingUsage struct {
+	Model  string
+	Tokens int64
+}
+
+func (u EmbeddingUsage) Capability() Capability { return CapabilityEmbedding }
+
+func (u EmbeddingUsage) Coins() int64 {
+	if u.Tokens <= 0 {
+		return 0
+	}
+
+	return int64(math.Ceil(float64(tablePrice(string(CapabilityEmbedding), u.Model)) * float64(u.Tokens) / 1000.0))
+}
+
+// SpeechUsage 语音合成（TTS）的用量，按照字符数量计费
+type SpeechUsage struct {
+	Model      string
+	Characters int
+}
+
+func (u SpeechUsage) Capability() Capability { return CapabilitySpeech }
+
+func (u SpeechUsage) Coins() int64 {
+	if u.Characters <= 0 {
+		return 0
+	}
+
+	return GetTextToVoiceCoins(u.Model, u.Characters)
+}
+
+// ImageUsage 图片生成的用量，按照生成的图片数量计费
+type ImageUsage struct {
+	Model  string
+	Images int
+}
+
+func (u ImageUsage) Capability() Capability { return CapabilityImage }
+
+func (u ImageUsage) Coins() int64 {
+	if u.Images <= 0 {
+		return 0
+	}
+
+	return int64(GetUnifiedImageGenCoins(u.Model) * u.Images)
+}
+
+// tablePrice 价格表中模型的单价，模型不存在时使用 default 的单价
+func tablePrice(table, model string) int64 {
+	if price, ok := coinTables[table][model]; ok {
+		return price
+	}
+
+	return coinTables[table]["default"]
+}
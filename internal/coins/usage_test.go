@@ -0,0 +1,37 @@
+package coins_test
+
+import (
+	"testing"
+
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestComputeFee_Embedding(t *testing.T) {
+	usage := coins.EmbeddingUsage{Model: "text-embedding-3-small", Tokens: 2500}
+	assert.Equal(t, coins.CapabilityEmbedding, usage.Capability())
+	assert.Equal(t, int64(3), coins.ComputeFee(usage))
+
+	// 价格表中不存在的模型使用默认价格
+	assert.Equal(t, int64(1), coins.ComputeFee(coins.EmbeddingUsage{Model: "unknown", Tokens: 10}))
+	assert.Equal(t, int64(0), coins.ComputeFee(coins.EmbeddingUsage{Model: "text-embedding-3-small"}))
+}
+
+func TestComputeFee_Speech(t *testing.T) {
+	usage := coins.SpeechUsage{Model: "tts-1-hd", Characters: 1500}
+	assert.Equal(t, coins.CapabilitySpeech, usage.Capability())
+	assert.Equal(t, coins.GetTextToVoiceCoins("tts-1-hd", 1500), coins.ComputeFee(usage))
+	assert.Equal(t, int64(45), coins.ComputeFee(usage))
+}
+
+func TestComputeFee_Mixed(t *testing.T) {
+	chat := coins.ChatUsage{Model: coins.ModelInfo{ModelId: "test", InputPrice: 10, OutputPrice: 20}, InputTokens: 1000, OutputTokens: 500}
+	_, _, total := chat.Detail()
+	assert.Equal(t, int64(20), total)
+
+	image := coins.ImageUsage{Model: "dall-e-3", Images: 2}
+	imageCoins := int64(coins.GetUnifiedImageGenCoins("dall-e-3") * 2)
+	assert.Equal(t, imageCoins, coins.ComputeFee(image))
+
+	assert.Equal(t, total+imageCoins+3, coins.ComputeFee(chat, image, nil, coins.SpeechUsage{Model: "tts-1", Characters: 200}))
+}
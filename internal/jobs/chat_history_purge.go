@@ -0,0 +1,24 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+)
+
+// ChatHistoryPurgeJob 清理超过保留期限（chat-history-retention-days，可以按照用户类型覆盖）的聊天记录
+func ChatHistoryPurgeJob(ctx context.Context, svc *service.Service) error {
+	count, err := svc.HistoryRetention.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		log.F(log.M{"count": count}).Errorf("清理过期的聊天记录失败: %v", err)
+		return err
+	}
+
+	if count > 0 {
+		log.F(log.M{"count": count}).Infof("清理过期的聊天记录 %d 条", count)
+	}
+
+	return nil
+}
@@ -95,6 +95,26 @@ func (Provider) Jobs(cc infra.Resolver, creator scheduler.JobCreator) {
 		log.Errorf("注册定时任务 clear-expired-cache 失败: %v", err)
 	}
 
+	// 每分钟检查一次需要生成消息摘要的房间
+	if err := creator.Add(
+		"room-digest",
+		"0 * * * * *",
+		scheduler.WithoutOverlap(RoomDigestJob).SkipCallback(func() {
+			log.Debugf("上一次 room-digest 任务还未执行完毕，本次任务将被跳过")
+		}),
+	); err != nil {
+		log.Errorf("注册定时任务 room-digest 失败: %v", err)
+	}
+
+	// 每天凌晨 3:30 清理超过保留期限的聊天记录
+	if err := creator.Add(
+		"chat-history-purge",
+		"0 30 3 * * *",
+		scheduler.WithoutOverlap(ChatHistoryPurgeJob),
+	); err != nil {
+		log.Errorf("注册定时任务 chat-history-purge 失败: %v", err)
+	}
+
 	// 用户注册通知（管理）
 	if err := creator.Add(
 		"user-signup-notification",
@@ -0,0 +1,322 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+	"github.com/redis/go-redis/v9"
+	cronV3 "github.com/robfig/cron/v3"
+)
+
+const (
+	// roomDigestBackoffKey 摘要模型不可用时暂停生成摘要，值为暂停的结束时间
+	roomDigestBackoffKey = "room-digest:backoff-until"
+	// roomDigestFailuresKey 摘要模型连续不可用的次数，用于计算暂停时长
+	roomDigestFailuresKey = "room-digest:failures"
+	// roomDigestMinBackoff/roomDigestMaxBackoff 暂停时长的范围，每次连续不可用时加倍
+	roomDigestMinBackoff = time.Minute
+	roomDigestMaxBackoff = time.Hour
+	// roomDigestMaxMessages 每次摘要最多读取的消息数量（最近的消息）
+	roomDigestMaxMessages = 500
+	// roomDigestMaxMessageRunes 单条消息参与摘要的最大字符数量，超过时截断
+	roomDigestMaxMessageRunes = 2000
+	// roomDigestMaxOutputTokens 摘要的最大输出 Token 数量
+	roomDigestMaxOutputTokens = 1024
+)
+
+// roomDigestPrompt 生成房间消息摘要使用的系统提示语
+const roomDigestPrompt = `You write a digest of the recent activity in a chat room for people who did not follow it. Summarize the transcript below as a short list of bullet points covering the topics discussed, conclusions and decisions, and open questions or follow-ups. Do not add anything that is not in the transcript. Reply with the digest only, in the language used in the transcript.`
+
+// errRoomDigestModelUnavailable 摘要模型请求失败，暂停生成摘要
+var errRoomDigestModelUnavailable = errors.New("room digest model unavailable")
+
+// roomDigestNotification 发送到通知地址（room-digest-webhook）的摘要内容
+type roomDigestNotification struct {
+	RoomID       int64     `json:"room_id"`
+	RoomName     string    `json:"room_name"`
+	UserID       int64     `json:"user_id"`
+	MessageID    int64     `json:"message_id"`
+	Digest       string    `json:"digest"`
+	MessageCount int       `json:"message_count"`
+	StartAt      time.Time `json:"start_at"`
+	EndAt        time.Time `json:"end_at"`
+}
+
+// RoomDigestJob 按照房间配置的定时规则（rooms.digest_schedule）生成房间的消息摘要
+//
+// 摘要只包含上一次摘要之后的新消息，没有新消息的房间直接跳过。摘要保存为房间中的系统消息，
+// 费用计入房间所有者，配置了通知地址时同时发送通知。摘要模型不可用时暂停一段时间，连续不可用时暂停时长加倍。
+func RoomDigestJob(ctx context.Context, conf *config.Config, rep *repo.Repository, ch chat.Chat, svc *service.Service, rds *redis.Client) error {
+	if conf.RoomDigestModel == "" {
+		return nil
+	}
+
+	if until, err := rds.Get(ctx, roomDigestBackoffKey).Time(); err == nil && time.Now().Before(until) {
+		log.Debugf("房间消息摘要模型暂时不可用，%s 之前不生成摘要", until.Format(time.DateTime))
+		return nil
+	}
+
+	mod := svc.Chat.Model(ctx, conf.RoomDigestModel)
+	if mod == nil {
+		log.F(log.M{"model": conf.RoomDigestModel}).Errorf("房间消息摘要模型不存在")
+		return nil
+	}
+
+	if checker, ok := ch.(chat.ModelHealthChecker); ok && !checker.ModelHealthy(ctx, conf.RoomDigestModel) {
+		backoffRoomDigest(ctx, rds, errors.New("all providers are unhealthy"))
+		return nil
+	}
+
+	rooms, err := rep.Room.DigestRooms(ctx)
+	if err != nil {
+		log.Errorf("查询开启消息摘要的房间失败: %v", err)
+		return err
+	}
+
+	now := time.Now()
+	for _, room := range rooms {
+		if err := digestRoom(ctx, conf, rep, ch, mod, room, now); err != nil {
+			if errors.Is(err, errRoomDigestModelUnavailable) {
+				backoffRoomDigest(ctx, rds, err)
+				return nil
+			}
+
+			log.F(log.M{"room_id": room.Id, "user_id": room.UserId}).Errorf("生成房间消息摘要失败: %v", err)
+		}
+	}
+
+	return rds.Del(ctx, roomDigestFailuresKey).Err()
+}
+
+// digestRoom 为房间生成消息摘要，未到摘要时间或者没有新消息时只更新检查时间
+func digestRoom(ctx context.Context, conf *config.Config, rep *repo.Repository, ch chat.Chat, mod *repo.Model, room model.Rooms, now time.Time) error {
+	sched, err := repo.ParseDigestSchedule(room.DigestSchedule)
+	if err != nil {
+		return fmt.Errorf("invalid digest schedule %q: %w", room.DigestSchedule, err)
+	}
+
+	// 第一次检查时只记录起点，摘要从开启之后的新消息开始
+	if room.LastDigestAt.IsZero() {
+		latestID, err := rep.Message.LatestMessageID(ctx, room.UserId, room.Id)
+		if err != nil {
+			return err
+		}
+
+		return rep.Room.UpdateDigestState(ctx, room.Id, latestID, now)
+	}
+
+	if !roomDigestDue(sched, room.LastDigestAt, now) {
+		return nil
+	}
+
+	messages, err := rep.Message.MessagesAfter(ctx, room.UserId, room.Id, room.LastDigestMessageId, roomDigestMaxMessages)
+	if err != nil {
+		return err
+	}
+
+	if len(messages) == 0 {
+		return rep.Room.UpdateDigestState(ctx, room.Id, room.LastDigestMessageId, now)
+	}
+
+	// 房间所有者没有可用的智慧果时跳过本次摘要，新消息保留到下一次
+	quota, err := rep.Quota.GetUserQuota(ctx, room.UserId)
+	if err != nil {
+		return err
+	}
+
+	if quota.Rest <= 0 {
+		log.F(log.M{"room_id": room.Id, "user_id": room.UserId}).Debugf("房间所有者智慧果不足，跳过消息摘要")
+		return rep.Room.UpdateDigestState(ctx, room.Id, room.LastDigestMessageId, now)
+	}
+
+	transcript, err := roomDigestTranscript(messages, mod.ModelId, conf.RoomDigestMaxInputTokens)
+	if err != nil {
+		return err
+	}
+
+	res, err := ch.Chat(ctx, chat.Request{
+		Model: mod.ModelId,
+		Messages: chat.Messages{
+			{Role: chat.RoleSystem, Content: roomDigestPrompt},
+			{Role: chat.RoleUser, Content: transcript},
+		},
+		MaxTokens: roomDigestMaxOutputTokens,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRoomDigestModelUnavailable, err)
+	}
+
+	if res.ErrorCode != "" {
+		return fmt.Errorf("%w: [%s] %s", errRoomDigestModelUnavailable, res.ErrorCode, res.Error)
+	}
+
+	digest := strings.TrimSpace(res.Text)
+	if digest == "" {
+		return errors.New("empty digest")
+	}
+
+	// 服务提供商没有返回 Token 用量时，按照请求和摘要的内容计算
+	if res.InputTokens == 0 {
+		res.InputTokens, _ = chat.MessageTokenCount(chat.Messages{{Role: chat.RoleSystem, Content: roomDigestPrompt}, {Role: chat.RoleUser, Content: transcript}}, mod.ModelId)
+	}
+	if res.OutputTokens == 0 {
+		res.OutputTokens, _ = chat.MessageTokenCount(chat.Messages{{Role: chat.RoleAssistant, Content: digest}}, mod.ModelId)
+	}
+
+	inputPrice, outputPrice, totalPrice := res.ToCoinUsage(mod.ToCoinModel()).Detail()
+
+	messageID, err := rep.Message.Add(ctx, repo.MessageAddReq{
+		UserID:        room.UserId,
+		RoomID:        room.Id,
+		Role:          repo.MessageRoleSystem,
+		Message:       digest,
+		Model:         mod.ModelId,
+		QuotaConsumed: totalPrice,
+		TokenConsumed: int64(res.InputTokens + res.OutputTokens),
+	})
+	if err != nil {
+		return fmt.Errorf("save digest failed: %w", err)
+	}
+
+	// 摘要的费用计入房间所有者
+	if totalPrice > 0 {
+		meta := repo.NewQuotaUsedMeta("room-digest", mod.ModelId)
+		meta.InputToken = res.InputTokens
+		meta.OutputToken = res.OutputTokens
+		meta.InputPrice = inputPrice
+		meta.OutputPrice = outputPrice
+
+		if err := rep.Quota.QuotaConsume(ctx, room.UserId, totalPrice, meta); err != nil {
+			log.F(log.M{"room_id": room.Id, "user_id": room.UserId}).Errorf("扣除房间消息摘要费用失败: %v", err)
+		}
+	}
+
+	if err := rep.Room.UpdateDigestState(ctx, room.Id, messages[len(messages)-1].Id, now); err != nil {
+		return err
+	}
+
+	if conf.RoomDigestWebhook != "" {
+		notification := roomDigestNotification{
+			RoomID:       room.Id,
+			RoomName:     room.Name,
+			UserID:       room.UserId,
+			MessageID:    messageID,
+			Digest:       digest,
+			MessageCount: len(messages),
+			StartAt:      messages[0].CreatedAt,
+			EndAt:        messages[len(messages)-1].CreatedAt,
+		}
+
+		if err := sendRoomDigestNotification(ctx, conf.RoomDigestWebhook, notification); err != nil {
+			log.F(log.M{"room_id": room.Id, "user_id": room.UserId}).Errorf("发送房间消息摘要通知失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// roomDigestDue 上一次摘要之后，定时规则是否已经到达下一次执行时间
+func roomDigestDue(sched cronV3.Schedule, lastDigestAt time.Time, now time.Time) bool {
+	return !sched.Next(lastDigestAt).After(now)
+}
+
+// roomDigestBackoff 摘要模型连续不可用 failures 次之后的暂停时长
+func roomDigestBackoff(failures int64) time.Duration {
+	wait := roomDigestMinBackoff
+	for i := int64(1); i < failures && wait < roomDigestMaxBackoff; i++ {
+		wait *= 2
+	}
+
+	return min(wait, roomDigestMaxBackoff)
+}
+
+// backoffRoomDigest 摘要模型不可用时暂停生成摘要
+func backoffRoomDigest(ctx context.Context, rds *redis.Client, cause error) {
+	failures, err := rds.Incr(ctx, roomDigestFailuresKey).Result()
+	if err != nil {
+		log.Errorf("更新房间消息摘要失败次数失败: %v", err)
+		failures = 1
+	}
+
+	wait := roomDigestBackoff(failures)
+	if err := rds.Set(ctx, roomDigestBackoffKey, time.Now().Add(wait), wait).Err(); err != nil {
+		log.Errorf("暂停房间消息摘要失败: %v", err)
+	}
+
+	log.Warningf("房间消息摘要模型不可用，暂停 %s: %v", wait, cause)
+}
+
+// roomDigestTranscript 将聊天记录转换为摘要的输入内容
+//
+// 单条消息过长时截断，总长度超过 maxTokens 时使用上下文缩减只保留最近的消息
+func roomDigestTranscript(messages []model.ChatMessages, mod string, maxTokens int) (string, error) {
+	contextMessages := make(chat.Messages, 0, len(messages))
+	for _, msg := range messages {
+		role := chat.RoleUser
+		if repo.MessageRole(msg.Role) == repo.MessageRoleAssistant {
+			role = chat.RoleAssistant
+		}
+
+		contextMessages = append(contextMessages, chat.Message{Role: role, Content: misc.SubString(msg.Message, roomDigestMaxMessageRunes)})
+	}
+
+	if maxTokens > 0 {
+		reduced, _, err := chat.ReduceMessageContext(contextMessages, mod, maxTokens)
+		if err != nil {
+			return "", err
+		}
+
+		// 上下文缩减只会去掉较早的消息
+		messages = messages[len(messages)-len(reduced):]
+		contextMessages = reduced
+	}
+
+	lines := make([]string, 0, len(contextMessages))
+	for i, msg := range contextMessages {
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", messages[i].CreatedAt.Format("2006-01-02 15:04"), msg.Role, msg.Content))
+	}
+
+	return strings.Join(lines, "\n\n"), nil
+}
+
+// sendRoomDigestNotification 使用 POST 请求将摘要发送到通知地址
+func sendRoomDigestNotification(ctx context.Context, url string, notification roomDigestNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
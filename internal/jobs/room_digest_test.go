@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestRoomDigestBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, roomDigestBackoff(1))
+	assert.Equal(t, 2*time.Minute, roomDigestBackoff(2))
+	assert.Equal(t, 8*time.Minute, roomDigestBackoff(4))
+	assert.Equal(t, time.Hour, roomDigestBackoff(100))
+}
+
+func TestRoomDigestDue(t *testing.T) {
+	sched, err := repo.ParseDigestSchedule("0 9 * * *")
+	assert.NoError(t, err)
+
+	last := time.Date(2026, 10, 15, 9, 0, 0, 0, time.Local)
+	assert.False(t, roomDigestDue(sched, last, last.Add(23*time.Hour)))
+	assert.True(t, roomDigestDue(sched, last, last.Add(24*time.Hour)))
+
+	// 两次摘要的间隔不能少于 1 小时
+	_, err = repo.ParseDigestSchedule("*/5 * * * *")
+	assert.True(t, err != nil)
+	// 只有部分相邻的摘要间隔过短时同样不允许，与校验的时间无关
+	_, err = repo.ParseDigestSchedule("0,30 9 * * *")
+	assert.True(t, err != nil)
+	_, err = repo.ParseDigestSchedule("0 9 * * 1,2")
+	assert.NoError(t, err)
+	_, err = repo.ParseDigestSchedule("not a schedule")
+	assert.True(t, err != nil)
+}
+
+func TestRoomDigestTranscript(t *testing.T) {
+	createdAt := time.Date(2026, 10, 16, 9, 30, 0, 0, time.Local)
+	messages := []model.ChatMessages{
+		{Id: 1, Role: int64(repo.MessageRoleUser), Message: strings.Repeat("early question ", 200), CreatedAt: createdAt},
+		{Id: 2, Role: int64(repo.MessageRoleAssistant), Message: strings.Repeat("early answer ", 200), CreatedAt: createdAt},
+		{Id: 3, Role: int64(repo.MessageRoleUser), Message: "发布时间定在周五", CreatedAt: createdAt},
+		{Id: 4, Role: int64(repo.MessageRoleAssistant), Message: "好的，周五发布", CreatedAt: createdAt},
+	}
+
+	transcript, err := roomDigestTranscript(messages, "gpt-3.5-turbo", 0)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(transcript, "[2026-10-16 09:30] user: early question"))
+	assert.True(t, strings.HasSuffix(transcript, "[2026-10-16 09:30] assistant: 好的，周五发布"))
+
+	// 单条消息过长时截断
+	messages[0].Message = strings.Repeat("长", roomDigestMaxMessageRunes+100)
+	transcript, err = roomDigestTranscript(messages, "gpt-3.5-turbo", 0)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(transcript, strings.Repeat("长", roomDigestMaxMessageRunes+1)))
+}
@@ -148,7 +148,7 @@ func BuildOpenAICompletionHandler(client openai2.Client, rep *repo2.Repository,
 		inputTokens, _ := chat.MessageTokenCount(
 			array.Map(req.Messages, func(item openai.ChatCompletionMessage, _ int) chat.Message {
 				return chat.Message{
-					Role:    item.Role,
+					Role:    chat.Role(item.Role),
 					Content: item.Content,
 				}
 			}),
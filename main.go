@@ -46,6 +46,7 @@ import (
 	"github.com/mylxsw/aidea-server/pkg/tencent"
 	"github.com/mylxsw/aidea-server/pkg/token"
 	"github.com/mylxsw/aidea-server/pkg/uploader"
+	"github.com/mylxsw/aidea-server/pkg/usagehook"
 	"github.com/mylxsw/aidea-server/pkg/voice"
 	"github.com/mylxsw/aidea-server/pkg/youdao"
 
@@ -64,6 +65,7 @@ import (
 	"github.com/mylxsw/aidea-server/server"
 	"github.com/mylxsw/glacier/infra"
 	"github.com/mylxsw/glacier/starter/app"
+	"github.com/urfave/cli/v2"
 )
 
 var GitCommit string
@@ -118,6 +120,11 @@ func main() {
 	//	log.With(conf).Debugf("configuration loaded")
 	//})
 
+	// 渠道密钥加密命令
+	ins.WithCLIOptions(func(cliApp *cli.App) {
+		cliApp.Commands = append(cliApp.Commands, migrate.EncryptChannelSecretsCommand())
+	})
+
 	ins.OnServerReady(func(conf *config.Config) {
 		log.Infof("服务启动成功，监听地址为 %s", conf.Listen)
 	})
@@ -138,6 +145,7 @@ func main() {
 		proxy.Provider{},
 		file.Provider{},
 		migrate.Provider{},
+		usagehook.Provider{},
 	)
 
 	// 普通云服务商
@@ -0,0 +1,138 @@
+package data
+
+import "github.com/mylxsw/eloquent/migrate"
+
+func Migrate20261016DDL(m *migrate.Manager) {
+	m.Schema("20261016-ddl").Table("rooms", func(builder *migrate.Builder) {
+		builder.TinyInteger("merge_user_messages", false, true).Nullable(true).Comment("是否合并连续的用户消息：0-否 1-是")
+	})
+
+	m.Schema("20261016-ddl-chat-defaults").Table("rooms", func(builder *migrate.Builder) {
+		builder.Text("chat_defaults").Nullable(true).Comment("请求参数的默认值，JSON 格式，如 temperature/top_p/max_tokens 等")
+	})
+
+	m.Schema("20261016-ddl-chat-messages-prompt").Table("chat_messages", func(builder *migrate.Builder) {
+		builder.String("system_prompt_hash", 64).Nullable(true).Comment("实际生效的系统提示语的哈希（sha256）")
+		builder.Text("system_prompt").Nullable(true).Comment("实际生效的系统提示语全文，开启 chat-record-system-prompt 时保存")
+		builder.String("upstream_model", 128).Nullable(true).Comment("实际请求的上游模型（服务提供商模型重写之后）")
+		builder.Integer("channel_id", false, true).Nullable(true).Comment("渠道 ID，使用配置文件中的服务提供商时为 0")
+		builder.String("provider", 32).Nullable(true).Comment("服务提供商类型")
+	})
+
+	m.Schema("20261016-ddl-rooms-private").Table("rooms", func(builder *migrate.Builder) {
+		builder.TinyInteger("private", false, true).Nullable(true).Comment("是否为私密房间：0-否 1-是，搜索聊天记录时可以排除私密房间")
+	})
+
+	m.Schema("20261016-ddl-rooms-digest").Table("rooms", func(builder *migrate.Builder) {
+		builder.String("digest_schedule", 64).Nullable(true).Comment("消息摘要的定时规则（cron 表达式），为空时不生成摘要")
+		builder.Integer("last_digest_message_id", false, true).Nullable(true).Comment("最后一次摘要包含的最大消息 ID")
+		builder.Timestamp("last_digest_at", 0).Nullable(true).Comment("最后一次生成摘要（或者检查）的时间")
+	})
+
+	m.Schema("20261016-ddl-chat-messages-parts").Table("chat_messages", func(builder *migrate.Builder) {
+		builder.Text("parts").Nullable(true).Comment("回复中的多模态内容（如生成的图片及说明），JSON 格式")
+	})
+
+	// 聊天记录全文搜索索引，使用 ngram 分词以支持中文，写入聊天记录时异步更新
+	m.Schema("20261016-ddl-chat-messages-search").Raw("chat_messages_search", func() []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS chat_messages_search
+(
+    message_id INT                                 PRIMARY KEY COMMENT '聊天记录 ID（chat_messages.id）',
+    user_id    INT                                 NOT NULL,
+    room_id    INT                                 NULL,
+    role       TINYINT                             NULL COMMENT '角色：1-用户 2-机器人',
+    model      VARCHAR(32)                         NULL COMMENT '聊天模型',
+    content    TEXT COLLATE utf8mb4_general_ci     NULL COMMENT '消息内容',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    FULLTEXT INDEX chat_messages_search_content_idx (content) WITH PARSER ngram
+) CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci`,
+			`CREATE INDEX chat_messages_search_user_idx ON chat_messages_search (user_id, message_id)`,
+			// 已有的聊天记录
+			`INSERT IGNORE INTO chat_messages_search (message_id, user_id, room_id, role, model, content, created_at)
+SELECT id, user_id, room_id, role, model, message, created_at FROM chat_messages WHERE message IS NOT NULL AND message != ''`,
+		}
+	})
+
+	// 用户自定义模型：基础模型 + 固定的系统提示语和请求参数，模型 ID 为 user-model@{code}
+	m.Schema("20261016-ddl-user-models").Raw("user_models", func() []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS user_models
+(
+    id            INT AUTO_INCREMENT                  PRIMARY KEY,
+    user_id       INT                                 NOT NULL COMMENT '创建者',
+    code          VARCHAR(32)                         NOT NULL COMMENT '模型标识，模型 ID 为 user-model@{code}',
+    model         VARCHAR(50)                         NOT NULL COMMENT '基础模型',
+    name          VARCHAR(255)                        NULL COMMENT '模型名称',
+    description   VARCHAR(255)                        NULL COMMENT '模型描述',
+    avatar_url    VARCHAR(255)                        NULL COMMENT '头像地址',
+    system_prompt TEXT                                NULL COMMENT '系统提示语',
+    chat_defaults TEXT                                NULL COMMENT '请求参数的默认值，JSON 格式，如 temperature/top_p/max_tokens 等',
+    visibility    TINYINT   DEFAULT 1                 NOT NULL COMMENT '可见范围：1-私有 2-通过链接分享 3-公开',
+    created_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE INDEX user_models_code_idx (code),
+    INDEX user_models_user_idx (user_id),
+    INDEX user_models_visibility_idx (visibility)
+) CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci`,
+		}
+	})
+
+	// 聊天记录删除标记：聊天记录超过保留期限被清理或者用户删除所有聊天记录时写入，同步客户端据此清理本地的聊天记录
+	m.Schema("20261016-ddl-chat-message-tombstones").Raw("chat_message_tombstones", func() []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS chat_message_tombstones
+(
+    id             INT AUTO_INCREMENT                  PRIMARY KEY,
+    user_id        INT                                 NOT NULL,
+    room_id        INT       DEFAULT 0                 NOT NULL COMMENT '房间 ID',
+    max_message_id INT                                 NOT NULL COMMENT '房间中 ID 不大于该值的消息都已经删除',
+    reason         VARCHAR(16)                         NOT NULL COMMENT '删除原因：retention-超过保留期限 user-用户删除',
+    created_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    INDEX chat_message_tombstones_user_idx (user_id, id)
+) CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci`,
+		}
+	})
+
+	m.Schema("20261016-ddl-rooms-max-images").Table("rooms", func(builder *migrate.Builder) {
+		builder.Integer("max_images", false, true).Nullable(true).Comment("整个对话中图片的最大数量，超过时从最早的消息开始去掉图片，为 0 时不限制")
+	})
+
+	m.Schema("20261016-ddl-chat-messages-pinned").Table("chat_messages", func(builder *migrate.Builder) {
+		builder.TinyInteger("pinned", false, true).Nullable(true).Comment("是否固定在上下文中：0-否 1-是，固定的消息不会因为上下文缩减被丢弃")
+		builder.Index("chat_messages_room_pinned_idx", "room_id", "pinned")
+	})
+
+	m.Schema("20261016-ddl-rooms-max-output-tokens").Table("rooms", func(builder *migrate.Builder) {
+		builder.Integer("max_output_tokens", false, true).Nullable(true).Comment("流式输出的 Token 数量绝对上限，超过时强制终止，为 0 时只使用全局配置")
+	})
+
+	// API Key 只保存哈希值，并支持按照 Key 限制可用的模型与请求频率
+	m.Schema("20261016-ddl-user-api-key-scopes").Table("user_api_key", func(builder *migrate.Builder) {
+		builder.TinyInteger("hashed", false, true).Nullable(true).Comment("token 字段是否为 Key 的哈希值：0-明文（历史数据） 1-SHA256 哈希")
+		builder.String("key_prefix", 32).Nullable(true).Comment("Key 的前缀与后缀，用于展示")
+		builder.String("allowed_models", 1024).Nullable(true).Comment("允许使用的模型，JSON 数组，支持通配符，为空时不限制")
+		builder.Integer("rpm", false, true).Nullable(true).Comment("每分钟最大请求数，为 0 时不限制")
+		builder.Integer("tpm", false, true).Nullable(true).Comment("每分钟最大输入 Token 数，为 0 时不限制")
+		builder.Timestamp("last_used_at", 0).Nullable(true).Comment("最后使用时间")
+	})
+
+	// 用量通知的死信表：多次重试之后仍然无法送达的事件，管理员可以按照时间范围重新发送
+	m.Schema("20261016-ddl-usage-webhook-dead-letters").Raw("usage_webhook_dead_letters", func() []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS usage_webhook_dead_letters
+(
+    id          INT AUTO_INCREMENT                  PRIMARY KEY,
+    event_id    VARCHAR(64)                         NOT NULL COMMENT '事件 ID，接收方用于去重',
+    user_id     INT                                 NOT NULL,
+    endpoint    VARCHAR(255)                        NOT NULL COMMENT '通知地址',
+    payload     TEXT                                NOT NULL COMMENT '事件内容，JSON 格式',
+    attempts    INT       DEFAULT 0                 NOT NULL COMMENT '已经尝试发送的次数',
+    last_error  VARCHAR(1024)                       NULL COMMENT '最后一次发送失败的原因',
+    occurred_at TIMESTAMP                           NOT NULL COMMENT '事件发生的时间（请求完成的时间）',
+    created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    INDEX usage_webhook_dead_letters_occurred_idx (occurred_at)
+) CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci`,
+		}
+	})
+}
@@ -26,6 +26,7 @@ func Migrate(ctx context.Context, db *sql.DB) error {
 	data.Migrate20240307DDL(m)
 	data.Migrate20240315Mix(m)
 	data.Migrate20240411DDL(m)
+	data.Migrate20261016DDL(m)
 
 	return m.Run(ctx)
 }
@@ -0,0 +1,36 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/secret"
+	"github.com/urfave/cli/v2"
+)
+
+// EncryptChannelSecretsCommand 加密数据库中明文存储的渠道密钥（原地更新）
+//
+// 使用方式：aidea-server --conf config.yaml encrypt-channel-secrets，需要先配置 channel-secret-master-key
+func EncryptChannelSecretsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "encrypt-channel-secrets",
+		Usage: "加密数据库中明文存储的渠道密钥",
+		Action: func(c *cli.Context) error {
+			db, err := sql.Open("mysql", c.String("db-uri"))
+			if err != nil {
+				return fmt.Errorf("数据库连接失败: %w", err)
+			}
+			defer db.Close()
+
+			envelope := secret.NewEnvelopeWithMasterKey(c.String("channel-secret-master-key"))
+			count, err := repo.NewModelRepo(db, envelope).EncryptChannelSecrets(c.Context)
+			if err != nil {
+				return fmt.Errorf("渠道密钥加密失败（已加密 %d 个）: %w", count, err)
+			}
+
+			fmt.Printf("渠道密钥加密完成，共加密 %d 个渠道\n", count)
+			return nil
+		},
+	}
+}
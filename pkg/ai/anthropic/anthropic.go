@@ -5,10 +5,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/bodylimit"
 )
 
 type Anthropic struct {
@@ -62,8 +65,8 @@ type MessageRequest struct {
 	Model Model `json:"model"`
 	// Messages The messages that you want Claude to complete.
 	Messages []Message `json:"messages"`
-	// System System prompt
-	System string `json:"system,omitempty"`
+	// System System prompt, as an array of text content blocks (supports cache_control)
+	System []MessageContent `json:"system,omitempty"`
 	// MaxTokens The maximum number of tokens to generate before stopping.
 	// Note that our models may stop before reaching this maximum.
 	// This parameter only specifies the absolute maximum number of tokens to generate.
@@ -84,8 +87,40 @@ type MessageRequest struct {
 	// Used to remove "long tail" low probability responses. Learn more technical details here.
 	// Recommended for advanced use cases only. You usually only need to use temperature.
 	TopK int `json:"top_k,omitempty"`
+	// Thinking Configuration for enabling Claude's extended thinking.
+	// When enabled, budget_tokens must be at least 1024 and less than max_tokens,
+	// temperature and top_k can not be modified.
+	Thinking *Thinking `json:"thinking,omitempty"`
+	// StopSequences Custom text sequences that will cause the model to stop generating.
+	// If the model encounters one of the custom sequences, the response stop_reason value will be "stop_sequence"
+	// and the response stop_sequence value will contain the matched stop sequence.
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// Tools Definitions of tools that the model may use.
+	// If the model decides to use a tool, the response contains tool_use content blocks and the stop_reason is "tool_use".
+	Tools []Tool `json:"tools,omitempty"`
+}
+
+// Tool definition of a tool that the model may use
+type Tool struct {
+	// Name of the tool
+	Name string `json:"name"`
+	// Description of what this tool does
+	Description string `json:"description,omitempty"`
+	// InputSchema JSON schema for the tool input shape that the model will produce in tool_use blocks
+	InputSchema any `json:"input_schema"`
+}
+
+// Thinking extended thinking configuration
+type Thinking struct {
+	// Type enabled/disabled
+	Type string `json:"type"`
+	// BudgetTokens Determines how many tokens Claude can use for its internal reasoning process.
+	BudgetTokens int `json:"budget_tokens,omitempty"`
 }
 
+// MinThinkingBudgetTokens the minimum budget tokens for extended thinking
+const MinThinkingBudgetTokens = 1024
+
 type Message struct {
 	// Role The role of the message.
 	Role string `json:"role"`
@@ -101,12 +136,43 @@ func NewTextMessage(role string, text string) Message {
 }
 
 type MessageContent struct {
-	// Type The type of the message, support "text", "image"
+	// Type The type of the message, support "text", "image", "tool_use", "tool_result"
 	Type string `json:"type"`
 	// Text The text of the message. Required if type is "text".
 	Text string `json:"text,omitempty"`
 	// Source The source of the image. Required if type is "image".
 	Source *ImageSource `json:"source,omitempty"`
+	// ID/Name/Input The tool use id, tool name and input object. Required if type is "tool_use" (assistant messages).
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// ToolUseID/Content The id of the tool use this result answers and the result text. Required if type is "tool_result" (user messages).
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	// CacheControl Marks the end of a reusable prompt prefix (prompt caching).
+	// The prefix up to and including this block is cached, and later requests with the same prefix read from the cache.
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl prompt caching breakpoint
+type CacheControl struct {
+	// Type only support "ephemeral"
+	Type string `json:"type"`
+}
+
+// NewEphemeralCacheControl creates a cache breakpoint with the default lifetime (5 minutes)
+func NewEphemeralCacheControl() *CacheControl {
+	return &CacheControl{Type: "ephemeral"}
+}
+
+// NewToolUseContent creates a tool_use block of an assistant message, input must be a JSON object
+func NewToolUseContent(id, name string, input json.RawMessage) MessageContent {
+	return MessageContent{Type: "tool_use", ID: id, Name: name, Input: input}
+}
+
+// NewToolResultContent creates a tool_result block of a user message
+func NewToolResultContent(toolUseID, content string) MessageContent {
+	return MessageContent{Type: "tool_result", ToolUseID: toolUseID, Content: content}
 }
 
 func NewImageSource(mediaType, data string) *ImageSource {
@@ -177,11 +243,20 @@ func (resp MessageResponse) Text() string {
 type Usage struct {
 	InputTokens  int `json:"input_tokens,omitempty"`
 	OutputTokens int `json:"output_tokens,omitempty"`
+	// CacheCreationInputTokens The number of input tokens used to create the cache entry.
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	// CacheReadInputTokens The number of input tokens read from the cache.
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"`
 }
 
 type MessageResponseContent struct {
+	// Type The type of the content block, "text" or "tool_use"
 	Type string `json:"type,omitempty"`
 	Text string `json:"text,omitempty"`
+	// ID/Name/Input The tool use id, tool name and input object, only in "tool_use" blocks
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
 }
 
 func (ai *Anthropic) Chat(ctx context.Context, req MessageRequest) (*MessageResponse, error) {
@@ -213,13 +288,18 @@ func (ai *Anthropic) Chat(ctx context.Context, req MessageRequest) (*MessageResp
 	defer httpResp.Body.Close()
 
 	if httpResp.StatusCode < http.StatusOK || httpResp.StatusCode >= http.StatusBadRequest {
-		data, _ := io.ReadAll(httpResp.Body)
-		return nil, fmt.Errorf("chat failed [%s]: %s", httpResp.Status, string(data))
+		data, err := io.ReadAll(httpResp.Body)
+		if errors.Is(err, bodylimit.ErrResponseTooLarge) {
+			// 不保留已经读取的部分内容，避免记录到日志中
+			return nil, fmt.Errorf("chat failed [%s]: %w", httpResp.Status, err)
+		}
+
+		return nil, &HTTPError{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: data}
 	}
 
 	var chatResp MessageResponse
 	if err := json.NewDecoder(httpResp.Body).Decode(&chatResp); err != nil {
-		return nil, fmt.Errorf("decode response failed: %s", err)
+		return nil, fmt.Errorf("decode response failed: %w", err)
 	}
 
 	return &chatResp, nil
@@ -237,6 +317,11 @@ type MessageStreamResponse struct {
 	Type  string        `json:"type"`
 	Index int           `json:"index,omitempty"`
 	Delta *MessageDelta `json:"delta,omitempty"`
+	// ContentBlock The content block being started, only in the content_block_start event.
+	// For "tool_use" blocks, the input is streamed as input_json_delta fragments in the following content_block_delta events.
+	ContentBlock *MessageResponseContent `json:"content_block,omitempty"`
+	// Message The message object with empty content, only in the message_start event
+	Message *MessageResponse `json:"message,omitempty"`
 	// Error 错误信息
 	Error *ResponseError `json:"error,omitempty"`
 }
@@ -250,8 +335,12 @@ func (res MessageStreamResponse) Text() string {
 }
 
 type MessageDelta struct {
-	Type         string `json:"type,omitempty"`
-	Text         string `json:"text,omitempty"`
+	// Type The type of the delta, "text_delta" or "input_json_delta" in content_block_delta events
+	Type string `json:"type,omitempty"`
+	Text string `json:"text,omitempty"`
+	// PartialJSON A fragment of the tool input JSON, only in "input_json_delta" deltas.
+	// The fragments of a block must be concatenated before parsing.
+	PartialJSON  string `json:"partial_json,omitempty"`
 	StopReason   string `json:"stop_reason,omitempty"`
 	StopSequence string `json:"stop_sequence,omitempty"`
 	Usage        *Usage `json:"usage,omitempty"`
@@ -289,10 +378,14 @@ func (ai *Anthropic) ChatStream(ctx context.Context, req MessageRequest) (<-chan
 	}
 
 	if httpResp.StatusCode < http.StatusOK || httpResp.StatusCode >= http.StatusBadRequest {
-		data, _ := io.ReadAll(httpResp.Body)
+		data, err := io.ReadAll(httpResp.Body)
 		_ = httpResp.Body.Close()
 
-		return nil, fmt.Errorf("chat failed [%s]: %s", httpResp.Status, string(data))
+		if errors.Is(err, bodylimit.ErrResponseTooLarge) {
+			return nil, fmt.Errorf("chat failed [%s]: %w", httpResp.Status, err)
+		}
+
+		return nil, &HTTPError{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: data}
 	}
 
 	res := make(chan MessageStreamResponse)
@@ -339,12 +432,13 @@ func (ai *Anthropic) ChatStream(ctx context.Context, req MessageRequest) (<-chan
 				return
 			}
 
-			if chatResponse.Delta != nil {
+			// content_block_start/content_block_stop 不包含 delta，但工具调用需要根据它们确定内容块的边界
+			if chatResponse.Delta != nil || chatResponse.ContentBlock != nil || chatResponse.Type == "content_block_stop" {
 				select {
 				case <-ctx.Done():
 					return
 				case res <- chatResponse:
-					if chatResponse.Delta.StopReason != "" {
+					if chatResponse.Delta != nil && chatResponse.Delta.StopReason != "" {
 						return
 					}
 				}
@@ -355,6 +449,29 @@ func (ai *Anthropic) ChatStream(ctx context.Context, req MessageRequest) (<-chan
 	return res, nil
 }
 
+// HTTPError 请求失败时返回的错误，包含原始的响应内容
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("chat failed [%s]: %s", e.Status, string(e.Body))
+}
+
+// ResponseError 解析响应内容中的错误信息，解析失败时返回 nil
+func (e *HTTPError) ResponseError() *ResponseError {
+	var resp struct {
+		Error *ResponseError `json:"error"`
+	}
+	if err := json.Unmarshal(e.Body, &resp); err != nil {
+		return nil
+	}
+
+	return resp.Error
+}
+
 type ResponseError struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
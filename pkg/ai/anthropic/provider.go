@@ -1,6 +1,8 @@
 package anthropic
 
 import (
+	"github.com/mylxsw/aidea-server/pkg/ai/bodylimit"
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
 	"github.com/mylxsw/aidea-server/pkg/proxy"
 	"net/http"
 
@@ -19,6 +21,8 @@ func (Provider) Register(binder infra.Binder) {
 			})
 		}
 
+		client.Transport = bodylimit.NewTransport(recorder.NewTransport(client.Transport, "anthropic"), conf.ChatMaxResponseSizeBytes())
+
 		return New(conf.AnthropicServer, conf.AnthropicAPIKey, client)
 	})
 }
@@ -0,0 +1,91 @@
+package bodylimit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// ErrResponseTooLarge 上游响应内容超过大小限制，可以使用 errors.Is 判断 ResponseTooLargeError
+var ErrResponseTooLarge = errors.New("上游响应内容过大")
+
+// DefaultMaxSize 默认的非流式响应最大字节数
+const DefaultMaxSize int64 = 32 * 1024 * 1024
+
+// ResponseTooLargeError 上游响应内容超过大小限制，不包含响应内容，避免日志中记录大量无用的数据
+type ResponseTooLargeError struct {
+	// Limit 允许的最大字节数
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("%s（超过 %d 字节）", ErrResponseTooLarge.Error(), e.Limit)
+}
+
+// Is 兼容 errors.Is(err, ErrResponseTooLarge)
+func (e *ResponseTooLargeError) Is(target error) bool {
+	return target == ErrResponseTooLarge
+}
+
+// Transport 限制非流式响应的大小，超过限制时中断读取并返回 ResponseTooLargeError，避免上游异常时（如生成失控、返回 HTML 错误页面）
+// 将大量数据读取到内存中。流式响应（text/event-stream）不受限制
+type Transport struct {
+	base    http.RoundTripper
+	maxSize int64
+}
+
+// NewTransport 创建限制响应大小的 Transport，maxSize 小于等于 0 时使用 DefaultMaxSize
+func NewTransport(base http.RoundTripper, maxSize int64) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	return &Transport{base: base, maxSize: maxSize}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || isStream(resp) {
+		return resp, err
+	}
+
+	if resp.ContentLength > t.maxSize {
+		_ = resp.Body.Close()
+		return nil, &ResponseTooLargeError{Limit: t.maxSize}
+	}
+
+	resp.Body = &limitedBody{ReadCloser: resp.Body, limit: t.maxSize}
+	return resp, nil
+}
+
+func isStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// limitedBody 读取超过 limit 字节时返回 ResponseTooLargeError
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, &ResponseTooLargeError{Limit: b.limit}
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), &ResponseTooLargeError{Limit: b.limit}
+	}
+
+	return n, err
+}
@@ -0,0 +1,70 @@
+package bodylimit_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/bodylimit"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestTransport(t *testing.T) {
+	body := strings.Repeat("x", 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/content-length":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(body))
+		case "/chunked":
+			w.Header().Set("Content-Type", "application/json")
+			for i := 0; i < 4; i++ {
+				_, _ = w.Write([]byte(body[:512]))
+				w.(http.Flusher).Flush()
+			}
+		case "/stream":
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			_, _ = w.Write([]byte(body))
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: bodylimit.NewTransport(nil, 1024)}
+	get := func(path string) ([]byte, error) {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		return io.ReadAll(resp.Body)
+	}
+
+	// 响应头中的长度超过限制时，不读取响应体
+	_, err := get("/content-length")
+	assert.True(t, errors.Is(err, bodylimit.ErrResponseTooLarge))
+
+	// 没有响应长度时，读取超过限制后中断
+	data, err := get("/chunked")
+	assert.True(t, errors.Is(err, bodylimit.ErrResponseTooLarge))
+	assert.Equal(t, 1024, len(data))
+
+	var tooLarge *bodylimit.ResponseTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.EqualValues(t, 1024, tooLarge.Limit)
+	assert.False(t, strings.Contains(err.Error(), "xxx"))
+
+	// 流式响应不受限制
+	data, err = get("/stream")
+	assert.NoError(t, err)
+	assert.Equal(t, 2048, len(data))
+
+	data, err = get("/")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+}
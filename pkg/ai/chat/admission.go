@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/rate"
+	"github.com/mylxsw/asteria/log"
+)
+
+// Admission 按照优先级准入的并发控制：同时处理的请求数量达到上限时，新的请求进入等待队列，
+// 有请求完成时优先准入优先级（Request.Priority）最高的请求，优先级相同时先到先得。
+//
+// 为了避免低优先级的请求在高负载下一直等待，等待时间超过 maxWait 的请求不再按照优先级排序，按照等待的先后顺序最先准入，
+// 因此任何请求的等待时间不会超过 maxWait 加上排在它之前的超时请求的处理时间
+type Admission struct {
+	lock    sync.Mutex
+	limit   int
+	maxWait time.Duration
+	running int
+	// waiters 等待准入的请求，按照进入队列的先后顺序排列
+	waiters []*admissionWaiter
+	now     func() time.Time
+	// metrics 准入和排队等待时间的统计，为 nil 时不统计
+	metrics rate.MetricsSink
+}
+
+type admissionWaiter struct {
+	priority int
+	queuedAt time.Time
+	// ready 准入时关闭，准入的请求占用了释放者的名额
+	ready chan struct{}
+}
+
+// NewAdmission 创建并发控制，limit 为同时处理的最大请求数量，maxWait 为低优先级的请求最长的等待时间，为 0 时只按照优先级准入，
+// metrics 不为 nil 时上报每个请求的准入结果和排队等待时间
+func NewAdmission(limit int, maxWait time.Duration, metrics rate.MetricsSink) *Admission {
+	return &Admission{limit: max(limit, 1), maxWait: maxWait, now: time.Now, metrics: metrics}
+}
+
+// Acquire 等待准入，返回释放名额的函数（只能调用一次），ctx 取消时返回 ctx.Err()
+func (a *Admission) Acquire(ctx context.Context, priority int) (func(), error) {
+	a.lock.Lock()
+	if a.running < a.limit && len(a.waiters) == 0 {
+		a.running++
+		a.lock.Unlock()
+		a.observe(true, 0)
+		return a.releaseOnce(), nil
+	}
+
+	w := &admissionWaiter{priority: priority, queuedAt: a.now(), ready: make(chan struct{})}
+	a.waiters = append(a.waiters, w)
+	a.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		a.observe(true, a.now().Sub(w.queuedAt))
+		return a.releaseOnce(), nil
+	case <-ctx.Done():
+		a.observe(false, a.now().Sub(w.queuedAt))
+
+		a.lock.Lock()
+		for i, item := range a.waiters {
+			if item == w {
+				a.waiters = append(a.waiters[:i], a.waiters[i+1:]...)
+				a.lock.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		a.lock.Unlock()
+
+		// 取消的同时已经准入，将名额交给下一个请求
+		a.release()
+		return nil, ctx.Err()
+	}
+}
+
+// observe 上报请求的准入结果，wait 为排队等待的时间，未排队时为 0
+func (a *Admission) observe(admitted bool, wait time.Duration) {
+	if a.metrics == nil {
+		return
+	}
+
+	a.metrics.Observe(rate.Event{Scope: rate.Scope{Limiter: rate.LimiterConcurrency}, Admitted: admitted, Wait: wait})
+}
+
+func (a *Admission) releaseOnce() func() {
+	var once sync.Once
+	return func() { once.Do(a.release) }
+}
+
+// release 释放名额，有等待的请求时名额直接交给下一个准入的请求
+func (a *Admission) release() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.waiters) == 0 {
+		a.running--
+		return
+	}
+
+	next := a.next()
+	w := a.waiters[next]
+	a.waiters = append(a.waiters[:next], a.waiters[next+1:]...)
+	close(w.ready)
+}
+
+// next 下一个准入的请求：等待时间最长的请求已经超过 maxWait 时准入该请求，否则准入优先级最高的请求中最早进入队列的请求
+func (a *Admission) next() int {
+	if a.maxWait > 0 && a.now().Sub(a.waiters[0].queuedAt) >= a.maxWait {
+		return 0
+	}
+
+	next := 0
+	for i, w := range a.waiters {
+		if w.priority > a.waiters[next].priority {
+			next = i
+		}
+	}
+
+	return next
+}
+
+// Waiting 等待准入的请求数量
+func (a *Admission) Waiting() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return len(a.waiters)
+}
+
+// releaseOnDone 上游的响应流结束时释放并发名额
+func releaseOnDone(ctx context.Context, stream <-chan Response, release func()) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		// 提前退出时，上游的响应流结束之后才释放
+		defer release()
+		defer drainStream(stream)
+
+		for data := range stream {
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
+
+// Priorities 用户类型（users.user_type）对应的请求优先级，未配置的用户类型优先级为 0
+type Priorities map[int64]int
+
+// ParsePriorities 解析用户类型对应的请求优先级，格式为 "用户类型:优先级"，优先级越大越先处理，格式错误的配置会被忽略
+func ParsePriorities(tiers []string) Priorities {
+	priorities := make(Priorities)
+	for _, tier := range tiers {
+		segs := strings.SplitN(strings.TrimSpace(tier), ":", 2)
+		if len(segs) != 2 {
+			log.Warningf("请求优先级 %q 格式错误，应为 用户类型:优先级", tier)
+			continue
+		}
+
+		userType, err1 := strconv.ParseInt(strings.TrimSpace(segs[0]), 10, 64)
+		priority, err2 := strconv.Atoi(strings.TrimSpace(segs[1]))
+		if err1 != nil || err2 != nil {
+			log.Warningf("请求优先级 %q 格式错误，应为 用户类型:优先级", tier)
+			continue
+		}
+
+		priorities[userType] = priority
+	}
+
+	return priorities
+}
+
+// Priority 返回用户类型对应的请求优先级
+func (p Priorities) Priority(userType int64) int {
+	return p[userType]
+}
@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/rate"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// admissionRecorder 记录请求准入的顺序
+type admissionRecorder struct {
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+	admitted []int
+}
+
+// enqueue 将请求加入等待队列，请求准入之后记录优先级并立即释放名额，返回时请求已经进入队列
+func (r *admissionRecorder) enqueue(t *testing.T, a *Admission, priority int) {
+	waiting := a.Waiting()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		release, err := a.Acquire(context.TODO(), priority)
+		assert.NoError(t, err)
+
+		r.lock.Lock()
+		r.admitted = append(r.admitted, priority)
+		r.lock.Unlock()
+
+		release()
+	}()
+
+	for a.Waiting() != waiting+1 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmission_Priority(t *testing.T) {
+	a := NewAdmission(1, 0, nil)
+
+	release, err := a.Acquire(context.TODO(), 0)
+	assert.NoError(t, err)
+
+	var rec admissionRecorder
+	for _, priority := range []int{0, 5, 1, 5} {
+		rec.enqueue(t, a, priority)
+	}
+
+	release()
+	rec.wg.Wait()
+
+	assert.Equal(t, []int{5, 5, 1, 0}, rec.admitted)
+	assert.Equal(t, 0, a.Waiting())
+}
+
+func TestAdmission_Starvation(t *testing.T) {
+	var elapsed atomic.Int64
+	start := time.Now()
+
+	a := NewAdmission(1, 10*time.Second, nil)
+	a.now = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+
+	release, err := a.Acquire(context.TODO(), 0)
+	assert.NoError(t, err)
+
+	var rec admissionRecorder
+	rec.enqueue(t, a, 0)
+	elapsed.Store(int64(5 * time.Second))
+	rec.enqueue(t, a, 1)
+
+	// 低优先级的请求已经等待超过 maxWait，先于高优先级的请求准入
+	elapsed.Store(int64(11 * time.Second))
+	rec.enqueue(t, a, 10)
+
+	release()
+	rec.wg.Wait()
+
+	assert.Equal(t, []int{0, 10, 1}, rec.admitted)
+}
+
+// admissionEvents 记录并发控制上报的限流事件
+type admissionEvents struct {
+	lock   sync.Mutex
+	events []rate.Event
+}
+
+func (e *admissionEvents) Observe(evt rate.Event) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.events = append(e.events, evt)
+}
+
+func TestAdmission_Metrics(t *testing.T) {
+	var elapsed atomic.Int64
+	start := time.Now()
+
+	events := &admissionEvents{}
+	a := NewAdmission(1, 0, events)
+	a.now = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+
+	release, err := a.Acquire(context.TODO(), 0)
+	assert.NoError(t, err)
+
+	var rec admissionRecorder
+	rec.enqueue(t, a, 0)
+
+	elapsed.Store(int64(2 * time.Second))
+	release()
+	rec.wg.Wait()
+
+	// 排队取消的请求记录为拒绝
+	release, err = a.Acquire(context.TODO(), 0)
+	assert.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = a.Acquire(ctx, 0)
+	assert.True(t, err != nil)
+
+	scope := rate.Scope{Limiter: rate.LimiterConcurrency}
+	assert.Equal(t, []rate.Event{
+		{Scope: scope, Admitted: true},
+		{Scope: scope, Admitted: true, Wait: 2 * time.Second},
+		{Scope: scope, Admitted: true},
+		{Scope: scope, Admitted: false},
+	}, events.events)
+}
+
+func TestAdmission_Cancel(t *testing.T) {
+	a := NewAdmission(1, 0, nil)
+
+	release, err := a.Acquire(context.TODO(), 0)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = a.Acquire(ctx, 10)
+	assert.True(t, err != nil)
+	assert.Equal(t, 0, a.Waiting())
+
+	// 重复释放不会多释放名额
+	release()
+	release()
+
+	release, err = a.Acquire(context.TODO(), 0)
+	assert.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = a.Acquire(ctx, 0)
+	assert.True(t, err != nil)
+	release()
+}
+
+func TestDispatcher_AdmissionStream(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "hello"}, {FinishReason: "stop"}}}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+	d.admission = NewAdmission(1, 0, nil)
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+	for range stream {
+	}
+
+	// 响应流结束之后释放名额
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+
+	release, err := d.admission.Acquire(ctx, 0)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestParsePriorities(t *testing.T) {
+	priorities := ParsePriorities([]string{"1:10", " 3 : 5 ", "invalid", "2:x"})
+
+	assert.Equal(t, 10, priorities.Priority(1))
+	assert.Equal(t, 5, priorities.Priority(3))
+	assert.Equal(t, 0, priorities.Priority(2))
+	assert.Equal(t, 0, priorities.Priority(0))
+}
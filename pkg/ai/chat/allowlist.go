@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+)
+
+// ErrNoAllowedProvider 模型配置的服务提供商（渠道）都不允许使用该模型（参考 repo.ChannelMeta.AllowedModels）
+var ErrNoAllowedProvider = errors.New("没有可以使用该模型的服务渠道")
+
+// providerModel 服务提供商实际请求上游时使用的模型名称（模型重写之后的名称）
+func providerModel(mod repo.Model, pro repo.ModelProvider) string {
+	if pro.ModelRewrite != "" {
+		return pro.ModelRewrite
+	}
+
+	return mod.ModelId
+}
+
+// allowedProviders 去掉渠道的模型允许列表不包含该模型的服务提供商，避免模型配置错误时将请求发送到错误的渠道
+//
+// 主备切换在过滤之后的服务提供商中进行，所以备用渠道同样需要满足允许列表；
+// 渠道信息查询失败时保留该服务提供商，不影响正常的请求
+func allowedProviders(ctx context.Context, channels ChannelQuerier, mod repo.Model) ([]repo.ModelProvider, error) {
+	if channels == nil || len(mod.Providers) == 0 {
+		return mod.Providers, nil
+	}
+
+	ret := make([]repo.ModelProvider, 0, len(mod.Providers))
+	for _, pro := range mod.Providers {
+		if pro.ID <= 0 {
+			ret = append(ret, pro)
+			continue
+		}
+
+		ch, err := channels.Channel(ctx, pro.ID)
+		if err != nil {
+			log.F(log.M{"model": mod.ModelId, "channel_id": pro.ID}).Errorf("query channel failed, skip allowed models check: %v", err)
+			ret = append(ret, pro)
+			continue
+		}
+
+		if upstream := providerModel(mod, pro); !ch.Meta.AllowsModel(upstream) {
+			log.F(log.M{"model": mod.ModelId, "upstream_model": upstream, "channel_id": pro.ID}).Warningf("渠道的模型允许列表不包含该模型，跳过该渠道")
+			continue
+		}
+
+		ret = append(ret, pro)
+	}
+
+	if len(ret) == 0 {
+		return nil, ErrNoAllowedProvider
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestChannelMeta_AllowsModel(t *testing.T) {
+	// 允许列表为空时允许所有模型
+	assert.True(t, repo.ChannelMeta{}.AllowsModel("gpt-4o"))
+
+	meta := repo.ChannelMeta{AllowedModels: []string{"gpt-4o", "claude-3-*", "openai/gpt-4?", " qwen-max "}}
+	for _, name := range []string{"gpt-4o", "claude-3-opus-20240229", "openai/gpt-4o", "qwen-max"} {
+		assert.True(t, meta.AllowsModel(name))
+	}
+
+	for _, name := range []string{"gpt-4o-mini", "gpt-4", "claude-2.1", "openai/gpt-4-turbo", ""} {
+		assert.False(t, meta.AllowsModel(name))
+	}
+
+	// * 可以匹配 /
+	assert.True(t, repo.ChannelMeta{AllowedModels: []string{"*/llama-*"}}.AllowsModel("meta-llama/llama-3-70b"))
+	assert.True(t, repo.ChannelMeta{AllowedModels: []string{"*"}}.AllowsModel("anything"))
+}
+
+func newAllowlistTestDispatcher(channels fakeChannelQuerier) (*Dispatcher, *fakeClientFactory) {
+	router := fakeModelRouter{
+		"gpt-4o": {
+			Models: model.Models{ModelId: "gpt-4o"},
+			Providers: []repo.ModelProvider{
+				{ID: 1},
+				{ID: 2, ModelRewrite: "gpt-4o-2024-08-06"},
+			},
+		},
+	}
+
+	factory := &fakeClientFactory{client: &streamChatClient{}, typ: service.ProviderOpenAI}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.channels = channels
+
+	return d, factory
+}
+
+func TestDispatcher_ChannelAllowedModels(t *testing.T) {
+	ch1 := newTestChannel(1, service.ProviderAnthropic)
+	ch1.Meta.AllowedModels = []string{"claude-*"}
+	ch2 := newTestChannel(2, service.ProviderOpenAI)
+	ch2.Meta.AllowedModels = []string{"gpt-4o-*"}
+
+	// 第一个渠道不允许使用该模型，使用第二个渠道（检查的是重写之后的模型名称）
+	d, factory := newAllowlistTestDispatcher(fakeChannelQuerier{1: ch1, 2: ch2})
+	_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, factory.providers[0].ID)
+
+	// 所有渠道都不允许使用该模型
+	ch2.Meta.AllowedModels = []string{"gpt-4o"}
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.True(t, errors.Is(err, ErrNoAllowedProvider))
+	assert.Equal(t, 1, len(factory.providers))
+
+	// 允许列表为空，或者渠道信息查询失败时，不影响渠道的选择
+	d, factory = newAllowlistTestDispatcher(fakeChannelQuerier{1: newTestChannel(1, service.ProviderOpenAI)})
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, factory.providers[0].ID)
+
+	d, factory = newAllowlistTestDispatcher(fakeChannelQuerier{})
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, factory.providers[0].ID)
+}
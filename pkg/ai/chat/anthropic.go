@@ -2,14 +2,23 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
 	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/service"
 	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/go-utils/ternary"
 	"strings"
 )
 
+// anthropicDefaultMaxTokens 请求中未指定 max_tokens 时，默认的最大输出 Token 数量
+const anthropicDefaultMaxTokens = 4000
+
+// anthropicPromptCacheMinTokens 系统提示语达到该 Token 数量时，标记为可缓存的前缀
+// （Anthropic 不缓存过短的前缀，而写入缓存的价格比普通输入更高）
+const anthropicPromptCacheMinTokens = 1024
+
 type AnthropicChat struct {
 	ai *anthropic.Anthropic
 }
@@ -29,6 +38,23 @@ func (chat *AnthropicChat) initRequest(req Request) (anthropic.MessageRequest, e
 			if msg.Content != "" {
 				systemMessage = msg.Content
 			}
+		} else if msg.Role == RoleTool {
+			// Anthropic 没有 tool 角色，工具调用结果以 tool_result 块的形式放在用户消息中，
+			// 连续的多个工具调用结果（并行工具调用）合并到同一条用户消息
+			result := anthropic.NewToolResultContent(msg.ToolCallID, msg.Content)
+			if n := len(contextMessages); n > 0 && contextMessages[n-1].Role == string(RoleUser) && isAnthropicToolResults(contextMessages[n-1].Content) {
+				contextMessages[n-1].Content = append(contextMessages[n-1].Content, result)
+			} else {
+				contextMessages = append(contextMessages, anthropic.Message{
+					Role:    string(RoleUser),
+					Content: []anthropic.MessageContent{result},
+				})
+			}
+		} else if msg.Role == RoleAssistant && len(msg.ToolCalls) > 0 {
+			contextMessages = append(contextMessages, anthropic.Message{
+				Role:    string(msg.Role),
+				Content: toAnthropicToolUses(msg.Content, msg.ToolCalls),
+			})
 		} else {
 			if msg.MultipartContents != nil {
 				contents := make([]anthropic.MessageContent, 0)
@@ -37,6 +63,7 @@ func (chat *AnthropicChat) initRequest(req Request) (anthropic.MessageRequest, e
 					if ct.Type == "text" {
 						item.Text = ct.Text
 					} else if ct.ImageURL != nil {
+						// Claude 不支持图片识别精度，忽略 ImageURL.Detail
 						imageMimeType, err := misc.Base64ImageMediaType(ct.ImageURL.URL)
 						if err != nil {
 							log.F(log.M{"url": ct.ImageURL.URL}).Errorf("parse image mime type failed: %v", err)
@@ -53,22 +80,48 @@ func (chat *AnthropicChat) initRequest(req Request) (anthropic.MessageRequest, e
 				}
 
 				contextMessages = append(contextMessages, anthropic.Message{
-					Role:    msg.Role,
+					Role:    string(msg.Role),
 					Content: contents,
 				})
 			} else {
-				contextMessages = append(contextMessages, anthropic.NewTextMessage(msg.Role, msg.Content))
+				contextMessages = append(contextMessages, anthropic.NewTextMessage(string(msg.Role), msg.Content))
 			}
 		}
 	}
 
 	res := anthropic.MessageRequest{
-		Model:    anthropic.Model(req.Model),
-		Messages: contextMessages,
+		Model:         anthropic.Model(req.Model),
+		Messages:      contextMessages,
+		StopSequences: req.Stop,
+		Tools:         toAnthropicTools(req.Tools),
 	}
 
 	if systemMessage != "" {
-		res.System = systemMessage
+		system := anthropic.MessageContent{Type: "text", Text: systemMessage}
+		// 系统提示语在同一个角色的多轮对话之间保持不变，标记为缓存前缀之后，后续请求可以使用缓存的价格
+		if tokens, err := MessageTokenCount(Messages{{Role: RoleSystem, Content: systemMessage}}, req.Model); err == nil && tokens >= anthropicPromptCacheMinTokens {
+			system.CacheControl = anthropic.NewEphemeralCacheControl()
+		}
+
+		res.System = []anthropic.MessageContent{system}
+	}
+
+	if req.Temperature != nil {
+		// Anthropic 的 temperature 取值范围为 0-1
+		res.Temperature = min(*req.Temperature, 1)
+	}
+
+	if req.TopP != nil {
+		res.TopP = *req.TopP
+	}
+
+	if req.ReasoningBudget.Enabled() {
+		// 思考预算不能低于 Anthropic 的最小值，且必须小于 max_tokens，这里在输出 Token 数量的基础上追加思考预算
+		budget := max(req.ReasoningBudget.Tokens(), anthropic.MinThinkingBudgetTokens)
+		res.Thinking = &anthropic.Thinking{Type: "enabled", BudgetTokens: budget}
+		res.MaxTokens = budget + ternary.If(req.MaxTokens > 0, req.MaxTokens, anthropicDefaultMaxTokens)
+		// 启用思考时，不允许修改 temperature
+		res.Temperature = 0
 	}
 
 	return res, nil
@@ -82,17 +135,23 @@ func (chat *AnthropicChat) Chat(ctx context.Context, req Request) (*Response, er
 
 	res, err := chat.ai.Chat(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, wrapAnthropicError(err)
 	}
 
 	if res.Error != nil && res.Error.Type != "" {
 		return nil, fmt.Errorf("anthropic ai chat error: [%s] %s", res.Error.Type, res.Error.Message)
 	}
 
-	ret := Response{Text: res.Text()}
+	ret := Response{
+		Text:         res.Text(),
+		FinishReason: NormalizeFinishReason(res.StopReason),
+		StoppedBy:    res.StopSequence,
+		ToolCalls:    fromAnthropicToolUses(res.Content),
+	}
 	if res.Usage != nil {
 		ret.InputTokens = res.Usage.InputTokens
 		ret.OutputTokens = res.Usage.OutputTokens
+		observeUpstreamPromptCache(service.ProviderAnthropic, res.Usage.CacheReadInputTokens, res.Usage.CacheCreationInputTokens)
 	}
 
 	return &ret, nil
@@ -106,33 +165,114 @@ func (chat *AnthropicChat) ChatStream(ctx context.Context, req Request) (<-chan
 
 	stream, err := chat.ai.ChatStream(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, wrapAnthropicError(err)
 	}
 
 	res := make(chan Response)
 	go func() {
 		defer close(res)
 
+		// 工具调用的参数以 input_json_delta 片段的形式返回，需要按照内容块组装后才能使用
+		// toolBlocks 内容块序号（包括文本内容块）到工具调用序号的映射
+		toolCalls := newToolCallAssembler()
+		toolBlocks := make(map[int]int)
+		toolCallsSent := false
+
+		// sendToolDelta 开启 Request.StreamToolCalls 时，返回工具调用的中间状态
+		sendToolDelta := func(delta ToolCallDelta) bool {
+			if !req.StreamToolCalls {
+				return true
+			}
+
+			select {
+			case <-ctx.Done():
+				return false
+			case res <- Response{ToolCallDelta: &delta, Interim: true}:
+				return true
+			}
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case data, ok := <-stream:
 				if !ok {
+					// 没有收到结束原因时，仍然返回已经组装的工具调用，与 OpenAI 的处理方式一致
+					if !toolCallsSent && !toolCalls.Empty() {
+						select {
+						case <-ctx.Done():
+						case res <- Response{ToolCalls: toolCalls.ToolCalls()}:
+						}
+					}
 					return
 				}
 				if data.Error != nil && data.Error.Type != "" {
+					body, _ := json.Marshal(data.Error)
+					upstreamErr := NewUpstreamError("anthropic", 0, data.Error.Type, "", data.Error.Message, body)
+
 					select {
 					case <-ctx.Done():
-					case res <- Response{Error: data.Error.Message, ErrorCode: data.Error.Type}:
+					case res <- Response{Error: data.Error.Message, ErrorCode: upstreamErr.ErrorCode(), Upstream: upstreamErr}:
 					}
 					return
 				}
 
+				if data.Message != nil && data.Message.Usage != nil {
+					observeUpstreamPromptCache(service.ProviderAnthropic, data.Message.Usage.CacheReadInputTokens, data.Message.Usage.CacheCreationInputTokens)
+				}
+
+				switch data.Type {
+				case "content_block_start":
+					if data.ContentBlock == nil || data.ContentBlock.Type != "tool_use" {
+						continue
+					}
+
+					toolBlocks[data.Index] = len(toolBlocks)
+					if !sendToolDelta(toolCalls.Add(toolBlocks[data.Index], data.ContentBlock.ID, "function", data.ContentBlock.Name, "")) {
+						return
+					}
+					continue
+				case "content_block_delta":
+					index, isTool := toolBlocks[data.Index]
+					if !isTool || data.Delta == nil || data.Delta.Type != "input_json_delta" {
+						break
+					}
+
+					// 参数为空的工具调用，片段内容为空字符串，不需要返回中间状态
+					if data.Delta.PartialJSON == "" {
+						continue
+					}
+
+					if !sendToolDelta(toolCalls.Add(index, "", "", "", data.Delta.PartialJSON)) {
+						return
+					}
+					continue
+				case "content_block_stop":
+					if index, isTool := toolBlocks[data.Index]; isTool {
+						if err := toolCalls.Complete(index); err != nil {
+							log.F(log.M{"index": index, "model": req.Model}).Warningf("anthropic tool_use input is not a valid json: %v", err)
+						}
+					}
+					continue
+				}
+
+				item := Response{Text: data.Text()}
+				if data.Delta != nil {
+					item.FinishReason = NormalizeFinishReason(data.Delta.StopReason)
+					item.StoppedBy = data.Delta.StopSequence
+				}
+
+				// 工具调用在包含结束原因（tool_use）的最后一个响应中返回
+				if item.FinishReason != "" && !toolCalls.Empty() {
+					item.ToolCalls = toolCalls.ToolCalls()
+					toolCallsSent = true
+				}
+
 				select {
 				case <-ctx.Done():
 					return
-				case res <- Response{Text: data.Text()}:
+				case res <- item:
 				}
 			}
 		}
@@ -141,6 +281,84 @@ func (chat *AnthropicChat) ChatStream(ctx context.Context, req Request) (<-chan
 	return res, nil
 }
 
+// toAnthropicTools 将 OpenAI 兼容格式的工具定义转换为 Anthropic 的格式
+func toAnthropicTools(tools []Tool) []anthropic.Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	ret := make([]anthropic.Tool, 0, len(tools))
+	for _, tool := range tools {
+		// Anthropic 要求必须指定 input_schema
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+
+		ret = append(ret, anthropic.Tool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	return ret
+}
+
+// fromAnthropicToolUses 将响应中的 tool_use 内容块转换为 OpenAI 兼容格式的工具调用
+// toAnthropicToolUses 将助手消息中的工具调用转换为 tool_use 块，消息内容不为空时作为第一个 text 块
+func toAnthropicToolUses(content string, calls []ToolCall) []anthropic.MessageContent {
+	contents := make([]anthropic.MessageContent, 0, len(calls)+1)
+	if content != "" {
+		contents = append(contents, anthropic.MessageContent{Type: "text", Text: content})
+	}
+
+	for _, call := range calls {
+		// tool_use 的 input 必须是 JSON 对象，参数为空或者无效时使用空对象
+		input := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(input) || !strings.HasPrefix(strings.TrimSpace(call.Function.Arguments), "{") {
+			input = json.RawMessage("{}")
+		}
+
+		contents = append(contents, anthropic.NewToolUseContent(call.ID, call.Function.Name, input))
+	}
+
+	return contents
+}
+
+// isAnthropicToolResults 判断消息内容是否全部为 tool_result 块
+func isAnthropicToolResults(contents []anthropic.MessageContent) bool {
+	for _, ct := range contents {
+		if ct.Type != "tool_result" {
+			return false
+		}
+	}
+
+	return len(contents) > 0
+}
+
+func fromAnthropicToolUses(contents []anthropic.MessageResponseContent) []ToolCall {
+	var ret []ToolCall
+	for _, content := range contents {
+		if content.Type != "tool_use" {
+			continue
+		}
+
+		arguments := string(content.Input)
+		if arguments == "" {
+			arguments = "{}"
+		}
+
+		ret = append(ret, ToolCall{
+			ID:       content.ID,
+			Type:     "function",
+			Function: ToolCallFunction{Name: content.Name, Arguments: arguments},
+		})
+	}
+
+	return ret
+}
+
 func (chat *AnthropicChat) MaxContextLength(model string) int {
 	// https://docs.anthropic.com/claude/reference/selecting-a-model
 	// 这里减掉 4000 用于输出
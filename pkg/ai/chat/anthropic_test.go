@@ -0,0 +1,217 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// anthropicFixtureServer 返回 testdata 中录制的 Anthropic 响应，并记录请求体
+func anthropicFixtureServer(t *testing.T, fixture string, body *map[string]any) *httptest.Server {
+	data, err := os.ReadFile(fixture)
+	assert.NoError(t, err)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(reqBody, body))
+
+		if stream, _ := (*body)["stream"].(bool); stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		_, _ = w.Write(data)
+	}))
+}
+
+var anthropicWeatherTools = []Tool{
+	{
+		Type: "function",
+		Function: ToolFunction{
+			Name: "get_weather",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"city": map[string]any{"type": "string"}, "unit": map[string]any{"type": "string"}},
+			},
+		},
+	},
+	{Type: "function", Function: ToolFunction{Name: "get_time"}},
+}
+
+func TestAnthropicChat_ChatStreamParallelToolUse(t *testing.T) {
+	var body map[string]any
+	server := anthropicFixtureServer(t, "testdata/anthropic_parallel_tool_use.sse", &body)
+	defer server.Close()
+
+	stream, err := NewAnthropicChat(anthropic.New(server.URL, "sk-test", http.DefaultClient)).ChatStream(context.TODO(), Request{
+		Model:           "claude-3-5-sonnet-20241022",
+		Messages:        Messages{{Role: RoleUser, Content: "北京和上海现在的天气怎么样？现在几点了？"}},
+		Tools:           anthropicWeatherTools,
+		StreamToolCalls: true,
+	})
+	assert.NoError(t, err)
+
+	var text string
+	var deltas []ToolCallDelta
+	var final []Response
+	for res := range stream {
+		if res.Interim {
+			deltas = append(deltas, *res.ToolCallDelta)
+			continue
+		}
+
+		text += res.Text
+		if res.FinishReason != "" || len(res.ToolCalls) > 0 {
+			final = append(final, res)
+		}
+	}
+
+	// 工具定义转换为 Anthropic 的格式，没有参数定义的工具使用空对象
+	tools := body["tools"].([]any)
+	assert.Equal(t, 2, len(tools))
+	assert.Equal(t, "get_weather", tools[0].(map[string]any)["name"])
+	assert.Equal(t, "object", tools[1].(map[string]any)["input_schema"].(map[string]any)["type"])
+
+	assert.Equal(t, "我来同时查询两个城市的天气。", text)
+
+	// 中间状态按照内容块的顺序返回，工具调用的序号不包含文本内容块
+	expectedDeltas := []ToolCallDelta{
+		{Index: 0, ID: "toolu_01T1x1fJ34qAmk2tNTrN7Up6", Name: "get_weather"},
+		{Index: 0, ID: "toolu_01T1x1fJ34qAmk2tNTrN7Up6", Name: "get_weather", ArgumentsDelta: `{"city": "北`},
+		{Index: 0, ID: "toolu_01T1x1fJ34qAmk2tNTrN7Up6", Name: "get_weather", ArgumentsDelta: `京", "unit": "c`},
+		{Index: 0, ID: "toolu_01T1x1fJ34qAmk2tNTrN7Up6", Name: "get_weather", ArgumentsDelta: `elsius"}`},
+		{Index: 1, ID: "toolu_01PrJ4aT6yHv2CkvQvzVfXs9", Name: "get_weather"},
+		{Index: 1, ID: "toolu_01PrJ4aT6yHv2CkvQvzVfXs9", Name: "get_weather", ArgumentsDelta: `{"city"`},
+		{Index: 1, ID: "toolu_01PrJ4aT6yHv2CkvQvzVfXs9", Name: "get_weather", ArgumentsDelta: `: "上海", "unit": "celsius"}`},
+		{Index: 2, ID: "toolu_01VkQ8yCkD3wP9mNhR5sLbTe", Name: "get_time"},
+	}
+	assert.EqualValues(t, expectedDeltas, deltas)
+
+	// 组装完成的工具调用与结束原因在同一个响应中返回
+	assert.Equal(t, 1, len(final))
+	assert.Equal(t, FinishReasonToolCalls, final[0].FinishReason)
+	assert.Equal(t, 3, len(final[0].ToolCalls))
+
+	cities := make([]string, 0)
+	for _, call := range final[0].ToolCalls[:2] {
+		assert.Equal(t, "function", call.Type)
+		assert.Equal(t, "get_weather", call.Function.Name)
+
+		var args map[string]string
+		assert.NoError(t, json.Unmarshal([]byte(call.Function.Arguments), &args))
+		assert.Equal(t, "celsius", args["unit"])
+		cities = append(cities, args["city"])
+	}
+	assert.EqualValues(t, []string{"北京", "上海"}, cities)
+
+	// 参数为空的工具调用，参数为空对象
+	assert.Equal(t, "get_time", final[0].ToolCalls[2].Function.Name)
+	assert.Equal(t, "{}", final[0].ToolCalls[2].Function.Arguments)
+}
+
+func TestAnthropicChat_ChatStreamToolUseWithoutInterim(t *testing.T) {
+	var body map[string]any
+	server := anthropicFixtureServer(t, "testdata/anthropic_parallel_tool_use.sse", &body)
+	defer server.Close()
+
+	stream, err := NewAnthropicChat(anthropic.New(server.URL, "sk-test", http.DefaultClient)).ChatStream(context.TODO(), Request{
+		Model:    "claude-3-5-sonnet-20241022",
+		Messages: Messages{{Role: RoleUser, Content: "北京和上海现在的天气怎么样？现在几点了？"}},
+		Tools:    anthropicWeatherTools,
+	})
+	assert.NoError(t, err)
+
+	var responses []Response
+	for res := range stream {
+		assert.False(t, res.Interim)
+		responses = append(responses, res)
+	}
+
+	last := responses[len(responses)-1]
+	assert.Equal(t, FinishReasonToolCalls, last.FinishReason)
+	assert.Equal(t, `{"city": "北京", "unit": "celsius"}`, last.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, `{"city": "上海", "unit": "celsius"}`, last.ToolCalls[1].Function.Arguments)
+}
+
+func TestAnthropicChat_ChatToolUse(t *testing.T) {
+	var body map[string]any
+	server := anthropicFixtureServer(t, "testdata/anthropic_parallel_tool_use.json", &body)
+	defer server.Close()
+
+	res, err := NewAnthropicChat(anthropic.New(server.URL, "sk-test", http.DefaultClient)).Chat(context.TODO(), Request{
+		Model:    "claude-3-5-sonnet-20241022",
+		Messages: Messages{{Role: RoleUser, Content: "北京和上海现在的天气怎么样？现在几点了？"}},
+		Tools:    anthropicWeatherTools,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "我来同时查询两个城市的天气。", res.Text)
+	assert.Equal(t, FinishReasonToolCalls, res.FinishReason)
+	assert.Equal(t, 3, len(res.ToolCalls))
+	assert.Equal(t, "toolu_01T1x1fJ34qAmk2tNTrN7Up6", res.ToolCalls[0].ID)
+	assert.Equal(t, `{"city":"北京","unit":"celsius"}`, res.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, `{"city":"上海","unit":"celsius"}`, res.ToolCalls[1].Function.Arguments)
+	assert.Equal(t, "{}", res.ToolCalls[2].Function.Arguments)
+}
+
+func TestAnthropicChat_ChatToolResults(t *testing.T) {
+	var body map[string]any
+	server := anthropicFixtureServer(t, "testdata/anthropic_parallel_tool_use.json", &body)
+	defer server.Close()
+
+	ai := NewAnthropicChat(anthropic.New(server.URL, "sk-test", http.DefaultClient))
+	req := Request{
+		Model:    "claude-3-5-sonnet-20241022",
+		Messages: Messages{{Role: RoleUser, Content: "北京和上海现在的天气怎么样？现在几点了？"}},
+		Tools:    anthropicWeatherTools,
+	}
+
+	// 第一轮：模型发起工具调用
+	res, err := ai.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(res.ToolCalls))
+
+	// 第二轮：将工具调用以及工具调用结果带回给模型
+	req.Messages = append(req.Messages, Message{Role: RoleAssistant, Content: res.Text, ToolCalls: res.ToolCalls})
+	for _, call := range res.ToolCalls {
+		req.Messages = append(req.Messages, Message{Role: RoleTool, ToolCallID: call.ID, Content: "result of " + call.ID})
+	}
+
+	_, err = ai.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+
+	messages := body["messages"].([]any)
+	assert.Equal(t, 3, len(messages))
+
+	assistant := messages[1].(map[string]any)
+	assert.Equal(t, "assistant", assistant["role"])
+	contents := assistant["content"].([]any)
+	assert.Equal(t, 4, len(contents))
+	assert.Equal(t, "text", contents[0].(map[string]any)["type"])
+	assert.Equal(t, "我来同时查询两个城市的天气。", contents[0].(map[string]any)["text"])
+
+	toolUse := contents[1].(map[string]any)
+	assert.Equal(t, "tool_use", toolUse["type"])
+	assert.Equal(t, res.ToolCalls[0].ID, toolUse["id"])
+	assert.Equal(t, "get_weather", toolUse["name"])
+	assert.Equal(t, "北京", toolUse["input"].(map[string]any)["city"])
+	assert.Equal(t, 0, len(contents[3].(map[string]any)["input"].(map[string]any)))
+
+	results := messages[2].(map[string]any)
+	assert.Equal(t, "user", results["role"])
+	contents = results["content"].([]any)
+	assert.Equal(t, 3, len(contents))
+	for i, ct := range contents {
+		result := ct.(map[string]any)
+		assert.Equal(t, "tool_result", result["type"])
+		assert.Equal(t, res.ToolCalls[i].ID, result["tool_use_id"])
+		assert.Equal(t, "result of "+res.ToolCalls[i].ID, result["content"])
+	}
+}
@@ -0,0 +1,187 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+)
+
+// 请求上游失败的错误分类
+const (
+	AttemptErrorCanceled        = "canceled"
+	AttemptErrorTimeout         = "timeout"
+	AttemptErrorContentFilter   = "content_filter"
+	AttemptErrorContextExceeded = "context_exceeded"
+	AttemptErrorPayloadTooLarge = "payload_too_large"
+	AttemptErrorQuotaExceeded   = "quota_exceeded"
+	AttemptErrorRateLimited     = "rate_limited"
+	AttemptErrorServer          = "server_error"
+	AttemptErrorClient          = "client_error"
+	AttemptErrorUnknown         = "unknown"
+)
+
+// AttemptInfo 一次请求上游的记录，用于排查主备切换、重试等情况下的请求过程
+//
+// 只包含渠道、错误分类和状态码，不包含请求内容、上游返回的错误详情和渠道密钥
+type AttemptInfo struct {
+	// ChannelID 使用的渠道，为 0 时表示使用配置文件配置的服务提供商
+	ChannelID    int64  `json:"channel_id,omitempty"`
+	ProviderType string `json:"provider_type"`
+	// Error 错误分类（参考 AttemptError* 常量），请求成功时为空
+	Error string `json:"error,omitempty"`
+	// StatusCode 上游返回的状态码，无法确定时为 0
+	StatusCode int `json:"status_code,omitempty"`
+	// LatencyMs 请求耗时（毫秒），流式输出为收到第一个响应（或错误响应）的耗时
+	LatencyMs int64 `json:"latency_ms"`
+}
+
+// classifyAttemptError 请求上游失败的错误分类，以及上游返回的状态码
+func classifyAttemptError(err error) (string, int) {
+	if err == nil {
+		return "", 0
+	}
+
+	var statusCode int
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		statusCode = upstreamErr.StatusCode
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return AttemptErrorCanceled, statusCode
+	case errors.Is(err, context.DeadlineExceeded):
+		return AttemptErrorTimeout, statusCode
+	case errors.Is(err, ErrContentFilter):
+		return AttemptErrorContentFilter, statusCode
+	case errors.Is(err, ErrContextExceedLimit):
+		return AttemptErrorContextExceeded, statusCode
+	case errors.Is(err, ErrPayloadTooLarge):
+		return AttemptErrorPayloadTooLarge, statusCode
+	case errors.Is(err, ErrQuotaExceeded):
+		return AttemptErrorQuotaExceeded, statusCode
+	case statusCode == http.StatusTooManyRequests:
+		return AttemptErrorRateLimited, statusCode
+	case statusCode >= 500:
+		return AttemptErrorServer, statusCode
+	case statusCode >= 400:
+		return AttemptErrorClient, statusCode
+	}
+
+	return AttemptErrorUnknown, statusCode
+}
+
+// attemptLog 一次对话请求中所有请求上游的记录，可以并发写入（多个候选回答）
+type attemptLog struct {
+	lock     sync.Mutex
+	attempts []AttemptInfo
+}
+
+func (l *attemptLog) add(attempt AttemptInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.attempts = append(l.attempts, attempt)
+}
+
+// list 返回目前为止的所有记录（副本）
+func (l *attemptLog) list() []AttemptInfo {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]AttemptInfo(nil), l.attempts...)
+}
+
+// attemptChat 记录每一次请求上游的渠道、结果和耗时，需要放在 retryChat 之内，才能记录每一次重试
+type attemptChat struct {
+	imp          Chat
+	log          *attemptLog
+	provider     repo.ModelProvider
+	providerType string
+}
+
+func (c *attemptChat) record(start time.Time, err error) {
+	class, statusCode := classifyAttemptError(err)
+	c.log.add(AttemptInfo{
+		ChannelID:    c.provider.ID,
+		ProviderType: c.providerType,
+		Error:        class,
+		StatusCode:   statusCode,
+		LatencyMs:    time.Since(start).Milliseconds(),
+	})
+}
+
+func (c *attemptChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	start := time.Now()
+	res, err := c.imp.Chat(ctx, req)
+	c.record(start, err)
+
+	return res, err
+}
+
+func (c *attemptChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	start := time.Now()
+	stream, err := c.imp.ChatStream(ctx, req)
+	if err != nil {
+		c.record(start, err)
+		return nil, err
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		recorded := false
+		defer func() {
+			// 没有收到任何响应时，按照请求被取消或者成功记录
+			if !recorded {
+				c.record(start, ctx.Err())
+			}
+		}()
+
+		for data := range stream {
+			if !recorded && !data.Interim {
+				recorded = true
+				if data.ErrorCode != "" {
+					c.record(start, streamError(data))
+				} else {
+					c.record(start, nil)
+				}
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	}), nil
+}
+
+func (c *attemptChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
+
+// logAttempts 请求失败时没有响应可以返回，调试模式下将请求上游的记录写入日志
+func logAttempts(req Request, err error) {
+	if req.attempts == nil {
+		return
+	}
+
+	log.F(log.M{"model": req.Model, "attempts": req.attempts.list()}).Warningf("chat request failed after attempts: %v", err)
+}
+
+// attachAttempts 在响应流的结束响应（包含结束原因或错误的响应）中附加请求上游的记录
+func attachAttempts(ctx context.Context, stream <-chan Response, attempts *attemptLog) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		for data := range stream {
+			if data.FinishReason != "" || data.ErrorCode != "" {
+				data.Attempts = attempts.list()
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/control"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestClassifyAttemptError(t *testing.T) {
+	testCases := []struct {
+		err        error
+		class      string
+		statusCode int
+	}{
+		{nil, "", 0},
+		{context.Canceled, AttemptErrorCanceled, 0},
+		{fmt.Errorf("request: %w", context.DeadlineExceeded), AttemptErrorTimeout, 0},
+		{ErrContentFilter, AttemptErrorContentFilter, 0},
+		{ErrContextExceedLimit, AttemptErrorContextExceeded, 0},
+		{ErrPayloadTooLarge, AttemptErrorPayloadTooLarge, 0},
+		{NewUpstreamError("openai", http.StatusTooManyRequests, "", "", "rate limited", nil), AttemptErrorRateLimited, http.StatusTooManyRequests},
+		{NewUpstreamError("openai", http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", "You exceeded your current quota", nil), AttemptErrorQuotaExceeded, http.StatusTooManyRequests},
+		{NewUpstreamError("openai", http.StatusBadGateway, "", "", "bad gateway", nil), AttemptErrorServer, http.StatusBadGateway},
+		{NewUpstreamError("openai", http.StatusUnauthorized, "", "", "invalid api key sk-xxx", nil), AttemptErrorClient, http.StatusUnauthorized},
+		{errors.New("connection reset"), AttemptErrorUnknown, 0},
+	}
+
+	for _, tc := range testCases {
+		class, statusCode := classifyAttemptError(tc.err)
+		assert.Equal(t, tc.class, class)
+		assert.Equal(t, tc.statusCode, statusCode)
+	}
+}
+
+func TestDispatcher_Attempts(t *testing.T) {
+	patterns, err := ParseRetryPatterns([]string{"openai:*server is busy*"})
+	assert.NoError(t, err)
+
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 3}}}}
+	newDispatcher := func(client Chat) *Dispatcher {
+		d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+		d.retryPatterns = patterns
+		d.retryDelay = 0
+		return d
+	}
+
+	req := Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+	debugCtx := control.NewContext(context.TODO(), &control.Control{Debug: true})
+
+	// 第一次请求失败后重试成功，记录每一次请求的结果
+	client := &flakyChatClient{errs: []error{NewUpstreamError("openai", http.StatusServiceUnavailable, "", "", "server is busy, secret sk-xxx", nil)}}
+	res, err := newDispatcher(client).Chat(debugCtx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.Attempts))
+	assert.EqualValues(t, 3, res.Attempts[0].ChannelID)
+	assert.Equal(t, service.ProviderOpenAI, res.Attempts[0].ProviderType)
+	assert.Equal(t, AttemptErrorServer, res.Attempts[0].Error)
+	assert.Equal(t, http.StatusServiceUnavailable, res.Attempts[0].StatusCode)
+	assert.Equal(t, "", res.Attempts[1].Error)
+
+	// 非调试模式下不返回
+	client = &flakyChatClient{errs: []error{errors.New("server is busy")}}
+	res, err = newDispatcher(client).Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(res.Attempts))
+
+	// 流式输出在输出结束后返回
+	stream := &scriptedStreamClient{scripts: [][]Response{
+		{{ErrorCode: ErrCodeUpstream, Error: "server is busy", Upstream: NewUpstreamError("openai", http.StatusTooManyRequests, "", "", "server is busy", nil)}},
+		{{Text: "ok"}, {FinishReason: FinishReasonStop}},
+	}}
+	ch, err := newDispatcher(stream).ChatStream(debugCtx, req)
+	assert.NoError(t, err)
+
+	var attempts []AttemptInfo
+	var text string
+	for data := range ch {
+		text += data.Text
+		if data.Attempts != nil {
+			attempts = data.Attempts
+		}
+	}
+
+	assert.Equal(t, "ok", text)
+	assert.Equal(t, 2, len(attempts))
+	assert.Equal(t, AttemptErrorRateLimited, attempts[0].Error)
+	assert.Equal(t, "", attempts[1].Error)
+}
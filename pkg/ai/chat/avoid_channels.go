@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 避开用户不满意的渠道的结果
+const (
+	// avoidanceAvoided 有其它健康的渠道，避开了用户不满意的渠道
+	avoidanceAvoided = "avoided"
+	// avoidanceOnlyChoice 没有其它健康的渠道，仍然按照原有的规则选择
+	avoidanceOnlyChoice = "only_choice"
+)
+
+// newChannelAvoidanceCounter 创建避开用户不满意的渠道的次数统计，并注册到 registerer
+func newChannelAvoidanceCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	return registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_channel_avoidance_count",
+		Help:      "provider selections of requests asking to avoid channels of disliked answers, by outcome",
+	}, []string{"model", "outcome"}))
+}
+
+// avoidChannels 请求指定了需要避开的渠道时（Request.AvoidChannels），只从其它健康的渠道中选择，
+// 没有其它健康的渠道时保持不变，避开渠道只是软性偏好，不会导致请求失败
+func (d *Dispatcher) avoidChannels(mod repo.Model, req Request) repo.Model {
+	if len(req.AvoidChannels) == 0 || len(mod.Providers) == 0 {
+		return mod
+	}
+
+	avoid := make(map[int64]bool, len(req.AvoidChannels))
+	for _, id := range req.AvoidChannels {
+		avoid[id] = true
+	}
+
+	avoided := func(item repo.ModelProvider, _ int) bool { return item.ID > 0 && avoid[item.ID] }
+	if len(array.Filter(mod.Providers, avoided)) == 0 {
+		return mod
+	}
+
+	outcome := avoidanceOnlyChoice
+	others := array.Filter(mod.Providers, func(item repo.ModelProvider, i int) bool {
+		return !avoided(item, i) && (d.health == nil || d.health.Healthy(item))
+	})
+	if len(others) > 0 {
+		mod.Providers = others
+		outcome = avoidanceAvoided
+	}
+
+	if d.channelAvoidance != nil {
+		d.channelAvoidance.WithLabelValues(mod.ModelId, outcome).Inc()
+	}
+
+	return mod
+}
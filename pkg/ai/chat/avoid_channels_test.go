@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDispatcher_AvoidChannels(t *testing.T) {
+	d := NewDispatcher(fakeModelRouter{}, nil, "", PayloadPolicyReject)
+	d.health = NewHealthTracker(1, time.Minute)
+	d.channelAvoidance = newChannelAvoidanceCounter(prometheus.NewRegistry())
+
+	mod := repo.Model{Providers: []repo.ModelProvider{{ID: 1}, {ID: 2}, {ID: 3}}}
+	mod.ModelId = "gpt-4o"
+	req := Request{Messages: Messages{{Role: RoleUser, Content: "hi"}}, AvoidChannels: []int64{1}}
+
+	// 有其它健康的渠道时避开
+	pro, _, err := d.selectProvider(context.TODO(), mod, req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pro.ID)
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.channelAvoidance.WithLabelValues("gpt-4o", avoidanceAvoided)))
+
+	// 其它渠道不健康时，只能使用需要避开的渠道
+	d.health.Report(repo.ModelProvider{ID: 2}, errors.New("upstream unavailable"))
+	d.health.Report(repo.ModelProvider{ID: 3}, errors.New("upstream unavailable"))
+	pro, _, err = d.selectProvider(context.TODO(), mod, req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pro.ID)
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.channelAvoidance.WithLabelValues("gpt-4o", avoidanceOnlyChoice)))
+
+	// 需要避开的渠道不在模型的渠道中时不统计
+	req.AvoidChannels = []int64{9}
+	pro, _, err = d.selectProvider(context.TODO(), mod, req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pro.ID)
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.channelAvoidance.WithLabelValues("gpt-4o", avoidanceOnlyChoice)))
+}
+
+func TestDispatcher_AvoidChannelsVision(t *testing.T) {
+	d := NewDispatcher(fakeModelRouter{}, nil, "", PayloadPolicyReject)
+	d.health = NewHealthTracker(1, time.Minute)
+	d.channelAvoidance = newChannelAvoidanceCounter(prometheus.NewRegistry())
+
+	mod := repo.Model{Providers: []repo.ModelProvider{{ID: 1}, {ID: 2, TextOnly: true}}, Meta: repo.ModelMeta{Vision: true}}
+	mod.ModelId = "gpt-4o"
+	req := Request{Messages: Messages{imageMessage("https://example.com/a.png")}, AvoidChannels: []int64{1}}
+
+	// 需要避开的渠道是唯一支持图片的渠道时，不会因为纯文本的渠道可用而放弃图片或者请求失败
+	pro, degraded, err := d.selectProvider(context.TODO(), mod, req)
+	assert.NoError(t, err)
+	assert.False(t, degraded)
+	assert.Equal(t, int64(1), pro.ID)
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.channelAvoidance.WithLabelValues("gpt-4o", avoidanceOnlyChoice)))
+
+	// 有其它支持图片的渠道时避开
+	mod.Providers = append(mod.Providers, repo.ModelProvider{ID: 3})
+	pro, degraded, err = d.selectProvider(context.TODO(), mod, req)
+	assert.NoError(t, err)
+	assert.False(t, degraded)
+	assert.Equal(t, int64(3), pro.ID)
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.channelAvoidance.WithLabelValues("gpt-4o", avoidanceAvoided)))
+
+	// 纯文本请求可以使用纯文本的渠道
+	req.Messages = Messages{{Role: RoleUser, Content: "hi"}}
+	pro, _, err = d.selectProvider(context.TODO(), mod, req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pro.ID)
+}
@@ -16,11 +16,11 @@ func NewBaichuanAIChat(ai *baichuan.BaichuanAI) *BaichuanAIChat {
 }
 
 func (ai *BaichuanAIChat) initRequest(req Request) baichuan.Request {
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	messages := array.Map(req.Messages, func(item Message, _ int) baichuan.Message {
 		return baichuan.Message{
-			Role:    item.Role,
+			Role:    string(item.Role),
 			Content: item.Content,
 		}
 	})
@@ -54,7 +54,7 @@ func (ai *BaichuanAIChat) Chat(ctx context.Context, req Request) (*Response, err
 
 	return &Response{
 		Text:         content,
-		FinishReason: finishReason,
+		FinishReason: NormalizeFinishReason(finishReason),
 		InputTokens:  resp.Usage.PromptTokens,
 		OutputTokens: resp.Usage.AnswerTokens,
 	}, nil
@@ -100,7 +100,7 @@ func (ai *BaichuanAIChat) ChatStream(ctx context.Context, req Request) (<-chan R
 					return
 				case res <- Response{
 					Text:         content,
-					FinishReason: finishReason,
+					FinishReason: NormalizeFinishReason(finishReason),
 					InputTokens:  data.Usage.PromptTokens,
 					OutputTokens: data.Usage.AnswerTokens,
 				}:
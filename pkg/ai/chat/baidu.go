@@ -23,7 +23,7 @@ func (chat *BaiduAIChat) initRequest(req Request) baidu.ChatRequest {
 
 	for _, msg := range req.Messages {
 		m := baidu.ChatMessage{
-			Role:    msg.Role,
+			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
 
@@ -36,6 +36,7 @@ func (chat *BaiduAIChat) initRequest(req Request) baidu.ChatRequest {
 
 	res := baidu.ChatRequest{}
 
+	// 文心千帆要求上下文为奇数条并以用户消息结尾，否则请求失败，因此不支持 NoAutoContinue，始终补充“继续”
 	contextMessages = contextMessages.Fix()
 	if len(systemMessages) > 0 {
 		systemMessage := systemMessages[0]
@@ -53,7 +54,7 @@ func (chat *BaiduAIChat) initRequest(req Request) baidu.ChatRequest {
 				systemMessage,
 				baidu.ChatMessage{
 					Role:    "assistant",
-					Content: "好的",
+					Content: systemFoldAck,
 				},
 			)
 
@@ -75,11 +76,31 @@ func (chat *BaiduAIChat) Chat(ctx context.Context, req Request) (*Response, erro
 		return nil, fmt.Errorf("baidu ai chat error: [%d] %s", res.ErrorCode, res.ErrorMessage)
 	}
 
-	return &Response{
+	ret := Response{
 		Text:         res.Result,
 		InputTokens:  res.Usage.PromptTokens,
 		OutputTokens: res.Usage.CompletionTokens,
-	}, nil
+		FinishReason: baiduFinishReason(res, true),
+	}
+	if filterErr := baiduContentFilterError(res); filterErr != nil {
+		ret = filterErr.Attach(ret)
+	}
+
+	return &ret, nil
+}
+
+// baiduFinishReason 文心千帆没有返回结束原因，根据是否截断、是否存在安全风险判断，end 表示是否为最后一个响应
+func baiduFinishReason(res *baidu.ChatResponse, end bool) string {
+	switch {
+	case res.NeedClearHistory:
+		return FinishReasonContentFilter
+	case res.IsTruncated:
+		return FinishReasonLength
+	case end:
+		return FinishReasonStop
+	}
+
+	return ""
 }
 
 func (chat *BaiduAIChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
@@ -112,14 +133,20 @@ func (chat *BaiduAIChat) ChatStream(ctx context.Context, req Request) (<-chan Re
 					return
 				}
 
-				select {
-				case <-ctx.Done():
-					return
-				case res <- Response{
+				ret := Response{
 					Text:         data.Result,
 					InputTokens:  data.Usage.PromptTokens,
 					OutputTokens: data.Usage.TotalTokens - data.Usage.PromptTokens,
-				}:
+					FinishReason: baiduFinishReason(&data, data.IsEND),
+				}
+				if filterErr := baiduContentFilterError(&data); filterErr != nil {
+					ret = filterErr.Attach(ret)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case res <- ret:
 				}
 			}
 		}
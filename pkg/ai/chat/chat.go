@@ -4,33 +4,147 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/mylxsw/aidea-server/internal/coins"
 	"github.com/mylxsw/aidea-server/pkg/ai/google"
-	"github.com/mylxsw/aidea-server/pkg/ai/oneapi"
-	"github.com/mylxsw/aidea-server/pkg/ai/openai"
-	"github.com/mylxsw/aidea-server/pkg/ai/openrouter"
 	"github.com/mylxsw/aidea-server/pkg/misc"
-	"github.com/mylxsw/aidea-server/pkg/proxy"
 	"github.com/mylxsw/aidea-server/pkg/repo"
-	"github.com/mylxsw/aidea-server/pkg/repo/model"
-	"github.com/mylxsw/aidea-server/pkg/service"
-	"github.com/mylxsw/aidea-server/pkg/youdao"
 	"github.com/mylxsw/asteria/log"
-	"github.com/mylxsw/glacier/infra"
 	"strings"
+	"time"
 
 	"github.com/mylxsw/aidea-server/config"
 	"github.com/mylxsw/go-utils/array"
 )
 
 var (
+	// ErrContextExceedLimit 上下文长度超过最大限制，可以使用 errors.Is 判断 ContextExceedError
 	ErrContextExceedLimit = errors.New("上下文长度超过最大限制")
 	ErrContentFilter      = errors.New("请求或响应内容包含敏感词")
+	// ErrDefaultModelNotFound 配置的默认聊天模型不存在
+	ErrDefaultModelNotFound = errors.New("默认聊天模型不存在")
+	// ErrEmptyMessages 请求中没有有效的消息
+	ErrEmptyMessages = errors.New("请求中没有有效的消息内容")
+	// ErrTooManyMessages 请求中的消息数量超过最大限制
+	ErrTooManyMessages = errors.New("消息数量超过最大限制")
 )
 
+// DefaultMaxMessages 单次请求允许的最大消息数量（未配置时使用）
+const DefaultMaxMessages = 1000
+
+// maxTokensSafetyMargin 计算剩余上下文长度时保留的 Token 数量，Token 数量是估算的，与服务提供商的计算结果可能存在误差
+const maxTokensSafetyMargin = 64
+
+// MaxTokensAdjustment 剩余的上下文长度不足以容纳请求的最大输出 Token 数量时，自动调低后的记录，
+// 客户端可以据此向用户解释回复较短的原因
+type MaxTokensAdjustment struct {
+	// Requested 请求的最大输出 Token 数量
+	Requested int `json:"requested"`
+	// Adjusted 调整后的最大输出 Token 数量
+	Adjusted int `json:"adjusted"`
+}
+
+// ContextExceedError 上下文长度超过最大限制，包含具体的 Token 数量，方便客户端提示用户需要缩减多少内容
+type ContextExceedError struct {
+	// MaxContext 本次请求允许的最大 Token 数量
+	MaxContext int `json:"max_context"`
+	// InputTokens 无法缩减的输入内容的 Token 数量
+	InputTokens int `json:"input_tokens"`
+	// Overflow 超出的 Token 数量
+	Overflow int `json:"overflow"`
+}
+
+func (e *ContextExceedError) Error() string {
+	return fmt.Sprintf("超过模型最大允许的上下文长度限制（输入 %d Tokens，超出 %d Tokens），请尝试“新对话”或缩短输入内容长度", e.InputTokens, e.Overflow)
+}
+
+// Is 兼容 errors.Is(err, ErrContextExceedLimit)
+func (e *ContextExceedError) Is(target error) bool {
+	return target == ErrContextExceedLimit
+}
+
+// ErrorData 返回给客户端的结构化错误详情
+func (e *ContextExceedError) ErrorData() any {
+	return e
+}
+
+// OutputReserveError 缩减上下文之后，剩余的上下文长度仍然无法为输出内容预留 MinOutputTokens 个 Token（输入内容本身没有超过上下文长度），
+// 客户端可以缩短输入内容或者调低 min_output_tokens 后重试
+type OutputReserveError struct {
+	// MaxContext 模型的最大上下文长度
+	MaxContext int `json:"max_context"`
+	// InputTokens 无法缩减的输入内容的 Token 数量
+	InputTokens int `json:"input_tokens"`
+	// MinOutputTokens 需要为输出内容预留的 Token 数量
+	MinOutputTokens int `json:"min_output_tokens"`
+	// Overflow 超出的 Token 数量
+	Overflow int `json:"overflow"`
+}
+
+func (e *OutputReserveError) Error() string {
+	return fmt.Sprintf("剩余的上下文长度不足以生成至少 %d Tokens 的回复（输入 %d Tokens，超出 %d Tokens），请缩短输入内容长度", e.MinOutputTokens, e.InputTokens, e.Overflow)
+}
+
+// Is 兼容 errors.Is(err, ErrContextExceedLimit)
+func (e *OutputReserveError) Is(target error) bool {
+	return target == ErrContextExceedLimit
+}
+
+// ErrorData 返回给客户端的结构化错误详情
+func (e *OutputReserveError) ErrorData() any {
+	return e
+}
+
 type Message struct {
-	Role              string              `json:"role"`
+	Role              Role                `json:"role"`
 	Content           string              `json:"content"`
 	MultipartContents []*MultipartContent `json:"multipart_content,omitempty"`
+	// ToolCalls 助手消息中模型发起的工具调用（上一轮响应中的 Response.ToolCalls）
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID 工具调用结果消息（role 为 tool）对应的工具调用 ID
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Pinned 房间中固定的消息（由服务端根据聊天记录设置，参考 Messages.WithPinned），缩减上下文时与 system 消息一样始终保留
+	Pinned bool `json:"-"`
+}
+
+// Text 消息的文本内容，Content 为空时，使用多模态内容中的文本
+func (m Message) Text() string {
+	if strings.TrimSpace(m.Content) != "" {
+		return m.Content
+	}
+
+	texts := make([]string, 0, len(m.MultipartContents))
+	for _, part := range m.MultipartContents {
+		if part != nil && strings.TrimSpace(part.Text) != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// IsEmpty 消息内容是否为空，对于只包含图片等多模态内容的消息，Content 为空，但不应被视为空消息
+// system 消息只支持文本内容，没有文本时视为空消息
+func (m Message) IsEmpty() bool {
+	if strings.TrimSpace(m.Content) != "" {
+		return false
+	}
+
+	// 只包含工具调用的助手消息，以及内容为空的工具调用结果，都是有效的消息
+	if len(m.ToolCalls) > 0 || (m.Role == RoleTool && m.ToolCallID != "") {
+		return false
+	}
+
+	if m.Role == RoleSystem {
+		return strings.TrimSpace(m.Text()) == ""
+	}
+
+	for _, part := range m.MultipartContents {
+		if !part.IsEmpty() {
+			return false
+		}
+	}
+
+	return true
 }
 
 type MultipartContent struct {
@@ -40,6 +154,19 @@ type MultipartContent struct {
 	Text     string    `json:"text,omitempty"`
 }
 
+// IsEmpty 多模态内容是否为空：图片没有 URL，或者文本内容为空白
+func (mc *MultipartContent) IsEmpty() bool {
+	if mc == nil {
+		return true
+	}
+
+	if mc.ImageURL != nil && strings.TrimSpace(mc.ImageURL.URL) != "" {
+		return false
+	}
+
+	return strings.TrimSpace(mc.Text) == ""
+}
+
 type ImageURL struct {
 	// URL Either a URL of the image or the base64 encoded image data.
 	URL string `json:"url,omitempty"`
@@ -54,7 +181,24 @@ type ImageURL struct {
 	// - `high` will enable “high res” mode, which first allows the model to see the low res image and
 	//   then creates detailed crops of input images as 512px squares based on the input image size.
 	//   Each of the detailed crops uses twice the token budget (65 tokens) for a total of 129 tokens.
+	//
+	// Detail 是 OpenAI 特有的参数：OpenAI 系列的服务提供商未指定时使用 low，Qwen-VL 转换为高分辨率参数，
+	// 其它服务提供商（Gemini、Claude、GLM-4V 等）忽略该参数
 	Detail string `json:"detail,omitempty"`
+	// Caption 图片说明，只用于助手回复中生成的图片（参考 Response.Parts），不会发送给服务提供商
+	Caption string `json:"caption,omitempty"`
+}
+
+// defaultOpenAIImageDetail OpenAI 系列的服务提供商未指定图片识别精度时使用的默认值
+const defaultOpenAIImageDetail = "low"
+
+// openAIImageDetail 转换为 OpenAI 请求中的图片识别精度，未指定时使用 low
+func openAIImageDetail(detail string) string {
+	if detail == "" {
+		return defaultOpenAIImageDetail
+	}
+
+	return detail
 }
 
 type Messages []Message
@@ -63,8 +207,14 @@ func (ms Messages) ToLogEntry() Messages {
 	ret := make(Messages, len(ms))
 	for i, msg := range ms {
 		mm := Message{
-			Role:    msg.Role,
-			Content: misc.SubString(msg.Content, 20),
+			Role:       msg.Role,
+			Content:    misc.SubString(msg.Content, 20),
+			ToolCallID: msg.ToolCallID,
+		}
+
+		for _, call := range msg.ToolCalls {
+			call.Function.Arguments = misc.SubString(call.Function.Arguments, 20)
+			mm.ToolCalls = append(mm.ToolCalls, call)
 		}
 
 		if msg.MultipartContents != nil {
@@ -77,8 +227,9 @@ func (ms Messages) ToLogEntry() Messages {
 
 				if part.ImageURL != nil {
 					mm.MultipartContents[j].ImageURL = &ImageURL{
-						URL:    misc.SubString(part.ImageURL.URL, 20),
-						Detail: part.ImageURL.Detail,
+						URL:     misc.SubString(part.ImageURL.URL, 20),
+						Detail:  part.ImageURL.Detail,
+						Caption: misc.SubString(part.ImageURL.Caption, 20),
 					}
 				}
 			}
@@ -106,41 +257,139 @@ func (ms Messages) HasImage() bool {
 // 1. 强制上下文为 user/assistant 轮流出现
 // 2. 第一个普通消息必须是用户消息
 // 3. 最后一条消息必须是用户消息
+//
+// 工具调用结果（role 为 tool）作为用户一方，同一轮中的多条工具调用结果全部保留，并且保持与发起工具调用的助手消息相邻；
+// 工具调用结果被丢弃时，助手消息中对应的工具调用也会被移除。返回新的消息列表，不修改原始消息
 func (ms Messages) Fix() Messages {
+	return ms.fix(true)
+}
+
+// FixWithoutContinue 与 Fix 相同，但是最后一条消息不是用户消息时不补充“继续”，保留以助手消息结尾的上下文
+func (ms Messages) FixWithoutContinue() Messages {
+	return ms.fix(false)
+}
+
+func (ms Messages) fix(autoContinue bool) Messages {
 	msgs := ms
-	// 如果最后一条消息不是用户消息，则补充一条用户消息
+	if len(msgs) == 0 {
+		return msgs
+	}
+
+	// 如果最后一条消息不是用户消息（或工具调用结果），则补充一条用户消息
 	last := msgs[len(msgs)-1]
-	if last.Role != "user" {
+	if autoContinue && fixTurn(last.Role) != RoleUser {
 		last = Message{
-			Role:    "user",
+			Role:    RoleUser,
 			Content: "继续",
 		}
-		msgs = append(msgs, last)
+		// 限制容量，避免 append 写入调用方切片的底层数组
+		msgs = append(msgs[:len(msgs):len(msgs)], last)
 	}
 
 	// 过滤掉 system 消息，因为 system 消息需要在每次对话中保留，不受上下文长度限制
-	systemMsgs := array.Filter(msgs, func(m Message, _ int) bool { return m.Role == "system" })
+	systemMsgs := array.Filter(msgs, func(m Message, _ int) bool { return m.Role == RoleSystem })
 	if len(systemMsgs) > 0 {
-		msgs = array.Filter(msgs, func(m Message, _ int) bool { return m.Role != "system" })
+		msgs = array.Filter(msgs, func(m Message, _ int) bool { return m.Role != RoleSystem })
+	}
+
+	// 从后往前按轮次分组，连续相同一方的消息只保留最后一轮
+	turns := make([]Messages, 0)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		m := msgs[i]
+		if len(turns) > 0 {
+			next := turns[len(turns)-1]
+			if fixTurn(m.Role) == fixTurn(next[0].Role) {
+				if m.Role == RoleTool && next[0].Role == RoleTool {
+					turns[len(turns)-1] = append(Messages{m}, next...)
+				}
+				continue
+			}
+		}
+
+		if len(m.ToolCalls) > 0 && (len(turns) == 0 || turns[len(turns)-1][0].Role != RoleTool) {
+			m.ToolCalls = nil
+		}
+
+		turns = append(turns, Messages{m})
 	}
 
-	finalMessages := make([]Message, 0)
-	var lastRole string
+	// 第一轮必须是用户消息，工具调用结果不能脱离发起工具调用的助手消息单独出现
+	for len(turns) > 0 && turns[len(turns)-1][0].Role != RoleUser {
+		turns = turns[:len(turns)-1]
+	}
 
-	for _, m := range array.Reverse(msgs) {
-		if m.Role == lastRole {
+	finalMessages := systemMsgs
+	for i := len(turns) - 1; i >= 0; i-- {
+		finalMessages = append(finalMessages, turns[i]...)
+	}
+
+	return finalMessages
+}
+
+// fixMessages 按照请求的设置修正上下文（参考 Messages.Fix），指定了 NoAutoContinue 时不补充“继续”
+func (req Request) fixMessages(ms Messages) Messages {
+	if req.NoAutoContinue {
+		return ms.FixWithoutContinue()
+	}
+
+	return ms.Fix()
+}
+
+// fixTurn 消息在 user/assistant 轮流出现时所属的一方，工具调用结果属于用户一方
+func fixTurn(role Role) Role {
+	if role == RoleTool {
+		return RoleUser
+	}
+
+	return role
+}
+
+// MergeUserMessages 将相邻的多条用户消息合并为一条（文本使用换行连接，多模态内容依次合并）
+//
+// 用户连续发送多条消息时，Fix 会去除连续相同角色的消息，只保留最后一条，合并后可以避免丢失较早发送的消息
+func (ms Messages) MergeUserMessages() Messages {
+	ret := make(Messages, 0, len(ms))
+	for _, m := range ms {
+		if m.Role == RoleUser && len(ret) > 0 && ret[len(ret)-1].Role == RoleUser {
+			ret[len(ret)-1] = mergeUserMessage(ret[len(ret)-1], m)
 			continue
 		}
 
-		lastRole = m.Role
-		finalMessages = append(finalMessages, m)
+		ret = append(ret, m)
 	}
 
-	if len(finalMessages)%2 == 0 {
-		finalMessages = finalMessages[:len(finalMessages)-1]
+	return ret
+}
+
+func mergeUserMessage(prev, next Message) Message {
+	ret := Message{Role: RoleUser}
+
+	texts := make([]string, 0, 2)
+	for _, text := range []string{prev.Text(), next.Text()} {
+		if strings.TrimSpace(text) != "" {
+			texts = append(texts, text)
+		}
+	}
+	ret.Content = strings.Join(texts, "\n")
+
+	if len(prev.MultipartContents) > 0 || len(next.MultipartContents) > 0 {
+		ret.MultipartContents = append(userMessageParts(prev), userMessageParts(next)...)
+	}
+
+	return ret
+}
+
+// userMessageParts 将消息转换为多模态内容，纯文本消息转换为一条 text 类型的内容
+func userMessageParts(m Message) []*MultipartContent {
+	if len(m.MultipartContents) > 0 {
+		return array.Filter(m.MultipartContents, func(part *MultipartContent, _ int) bool { return part != nil })
+	}
+
+	if strings.TrimSpace(m.Content) == "" {
+		return nil
 	}
 
-	return append(systemMsgs, array.Reverse(finalMessages)...)
+	return []*MultipartContent{{Type: "text", Text: m.Content}}
 }
 
 // Request represents a request structure for chat completion API.
@@ -151,12 +400,108 @@ type Request struct {
 	MaxTokens int      `json:"max_tokens,omitempty"`
 	N         int      `json:"n,omitempty"` // 复用作为 room_id
 
+	// MinOutputTokens 为输出内容预留的最小 Token 数量（不超过 MaxTokens），Fix 缩减上下文时会预留该长度，
+	// 缩减上下文之后仍然无法预留时返回 OutputReserveError，为 0 时不预留。服务端配置的 chat-min-output-tokens 更大时使用配置的值
+	MinOutputTokens int `json:"min_output_tokens,omitempty"`
+
+	// Choices 需要生成的候选回复数量，大于 1 时以多个并行请求生成（最多 MaxChoices 个），
+	// 由于 N 目前复用作为 room_id，暂时只能在服务端内部指定
+	Choices int `json:"-"`
+
 	// 业务定制字段
 	RoomID    int64 `json:"-"`
 	WebSocket bool  `json:"-"`
+	// UserID 发起请求的用户 ID，用于检查用户自定义模型的使用权限，匿名用户为 0
+	UserID int64 `json:"-"`
+	// AvoidChannels 需要尽量避开的渠道 ID（用户点踩或者重新生成的回答使用的渠道），有其它健康的渠道时不使用这些渠道
+	AvoidChannels []int64 `json:"-"`
+	// Priority 请求的优先级（由用户类型决定，参考 Priorities），并发请求数量达到上限时优先级高的请求先处理
+	Priority int `json:"-"`
 
 	// TempModel 用户可以指定临时模型来进行当前对话，实现临时切换模型的功能
 	TempModel string `json:"temp_model,omitempty"`
+	// PersonaPrompt 角色提示语（如首页模型的设定），设置后会替代请求中的 system 消息
+	PersonaPrompt string `json:"-"`
+	// RawMode 原始模式，不注入模型、服务提供商、角色、输出格式和回复语言等系统提示语，只发送用户自己的消息，
+	// 用于评测和调试模型本身的行为，只有内部用户可以使用，计费和限制不受影响
+	RawMode bool `json:"raw_mode,omitempty"`
+	// NoAutoContinue 不自动补充和改写“继续”消息：最后一条消息不是用户消息时不补充“继续”，也不将“继续”改写为“请接着说”，
+	// 由调用方完全控制对话的轮次结构（如 Agent 中有意以助手消息结尾的上下文）。
+	// 腾讯混元、文心千帆的接口要求最后一条消息是用户消息，这两个服务提供商不支持，仍然补充“继续”
+	NoAutoContinue bool `json:"no_auto_continue,omitempty"`
+
+	// Tools 可供模型调用的工具列表
+	Tools []Tool `json:"tools,omitempty"`
+	// StreamToolCalls 流式输出时，是否实时返回工具调用的中间状态（工具名称、参数片段）
+	StreamToolCalls bool `json:"stream_tool_calls,omitempty"`
+
+	// Temperature/TopP 采样参数，为 nil 时使用默认值（参考 ApplyDefaults），仍未指定时使用服务提供商的默认值
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	// Stop 自定义的停止序列，生成的内容中出现任意一个时停止生成，触发的停止序列通过 Response.StoppedBy 返回
+	Stop []string `json:"stop,omitempty"`
+	// Seed 随机数种子，与 temperature 为 0 同时使用时要求可复现的输出（参考 WantsReproducible）
+	Seed *int `json:"seed,omitempty"`
+	// SessionID 多轮对话的会话 ID，指定后未指定 seed 时使用根据会话生成的固定种子，并记录每轮对话的 system_fingerprint（参考 SessionFingerprints）
+	SessionID string `json:"session_id,omitempty"`
+	// ImageDetail 请求中未指定识别精度的图片默认使用的识别精度：low/high/auto，优先级高于房间、模型和部署配置的默认值，
+	// 图片中明确指定的识别精度仍然优先（如 OCR 等对图片质量要求较高的场景可以指定为 high）
+	ImageDetail string `json:"image_detail,omitempty"`
+	// TaskProfile 根据任务类型调整采样参数时使用的配置名称（参考 TaskProfiles.Select），在响应中返回
+	TaskProfile string `json:"-"`
+	// ReplyLanguage 回复使用的语言，只能通过默认值（RequestDefaults）指定
+	ReplyLanguage string `json:"-"`
+	// EnforceReplyLanguage 是否检查流式输出的语言，与 ReplyLanguage 不一致时重试一次（参考 replyLanguageChat）
+	EnforceReplyLanguage bool `json:"enforce_reply_language,omitempty"`
+	// OutputStyle 输出内容的格式：markdown/plain，为 plain 时添加要求纯文本输出的系统提示语
+	OutputStyle OutputStyle `json:"output_style,omitempty"`
+	// StripMarkdown 输出格式为 plain 时，是否去掉输出内容中残留的 Markdown 标记（流式输出时按行输出）
+	StripMarkdown bool `json:"strip_markdown,omitempty"`
+	// OutputChecksum 是否计算输出内容的 SHA-256 摘要（Response.OutputSHA256），用于校验输出内容与审计日志是否一致
+	OutputChecksum bool `json:"output_checksum,omitempty"`
+	// ExtraBody 合并到请求体中的额外参数，只对 OpenAI 兼容的服务提供商生效，同名参数优先于渠道配置（repo.ChannelMeta.ExtraBody），
+	// 不能覆盖请求体中已有的参数，只有内部用户和 API 调用方可以指定（参考 ValidateExtraBody）
+	ExtraBody map[string]any `json:"extra_body,omitempty"`
+	// ReasoningBudget 思考（推理）预算，由各个服务提供商转换为自己的参数，不支持的服务提供商忽略
+	ReasoningBudget ReasoningBudget `json:"reasoning_budget,omitempty"`
+
+	// Sources 上下文中提供的参考资料，只用于匹配输出内容中引用的段落，不会发送给上游
+	Sources []Source `json:"sources,omitempty"`
+	// ReturnUsedSources 是否在响应中返回输出内容引用的参考资料段落（Response.UsedSources）
+	ReturnUsedSources bool `json:"return_used_sources,omitempty"`
+
+	// PromptTemplateID 服务端保存的提示语模板 ID，分发之前展开为系统提示语和用户消息（参考 ExpandPromptTemplate）
+	PromptTemplateID string `json:"prompt_template_id,omitempty"`
+	// PromptVariables 提示语模板中引用的变量
+	PromptVariables map[string]string `json:"prompt_variables,omitempty"`
+
+	// MaxImages 整个对话中图片的最大数量（房间配置），Fix 时从最早的消息开始去掉超出的图片，为 0 时不限制
+	MaxImages int `json:"-"`
+	// OutputTokenLimit 流式输出的 Token 数量绝对上限（房间配置），与全局配置（chat-max-output-tokens）取较小的值，为 0 时不限制
+	OutputTokenLimit int `json:"-"`
+	// ImageTool 服务端执行的图片生成工具（generate_image）的配置，由 ServerToolChat 处理，为 nil 时不提供该工具
+	ImageTool *ImageToolOptions `json:"-"`
+	// WebSearch 服务端执行的网页搜索工具（web_search）的配置，由 ServerToolChat 处理，为 nil 时不提供该工具
+	WebSearch *WebSearchOptions `json:"-"`
+	// ToolLoopBudget 服务端工具调用循环的耗时预算，预算即将耗尽时不再调用工具，要求模型根据已有的信息直接回答，为 0 时不限制
+	ToolLoopBudget time.Duration `json:"-"`
+	// MaxTokensAdjustment 剩余的上下文长度不足时，Fix 自动调低 MaxTokens 的调整记录，未调整时为 nil
+	MaxTokensAdjustment *MaxTokensAdjustment `json:"-"`
+	// InputTokenBreakdown Fix 之后输入 Token 数量按照消息角色的分布，无法计算时为 nil
+	InputTokenBreakdown *InputTokenBreakdown `json:"-"`
+
+	// reproducible 请求要求可复现的输出时，模型是否支持，由 Dispatcher 设置
+	reproducible *bool
+	// warnings 请求降级处理时返回给用户的警告信息，由 Dispatcher 设置
+	warnings []string
+	// attempts 请求上游的记录，只在调试模式下由 Dispatcher 设置
+	attempts *attemptLog
+	// channel 本次请求使用的服务提供商，由 Dispatcher 设置
+	channel repo.ModelProvider
+	// failedChannels 故障转移时需要排除的已经请求失败的服务提供商（参考 providerKey），由 Dispatcher 设置
+	failedChannels map[string]bool
+	// multipartFlattened 多模态消息是否已经转换为纯文本（参考 flattenMultipart），由 Dispatcher 设置
+	multipartFlattened bool
 }
 
 func (req Request) assembleMessage() string {
@@ -184,8 +529,11 @@ func (req Request) Init() Request {
 		req.N = 0
 	}
 
+	// 规范化消息内容的编码（无效字符、空字符、BOM 等），之后再过滤空消息
+	req.Messages = normalizeMessages(req.Messages)
+
 	// 过滤掉内容为空的 message
-	req.Messages = array.Filter(req.Messages, func(item Message, _ int) bool { return strings.TrimSpace(item.Content) != "" })
+	req.Messages = array.Filter(req.Messages, func(item Message, _ int) bool { return !item.IsEmpty() })
 
 	// TODO 临时方案，对于 Google Gemini Pro Vision 模型，有以下特性:
 	// 1. 不支持多轮对话
@@ -198,281 +546,290 @@ func (req Request) Init() Request {
 	return req
 }
 
-// Fix 修复请求内容，注意：上下文长度修复后，最终的上下文数量不包含 system 消息和用户最后一条消息
-func (req Request) Fix(chat Chat, maxContextLength int64, maxTokenCount int) (*Request, int64, error) {
-	// 自动缩减上下文长度至满足模型要求的最大长度，尽可能避免出现超过模型上下文长度的问题
-	systemMessages := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role == "system" })
-	systemMessageLen, _ := MessageTokenCount(systemMessages, req.Model)
+// Validate 校验请求参数，在执行 Fix 等开销较大的处理之前调用，尽早拒绝异常请求
+// maxMessages 为单次请求允许的最大消息数量，小于等于 0 时使用 DefaultMaxMessages；
+// roleLimits 为每种角色的单条消息内容的最大字符数量（多模态消息包括所有文本部分），超过时返回 ContentTooLongError，为零值时不限制
+func (req Request) Validate(maxMessages int, roleLimits RoleContentLimits) error {
+	if maxMessages <= 0 {
+		maxMessages = DefaultMaxMessages
+	}
 
-	// 模型允许的 Tokens 数量和请求参数指定的 Tokens 数量，取最小值
-	modelTokenLimit := chat.MaxContextLength(req.Model) - systemMessageLen
-	if modelTokenLimit < maxTokenCount {
-		maxTokenCount = modelTokenLimit
+	if len(req.Messages) > maxMessages {
+		return fmt.Errorf("%w：最多允许 %d 条，当前 %d 条", ErrTooManyMessages, maxMessages, len(req.Messages))
 	}
 
-	messages, inputTokens, err := ReduceMessageContext(
-		ReduceMessageContextUpToContextWindow(
-			array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != "system" }),
-			int(maxContextLength),
-		),
-		req.Model,
-		maxTokenCount,
-	)
-	if err != nil {
-		return nil, 0, errors.New("超过模型最大允许的上下文长度限制，请尝试“新对话”或缩短输入内容长度")
+	for i, msg := range req.Messages {
+		if limit := roleLimits.Limit(msg.Role); limit > 0 {
+			if runes := contentRunes(msg); runes > limit {
+				return &ContentTooLongError{Index: i, Role: msg.Role, MaxRunes: limit, Runes: runes}
+			}
+		}
 	}
 
-	req.Messages = array.Map(append(systemMessages, messages...), func(item Message, _ int) Message {
-		if len(item.MultipartContents) > 0 {
-			item.MultipartContents = array.Map(item.MultipartContents, func(part *MultipartContent, _ int) *MultipartContent {
-				if part.ImageURL != nil && part.ImageURL.URL != "" && part.ImageURL.Detail == "" {
-					part.ImageURL.Detail = "low"
-				}
+	if req.MinOutputTokens < 0 {
+		return fmt.Errorf("invalid min output tokens: %d", req.MinOutputTokens)
+	}
 
-				return part
-			})
-		}
-		return item
-	})
+	if err := req.ReasoningBudget.Validate(); err != nil {
+		return err
+	}
 
-	return &req, int64(inputTokens), nil
-}
+	if err := req.OutputStyle.Validate(); err != nil {
+		return err
+	}
 
-func (req Request) ResolveCalFeeModel(conf *config.Config) string {
-	return req.Model
-}
+	if err := (RequestDefaults{ImageDetail: req.ImageDetail}).Validate(); err != nil {
+		return err
+	}
 
-type Response struct {
-	Error        string `json:"error,omitempty"`
-	ErrorCode    string `json:"error_code,omitempty"`
-	Text         string `json:"text,omitempty"`
-	FinishReason string `json:"finish_reason,omitempty"`
-	InputTokens  int    `json:"input_tokens,omitempty"`
-	OutputTokens int    `json:"output_tokens,omitempty"`
+	return nil
 }
 
-type Chat interface {
-	// Chat 以请求-响应的方式进行对话
-	Chat(ctx context.Context, req Request) (*Response, error)
-	// ChatStream 以流的方式进行对话
-	ChatStream(ctx context.Context, req Request) (<-chan Response, error)
-	// MaxContextLength 获取模型的最大上下文长度
-	MaxContextLength(model string) int
-}
+// WithDefaultModel 请求中未指定模型时，使用配置的默认模型
+func (req Request) WithDefaultModel(defaultModel string) Request {
+	if strings.TrimSpace(req.Model) != "" || defaultModel == "" {
+		return req
+	}
 
-type Imp struct {
-	ai       *AI
-	svc      *service.Service
-	proxy    *proxy.Proxy
-	resolver infra.Resolver
+	log.F(log.M{"default_model": defaultModel}).Info("request model is empty, use default model instead")
+	req.Model = defaultModel
+
+	return req
 }
 
-func NewChat(conf *config.Config, resolver infra.Resolver, svc *service.Service, ai *AI) Chat {
-	var proxyDialer *proxy.Proxy
-	if conf.SupportProxy() {
-		resolver.MustResolve(func(pp *proxy.Proxy) {
-			proxyDialer = pp
-		})
+// Fix 修复请求内容，注意：上下文长度修复后，最终的上下文数量不包含 system 消息和用户最后一条消息
+//
+// 固定的消息（Message.Pinned）与 system 消息一样始终保留，不参与上下文缩减，固定的消息本身已经超过模型的上下文长度时返回 PinnedContextExceedError
+//
+// 服务提供商不支持 system 角色时（参考 supportSystemRole），按照 system 消息转换为 user 消息之后的结构计算 Token 数量
+func (req Request) Fix(chat Chat, maxContextLength int64, maxTokenCount int) (*Request, int64, error) {
+	if len(req.Messages) == 0 {
+		return nil, 0, ErrEmptyMessages
 	}
 
-	return &Imp{ai: ai, svc: svc, proxy: proxyDialer, resolver: resolver}
-}
+	// 服务提供商不支持 system 角色时，system 消息会转换为 user 消息和 assistant 确认消息，按照转换后的结构计算 Token 数量
+	countMessages := func(messages Messages) Messages { return messages }
+	if !supportSystemRole(chat, req.Model) {
+		countMessages = foldSystemMessages
+	}
 
-func (ai *Imp) queryModel(modelId string) repo.Model {
-	pro := ai.svc.Chat.Model(context.Background(), modelId)
-	if pro == nil {
-		pro = &repo.Model{
-			Providers: []repo.ModelProvider{
-				{Name: service.ProviderOpenAI},
-			},
-			Models: model.Models{
-				ModelId: modelId,
-			},
-			Meta: repo.ModelMeta{
-				Restricted: true,
-				MaxContext: 4000,
-			},
-		}
+	// 自动缩减上下文长度至满足模型要求的最大长度，尽可能避免出现超过模型上下文长度的问题
+	systemMessages := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role == RoleSystem })
+	systemMessageLen, _ := MessageTokenCount(countMessages(systemMessages), req.Model)
+	if req.PersonaPrompt != "" && !req.RawMode {
+		// 角色提示语会替代请求中的 system 消息
+		systemMessageLen, _ = MessageTokenCount(countMessages(Messages{{Role: RoleSystem, Content: req.PersonaPrompt}}), req.Model)
 	}
 
-	return *pro
-}
+	pinnedMessages := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != RoleSystem && item.Pinned })
+	var pinnedMessageLen int
+	if len(pinnedMessages) > 0 {
+		// 回复的起始标记已经包含在 system 消息的 Token 数量中
+		pinnedMessageLen, _ = MessageTokenCount(pinnedMessages, req.Model)
+		pinnedMessageLen -= replyPrimingTokens
+	}
 
-// selectImp 选择合适的 AI 服务提供商
-//
-// 并不是所有类型的渠道都支持动态配置（根据数据库 channels 中的配置创建客户端），目前只有 openai/oneapi/openrouter 支持
-// 首先 根据 Channel ID 选择对应的 AI 服务提供商，如果 Channel ID 不存在或者对应的 AI 服务提供商不支持，则根据 Model ID 选择对应的 AI 服务提供商
-// 如果 Model ID 也不存在或者对应的 AI 服务提供商不支持，则使用 OpenAI 作为默认的 AI 服务提供商
-func (ai *Imp) selectImp(provider repo.ModelProvider) Chat {
-	if provider.ID > 0 {
-		ch, err := ai.svc.Chat.Channel(context.Background(), provider.ID)
-		if err != nil {
-			log.F(log.M{"provider": provider}).Errorf("get channel %d failed: %v", provider.ID, err)
-		} else {
-			switch ch.Type {
-			case service.ProviderOpenAI:
-				return ai.createOpenAIClient(ch)
-			case service.ProviderOneAPI:
-				return ai.createOneAPIClient(ch)
-			case service.ProviderOpenRouter:
-				return ai.createOpenRouterClient(ch)
-			default:
-				if ret := ai.selectProvider(ch.Type); ret != nil {
-					return ret
+	// 模型允许的 Tokens 数量和请求参数指定的 Tokens 数量，取最小值
+	// 需要为输出内容预留空间，上下文缩减后仍然无法预留时返回 ContextExceedError，避免生成过短的回复
+	contextLength := chat.MaxContextLength(req.Model)
+	if overflow := systemMessageLen + pinnedMessageLen - contextLength; len(pinnedMessages) > 0 && overflow >= 0 {
+		return nil, 0, &PinnedContextExceedError{MaxContext: contextLength, PinnedTokens: pinnedMessageLen, Overflow: overflow + 1}
+	}
+
+	reservedOutputTokens := req.reservedOutputTokens()
+	modelTokenLimit := contextLength - systemMessageLen - pinnedMessageLen - reservedOutputTokens
+
+	if modelTokenLimit < maxTokenCount {
+		maxTokenCount = modelTokenLimit
+	}
+
+	// 按照消息数量缩减上下文之后，再限制对话中图片的总数量，避免长对话中累积的图片导致费用过高
+	messages, inputTokens, err := ReduceMessageContext(
+		capConversationImages(
+			ReduceMessageContextUpToContextWindow(
+				array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != RoleSystem && !item.Pinned }),
+				int(maxContextLength),
+			),
+			req.MaxImages,
+		),
+		req.Model,
+		maxTokenCount,
+	)
+	if err != nil {
+		var exceedErr *ContextExceedError
+		if errors.As(err, &exceedErr) {
+			// 加上 system 消息和固定的消息的 Token 数量，返回完整请求的上下文信息
+			inputTokens := exceedErr.InputTokens + systemMessageLen + pinnedMessageLen
+
+			// 输入内容本身没有超过上下文长度，只是无法为输出内容预留空间
+			if reservedOutputTokens > 0 && inputTokens <= contextLength {
+				return nil, 0, &OutputReserveError{
+					MaxContext:      contextLength,
+					InputTokens:     inputTokens,
+					MinOutputTokens: reservedOutputTokens - maxTokensSafetyMargin,
+					Overflow:        exceedErr.Overflow,
 				}
 			}
+
+			return nil, 0, &ContextExceedError{
+				MaxContext:  exceedErr.MaxContext + systemMessageLen + pinnedMessageLen,
+				InputTokens: inputTokens,
+				Overflow:    exceedErr.Overflow,
+			}
 		}
-	}
 
-	if ret := ai.selectProvider(provider.Name); ret != nil {
-		return ret
-	}
-
-	log.Errorf("unsupported provider: %s, using openai instead", provider.Name)
-
-	return ai.ai.OpenAI
-}
-
-func (ai *Imp) selectProvider(name string) Chat {
-	switch name {
-	case service.ProviderOpenAI:
-		return ai.ai.OpenAI
-	case service.ProviderXunFei:
-		return ai.ai.Xfyun
-	case service.ProviderWenXin:
-		return ai.ai.Baidu
-	case service.ProviderDashscope:
-		return ai.ai.DashScope
-	case service.ProviderSenseNova:
-		return ai.ai.SenseNova
-	case service.ProviderTencent:
-		return ai.ai.Tencent
-	case service.ProviderBaiChuan:
-		return ai.ai.Baichuan
-	case service.Provider360:
-		return ai.ai.GPT360
-	case service.ProviderOneAPI:
-		return ai.ai.OneAPI
-	case service.ProviderOpenRouter:
-		return ai.ai.Openrouter
-	case service.ProviderSky:
-		return ai.ai.Sky
-	case service.ProviderZhipu:
-		return ai.ai.Zhipu
-	case service.ProviderMoonshot:
-		return ai.ai.Moonshot
-	case service.ProviderGoogle:
-		return ai.ai.Google
-	case service.ProviderAnthropic:
-		return ai.ai.Anthropic
-	default:
+		return nil, 0, errors.New("超过模型最大允许的上下文长度限制，请尝试“新对话”或缩短输入内容长度")
 	}
 
-	return nil
-}
+	inputTokens += pinnedMessageLen
 
-func (ai *Imp) Chat(ctx context.Context, req Request) (*Response, error) {
-	req, pro := ai.fixRequest(ctx, req)
-	return ai.selectImp(pro).Chat(ctx, req)
-}
+	// 剩余的上下文长度不足以容纳请求的最大输出 Token 数量时，自动调低（不会超过请求的值）
+	if remaining := contextLength - systemMessageLen - inputTokens - maxTokensSafetyMargin; req.MaxTokens > remaining && remaining > 0 {
+		req.MaxTokensAdjustment = &MaxTokensAdjustment{Requested: req.MaxTokens, Adjusted: remaining}
+		req.MaxTokens = remaining
+	}
 
-func (ai *Imp) fixRequest(ctx context.Context, req Request) (Request, repo.ModelProvider) {
-	// TODO 这里是临时解决方案
-	// 使用微软的 Azure OpenAI 接口时，聊天内容只有“继续”两个字时，会触发风控，导致无法继续对话
-	req.Messages = array.Map(req.Messages, func(item Message, _ int) Message {
-		content := strings.TrimSpace(item.Content)
-		if content == "继续" {
-			item.Content = "请接着说"
+	// 图片识别精度只有 OpenAI 系列的服务提供商支持，这里不设置默认值，由各个服务提供商自行处理（参考 openAIImageDetail）
+	req.Messages = array.Map(append(append(systemMessages, pinnedMessages...), messages...), func(item Message, _ int) Message {
+		if len(item.MultipartContents) > 0 {
+			item.MultipartContents = array.Filter(item.MultipartContents, func(part *MultipartContent, _ int) bool { return part != nil })
 		}
-
 		return item
 	})
 
-	mod := ai.queryModel(req.Model)
-	pro := mod.SelectProvider(ctx)
-
-	if pro.ModelRewrite != "" {
-		req.Model = pro.ModelRewrite
+	if breakdown, err := MessageTokenCountByRole(countMessages(req.Messages), req.Model); err == nil {
+		req.InputTokenBreakdown = &breakdown
 	}
 
-	systemPrompts := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role == "system" })
-	chatMessages := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != "system" })
+	return &req, int64(inputTokens), nil
+}
 
-	if mod.Meta.Prompt != "" {
-		if len(systemPrompts) > 0 {
-			systemPrompts[0].Content = mod.Meta.Prompt + "\n" + systemPrompts[0].Content
-			systemPrompts = Messages{systemPrompts[0]}
-		} else {
-			systemPrompts = Messages{{Role: "system", Content: mod.Meta.Prompt}}
-		}
+// reservedOutputTokens 缩减上下文时为输出内容预留的 Token 数量，不超过请求的最大输出 Token 数量
+func (req Request) reservedOutputTokens() int {
+	if req.MinOutputTokens <= 0 {
+		return 0
 	}
 
-	req.Messages = Messages(append(systemPrompts, chatMessages...)).Fix()
+	reserved := req.MinOutputTokens
+	if req.MaxTokens > 0 {
+		reserved = min(reserved, req.MaxTokens)
+	}
 
-	return req, pro
+	return reserved + maxTokensSafetyMargin
 }
 
-func (ai *Imp) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
-	req, pro := ai.fixRequest(ctx, req)
-	log.F(log.M{"model": req.Model, "message": req.Messages.ToLogEntry()}).Debug("chat stream request")
-	return ai.selectImp(pro).ChatStream(ctx, req)
+func (req Request) ResolveCalFeeModel(conf *config.Config) string {
+	return req.Model
 }
 
-func (ai *Imp) MaxContextLength(model string) int {
-	mod := ai.queryModel(model)
-	if mod.Meta.MaxContext > 0 {
-		return mod.Meta.MaxContext
-	}
+type Response struct {
+	Error        string `json:"error,omitempty"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	Text         string `json:"text,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Refusal 模型拒绝回答时的说明（如 OpenAI 返回的 refusal 字段），此时 FinishReason 为 FinishReasonRefusal，
+	// 与触发内容安全策略（ErrContentFilter）不同，客户端可以展示为“助手拒绝了该请求”，流式输出时在结束响应中返回完整的说明
+	Refusal string `json:"refusal,omitempty"`
+	// StoppedBy 触发结束的停止序列（请求中 Stop 的取值之一），服务提供商没有返回且无法根据输出内容明确推断时为空
+	StoppedBy    string `json:"stopped_by,omitempty"`
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
 
-	return ai.selectImp(mod.SelectProvider(context.Background())).MaxContextLength(model)
+	// ToolCalls 组装完成的工具调用
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallDelta 工具调用的中间状态，只在开启 Request.StreamToolCalls 时返回
+	ToolCallDelta *ToolCallDelta `json:"tool_call_delta,omitempty"`
+	// Interim 是否为中间状态的响应，中间状态的响应不包含最终的输出内容，仅用于展示进度
+	Interim bool `json:"interim,omitempty"`
+
+	// DebugRequest 实际发送给上游的请求内容，只在调试模式下返回
+	DebugRequest *DebugRequest `json:"debug_request,omitempty"`
+	// Attempts 本次请求上游的记录（包括重试），只在调试模式下返回，流式输出时在结束响应中返回
+	Attempts []AttemptInfo `json:"attempts,omitempty"`
+	// UsedSources 输出内容引用的参考资料段落，只在开启 Request.ReturnUsedSources 时返回，流式输出时在输出结束后返回
+	UsedSources []UsedSource `json:"used_sources,omitempty"`
+	// Citations 回复引用的外部来源（如服务端执行的 web_search 工具返回的搜索结果），流式输出时在包含结束原因的响应中返回
+	Citations []Citation `json:"citations,omitempty"`
+	// ToolIterations 服务端工具调用循环完成的次数（执行工具之后再次请求模型的次数），流式输出时在包含结束原因的响应中返回
+	ToolIterations int `json:"tool_iterations,omitempty"`
+	// BudgetLimited 服务端工具调用循环是否因为耗时预算（Request.ToolLoopBudget）提前结束，此时回答只基于已有的工具调用结果
+	BudgetLimited bool `json:"budget_limited,omitempty"`
+	// Reproducible 请求要求可复现的输出时，模型是否支持，为 false 时相同的请求可能返回不同的结果，
+	// 请求未要求可复现的输出时为 nil，流式输出时在第一个响应中返回
+	Reproducible *bool `json:"reproducible,omitempty"`
+	// Warning 请求被降级处理时（如图片理解不可用时去掉了图片）的警告信息，流式输出时在第一个响应中返回，
+	// 有多条警告信息时为第一条（兼容只读取 warning 的客户端），全部的警告信息参考 Warnings
+	Warning string `json:"warning,omitempty"`
+	// Warnings 全部的警告信息，按照产生的顺序排列，与 Warning 在同一个响应中返回
+	Warnings []string `json:"warnings,omitempty"`
+	// TaskProfile 根据任务类型调整了采样参数时使用的配置名称，用于对比参数调整的效果，流式输出时在第一个响应中返回
+	TaskProfile string `json:"task_profile,omitempty"`
+	// Sandbox 是否为沙箱模式（演示账号）返回的预置回答，客户端可以据此为演示内容添加水印，流式输出时在第一个响应和包含结束原因的响应中返回
+	Sandbox bool `json:"sandbox,omitempty"`
+	// SystemFingerprint 上游返回的模型指纹，不支持的服务提供商为空
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+	// FingerprintChanged 请求指定了 SessionID 时，指纹是否与会话中上一轮对话不同（上游模型可能已经更换）
+	FingerprintChanged bool `json:"fingerprint_changed,omitempty"`
+
+	// InputTokenBreakdown 输入 Token 数量按照消息角色（system/user/assistant/tool）的分布，来自 Request.Fix，
+	// 流式输出时在包含结束原因的响应中返回
+	InputTokenBreakdown *InputTokenBreakdown `json:"input_token_breakdown,omitempty"`
+
+	// OutputSHA256 请求开启 OutputChecksum 时，输出内容（经过所有处理之后用户看到的内容）的 SHA-256 摘要，
+	// 流式输出时在结束的响应中返回，为所有响应的 Text 拼接之后的摘要
+	OutputSHA256 string `json:"output_sha256,omitempty"`
+
+	// Choices 请求生成多个候选回复时（Request.Choices 大于 1），所有成功的候选回复，Token 数量为所有候选回复的总和
+	Choices []Choice `json:"choices,omitempty"`
+	// ChoiceIndex 流式输出生成多个候选回复时，响应所属的候选回复
+	ChoiceIndex int `json:"choice_index,omitempty"`
+
+	// Parts 回复中文本之外的多模态内容（如图片生成流程返回的图片地址及说明），流式输出时每个响应只包含新增的内容。
+	// 保存到聊天记录后，客户端在后续的请求中将其作为助手消息的 multipart_content 发送，参考 liftAssistantImages
+	Parts []*MultipartContent `json:"parts,omitempty"`
+
+	// Upstream 上游服务返回的错误详情，只用于日志和后台管理工具，不会返回给用户
+	Upstream *UpstreamError `json:"-"`
+	// ContentFilter 触发内容安全策略的详细原因（包括命中的敏感词），只用于日志和后台管理工具，不会返回给用户
+	ContentFilter *ContentFilterError `json:"-"`
 }
 
-// createOpenAIClient 创建一个 OpenAI Client
-func (ai *Imp) createOpenAIClient(ch *repo.Channel) Chat {
-	conf := openai.Config{
-		Enable:        true,
-		OpenAIServers: []string{ch.Server},
-		OpenAIKeys:    []string{ch.Secret},
-		AutoProxy:     ch.Meta.UsingProxy,
-	}
-
-	if ch.Meta.OpenAIAzure {
-		conf.OpenAIAzure = true
-		conf.OpenAIAPIVersion = ch.Meta.OpenAIAzureAPIVersion
-	}
-
-	return NewOpenAIChat(openai.NewOpenAIClient(&conf, ai.proxy))
+// ToCoinUsage 服务提供商返回的 Token 用量，按照 model 的价格计费（coins.ComputeFee）
+func (res Response) ToCoinUsage(model coins.ModelInfo) coins.ChatUsage {
+	return coins.ChatUsage{Model: model, InputTokens: int64(res.InputTokens), OutputTokens: int64(res.OutputTokens)}
 }
 
-// createOneAPIClient 创建一个 OneAPI Client
-func (ai *Imp) createOneAPIClient(ch *repo.Channel) Chat {
-	conf := openai.Config{
-		Enable:        true,
-		OpenAIServers: []string{ch.Server},
-		OpenAIKeys:    []string{ch.Secret},
-		AutoProxy:     ch.Meta.UsingProxy,
+// addWarnings 追加警告信息，Warning 始终为第一条
+func (res *Response) addWarnings(warnings ...string) {
+	res.Warnings = append(res.Warnings, warnings...)
+	if len(res.Warnings) > 0 {
+		res.Warning = res.Warnings[0]
 	}
+}
 
-	var trans youdao.Translater
-	_ = ai.resolver.Resolve(func(t youdao.Translater) {
-		trans = t
-	})
-
-	return NewOneAPIChat(oneapi.New(openai.NewOpenAIClient(&conf, ai.proxy), trans))
+type Chat interface {
+	// Chat 以请求-响应的方式进行对话
+	Chat(ctx context.Context, req Request) (*Response, error)
+	// ChatStream 以流的方式进行对话
+	ChatStream(ctx context.Context, req Request) (<-chan Response, error)
+	// MaxContextLength 获取模型的最大上下文长度
+	MaxContextLength(model string) int
 }
 
-// createOpenRouterClient 创建一个 OpenRouter Client
-func (ai *Imp) createOpenRouterClient(ch *repo.Channel) Chat {
-	if ch.Server == "" {
-		ch.Server = "https://openrouter.ai/api/v1"
+// ValidateDefaultModel 校验配置的默认聊天模型是否存在，未配置默认模型时不做校验。
+// 只有模型不存在（queryModel 返回 repo.ErrNotFound）时返回 ErrDefaultModelNotFound，查询失败时返回原始错误
+func ValidateDefaultModel(defaultModel string, queryModel func(modelID string) (*repo.Model, error)) error {
+	if defaultModel == "" {
+		return nil
 	}
 
-	conf := openai.Config{
-		Enable:        true,
-		OpenAIServers: []string{ch.Server},
-		OpenAIKeys:    []string{ch.Secret},
-		AutoProxy:     ch.Meta.UsingProxy,
+	if _, err := queryModel(defaultModel); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return fmt.Errorf("%w: %s（请检查配置项 default-chat-model 是否正确）", ErrDefaultModelNotFound, defaultModel)
+		}
+
+		return fmt.Errorf("query default chat model %s failed: %w", defaultModel, err)
 	}
 
-	return NewOpenRouterChat(openrouter.NewOpenRouter(openai.NewOpenAIClient(&conf, ai.proxy)))
+	return nil
 }
@@ -2,10 +2,19 @@ package chat
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/aidea-server/pkg/ai/baidu"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
 	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/go-utils/assert"
+	"github.com/pkoukk/tiktoken-go"
 )
 
 type ChatTestClient struct{}
@@ -22,6 +31,13 @@ func (c ChatTestClient) MaxContextLength(model string) int {
 	return 2048
 }
 
+// skipWithoutTiktoken 计算 Token 数量依赖 tiktoken 编码文件（需要联网下载），无法加载时跳过测试
+func skipWithoutTiktoken(t testing.TB) {
+	if _, err := tiktoken.EncodingForModel("gpt-3.5-turbo"); err != nil {
+		t.Skipf("tiktoken encoding not available: %v", err)
+	}
+}
+
 func TestRequestFix(t *testing.T) {
 	req := Request{
 		Messages: Messages{
@@ -54,6 +70,99 @@ func TestRequestFix(t *testing.T) {
 	}
 }
 
+func TestRequestFix_MaxImages(t *testing.T) {
+	image := func(name string) *MultipartContent {
+		return &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/" + name + ".png", Detail: "low"}}
+	}
+
+	req := Request{
+		Messages: Messages{
+			{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "text", Text: "turn #1"}, image("1a"), image("1b")}},
+			{Role: RoleAssistant, Content: "answer #1"},
+			{Role: RoleUser, MultipartContents: []*MultipartContent{image("2a")}},
+			{Role: RoleAssistant, Content: "answer #2"},
+			{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "text", Text: "turn #3"}, image("3a"), image("3b")}},
+			{Role: RoleAssistant, Content: "answer #3"},
+			{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "text", Text: "turn #4"}, image("4a"), image("4b")}},
+		},
+		Model:     "gpt-4o",
+		MaxImages: 3,
+	}.Init()
+
+	images := func(messages Messages) []string {
+		urls := make([]string, 0)
+		for _, msg := range messages {
+			for _, part := range msg.MultipartContents {
+				if isImagePart(part) {
+					urls = append(urls, strings.TrimSuffix(strings.TrimPrefix(part.ImageURL.URL, "https://example.com/"), ".png"))
+				}
+			}
+		}
+
+		return urls
+	}
+
+	// 从最早的消息开始去掉图片，保留最新的图片和所有文本
+	fixed, _, err := req.Fix(ChatTestClient{}, 10, 100000)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, len(fixed.Messages))
+	assert.EqualValues(t, []string{"3b", "4a", "4b"}, images(fixed.Messages))
+	assert.Equal(t, "turn #1", fixed.Messages[0].Text())
+	assert.Equal(t, strippedImagePlaceholder, fixed.Messages[2].Content)
+	assert.Equal(t, "turn #3", fixed.Messages[4].Text())
+
+	// 原始请求不受影响
+	assert.Equal(t, 7, len(images(req.Messages)))
+
+	// 最后一条用户消息中的图片总是保留
+	req.MaxImages = 1
+	fixed, _, err = req.Fix(ChatTestClient{}, 10, 100000)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"4a", "4b"}, images(fixed.Messages))
+
+	// 未超过限制或者不限制时保持原样
+	for _, maxImages := range []int{0, 7} {
+		req.MaxImages = maxImages
+		fixed, _, err = req.Fix(ChatTestClient{}, 10, 100000)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, len(images(fixed.Messages)))
+	}
+}
+
+func TestRequestFix_InputTokenBreakdown(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	req := Request{
+		Messages: Messages{
+			{Role: RoleSystem, Content: "You are a helpful assistant."},
+			{Role: RoleUser, Content: "What's the weather like in Beijing?"},
+			{Role: RoleAssistant, Content: "Let me check the weather for you."},
+			{Role: RoleTool, Content: `{"city":"Beijing","weather":"sunny","temperature":25}`},
+			{Role: RoleAssistant, Content: "It is sunny in Beijing, 25°C."},
+			{Role: RoleUser, Content: "And tomorrow?"},
+		},
+		Model: "gpt-3.5-turbo",
+	}.Init()
+
+	fixed, inputTokens, err := req.Fix(ChatTestClient{}, 10, 1024)
+	assert.NoError(t, err)
+
+	breakdown := fixed.InputTokenBreakdown
+	assert.True(t, breakdown != nil)
+	assert.True(t, breakdown.System > 0 && breakdown.User > 0 && breakdown.Assistant > 0 && breakdown.Tool > 0)
+
+	// 各个角色的 Token 数量之和与完整请求的 Token 数量相同
+	total, err := MessageTokenCount(fixed.Messages, fixed.Model)
+	assert.NoError(t, err)
+	assert.Equal(t, total, breakdown.Total())
+
+	// Fix 返回的输入 Token 数量不包含 system 消息
+	system, err := MessageTokenCount(Messages{fixed.Messages[0]}, fixed.Model)
+	assert.NoError(t, err)
+	assert.Equal(t, system-replyPrimingTokens, breakdown.System)
+	assert.EqualValues(t, inputTokens, breakdown.Total()-breakdown.System)
+}
+
 func TestMessages_Fix(t *testing.T) {
 	messages := Messages{
 		{Role: "system", Content: "假如你是鲁迅，请使用批判性，略带讽刺的语言来回答我的问题，语言要风趣，幽默，略带调侃"},
@@ -68,6 +177,315 @@ func TestMessages_Fix(t *testing.T) {
 	log.With(messages).Debug("messages")
 }
 
+func TestMessages_FixWithoutContinue(t *testing.T) {
+	messages := Messages{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "你好"},
+		{Role: RoleAssistant, Content: "好的，"},
+	}
+
+	fixed := messages.Fix()
+	assert.Equal(t, 4, len(fixed))
+	assert.Equal(t, Message{Role: RoleUser, Content: "继续"}, fixed[3])
+
+	// 保留以助手消息结尾的上下文，其它修正仍然生效
+	fixed = append(Messages{{Role: RoleAssistant, Content: "开场白"}}, messages...).FixWithoutContinue()
+	assert.EqualValues(t, messages, fixed)
+}
+
+func TestDispatcher_NoAutoContinue(t *testing.T) {
+	client := &streamChatClient{}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+
+	req := Request{Model: "gpt-4o", Messages: Messages{
+		{Role: RoleUser, Content: "写一首诗"},
+		{Role: RoleAssistant, Content: "床前明月光，"},
+		{Role: RoleUser, Content: "继续"},
+		{Role: RoleAssistant, Content: "疑是地上霜。"},
+	}}
+
+	// 未指定时改写“继续”，并在最后补充“继续”
+	_, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	sent := client.requests[0].Messages
+	assert.Equal(t, 5, len(sent))
+	assert.Equal(t, "请接着说", sent[2].Content)
+	assert.Equal(t, Message{Role: RoleUser, Content: "继续"}, sent[4])
+
+	req.NoAutoContinue = true
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.EqualValues(t, req.Messages, client.requests[1].Messages)
+}
+
+func TestNoAutoContinue_ProviderExceptions(t *testing.T) {
+	req := Request{NoAutoContinue: true, Messages: Messages{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "写一首诗"},
+		{Role: RoleAssistant, Content: "床前明月光，"},
+	}}
+
+	// 腾讯混元、文心千帆要求最后一条消息是用户消息，指定 NoAutoContinue 时仍然补充“继续”
+	tencentMessages := (&TencentAIChat{}).initRequest(req).Messages
+	assert.Equal(t, "user", tencentMessages[len(tencentMessages)-1].Role)
+	assert.Equal(t, "继续", tencentMessages[len(tencentMessages)-1].Content)
+
+	req.Model = "文心千帆:" + string(baidu.ModelErnieBot)
+	baiduMessages := (&BaiduAIChat{}).initRequest(req).Messages
+	assert.Equal(t, "user", baiduMessages[len(baiduMessages)-1].Role)
+	assert.Equal(t, "继续", baiduMessages[len(baiduMessages)-1].Content)
+}
+
+func TestMessages_MergeUserMessages(t *testing.T) {
+	// 两条连续的用户消息
+	messages := Messages{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "你好"},
+		{Role: RoleUser, Content: "在吗"},
+	}.MergeUserMessages()
+
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "你好\n在吗", messages[1].Content)
+	assert.Equal(t, 0, len(messages[1].MultipartContents))
+
+	// 三条连续的用户消息，合并后 Fix 不会丢弃较早的消息
+	messages = Messages{
+		{Role: RoleUser, Content: "hello"},
+		{Role: RoleAssistant, Content: "hi"},
+		{Role: RoleUser, Content: "第一条"},
+		{Role: RoleUser, Content: "第二条"},
+		{Role: RoleUser, Content: "第三条"},
+	}.MergeUserMessages().Fix()
+
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, RoleAssistant, messages[1].Role)
+	assert.Equal(t, "第一条\n第二条\n第三条", messages[2].Content)
+}
+
+func TestMessages_MergeUserMultipartMessages(t *testing.T) {
+	image := &ImageURL{URL: "https://example.com/a.png"}
+
+	// 文本消息与多模态消息合并
+	messages := Messages{
+		{Role: RoleUser, Content: "看看这张图"},
+		{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "image_url", ImageURL: image}}},
+	}.MergeUserMessages()
+
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "看看这张图", messages[0].Content)
+	assert.Equal(t, 2, len(messages[0].MultipartContents))
+	assert.Equal(t, "text", messages[0].MultipartContents[0].Type)
+	assert.Equal(t, "看看这张图", messages[0].MultipartContents[0].Text)
+	assert.Equal(t, image, messages[0].MultipartContents[1].ImageURL)
+
+	// 三条消息，包含多条多模态消息
+	messages = Messages{
+		{Role: RoleAssistant, Content: "hi"},
+		{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "text", Text: "第一张"}, {Type: "image_url", ImageURL: image}}},
+		{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "text", Text: "第二张"}, {Type: "image_url", ImageURL: image}}},
+		{Role: RoleUser, Content: "有什么区别？"},
+	}.MergeUserMessages()
+
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "第一张\n第二张\n有什么区别？", messages[1].Content)
+	assert.Equal(t, 5, len(messages[1].MultipartContents))
+	assert.Equal(t, "第二张", messages[1].MultipartContents[2].Text)
+	assert.Equal(t, "有什么区别？", messages[1].MultipartContents[4].Text)
+
+	// 非连续的用户消息保持不变
+	messages = Messages{
+		{Role: RoleUser, Content: "a"},
+		{Role: RoleAssistant, Content: "b"},
+		{Role: RoleUser, Content: "c"},
+	}
+	assert.EqualValues(t, messages, messages.MergeUserMessages())
+}
+
+func TestRequest_InitMultipartMessages(t *testing.T) {
+	image := &ImageURL{URL: "https://example.com/a.png"}
+	req := Request{
+		Messages: Messages{
+			{Role: RoleUser, Content: "hello"},
+			// 只包含图片
+			{Role: RoleUser, MultipartContents: []*MultipartContent{{Type: "image_url", ImageURL: image}}},
+			// 空白文本 + 图片
+			{Role: RoleUser, Content: "  ", MultipartContents: []*MultipartContent{{Type: "text", Text: " \n"}, {Type: "image_url", ImageURL: image}}},
+			// 真正的空消息
+			{Role: RoleUser, Content: " ", MultipartContents: []*MultipartContent{{Type: "text", Text: "  "}, {Type: "image_url", ImageURL: &ImageURL{}}, nil}},
+			{Role: RoleAssistant, Content: ""},
+		},
+	}.Init()
+
+	assert.Equal(t, 3, len(req.Messages))
+	assert.Equal(t, "hello", req.Messages[0].Content)
+	assert.Equal(t, 1, len(req.Messages[1].MultipartContents))
+	assert.Equal(t, 2, len(req.Messages[2].MultipartContents))
+}
+
+func TestRequest_Validate(t *testing.T) {
+	newRequest := func(n int) Request {
+		messages := make(Messages, n)
+		for i := range messages {
+			messages[i] = Message{Role: RoleUser, Content: "hello"}
+		}
+
+		return Request{Messages: messages}
+	}
+
+	assert.NoError(t, newRequest(10).Validate(10, RoleContentLimits{}))
+
+	err := newRequest(11).Validate(10, RoleContentLimits{})
+	assert.True(t, errors.Is(err, ErrTooManyMessages))
+
+	// 未配置时使用默认值
+	assert.NoError(t, newRequest(DefaultMaxMessages).Validate(0, RoleContentLimits{}))
+	assert.True(t, errors.Is(newRequest(DefaultMaxMessages+1).Validate(0, RoleContentLimits{}), ErrTooManyMessages))
+}
+
+func TestRequest_WithDefaultModel(t *testing.T) {
+	req := Request{Messages: Messages{{Role: RoleUser, Content: "hello"}}}.Init()
+	assert.Equal(t, "gpt-3.5-turbo", req.WithDefaultModel("gpt-3.5-turbo").Model)
+
+	// 已经指定模型时，不使用默认模型
+	req = Request{Model: "openai:gpt-4"}.Init()
+	assert.Equal(t, "gpt-4", req.WithDefaultModel("gpt-3.5-turbo").Model)
+
+	// 未配置默认模型时，保持原样
+	assert.Equal(t, "", Request{}.WithDefaultModel("").Model)
+}
+
+func TestValidateDefaultModel(t *testing.T) {
+	models := map[string]*repo.Model{
+		"gpt-3.5-turbo": {Models: model.Models{ModelId: "gpt-3.5-turbo"}},
+	}
+	queryModel := func(modelID string) (*repo.Model, error) {
+		if ret, ok := models[modelID]; ok {
+			return ret, nil
+		}
+
+		return nil, repo.ErrNotFound
+	}
+
+	assert.NoError(t, ValidateDefaultModel("", queryModel))
+	assert.NoError(t, ValidateDefaultModel("gpt-3.5-turbo", queryModel))
+
+	err := ValidateDefaultModel("gpt-5", queryModel)
+	assert.True(t, errors.Is(err, ErrDefaultModelNotFound))
+	assert.True(t, strings.Contains(err.Error(), "gpt-5"))
+
+	// 查询失败时不认为模型不存在
+	dbErr := errors.New("connection refused")
+	err = ValidateDefaultModel("gpt-3.5-turbo", func(string) (*repo.Model, error) { return nil, dbErr })
+	assert.True(t, errors.Is(err, dbErr))
+	assert.False(t, errors.Is(err, ErrDefaultModelNotFound))
+}
+
+func TestContextExceedError(t *testing.T) {
+	var err error = &ContextExceedError{MaxContext: 16000, InputTokens: 19200, Overflow: 3200}
+	assert.True(t, errors.Is(err, ErrContextExceedLimit))
+	assert.True(t, strings.Contains(err.Error(), "3200"))
+
+	// 包装后仍然可以识别
+	wrapped := fmt.Errorf("chat failed: %w", err)
+	assert.True(t, errors.Is(wrapped, ErrContextExceedLimit))
+
+	var exceedErr *ContextExceedError
+	assert.True(t, errors.As(wrapped, &exceedErr))
+	assert.Equal(t, 3200, exceedErr.Overflow)
+}
+
+func TestRequestFix_ContextExceed(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	req := Request{
+		Model: "gpt-3.5-turbo",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "system"},
+			{Role: RoleUser, Content: strings.Repeat("hello ", 3000)},
+		},
+	}
+
+	_, _, err := req.Fix(ChatTestClient{}, 10, 100000)
+	assert.True(t, errors.Is(err, ErrContextExceedLimit))
+
+	var exceedErr *ContextExceedError
+	assert.True(t, errors.As(err, &exceedErr))
+	assert.Equal(t, 2048, exceedErr.MaxContext)
+	assert.True(t, exceedErr.InputTokens > exceedErr.MaxContext)
+	assert.Equal(t, exceedErr.InputTokens-exceedErr.MaxContext, exceedErr.Overflow)
+}
+
+func TestRequestFix_AdaptiveMaxTokens(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	newRequest := func(maxTokens, minOutputTokens int, history int, last string) Request {
+		messages := Messages{{Role: RoleSystem, Content: "system"}}
+		for i := 0; i < history; i++ {
+			messages = append(messages,
+				Message{Role: RoleUser, Content: strings.Repeat("question ", 150)},
+				Message{Role: RoleAssistant, Content: strings.Repeat("answer ", 150)},
+			)
+		}
+		messages = append(messages, Message{Role: RoleUser, Content: last})
+
+		return Request{Model: "gpt-3.5-turbo", Messages: messages, MaxTokens: maxTokens, MinOutputTokens: minOutputTokens}
+	}
+
+	// 剩余的上下文长度足够时保持不变
+	fixed, _, err := fixWithTestClient(newRequest(100, 0, 0, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, fixed.MaxTokens)
+	assert.True(t, fixed.MaxTokensAdjustment == nil)
+
+	// 剩余的上下文长度不足时调低，并记录调整信息
+	fixed, inputTokens, err := fixWithTestClient(newRequest(4000, 0, 0, "hello"))
+	assert.NoError(t, err)
+	assert.True(t, fixed.MaxTokensAdjustment != nil)
+	assert.Equal(t, 4000, fixed.MaxTokensAdjustment.Requested)
+	assert.Equal(t, fixed.MaxTokens, fixed.MaxTokensAdjustment.Adjusted)
+	assert.True(t, fixed.MaxTokens+int(inputTokens)+maxTokensSafetyMargin <= 2048)
+
+	// 未指定最大输出 Token 数量时不调整
+	fixed, _, err = fixWithTestClient(newRequest(0, 0, 0, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fixed.MaxTokens)
+
+	// 预留输出空间时，缩减更多的上下文，保证剩余长度不低于预留值
+	fixed, _, err = fixWithTestClient(newRequest(1000, 256, 5, "hello"))
+	assert.NoError(t, err)
+	assert.True(t, fixed.MaxTokens >= 256)
+
+	// 最后一条消息过长，剩余长度低于预留值时返回错误
+	last := strings.Repeat("hello ", 1800)
+	_, _, err = fixWithTestClient(newRequest(1000, 0, 0, last))
+	assert.NoError(t, err)
+
+	_, _, err = fixWithTestClient(newRequest(1000, 256, 0, last))
+	assert.True(t, errors.Is(err, ErrContextExceedLimit))
+
+	// 输入内容本身没有超过上下文长度时，返回无法预留输出空间的错误
+	var reserveErr *OutputReserveError
+	assert.True(t, errors.As(err, &reserveErr))
+	assert.Equal(t, 2048, reserveErr.MaxContext)
+	assert.Equal(t, 256, reserveErr.MinOutputTokens)
+	assert.True(t, reserveErr.InputTokens <= reserveErr.MaxContext)
+	assert.True(t, reserveErr.Overflow > 0)
+
+	// 预留值不超过请求的最大输出 Token 数量
+	_, _, err = fixWithTestClient(newRequest(10, 1000, 0, last))
+	assert.NoError(t, err)
+
+	// 输入内容本身超过上下文长度时，仍然返回上下文超限错误
+	_, _, err = fixWithTestClient(newRequest(1000, 256, 0, strings.Repeat("hello ", 3000)))
+	assert.True(t, errors.As(err, new(*ContextExceedError)))
+	assert.False(t, errors.As(err, &reserveErr))
+}
+
+// fixWithTestClient 使用默认的参数执行 Fix（上下文长度为 2048）
+func fixWithTestClient(req Request) (*Request, int64, error) {
+	return req.Fix(ChatTestClient{}, 10, 100000)
+}
+
 func TestDashscopeChat_InitRequest(t *testing.T) {
 	client := NewDashScopeChat(nil, nil)
 	{
@@ -280,3 +698,17 @@ func TestBaiduAIChat_InitRequest(t *testing.T) {
 	}
 
 }
+
+func TestResponse_ToCoinUsage(t *testing.T) {
+	mod := coins.ModelInfo{ModelId: "test", InputPrice: 10, OutputPrice: 20}
+
+	// 聊天和压缩使用的辅助模型的用量合并计费
+	res := Response{InputTokens: 1000, OutputTokens: 500}
+	compression := CompressionUsage{Model: "aux", InputTokens: 2000}
+	assert.Equal(t, coins.CapabilityChat, res.ToCoinUsage(mod).Capability())
+	assert.Equal(t, int64(20), coins.ComputeFee(res.ToCoinUsage(mod)))
+	assert.Equal(t, int64(40), coins.ComputeFee(res.ToCoinUsage(mod), compression.ToCoinUsage(mod)))
+
+	// 没有返回用量时不计费
+	assert.Equal(t, int64(0), coins.ComputeFee(Response{}.ToCoinUsage(mod)))
+}
@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// outputChecksum 输出内容的 SHA-256 摘要（十六进制）
+func outputChecksum(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// attachOutputChecksum 计算流式响应中所有输出内容（不包括中间状态的响应）的 SHA-256 摘要，
+// 在结束的响应（包含结束原因或者错误码）中返回，摘要包括该响应自身的输出内容
+//
+// 需要放在所有修改输出内容的处理之后，保证摘要与用户看到的内容一致
+func attachOutputChecksum(ctx context.Context, stream <-chan Response) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		h := sha256.New()
+		for data := range stream {
+			if !data.Interim {
+				h.Write([]byte(data.Text))
+			}
+
+			if data.FinishReason != "" || data.ErrorCode != "" {
+				data.OutputSHA256 = hex.EncodeToString(h.Sum(nil))
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
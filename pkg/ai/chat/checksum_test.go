@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func sha256Hex(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDispatcher_OutputChecksum(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{
+		{Text: "# 标题\n这是**粗体**"},
+		{Text: "内容\n- 列表"},
+		{Text: "项\n"},
+		{FinishReason: "stop"},
+	}}
+	d := NewDispatcher(fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	// 摘要覆盖经过处理（去掉 Markdown 标记）之后的输出内容
+	stream, err := d.ChatStream(context.TODO(), Request{
+		Model:          "gpt-4",
+		Messages:       Messages{{Role: RoleUser, Content: "hello"}},
+		OutputStyle:    OutputStylePlain,
+		StripMarkdown:  true,
+		OutputChecksum: true,
+	})
+	assert.NoError(t, err)
+
+	var text strings.Builder
+	var checksum string
+	for _, data := range assertFinishReasonConformance(t, stream) {
+		text.WriteString(data.Text)
+		if data.FinishReason != "" {
+			checksum = data.OutputSHA256
+		} else {
+			assert.Equal(t, "", data.OutputSHA256)
+		}
+	}
+
+	assert.Equal(t, "标题\n这是粗体内容\n- 列表项\n", text.String())
+	assert.Equal(t, sha256Hex(text.String()), checksum)
+
+	// 未开启时不计算
+	stream, err = d.ChatStream(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	for _, data := range assertFinishReasonConformance(t, stream) {
+		assert.Equal(t, "", data.OutputSHA256)
+	}
+
+	// 非流式输出
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}, OutputChecksum: true})
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Hex("ok"), res.OutputSHA256)
+}
+
+func TestAttachOutputChecksum_Error(t *testing.T) {
+	stream := make(chan Response, 3)
+	stream <- Response{Text: "partial "}
+	stream <- Response{Interim: true, Text: "ignored"}
+	stream <- Response{Text: "output", ErrorCode: ErrCodeUpstream, Error: "upstream error"}
+	close(stream)
+
+	var responses []Response
+	for data := range attachOutputChecksum(context.TODO(), stream) {
+		responses = append(responses, data)
+	}
+
+	// 以错误结束时，摘要在错误响应中返回，不包括中间状态的响应
+	assert.Equal(t, 3, len(responses))
+	assert.Equal(t, sha256Hex("partial output"), responses[2].OutputSHA256)
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// MaxChoices 单次请求最多生成的候选回复数量
+	MaxChoices = 5
+	// maxParallelChoices 同时向上游发起的候选回复请求数量
+	maxParallelChoices = 3
+)
+
+// Choice 多个候选回复中的一个
+type Choice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	// OutputSHA256 请求开启 OutputChecksum 时，候选回复内容的 SHA-256 摘要
+	OutputSHA256 string `json:"output_sha256,omitempty"`
+}
+
+// choiceCount 请求需要生成的候选回复数量，取值范围为 1-MaxChoices
+func choiceCount(req Request) int {
+	return min(max(req.Choices, 1), MaxChoices)
+}
+
+// chatChoices 以多个并行请求模拟生成多个候选回复（同时最多 maxParallelChoices 个请求）
+//
+// 返回的响应中 Choices 为所有成功的候选回复，Text/FinishReason 与第一个成功的候选回复相同，
+// Token 数量为所有候选回复的总和（用于计费）。所有请求都失败时，返回第一个失败的错误
+func chatChoices(ctx context.Context, imp Chat, req Request, n int) (*Response, error) {
+	results := make([]*Response, n)
+	errs := make([]error, n)
+
+	sem := make(chan struct{}, maxParallelChoices)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[i], errs[i] = imp.Chat(ctx, req)
+		}(i)
+	}
+	wg.Wait()
+
+	var ret *Response
+	for i, res := range results {
+		if errs[i] != nil {
+			continue
+		}
+
+		if ret == nil {
+			copied := *res
+			ret = &copied
+			ret.InputTokens, ret.OutputTokens = 0, 0
+		}
+
+		ret.Choices = append(ret.Choices, Choice{
+			Index:        i,
+			Text:         res.Text,
+			FinishReason: res.FinishReason,
+			InputTokens:  res.InputTokens,
+			OutputTokens: res.OutputTokens,
+		})
+		ret.InputTokens += res.InputTokens
+		ret.OutputTokens += res.OutputTokens
+	}
+
+	if ret == nil {
+		return nil, errs[0]
+	}
+
+	return ret, nil
+}
+
+// chatStreamChoices 以多个并行的流式请求模拟生成多个候选回复（同时最多 maxParallelChoices 个请求），
+// 所有候选回复的响应合并到一个流中，每个响应通过 ChoiceIndex 标识所属的候选回复
+//
+// process 用于对每个候选回复的流分别做后续处理（补充结束原因等）。第一个请求失败时直接返回错误，
+// 之后的请求失败时，以错误响应结束对应的候选回复
+func chatStreamChoices(ctx context.Context, imp Chat, req Request, n int, process func(<-chan Response) <-chan Response) (<-chan Response, error) {
+	first, err := imp.ChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+
+		sem := make(chan struct{}, maxParallelChoices)
+		var wg sync.WaitGroup
+		forward := func(index int, stream <-chan Response) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			defer drainStream(stream)
+
+			for data := range process(stream) {
+				data.ChoiceIndex = index
+				select {
+				case <-ctx.Done():
+					return
+				case res <- data:
+				}
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			select {
+			case <-ctx.Done():
+				wg.Done()
+				wg.Wait()
+				return
+			case sem <- struct{}{}:
+			}
+
+			stream := first
+			if i > 0 {
+				next, err := imp.ChatStream(ctx, req)
+				if err != nil {
+					next = singleResponse(Response{ErrorCode: "CHOICE_FAILED", Error: err.Error()})
+				}
+
+				stream = next
+			}
+
+			go forward(i, stream)
+		}
+
+		wg.Wait()
+	}()
+
+	return res, nil
+}
+
+// singleResponse 只包含一个响应的流
+func singleResponse(data Response) <-chan Response {
+	res := make(chan Response, 1)
+	res <- data
+	close(res)
+
+	return res
+}
@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// choicesChatClient 每次请求返回不同的内容，记录同时进行中的请求数量
+type choicesChatClient struct {
+	ChatTestClient
+	lock     sync.Mutex
+	calls    int
+	failAt   int
+	running  int32
+	parallel int32
+}
+
+func (c *choicesChatClient) next() (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.calls++
+	if c.calls == c.failAt {
+		return c.calls, errors.New("upstream failed")
+	}
+
+	return c.calls, nil
+}
+
+func (c *choicesChatClient) enter() func() {
+	running := atomic.AddInt32(&c.running, 1)
+	for {
+		parallel := atomic.LoadInt32(&c.parallel)
+		if running <= parallel || atomic.CompareAndSwapInt32(&c.parallel, parallel, running) {
+			break
+		}
+	}
+
+	return func() { atomic.AddInt32(&c.running, -1) }
+}
+
+func (c *choicesChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	defer c.enter()()
+
+	call, err := c.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{Text: fmt.Sprintf("reply %d", call), FinishReason: "stop", InputTokens: 10, OutputTokens: call}, nil
+}
+
+func (c *choicesChatClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	call, err := c.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return singleResponse(Response{Text: fmt.Sprintf("reply %d", call)}), nil
+}
+
+func newChoicesDispatcher(client Chat) *Dispatcher {
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+
+	return NewDispatcher(router, factory, "", PayloadPolicyReject)
+}
+
+func TestDispatcher_Choices(t *testing.T) {
+	client := &choicesChatClient{}
+	d := newChoicesDispatcher(client)
+
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4", Choices: 8, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	// 最多生成 MaxChoices 个候选回复，同时最多 maxParallelChoices 个请求
+	assert.Equal(t, MaxChoices, len(res.Choices))
+	assert.True(t, client.parallel <= maxParallelChoices)
+
+	// 计费使用所有候选回复的 Token 总和
+	assert.Equal(t, 10*MaxChoices, res.InputTokens)
+	assert.Equal(t, 1+2+3+4+5, res.OutputTokens)
+	for i, choice := range res.Choices {
+		assert.Equal(t, i, choice.Index)
+		assert.Equal(t, FinishReasonStop, choice.FinishReason)
+	}
+	assert.Equal(t, res.Choices[0].Text, res.Text)
+
+	// 部分候选回复失败时，只返回成功的候选回复
+	client = &choicesChatClient{failAt: 2}
+	d = newChoicesDispatcher(client)
+
+	res, err = d.Chat(context.TODO(), Request{Model: "gpt-4", Choices: 3, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.Choices))
+	assert.Equal(t, 20, res.InputTokens)
+}
+
+func TestDispatcher_ChoicesStream(t *testing.T) {
+	client := &choicesChatClient{failAt: 3}
+	d := newChoicesDispatcher(client)
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", Choices: 3, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	// 每个候选回复的响应分别补充结束原因，失败的候选回复以错误结束
+	texts := make(map[int]string)
+	finishes := make(map[int]string)
+	errorCodes := make(map[int]string)
+	for res := range stream {
+		texts[res.ChoiceIndex] += res.Text
+		if res.FinishReason != "" {
+			finishes[res.ChoiceIndex] = res.FinishReason
+		}
+		if res.ErrorCode != "" {
+			errorCodes[res.ChoiceIndex] = res.ErrorCode
+		}
+	}
+
+	assert.Equal(t, 3, len(texts))
+	assert.Equal(t, FinishReasonStop, finishes[0])
+	assert.Equal(t, FinishReasonStop, finishes[1])
+	assert.Equal(t, "CHOICE_FAILED", errorCodes[2])
+	assert.True(t, texts[0] != texts[1])
+}
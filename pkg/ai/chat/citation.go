@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// minQuotedPassageRunes 原文引用匹配时，段落的最小长度（字符数），过短的段落容易误判
+	minQuotedPassageRunes = 12
+	// citationShingleSize 相似度匹配时，连续 Token 片段的长度
+	citationShingleSize = 3
+	// minReferencedScore 相似度匹配时，段落被认为已引用的最小得分
+	minReferencedScore = 0.5
+)
+
+// Source 请求上下文中提供的参考资料（如 RAG 检索到的文档）
+type Source struct {
+	ID      string `json:"id"`
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content"`
+}
+
+// UsedSource 模型输出中引用的参考资料段落
+type UsedSource struct {
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+	// Passage 被引用的段落原文
+	Passage string `json:"passage"`
+	// Score 匹配得分，原文引用时为 1，否则为段落与输出内容的相似度
+	Score float64 `json:"score"`
+}
+
+// Citation 回复引用的外部来源（如 web_search 工具返回的搜索结果），客户端展示为参考链接
+type Citation struct {
+	// Index 来源的编号，模型在回复中使用 [编号] 引用
+	Index   int    `json:"index"`
+	Title   string `json:"title,omitempty"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// FindUsedSources 找出模型输出中引用的参考资料段落，这是一种启发式的匹配
+//
+// 参考资料按照句子拆分为段落，段落在输出中原文出现（忽略大小写、空白和标点）时认为被引用，
+// 否则计算段落中连续 Token 片段在输出中出现的比例，不低于 minReferencedScore 时认为被引用。
+func FindUsedSources(sources []Source, output string) []UsedSource {
+	normalizedOutput := normalizeCitationText(output)
+	if normalizedOutput == "" {
+		return nil
+	}
+
+	outputShingles := citationShingles(citationTokens(normalizedOutput))
+
+	var ret []UsedSource
+	for _, src := range sources {
+		for _, passage := range splitPassages(src.Content) {
+			normalized := normalizeCitationText(passage)
+			if normalized == "" {
+				continue
+			}
+
+			score := 0.0
+			if utf8.RuneCountInString(normalized) >= minQuotedPassageRunes && strings.Contains(normalizedOutput, normalized) {
+				score = 1
+			} else if shingles := citationShingles(citationTokens(normalized)); len(shingles) > 0 {
+				matched := 0
+				for shingle := range shingles {
+					if _, ok := outputShingles[shingle]; ok {
+						matched++
+					}
+				}
+
+				score = float64(matched) / float64(len(shingles))
+			}
+
+			if score >= minReferencedScore {
+				ret = append(ret, UsedSource{ID: src.ID, Title: src.Title, Passage: passage, Score: score})
+			}
+		}
+	}
+
+	return ret
+}
+
+// splitPassages 将参考资料按照句子拆分为段落
+func splitPassages(content string) []string {
+	var passages []string
+	var current strings.Builder
+	for _, r := range content {
+		current.WriteRune(r)
+
+		switch r {
+		case '。', '！', '？', '；', '.', '!', '?', ';', '\n':
+			if passage := strings.TrimSpace(current.String()); passage != "" {
+				passages = append(passages, passage)
+			}
+			current.Reset()
+		}
+	}
+
+	if passage := strings.TrimSpace(current.String()); passage != "" {
+		passages = append(passages, passage)
+	}
+
+	return passages
+}
+
+// normalizeCitationText 转换为小写，去掉标点符号，连续的空白合并为一个空格
+func normalizeCitationText(text string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+
+		if space && sb.Len() > 0 {
+			sb.WriteRune(' ')
+		}
+		space = false
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// citationTokens 拆分为 Token：连续的 ASCII 字符为一个 Token，非 ASCII 字符（如中文）每个字符为一个 Token
+func citationTokens(text string) []string {
+	var tokens []string
+	for _, word := range strings.Fields(text) {
+		start := 0
+		for i, r := range word {
+			if r < utf8.RuneSelf {
+				continue
+			}
+
+			if start < i {
+				tokens = append(tokens, word[start:i])
+			}
+			tokens = append(tokens, string(r))
+			start = i + utf8.RuneLen(r)
+		}
+
+		if start < len(word) {
+			tokens = append(tokens, word[start:])
+		}
+	}
+
+	return tokens
+}
+
+// citationShingles 连续 citationShingleSize 个 Token 组成的片段集合，Token 数量不足时不返回任何片段
+func citationShingles(tokens []string) map[string]struct{} {
+	ret := make(map[string]struct{})
+	for i := 0; i+citationShingleSize <= len(tokens); i++ {
+		ret[strings.Join(tokens[i:i+citationShingleSize], " ")] = struct{}{}
+	}
+
+	return ret
+}
+
+// attachUsedSources 缓存流式响应的输出内容，流结束后追加一个包含引用段落的响应
+func attachUsedSources(ctx context.Context, stream <-chan Response, sources []Source) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		var output strings.Builder
+		for data := range stream {
+			if data.ErrorCode != "" {
+				send(data)
+				return
+			}
+
+			if !data.Interim {
+				output.WriteString(data.Text)
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+
+		if used := FindUsedSources(sources, output.String()); len(used) > 0 {
+			send(Response{UsedSources: used})
+		}
+	})
+}
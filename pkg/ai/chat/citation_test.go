@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+var citationTestSources = []Source{
+	{ID: "doc-1", Title: "Cats", Content: "Cats sleep for about 16 hours a day. They are obligate carnivores."},
+	{ID: "doc-2", Title: "Dogs", Content: "Dogs were domesticated more than 15,000 years ago. Many breeds exist today."},
+	{ID: "doc-3", Title: "长城", Content: "长城东起山海关，西至嘉峪关，全长约两万一千多公里。它是世界文化遗产。"},
+}
+
+func TestFindUsedSources_Quoted(t *testing.T) {
+	output := `According to the provided material, "dogs were domesticated more than 15,000 years ago!" That's a long time.`
+
+	used := FindUsedSources(citationTestSources, output)
+	assert.Equal(t, 1, len(used))
+	assert.Equal(t, "doc-2", used[0].ID)
+	assert.Equal(t, "Dogs", used[0].Title)
+	assert.Equal(t, "Dogs were domesticated more than 15,000 years ago.", used[0].Passage)
+	assert.Equal(t, 1.0, used[0].Score)
+}
+
+func TestFindUsedSources_Chinese(t *testing.T) {
+	used := FindUsedSources(citationTestSources, "资料显示，长城东起山海关，西至嘉峪关，非常壮观。")
+	assert.Equal(t, 1, len(used))
+	assert.Equal(t, "doc-3", used[0].ID)
+	assert.Equal(t, "长城东起山海关，西至嘉峪关，全长约两万一千多公里。", used[0].Passage)
+	assert.True(t, used[0].Score >= minReferencedScore && used[0].Score < 1)
+}
+
+func TestFindUsedSources_NotUsed(t *testing.T) {
+	assert.Equal(t, 0, len(FindUsedSources(citationTestSources, "I don't know much about animals, sorry.")))
+	assert.Equal(t, 0, len(FindUsedSources(citationTestSources, "")))
+}
+
+func TestDispatcher_ChatStreamUsedSources(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{
+		{Text: "Cats sleep for about "},
+		{Text: "16 hours a day", FinishReason: FinishReasonStop},
+	}}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	stream, err := d.ChatStream(context.TODO(), Request{
+		Model:             "gpt-4",
+		Messages:          Messages{{Role: RoleUser, Content: "How long do cats sleep?"}},
+		Sources:           citationTestSources,
+		ReturnUsedSources: true,
+	})
+	assert.NoError(t, err)
+
+	var responses []Response
+	for res := range stream {
+		responses = append(responses, res)
+	}
+
+	// 输出结束后，追加一个包含引用段落的响应
+	assert.Equal(t, 3, len(responses))
+	last := responses[2]
+	assert.Equal(t, "", last.Text)
+	assert.Equal(t, 1, len(last.UsedSources))
+	assert.Equal(t, "doc-1", last.UsedSources[0].ID)
+	assert.Equal(t, "Cats sleep for about 16 hours a day.", last.UsedSources[0].Passage)
+
+	// 未开启时不返回
+	stream, err = d.ChatStream(context.TODO(), Request{
+		Model:    "gpt-4",
+		Messages: Messages{{Role: RoleUser, Content: "How long do cats sleep?"}},
+		Sources:  citationTestSources,
+	})
+	assert.NoError(t, err)
+	for res := range stream {
+		assert.Equal(t, 0, len(res.UsedSources))
+	}
+}
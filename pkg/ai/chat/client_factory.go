@@ -0,0 +1,206 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/ai/oneapi"
+	"github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/ai/openrouter"
+	"github.com/mylxsw/aidea-server/pkg/proxy"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/aidea-server/pkg/youdao"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/glacier/infra"
+)
+
+// ChannelQuerier 查询渠道信息
+type ChannelQuerier interface {
+	Channel(ctx context.Context, id int64) (*repo.Channel, error)
+}
+
+// ClientFactory 根据服务提供商创建（选择）对应的客户端
+type ClientFactory interface {
+	// Client 返回服务提供商对应的客户端，同时返回服务提供商的类型（渠道类型）
+	Client(ctx context.Context, provider repo.ModelProvider) (Chat, string)
+}
+
+// clientResolver 创建客户端失败时返回错误的 ClientFactory（ClientFactory.Client 失败时使用其它服务提供商代替），
+// 用于不能随意更换服务提供商的场景，如文本补全接口
+type clientResolver interface {
+	ResolveClient(ctx context.Context, provider repo.ModelProvider) (Chat, string, error)
+}
+
+// ClientBuilder 根据渠道配置动态创建客户端
+type ClientBuilder func(ch *repo.Channel) Chat
+
+type clientFactory struct {
+	channels ChannelQuerier
+	// secrets 渠道密钥解密
+	secrets *ChannelSecrets
+	// dynamic 支持动态配置的渠道类型（根据数据库 channels 中的配置创建客户端）
+	dynamic map[string]ClientBuilder
+	// static 使用配置文件配置的服务提供商
+	static map[string]Chat
+}
+
+func NewClientFactory(conf *config.Config, resolver infra.Resolver, svc *service.Service, ai *AI, secrets *ChannelSecrets) ClientFactory {
+	var proxyDialer *proxy.Proxy
+	if conf.SupportProxy() {
+		resolver.MustResolve(func(pp *proxy.Proxy) {
+			proxyDialer = pp
+		})
+	}
+
+	return &clientFactory{
+		channels: svc.Chat,
+		secrets:  secrets,
+		dynamic: map[string]ClientBuilder{
+			service.ProviderOpenAI: func(ch *repo.Channel) Chat {
+				return createOpenAIClient(ch, proxyDialer, conf.ChatMaxResponseSizeBytes())
+			},
+			service.ProviderOneAPI: func(ch *repo.Channel) Chat {
+				return createOneAPIClient(ch, proxyDialer, resolver, conf.ChatMaxResponseSizeBytes())
+			},
+			service.ProviderOpenRouter: func(ch *repo.Channel) Chat {
+				return createOpenRouterClient(ch, proxyDialer, conf.ChatMaxResponseSizeBytes())
+			},
+		},
+		static: map[string]Chat{
+			service.ProviderOpenAI:     ai.OpenAI,
+			service.ProviderXunFei:     ai.Xfyun,
+			service.ProviderWenXin:     ai.Baidu,
+			service.ProviderDashscope:  ai.DashScope,
+			service.ProviderSenseNova:  ai.SenseNova,
+			service.ProviderTencent:    ai.Tencent,
+			service.ProviderBaiChuan:   ai.Baichuan,
+			service.Provider360:        ai.GPT360,
+			service.ProviderOneAPI:     ai.OneAPI,
+			service.ProviderOpenRouter: ai.Openrouter,
+			service.ProviderSky:        ai.Sky,
+			service.ProviderZhipu:      ai.Zhipu,
+			service.ProviderMoonshot:   ai.Moonshot,
+			service.ProviderGoogle:     ai.Google,
+			service.ProviderAnthropic:  ai.Anthropic,
+		},
+	}
+}
+
+// Client 选择合适的 AI 服务提供商
+//
+// 并不是所有类型的渠道都支持动态配置（根据数据库 channels 中的配置创建客户端），目前只有 openai/oneapi/openrouter 支持
+// 首先 根据 Channel ID 选择对应的 AI 服务提供商，如果 Channel ID 不存在或者对应的 AI 服务提供商不支持，则根据 Model ID 选择对应的 AI 服务提供商
+// 如果 Model ID 也不存在或者对应的 AI 服务提供商不支持，则使用 OpenAI 作为默认的 AI 服务提供商
+func (f *clientFactory) Client(ctx context.Context, provider repo.ModelProvider) (Chat, string) {
+	imp, typ, err := f.ResolveClient(ctx, provider)
+	if err == nil {
+		return imp, typ
+	}
+
+	if ret, ok := f.static[provider.Name]; ok {
+		log.F(log.M{"provider": provider}).Errorf("%v, using provider %s instead", err, provider.Name)
+		return ret, provider.Name
+	}
+
+	log.F(log.M{"provider": provider}).Errorf("%v, using openai instead", err)
+
+	return f.static[service.ProviderOpenAI], service.ProviderOpenAI
+}
+
+// ResolveClient 与 Client 相同，但是渠道查询失败或者服务提供商不支持时返回错误，不使用其它服务提供商代替
+func (f *clientFactory) ResolveClient(ctx context.Context, provider repo.ModelProvider) (Chat, string, error) {
+	if provider.ID > 0 {
+		ch, err := f.channels.Channel(ctx, provider.ID)
+		if err == nil {
+			ch, err = f.secrets.Resolve(ctx, ch)
+		}
+
+		if err != nil {
+			return nil, "", fmt.Errorf("get channel %d failed: %w", provider.ID, err)
+		}
+
+		if build, ok := f.dynamic[ch.Type]; ok {
+			return build(ch), ch.Type, nil
+		}
+
+		if ret, ok := f.static[ch.Type]; ok {
+			return ret, ch.Type, nil
+		}
+	}
+
+	if ret, ok := f.static[provider.Name]; ok {
+		return ret, provider.Name, nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported provider: %s", provider.Name)
+}
+
+// channelMaxResponseSize 渠道的非流式响应最大字节数，渠道未指定时使用 defaultSize
+func channelMaxResponseSize(ch *repo.Channel, defaultSize int64) int64 {
+	if ch.Meta.MaxResponseSize > 0 {
+		return int64(ch.Meta.MaxResponseSize) * 1024 * 1024
+	}
+
+	return defaultSize
+}
+
+// createOpenAIClient 创建一个 OpenAI Client
+func createOpenAIClient(ch *repo.Channel, proxyDialer *proxy.Proxy, maxResponseSize int64) Chat {
+	conf := openai.Config{
+		Enable:          true,
+		OpenAIServers:   []string{ch.Server},
+		OpenAIKeys:      []string{ch.Secret},
+		AutoProxy:       ch.Meta.UsingProxy,
+		MaxResponseSize: channelMaxResponseSize(ch, maxResponseSize),
+		UserAgent:       ch.Meta.UserAgent,
+		ExtraBody:       ch.Meta.ExtraBody,
+	}
+
+	if ch.Meta.OpenAIAzure {
+		conf.OpenAIAzure = true
+		conf.OpenAIAPIVersion = ch.Meta.OpenAIAzureAPIVersion
+	}
+
+	return NewOpenAIChat(openai.NewOpenAIClient(&conf, proxyDialer))
+}
+
+// createOneAPIClient 创建一个 OneAPI Client
+func createOneAPIClient(ch *repo.Channel, proxyDialer *proxy.Proxy, resolver infra.Resolver, maxResponseSize int64) Chat {
+	conf := openai.Config{
+		Enable:          true,
+		OpenAIServers:   []string{ch.Server},
+		OpenAIKeys:      []string{ch.Secret},
+		AutoProxy:       ch.Meta.UsingProxy,
+		MaxResponseSize: channelMaxResponseSize(ch, maxResponseSize),
+		UserAgent:       ch.Meta.UserAgent,
+		ExtraBody:       ch.Meta.ExtraBody,
+	}
+
+	var trans youdao.Translater
+	_ = resolver.Resolve(func(t youdao.Translater) {
+		trans = t
+	})
+
+	return NewOneAPIChat(oneapi.New(openai.NewOpenAIClient(&conf, proxyDialer), trans))
+}
+
+// createOpenRouterClient 创建一个 OpenRouter Client
+func createOpenRouterClient(ch *repo.Channel, proxyDialer *proxy.Proxy, maxResponseSize int64) Chat {
+	if ch.Server == "" {
+		ch.Server = "https://openrouter.ai/api/v1"
+	}
+
+	conf := openai.Config{
+		Enable:          true,
+		OpenAIServers:   []string{ch.Server},
+		OpenAIKeys:      []string{ch.Secret},
+		AutoProxy:       ch.Meta.UsingProxy,
+		MaxResponseSize: channelMaxResponseSize(ch, maxResponseSize),
+		UserAgent:       ch.Meta.UserAgent,
+		ExtraBody:       ch.Meta.ExtraBody,
+	}
+
+	return NewOpenRouterChat(openrouter.NewOpenRouter(openai.NewOpenAIClient(&conf, proxyDialer)))
+}
@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/secret"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// fakeChannelQuerier 使用固定的渠道列表模拟渠道查询
+type fakeChannelQuerier map[int64]*repo.Channel
+
+func (q fakeChannelQuerier) Channel(ctx context.Context, id int64) (*repo.Channel, error) {
+	if ch, ok := q[id]; ok {
+		return ch, nil
+	}
+
+	return nil, errors.New("channel not found")
+}
+
+func newTestChannel(id int64, typ string) *repo.Channel {
+	return &repo.Channel{Channels: model.Channels{Id: id, Type: typ}}
+}
+
+func TestClientFactory_Client(t *testing.T) {
+	openaiClient := &recordChatClient{}
+	anthropicClient := &recordChatClient{}
+	dynamicClient := &recordChatClient{}
+
+	var built []*repo.Channel
+	factory := &clientFactory{
+		channels: fakeChannelQuerier{
+			1: newTestChannel(1, service.ProviderOpenAI),
+			2: newTestChannel(2, service.ProviderAnthropic),
+			3: newTestChannel(3, "unknown"),
+		},
+		dynamic: map[string]ClientBuilder{
+			service.ProviderOpenAI: func(ch *repo.Channel) Chat {
+				built = append(built, ch)
+				return dynamicClient
+			},
+		},
+		static: map[string]Chat{
+			service.ProviderOpenAI:    openaiClient,
+			service.ProviderAnthropic: anthropicClient,
+		},
+	}
+
+	// 支持动态配置的渠道，根据渠道配置创建客户端
+	imp, typ := factory.Client(context.TODO(), repo.ModelProvider{ID: 1})
+	assert.True(t, imp == Chat(dynamicClient))
+	assert.Equal(t, service.ProviderOpenAI, typ)
+	assert.Equal(t, 1, len(built))
+	assert.EqualValues(t, 1, built[0].Id)
+
+	// 不支持动态配置的渠道，使用渠道类型对应的固定客户端
+	imp, typ = factory.Client(context.TODO(), repo.ModelProvider{ID: 2})
+	assert.True(t, imp == Chat(anthropicClient))
+	assert.Equal(t, service.ProviderAnthropic, typ)
+
+	// 渠道类型不支持时，根据服务提供商名称选择
+	imp, typ = factory.Client(context.TODO(), repo.ModelProvider{ID: 3, Name: service.ProviderAnthropic})
+	assert.True(t, imp == Chat(anthropicClient))
+	assert.Equal(t, service.ProviderAnthropic, typ)
+
+	// 渠道查询失败时，根据服务提供商名称选择
+	imp, typ = factory.Client(context.TODO(), repo.ModelProvider{ID: 100, Name: service.ProviderAnthropic})
+	assert.True(t, imp == Chat(anthropicClient))
+	assert.Equal(t, service.ProviderAnthropic, typ)
+
+	// 服务提供商不支持时，使用 OpenAI
+	imp, typ = factory.Client(context.TODO(), repo.ModelProvider{Name: "unknown"})
+	assert.True(t, imp == Chat(openaiClient))
+	assert.Equal(t, service.ProviderOpenAI, typ)
+
+	assert.Equal(t, 1, len(built))
+}
+
+func TestClientFactory_EncryptedSecret(t *testing.T) {
+	ctx := context.TODO()
+	envelope := secret.NewEnvelopeWithMasterKey("master-key")
+
+	encrypted, err := envelope.Encrypt(ctx, "sk-channel-secret")
+	assert.NoError(t, err)
+
+	ch := newTestChannel(1, service.ProviderOpenAI)
+	ch.Secret = encrypted
+
+	var built []*repo.Channel
+	factory := &clientFactory{
+		channels: fakeChannelQuerier{1: ch},
+		secrets:  NewChannelSecrets(envelope),
+		dynamic: map[string]ClientBuilder{
+			service.ProviderOpenAI: func(ch *repo.Channel) Chat {
+				built = append(built, ch)
+				return &recordChatClient{}
+			},
+		},
+		static: map[string]Chat{service.ProviderOpenAI: &recordChatClient{}},
+	}
+
+	// 使用解密后的密钥创建客户端，不修改查询到的渠道信息
+	factory.Client(ctx, repo.ModelProvider{ID: 1})
+	assert.Equal(t, "sk-channel-secret", built[0].Secret)
+	assert.Equal(t, encrypted, ch.Secret)
+
+	// 密钥更新后，缓存的解密结果失效
+	ch.Secret, err = envelope.Encrypt(ctx, "sk-channel-secret-updated")
+	assert.NoError(t, err)
+
+	factory.Client(ctx, repo.ModelProvider{ID: 1})
+	assert.Equal(t, "sk-channel-secret-updated", built[1].Secret)
+
+	// 无法解密时，不使用该渠道
+	factory.secrets = NewChannelSecrets(secret.NewEnvelopeWithMasterKey("another-key"))
+	factory.Client(ctx, repo.ModelProvider{ID: 1})
+	assert.Equal(t, 2, len(built))
+}
+
+func TestCreateClient_UserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "ok"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	builders := map[string]func(ch *repo.Channel) Chat{
+		service.ProviderOpenAI:     func(ch *repo.Channel) Chat { return createOpenAIClient(ch, nil, 0) },
+		service.ProviderOpenRouter: func(ch *repo.Channel) Chat { return createOpenRouterClient(ch, nil, 0) },
+	}
+
+	for typ, build := range builders {
+		t.Run(typ, func(t *testing.T) {
+			req := Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+
+			// 未配置时使用默认的 User-Agent
+			ch := &repo.Channel{Channels: model.Channels{Type: typ, Server: server.URL, Secret: "sk-test"}}
+			_, err := build(ch).Chat(context.TODO(), req)
+			assert.NoError(t, err)
+			assert.Equal(t, openai.DefaultUserAgent, userAgent)
+
+			ch.Meta.UserAgent = "Mozilla/5.0 (compatible; AIdea)"
+			_, err = build(ch).Chat(context.TODO(), req)
+			assert.NoError(t, err)
+			assert.Equal(t, "Mozilla/5.0 (compatible; AIdea)", userAgent)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// CoalesceStream 合并流式响应中的文本片段，减少向客户端写入的次数
+//
+// 部分服务提供商每个 Token 返回一个片段，较长的回答需要经过大量的写入，在网络较差的移动端上会明显拖慢渲染速度。
+// 这里缓存文本片段，满足以下任意一个条件时一起输出：距离第一个缓存的片段已经过去 interval、缓存的字节数达到 maxBytes、
+// 片段中包含换行。包含文本之外内容的响应（结束原因、Token 用量、工具调用等）在输出缓存的文本之后立即输出，不会被延迟。
+// 合并只会拼接文本，不会丢弃或者修改任何内容。interval 小于等于 0 时不做处理。
+func CoalesceStream(ctx context.Context, stream <-chan Response, interval time.Duration, maxBytes int) <-chan Response {
+	return coalesceStream(ctx, stream, interval, func(text string) int { return len(text) }, maxBytes, true)
+}
+
+// AggregateStream 合并流式响应中的文本片段，减少 WebSocket 帧的数量
+//
+// 与 CoalesceStream 相同，区别在于按照 Token 数量（近似值，参考 splitPacingTokens）而不是字节数限制缓存的文本，
+// 并且遇到换行时不会立即输出：距离第一个缓存的片段已经过去 interval 或者缓存的 Token 数量达到 maxTokens 时一起输出。
+// 结束原因等非文本的响应以及流结束时，立即输出缓存的文本。interval 小于等于 0 时不做处理。
+func AggregateStream(ctx context.Context, stream <-chan Response, interval time.Duration, maxTokens int) <-chan Response {
+	return coalesceStream(ctx, stream, interval, func(text string) int { return len(splitPacingTokens(text)) }, maxTokens, false)
+}
+
+// coalesceStream 缓存文本片段，间隔时间到达、缓存的大小（由 size 计算）达到 limit（大于 0 时）
+// 或者 flushOnNewline 为 true 时片段中包含换行时一起输出
+func coalesceStream(ctx context.Context, stream <-chan Response, interval time.Duration, size func(text string) int, limit int, flushOnNewline bool) <-chan Response {
+	if interval <= 0 {
+		return stream
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		var pending strings.Builder
+		// pendingSize 缓存的文本的大小
+		var pendingSize int
+		// deadline 缓存的文本最晚输出的时间，没有缓存的文本时为 nil
+		var deadline <-chan time.Time
+		flush := func() bool {
+			deadline = nil
+			if pending.Len() == 0 {
+				return true
+			}
+
+			text := pending.String()
+			pending.Reset()
+			pendingSize = 0
+
+			return send(Response{Text: text})
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case data, ok := <-stream:
+				if !ok {
+					flush()
+					return
+				}
+
+				if !isPlainTextResponse(data) {
+					// 先输出缓存的文本，当前响应保持原样立即输出（错误等响应中的文本可能会被区别处理，不能合并）
+					if !flush() || !send(data) {
+						return
+					}
+					continue
+				}
+
+				if data.Text == "" {
+					continue
+				}
+
+				if pending.Len() == 0 {
+					deadline = time.After(interval)
+				}
+				pending.WriteString(data.Text)
+				pendingSize += size(data.Text)
+
+				if (limit > 0 && pendingSize >= limit) || (flushOnNewline && strings.Contains(data.Text, "\n")) {
+					if !flush() {
+						return
+					}
+				}
+			case <-deadline:
+				if !flush() {
+					return
+				}
+			}
+		}
+	})
+}
+
+// isPlainTextResponse 响应中是否只包含文本，只有这样的响应才可以合并
+func isPlainTextResponse(data Response) bool {
+	data.Text = ""
+	return reflect.ValueOf(data).IsZero()
+}
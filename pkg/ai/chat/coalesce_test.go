@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// sliceStream 依次输出 responses 中的响应，每个响应之间等待 gap
+func sliceStream(responses []Response, gap time.Duration) <-chan Response {
+	stream := make(chan Response)
+	go func() {
+		defer close(stream)
+		for _, res := range responses {
+			stream <- res
+			if gap > 0 {
+				time.Sleep(gap)
+			}
+		}
+	}()
+
+	return stream
+}
+
+func collectStream(stream <-chan Response) []Response {
+	var ret []Response
+	for res := range stream {
+		ret = append(ret, res)
+	}
+
+	return ret
+}
+
+func concatText(responses []Response) string {
+	var text strings.Builder
+	for _, res := range responses {
+		text.WriteString(res.Text)
+	}
+
+	return text.String()
+}
+
+func TestCoalesceStream_PreservesContent(t *testing.T) {
+	r := rand.New(rand.NewSource(20261016))
+	pieces := []string{"a", "你", "好", " ", "\n", "hello ", "🙂", "```go\n", "", "世界\n\n", "x"}
+
+	for i := 0; i < 50; i++ {
+		var responses []Response
+		for j := r.Intn(200); j > 0; j-- {
+			responses = append(responses, Response{Text: pieces[r.Intn(len(pieces))]})
+		}
+		responses = append(responses, Response{FinishReason: FinishReasonStop, InputTokens: 10, OutputTokens: len(responses)})
+
+		raw := collectStream(sliceStream(responses, 0))
+		coalesced := collectStream(CoalesceStream(context.TODO(), sliceStream(responses, 0), time.Hour, 1+r.Intn(64)))
+
+		// 合并前后的文本完全一致，最后一个响应为结束原因和 Token 用量
+		assert.Equal(t, concatText(raw), concatText(coalesced))
+		assert.True(t, len(coalesced) <= len(raw))
+
+		last := coalesced[len(coalesced)-1]
+		assert.Equal(t, FinishReasonStop, last.FinishReason)
+		assert.Equal(t, len(responses)-1, last.OutputTokens)
+	}
+}
+
+func TestCoalesceStream_Flush(t *testing.T) {
+	// 字节数达到上限时输出
+	responses := collectStream(CoalesceStream(context.TODO(), sliceStream([]Response{
+		{Text: "ab"}, {Text: "cd"}, {Text: "ef"}, {Text: "g"},
+	}, 0), time.Hour, 4))
+	assert.EqualValues(t, []Response{{Text: "abcd"}, {Text: "efg"}}, responses)
+
+	// 遇到换行时输出
+	responses = collectStream(CoalesceStream(context.TODO(), sliceStream([]Response{
+		{Text: "第一"}, {Text: "行\n第"}, {Text: "二行"},
+	}, 0), time.Hour, 1024))
+	assert.EqualValues(t, []Response{{Text: "第一行\n第"}, {Text: "二行"}}, responses)
+
+	// 间隔时间到达时输出
+	responses = collectStream(CoalesceStream(context.TODO(), sliceStream([]Response{
+		{Text: "a"}, {Text: "b"}, {Text: "c"},
+	}, 60*time.Millisecond), 100*time.Millisecond, 1024))
+	assert.EqualValues(t, []Response{{Text: "ab"}, {Text: "c"}}, responses)
+
+	// 不合并包含其它内容的响应
+	responses = collectStream(CoalesceStream(context.TODO(), sliceStream([]Response{
+		{Text: "a"}, {Text: "b", Warning: "warning"}, {Text: "c"}, {Error: "failed", ErrorCode: "ERR"},
+	}, 0), time.Hour, 1024))
+	assert.EqualValues(t, []Response{{Text: "a"}, {Text: "b", Warning: "warning"}, {Text: "c"}, {Error: "failed", ErrorCode: "ERR"}}, responses)
+}
+
+func TestCoalesceStream_UsageNotDelayed(t *testing.T) {
+	stream := make(chan Response)
+	go func() {
+		defer close(stream)
+
+		stream <- Response{Text: "hello"}
+		stream <- Response{FinishReason: FinishReasonStop, InputTokens: 3, OutputTokens: 1}
+		// 上游在输出 Token 用量之后仍然保持一段时间才结束
+		time.Sleep(500 * time.Millisecond)
+	}()
+
+	start := time.Now()
+	coalesced := CoalesceStream(context.TODO(), stream, time.Hour, 1024)
+
+	assert.Equal(t, Response{Text: "hello"}, <-coalesced)
+	assert.Equal(t, 1, (<-coalesced).OutputTokens)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+
+	for range coalesced {
+	}
+}
+
+func TestCoalesceStream_Disabled(t *testing.T) {
+	stream := make(chan Response)
+	assert.True(t, CoalesceStream(context.TODO(), stream, 0, 1024) == (<-chan Response)(stream))
+}
+
+func TestAggregateStream_PreservesContent(t *testing.T) {
+	r := rand.New(rand.NewSource(20261016))
+	pieces := []string{"a", "你", "好", " ", "\n", "hello ", "🙂", "```go\n", "", "世界\n\n", "x"}
+
+	for i := 0; i < 50; i++ {
+		var responses []Response
+		for j := r.Intn(200); j > 0; j-- {
+			responses = append(responses, Response{Text: pieces[r.Intn(len(pieces))]})
+		}
+		responses = append(responses, Response{FinishReason: FinishReasonStop})
+
+		raw := collectStream(sliceStream(responses, 0))
+		aggregated := collectStream(AggregateStream(context.TODO(), sliceStream(responses, 0), time.Hour, 1+r.Intn(32)))
+
+		assert.Equal(t, concatText(raw), concatText(aggregated))
+		assert.True(t, len(aggregated) <= len(raw))
+		assert.Equal(t, FinishReasonStop, aggregated[len(aggregated)-1].FinishReason)
+	}
+}
+
+func TestAggregateStream_Flush(t *testing.T) {
+	// Token 数量达到上限时输出，换行不会触发输出
+	responses := collectStream(AggregateStream(context.TODO(), sliceStream([]Response{
+		{Text: "你"}, {Text: "好\n"}, {Text: "世"}, {Text: "界"}, {Text: "！"},
+	}, 0), time.Hour, 4))
+	assert.EqualValues(t, []Response{{Text: "你好\n世"}, {Text: "界！"}}, responses)
+
+	// 结束原因之前输出缓存的文本
+	responses = collectStream(AggregateStream(context.TODO(), sliceStream([]Response{
+		{Text: "a"}, {Text: "b"}, {FinishReason: FinishReasonStop},
+	}, 0), time.Hour, 100))
+	assert.EqualValues(t, []Response{{Text: "ab"}, {FinishReason: FinishReasonStop}}, responses)
+}
+
+func TestAggregateStream_Interval(t *testing.T) {
+	// 每 10ms 输出一个 Token，间隔为 100ms 时，每次输出大约 10 个 Token
+	var responses []Response
+	for i := 0; i < 40; i++ {
+		responses = append(responses, Response{Text: "字"})
+	}
+
+	start := time.Now()
+	var flushes []time.Duration
+	for res := range AggregateStream(context.TODO(), sliceStream(responses, 10*time.Millisecond), 100*time.Millisecond, 1000) {
+		assert.True(t, res.Text != "")
+		flushes = append(flushes, time.Since(start))
+	}
+
+	assert.True(t, len(flushes) >= 3 && len(flushes) <= 6)
+	// 第一次输出不早于间隔时间，也不会明显晚于间隔时间
+	assert.True(t, flushes[0] >= 100*time.Millisecond && flushes[0] < 200*time.Millisecond)
+}
+
+func TestAggregateStream_Disabled(t *testing.T) {
+	stream := make(chan Response)
+	assert.True(t, AggregateStream(context.TODO(), stream, 0, 16) == (<-chan Response)(stream))
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"strings"
+)
+
+// continuationRequests 用户要求接着输出的消息内容（不区分大小写，忽略首尾的空白字符与标点）
+var continuationRequests = map[string]bool{
+	"继续":       true,
+	"请继续":      true,
+	"接着说":      true,
+	"请接着说":     true,
+	"继续输出":     true,
+	"continue": true,
+	"go on":    true,
+}
+
+// isContinuationRequest 是否为要求接着上一条回答继续输出的用户消息
+func isContinuationRequest(msg Message) bool {
+	if msg.Role != RoleUser || len(msg.MultipartContents) > 0 {
+		return false
+	}
+
+	content := strings.ToLower(strings.Trim(msg.Content, " \t\r\n.。!！"))
+	return continuationRequests[content]
+}
+
+// endsInCodeBlock 内容是否结束在未闭合的代码块（``` 包围的内容）中
+func endsInCodeBlock(content string) bool {
+	return countCodeFences(content)%2 == 1
+}
+
+// countCodeFences 内容中代码块分隔符（以 ``` 开头的行）的数量
+func countCodeFences(content string) int {
+	var count int
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// joinCodeContinuation 将续写的内容拼接到截断在代码块中的内容之后。
+// 续写的内容通常从截断的位置直接开始；如果续写时重新开启了一个代码块（第一行为代码块分隔符，且自身的代码块是完整的），
+// 则去掉重新开启的分隔符，避免合并之后出现嵌套的代码块
+func joinCodeContinuation(content, continuation string) string {
+	trimmed := strings.TrimLeft(continuation, " \t\r\n")
+	firstLine, rest, _ := strings.Cut(trimmed, "\n")
+	if !strings.HasPrefix(strings.TrimSpace(firstLine), "```") || countCodeFences(continuation)%2 == 1 {
+		return content + continuation
+	}
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return content + rest
+}
+
+// mergeSplitCodeBlocks 合并被拆分的代码块：助手消息因为输出长度限制截断在代码块中间，用户要求继续之后，
+// 代码块的剩余部分在下一条助手消息中。这种情况下将两条助手消息（以及中间要求继续的用户消息）合并为一条完整的助手消息，
+// 多次继续时依次合并。返回新的消息列表，不修改原始消息
+func mergeSplitCodeBlocks(messages Messages) Messages {
+	ret := make(Messages, 0, len(messages))
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+		for msg.Role == RoleAssistant && len(msg.MultipartContents) == 0 && len(msg.ToolCalls) == 0 && endsInCodeBlock(msg.Content) {
+			next := i + 1
+			if next < len(messages) && isContinuationRequest(messages[next]) {
+				next++
+			}
+
+			if next >= len(messages) || messages[next].Role != RoleAssistant || len(messages[next].MultipartContents) > 0 || len(messages[next].ToolCalls) > 0 {
+				break
+			}
+
+			msg.Content = joinCodeContinuation(msg.Content, messages[next].Content)
+			i = next
+		}
+
+		ret = append(ret, msg)
+	}
+
+	return ret
+}
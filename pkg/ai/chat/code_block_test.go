@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestMergeSplitCodeBlocks(t *testing.T) {
+	messages := mergeSplitCodeBlocks(Messages{
+		{Role: RoleUser, Content: "写一个 hello world"},
+		{Role: RoleAssistant, Content: "好的：\n\n```go\nfunc main() {\n\tfmt.Pri"},
+		{Role: RoleUser, Content: "继续"},
+		{Role: RoleAssistant, Content: "ntln(\"hello world\")\n}\n```\n\n运行即可。"},
+		{Role: RoleUser, Content: "改成 Python"},
+	})
+
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, RoleAssistant, messages[1].Role)
+	assert.Equal(t, "好的：\n\n```go\nfunc main() {\n\tfmt.Println(\"hello world\")\n}\n```\n\n运行即可。", messages[1].Content)
+	assert.False(t, endsInCodeBlock(messages[1].Content))
+	assert.Equal(t, "改成 Python", messages[2].Content)
+}
+
+func TestMergeSplitCodeBlocks_Reopened(t *testing.T) {
+	// 续写时重新开启了代码块，合并时去掉重新开启的分隔符；多次继续时依次合并
+	messages := mergeSplitCodeBlocks(Messages{
+		{Role: RoleAssistant, Content: "```python\nprint(1)\n"},
+		{Role: RoleUser, Content: "continue"},
+		{Role: RoleAssistant, Content: "```python\nprint(2)\n```"},
+		{Role: RoleUser, Content: "请接着说。"},
+		{Role: RoleAssistant, Content: "以上"},
+		{Role: RoleUser, Content: "谢谢"},
+	})
+
+	assert.Equal(t, 4, len(messages))
+	assert.Equal(t, "```python\nprint(1)\nprint(2)\n```", messages[0].Content)
+	assert.Equal(t, "请接着说。", messages[1].Content)
+	assert.Equal(t, "以上", messages[2].Content)
+}
+
+func TestMergeSplitCodeBlocks_Unchanged(t *testing.T) {
+	messages := Messages{
+		// 代码块已经闭合
+		{Role: RoleAssistant, Content: "```go\nfmt.Println()\n```"},
+		{Role: RoleUser, Content: "继续"},
+		{Role: RoleAssistant, Content: "好的"},
+		// 下一条用户消息不是要求继续
+		{Role: RoleAssistant, Content: "```go\nfmt.Pri"},
+		{Role: RoleUser, Content: "这段代码有问题"},
+		{Role: RoleAssistant, Content: "抱歉"},
+		// 最后一条消息是要求继续，还没有续写的内容
+		{Role: RoleAssistant, Content: "```go\nfmt.Pri"},
+		{Role: RoleUser, Content: "继续"},
+	}
+
+	assert.EqualValues(t, messages, mergeSplitCodeBlocks(messages))
+}
+
+func TestDispatcher_MergeSplitCodeBlocks(t *testing.T) {
+	client := &streamChatClient{}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+
+	req := Request{Model: "gpt-4o", Messages: Messages{
+		{Role: RoleUser, Content: "打印空行"},
+		{Role: RoleAssistant, Content: "```go\nfmt.Pri"},
+		{Role: RoleUser, Content: "继续"},
+		{Role: RoleAssistant, Content: "ntln()\n```"},
+		{Role: RoleUser, Content: "解释一下"},
+	}}
+
+	// 未开启时保持不变
+	_, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(client.requests[0].Messages))
+
+	d.mergeCodeBlocks = true
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(client.requests[1].Messages))
+	assert.Equal(t, "```go\nfmt.Println()\n```", client.requests[1].Messages[1].Content)
+}
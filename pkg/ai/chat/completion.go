@@ -0,0 +1,257 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrCompletionNotSupported 模型的服务提供商不支持旧版的文本补全接口
+var ErrCompletionNotSupported = errors.New("当前模型的服务提供商不支持文本补全接口")
+
+// CompletionOptions 文本补全请求的参数
+type CompletionOptions struct {
+	// MaxTokens 最大输出 Token 数量，为 0 时使用服务提供商的默认值
+	MaxTokens int
+	// Temperature/TopP 采样参数，为 nil 时使用服务提供商的默认值
+	Temperature *float64
+	TopP        *float64
+	// Stop 停止序列
+	Stop []string
+	// Suffix 补全内容之后的文本，部分模型支持（用于中间插入）
+	Suffix string
+}
+
+// Completer 使用旧版的文本补全接口（/completions）生成内容，用于没有经过对话微调的基础模型（base/instruct）
+type Completer interface {
+	// Complete 以请求-响应的方式补全 prompt 之后的内容
+	Complete(ctx context.Context, model, prompt string, opts CompletionOptions) (*Response, error)
+	// CompleteStream 以流的方式补全 prompt 之后的内容
+	CompleteStream(ctx context.Context, model, prompt string, opts CompletionOptions) (<-chan Response, error)
+}
+
+// completionClient OpenAI 兼容的文本补全接口
+type completionClient interface {
+	Completion(ctx context.Context, request openai.CompletionRequest) (openai.CompletionResponse, error)
+	CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan openai2.CompletionStreamResponse, error)
+}
+
+// openAICompletionClient 将 openai.Client 转换为 completionClient
+type openAICompletionClient struct {
+	oai openai2.Client
+}
+
+func (c openAICompletionClient) Completion(ctx context.Context, request openai.CompletionRequest) (openai.CompletionResponse, error) {
+	return c.oai.CreateCompletion(ctx, request)
+}
+
+func (c openAICompletionClient) CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan openai2.CompletionStreamResponse, error) {
+	return c.oai.CompletionStream(ctx, request)
+}
+
+func (chat *OpenAIChat) Complete(ctx context.Context, model, prompt string, opts CompletionOptions) (*Response, error) {
+	return openAIComplete(ctx, openAICompletionClient{oai: chat.oai}, "openai", strings.TrimPrefix(model, "openai:"), prompt, opts)
+}
+
+func (chat *OpenAIChat) CompleteStream(ctx context.Context, model, prompt string, opts CompletionOptions) (<-chan Response, error) {
+	return openAICompleteStream(ctx, openAICompletionClient{oai: chat.oai}, "openai", strings.TrimPrefix(model, "openai:"), prompt, opts)
+}
+
+func (chat *OneAPIChat) Complete(ctx context.Context, model, prompt string, opts CompletionOptions) (*Response, error) {
+	return openAIComplete(ctx, chat.oai, "oneapi", strings.TrimPrefix(model, "oneapi:"), prompt, opts)
+}
+
+func (chat *OneAPIChat) CompleteStream(ctx context.Context, model, prompt string, opts CompletionOptions) (<-chan Response, error) {
+	return openAICompleteStream(ctx, chat.oai, "oneapi", strings.TrimPrefix(model, "oneapi:"), prompt, opts)
+}
+
+func (chat *OpenRouterChat) Complete(ctx context.Context, model, prompt string, opts CompletionOptions) (*Response, error) {
+	return openAIComplete(ctx, chat.oai, "openrouter", strings.TrimPrefix(model, "openrouter:"), prompt, opts)
+}
+
+func (chat *OpenRouterChat) CompleteStream(ctx context.Context, model, prompt string, opts CompletionOptions) (<-chan Response, error) {
+	return openAICompleteStream(ctx, chat.oai, "openrouter", strings.TrimPrefix(model, "openrouter:"), prompt, opts)
+}
+
+func newOpenAICompletionRequest(model, prompt string, opts CompletionOptions) openai.CompletionRequest {
+	return openai.CompletionRequest{
+		Model:       model,
+		Prompt:      prompt,
+		Suffix:      opts.Suffix,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: float32Value(opts.Temperature),
+		TopP:        float32Value(opts.TopP),
+		Stop:        opts.Stop,
+	}
+}
+
+// openAICompletionResponse 将文本补全接口的响应转换为 Response，只使用第一个候选结果
+func openAICompletionResponse(res openai.CompletionResponse) Response {
+	ret := Response{
+		InputTokens:  res.Usage.PromptTokens,
+		OutputTokens: res.Usage.CompletionTokens,
+	}
+
+	if len(res.Choices) > 0 {
+		ret.Text = res.Choices[0].Text
+		ret.FinishReason = NormalizeFinishReason(res.Choices[0].FinishReason)
+	}
+
+	return ret
+}
+
+func openAIComplete(ctx context.Context, client completionClient, provider, model, prompt string, opts CompletionOptions) (*Response, error) {
+	ctx = withOpenAIExtraBody(ctx, Request{Temperature: opts.Temperature})
+
+	res, err := client.Completion(ctx, newOpenAICompletionRequest(model, prompt, opts))
+	if err != nil {
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
+			log.With(err).Errorf("违反内容安全策略: %s", filterErr.Detail())
+			return nil, filterErr
+		}
+
+		return nil, wrapOpenAIError(provider, err)
+	}
+
+	ret := openAICompletionResponse(res)
+	return &ret, nil
+}
+
+func openAICompleteStream(ctx context.Context, client completionClient, provider, model, prompt string, opts CompletionOptions) (<-chan Response, error) {
+	ctx = withOpenAIExtraBody(ctx, Request{Temperature: opts.Temperature})
+
+	req := newOpenAICompletionRequest(model, prompt, opts)
+	req.Stream = true
+
+	stream, err := client.CompletionStream(ctx, req)
+	if err != nil {
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
+			log.F(log.M{"error": err, "detail": filterErr.Detail(), "model": model}).Errorf("违反内容安全策略")
+			return nil, filterErr
+		}
+
+		return nil, wrapOpenAIError(provider, err)
+	}
+
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case data, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				ret := Response{Error: data.ErrorMessage, ErrorCode: data.Code}
+				if data.Code != "" {
+					var upstreamErr *UpstreamError
+					if errors.As(wrapOpenAIError(provider, data.Err), &upstreamErr) {
+						ret.ErrorCode = data.Code + ":" + upstreamErr.ErrorCode()
+						ret.Upstream = upstreamErr
+					}
+				} else {
+					ret = openAICompletionResponse(*data.CompletionResponse)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case res <- ret:
+				}
+
+				if data.Code != "" {
+					return
+				}
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+// Complete 使用旧版的文本补全接口生成内容，与对话一样根据模型配置（包括渠道的模型允许列表）选择服务提供商，
+// 服务提供商不支持文本补全接口时返回 ErrCompletionNotSupported
+func (d *Dispatcher) Complete(ctx context.Context, model, prompt string, opts CompletionOptions) (*Response, error) {
+	imp, pro, model, err := d.selectCompleter(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := imp.Complete(ctx, model, prompt, opts)
+	if d.health != nil {
+		d.health.Report(pro, err)
+	}
+
+	return res, err
+}
+
+// CompleteStream 以流的方式使用旧版的文本补全接口生成内容，正常结束时最后一个响应一定包含结束原因
+func (d *Dispatcher) CompleteStream(ctx context.Context, model, prompt string, opts CompletionOptions) (<-chan Response, error) {
+	imp, pro, model, err := d.selectCompleter(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := imp.CompleteStream(ctx, model, prompt, opts)
+	if d.health != nil {
+		d.health.Report(pro, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ensureFinishReason(ctx, stream), nil
+}
+
+// selectCompleter 为文本补全请求选择服务提供商，返回服务提供商的客户端以及模型重写之后的模型名称
+func (d *Dispatcher) selectCompleter(ctx context.Context, model string) (Completer, repo.ModelProvider, string, error) {
+	if strings.TrimSpace(model) == "" {
+		model = d.defaultModel
+	}
+
+	mod, err := d.router.Model(ctx, model)
+	if err != nil {
+		return nil, repo.ModelProvider{}, model, err
+	}
+
+	providers, err := allowedProviders(ctx, d.channels, mod)
+	if err != nil {
+		return nil, repo.ModelProvider{}, model, err
+	}
+	mod.Providers = providers
+
+	pro, _, err := d.selectProvider(ctx, mod, Request{Model: model})
+	if err != nil {
+		return nil, pro, model, err
+	}
+
+	if pro.ModelRewrite != "" {
+		model = pro.ModelRewrite
+	}
+
+	var imp Chat
+	if resolver, ok := d.clients.(clientResolver); ok {
+		if imp, _, err = resolver.ResolveClient(ctx, pro); err != nil {
+			return nil, pro, model, fmt.Errorf("create completion client failed: %w", err)
+		}
+	} else {
+		imp, _ = d.clients.Client(ctx, pro)
+	}
+
+	completer, ok := imp.(Completer)
+	if !ok {
+		return nil, pro, model, ErrCompletionNotSupported
+	}
+
+	return completer, pro, model, nil
+}
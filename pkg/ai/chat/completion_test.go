@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// completionServer 模拟旧版的文本补全接口，记录收到的请求体
+func completionServer(t *testing.T, body *map[string]any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/completions") || strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		*body = nil
+		assert.NoError(t, json.Unmarshal(data, body))
+
+		if stream, _ := (*body)["stream"].(bool); stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: {\"choices\": [{\"index\": 0, \"text\": \"Hello\", \"finish_reason\": null}]}\n\n"))
+			_, _ = w.Write([]byte("data: {\"choices\": [{\"index\": 0, \"text\": \", world\", \"finish_reason\": \"length\"}]}\n\n"))
+			_, _ = w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "text": "Hello, world", "finish_reason": "stop"}], "usage": {"prompt_tokens": 3, "completion_tokens": 4, "total_tokens": 7}}`))
+	}))
+}
+
+func TestOpenAIChat_Complete(t *testing.T) {
+	var body map[string]any
+	server := completionServer(t, &body)
+	defer server.Close()
+
+	imp := createOpenAIClient(&repo.Channel{Channels: model.Channels{Server: server.URL, Secret: "sk-test"}}, nil, 0).(Completer)
+
+	res, err := imp.Complete(context.Background(), "davinci-002", "Say hello:", CompletionOptions{MaxTokens: 16, Temperature: float64Ptr(0), Stop: []string{"\n"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world", res.Text)
+	assert.Equal(t, FinishReasonStop, res.FinishReason)
+	assert.Equal(t, 3, res.InputTokens)
+	assert.Equal(t, 4, res.OutputTokens)
+
+	assert.Equal(t, "davinci-002", body["model"])
+	assert.Equal(t, "Say hello:", body["prompt"])
+	assert.Equal(t, float64(16), body["max_tokens"])
+	// temperature 为 0 时需要明确指定
+	assert.Equal(t, float64(0), body["temperature"])
+	assert.EqualValues(t, []any{"\n"}, body["stop"])
+}
+
+func TestOpenAIChat_CompleteStream(t *testing.T) {
+	var body map[string]any
+	server := completionServer(t, &body)
+	defer server.Close()
+
+	imp := createOpenAIClient(&repo.Channel{Channels: model.Channels{Server: server.URL, Secret: "sk-test"}}, nil, 0).(Completer)
+
+	stream, err := imp.CompleteStream(context.Background(), "davinci-002", "Say hello:", CompletionOptions{})
+	assert.NoError(t, err)
+
+	var text, finishReason string
+	for res := range stream {
+		assert.Equal(t, "", res.Error)
+		text += res.Text
+		if res.FinishReason != "" {
+			finishReason = res.FinishReason
+		}
+	}
+
+	assert.Equal(t, "Hello, world", text)
+	assert.Equal(t, FinishReasonLength, finishReason)
+	assert.Equal(t, true, body["stream"])
+}
+
+func TestDispatcher_Complete(t *testing.T) {
+	var body map[string]any
+	server := completionServer(t, &body)
+	defer server.Close()
+
+	imp := createOpenAIClient(&repo.Channel{Channels: model.Channels{Server: server.URL, Secret: "sk-test"}}, nil, 0)
+	router := fakeModelRouter{
+		"base-model": {
+			Models:    model.Models{ModelId: "base-model"},
+			Providers: []repo.ModelProvider{{Name: "openai", ModelRewrite: "davinci-002"}},
+		},
+	}
+
+	clients := &fakeClientFactory{client: imp}
+	d := NewDispatcher(router, clients, "", PayloadPolicyReject)
+
+	res, err := d.Complete(context.Background(), "base-model", "Say hello:", CompletionOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world", res.Text)
+	assert.Equal(t, "davinci-002", body["model"])
+	assert.Equal(t, 1, len(clients.providers))
+
+	stream, err := d.CompleteStream(context.Background(), "base-model", "Say hello:", CompletionOptions{})
+	assert.NoError(t, err)
+
+	var text string
+	for res := range stream {
+		text += res.Text
+	}
+	assert.Equal(t, "Hello, world", text)
+
+	// 服务提供商不支持文本补全接口
+	d = NewDispatcher(router, &fakeClientFactory{client: &streamChatClient{}}, "", PayloadPolicyReject)
+	_, err = d.Complete(context.Background(), "base-model", "Say hello:", CompletionOptions{})
+	assert.True(t, errors.Is(err, ErrCompletionNotSupported))
+}
+
+func TestDispatcher_CompleteChannelError(t *testing.T) {
+	router := fakeModelRouter{
+		"base-model": {
+			Models:    model.Models{ModelId: "base-model"},
+			Providers: []repo.ModelProvider{{ID: 100, Name: service.ProviderOpenAI}},
+		},
+	}
+
+	// 渠道查询失败时返回原始的错误，不使用其它服务提供商代替
+	factory := &clientFactory{
+		channels: fakeChannelQuerier{},
+		static:   map[string]Chat{service.ProviderOpenAI: &streamChatClient{}},
+	}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+
+	_, err := d.Complete(context.Background(), "base-model", "Say hello:", CompletionOptions{})
+	assert.True(t, err != nil && strings.Contains(err.Error(), "channel not found"))
+	assert.False(t, errors.Is(err, ErrCompletionNotSupported))
+}
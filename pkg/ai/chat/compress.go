@@ -0,0 +1,258 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultCompressionRatio 压缩后的目标长度占原始长度的比例
+	defaultCompressionRatio = 0.3
+	// defaultCompressionKeepTurns 保持原样的最近对话轮数
+	defaultCompressionKeepTurns = 2
+	// compressionCacheTTL 压缩结果的缓存时间
+	compressionCacheTTL = 24 * time.Hour
+)
+
+// compressionPrompt 压缩对话使用的系统提示语
+// compressionSummaryPrefix 替换较早的对话的压缩结果的前缀
+const compressionSummaryPrefix = "Summary of the earlier conversation:\n"
+
+const compressionPrompt = `You compress conversation transcripts. Rewrite the transcript below as a much shorter summary while preserving ALL facts, names, numbers, dates, code identifiers, decisions and open questions. Keep who said what (user or assistant). Do not add anything that is not in the transcript. Reply with the compressed text only, in the original language, in no more than %d tokens.`
+
+// CompressionUsage 压缩请求的资源消耗，命中缓存时为 nil
+type CompressionUsage struct {
+	// Model 压缩使用的辅助模型
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
+// ToCoinUsage 压缩请求的 Token 用量，按照辅助模型 model 的价格计费
+func (u CompressionUsage) ToCoinUsage(model coins.ModelInfo) coins.ChatUsage {
+	return coins.ChatUsage{Model: model, InputTokens: int64(u.InputTokens), OutputTokens: int64(u.OutputTokens)}
+}
+
+// CompressionCache 压缩结果的缓存，key 为待压缩内容的哈希
+type CompressionCache interface {
+	Get(ctx context.Context, key string) (string, bool)
+	Set(ctx context.Context, key string, value string)
+}
+
+// compressionCacheTracker 登记用户使用的压缩结果缓存，用户删除所有聊天记录时一起删除
+type compressionCacheTracker interface {
+	Track(ctx context.Context, userID int64, key string)
+}
+
+// Compressor 长输入压缩
+//
+// 对于输入 Token 价格较高的模型（如 o1、opus），当输入超过模型配置（models.meta.compression）的阈值时，
+// 使用价格较低的辅助模型压缩较早的对话，只保留最近 KeepTurns 轮对话原文。与房间的上下文不同，压缩只对当前
+// 请求生效，不保存任何状态，相同的对话前缀通过缓存避免重复压缩。
+type Compressor struct {
+	chat         Chat
+	defaultModel string
+	cache        CompressionCache
+	countTokens  func(messages Messages, model string) (int, error)
+}
+
+// NewCompressor 创建长输入压缩器，conf.ChatCompressionModel 为默认的辅助模型
+func NewCompressor(conf *config.Config, ch Chat, rds *redis.Client) *Compressor {
+	return &Compressor{
+		chat:         ch,
+		defaultModel: conf.ChatCompressionModel,
+		cache:        &redisCompressionCache{rds: rds},
+		countTokens:  MessageTokenCount,
+	}
+}
+
+// Compress 压缩请求中较早的对话，不满足压缩条件时原样返回
+//
+// 压缩失败时返回原始请求和错误，调用方可以忽略错误继续使用原始请求。
+func (c *Compressor) Compress(ctx context.Context, req Request, meta *repo.CompressionMeta) (Request, *CompressionUsage, error) {
+	if meta == nil || meta.Threshold <= 0 {
+		return req, nil, nil
+	}
+
+	auxModel := meta.Model
+	if auxModel == "" {
+		auxModel = c.defaultModel
+	}
+
+	if auxModel == "" || auxModel == req.Model {
+		return req, nil, nil
+	}
+
+	inputTokens, err := c.countTokens(req.Messages, req.Model)
+	if err != nil || inputTokens <= meta.Threshold {
+		return req, nil, err
+	}
+
+	keepTurns := meta.KeepTurns
+	if keepTurns <= 0 {
+		keepTurns = defaultCompressionKeepTurns
+	}
+
+	systemMessages, older, recent := splitCompressibleMessages(req.Messages, keepTurns)
+	if len(older) == 0 {
+		return req, nil, nil
+	}
+
+	olderTokens, err := c.countTokens(older, auxModel)
+	if err != nil {
+		return req, nil, err
+	}
+
+	ratio := meta.Ratio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = defaultCompressionRatio
+	}
+
+	targetTokens := max(int(float64(olderTokens)*ratio), 100)
+	transcript := renderTranscript(older)
+	cacheKey := compressionCacheKey(auxModel, targetTokens, transcript)
+
+	compressed, cached := c.cache.Get(ctx, cacheKey)
+
+	var usage *CompressionUsage
+	if !cached {
+		res, err := c.chat.Chat(ctx, Request{
+			Model: auxModel,
+			Messages: Messages{
+				{Role: RoleSystem, Content: fmt.Sprintf(compressionPrompt, targetTokens)},
+				{Role: RoleUser, Content: transcript},
+			},
+			MaxTokens: targetTokens * 2,
+		})
+		if err != nil {
+			return req, nil, fmt.Errorf("compress messages failed: %w", err)
+		}
+
+		if res.ErrorCode != "" {
+			return req, nil, fmt.Errorf("compress messages failed: [%s] %s", res.ErrorCode, res.Error)
+		}
+
+		compressed = strings.TrimSpace(res.Text)
+		usage = &CompressionUsage{Model: auxModel, InputTokens: res.InputTokens, OutputTokens: res.OutputTokens}
+		if usage.InputTokens == 0 {
+			usage.InputTokens, _ = c.countTokens(Messages{{Role: RoleUser, Content: transcript}}, auxModel)
+		}
+		if usage.OutputTokens == 0 {
+			usage.OutputTokens, _ = c.countTokens(Messages{{Role: RoleAssistant, Content: compressed}}, auxModel)
+		}
+
+		if compressed == "" {
+			return req, usage, errors.New("compress messages failed: empty response")
+		}
+
+		c.cache.Set(ctx, cacheKey, compressed)
+	}
+
+	if tracker, ok := c.cache.(compressionCacheTracker); ok {
+		tracker.Track(ctx, req.UserID, cacheKey)
+	}
+
+	// 压缩结果作为最近的对话之前的一轮对话，而不是 system 消息，避免设置了角色提示语时被替换（参考 assembleSystemPrompt）
+	messages := make(Messages, 0, len(systemMessages)+len(recent)+2)
+	messages = append(messages, systemMessages...)
+	messages = append(messages,
+		Message{Role: RoleUser, Content: compressionSummaryPrefix + compressed},
+		Message{Role: RoleAssistant, Content: systemFoldAck},
+	)
+	messages = append(messages, recent...)
+	req.Messages = messages
+
+	return req, usage, nil
+}
+
+// splitCompressibleMessages 将消息拆分为 system 消息、需要压缩的较早消息和保持原样的最近 keepTurns 轮消息
+//
+// 每一轮对话以用户消息开始，最后一条用户消息总是保持原样。
+func splitCompressibleMessages(messages Messages, keepTurns int) (system Messages, older Messages, recent Messages) {
+	var conversation Messages
+	for _, msg := range messages {
+		if msg.Role == RoleSystem {
+			system = append(system, msg)
+		} else {
+			conversation = append(conversation, msg)
+		}
+	}
+
+	split := len(conversation)
+	for i := len(conversation) - 1; i >= 0 && keepTurns > 0; i-- {
+		if conversation[i].Role == RoleUser {
+			split = i
+			keepTurns--
+		}
+	}
+
+	return system, conversation[:split], conversation[split:]
+}
+
+// renderTranscript 将消息转换为文本记录，图片使用占位符代替
+func renderTranscript(messages Messages) string {
+	lines := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		content := msg.Content
+		if len(msg.MultipartContents) > 0 {
+			parts := make([]string, 0, len(msg.MultipartContents))
+			for _, part := range msg.MultipartContents {
+				if part == nil {
+					continue
+				}
+
+				if part.ImageURL != nil {
+					parts = append(parts, "[image]")
+				} else if part.Text != "" {
+					parts = append(parts, part.Text)
+				}
+			}
+
+			content = strings.Join(parts, "\n")
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: %s", msg.Role, content))
+	}
+
+	return strings.Join(lines, "\n\n")
+}
+
+// compressionCacheKey 压缩结果的缓存 key，相同的辅助模型、目标长度和对话内容共用压缩结果
+func compressionCacheKey(model string, targetTokens int, transcript string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%s", model, targetTokens, transcript)))
+	return "chat:compression:" + hex.EncodeToString(sum[:])
+}
+
+// redisCompressionCache 使用 Redis 缓存压缩结果
+type redisCompressionCache struct {
+	rds *redis.Client
+}
+
+func (c *redisCompressionCache) Get(ctx context.Context, key string) (string, bool) {
+	val, err := c.rds.Get(ctx, key).Result()
+	if err != nil {
+		return "", false
+	}
+
+	return val, true
+}
+
+func (c *redisCompressionCache) Set(ctx context.Context, key string, value string) {
+	_ = c.rds.Set(ctx, key, value, compressionCacheTTL).Err()
+}
+
+// Track 压缩结果包含用户的聊天内容，登记到用户的内容缓存中
+func (c *redisCompressionCache) Track(ctx context.Context, userID int64, key string) {
+	service.TrackUserContentCache(ctx, c.rds, userID, key)
+}
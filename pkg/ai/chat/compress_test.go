@@ -0,0 +1,160 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// compressChatClient 记录压缩请求，返回固定的压缩结果
+type compressChatClient struct {
+	ChatTestClient
+	requests []Request
+	err      error
+}
+
+func (c *compressChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.requests = append(c.requests, req)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &Response{Text: " compressed ", InputTokens: 120, OutputTokens: 30}, nil
+}
+
+// memoryCompressionCache 内存中的压缩结果缓存
+type memoryCompressionCache map[string]string
+
+func (c memoryCompressionCache) Get(ctx context.Context, key string) (string, bool) {
+	val, ok := c[key]
+	return val, ok
+}
+
+func (c memoryCompressionCache) Set(ctx context.Context, key string, value string) {
+	c[key] = value
+}
+
+// wordCount 使用单词数量代替 Token 数量，避免依赖 tiktoken
+func wordCount(messages Messages, model string) (int, error) {
+	count := 0
+	for _, msg := range messages {
+		count += len(strings.Fields(msg.Content))
+	}
+
+	return count, nil
+}
+
+func newTestCompressor(client Chat) *Compressor {
+	return &Compressor{
+		chat:         client,
+		defaultModel: "cheap-model",
+		cache:        memoryCompressionCache{},
+		countTokens:  wordCount,
+	}
+}
+
+func compressTestRequest() Request {
+	return Request{
+		Model: "o1",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "system prompt"},
+			{Role: RoleUser, Content: "my name is Alice and I have 3 cats"},
+			{Role: RoleAssistant, Content: "nice to meet you Alice"},
+			{Role: RoleUser, Content: "what about dogs"},
+			{Role: RoleAssistant, Content: "dogs are great too"},
+			{Role: RoleUser, Content: "how many cats do I have"},
+		},
+	}
+}
+
+func TestCompressor_Compress(t *testing.T) {
+	client := &compressChatClient{}
+	compressor := newTestCompressor(client)
+	meta := &repo.CompressionMeta{Threshold: 10, KeepTurns: 2}
+
+	req, usage, err := compressor.Compress(context.Background(), compressTestRequest(), meta)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(client.requests))
+	assert.Equal(t, "cheap-model", client.requests[0].Model)
+	assert.Equal(t, "user: my name is Alice and I have 3 cats\n\nassistant: nice to meet you Alice", client.requests[0].Messages[1].Content)
+
+	assert.Equal(t, &CompressionUsage{Model: "cheap-model", InputTokens: 120, OutputTokens: 30}, usage)
+
+	// system 消息 + 压缩结果（用户消息和助手确认消息） + 最近 2 轮对话原文
+	assert.Equal(t, 6, len(req.Messages))
+	assert.Equal(t, "system prompt", req.Messages[0].Content)
+	assert.Equal(t, RoleUser, req.Messages[1].Role)
+	assert.Equal(t, "Summary of the earlier conversation:\ncompressed", req.Messages[1].Content)
+	assert.Equal(t, RoleAssistant, req.Messages[2].Role)
+	assert.Equal(t, "what about dogs", req.Messages[3].Content)
+	assert.Equal(t, "how many cats do I have", req.Messages[5].Content)
+
+	// 相同的对话前缀命中缓存，不再请求辅助模型，也不产生消耗
+	next := compressTestRequest()
+	next.Messages[5].Content = "and how many dogs"
+	req, usage, err = compressor.Compress(context.Background(), next, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(client.requests))
+	assert.True(t, usage == nil)
+	assert.Equal(t, "Summary of the earlier conversation:\ncompressed", req.Messages[1].Content)
+	assert.Equal(t, "and how many dogs", req.Messages[5].Content)
+}
+
+func TestCompressor_CompressWithPersona(t *testing.T) {
+	compressor := newTestCompressor(&compressChatClient{})
+	req, _, err := compressor.Compress(context.Background(), compressTestRequest(), &repo.CompressionMeta{Threshold: 10, KeepTurns: 2})
+	assert.NoError(t, err)
+
+	// 角色提示语替换请求中的 system 消息，压缩结果仍然发送给服务提供商
+	req.PersonaPrompt = "persona"
+	client := &streamChatClient{}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	_, err = d.Chat(context.Background(), req)
+	assert.NoError(t, err)
+
+	sent := client.requests[0].Messages
+	assert.Equal(t, Message{Role: RoleSystem, Content: "persona"}, sent[0])
+	assert.Equal(t, "Summary of the earlier conversation:\ncompressed", sent[1].Content)
+	assert.Equal(t, "how many cats do I have", sent[len(sent)-1].Content)
+}
+
+func TestCompressor_CompressSkipped(t *testing.T) {
+	client := &compressChatClient{}
+	compressor := newTestCompressor(client)
+	original := compressTestRequest()
+
+	cases := map[string]*repo.CompressionMeta{
+		"not enabled":        nil,
+		"below threshold":    {Threshold: 1000},
+		"nothing to compact": {Threshold: 10, KeepTurns: 3},
+		"same model":         {Threshold: 10, Model: "o1"},
+	}
+
+	for name, meta := range cases {
+		t.Run(name, func(t *testing.T) {
+			req, usage, err := compressor.Compress(context.Background(), original, meta)
+			assert.NoError(t, err)
+			assert.True(t, usage == nil)
+			assert.Equal(t, original, req)
+		})
+	}
+
+	assert.Equal(t, 0, len(client.requests))
+}
+
+func TestCompressor_CompressFailed(t *testing.T) {
+	client := &compressChatClient{err: errors.New("upstream unavailable")}
+	compressor := newTestCompressor(client)
+	original := compressTestRequest()
+
+	req, usage, err := compressor.Compress(context.Background(), original, &repo.CompressionMeta{Threshold: 10})
+	assert.True(t, err != nil)
+	assert.True(t, usage == nil)
+	assert.Equal(t, original, req)
+}
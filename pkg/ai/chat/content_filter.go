@@ -0,0 +1,349 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/baidu"
+	"github.com/mylxsw/aidea-server/pkg/ai/google"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrCodeContentFilter 触发内容安全策略时的统一错误码
+const ErrCodeContentFilter = "CONTENT_FILTER"
+
+// 触发内容安全策略的内容方向
+const (
+	// ContentFilterInput 用户输入的内容
+	ContentFilterInput = "input"
+	// ContentFilterOutput 模型生成的内容
+	ContentFilterOutput = "output"
+)
+
+// 触发内容安全策略的内容分类
+const (
+	ContentFilterCategoryHate       = "hate"
+	ContentFilterCategorySexual     = "sexual"
+	ContentFilterCategoryViolence   = "violence"
+	ContentFilterCategorySelfHarm   = "self_harm"
+	ContentFilterCategoryHarassment = "harassment"
+	ContentFilterCategoryDangerous  = "dangerous"
+	ContentFilterCategoryPolitical  = "political"
+	ContentFilterCategoryUnknown    = "unknown"
+)
+
+// 触发内容安全策略的过滤器，服务提供商的兜底处理使用服务提供商类型
+const (
+	// ContentFilterSourceKeyword 服务端的敏感词检测
+	ContentFilterSourceKeyword = "keyword"
+	ContentFilterSourceOpenAI  = "openai"
+	ContentFilterSourceAzure   = "azure"
+	ContentFilterSourceBaidu   = "baidu"
+	ContentFilterSourceGemini  = "gemini"
+)
+
+var contentFilterCategoryNames = map[string]string{
+	ContentFilterCategoryHate:       "仇恨言论",
+	ContentFilterCategorySexual:     "色情",
+	ContentFilterCategoryViolence:   "暴力",
+	ContentFilterCategorySelfHarm:   "自我伤害",
+	ContentFilterCategoryHarassment: "骚扰",
+	ContentFilterCategoryDangerous:  "危险内容",
+	ContentFilterCategoryPolitical:  "政治敏感",
+}
+
+var contentFilterSourceNames = map[string]string{
+	ContentFilterSourceKeyword: "内容安全检测",
+	ContentFilterSourceOpenAI:  "OpenAI 内容审核",
+	ContentFilterSourceAzure:   "Azure OpenAI 内容过滤",
+	ContentFilterSourceBaidu:   "文心千帆内容安全策略",
+	ContentFilterSourceGemini:  "Gemini 安全策略",
+}
+
+// ContentFilterSpan 命中的内容在原文中的位置（字节偏移量，[Start, End)）
+type ContentFilterSpan struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// ContentFilterError 触发内容安全策略的详细原因，可以使用 errors.Is(err, ErrContentFilter) 判断
+//
+// Reason 和 Spans 包含命中的敏感词等详细信息，只能用于日志和后台管理工具，不能返回给用户，避免用户借此试探过滤规则
+type ContentFilterError struct {
+	// Direction 触发的内容方向：input/output
+	Direction string `json:"direction"`
+	// Category 内容分类，无法确定时为 unknown
+	Category string `json:"category"`
+	// Source 触发拦截的过滤器或服务提供商
+	Source string `json:"source"`
+	// Reason 过滤器返回的原始原因（如严重程度、概率、命中的敏感词）
+	Reason string `json:"-"`
+	// Spans 命中的内容在原文中的位置
+	Spans []ContentFilterSpan `json:"-"`
+}
+
+func (e *ContentFilterError) Error() string {
+	return fmt.Sprintf("%s [%s]", ErrContentFilter.Error(), e.ErrorCode())
+}
+
+// Is 兼容 errors.Is(err, ErrContentFilter)
+func (e *ContentFilterError) Is(target error) bool {
+	return target == ErrContentFilter
+}
+
+// ErrorCode 返回统一错误码，附带内容方向、分类和过滤器，如 CONTENT_FILTER:input:hate:azure
+func (e *ContentFilterError) ErrorCode() string {
+	return strings.Join([]string{ErrCodeContentFilter, e.Direction, e.categoryOrUnknown(), e.Source}, ":")
+}
+
+// Message 返回给用户的提示信息，不包含命中的敏感词
+func (e *ContentFilterError) Message() string {
+	subject := "您的输入内容"
+	if e.Direction == ContentFilterOutput {
+		subject = "生成的内容"
+	}
+
+	source, ok := contentFilterSourceNames[e.Source]
+	if !ok {
+		source = "服务提供商的内容安全策略"
+	}
+
+	if name, ok := contentFilterCategoryNames[e.Category]; ok {
+		return fmt.Sprintf("%s涉及「%s」，已被%s拦截", subject, name, source)
+	}
+
+	return fmt.Sprintf("%s已被%s拦截", subject, source)
+}
+
+// Detail 包含原始原因和命中位置的错误详情，只能用于日志和后台管理工具
+func (e *ContentFilterError) Detail() string {
+	detail := e.Error()
+	if e.Reason != "" {
+		detail += "\nreason: " + e.Reason
+	}
+
+	for _, span := range e.Spans {
+		detail += fmt.Sprintf("\nspan: [%d, %d) %s", span.Start, span.End, span.Text)
+	}
+
+	return detail
+}
+
+// Attach 将拦截原因附加到响应中：Error 为给用户的提示信息，ErrorCode 为统一错误码，结束原因为 content_filter
+func (e *ContentFilterError) Attach(res Response) Response {
+	res.Error = e.Message()
+	res.ErrorCode = e.ErrorCode()
+	res.FinishReason = FinishReasonContentFilter
+	res.ContentFilter = e
+
+	return res
+}
+
+func (e *ContentFilterError) categoryOrUnknown() string {
+	if e.Category == "" {
+		return ContentFilterCategoryUnknown
+	}
+
+	return e.Category
+}
+
+// ContentFilterMessage 返回触发内容安全策略时给用户的提示信息，没有详细原因时使用 ErrContentFilter 的描述
+func ContentFilterMessage(err error) string {
+	var filterErr *ContentFilterError
+	if errors.As(err, &filterErr) {
+		return filterErr.Message()
+	}
+
+	return ErrContentFilter.Error()
+}
+
+// NewKeywordFilterError 创建敏感词检测的拦截原因，记录敏感词在 content 中的所有位置
+func NewKeywordFilterError(direction, category, content string, words []string) *ContentFilterError {
+	ret := &ContentFilterError{
+		Direction: direction,
+		Category:  category,
+		Source:    ContentFilterSourceKeyword,
+		Reason:    strings.Join(words, ","),
+	}
+
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+
+		for offset := 0; offset < len(content); {
+			index := strings.Index(content[offset:], word)
+			if index < 0 {
+				break
+			}
+
+			start := offset + index
+			ret.Spans = append(ret.Spans, ContentFilterSpan{Start: start, End: start + len(word), Text: word})
+			offset = start + len(word)
+		}
+	}
+
+	return ret
+}
+
+// azureContentFilterError 根据 Azure OpenAI 返回的内容过滤结果创建拦截原因
+func azureContentFilterError(direction string, results openai.ContentFilterResults) *ContentFilterError {
+	ret := &ContentFilterError{Direction: direction, Source: ContentFilterSourceAzure}
+
+	categories := []struct {
+		name     string
+		filtered bool
+		severity string
+	}{
+		{ContentFilterCategoryHate, results.Hate.Filtered, results.Hate.Severity},
+		{ContentFilterCategorySexual, results.Sexual.Filtered, results.Sexual.Severity},
+		{ContentFilterCategoryViolence, results.Violence.Filtered, results.Violence.Severity},
+		{ContentFilterCategorySelfHarm, results.SelfHarm.Filtered, results.SelfHarm.Severity},
+	}
+
+	var reasons []string
+	for _, cat := range categories {
+		if !cat.filtered {
+			continue
+		}
+
+		if ret.Category == "" {
+			ret.Category = cat.name
+		}
+
+		reasons = append(reasons, fmt.Sprintf("%s=%s", cat.name, cat.severity))
+	}
+
+	ret.Reason = strings.Join(reasons, ",")
+	return ret
+}
+
+// openAIContentFilterError 转换 OpenAI（包括 Azure OpenAI）返回的内容过滤错误，不是内容过滤错误时返回 nil
+func openAIContentFilterError(err error) *ContentFilterError {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.InnerError != nil && apiErr.InnerError.Code == "ResponsibleAIPolicyViolation" {
+			return azureContentFilterError(ContentFilterInput, apiErr.InnerError.ContentFilterResults)
+		}
+
+		if fmt.Sprintf("%v", apiErr.Code) == "content_filter" {
+			return &ContentFilterError{Direction: ContentFilterInput, Source: ContentFilterSourceOpenAI, Reason: apiErr.Message}
+		}
+	}
+
+	if strings.Contains(err.Error(), "content management policy") {
+		return &ContentFilterError{Direction: ContentFilterInput, Source: ContentFilterSourceAzure, Reason: err.Error()}
+	}
+
+	return nil
+}
+
+// openAIStreamContentFilterError 流式响应以 content_filter 结束时，根据 Azure OpenAI 返回的内容过滤结果创建拦截原因，没有触发时返回 nil
+func openAIStreamContentFilterError(choices []openai.ChatCompletionStreamChoice) *ContentFilterError {
+	for _, choice := range choices {
+		if NormalizeFinishReason(string(choice.FinishReason)) != FinishReasonContentFilter {
+			continue
+		}
+
+		ret := azureContentFilterError(ContentFilterOutput, choice.ContentFilterResults)
+		if ret.Category == "" {
+			ret.Source = ContentFilterSourceOpenAI
+		}
+
+		return ret
+	}
+
+	return nil
+}
+
+// openAIStreamResponse 创建 OpenAI 流式响应对应的 Response，以 content_filter 结束时附带拦截原因
+func openAIStreamResponse(text string, choices []openai.ChatCompletionStreamChoice) Response {
+	ret := Response{Text: text, FinishReason: openAIFinishReason(choices)}
+	if filterErr := openAIStreamContentFilterError(choices); filterErr != nil {
+		return filterErr.Attach(ret)
+	}
+
+	return ret
+}
+
+// baiduContentFilterError 文心千帆提示用户输入存在安全风险时创建拦截原因，没有触发时返回 nil
+func baiduContentFilterError(res *baidu.ChatResponse) *ContentFilterError {
+	if !res.NeedClearHistory {
+		return nil
+	}
+
+	reason := "ban_round=-1（当前问题）"
+	if res.BanRound != -1 {
+		reason = fmt.Sprintf("ban_round=%d", res.BanRound)
+	}
+
+	return &ContentFilterError{Direction: ContentFilterInput, Source: ContentFilterSourceBaidu, Reason: reason}
+}
+
+// geminiCategories Gemini 的安全分类与统一分类的对应关系
+var geminiCategories = map[string]string{
+	"HARM_CATEGORY_HATE_SPEECH":       ContentFilterCategoryHate,
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": ContentFilterCategorySexual,
+	"HARM_CATEGORY_HARASSMENT":        ContentFilterCategoryHarassment,
+	"HARM_CATEGORY_DANGEROUS_CONTENT": ContentFilterCategoryDangerous,
+}
+
+// geminiContentFilterError Gemini 拦截输入（promptFeedback.blockReason）或者因安全原因结束输出（finishReason 为 SAFETY）时创建拦截原因，
+// 没有触发时返回 nil
+func geminiContentFilterError(res *google.Response) *ContentFilterError {
+	if res.PromptFeedback != nil && res.PromptFeedback.BlockReason != "" {
+		ret := &ContentFilterError{Direction: ContentFilterInput, Source: ContentFilterSourceGemini}
+		ret.Category, ret.Reason = geminiSafetyReason(res.PromptFeedback.SafetyRatings)
+		ret.Reason = strings.TrimPrefix(ret.Reason+",blockReason="+res.PromptFeedback.BlockReason, ",")
+		return ret
+	}
+
+	for _, candidate := range res.Candidates {
+		if strings.ToUpper(candidate.FinishReason) != "SAFETY" {
+			continue
+		}
+
+		ret := &ContentFilterError{Direction: ContentFilterOutput, Source: ContentFilterSourceGemini}
+		ret.Category, ret.Reason = geminiSafetyReason(candidate.SafetyRatings)
+		return ret
+	}
+
+	return nil
+}
+
+// geminiSafetyReason 返回被拦截的安全分类（第一个被拦截的分类）以及所有分类的概率
+func geminiSafetyReason(ratings []google.SafetyRating) (string, string) {
+	var category string
+	var reasons []string
+	for _, rating := range ratings {
+		reasons = append(reasons, fmt.Sprintf("%s=%s", rating.Category, rating.Probability))
+		if category == "" && (rating.Blocked || rating.Probability == "HIGH") {
+			category = geminiCategories[rating.Category]
+		}
+	}
+
+	return category, strings.Join(reasons, ",")
+}
+
+// withContentFilterReason 服务提供商只返回了 content_filter 结束原因时，补充兜底的拦截原因（输出内容，分类未知），source 为服务提供商类型
+func withContentFilterReason(res Response, source string) Response {
+	if res.FinishReason != FinishReasonContentFilter || res.ErrorCode != "" {
+		return res
+	}
+
+	return (&ContentFilterError{Direction: ContentFilterOutput, Source: source}).Attach(res)
+}
+
+// attachContentFilterReason 为流式响应中只返回了 content_filter 结束原因的响应补充拦截原因，参考 withContentFilterReason
+func attachContentFilterReason(ctx context.Context, stream <-chan Response, source string) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		for data := range stream {
+			if !send(withContentFilterReason(data, source)) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/baidu"
+	"github.com/mylxsw/aidea-server/pkg/ai/google"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestNewKeywordFilterError(t *testing.T) {
+	err := NewKeywordFilterError(ContentFilterInput, ContentFilterCategoryPolitical, "abc 违禁词 def 违禁词", []string{"违禁词", ""})
+	assert.True(t, errors.Is(err, ErrContentFilter))
+	assert.Equal(t, "CONTENT_FILTER:input:political:keyword", err.ErrorCode())
+	assert.Equal(t, "您的输入内容涉及「政治敏感」，已被内容安全检测拦截", err.Message())
+
+	// 命中的敏感词只出现在详情中
+	assert.False(t, strings.Contains(err.Error(), "违禁词"))
+	assert.False(t, strings.Contains(err.Message(), "违禁词"))
+	assert.Equal(t, []ContentFilterSpan{{Start: 4, End: 13, Text: "违禁词"}, {Start: 18, End: 27, Text: "违禁词"}}, err.Spans)
+	assert.True(t, strings.Contains(ErrorDetail(err), "span: [4, 13) 违禁词"))
+}
+
+func TestOpenAIChat_ContentFilter(t *testing.T) {
+	// Azure OpenAI 拦截输入
+	client := &fakeOpenAIClient{
+		err: &openai.APIError{
+			Code:           "content_filter",
+			Message:        "The response was filtered",
+			Type:           "invalid_request_error",
+			HTTPStatusCode: http.StatusBadRequest,
+			InnerError: &openai.InnerError{
+				Code: "ResponsibleAIPolicyViolation",
+				ContentFilterResults: openai.ContentFilterResults{
+					Violence: openai.Violence{Filtered: true, Severity: "medium"},
+				},
+			},
+		},
+	}
+
+	_, err := NewOpenAIChat(client).Chat(context.TODO(), Request{Model: "gpt-3.5-turbo", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.True(t, errors.Is(err, ErrContentFilter))
+
+	var filterErr *ContentFilterError
+	assert.True(t, errors.As(err, &filterErr))
+	assert.Equal(t, "CONTENT_FILTER:input:violence:azure", filterErr.ErrorCode())
+	assert.Equal(t, "您的输入内容涉及「暴力」，已被Azure OpenAI 内容过滤拦截", ContentFilterMessage(err))
+	assert.True(t, strings.Contains(ErrorDetail(err), "violence=medium"))
+
+	// Azure OpenAI 拦截输出
+	client = &fakeOpenAIClient{
+		chunks: []openai2.ChatStreamResponse{
+			{ChatResponse: &openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: "hello"}}}}},
+			{ChatResponse: &openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{
+				FinishReason:         "content_filter",
+				ContentFilterResults: openai.ContentFilterResults{Hate: openai.Hate{Filtered: true, Severity: "high"}},
+			}}}},
+		},
+	}
+
+	stream, err := NewOpenAIChat(client).ChatStream(context.TODO(), Request{Model: "gpt-3.5-turbo", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	assert.Equal(t, "hello", responses[0].Text)
+
+	last := responses[len(responses)-1]
+	assert.Equal(t, "CONTENT_FILTER:output:hate:azure", last.ErrorCode)
+	assert.Equal(t, FinishReasonContentFilter, last.FinishReason)
+	assert.Equal(t, "生成的内容涉及「仇恨言论」，已被Azure OpenAI 内容过滤拦截", last.Error)
+	assert.Equal(t, "hate=high", last.ContentFilter.Reason)
+}
+
+func TestBaiduContentFilterError(t *testing.T) {
+	assert.True(t, baiduContentFilterError(&baidu.ChatResponse{Result: "ok"}) == nil)
+
+	err := baiduContentFilterError(&baidu.ChatResponse{NeedClearHistory: true, BanRound: -1})
+	assert.Equal(t, "CONTENT_FILTER:input:unknown:baidu", err.ErrorCode())
+	assert.Equal(t, "您的输入内容已被文心千帆内容安全策略拦截", err.Message())
+	assert.Equal(t, "ban_round=-1（当前问题）", err.Reason)
+}
+
+func TestGeminiContentFilterError(t *testing.T) {
+	assert.True(t, geminiContentFilterError(&google.Response{Candidates: []google.Candidate{{FinishReason: "STOP"}}}) == nil)
+
+	// 拦截输入
+	err := geminiContentFilterError(&google.Response{PromptFeedback: &google.PromptFeedback{
+		BlockReason:   "SAFETY",
+		SafetyRatings: []google.SafetyRating{{Category: "HARM_CATEGORY_HARASSMENT", Probability: "NEGLIGIBLE"}, {Category: "HARM_CATEGORY_SEXUALLY_EXPLICIT", Probability: "HIGH"}},
+	}})
+	assert.Equal(t, "CONTENT_FILTER:input:sexual:gemini", err.ErrorCode())
+	assert.Equal(t, "HARM_CATEGORY_HARASSMENT=NEGLIGIBLE,HARM_CATEGORY_SEXUALLY_EXPLICIT=HIGH,blockReason=SAFETY", err.Reason)
+
+	// 拦截输出
+	err = geminiContentFilterError(&google.Response{Candidates: []google.Candidate{{
+		FinishReason:  "SAFETY",
+		SafetyRatings: []google.SafetyRating{{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "MEDIUM", Blocked: true}},
+	}}})
+	assert.Equal(t, "CONTENT_FILTER:output:dangerous:gemini", err.ErrorCode())
+}
+
+func TestDispatcher_ContentFilterFallback(t *testing.T) {
+	// 服务提供商只返回了 content_filter 结束原因
+	client := &streamChatClient{chunks: []Response{{Text: "你好"}, {FinishReason: "content_filter"}}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{"glm-4": {Models: model.Models{ModelId: "glm-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "glm-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	last := responses[len(responses)-1]
+	assert.Equal(t, "CONTENT_FILTER:output:unknown:"+service.ProviderOpenAI, last.ErrorCode)
+	assert.Equal(t, "生成的内容已被OpenAI 内容审核拦截", last.Error)
+	assert.Equal(t, FinishReasonContentFilter, last.FinishReason)
+}
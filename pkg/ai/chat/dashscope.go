@@ -24,7 +24,7 @@ func NewDashScopeChat(dashscope *dashscope.DashScope, file *file.File) *DashScop
 }
 
 func (ds *DashScopeChat) initRequest(req Request) dashscope.ChatRequest {
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	var systemMessages Messages
 	var contextMessages Messages
@@ -43,25 +43,14 @@ func (ds *DashScopeChat) initRequest(req Request) dashscope.ChatRequest {
 		}
 	}
 
-	contextMessages = contextMessages.Fix()
+	contextMessages = req.fixMessages(contextMessages)
 	if len(systemMessages) > 0 {
-		systemMessage := systemMessages[0]
-		finalSystemMessages := make(Messages, 0)
-
-		systemMessage.Role = "user"
-		finalSystemMessages = append(
-			finalSystemMessages,
-			systemMessage,
-			Message{
-				Role:    "assistant",
-				Content: "好的",
-			},
-		)
-
-		contextMessages = append(finalSystemMessages, contextMessages...)
+		contextMessages = append(foldSystemMessage(systemMessages[0]), contextMessages...)
 	}
 
 	input := dashscope.ChatInput{}
+	// 图片识别精度为 high 时，使用通义千问 VL 模型的高分辨率模式，其它取值忽略
+	highResolution := false
 
 	if req.Model == dashscope.ModelQWenVLPlus || req.Model == dashscope.ModelQWenVLMax || strings.Contains(req.Model, "-vl-") {
 		input.Messages = array.Map(contextMessages, func(msg Message, _ int) dashscope.Message {
@@ -80,6 +69,8 @@ func (ds *DashScopeChat) initRequest(req Request) dashscope.ChatRequest {
 							Text: ct.Text,
 						})
 					} else if ct.ImageURL != nil {
+						highResolution = highResolution || ct.ImageURL.Detail == "high"
+
 						imageURL := ct.ImageURL.URL
 						if strings.HasPrefix(imageURL, "data:") {
 							// 替换为图片 URL
@@ -106,7 +97,7 @@ func (ds *DashScopeChat) initRequest(req Request) dashscope.ChatRequest {
 			}
 
 			return dashscope.Message{
-				Role:    msg.Role,
+				Role:    string(msg.Role),
 				Content: contents,
 			}
 		})
@@ -137,7 +128,8 @@ func (ds *DashScopeChat) initRequest(req Request) dashscope.ChatRequest {
 		Model: strings.TrimPrefix(req.Model, "灵积:"),
 		Input: input,
 		Parameters: dashscope.ChatParameters{
-			EnableSearch: enableSearch,
+			EnableSearch:           enableSearch,
+			VLHighResolutionImages: highResolution,
 		},
 	}
 }
@@ -157,6 +149,7 @@ func (ds *DashScopeChat) Chat(ctx context.Context, req Request) (*Response, erro
 		Text:         resp.Output.Text,
 		InputTokens:  resp.Usage.InputTokens,
 		OutputTokens: resp.Usage.OutputTokens,
+		FinishReason: NormalizeFinishReason(resp.Output.FinishReason),
 	}, nil
 }
 
@@ -195,6 +188,7 @@ func (ds *DashScopeChat) ChatStream(ctx context.Context, req Request) (<-chan Re
 					Text:         strings.TrimPrefix(data.Output.Text, lastMessage),
 					InputTokens:  data.Usage.InputTokens,
 					OutputTokens: data.Usage.OutputTokens,
+					FinishReason: NormalizeFinishReason(data.Output.FinishReason),
 				}:
 				}
 
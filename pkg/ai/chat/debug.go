@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/control"
+)
+
+// DebugRequest 实际发送给上游的请求内容（经过模型重写、系统提示语合并、消息修正以及请求内容大小限制等处理之后），
+// 用于排查“到底向模型发送了什么”的问题
+//
+// 只包含请求内容本身，不包含渠道地址、密钥等服务提供商的配置信息
+type DebugRequest struct {
+	// Provider 服务提供商类型（渠道类型）
+	Provider  string   `json:"provider"`
+	Model     string   `json:"model"`
+	Messages  Messages `json:"messages"`
+	MaxTokens int      `json:"max_tokens,omitempty"`
+	Tools     []Tool   `json:"tools,omitempty"`
+}
+
+func newDebugRequest(req Request, providerType string) *DebugRequest {
+	return &DebugRequest{
+		Provider:  providerType,
+		Model:     req.Model,
+		Messages:  append(Messages{}, req.Messages...),
+		MaxTokens: req.MaxTokens,
+		Tools:     req.Tools,
+	}
+}
+
+// debugEnabled 当前请求是否启用了调试模式
+func debugEnabled(ctx context.Context) bool {
+	return control.FromContext(ctx).Debug
+}
+
+// prependDebugRequest 在响应流的开始位置插入一条包含实际请求内容的中间状态响应
+func prependDebugRequest(ctx context.Context, stream <-chan Response, debugReq *DebugRequest) <-chan Response {
+	return prependResponse(ctx, stream, Response{Interim: true, DebugRequest: debugReq})
+}
+
+// prependResponse 在响应流的开始位置插入一条响应
+func prependResponse(ctx context.Context, stream <-chan Response, first Response) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		if !send(first) {
+			return
+		}
+
+		for data := range stream {
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/control"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func newDebugTestDispatcher(client Chat) *Dispatcher {
+	router := fakeModelRouter{
+		"claude": {
+			Models:    model.Models{ModelId: "claude"},
+			Providers: []repo.ModelProvider{{ID: 10, ModelRewrite: "claude-3-opus", Prompt: "provider"}},
+			Meta:      repo.ModelMeta{Prompt: "model"},
+		},
+	}
+
+	return NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderAnthropic}, "", PayloadPolicyReject)
+}
+
+func newDebugTestRequest() Request {
+	return Request{
+		Model:     "claude",
+		MaxTokens: 100,
+		Messages: Messages{
+			{Role: RoleSystem, Content: "user"},
+			{Role: RoleUser, Content: "继续"},
+		},
+	}
+}
+
+func assertDebugRequest(t *testing.T, sent Request, debugReq *DebugRequest) {
+	t.Helper()
+
+	if debugReq == nil {
+		t.Fatalf("debug request is missing")
+	}
+
+	assert.Equal(t, service.ProviderAnthropic, debugReq.Provider)
+	assert.Equal(t, sent.Model, debugReq.Model)
+	assert.Equal(t, sent.MaxTokens, debugReq.MaxTokens)
+	assert.EqualValues(t, sent.Messages, debugReq.Messages)
+}
+
+func TestDispatcher_ChatDebugRequest(t *testing.T) {
+	client := &streamChatClient{}
+	d := newDebugTestDispatcher(client)
+
+	// 默认不返回请求内容
+	res, err := d.Chat(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+	assert.True(t, res.DebugRequest == nil)
+
+	ctx := control.NewContext(context.TODO(), &control.Control{Debug: true})
+	res, err = d.Chat(ctx, newDebugTestRequest())
+	assert.NoError(t, err)
+
+	// 返回的请求内容与实际发送给上游的一致
+	assertDebugRequest(t, client.requests[1], res.DebugRequest)
+	assert.Equal(t, "claude-3-opus", res.DebugRequest.Model)
+	assert.Equal(t, "model\nprovider\nuser", res.DebugRequest.Messages[0].Content)
+	assert.Equal(t, "请接着说", res.DebugRequest.Messages[1].Content)
+}
+
+func TestDispatcher_ChatStreamDebugRequest(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "你好"}}}
+	d := newDebugTestDispatcher(client)
+
+	// 默认不返回请求内容
+	stream, err := d.ChatStream(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+	for _, res := range assertFinishReasonConformance(t, stream) {
+		assert.True(t, res.DebugRequest == nil)
+	}
+
+	ctx := control.NewContext(context.TODO(), &control.Control{Debug: true})
+	stream, err = d.ChatStream(ctx, newDebugTestRequest())
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	assert.Equal(t, 3, len(responses))
+
+	// 第一条为中间状态的响应，包含实际发送给上游的请求内容
+	assert.True(t, responses[0].Interim)
+	assert.Equal(t, "", responses[0].Text)
+	assertDebugRequest(t, client.requests[1], responses[0].DebugRequest)
+
+	assert.Equal(t, "你好", responses[1].Text)
+	assert.True(t, responses[1].DebugRequest == nil)
+	assert.Equal(t, FinishReasonStop, responses[2].FinishReason)
+}
+
+func TestDispatcher_ChatWithTrace(t *testing.T) {
+	client := &streamChatClient{}
+	d := newDebugTestDispatcher(client)
+
+	sent, res, err := d.ChatWithTrace(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+
+	// 返回的请求经过了所有修正，与实际发送给上游的一致
+	assert.Equal(t, 1, len(client.requests))
+	assert.EqualValues(t, client.requests[0], *sent)
+	assert.Equal(t, "claude-3-opus", sent.Model)
+	assert.Equal(t, 2, len(sent.Messages))
+	assert.Equal(t, "model\nprovider\nuser", sent.Messages[0].Content)
+	assert.Equal(t, "请接着说", sent.Messages[1].Content)
+
+	// 与 Chat 的响应一致
+	expected, err := d.Chat(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+	assert.EqualValues(t, expected, res)
+}
+
+func TestServerToolChat_ChatWithTrace(t *testing.T) {
+	client := &streamChatClient{}
+	var c Chat = NewServerToolChat(newDebugTestDispatcher(client), NewImageTool(&imageToolTestGenerator{}, "dall-e-3", time.Second))
+
+	// 配置了服务端工具时，仍然可以获取实际发送给服务提供商的请求
+	tracer, ok := c.(TraceChat)
+	assert.True(t, ok)
+
+	req := newDebugTestRequest()
+	req.ImageTool = &ImageToolOptions{Remaining: 1}
+	sent, res, err := tracer.ChatWithTrace(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+
+	assert.Equal(t, 1, len(client.requests))
+	assert.EqualValues(t, client.requests[0], *sent)
+	assert.Equal(t, "claude-3-opus", sent.Model)
+	assert.Equal(t, ImageToolName, sent.Tools[0].Function.Name)
+
+	// 请求没有启用服务端工具时直接转发
+	sent, _, err = tracer.ChatWithTrace(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+	assert.EqualValues(t, client.requests[1], *sent)
+	assert.Equal(t, 0, len(sent.Tools))
+}
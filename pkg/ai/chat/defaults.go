@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+)
+
+// RequestDefaults 请求参数的默认值，零值（nil/0/空字符串）表示未指定
+//
+// 默认值来源的优先级为：请求 > 房间（数字人） > 模型（models.meta） > 部署配置（chat-default-*），
+// 只有请求中未指定的参数才会使用默认值，每个参数独立取优先级最高且已指定的来源。
+type RequestDefaults struct {
+	// Temperature 采样温度
+	Temperature *float64 `json:"temperature,omitempty"`
+	// TopP 核采样概率
+	TopP *float64 `json:"top_p,omitempty"`
+	// MaxTokens 最大输出 Token 数量
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// ImageDetail 图片的识别精度：low/high/auto
+	ImageDetail string `json:"image_detail,omitempty"`
+	// ReplyLanguage 回复使用的语言，如 zh-CN，会作为系统提示语追加到请求中
+	ReplyLanguage string `json:"reply_language,omitempty"`
+}
+
+// IsEmpty 是否未指定任何默认值
+func (d RequestDefaults) IsEmpty() bool {
+	return d.Temperature == nil && d.TopP == nil && d.MaxTokens <= 0 && d.ImageDetail == "" && d.ReplyLanguage == ""
+}
+
+// Validate 校验默认值是否合法
+func (d RequestDefaults) Validate() error {
+	if d.Temperature != nil && (*d.Temperature < 0 || *d.Temperature > 2) {
+		return fmt.Errorf("temperature 取值范围为 0-2")
+	}
+
+	if d.TopP != nil && (*d.TopP < 0 || *d.TopP > 1) {
+		return fmt.Errorf("top_p 取值范围为 0-1")
+	}
+
+	if d.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens 不能小于 0")
+	}
+
+	switch d.ImageDetail {
+	case "", "low", "high", "auto":
+	default:
+		return fmt.Errorf("image_detail 只能是 low/high/auto")
+	}
+
+	return nil
+}
+
+// ParseRequestDefaults 解析 JSON 格式的默认值（如房间的 chat_defaults 字段），内容为空时返回零值
+func ParseRequestDefaults(data string) (RequestDefaults, error) {
+	var ret RequestDefaults
+	if strings.TrimSpace(data) == "" {
+		return ret, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), &ret); err != nil {
+		return ret, err
+	}
+
+	return ret, ret.Validate()
+}
+
+// ModelRequestDefaults 模型配置（models.meta）中的默认值
+func ModelRequestDefaults(meta repo.ModelMeta) RequestDefaults {
+	return RequestDefaults{
+		Temperature:   meta.Temperature,
+		TopP:          meta.TopP,
+		MaxTokens:     meta.MaxTokens,
+		ImageDetail:   meta.ImageDetail,
+		ReplyLanguage: meta.ReplyLanguage,
+	}
+}
+
+// DeploymentRequestDefaults 部署配置中的默认值，配置项为 0 表示不指定 temperature/top_p
+func DeploymentRequestDefaults(conf *config.Config) RequestDefaults {
+	ret := RequestDefaults{
+		MaxTokens:     conf.ChatDefaultMaxTokens,
+		ImageDetail:   conf.ChatDefaultImageDetail,
+		ReplyLanguage: conf.ChatDefaultReplyLanguage,
+	}
+
+	if conf.ChatDefaultTemperature > 0 {
+		temperature := conf.ChatDefaultTemperature
+		ret.Temperature = &temperature
+	}
+
+	if conf.ChatDefaultTopP > 0 {
+		topP := conf.ChatDefaultTopP
+		ret.TopP = &topP
+	}
+
+	return ret
+}
+
+// ApplyDefaults 为请求中未指定的参数填充默认值
+//
+// layers 按照优先级从高到低排列，即 房间 → 模型 → 部署配置，对每个参数，请求中已指定时保持不变，
+// 否则使用第一个指定了该参数的来源。图片的识别精度优先使用图片中指定的值，其次是请求的 ImageDetail。
+// 需要在 Fix 之前调用，Fix 计算上下文长度时依赖图片识别精度。
+func (req Request) ApplyDefaults(layers ...RequestDefaults) Request {
+	if req.ImageDetail != "" {
+		req.Messages = applyImageDetail(req.Messages, req.ImageDetail)
+	}
+
+	for _, layer := range layers {
+		if req.Temperature == nil && layer.Temperature != nil {
+			temperature := *layer.Temperature
+			req.Temperature = &temperature
+		}
+
+		if req.TopP == nil && layer.TopP != nil {
+			topP := *layer.TopP
+			req.TopP = &topP
+		}
+
+		if req.MaxTokens <= 0 && layer.MaxTokens > 0 {
+			req.MaxTokens = layer.MaxTokens
+		}
+
+		if req.ReplyLanguage == "" && layer.ReplyLanguage != "" {
+			req.ReplyLanguage = layer.ReplyLanguage
+		}
+
+		if layer.ImageDetail != "" {
+			req.Messages = applyImageDetail(req.Messages, layer.ImageDetail)
+		}
+	}
+
+	return req
+}
+
+// applyImageDetail 为未指定识别精度的图片设置识别精度，返回新的消息列表，不修改原始消息
+func applyImageDetail(messages Messages, detail string) Messages {
+	ret := make(Messages, 0, len(messages))
+	for _, msg := range messages {
+		if len(msg.MultipartContents) > 0 {
+			parts := make([]*MultipartContent, 0, len(msg.MultipartContents))
+			for _, part := range msg.MultipartContents {
+				if part != nil && part.ImageURL != nil && part.ImageURL.URL != "" && part.ImageURL.Detail == "" {
+					imageURL := *part.ImageURL
+					imageURL.Detail = detail
+					part = &MultipartContent{Type: part.Type, Text: part.Text, ImageURL: &imageURL}
+				}
+
+				parts = append(parts, part)
+			}
+
+			msg.MultipartContents = parts
+		}
+
+		ret = append(ret, msg)
+	}
+
+	return ret
+}
+
+// replyLanguagePrompt 回复语言的系统提示语
+func replyLanguagePrompt(lang string) string {
+	if strings.TrimSpace(lang) == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("Please reply in %s unless the user explicitly asks for another language.", lang)
+}
+
+// float32Value 转换为 OpenAI 请求中的采样参数，为 nil 时返回 0（请求中会被忽略，使用服务提供商的默认值）
+func float32Value(val *float64) float32 {
+	if val == nil {
+		return 0
+	}
+
+	return float32(*val)
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func TestRequest_ApplyDefaultsPrecedence(t *testing.T) {
+	room := RequestDefaults{Temperature: float64Ptr(0.5)}
+	model := ModelRequestDefaults(repo.ModelMeta{Temperature: float64Ptr(0.7), TopP: float64Ptr(0.8), MaxTokens: 2048})
+	deployment := DeploymentRequestDefaults(&config.Config{
+		ChatDefaultTemperature:   0.3,
+		ChatDefaultTopP:          0.9,
+		ChatDefaultMaxTokens:     1024,
+		ChatDefaultImageDetail:   "high",
+		ChatDefaultReplyLanguage: "zh-CN",
+	})
+
+	// 请求未指定任何参数：每个参数独立取优先级最高的来源
+	req := Request{}.ApplyDefaults(room, model, deployment)
+	assert.Equal(t, 0.5, *req.Temperature)
+	assert.Equal(t, 0.8, *req.TopP)
+	assert.Equal(t, 2048, req.MaxTokens)
+	assert.Equal(t, "zh-CN", req.ReplyLanguage)
+
+	// 请求中指定的参数优先级最高，包括 temperature 为 0 的情况
+	req = Request{Temperature: float64Ptr(0), MaxTokens: 100}.ApplyDefaults(room, model, deployment)
+	assert.Equal(t, 0.0, *req.Temperature)
+	assert.Equal(t, 0.8, *req.TopP)
+	assert.Equal(t, 100, req.MaxTokens)
+
+	// 没有房间和模型配置时，使用部署配置
+	req = Request{}.ApplyDefaults(RequestDefaults{}, deployment)
+	assert.Equal(t, 0.3, *req.Temperature)
+	assert.Equal(t, 0.9, *req.TopP)
+	assert.Equal(t, 1024, req.MaxTokens)
+
+	// 没有任何默认值时，保持未指定，使用服务提供商的默认值
+	req = Request{}.ApplyDefaults(RequestDefaults{}, DeploymentRequestDefaults(&config.Config{}))
+	assert.True(t, req.Temperature == nil)
+	assert.True(t, req.TopP == nil)
+	assert.Equal(t, 0, req.MaxTokens)
+}
+
+func TestRequest_ApplyDefaultsImageDetail(t *testing.T) {
+	original := &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/a.png"}}
+	req := Request{
+		Messages: Messages{{
+			Role: RoleUser,
+			MultipartContents: []*MultipartContent{
+				{Type: "text", Text: "hello"},
+				original,
+				{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/b.png", Detail: "low"}},
+			},
+		}},
+	}
+
+	ret := req.ApplyDefaults(RequestDefaults{ImageDetail: "auto"}, RequestDefaults{ImageDetail: "high"})
+	parts := ret.Messages[0].MultipartContents
+	assert.Equal(t, "hello", parts[0].Text)
+	// 优先级较高的来源生效，请求中已指定的识别精度保持不变
+	assert.Equal(t, "auto", parts[1].ImageURL.Detail)
+	assert.Equal(t, "low", parts[2].ImageURL.Detail)
+	// 不修改原始请求
+	assert.Equal(t, "", original.ImageURL.Detail)
+
+	// 未配置识别精度时保持为空，Fix 也不会设置默认值，由服务提供商自行处理
+	skipWithoutTiktoken(t)
+	fixed, _, err := req.ApplyDefaults().Fix(ChatTestClient{}, 3, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, "", fixed.Messages[0].MultipartContents[1].ImageURL.Detail)
+}
+
+func TestParseRequestDefaults(t *testing.T) {
+	ret, err := ParseRequestDefaults("")
+	assert.NoError(t, err)
+	assert.True(t, ret.IsEmpty())
+
+	ret, err = ParseRequestDefaults(`{"temperature": 0.3, "image_detail": "high"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.3, *ret.Temperature)
+	assert.Equal(t, "high", ret.ImageDetail)
+
+	for _, data := range []string{`{"temperature": 3}`, `{"top_p": 1.5}`, `{"image_detail": "ultra"}`, `not json`} {
+		if _, err := ParseRequestDefaults(data); err == nil {
+			t.Errorf("expect error for %s", data)
+		}
+	}
+}
+
+func TestRequest_ApplyDefaultsRequestImageDetail(t *testing.T) {
+	req := Request{
+		ImageDetail: "high",
+		Messages: Messages{{
+			Role: RoleUser,
+			MultipartContents: []*MultipartContent{
+				{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/a.png"}},
+				{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/b.png", Detail: "low"}},
+			},
+		}},
+	}
+
+	// 请求指定的默认识别精度优先于房间、模型和部署配置，图片中明确指定的识别精度仍然优先
+	ret := req.ApplyDefaults(RequestDefaults{ImageDetail: "auto"}, RequestDefaults{ImageDetail: "low"})
+	parts := ret.Messages[0].MultipartContents
+	assert.Equal(t, "high", parts[0].ImageURL.Detail)
+	assert.Equal(t, "low", parts[1].ImageURL.Detail)
+
+	// 未指定时使用房间的默认值
+	req.ImageDetail = ""
+	ret = req.ApplyDefaults(RequestDefaults{ImageDetail: "auto"})
+	assert.Equal(t, "auto", ret.Messages[0].MultipartContents[0].ImageURL.Detail)
+
+	assert.NoError(t, Request{ImageDetail: "high"}.Validate(0, RoleContentLimits{}))
+	assert.True(t, Request{ImageDetail: "ultra"}.Validate(0, RoleContentLimits{}) != nil)
+}
@@ -0,0 +1,610 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
+	"github.com/mylxsw/aidea-server/pkg/rate"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/search"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/aidea-server/pkg/uploader"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Dispatcher 对话请求分发，使用 ModelRouter 选择服务提供商，使用 ClientFactory 创建客户端，
+// 在请求发送到上游之前完成请求的修正（模型重写、系统提示语、请求内容大小限制等）
+type Dispatcher struct {
+	router  ModelRouter
+	clients ClientFactory
+	// defaultModel 请求中未指定模型时使用的默认模型
+	defaultModel string
+	// payloadPolicy 请求内容大小超过服务提供商限制时的处理策略
+	payloadPolicy PayloadPolicy
+	// assistantTrim 历史消息中助手消息末尾空白字符的处理策略
+	assistantTrim AssistantTrimPolicy
+	// mergeCodeBlocks 是否合并历史消息中被拆分到两条助手消息中的代码块（参考 mergeSplitCodeBlocks）
+	mergeCodeBlocks bool
+	// files 请求中引用的远程文件的重新存储，为 nil 时不处理
+	files *FileRehoster
+	// templates 提示语模板的存储，为 nil 时请求中不能引用提示语模板
+	templates PromptTemplateStore
+	// health 服务提供商的健康状态，为 nil 时不考虑健康状态
+	health ChannelHealth
+	// latency 渠道最近一段时间内的请求耗时，为 nil 时不记录
+	latency *LatencyRecorder
+	// outputCapFactor 流式输出的 Token 数量上限系数（参考 outputCapLimit），为 0 时不限制
+	outputCapFactor float64
+	// maxOutputTokens 流式输出的 Token 数量绝对上限（参考 absoluteOutputLimit），为 0 时不限制
+	maxOutputTokens int
+	// outputCaps 流式输出超过 Token 数量上限的次数统计，为 nil 时不统计
+	outputCaps *prometheus.CounterVec
+	// tokenReconcile 本地估算的 Token 数量与服务提供商返回的实际用量的对账统计，为 nil 时不对账
+	tokenReconcile *tokenReconcileMetrics
+	// languageRoutes 按照提示语语言选择服务提供商的次数统计，为 nil 时不统计
+	languageRoutes *prometheus.CounterVec
+	// fingerprints 会话中每轮对话的模型指纹记录，为 nil 时不记录
+	fingerprints SessionFingerprints
+	// retryPatterns 可以重试的错误信息匹配规则，为 nil 时不重试
+	retryPatterns RetryPatterns
+	// retryDelay 重试之前的等待时间
+	retryDelay time.Duration
+	// mathDelimiters 输出内容中数学公式的分隔符格式，为空时不转换
+	mathDelimiters MathDelimiterStyle
+	// sanitize 输出内容中 HTML 标签的处理策略（参考 SanitizePolicy），为空时不处理
+	sanitize SanitizePolicy
+	// sanitizeLinkText 处理输出内容时链接文本的最大字符数，为 0 时不限制
+	sanitizeLinkText int
+	// userModels 用户自定义模型的存储，为 nil 时请求中不能使用自定义模型
+	userModels UserModelStore
+	// channels 渠道信息查询，用于检查渠道的模型允许列表以及是否转换多模态消息（flatten_multipart），为 nil 时不检查
+	channels ChannelQuerier
+	// flattened 自动开启 flatten_multipart 的渠道，记录在 FlattenedChannelStore 中
+	flattened *flattenedChannels
+	// models 模型列表查询，所有服务提供商都请求失败时用于推荐其它模型，为 nil 时不推荐
+	models ModelLister
+	// channelOutages 模型的所有服务提供商都请求失败的次数统计，为 nil 时不统计
+	channelOutages *prometheus.CounterVec
+	// channelAvoidance 避开用户不满意的渠道的次数统计，为 nil 时不统计
+	channelAvoidance *prometheus.CounterVec
+	// admission 同时处理的请求数量限制，按照请求的优先级准入，为 nil 时不限制
+	admission   *Admission
+	countTokens func(messages Messages, model string) (int, error)
+}
+
+func NewDispatcher(router ModelRouter, clients ClientFactory, defaultModel string, payloadPolicy PayloadPolicy) *Dispatcher {
+	return &Dispatcher{
+		router:        router,
+		clients:       clients,
+		defaultModel:  defaultModel,
+		payloadPolicy: payloadPolicy,
+		retryDelay:    defaultRetryDelay,
+		flattened:     newFlattenedChannels(),
+		countTokens:   MessageTokenCount,
+	}
+}
+
+func NewChat(conf *config.Config, router ModelRouter, clients ClientFactory, up *uploader.Uploader, templates PromptTemplateStore, svc *service.Service, oai openai2.Client, limitMetrics rate.MetricsSink) Chat {
+	d := NewDispatcher(router, clients, conf.DefaultChatModel, PayloadPolicy(conf.ChatPayloadPolicy))
+	d.templates = templates
+	d.assistantTrim = AssistantTrimPolicy(conf.ChatAssistantTrim)
+	d.mergeCodeBlocks = conf.ChatMergeSplitCodeBlocks
+	d.health = NewHealthTracker(defaultUnhealthyThreshold, defaultUnhealthyCooldown)
+	if conf.ChatLatencyWindow > 0 {
+		d.latency = NewLatencyRecorder(time.Duration(conf.ChatLatencyWindow)*time.Minute, latencyMaxSamples)
+	}
+	if conf.ChatMaxConcurrency > 0 {
+		d.admission = NewAdmission(conf.ChatMaxConcurrency, time.Duration(conf.ChatQueueMaxWait)*time.Second, limitMetrics)
+	}
+	d.outputCapFactor = conf.ChatOutputCapFactor
+	d.maxOutputTokens = conf.ChatMaxOutputTokens
+	d.outputCaps = newOutputCapCounter(prometheus.DefaultRegisterer)
+	d.tokenReconcile = newTokenReconcileMetrics(prometheus.DefaultRegisterer)
+	d.languageRoutes = newLanguageRouteCounter(prometheus.DefaultRegisterer)
+	d.channelOutages = newAllChannelsFailedCounter(prometheus.DefaultRegisterer)
+	d.channelAvoidance = newChannelAvoidanceCounter(prometheus.DefaultRegisterer)
+	d.fingerprints = svc.Chat
+	d.channels = svc.Chat
+	d.userModels = svc.Chat
+	d.models = svc.Chat
+	d.flattened.store = svc.Chat
+
+	retryPatterns, err := ParseRetryPatterns(conf.ChatRetryErrorPatterns)
+	if err != nil {
+		log.Errorf("invalid chat retry error patterns, only status codes are used for retry: %v", err)
+		retryPatterns = RetryPatterns{}
+	}
+	d.retryPatterns = retryPatterns
+
+	if err := MathDelimiterStyle(conf.ChatMathDelimiters).Validate(); err != nil {
+		log.Errorf("math delimiters are not normalized: %v", err)
+	} else {
+		d.mathDelimiters = MathDelimiterStyle(conf.ChatMathDelimiters)
+	}
+
+	if err := SanitizePolicy(conf.ChatOutputSanitize).Validate(); err != nil {
+		log.Errorf("output is not sanitized: %v", err)
+	} else {
+		d.sanitize = SanitizePolicy(conf.ChatOutputSanitize)
+		d.sanitizeLinkText = max(conf.ChatOutputSanitizeLinkText, 0)
+	}
+
+	if conf.ChatFileRehost {
+		d.files = NewFileRehoster(NewUploaderFileStore(up), int64(conf.ChatFileMaxSize)*1024*1024, conf.StorageDomain)
+	}
+
+	// 服务端执行的工具，只对请求中启用了对应工具（Request.ImageTool、Request.WebSearch）的请求生效
+	if tools := newServerTools(conf, oai, up, svc.Security); len(tools) > 0 {
+		return NewServerToolChat(d, tools...)
+	}
+
+	return d
+}
+
+// newServerTools 根据配置创建服务端执行的工具
+func newServerTools(conf *config.Config, oai openai2.Client, up *uploader.Uploader, detector PromptDetector) []ServerTool {
+	var tools []ServerTool
+	if conf.ChatImageToolModel != "" {
+		// 图片描述与创作岛一样需要经过内容安全检测
+		generator := NewOpenAIImageGenerator(oai, up, detector, conf.ChatImageToolModel)
+		tools = append(tools, NewImageTool(generator, conf.ChatImageToolModel, time.Duration(conf.ChatImageToolTimeout)*time.Second))
+	}
+
+	if conf.ChatWebSearchBackend != "" {
+		timeout := time.Duration(conf.ChatWebSearchTimeout) * time.Second
+		backend, err := search.New(search.Config{Backend: conf.ChatWebSearchBackend, Server: conf.ChatWebSearchServer, Key: conf.ChatWebSearchKey}, &http.Client{Timeout: timeout})
+		if err != nil {
+			log.Errorf("web search is disabled: %v", err)
+		} else {
+			tools = append(tools, NewWebSearchTool(backend, search.NewPageFetcher(timeout), WebSearchToolConfig{
+				Results:      conf.ChatWebSearchResults,
+				FetchPages:   conf.ChatWebSearchFetchPages,
+				PageMaxRunes: conf.ChatWebSearchPageMaxRunes,
+				Timeout:      timeout,
+			}))
+		}
+	}
+
+	return tools
+}
+
+func (d *Dispatcher) Chat(ctx context.Context, req Request) (*Response, error) {
+	_, res, err := d.chat(ctx, req)
+	return res, err
+}
+
+// TraceChat 可以同时返回实际发送给服务提供商的请求的 Chat 实现（Dispatcher、ServerToolChat），
+// NewChat 返回的 Chat 实现总是支持，调用方通过类型断言使用
+type TraceChat interface {
+	Chat
+	// ChatWithTrace 与 Chat 相同，同时返回经过所有修正后实际发送给服务提供商的请求
+	ChatWithTrace(ctx context.Context, req Request) (*Request, *Response, error)
+}
+
+// ChatWithTrace 与 Chat 相同，同时返回经过所有修正后实际发送给服务提供商的请求
+//
+// 返回的请求中包含系统提示语、服务提供商的模型名称等内部信息，只能用于内部调试，不能直接返回给普通用户
+func (d *Dispatcher) ChatWithTrace(ctx context.Context, req Request) (*Request, *Response, error) {
+	fixed, res, err := d.chat(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &fixed, res, nil
+}
+
+// chat 发起非流式请求，返回修正后的请求以及响应
+func (d *Dispatcher) chat(ctx context.Context, req Request) (Request, *Response, error) {
+	if d.admission != nil {
+		release, err := d.admission.Acquire(ctx, req.Priority)
+		if err != nil {
+			return req, nil, err
+		}
+		defer release()
+	}
+
+	req, err := ExpandPromptTemplate(ctx, d.templates, req)
+	if err != nil {
+		return req, nil, err
+	}
+
+	var res *Response
+	req, providerType, err := d.dispatch(ctx, req, func(req Request, imp Chat, _ string) (err error) {
+		if n := choiceCount(req); n > 1 {
+			res, err = chatChoices(ctx, imp, req, n)
+		} else {
+			res, err = imp.Chat(ctx, req)
+		}
+
+		return err
+	})
+	if err != nil {
+		logAttempts(req, err)
+		return req, nil, err
+	}
+
+	if debugEnabled(ctx) {
+		res.DebugRequest = newDebugRequest(req, providerType)
+	}
+	if req.attempts != nil {
+		res.Attempts = req.attempts.list()
+	}
+
+	if req.ReturnUsedSources {
+		res.UsedSources = FindUsedSources(req.Sources, res.Text)
+	}
+
+	res.Reproducible = req.reproducible
+	res.addWarnings(req.warnings...)
+	res.TaskProfile = req.TaskProfile
+	res.InputTokenBreakdown = req.InputTokenBreakdown
+	if d.mathDelimiters != "" {
+		res.Text = normalizeMathDelimiters(res.Text, d.mathDelimiters)
+		for i := range res.Choices {
+			res.Choices[i].Text = normalizeMathDelimiters(res.Choices[i].Text, d.mathDelimiters)
+		}
+	}
+	if d.sanitize != "" {
+		res.Text = sanitizeMarkdown(res.Text, d.sanitize, d.sanitizeLinkText)
+		for i := range res.Choices {
+			res.Choices[i].Text = sanitizeMarkdown(res.Choices[i].Text, d.sanitize, d.sanitizeLinkText)
+		}
+	}
+	if req.OutputStyle == OutputStylePlain && req.StripMarkdown {
+		res.Text = stripMarkdown(res.Text)
+		for i := range res.Choices {
+			res.Choices[i].Text = stripMarkdown(res.Choices[i].Text)
+		}
+	}
+	recordSessionFingerprint(ctx, d.fingerprints, req, res)
+	*res = withContentFilterReason(*res, providerType)
+	*res = withToolCallsFinishReason(*res)
+
+	if len(req.Stop) > 0 {
+		res.StoppedBy = resolveStoppedBy(res.StoppedBy, res.FinishReason, res.Text, req.Stop)
+	}
+
+	if req.OutputChecksum {
+		res.OutputSHA256 = outputChecksum(res.Text)
+		for i := range res.Choices {
+			res.Choices[i].OutputSHA256 = outputChecksum(res.Choices[i].Text)
+		}
+	}
+
+	return req, res, nil
+}
+
+// fixRequest 修正请求内容，返回修正后的请求、服务提供商的客户端以及服务提供商类型
+func (d *Dispatcher) fixRequest(ctx context.Context, req Request) (Request, Chat, string, error) {
+	// 合并历史消息中被拆分到两条助手消息中的代码块
+	if d.mergeCodeBlocks {
+		req.Messages = mergeSplitCodeBlocks(req.Messages)
+	}
+
+	// TODO 这里是临时解决方案
+	// 使用微软的 Azure OpenAI 接口时，聊天内容只有“继续”两个字时，会触发风控，导致无法继续对话
+	if !req.NoAutoContinue {
+		req.Messages = array.Map(req.Messages, func(item Message, _ int) Message {
+			content := strings.TrimSpace(item.Content)
+			if content == "继续" {
+				item.Content = "请接着说"
+			}
+
+			return item
+		})
+	}
+	req.Messages = trimAssistantMessages(req.Messages, d.assistantTrim)
+
+	// 用户自定义模型替换为基础模型，之后的处理（包括计费）都使用基础模型
+	req, err := ResolveUserModel(ctx, d.userModels, req)
+	if err != nil {
+		return req, nil, "", err
+	}
+
+	req = req.WithDefaultModel(d.defaultModel)
+	// 计费使用的模型名称（模型重写之前）
+	billingModel := req.Model
+
+	mod, err := d.router.Model(ctx, req.Model)
+	if err != nil {
+		return req, nil, "", err
+	}
+
+	providers, err := allowedProviders(ctx, d.channels, mod)
+	if err != nil {
+		return req, nil, "", err
+	}
+	mod.Providers = providers
+
+	// 故障转移时排除已经请求失败的服务提供商
+	if len(req.failedChannels) > 0 {
+		mod.Providers = array.Filter(mod.Providers, func(item repo.ModelProvider, _ int) bool { return !req.failedChannels[providerKey(item)] })
+		if len(mod.Providers) == 0 {
+			return req, nil, "", errChannelsExhausted
+		}
+	}
+
+	// 助手消息中的图片（生成的图片）转换为用户消息中的图片，需要在选择服务提供商之前处理
+	req.Messages = liftAssistantImages(req.Messages, mod.Meta.Vision, !req.NoAutoContinue)
+
+	pro, degraded, err := d.selectProvider(ctx, mod, req)
+	if err != nil {
+		return req, nil, "", err
+	}
+	req.channel = pro
+
+	// 支持图片的服务提供商都不可用，去掉图片后使用纯文本的服务提供商回答
+	if degraded {
+		req.Messages = stripImages(req.Messages)
+		req.warnings = append(req.warnings, VisionDegradedWarning)
+	}
+
+	// 渠道不支持多模态的消息格式时，转换为纯文本发送，至少能够回答文字部分的问题
+	if hasMultipartContents(req.Messages) && d.shouldFlattenMultipart(ctx, pro) {
+		messages, images := flattenMultipart(req.Messages)
+		req.Messages = messages
+		req.multipartFlattened = true
+		if images {
+			req.warnings = append(req.warnings, MultipartFlattenedWarning)
+		}
+	}
+
+	// 同一个会话的所有轮次使用相同的种子
+	if req.SessionID != "" && req.Seed == nil {
+		seed := sessionSeed(req.SessionID)
+		req.Seed = &seed
+	}
+
+	if pro.ModelRewrite != "" {
+		req.Model = pro.ModelRewrite
+	}
+
+	imp, providerType := d.clients.Client(ctx, pro)
+	req.reproducible = checkReproducible(req, mod.Meta, providerType)
+
+	// 不支持工具调用的服务提供商无法识别上下文中的工具调用，转换为纯文本之后对话仍然可以继续
+	if hasToolHistory(req.Messages) && d.shouldSummarizeToolHistory(ctx, pro, providerType, req) {
+		req.Messages = summarizeToolHistory(req.Messages)
+	}
+
+	userPrompts := array.Map(
+		array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role == RoleSystem }),
+		func(item Message, _ int) string { return item.Text() },
+	)
+	chatMessages := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != RoleSystem })
+
+	prompts := SystemPrompts{
+		Model:    mod.Meta.Prompt,
+		Provider: pro.Prompt,
+		Style:    outputStylePrompt(req.OutputStyle),
+		Persona:  req.PersonaPrompt,
+		User:     userPrompts,
+		Language: replyLanguagePrompt(req.ReplyLanguage),
+	}
+	if degraded {
+		prompts.Notice = visionDegradedPrompt
+	}
+	// 原始模式下只保留用户请求中的 system 消息
+	if req.RawMode {
+		prompts = SystemPrompts{User: userPrompts}
+	}
+
+	systemPrompts := assembleSystemPrompt(prompts, supportMultiSystemPrompts(imp))
+
+	req.Messages = req.fixMessages(append(systemPrompts, chatMessages...))
+
+	// 检查请求内容大小是否超过服务提供商的限制
+	if t, ok := service.LookupChannelType(providerType); ok && t.MaxPayloadSize > 0 {
+		messages, err := limitPayload(req.Messages, providerType, t.MaxPayloadSize, d.payloadPolicy)
+		if err != nil {
+			return req, imp, providerType, err
+		}
+
+		req.Messages = messages
+	}
+
+	// 图片按照低精度识别时提示用户，用户可以指定高精度重新生成，与其它警告信息一起返回
+	if imageDetailDefaulted(providerType, req.Messages) {
+		req.warnings = append(req.warnings, ImageDetailDefaultedWarning)
+	}
+
+	recordEffectiveRequest(ctx, req, pro, providerType)
+
+	if d.health != nil {
+		imp = &healthReportingChat{imp: imp, health: d.health, provider: pro}
+	}
+
+	if recorder.Enabled() {
+		imp = &recordingChat{imp: imp, providerType: providerType}
+	}
+
+	// 只统计渠道的耗时，配置文件中的服务提供商没有渠道 ID
+	if d.latency != nil && pro.ID > 0 {
+		imp = &latencyRecordingChat{imp: imp, recorder: d.latency, channelID: pro.ID}
+	}
+
+	// 每次请求上游（包括重试）分别对账
+	if d.tokenReconcile != nil {
+		imp = &usageReconcileChat{imp: imp, model: billingModel, countTokens: d.countTokens, metrics: d.tokenReconcile}
+	}
+
+	// 调试模式下记录每一次请求上游的结果，包括重试
+	if debugEnabled(ctx) {
+		if req.attempts == nil {
+			req.attempts = &attemptLog{}
+		}
+		imp = &attemptChat{imp: imp, log: req.attempts, provider: pro, providerType: providerType}
+	}
+
+	if d.retryPatterns != nil {
+		imp = &retryChat{imp: imp, providerType: providerType, patterns: d.retryPatterns, delay: d.retryDelay}
+	}
+
+	if script := replyLanguageScript(req.ReplyLanguage); req.EnforceReplyLanguage && script != "" {
+		imp = &replyLanguageChat{imp: imp, language: req.ReplyLanguage, script: script, minTokens: languageCheckMinTokens}
+	}
+
+	// 绝对上限更小时，以绝对上限为准，强制终止时提示用户
+	limit, note := outputCapLimit(req.MaxTokens, mod.Meta.MaxOutput, d.outputCapFactor), ""
+	if abs := absoluteOutputLimit(d.maxOutputTokens, req.OutputTokenLimit); abs > 0 && (limit <= 0 || abs < limit) {
+		limit, note = abs, OutputLimitWarning
+	}
+
+	if limit > 0 {
+		imp = &outputCapChat{
+			imp:          imp,
+			limit:        limit,
+			note:         note,
+			model:        billingModel,
+			provider:     pro,
+			providerType: providerType,
+			countTokens:  d.countTokens,
+			metrics:      d.outputCaps,
+		}
+	}
+
+	return req, imp, providerType, nil
+}
+
+func (d *Dispatcher) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	if d.admission == nil {
+		return d.chatStream(ctx, req)
+	}
+
+	release, err := d.admission.Acquire(ctx, req.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := d.chatStream(ctx, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// 并发名额在响应流结束之后释放
+	return releaseOnDone(ctx, stream, release), nil
+}
+
+func (d *Dispatcher) chatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	req, err := ExpandPromptTemplate(ctx, d.templates, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream <-chan Response
+	req, providerType, err := d.dispatch(ctx, req, func(req Request, imp Chat, providerType string) (err error) {
+		log.F(log.M{"model": req.Model, "message": req.Messages.ToLogEntry()}).Debug("chat stream request")
+
+		process := func(stream <-chan Response) <-chan Response {
+			stream = attachContentFilterReason(ctx, ensureFinishReason(ctx, stream), providerType)
+			if d.mathDelimiters != "" {
+				stream = normalizeMathStream(ctx, stream, d.mathDelimiters)
+			}
+			if d.sanitize != "" {
+				stream = sanitizeStream(ctx, stream, d.sanitize, d.sanitizeLinkText)
+			}
+			if req.OutputStyle == OutputStylePlain && req.StripMarkdown {
+				stream = stripMarkdownStream(ctx, stream)
+			}
+			if len(req.Stop) > 0 {
+				stream = attachStoppedBy(ctx, stream, req.Stop)
+			}
+			if req.ReturnUsedSources && len(req.Sources) > 0 {
+				stream = attachUsedSources(ctx, stream, req.Sources)
+			}
+			if req.InputTokenBreakdown != nil {
+				stream = attachInputTokenBreakdown(ctx, stream, req.InputTokenBreakdown)
+			}
+			// 摘要需要覆盖所有处理之后的输出内容，放在最后
+			if req.OutputChecksum {
+				stream = attachOutputChecksum(ctx, stream)
+			}
+
+			return stream
+		}
+
+		if n := choiceCount(req); n > 1 {
+			stream, err = chatStreamChoices(ctx, imp, req, n, process)
+			return err
+		}
+
+		stream, err = imp.ChatStream(ctx, req)
+		if err == nil {
+			stream = process(stream)
+		}
+
+		return err
+	})
+	if err != nil {
+		logAttempts(req, err)
+		return nil, err
+	}
+
+	if d.fingerprints != nil && req.SessionID != "" {
+		stream = recordStreamFingerprint(ctx, stream, d.fingerprints, req)
+	}
+	if req.attempts != nil {
+		stream = attachAttempts(ctx, stream, req.attempts)
+	}
+	if req.reproducible != nil || len(req.warnings) > 0 || req.TaskProfile != "" {
+		interim := Response{Interim: true, Reproducible: req.reproducible, TaskProfile: req.TaskProfile}
+		interim.addWarnings(req.warnings...)
+		stream = prependResponse(ctx, stream, interim)
+	}
+	if debugEnabled(ctx) {
+		stream = prependDebugRequest(ctx, stream, newDebugRequest(req, providerType))
+	}
+
+	return stream, nil
+}
+
+func (d *Dispatcher) MaxContextLength(model string) int {
+	if strings.TrimSpace(model) == "" && d.defaultModel != "" {
+		model = d.defaultModel
+	}
+
+	ctx := context.Background()
+
+	mod, err := d.router.Model(ctx, model)
+	if err != nil {
+		// 模型信息暂时不可用，使用受限模型的上下文长度，请求分发时会返回错误
+		return restrictedModel(model).Meta.MaxContext
+	}
+
+	if mod.Meta.MaxContext > 0 {
+		return mod.Meta.MaxContext
+	}
+
+	imp, _ := d.clients.Client(ctx, d.router.SelectProvider(ctx, mod))
+	return imp.MaxContextLength(model)
+}
+
+// SupportSystemRole 模型当前选择的服务提供商是否支持 system 角色，用于按照实际发送的消息结构计算上下文长度
+func (d *Dispatcher) SupportSystemRole(model string) bool {
+	if strings.TrimSpace(model) == "" && d.defaultModel != "" {
+		model = d.defaultModel
+	}
+
+	ctx := context.Background()
+
+	mod, err := d.router.Model(ctx, model)
+	if err != nil {
+		return true
+	}
+
+	pro := d.router.SelectProvider(ctx, mod)
+	if pro.ModelRewrite != "" {
+		model = pro.ModelRewrite
+	}
+
+	imp, _ := d.clients.Client(ctx, pro)
+	return supportSystemRole(imp, model)
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// fakeModelRouter 使用固定的模型列表模拟模型路由，总是选择第一个服务提供商
+type fakeModelRouter map[string]repo.Model
+
+func (r fakeModelRouter) Model(ctx context.Context, modelID string) (repo.Model, error) {
+	if mod, ok := r[modelID]; ok {
+		return mod, nil
+	}
+
+	return repo.Model{Models: model.Models{ModelId: modelID}, Providers: []repo.ModelProvider{{Name: service.ProviderOpenAI}}}, nil
+}
+
+func (r fakeModelRouter) SelectProvider(ctx context.Context, mod repo.Model) repo.ModelProvider {
+	return mod.Providers[0]
+}
+
+// fakeClientFactory 所有服务提供商都使用同一个客户端，记录请求的服务提供商
+type fakeClientFactory struct {
+	client    Chat
+	typ       string
+	providers []repo.ModelProvider
+}
+
+func (f *fakeClientFactory) Client(ctx context.Context, provider repo.ModelProvider) (Chat, string) {
+	f.providers = append(f.providers, provider)
+	return f.client, f.typ
+}
+
+// streamChatClient 记录收到的请求，按照预设的内容返回流式响应
+type streamChatClient struct {
+	ChatTestClient
+	chunks   []Response
+	requests []Request
+}
+
+func (c *streamChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.requests = append(c.requests, req)
+	return &Response{Text: "ok"}, nil
+}
+
+func (c *streamChatClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	c.requests = append(c.requests, req)
+
+	res := make(chan Response, len(c.chunks))
+	for _, chunk := range c.chunks {
+		res <- chunk
+	}
+	close(res)
+
+	return res, nil
+}
+
+func TestDispatcher_ChatStream(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "你好"}, {Text: "！"}}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderAnthropic}
+	router := fakeModelRouter{
+		"claude": {
+			Models:    model.Models{ModelId: "claude"},
+			Providers: []repo.ModelProvider{{ID: 10, ModelRewrite: "claude-3-opus", Prompt: "provider"}},
+			Meta:      repo.ModelMeta{Prompt: "model"},
+		},
+	}
+
+	d := NewDispatcher(router, factory, "claude", PayloadPolicyReject)
+	stream, err := d.ChatStream(context.TODO(), Request{
+		Messages: Messages{
+			{Role: RoleSystem, Content: "user"},
+			{Role: RoleUser, Content: "继续"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// 上游没有返回结束原因时，补充 stop
+	responses := assertFinishReasonConformance(t, stream)
+	assert.Equal(t, FinishReasonStop, responses[len(responses)-1].FinishReason)
+
+	// 使用默认模型，并使用服务提供商的模型名称重写
+	assert.Equal(t, 1, len(factory.providers))
+	assert.EqualValues(t, 10, factory.providers[0].ID)
+	assert.Equal(t, 1, len(client.requests))
+
+	req := client.requests[0]
+	assert.Equal(t, "claude-3-opus", req.Model)
+
+	// 不支持多条 system 消息的服务提供商，合并为一条
+	assert.Equal(t, 2, len(req.Messages))
+	assert.Equal(t, RoleSystem, req.Messages[0].Role)
+	assert.Equal(t, "model\nprovider\nuser", req.Messages[0].Content)
+	assert.Equal(t, "请接着说", req.Messages[1].Content)
+}
+
+func TestDispatcher_Chat(t *testing.T) {
+	client := &streamChatClient{}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+
+	d := NewDispatcher(fakeModelRouter{}, factory, "", PayloadPolicyReject)
+	res, err := d.Chat(context.TODO(), Request{
+		Model:         "gpt-4",
+		PersonaPrompt: "persona",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "user"},
+			{Role: RoleUser, Content: "hello"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+
+	// 角色提示语替代用户请求中的 system 消息
+	req := client.requests[0]
+	assert.Equal(t, "gpt-4", req.Model)
+	assert.Equal(t, 2, len(req.Messages))
+	assert.Equal(t, "persona", req.Messages[0].Content)
+}
+
+func TestDispatcher_MaxContextLength(t *testing.T) {
+	factory := &fakeClientFactory{client: &streamChatClient{}}
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderOpenAI}},
+			Meta:      repo.ModelMeta{MaxContext: 8000},
+		},
+	}
+
+	d := NewDispatcher(router, factory, "gpt-4", PayloadPolicyReject)
+
+	// 优先使用模型配置的上下文长度
+	assert.Equal(t, 8000, d.MaxContextLength("gpt-4"))
+	assert.Equal(t, 8000, d.MaxContextLength(""))
+	assert.Equal(t, 0, len(factory.providers))
+
+	// 模型未配置时，使用服务提供商客户端的上下文长度
+	assert.Equal(t, 2048, d.MaxContextLength("gpt-3.5-turbo"))
+	assert.Equal(t, 1, len(factory.providers))
+}
+
+func TestDispatcher_InputTokenBreakdown(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "你好"}, {FinishReason: "stop"}}}
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	breakdown := &InputTokenBreakdown{System: 10, User: 20, Assistant: 30, Tool: 5, Other: 3}
+	req := Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}, InputTokenBreakdown: breakdown}
+
+	// 流式输出时，在包含结束原因的响应中返回
+	stream, err := d.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	assert.True(t, responses[0].InputTokenBreakdown == nil)
+	assert.Equal(t, breakdown, responses[len(responses)-1].InputTokenBreakdown)
+	assert.Equal(t, 68, responses[len(responses)-1].InputTokenBreakdown.Total())
+
+	res, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, breakdown, res.InputTokenBreakdown)
+}
+
+func TestDispatcher_RawMode(t *testing.T) {
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{ID: 1, Prompt: "provider"}},
+			Meta:      repo.ModelMeta{Prompt: "model"},
+		},
+	}
+
+	newRequest := func(raw bool) Request {
+		return Request{
+			Model:         "gpt-4",
+			PersonaPrompt: "persona",
+			OutputStyle:   OutputStylePlain,
+			ReplyLanguage: "en",
+			RawMode:       raw,
+			Messages: Messages{
+				{Role: RoleSystem, Content: "user"},
+				{Role: RoleUser, Content: "hello"},
+			},
+		}
+	}
+
+	// 默认注入模型、服务提供商、输出格式、角色和回复语言的提示语
+	client := &streamChatClient{}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	_, err := d.Chat(context.TODO(), newRequest(false))
+	assert.NoError(t, err)
+
+	messages := client.requests[0].Messages
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, strings.Join([]string{"model", "provider", outputStylePrompt(OutputStylePlain), "persona", replyLanguagePrompt("en")}, "\n"), messages[0].Content)
+
+	// 原始模式下只发送用户自己的消息
+	client = &streamChatClient{}
+	d = NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	_, err = d.Chat(context.TODO(), newRequest(true))
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, Messages{
+		{Role: RoleSystem, Content: "user"},
+		{Role: RoleUser, Content: "hello"},
+	}, client.requests[0].Messages)
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+)
+
+// 模型配置与上游不一致的字段
+const (
+	DriftFieldExists     = "exists"
+	DriftFieldMaxContext = "max_context"
+	DriftFieldVision     = "vision"
+)
+
+// ModelLister 查询模型列表
+type ModelLister interface {
+	Models(ctx context.Context, returnAll bool) []repo.Model
+}
+
+// CapabilityDrift 模型配置（ModelMeta）与上游报告的能力不一致
+type CapabilityDrift struct {
+	ModelID       string `json:"model_id"`
+	ChannelID     int64  `json:"channel_id"`
+	UpstreamModel string `json:"upstream_model"`
+	// Field 不一致的字段：exists/max_context/vision
+	Field string `json:"field"`
+	// Configured 模型配置中的值
+	Configured string `json:"configured"`
+	// Reported 上游报告的值
+	Reported string `json:"reported"`
+}
+
+func (d CapabilityDrift) String() string {
+	return fmt.Sprintf("model %s (channel %d, upstream %s): %s configured as %s, but upstream reports %s", d.ModelID, d.ChannelID, d.UpstreamModel, d.Field, d.Configured, d.Reported)
+}
+
+// DriftDetector 检测模型配置与上游实际能力是否一致
+//
+// 只使用上游的元数据接口（不会产生费用），目前只有 OpenRouter（上下文长度、图片输入、模型是否存在）和 OpenAI（模型是否存在）支持，
+// 其它渠道以及使用配置文件配置的服务提供商无法检测，直接跳过
+type DriftDetector struct {
+	models     ModelLister
+	channels   ChannelQuerier
+	secrets    *ChannelSecrets
+	httpClient *http.Client
+}
+
+func NewDriftDetector(svc *service.Service, secrets *ChannelSecrets) *DriftDetector {
+	return &DriftDetector{
+		models:     svc.Chat,
+		channels:   svc.Chat,
+		secrets:    secrets,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Detect 检测所有启用的模型，返回不一致的配置，检测失败时只记录日志
+func (d *DriftDetector) Detect(ctx context.Context) []CapabilityDrift {
+	type target struct {
+		channelID     int64
+		upstreamModel string
+	}
+
+	drifts := make([]CapabilityDrift, 0)
+	probed := make(map[target]*ProbeResult)
+
+	for _, mod := range d.models.Models(ctx, false) {
+		for _, pro := range mod.Providers {
+			if pro.ID <= 0 {
+				continue
+			}
+
+			upstreamModel := mod.ModelId
+			if pro.ModelRewrite != "" {
+				upstreamModel = pro.ModelRewrite
+			}
+
+			key := target{channelID: pro.ID, upstreamModel: upstreamModel}
+			ret, ok := probed[key]
+			if !ok {
+				ret = d.probe(ctx, pro.ID, upstreamModel)
+				probed[key] = ret
+			}
+
+			if ret == nil {
+				continue
+			}
+
+			for _, drift := range compareCapability(mod, ret) {
+				drift.ChannelID = pro.ID
+				log.F(log.M{"model": drift.ModelID, "channel_id": drift.ChannelID, "field": drift.Field}).Warningf("model capability drift: %s", drift)
+				drifts = append(drifts, drift)
+			}
+		}
+	}
+
+	return drifts
+}
+
+// probe 查询上游的模型信息，无法查询时返回 nil
+func (d *DriftDetector) probe(ctx context.Context, channelID int64, upstreamModel string) *ProbeResult {
+	ch, err := d.channels.Channel(ctx, channelID)
+	if err == nil {
+		ch, err = d.secrets.Resolve(ctx, ch)
+	}
+
+	if err != nil {
+		log.F(log.M{"channel_id": channelID}).Warningf("model capability drift check: query channel failed: %v", err)
+		return nil
+	}
+
+	ret := &ProbeResult{ChannelID: channelID, UpstreamModel: upstreamModel}
+	probeMetadata(ctx, d.httpClient, ch, upstreamModel, ret)
+
+	for _, e := range ret.Errors {
+		log.F(log.M{"channel_id": channelID, "model": upstreamModel}).Warningf("model capability drift check: %s", e)
+	}
+
+	return ret
+}
+
+// compareCapability 比较模型配置与上游报告的能力
+func compareCapability(mod repo.Model, ret *ProbeResult) []CapabilityDrift {
+	drift := func(field, configured, reported string) CapabilityDrift {
+		return CapabilityDrift{
+			ModelID:       mod.ModelId,
+			UpstreamModel: ret.UpstreamModel,
+			Field:         field,
+			Configured:    configured,
+			Reported:      reported,
+		}
+	}
+
+	if ret.Exists != nil && !*ret.Exists {
+		return []CapabilityDrift{drift(DriftFieldExists, "true", "false")}
+	}
+
+	drifts := make([]CapabilityDrift, 0)
+	if ret.ContextLengthSource == ContextLengthFromOpenRouter && mod.Meta.MaxContext > 0 && mod.Meta.MaxContext != ret.ContextLength {
+		drifts = append(drifts, drift(DriftFieldMaxContext, strconv.Itoa(mod.Meta.MaxContext), strconv.Itoa(ret.ContextLength)))
+	}
+
+	if ret.ReportedVision != nil && *ret.ReportedVision != mod.Meta.Vision {
+		drifts = append(drifts, drift(DriftFieldVision, strconv.FormatBool(mod.Meta.Vision), strconv.FormatBool(*ret.ReportedVision)))
+	}
+
+	return drifts
+}
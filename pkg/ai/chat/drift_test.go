@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// fakeModelLister 使用固定的模型列表
+type fakeModelLister []repo.Model
+
+func (l fakeModelLister) Models(ctx context.Context, returnAll bool) []repo.Model {
+	return l
+}
+
+func TestDriftDetector_Detect(t *testing.T) {
+	var openrouterRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/openrouter/models":
+			openrouterRequests.Add(1)
+			_, _ = w.Write([]byte(`{"data":[
+				{"id":"anthropic/claude-3-opus","context_length":200000,"architecture":{"modality":"text+image->text"}},
+				{"id":"openai/gpt-4","context_length":8192,"architecture":{"modality":"text->text"}}
+			]}`))
+		case "/openai/models/gpt-4":
+			_, _ = w.Write([]byte(`{"id":"gpt-4","object":"model"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	openrouter := newTestChannel(1, service.ProviderOpenRouter)
+	openrouter.Server = server.URL + "/openrouter"
+	openai := newTestChannel(2, service.ProviderOpenAI)
+	openai.Server = server.URL + "/openai"
+
+	detector := &DriftDetector{
+		models: fakeModelLister{
+			{
+				// 上游的上下文长度已经变为 200000
+				Models:    model.Models{ModelId: "claude-3-opus"},
+				Meta:      repo.ModelMeta{MaxContext: 100000, Vision: true},
+				Providers: []repo.ModelProvider{{ID: 1, ModelRewrite: "anthropic/claude-3-opus"}},
+			},
+			{
+				// 配置与上游一致
+				Models:    model.Models{ModelId: "gpt-4"},
+				Meta:      repo.ModelMeta{MaxContext: 8192},
+				Providers: []repo.ModelProvider{{ID: 1, ModelRewrite: "openai/gpt-4"}, {ID: 2}},
+			},
+			{
+				// 上游不存在该模型
+				Models:    model.Models{ModelId: "gpt-5"},
+				Meta:      repo.ModelMeta{MaxContext: 8192},
+				Providers: []repo.ModelProvider{{ID: 2}},
+			},
+			{
+				// 相同的渠道与上游模型只查询一次
+				Models:    model.Models{ModelId: "claude-3-opus-vision"},
+				Meta:      repo.ModelMeta{MaxContext: 200000},
+				Providers: []repo.ModelProvider{{ID: 1, ModelRewrite: "anthropic/claude-3-opus"}},
+			},
+			{
+				// 使用配置文件配置的服务提供商无法检测
+				Models:    model.Models{ModelId: "ernie-bot"},
+				Meta:      repo.ModelMeta{MaxContext: 1},
+				Providers: []repo.ModelProvider{{Name: service.ProviderWenXin}},
+			},
+		},
+		channels:   fakeChannelQuerier{1: openrouter, 2: openai},
+		httpClient: server.Client(),
+	}
+
+	drifts := detector.Detect(context.TODO())
+	assert.Equal(t, 3, len(drifts))
+
+	assert.Equal(t, CapabilityDrift{
+		ModelID:       "claude-3-opus",
+		ChannelID:     1,
+		UpstreamModel: "anthropic/claude-3-opus",
+		Field:         DriftFieldMaxContext,
+		Configured:    "100000",
+		Reported:      "200000",
+	}, drifts[0])
+
+	assert.Equal(t, "gpt-5", drifts[1].ModelID)
+	assert.Equal(t, DriftFieldExists, drifts[1].Field)
+
+	assert.Equal(t, "claude-3-opus-vision", drifts[2].ModelID)
+	assert.Equal(t, DriftFieldVision, drifts[2].Field)
+	assert.Equal(t, "false", drifts[2].Configured)
+
+	assert.EqualValues(t, 2, openrouterRequests.Load())
+}
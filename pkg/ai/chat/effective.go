@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+)
+
+// EffectiveRequest 经过 Dispatcher 处理之后实际生效的请求信息，随聊天记录一起保存，
+// 用于排查“生成这个回答时使用的是什么系统提示语”的问题（房间的提示语可能已经被修改）
+type EffectiveRequest struct {
+	// SystemPrompt 合并后的系统提示语（模型、服务提供商、角色、房间等）
+	SystemPrompt string
+	// Model 实际请求的模型（服务提供商模型重写之后）
+	Model string
+	// ChannelID 渠道 ID，使用配置文件中的服务提供商时为 0
+	ChannelID int64
+	// Provider 服务提供商类型
+	Provider string
+	// FinishReason 结束原因，由输出响应的一方通过 RecordFinishReason 记录
+	FinishReason string
+}
+
+// SystemPromptHash 系统提示语的哈希（sha256），没有系统提示语时返回空字符串
+func (e *EffectiveRequest) SystemPromptHash() string {
+	if e.SystemPrompt == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(e.SystemPrompt))
+	return hex.EncodeToString(sum[:])
+}
+
+type effectiveRequestKey struct{}
+
+// WithEffectiveRequest 返回的 EffectiveRequest 会在请求分发时填充，请求重试时保存的是最后一次请求的信息
+func WithEffectiveRequest(ctx context.Context) (context.Context, *EffectiveRequest) {
+	effective := &EffectiveRequest{}
+	return context.WithValue(ctx, effectiveRequestKey{}, effective), effective
+}
+
+// recordEffectiveRequest 记录实际生效的请求信息，ctx 中没有 EffectiveRequest 时不做任何处理
+func recordEffectiveRequest(ctx context.Context, req Request, pro repo.ModelProvider, providerType string) {
+	effective, ok := ctx.Value(effectiveRequestKey{}).(*EffectiveRequest)
+	if !ok {
+		return
+	}
+
+	prompts := make([]string, 0)
+	for _, msg := range req.Messages {
+		if msg.Role == RoleSystem {
+			prompts = append(prompts, msg.Text())
+		}
+	}
+
+	*effective = EffectiveRequest{
+		SystemPrompt: strings.Join(prompts, "\n\n"),
+		Model:        req.Model,
+		ChannelID:    pro.ID,
+		Provider:     providerType,
+	}
+}
+
+// RecordFinishReason 记录输出的结束原因，ctx 中没有 EffectiveRequest 时不做任何处理
+func RecordFinishReason(ctx context.Context, reason string) {
+	if effective, ok := ctx.Value(effectiveRequestKey{}).(*EffectiveRequest); ok {
+		effective.FinishReason = reason
+	}
+}
@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestDispatcher_EffectiveRequest(t *testing.T) {
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{ID: 7, ModelRewrite: "gpt-4-turbo", Prompt: "provider"}},
+			Meta:      repo.ModelMeta{Prompt: "model"},
+		},
+	}
+
+	d := NewDispatcher(router, &fakeClientFactory{client: &streamChatClient{}, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	ctx, effective := WithEffectiveRequest(context.TODO())
+	_, err := d.Chat(ctx, Request{
+		Model: "gpt-4",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "room"},
+			{Role: RoleUser, Content: "hello"},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "gpt-4-turbo", effective.Model)
+	assert.EqualValues(t, 7, effective.ChannelID)
+	assert.Equal(t, service.ProviderOpenAI, effective.Provider)
+	assert.Equal(t, "model\nprovider\nroom", effective.SystemPrompt)
+	assert.Equal(t, 64, len(effective.SystemPromptHash()))
+
+	// 系统提示语变化时，哈希随之变化
+	previousHash := effective.SystemPromptHash()
+	_, err = d.Chat(ctx, Request{
+		Model: "gpt-4",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "room edited"},
+			{Role: RoleUser, Content: "hello"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, previousHash != effective.SystemPromptHash())
+
+	// 没有系统提示语时不计算哈希
+	assert.Equal(t, "", (&EffectiveRequest{}).SystemPromptHash())
+}
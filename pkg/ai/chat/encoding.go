@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrContentTooLong 消息内容的长度超过最大限制，可以使用 errors.Is 判断 ContentTooLongError
+var ErrContentTooLong = errors.New("消息内容长度超过最大限制")
+
+// ContentTooLongError 消息内容的长度（字符数量）超过最大限制
+type ContentTooLongError struct {
+	// Index 超过限制的消息在请求中的位置
+	Index int `json:"index"`
+	// Role 超过限制的消息角色
+	Role Role `json:"role"`
+	// MaxRunes 单条消息允许的最大字符数量
+	MaxRunes int `json:"max_runes"`
+	// Runes 消息内容的字符数量
+	Runes int `json:"runes"`
+}
+
+func (e *ContentTooLongError) Error() string {
+	return fmt.Sprintf("%s（第 %d 条 %s 消息 %d 个字符，%s 消息最多允许 %d 个字符），请缩短输入内容长度", ErrContentTooLong.Error(), e.Index+1, e.Role, e.Runes, e.Role, e.MaxRunes)
+}
+
+// Is 兼容 errors.Is(err, ErrContentTooLong)
+func (e *ContentTooLongError) Is(target error) bool {
+	return target == ErrContentTooLong
+}
+
+// ErrorData 返回给客户端的结构化错误详情
+func (e *ContentTooLongError) ErrorData() any {
+	return e
+}
+
+// RoleContentLimits 每种角色的单条消息内容的最大字符数量，小于等于 0 时不限制
+type RoleContentLimits struct {
+	// Default 所有角色的单条消息内容的最大字符数量，作为没有单独配置的角色的限制，单独配置的限制更大时同样以该值为准
+	Default int
+	// Roles 单独配置的每种角色的限制
+	Roles map[Role]int
+}
+
+// Limit 返回角色的单条消息内容的最大字符数量，为 0 时不限制
+func (l RoleContentLimits) Limit(role Role) int {
+	limit := l.Roles[role]
+	if limit <= 0 || (l.Default > 0 && l.Default < limit) {
+		return max(l.Default, 0)
+	}
+
+	return limit
+}
+
+// normalizeText 规范化消息内容的编码
+//
+// 部分 Android 输入法会输入单独的代理字符、空字符和 BOM，有的服务提供商会直接拒绝这样的请求，
+// 有的会保存下来，导致导出的历史记录损坏。这里将无效的 UTF-8 字节序列（包括编码后的代理字符）替换为 U+FFFD，
+// 去掉空字符和 BOM，并转换为 NFC 形式
+func normalizeText(text string) string {
+	if text == "" {
+		return text
+	}
+
+	text = strings.ToValidUTF8(text, string(utf8.RuneError))
+	text = strings.NewReplacer("\x00", "", "\uFEFF", "").Replace(text)
+
+	return norm.NFC.String(text)
+}
+
+// normalizeMessages 规范化所有消息（包括多模态消息中的文本部分）的编码，不修改原始消息
+func normalizeMessages(messages Messages) Messages {
+	ret := make(Messages, 0, len(messages))
+	for _, msg := range messages {
+		msg.Content = normalizeText(msg.Content)
+		if len(msg.MultipartContents) > 0 {
+			contents := make([]*MultipartContent, 0, len(msg.MultipartContents))
+			for _, part := range msg.MultipartContents {
+				if part != nil && part.Text != "" {
+					copied := *part
+					copied.Text = normalizeText(copied.Text)
+					part = &copied
+				}
+
+				contents = append(contents, part)
+			}
+
+			msg.MultipartContents = contents
+		}
+
+		ret = append(ret, msg)
+	}
+
+	return ret
+}
+
+// contentRunes 消息内容的字符数量，多模态消息包括所有文本部分
+func contentRunes(msg Message) int {
+	count := utf8.RuneCountInString(msg.Content)
+	for _, part := range msg.MultipartContents {
+		if part != nil {
+			count += utf8.RuneCountInString(part.Text)
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestNormalizeText(t *testing.T) {
+	// 空字符和 BOM
+	assert.Equal(t, "你好", normalizeText("\uFEFF你\x00好"))
+	// 无效的 UTF-8 字节序列
+	assert.Equal(t, "ab�cd", normalizeText("ab\xff\xfecd"))
+	// 截断的多字节字符
+	assert.Equal(t, "中�", normalizeText("中\xe6\x96"))
+	// UTF-8 编码的单独代理字符（U+D800）
+	assert.Equal(t, "a�b", normalizeText("a\xed\xa0\x80b"))
+	// 组合字符转换为 NFC 形式
+	assert.Equal(t, "caf\u00e9", normalizeText("cafe\u0301"))
+
+	assert.Equal(t, "正常的内容 normal", normalizeText("正常的内容 normal"))
+	assert.True(t, utf8.ValidString(normalizeText("\xc0\x80\xf5\x80\x80\x80")))
+}
+
+func TestRequestInit_NormalizeMessages(t *testing.T) {
+	part := &MultipartContent{Type: "text", Text: "\uFEFF描述\xffこの画像"}
+	image := &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/a.png"}}
+
+	req := Request{
+		Model: "gpt-4",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "\x00\uFEFF"},
+			{Role: RoleUser, Content: "hello\x00 world\xed\xbf\xbf"},
+			{Role: RoleUser, MultipartContents: []*MultipartContent{part, image, nil}},
+		},
+	}.Init()
+
+	// 规范化之后内容为空的消息被过滤
+	assert.Equal(t, 2, len(req.Messages))
+	assert.Equal(t, "hello world�", req.Messages[0].Content)
+	assert.Equal(t, "描述�この画像", req.Messages[1].MultipartContents[0].Text)
+	assert.Equal(t, image, req.Messages[1].MultipartContents[1])
+
+	// 不修改原始的多模态消息
+	assert.Equal(t, "\uFEFF描述\xffこの画像", part.Text)
+}
+
+func TestRequest_ValidateRoleContentLimits(t *testing.T) {
+	limits := RoleContentLimits{Roles: map[Role]int{RoleSystem: 10, RoleUser: 20}}
+	newRequest := func(system, user string) Request {
+		return Request{Messages: Messages{
+			{Role: RoleSystem, Content: system},
+			{Role: RoleAssistant, Content: strings.Repeat("a", 100)},
+			{Role: RoleUser, Content: user},
+		}}
+	}
+
+	// 未配置限制的角色（assistant）不限制
+	assert.NoError(t, newRequest(strings.Repeat("系", 10), strings.Repeat("用", 20)).Validate(0, limits))
+	assert.NoError(t, newRequest(strings.Repeat("系", 100), strings.Repeat("用", 100)).Validate(0, RoleContentLimits{}))
+
+	// system 消息超过限制
+	err := newRequest(strings.Repeat("系", 11), "hello").Validate(0, limits)
+	assert.True(t, errors.Is(err, ErrContentTooLong))
+
+	var tooLong *ContentTooLongError
+	assert.True(t, errors.As(err, &tooLong))
+	assert.Equal(t, ContentTooLongError{Index: 0, Role: RoleSystem, MaxRunes: 10, Runes: 11}, *tooLong)
+	assert.True(t, strings.Contains(err.Error(), "system 消息最多允许 10 个字符"))
+
+	// user 消息超过限制
+	err = newRequest("system", strings.Repeat("用", 21)).Validate(0, limits)
+	assert.True(t, errors.As(err, &tooLong))
+	assert.Equal(t, ContentTooLongError{Index: 2, Role: RoleUser, MaxRunes: 20, Runes: 21}, *tooLong)
+	assert.True(t, strings.Contains(err.Error(), "user 消息最多允许 20 个字符"))
+}
+
+func TestRequest_ValidateDefaultContentLimit(t *testing.T) {
+	req := Request{
+		Messages: Messages{
+			{Role: RoleUser, Content: "你好"},
+			{Role: RoleUser, Content: strings.Repeat("字", 5), MultipartContents: []*MultipartContent{{Type: "text", Text: "abc"}}},
+		},
+	}
+
+	assert.NoError(t, req.Validate(0, RoleContentLimits{Default: 8}))
+
+	// 没有单独配置的角色使用默认限制，错误信息中包含角色
+	err := req.Validate(0, RoleContentLimits{Default: 7})
+	assert.True(t, errors.Is(err, ErrContentTooLong))
+
+	var tooLong *ContentTooLongError
+	assert.True(t, errors.As(err, &tooLong))
+	assert.Equal(t, ContentTooLongError{Index: 1, Role: RoleUser, MaxRunes: 7, Runes: 8}, *tooLong)
+	assert.True(t, strings.Contains(err.Error(), "user 消息最多允许 7 个字符"))
+
+	// 单独配置的限制更小时以单独配置的为准，更大时以默认限制为准
+	limits := RoleContentLimits{Default: 7, Roles: map[Role]int{RoleUser: 6, RoleAssistant: 100}}
+	assert.Equal(t, 6, limits.Limit(RoleUser))
+	assert.Equal(t, 7, limits.Limit(RoleAssistant))
+	assert.Equal(t, 7, limits.Limit(RoleTool))
+	assert.Equal(t, 0, RoleContentLimits{}.Limit(RoleUser))
+}
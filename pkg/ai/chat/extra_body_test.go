@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/oneapi"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestValidateExtraBody(t *testing.T) {
+	assert.NoError(t, ValidateExtraBody(nil))
+	assert.NoError(t, ValidateExtraBody(map[string]any{"min_p": 0.05, "guided_json": map[string]any{"type": "object"}}))
+
+	// 请求结构体中的参数以及服务端控制的参数都不允许指定
+	for _, key := range []string{"model", "messages", "stream", " Stream ", "n", "max_tokens", "max_completion_tokens", "tools", "tool_choice", "stream_options", "seed"} {
+		assert.True(t, ValidateExtraBody(map[string]any{key: true}) != nil)
+	}
+}
+
+func TestExtraBody_Serialization(t *testing.T) {
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+
+		var body map[string]any
+		assert.NoError(t, json.Unmarshal(data, &body))
+		bodies = append(bodies, body)
+
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"ok\"}, \"finish_reason\": \"stop\"}]}\n\ndata: [DONE]\n\n"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "ok"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	channelExtra := map[string]any{"cache": map[string]any{"no-cache": true}, "min_p": 0.01, "stream": true}
+	ch := &repo.Channel{
+		Channels: model.Channels{Server: server.URL, Secret: "sk-test"},
+		Meta:     repo.ChannelMeta{ExtraBody: channelExtra},
+	}
+
+	clients := map[string]Chat{
+		"openai":     createOpenAIClient(ch, nil, 0),
+		"openrouter": createOpenRouterClient(ch, nil, 0),
+		"oneapi": NewOneAPIChat(oneapi.New(openai2.NewOpenAIClient(&openai2.Config{
+			Enable:        true,
+			OpenAIServers: []string{server.URL},
+			OpenAIKeys:    []string{"sk-test"},
+			ExtraBody:     channelExtra,
+		}, nil), nil)),
+	}
+
+	req := Request{
+		Model:     "gpt-4o",
+		Messages:  Messages{{Role: RoleUser, Content: "hello"}},
+		MaxTokens: 100,
+		ExtraBody: map[string]any{
+			"min_p":       0.05,
+			"guided_json": map[string]any{"type": "object"},
+			// 不能覆盖请求体中已有的参数，不允许指定的参数被忽略
+			"max_tokens":            1,
+			"model":                 "other",
+			"messages":              []any{},
+			"n":                     3,
+			"max_completion_tokens": 100000,
+			"tools":                 []any{map[string]any{"type": "function"}},
+			"stream_options":        map[string]any{"include_usage": false},
+		},
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			bodies = nil
+
+			_, err := client.Chat(context.TODO(), req)
+			assert.NoError(t, err)
+
+			stream, err := client.ChatStream(context.TODO(), req)
+			assert.NoError(t, err)
+			for range stream {
+			}
+
+			assert.Equal(t, 2, len(bodies))
+			for i, body := range bodies {
+				// 渠道配置和请求中的额外参数都会合并到请求体中，同名参数以请求中的为准
+				assert.EqualValues(t, map[string]any{"no-cache": true}, body["cache"])
+				assert.EqualValues(t, 0.05, body["min_p"])
+				assert.EqualValues(t, map[string]any{"type": "object"}, body["guided_json"])
+
+				// 请求体中已有的参数总是优先
+				assert.Equal(t, "gpt-4o", body["model"])
+				assert.EqualValues(t, 100, body["max_tokens"])
+				assert.Equal(t, 1, len(body["messages"].([]any)))
+
+				// 请求体中省略的零值参数也不能通过额外参数补充
+				for _, key := range []string{"n", "max_completion_tokens", "tools"} {
+					_, ok := body[key]
+					assert.False(t, ok)
+				}
+
+				_, streaming := body["stream"]
+				assert.Equal(t, i == 1, streaming)
+			}
+		})
+	}
+
+	// 没有额外参数时请求体保持不变
+	bodies = nil
+	_, err := createOpenAIClient(&repo.Channel{Channels: model.Channels{Server: server.URL, Secret: "sk-test"}}, nil, 0).
+		Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	_, ok := bodies[0]["min_p"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,223 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrAllChannelsFailed 模型的所有服务提供商都请求失败，通常意味着上游故障，可以使用 errors.Is 判断 AllChannelsFailedError
+var ErrAllChannelsFailed = errors.New("该模型暂时不可用，请稍后再试或切换模型")
+
+// errChannelsExhausted 故障转移时已经没有可以尝试的服务提供商
+var errChannelsExhausted = errors.New("no remaining channel to fail over")
+
+// ChannelFailure 单个服务提供商请求失败的记录
+type ChannelFailure struct {
+	// Channel 服务提供商标识（参考 providerKey）
+	Channel string `json:"channel"`
+	// ProviderType 服务提供商类型
+	ProviderType string `json:"provider_type,omitempty"`
+	// Error 统一格式的错误信息（不包含上游原始的错误响应体）
+	Error string `json:"error"`
+	// err 原始错误，用于 errors.Is/errors.As 判断
+	err error
+}
+
+// AllChannelsFailedError 模型的所有服务提供商都请求失败
+//
+// Error 返回可以展示给用户的提示信息，每个服务提供商的失败原因只能通过 Detail 用于日志和后台管理工具
+type AllChannelsFailedError struct {
+	// Model 请求的模型（模型重写之前的名称）
+	Model string `json:"model"`
+	// Failures 按照尝试顺序记录的每个服务提供商的失败原因
+	Failures []ChannelFailure `json:"failures"`
+	// Alternative 能力相近并且当前可用的其它模型，没有时为 nil
+	Alternative *repo.Model `json:"-"`
+}
+
+func (e *AllChannelsFailedError) Error() string {
+	return ErrAllChannelsFailed.Error()
+}
+
+// Is 与 ErrAllChannelsFailed 匹配
+func (e *AllChannelsFailedError) Is(target error) bool {
+	return target == ErrAllChannelsFailed
+}
+
+// Unwrap 返回每个服务提供商的原始错误，使 errors.Is/errors.As 仍然可以判断具体的失败原因
+func (e *AllChannelsFailedError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, item := range e.Failures {
+		if item.err != nil {
+			errs = append(errs, item.err)
+		}
+	}
+
+	return errs
+}
+
+// Detail 包含每个服务提供商失败原因的错误详情，只能用于日志和后台管理工具
+func (e *AllChannelsFailedError) Detail() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, item := range e.Failures {
+		failures = append(failures, fmt.Sprintf("%s(%s): %s", item.Channel, item.ProviderType, item.Error))
+	}
+
+	return fmt.Sprintf("all channels of model %s failed: %s", e.Model, strings.Join(failures, "; "))
+}
+
+// Message 返回给用户的提示信息，有能力相近的其它模型时推荐用户切换
+func (e *AllChannelsFailedError) Message() string {
+	if e.Alternative == nil {
+		return ErrAllChannelsFailed.Error()
+	}
+
+	name := e.Alternative.Name
+	if name == "" {
+		name = e.Alternative.ModelId
+	}
+
+	return fmt.Sprintf("%s，推荐使用 %s", ErrAllChannelsFailed.Error(), name)
+}
+
+// AllChannelsFailedMessage 返回所有服务提供商都请求失败时给用户的提示信息
+func AllChannelsFailedMessage(err error) string {
+	var channelsErr *AllChannelsFailedError
+	if errors.As(err, &channelsErr) {
+		return channelsErr.Message()
+	}
+
+	return ErrAllChannelsFailed.Error()
+}
+
+// newChannelFailure 创建服务提供商请求失败的记录，上游服务错误只保留状态码、错误码和错误信息
+func newChannelFailure(pro repo.ModelProvider, providerType string, err error) ChannelFailure {
+	return ChannelFailure{Channel: providerKey(pro), ProviderType: providerType, Error: err.Error(), err: err}
+}
+
+// newAllChannelsFailedCounter 创建模型的所有服务提供商都请求失败的次数统计，并注册到 registerer，
+// 出现时通常意味着上游故障，需要配置告警
+func newAllChannelsFailedCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	return registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_all_channels_failed_count",
+		Help:      "requests failed after every channel of the model has been tried, usually an upstream outage",
+	}, []string{"model"}))
+}
+
+// dispatch 修正请求并通过 call 请求上游，请求失败并且是暂时性的错误（参考 isRetryable）或者服务提供商账户额度已用完时，
+// 排除失败的服务提供商后重新选择其它服务提供商，所有服务提供商都失败时返回 AllChannelsFailedError
+//
+// 流式输出只在建立连接失败时切换服务提供商，连接之后的错误响应由 retryChat 处理
+func (d *Dispatcher) dispatch(ctx context.Context, req Request, call func(req Request, imp Chat, providerType string) error) (Request, string, error) {
+	// 重新存储请求中引用的远程文件，避免服务提供商获取文件时地址已经失效，故障转移时不需要重新存储
+	if d.files != nil {
+		rehosted, err := d.files.Rehost(ctx, req)
+		if err != nil {
+			return req, "", err
+		}
+
+		req = rehosted
+	}
+
+	var failures []ChannelFailure
+	for {
+		fixed, imp, providerType, err := d.fixRequest(ctx, req)
+		if errors.Is(err, errChannelsExhausted) {
+			return fixed, providerType, d.allChannelsFailed(ctx, fixed.Model, failures)
+		}
+		if err != nil {
+			return fixed, providerType, err
+		}
+
+		err = call(fixed, imp, providerType)
+
+		// 渠道不支持多模态的消息格式，转换为纯文本后重新请求（同一个请求只转换一次）
+		if err != nil && d.rememberFlattenMultipart(ctx, fixed, err) {
+			req.attempts = fixed.attempts
+			continue
+		}
+
+		if err == nil || !shouldFailover(providerType, err, d.retryPatterns) {
+			return fixed, providerType, err
+		}
+
+		failure := newChannelFailure(fixed.channel, providerType, err)
+		failures = append(failures, failure)
+
+		log.F(log.M{"model": fixed.Model, "channel": failure.Channel, "detail": ErrorDetail(err)}).Warningf("chat request failed, fail over to other channels: %v", err)
+
+		// 调试模式下保留所有服务提供商的请求记录
+		req.attempts = fixed.attempts
+		req.failedChannels = make(map[string]bool, len(failures))
+		for _, item := range failures {
+			req.failedChannels[item.Channel] = true
+		}
+	}
+}
+
+// shouldFailover 请求失败后是否切换到其它服务提供商，除了暂时性的错误之外，服务提供商账户额度已用完时（不会重试），
+// 其它服务提供商仍然可以正常使用
+func shouldFailover(providerType string, err error, patterns RetryPatterns) bool {
+	return isRetryable(providerType, err, patterns) || errors.Is(err, ErrQuotaExceeded)
+}
+
+// allChannelsFailed 创建所有服务提供商都请求失败的错误，同时记录统计信息用于告警
+func (d *Dispatcher) allChannelsFailed(ctx context.Context, modelID string, failures []ChannelFailure) error {
+	err := &AllChannelsFailedError{Model: modelID, Failures: failures}
+	if mod, e := d.router.Model(ctx, modelID); e == nil {
+		err.Alternative = d.suggestAlternative(ctx, mod)
+	}
+
+	if d.channelOutages != nil {
+		d.channelOutages.WithLabelValues(modelID).Inc()
+	}
+
+	log.F(log.M{"model": modelID, "failures": failures}).Errorf("all channels of model failed: %s", err.Detail())
+
+	return err
+}
+
+// suggestAlternative 查找能力相近（视觉能力、上下文长度不低于当前模型）并且有健康的服务提供商的其它模型，
+// 优先选择价格最接近的，没有时返回 nil
+func (d *Dispatcher) suggestAlternative(ctx context.Context, mod repo.Model) *repo.Model {
+	if d.models == nil {
+		return nil
+	}
+
+	var alternative *repo.Model
+	minDiff := math.MaxInt
+	for _, item := range d.models.Models(ctx, false) {
+		if item.ModelId == mod.ModelId {
+			continue
+		}
+
+		if (mod.Meta.Vision && !item.Meta.Vision) || item.Meta.MaxContext < mod.Meta.MaxContext || (item.Meta.Restricted && !mod.Meta.Restricted) {
+			continue
+		}
+
+		if d.health != nil && len(array.Filter(item.Providers, func(pro repo.ModelProvider, _ int) bool { return d.health.Healthy(pro) })) == 0 {
+			continue
+		}
+
+		diff := item.Meta.OutputPrice - mod.Meta.OutputPrice
+		if diff < 0 {
+			diff = -diff
+		}
+
+		if diff < minDiff {
+			item := item
+			alternative, minDiff = &item, diff
+		}
+	}
+
+	return alternative
+}
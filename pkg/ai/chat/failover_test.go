@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// channelClientFactory 按照渠道 ID 返回不同的客户端
+type channelClientFactory map[int64]Chat
+
+func (f channelClientFactory) Client(ctx context.Context, provider repo.ModelProvider) (Chat, string) {
+	return f[provider.ID], service.ProviderOpenAI
+}
+
+// unavailableChatClient 请求总是返回指定的错误
+type unavailableChatClient struct {
+	ChatTestClient
+	err error
+}
+
+func (c unavailableChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	return nil, c.err
+}
+
+func (c unavailableChatClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	return nil, c.err
+}
+
+func TestDispatcher_Failover(t *testing.T) {
+	badGateway := NewUpstreamError("openai", http.StatusBadGateway, "", "", "bad gateway", nil)
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{ID: 1}, {ID: 2}},
+			Meta:      repo.ModelMeta{Vision: true, MaxContext: 8000, OutputPrice: 30},
+		},
+	}
+
+	client := &streamChatClient{chunks: []Response{{Text: "ok"}, {FinishReason: FinishReasonStop}}}
+	factory := channelClientFactory{1: unavailableChatClient{err: badGateway}, 2: client}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.channelOutages = newAllChannelsFailedCounter(prometheus.NewRegistry())
+	d.models = fakeModelLister{
+		{Models: model.Models{ModelId: "gpt-4"}, Meta: repo.ModelMeta{Vision: true, MaxContext: 8000, OutputPrice: 30}},
+		{Models: model.Models{ModelId: "gpt-3.5"}, Meta: repo.ModelMeta{MaxContext: 16000, OutputPrice: 2}},
+		{Models: model.Models{ModelId: "gpt-4o"}, Meta: repo.ModelMeta{Vision: true, MaxContext: 128000, OutputPrice: 15}},
+		{Models: model.Models{ModelId: "claude"}, Meta: repo.ModelMeta{Vision: true, MaxContext: 200000, OutputPrice: 75}},
+	}
+
+	req := Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+
+	// 暂时性的错误切换到其它服务提供商
+	res, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.Equal(t, 1, len(client.requests))
+
+	stream, err := d.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", concatText(assertFinishReasonConformance(t, stream)))
+
+	// 所有服务提供商都失败时，汇总每个服务提供商的失败原因，并推荐能力相近的其它模型
+	factory[2] = unavailableChatClient{err: NewUpstreamError("openai", http.StatusServiceUnavailable, "", "", "overloaded", nil)}
+	_, err = d.Chat(context.TODO(), req)
+	assert.True(t, errors.Is(err, ErrAllChannelsFailed))
+	assert.Equal(t, ErrAllChannelsFailed.Error(), err.Error())
+
+	var upstreamErr *UpstreamError
+	assert.True(t, errors.As(err, &upstreamErr))
+
+	var channelsErr *AllChannelsFailedError
+	assert.True(t, errors.As(err, &channelsErr))
+	assert.Equal(t, "gpt-4", channelsErr.Model)
+	assert.Equal(t, 2, len(channelsErr.Failures))
+	assert.Equal(t, "channel:1", channelsErr.Failures[0].Channel)
+	assert.Equal(t, badGateway.Error(), channelsErr.Failures[0].Error)
+	assert.Equal(t, "channel:2", channelsErr.Failures[1].Channel)
+	assert.Equal(t, "gpt-4o", channelsErr.Alternative.ModelId)
+	assert.Equal(t, "该模型暂时不可用，请稍后再试或切换模型，推荐使用 gpt-4o", AllChannelsFailedMessage(err))
+	assert.Equal(t, channelsErr.Detail(), ErrorDetail(err))
+
+	_, err = d.ChatStream(context.TODO(), req)
+	assert.True(t, errors.Is(err, ErrAllChannelsFailed))
+	assert.EqualValues(t, 2, testutil.ToFloat64(d.channelOutages.WithLabelValues("gpt-4")))
+
+	// 不是暂时性的错误不切换服务提供商
+	unauthorized := NewUpstreamError("openai", http.StatusUnauthorized, "", "", "invalid api key", nil)
+	factory[1] = unavailableChatClient{err: unauthorized}
+	_, err = d.Chat(context.TODO(), req)
+	assert.False(t, errors.Is(err, ErrAllChannelsFailed))
+	assert.True(t, errors.Is(err, unauthorized))
+
+	// 服务提供商账户额度已用完时（不会重试）切换到其它服务提供商
+	factory[1] = unavailableChatClient{err: NewUpstreamError("deepseek", http.StatusPaymentRequired, "", "", "Insufficient Balance", nil)}
+	factory[2] = client
+	res, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+}
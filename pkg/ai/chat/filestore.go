@@ -0,0 +1,278 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/search"
+	"github.com/mylxsw/aidea-server/pkg/uploader"
+	"github.com/mylxsw/go-utils/array"
+)
+
+var (
+	// ErrFileTooLarge 请求中引用的文件超过大小限制
+	ErrFileTooLarge = errors.New("文件大小超过限制")
+	// ErrFileTypeNotAllowed 请求中引用的文件类型不支持
+	ErrFileTypeNotAllowed = errors.New("不支持的文件类型")
+)
+
+// DefaultRehostFileTypes 允许重新存储的文件类型
+var DefaultRehostFileTypes = []string{"image/jpeg", "image/png", "image/webp", "image/gif"}
+
+// fileTypeExtensions 文件类型对应的扩展名，存储服务根据扩展名返回 Content-Type
+var fileTypeExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+	"image/gif":  "gif",
+}
+
+const (
+	// rehostCacheSize 已经重新存储的文件地址缓存的最大条目数量，超过后淘汰最早写入的条目
+	rehostCacheSize = 10000
+	// rehostCacheTTL 已经重新存储的文件地址的缓存时间
+	rehostCacheTTL = 24 * time.Hour
+	// maxRehostRedirects 下载文件时最多允许的重定向次数
+	maxRehostRedirects = 3
+)
+
+// FileStore 文件的持久化存储
+type FileStore interface {
+	// Store 存储文件，返回稳定的访问地址
+	Store(ctx context.Context, data []byte, contentType string) (string, error)
+}
+
+// UploaderFileStore 使用对象存储（uploader）存储文件
+type UploaderFileStore struct {
+	up *uploader.Uploader
+}
+
+func NewUploaderFileStore(up *uploader.Uploader) *UploaderFileStore {
+	return &UploaderFileStore{up: up}
+}
+
+func (s *UploaderFileStore) Store(ctx context.Context, data []byte, contentType string) (string, error) {
+	return s.up.UploadStream(ctx, 0, uploader.DefaultUploadExpireAfterDays, data, fileTypeExtensions[contentType])
+}
+
+// FileRehoster 在请求分发之前，下载请求中引用的远程文件，重新存储到 FileStore 中，并将地址替换为稳定的地址，
+// 避免服务提供商获取文件时，客户端提供的临时地址已经失效
+//
+// 目前请求中引用远程文件的只有图片（image_url），data URL 以及已经存储在 stablePrefixes 中的文件保持不变。
+// 文件地址由用户提供，只允许访问公网地址；历史消息中的图片每轮对话都会出现，已经存储过的地址直接使用缓存的结果。
+type FileRehoster struct {
+	store          FileStore
+	client         *http.Client
+	maxSize        int64
+	allowedTypes   []string
+	stablePrefixes []string
+	cache          *rehostCache
+}
+
+// NewFileRehoster 创建文件重新存储处理器，maxSize 为单个文件的最大字节数，stablePrefixes 为不需要重新存储的地址前缀
+func NewFileRehoster(store FileStore, maxSize int64, stablePrefixes ...string) *FileRehoster {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: search.DenyPrivateAddress}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		// 不使用环境变量中的代理，否则连接的是代理服务器，无法检查文件地址是否指向内网
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRehostRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRehostRedirects)
+			}
+
+			return nil
+		},
+	}
+
+	return &FileRehoster{
+		store:          store,
+		client:         client,
+		maxSize:        maxSize,
+		allowedTypes:   DefaultRehostFileTypes,
+		stablePrefixes: array.Filter(stablePrefixes, func(item string, _ int) bool { return item != "" }),
+		cache:          newRehostCache(rehostCacheSize, rehostCacheTTL),
+	}
+}
+
+// Rehost 重新存储请求中引用的远程文件，返回新的请求，不修改原始请求
+func (r *FileRehoster) Rehost(ctx context.Context, req Request) (Request, error) {
+	rehosted := make(map[string]string)
+
+	messages := make(Messages, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		if len(msg.MultipartContents) > 0 {
+			parts := make([]*MultipartContent, 0, len(msg.MultipartContents))
+			for _, part := range msg.MultipartContents {
+				if part != nil && part.ImageURL != nil && r.needRehost(part.ImageURL.URL) {
+					stableURL, ok := rehosted[part.ImageURL.URL]
+					if !ok {
+						if stableURL, ok = r.cache.get(part.ImageURL.URL); !ok {
+							var err error
+							if stableURL, err = r.rehost(ctx, part.ImageURL.URL); err != nil {
+								return req, err
+							}
+
+							r.cache.set(part.ImageURL.URL, stableURL)
+						}
+
+						rehosted[part.ImageURL.URL] = stableURL
+					}
+
+					imageURL := *part.ImageURL
+					imageURL.URL = stableURL
+					part = &MultipartContent{Type: part.Type, Text: part.Text, ImageURL: &imageURL}
+				}
+
+				parts = append(parts, part)
+			}
+
+			msg.MultipartContents = parts
+		}
+
+		messages = append(messages, msg)
+	}
+
+	req.Messages = messages
+	return req, nil
+}
+
+// needRehost 是否需要重新存储，只处理 http/https 地址
+func (r *FileRehoster) needRehost(url string) bool {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return false
+	}
+
+	for _, prefix := range r.stablePrefixes {
+		if strings.HasPrefix(url, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rehost 下载远程文件，校验大小和类型后存储
+func (r *FileRehoster) rehost(ctx context.Context, url string) (string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("获取文件失败: %w", err)
+	}
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("获取文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("获取文件失败: [%d] %s", resp.StatusCode, url)
+	}
+
+	if r.maxSize > 0 && resp.ContentLength > r.maxSize {
+		return "", fmt.Errorf("%w：最大 %d 字节", ErrFileTooLarge, r.maxSize)
+	}
+
+	// 服务端可能没有返回 Content-Length，或者返回的值不准确，这里多读取一个字节用于判断是否超过限制
+	reader := io.Reader(resp.Body)
+	if r.maxSize > 0 {
+		reader = io.LimitReader(resp.Body, r.maxSize+1)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("获取文件失败: %w", err)
+	}
+
+	if r.maxSize > 0 && int64(len(data)) > r.maxSize {
+		return "", fmt.Errorf("%w：最大 %d 字节", ErrFileTooLarge, r.maxSize)
+	}
+
+	contentType := detectContentType(resp.Header.Get("Content-Type"), data)
+	if !array.In(contentType, r.allowedTypes) {
+		return "", fmt.Errorf("%w：%s", ErrFileTypeNotAllowed, contentType)
+	}
+
+	return r.store.Store(ctx, data, contentType)
+}
+
+// rehostCache 按照原始地址缓存重新存储之后的地址
+type rehostCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	entries map[string]rehostCacheEntry
+	// order 按照写入顺序排列的缓存 Key（环形缓冲区），next 为下一个写入位置
+	order []string
+	next  int
+}
+
+type rehostCacheEntry struct {
+	url       string
+	expiresAt time.Time
+}
+
+func newRehostCache(size int, ttl time.Duration) *rehostCache {
+	return &rehostCache{
+		ttl:     ttl,
+		entries: make(map[string]rehostCacheEntry, size),
+		order:   make([]string, 0, size),
+	}
+}
+
+func (c *rehostCache) get(url string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[url]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+
+	return entry.url, true
+}
+
+func (c *rehostCache) set(url string, stableURL string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := rehostCacheEntry{url: stableURL, expiresAt: time.Now().Add(c.ttl)}
+	if _, ok := c.entries[url]; ok {
+		c.entries[url] = entry
+		return
+	}
+
+	if len(c.order) < cap(c.order) {
+		c.order = append(c.order, url)
+	} else {
+		delete(c.entries, c.order[c.next])
+		c.order[c.next] = url
+		c.next = (c.next + 1) % len(c.order)
+	}
+
+	c.entries[url] = entry
+}
+
+// detectContentType 文件类型，服务端没有返回或者返回的是通用类型时，根据文件内容判断
+func detectContentType(header string, data []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(header); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/search"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// memoryFileStore 在内存中保存文件，返回固定格式的地址
+type memoryFileStore struct {
+	files        [][]byte
+	contentTypes []string
+}
+
+func (s *memoryFileStore) Store(ctx context.Context, data []byte, contentType string) (string, error) {
+	s.files = append(s.files, data)
+	s.contentTypes = append(s.contentTypes, contentType)
+	return fmt.Sprintf("https://storage.example.com/files/%d.%s", len(s.files), fileTypeExtensions[contentType]), nil
+}
+
+func imageMessage(urls ...string) Message {
+	parts := []*MultipartContent{{Type: "text", Text: "what is this?"}}
+	for _, url := range urls {
+		parts = append(parts, &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: url}})
+	}
+
+	return Message{Role: RoleUser, MultipartContents: parts}
+}
+
+func TestFileRehoster_TransientURL(t *testing.T) {
+	png, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(probeImage, "data:image/png;base64,"))
+	assert.NoError(t, err)
+
+	// 临时地址只能访问一次
+	var fetched atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetched.Add(1) > 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(png)
+	}))
+	defer server.Close()
+
+	store := &memoryFileStore{}
+	client := &streamChatClient{}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.files = NewFileRehoster(store, 1024, "https://storage.example.com/")
+	// 测试服务器监听在回环地址上，使用不检查内网地址的客户端
+	d.files.client = server.Client()
+
+	transientURL := server.URL + "/upload/tmp.png?token=abc"
+	stableURL := "https://storage.example.com/files/existing.png"
+	req := Request{
+		Model:    "gpt-4o",
+		Messages: Messages{imageMessage(transientURL, transientURL, stableURL, probeImage)},
+	}
+
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+
+	// 相同的地址只下载、存储一次，根据文件内容识别类型
+	assert.EqualValues(t, 1, fetched.Load())
+	assert.Equal(t, 1, len(store.files))
+	assert.Equal(t, "image/png", store.contentTypes[0])
+
+	parts := client.requests[0].Messages[0].MultipartContents
+	assert.Equal(t, "https://storage.example.com/files/1.png", parts[1].ImageURL.URL)
+	assert.Equal(t, "https://storage.example.com/files/1.png", parts[2].ImageURL.URL)
+	// 已经存储的文件和 data URL 保持不变
+	assert.Equal(t, stableURL, parts[3].ImageURL.URL)
+	assert.Equal(t, probeImage, parts[4].ImageURL.URL)
+
+	// 不修改原始请求
+	assert.Equal(t, transientURL, req.Messages[0].MultipartContents[1].ImageURL.URL)
+
+	// 下一轮对话历史消息中仍然是原始地址（已经失效），使用缓存的地址，不会再次下载
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, fetched.Load())
+	assert.Equal(t, 1, len(store.files))
+	assert.Equal(t, "https://storage.example.com/files/1.png", client.requests[1].Messages[0].MultipartContents[1].ImageURL.URL)
+}
+
+func TestFileRehoster_PrivateAddress(t *testing.T) {
+	var fetched atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer internal.Close()
+
+	store := &memoryFileStore{}
+	client := &streamChatClient{}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.files = NewFileRehoster(store, 1024)
+
+	// 用户提供的地址指向服务端内网（回环地址），拒绝访问
+	_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(internal.URL + "/secret.png")}})
+	assert.True(t, errors.Is(err, search.ErrPrivateAddress))
+	assert.EqualValues(t, 0, fetched.Load())
+	assert.Equal(t, 0, len(store.files))
+	assert.Equal(t, 0, len(client.requests))
+}
+
+func TestFileRehoster_Rejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(make([]byte, 2048))
+		case "/doc.txt":
+			_, _ = w.Write([]byte("hello world"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store := &memoryFileStore{}
+	client := &streamChatClient{}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.files = NewFileRehoster(store, 1024)
+	d.files.client = server.Client()
+
+	chat := func(url string) error {
+		_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(url)}})
+		return err
+	}
+
+	assert.True(t, errors.Is(chat(server.URL+"/large.png"), ErrFileTooLarge))
+	assert.True(t, errors.Is(chat(server.URL+"/doc.txt"), ErrFileTypeNotAllowed))
+
+	err := chat(server.URL + "/expired.png")
+	assert.True(t, err != nil && strings.Contains(err.Error(), "获取文件失败"))
+
+	// 拒绝的请求不会发送给服务提供商
+	assert.Equal(t, 0, len(store.files))
+	assert.Equal(t, 0, len(client.requests))
+}
@@ -0,0 +1,173 @@
+package chat
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// 统一的结束原因，客户端根据结束原因判断回答是否完整（如 length 时提供“继续”按钮）
+const (
+	// FinishReasonStop 正常结束
+	FinishReasonStop = "stop"
+	// FinishReasonLength 达到最大输出长度（max_tokens）被截断
+	FinishReasonLength = "length"
+	// FinishReasonContentFilter 触发内容安全策略被终止
+	FinishReasonContentFilter = "content_filter"
+	// FinishReasonToolCalls 模型请求调用工具
+	FinishReasonToolCalls = "tool_calls"
+	// FinishReasonRefusal 模型自身拒绝回答（与内容安全策略拦截不同），拒绝的说明在 Response.Refusal 中
+	FinishReasonRefusal = "refusal"
+)
+
+// finishReasonAliases 各服务提供商的结束原因与统一结束原因的对应关系（key 为小写）
+var finishReasonAliases = map[string]string{
+	// OpenAI 兼容接口（OpenAI/OneAPI/OpenRouter/Moonshot/灵积/智谱/百川/360/商汤/腾讯等）
+	"stop":           FinishReasonStop,
+	"length":         FinishReasonLength,
+	"content_filter": FinishReasonContentFilter,
+	"tool_calls":     FinishReasonToolCalls,
+	"function_call":  FinishReasonToolCalls,
+	"refusal":        FinishReasonRefusal,
+	// Anthropic
+	"end_turn":      FinishReasonStop,
+	"stop_sequence": FinishReasonStop,
+	"max_tokens":    FinishReasonLength,
+	"tool_use":      FinishReasonToolCalls,
+	// Google Gemini
+	"max_output_tokens":  FinishReasonLength,
+	"safety":             FinishReasonContentFilter,
+	"recitation":         FinishReasonContentFilter,
+	"blocklist":          FinishReasonContentFilter,
+	"prohibited_content": FinishReasonContentFilter,
+	// 智谱/商汤
+	"sensitive": FinishReasonContentFilter,
+	// 商汤，触发模型上下文长度限制
+	"context": FinishReasonLength,
+}
+
+// NormalizeFinishReason 将服务提供商返回的结束原因转换为统一的结束原因，空值（包括 null）返回空字符串，无法识别的原因视为正常结束
+func NormalizeFinishReason(reason string) string {
+	reason = strings.ToLower(strings.TrimSpace(reason))
+	if reason == "" || reason == "null" {
+		return ""
+	}
+
+	if ret, ok := finishReasonAliases[reason]; ok {
+		return ret
+	}
+
+	return FinishReasonStop
+}
+
+// openAIFinishReason 返回 OpenAI 兼容接口响应中的结束原因
+func openAIFinishReason[T openai.ChatCompletionChoice | openai.ChatCompletionStreamChoice](choices []T) string {
+	for _, choice := range choices {
+		var reason openai.FinishReason
+		switch c := any(choice).(type) {
+		case openai.ChatCompletionChoice:
+			reason = c.FinishReason
+		case openai.ChatCompletionStreamChoice:
+			reason = c.FinishReason
+		}
+
+		if ret := NormalizeFinishReason(string(reason)); ret != "" {
+			return ret
+		}
+	}
+
+	return ""
+}
+
+// withToolCallsFinishReason 只包含工具调用、没有文本内容的回答，文本统一为空，结束原因统一为 FinishReasonToolCalls，
+// 避免部分服务提供商返回空白文本或者 stop 时，客户端无法区分只调用工具的回答与空回答
+func withToolCallsFinishReason(res Response) Response {
+	if len(res.ToolCalls) == 0 || strings.TrimSpace(res.Text) != "" {
+		return res
+	}
+
+	res.Text = ""
+	if res.FinishReason == "" || res.FinishReason == FinishReasonStop {
+		res.FinishReason = FinishReasonToolCalls
+	}
+
+	return res
+}
+
+// ensureFinishReason 统一流式响应的结束原因，保证正常结束的流式响应，最后一个响应一定包含结束原因
+//
+// 服务提供商没有返回结束原因时（部分服务提供商不支持），流正常结束后补充一个 FinishReasonStop 的响应；
+// 出现错误或者请求被取消时，不补充结束原因。
+//
+// 只包含工具调用、没有文本内容的回答，结束原因统一为 FinishReasonToolCalls，组装完成的工具调用合并到最后一个包含结束原因的响应中
+func ensureFinishReason(ctx context.Context, stream <-chan Response) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		var finishReason string
+		var hasText, hasToolCalls bool
+		// pending 没有文本内容的结束响应，工具调用可能在结束原因之后返回，需要等待后续的响应确定最终的结束原因
+		var pending *Response
+		flush := func() bool {
+			if pending == nil {
+				return true
+			}
+
+			data := *pending
+			pending = nil
+			if hasToolCalls && !hasText && data.FinishReason == FinishReasonStop {
+				data.FinishReason = FinishReasonToolCalls
+			}
+
+			return send(data)
+		}
+
+		for data := range stream {
+			if data.ErrorCode != "" {
+				if flush() {
+					send(data)
+				}
+				return
+			}
+
+			data.FinishReason = NormalizeFinishReason(data.FinishReason)
+			if data.FinishReason != "" {
+				finishReason = data.FinishReason
+			}
+
+			hasText = hasText || strings.TrimSpace(data.Text) != ""
+			hasToolCalls = hasToolCalls || len(data.ToolCalls) > 0
+
+			// 结束原因之后只返回了工具调用，合并到结束响应中
+			if pending != nil && data.Text == "" && data.FinishReason == "" && len(data.ToolCalls) > 0 {
+				pending.ToolCalls = append(pending.ToolCalls, data.ToolCalls...)
+				continue
+			}
+
+			if !flush() {
+				return
+			}
+
+			if data.Text == "" && (data.FinishReason == FinishReasonStop || data.FinishReason == FinishReasonToolCalls) {
+				finish := data
+				pending = &finish
+				continue
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+
+		if !flush() {
+			return
+		}
+
+		if finishReason == "" && ctx.Err() == nil {
+			if hasToolCalls && !hasText {
+				send(Response{FinishReason: FinishReasonToolCalls})
+			} else {
+				send(Response{FinishReason: FinishReasonStop})
+			}
+		}
+	})
+}
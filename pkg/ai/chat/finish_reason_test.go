@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/sashabaranov/go-openai"
+)
+
+// assertFinishReasonConformance 所有服务提供商的流式响应都需要满足：正常结束时，最后一个响应包含统一的结束原因
+func assertFinishReasonConformance(t *testing.T, stream <-chan Response) []Response {
+	t.Helper()
+
+	var responses []Response
+	for res := range stream {
+		responses = append(responses, res)
+	}
+
+	if len(responses) == 0 {
+		t.Fatalf("empty stream")
+	}
+
+	last := responses[len(responses)-1]
+	if last.ErrorCode != "" {
+		return responses
+	}
+
+	switch last.FinishReason {
+	case FinishReasonStop, FinishReasonLength, FinishReasonContentFilter, FinishReasonToolCalls, FinishReasonRefusal:
+	default:
+		t.Fatalf("terminal chunk must have a normalized finish reason, got %q", last.FinishReason)
+	}
+
+	return responses
+}
+
+func TestNormalizeFinishReason(t *testing.T) {
+	cases := map[string]string{
+		"":                    "",
+		"null":                "",
+		"stop":                FinishReasonStop,
+		"length":              FinishReasonLength,
+		"content_filter":      FinishReasonContentFilter,
+		"function_call":       FinishReasonToolCalls,
+		"refusal":             FinishReasonRefusal,
+		"end_turn":            FinishReasonStop,
+		"max_tokens":          FinishReasonLength,
+		"tool_use":            FinishReasonToolCalls,
+		"STOP":                FinishReasonStop,
+		"MAX_TOKENS":          FinishReasonLength,
+		"SAFETY":              FinishReasonContentFilter,
+		"sensitive":           FinishReasonContentFilter,
+		"context":             FinishReasonLength,
+		"FINISH_REASON_OTHER": FinishReasonStop,
+	}
+
+	for reason, expected := range cases {
+		assert.Equal(t, expected, NormalizeFinishReason(reason))
+	}
+}
+
+func TestOpenAIChat_ChatStreamFinishReason(t *testing.T) {
+	client := &fakeOpenAIClient{
+		chunks: []openai2.ChatStreamResponse{
+			streamChunk(openai.ChatCompletionStreamChoice{Delta: openai.ChatCompletionStreamChoiceDelta{Content: "hello"}}),
+			streamChunk(openai.ChatCompletionStreamChoice{Delta: openai.ChatCompletionStreamChoiceDelta{Content: " world"}, FinishReason: openai.FinishReasonLength}),
+		},
+	}
+
+	stream, err := NewOpenAIChat(client).ChatStream(context.TODO(), Request{Model: "gpt-3.5-turbo", Messages: Messages{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, FinishReasonLength, responses[1].FinishReason)
+}
+
+func TestEnsureFinishReason(t *testing.T) {
+	newStream := func(responses ...Response) <-chan Response {
+		ch := make(chan Response, len(responses))
+		for _, res := range responses {
+			ch <- res
+		}
+		close(ch)
+
+		return ch
+	}
+
+	// 服务提供商没有返回结束原因时，补充 stop
+	responses := assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), newStream(Response{Text: "hello"})))
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, FinishReasonStop, responses[1].FinishReason)
+
+	// 结束原因统一转换，不重复补充
+	responses = assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), newStream(Response{Text: "hello"}, Response{FinishReason: "max_tokens"})))
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, FinishReasonLength, responses[1].FinishReason)
+
+	// 出现错误时，不补充结束原因，忽略后续的响应
+	responses = assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), newStream(Response{Text: "hello"}, Response{ErrorCode: "ERR500", Error: "failed"}, Response{Text: "ignored"})))
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, "", responses[1].FinishReason)
+}
@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/go-utils/array"
+)
+
+// MultipartFlattenedWarning 多模态消息转换为纯文本发送时返回给用户的警告信息
+const MultipartFlattenedWarning = "当前服务提供商不支持图片理解，本次回答未分析对话中的图片"
+
+// isInvalidContentTypeError 渠道（通常是中间的网关）不支持多模态的消息格式时返回的错误，如 "Invalid content type. image_url is only supported by certain models."
+func isInvalidContentTypeError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "invalid content type")
+}
+
+// hasMultipartContents 请求中是否包含多模态格式（content 为数组）的消息
+func hasMultipartContents(messages Messages) bool {
+	for _, msg := range messages {
+		if len(msg.MultipartContents) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flattenMultipart 将多模态消息转换为纯文本：文本部分依次拼接，图片替换为单独一行的图片地址（base64 编码的图片替换为占位符），
+// 返回转换后的消息以及是否包含图片
+func flattenMultipart(messages Messages) (Messages, bool) {
+	var images bool
+	ret := array.Map(messages, func(msg Message, _ int) Message {
+		if len(msg.MultipartContents) == 0 {
+			return msg
+		}
+
+		lines := make([]string, 0, len(msg.MultipartContents)+1)
+		if strings.TrimSpace(msg.Content) != "" {
+			lines = append(lines, msg.Content)
+		}
+
+		for _, part := range msg.MultipartContents {
+			if part == nil {
+				continue
+			}
+
+			if part.ImageURL != nil && part.ImageURL.URL != "" {
+				images = true
+				if strings.HasPrefix(part.ImageURL.URL, "data:") {
+					lines = append(lines, strippedImagePlaceholder)
+				} else {
+					lines = append(lines, part.ImageURL.URL)
+				}
+				continue
+			}
+
+			if strings.TrimSpace(part.Text) != "" {
+				lines = append(lines, part.Text)
+			}
+		}
+
+		msg.Content = strings.Join(lines, "\n")
+		msg.MultipartContents = nil
+		return msg
+	})
+
+	return ret, images
+}
+
+// FlattenedChannelStore 自动开启 flatten_multipart 的渠道记录（参考 providerKey），多个实例之间共享，重启之后仍然有效，由 service.ChatService 实现
+type FlattenedChannelStore interface {
+	// IsChannelFlattened 渠道是否已经自动开启 flatten_multipart
+	IsChannelFlattened(ctx context.Context, key string) (bool, error)
+	// FlattenChannel 记录渠道自动开启 flatten_multipart，已经记录过时返回 false
+	FlattenChannel(ctx context.Context, key string) (bool, error)
+}
+
+// flattenedChannels 返回过 invalid content type 错误、自动开启 flatten_multipart 的渠道（参考 providerKey），
+// 记录在 store 中，当前实例的内存中只缓存已经开启的渠道，store 为 nil 时只保存在内存中
+type flattenedChannels struct {
+	lock  sync.RWMutex
+	keys  map[string]bool
+	store FlattenedChannelStore
+}
+
+func newFlattenedChannels() *flattenedChannels {
+	return &flattenedChannels{keys: make(map[string]bool)}
+}
+
+func (c *flattenedChannels) has(ctx context.Context, key string) bool {
+	c.lock.RLock()
+	cached := c.keys[key]
+	c.lock.RUnlock()
+
+	if cached || c.store == nil {
+		return cached
+	}
+
+	flattened, err := c.store.IsChannelFlattened(ctx, key)
+	if err != nil {
+		log.F(log.M{"channel": key}).Errorf("query flattened channel failed: %v", err)
+		return false
+	}
+
+	if flattened {
+		c.lock.Lock()
+		c.keys[key] = true
+		c.lock.Unlock()
+	}
+
+	return flattened
+}
+
+// add 记录渠道需要转换多模态消息，已经记录过时返回 false
+func (c *flattenedChannels) add(ctx context.Context, key string) bool {
+	c.lock.Lock()
+	added := !c.keys[key]
+	c.keys[key] = true
+	c.lock.Unlock()
+
+	if c.store == nil {
+		return added
+	}
+
+	added, err := c.store.FlattenChannel(ctx, key)
+	if err != nil {
+		// 记录失败时只在当前实例中生效，之后的请求会再次触发自动开启
+		log.F(log.M{"channel": key}).Errorf("save flattened channel failed: %v", err)
+		return true
+	}
+
+	return added
+}
+
+// shouldFlattenMultipart 渠道是否需要将多模态消息转换为纯文本：渠道配置了 flatten_multipart，或者之前返回过 invalid content type 错误
+func (d *Dispatcher) shouldFlattenMultipart(ctx context.Context, pro repo.ModelProvider) bool {
+	if d.flattened.has(ctx, providerKey(pro)) {
+		return true
+	}
+
+	if d.channels == nil || pro.ID <= 0 {
+		return false
+	}
+
+	ch, err := d.channels.Channel(ctx, pro.ID)
+	if err != nil {
+		return false
+	}
+
+	return ch.Meta.FlattenMultipart
+}
+
+// rememberFlattenMultipart 渠道不支持多模态的消息格式时，自动为该渠道开启 flatten_multipart（只记录一次），返回是否需要转换后重新请求
+func (d *Dispatcher) rememberFlattenMultipart(ctx context.Context, req Request, err error) bool {
+	if req.multipartFlattened || !hasMultipartContents(req.Messages) || !isInvalidContentTypeError(err) {
+		return false
+	}
+
+	if d.flattened.add(ctx, providerKey(req.channel)) {
+		log.F(log.M{"model": req.Model, "channel": providerKey(req.channel)}).Warningf("channel rejected multipart contents, flatten multipart messages for this channel: %v", err)
+	}
+
+	return true
+}
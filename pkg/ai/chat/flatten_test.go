@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// multipartRejectingClient 请求中包含多模态格式的消息时，返回网关不支持该格式的错误
+type multipartRejectingClient struct {
+	streamChatClient
+}
+
+func (c *multipartRejectingClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	if hasMultipartContents(req.Messages) {
+		c.requests = append(c.requests, req)
+		return nil, errors.New("error, status code: 400, message: Invalid content type. image_url is only supported by certain models.")
+	}
+
+	return c.streamChatClient.Chat(ctx, req)
+}
+
+func newFlattenTestDispatcher(ch *repo.Channel, client Chat) (*Dispatcher, *fakeClientFactory) {
+	router := fakeModelRouter{
+		"gpt-4o": {
+			Models:    model.Models{ModelId: "gpt-4o"},
+			Providers: []repo.ModelProvider{{ID: 1}},
+		},
+	}
+
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.channels = fakeChannelQuerier{1: ch}
+
+	return d, factory
+}
+
+func TestFlattenMultipart(t *testing.T) {
+	messages, images := flattenMultipart(Messages{
+		{Role: RoleSystem, Content: "system"},
+		imageMessage("https://example.com/1.png", probeImage),
+	})
+
+	assert.True(t, images)
+	assert.Equal(t, "system", messages[0].Content)
+	assert.Equal(t, "what is this?\nhttps://example.com/1.png\n"+strippedImagePlaceholder, messages[1].Content)
+	assert.Equal(t, 0, len(messages[1].MultipartContents))
+
+	// 只包含文本的多模态消息
+	messages, images = flattenMultipart(Messages{imageMessage()})
+	assert.False(t, images)
+	assert.Equal(t, "what is this?", messages[0].Content)
+}
+
+func TestDispatcher_FlattenMultipart(t *testing.T) {
+	ch := newTestChannel(1, service.ProviderOpenAI)
+	ch.Meta.FlattenMultipart = true
+
+	client := &streamChatClient{}
+	d, _ := newFlattenTestDispatcher(ch, client)
+
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}})
+	assert.NoError(t, err)
+	assert.Equal(t, MultipartFlattenedWarning, res.Warning)
+	assert.Equal(t, "what is this?\nhttps://example.com/1.png", client.requests[0].Messages[0].Content)
+	assert.Equal(t, 0, len(client.requests[0].Messages[0].MultipartContents))
+
+	// 纯文本的请求不受影响
+	res, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.Warning)
+}
+
+func TestDispatcher_FlattenMultipartAuto(t *testing.T) {
+	client := &multipartRejectingClient{}
+	d, factory := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+
+	// 渠道返回 invalid content type 错误时，转换为纯文本后重新请求同一个渠道
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}})
+	assert.NoError(t, err)
+	assert.Equal(t, MultipartFlattenedWarning, res.Warning)
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, 2, len(factory.providers))
+	assert.EqualValues(t, 1, factory.providers[1].ID)
+	assert.Equal(t, "what is this?\nhttps://example.com/1.png", client.requests[1].Messages[0].Content)
+
+	// 之后的请求直接转换
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/2.png")}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(client.requests))
+	assert.Equal(t, 0, len(client.requests[2].Messages[0].MultipartContents))
+}
+
+// fakeFlattenedChannelStore 多个实例共享的 flatten_multipart 渠道记录
+type fakeFlattenedChannelStore map[string]bool
+
+func (s fakeFlattenedChannelStore) IsChannelFlattened(ctx context.Context, key string) (bool, error) {
+	return s[key], nil
+}
+
+func (s fakeFlattenedChannelStore) FlattenChannel(ctx context.Context, key string) (bool, error) {
+	if s[key] {
+		return false, nil
+	}
+
+	s[key] = true
+	return true, nil
+}
+
+func TestDispatcher_FlattenMultipartAutoShared(t *testing.T) {
+	store := fakeFlattenedChannelStore{}
+
+	client := &multipartRejectingClient{}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+	d.flattened.store = store
+
+	_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}})
+	assert.NoError(t, err)
+	assert.True(t, store["channel:1"])
+
+	// 重启之后（或者其它实例）直接转换，不再请求失败一次
+	client = &multipartRejectingClient{}
+	d, _ = newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+	d.flattened.store = store
+
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/2.png")}})
+	assert.NoError(t, err)
+	assert.Equal(t, MultipartFlattenedWarning, res.Warning)
+	assert.Equal(t, 1, len(client.requests))
+	assert.Equal(t, 0, len(client.requests[0].Messages[0].MultipartContents))
+}
@@ -3,11 +3,12 @@ package chat
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"github.com/mylxsw/aidea-server/pkg/ai/google"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/uploader"
 	"github.com/mylxsw/asteria/log"
-	"github.com/mylxsw/go-utils/array"
 	"github.com/mylxsw/go-utils/ternary"
 	"strings"
 )
@@ -21,7 +22,7 @@ func NewGoogleChat(gai *google.GoogleAI) *GoogleChat {
 }
 
 func (chat *GoogleChat) initRequest(req Request) (*google.Request, error) {
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	var systemMessages Messages
 	var contextMessages Messages
@@ -31,6 +32,8 @@ func (chat *GoogleChat) initRequest(req Request) (*google.Request, error) {
 			Role:              msg.Role,
 			Content:           msg.Content,
 			MultipartContents: msg.MultipartContents,
+			ToolCalls:         msg.ToolCalls,
+			ToolCallID:        msg.ToolCallID,
 		}
 
 		if msg.Role == "system" {
@@ -40,27 +43,40 @@ func (chat *GoogleChat) initRequest(req Request) (*google.Request, error) {
 		}
 	}
 
-	contextMessages = contextMessages.Fix()
+	contextMessages = req.fixMessages(contextMessages)
 	if len(systemMessages) > 0 {
-		systemMessage := systemMessages[0]
-		finalSystemMessages := make(Messages, 0)
+		contextMessages = append(foldSystemMessage(systemMessages[0]), contextMessages...)
+	}
 
-		systemMessage.Role = "user"
-		finalSystemMessages = append(
-			finalSystemMessages,
-			systemMessage,
-			Message{
-				Role:    "assistant",
-				Content: "好的",
-			},
-		)
+	googleReq := google.Request{}
+	if len(req.Stop) > 0 {
+		// Gemini 不返回触发的停止序列，由 Dispatcher 根据输出内容推断
+		googleReq.GenerationConfig = &google.GenerationConfig{StopSequences: req.Stop}
+	}
 
-		contextMessages = append(finalSystemMessages, contextMessages...)
+	if len(req.Tools) > 0 {
+		googleReq.Tools = []google.Tool{{FunctionDeclarations: toGoogleFunctionDeclarations(req.Tools)}}
 	}
 
-	googleReq := google.Request{}
+	googleReq.Contents = make([]google.Message, 0, len(contextMessages))
+	for i, msg := range contextMessages {
+		// 工具调用结果转换为 functionResponse，同一轮的多个结果合并到一条消息中，与模型消息中的 functionCall 一一对应
+		if msg.Role == RoleTool {
+			if part, ok := toGoogleFunctionResponse(contextMessages[:i], msg); ok {
+				if last := len(googleReq.Contents) - 1; last >= 0 && googleReq.Contents[last].Role == google.RoleFunction {
+					googleReq.Contents[last].Parts = append(googleReq.Contents[last].Parts, part)
+				} else {
+					googleReq.Contents = append(googleReq.Contents, google.Message{Role: google.RoleFunction, Parts: []google.MessagePart{part}})
+				}
+
+				continue
+			}
+
+			// 找不到对应的工具调用时，作为普通的用户消息
+			log.F(log.M{"tool_call_id": msg.ToolCallID}).Warningf("tool call not found for tool message, send as user message")
+			msg.Role = RoleUser
+		}
 
-	googleReq.Contents = array.Map(contextMessages, func(msg Message, _ int) google.Message {
 		contents := make([]google.MessagePart, 0)
 		if len(msg.MultipartContents) == 0 {
 			contents = append(contents, google.MessagePart{
@@ -73,6 +89,7 @@ func (chat *GoogleChat) initRequest(req Request) (*google.Request, error) {
 						Text: ct.Text,
 					})
 				} else if ct.ImageURL != nil {
+					// Gemini 不支持图片识别精度，忽略 ImageURL.Detail
 					if strings.HasPrefix(ct.ImageURL.URL, "http://") || strings.HasPrefix(ct.ImageURL.URL, "https://") {
 						encoded, mimeType, err := uploader.DownloadRemoteFileAsBase64Raw(context.TODO(), ct.ImageURL.URL, true)
 						if err == nil {
@@ -100,11 +117,22 @@ func (chat *GoogleChat) initRequest(req Request) (*google.Request, error) {
 			}
 		}
 
-		return google.Message{
+		for _, call := range msg.ToolCalls {
+			contents = append(contents, google.MessagePart{
+				FunctionCall: &google.FunctionCall{Name: call.Function.Name, Args: googleFunctionArgs(call.Function.Arguments)},
+			})
+		}
+
+		// 只包含工具调用的助手消息，不需要空的文本内容
+		if len(msg.ToolCalls) > 0 && strings.TrimSpace(msg.Content) == "" && len(msg.MultipartContents) == 0 {
+			contents = contents[1:]
+		}
+
+		googleReq.Contents = append(googleReq.Contents, google.Message{
 			Role:  ternary.IfElse(msg.Role == "user", google.RoleUser, google.RoleModel),
 			Parts: contents,
-		}
-	})
+		})
+	}
 
 	return &googleReq, nil
 }
@@ -117,7 +145,7 @@ func (chat *GoogleChat) Chat(ctx context.Context, req Request) (*Response, error
 
 	// Vision 模型必须要有图片才能用
 	if req.Model == google.ModelGeminiProVision && !googleReq.HasImage() {
-		return &Response{Text: "当前模型有以下限制，请您知晓：\n\n- 每次提问必须上传一张图片\n- 不支持多轮对话"}, nil
+		return &Response{Text: "当前模型有以下限制，请您知晓：\n\n- 每次提问必须上传一张图片\n- 不支持多轮对话", FinishReason: FinishReasonStop}, nil
 	}
 
 	res, err := chat.gai.Chat(ctx, req.Model, *googleReq)
@@ -130,7 +158,107 @@ func (chat *GoogleChat) Chat(ctx context.Context, req Request) (*Response, error
 		resText += "\n\n> 注意：当前模型不支持多轮对话，对话结束"
 	}
 
-	return &Response{Text: resText}, nil
+	ret := Response{Text: resText, FinishReason: googleFinishReason(res), ToolCalls: fromGoogleFunctionCalls(res.FunctionCalls(), 0)}
+	if len(ret.ToolCalls) > 0 {
+		// Gemini 发起函数调用时，结束原因仍然为 STOP
+		ret.FinishReason = FinishReasonToolCalls
+	}
+
+	if filterErr := geminiContentFilterError(res); filterErr != nil {
+		ret = filterErr.Attach(ret)
+	}
+
+	return &ret, nil
+}
+
+// googleFinishReason 返回 Google Gemini 响应中的结束原因
+func googleFinishReason(res *google.Response) string {
+	for _, candidate := range res.Candidates {
+		if ret := NormalizeFinishReason(candidate.FinishReason); ret != "" {
+			return ret
+		}
+	}
+
+	return ""
+}
+
+// toGoogleFunctionDeclarations 将 OpenAI 兼容格式的工具定义转换为 Gemini 的函数定义
+func toGoogleFunctionDeclarations(tools []Tool) []google.FunctionDeclaration {
+	ret := make([]google.FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		ret = append(ret, google.FunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+
+	return ret
+}
+
+// fromGoogleFunctionCalls 将 Gemini 的函数调用转换为 OpenAI 兼容格式的工具调用
+//
+// Gemini 的函数调用没有 ID，这里根据序号（offset 为流式输出中之前已经返回的函数调用数量）生成，
+// 下一轮对话中根据 ID 查找工具调用结果对应的函数名称
+func fromGoogleFunctionCalls(calls []google.FunctionCall, offset int) []ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	ret := make([]ToolCall, 0, len(calls))
+	for i, call := range calls {
+		ret = append(ret, ToolCall{
+			ID:       fmt.Sprintf("call_%d_%s", offset+i, call.Name),
+			Type:     "function",
+			Function: ToolCallFunction{Name: call.Name, Arguments: string(googleFunctionArgs(string(call.Args)))},
+		})
+	}
+
+	return ret
+}
+
+// googleFunctionArgs 函数参数必须为 JSON 对象，参数为空或者不是合法的 JSON 时使用空对象
+func googleFunctionArgs(arguments string) json.RawMessage {
+	arguments = strings.TrimSpace(arguments)
+	if arguments == "" || arguments == "null" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+
+	return json.RawMessage(arguments)
+}
+
+// toGoogleFunctionResponse 将工具调用结果转换为 functionResponse，函数名称从之前的助手消息中根据工具调用 ID 查找，
+// 找不到对应的工具调用时返回 false
+func toGoogleFunctionResponse(history Messages, msg Message) (google.MessagePart, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		for _, call := range history[i].ToolCalls {
+			if call.ID != msg.ToolCallID {
+				continue
+			}
+
+			return google.MessagePart{
+				FunctionResponse: &google.FunctionResponse{Name: call.Function.Name, Response: googleFunctionResponse(msg.Text())},
+			}, true
+		}
+	}
+
+	return google.MessagePart{}, false
+}
+
+// googleFunctionResponse Gemini 要求函数调用的结果为 JSON 对象，其它内容（包括非对象的 JSON 值）包装为 {"result": ...}
+func googleFunctionResponse(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+
+	var result any = content
+	if trimmed != "" && json.Valid([]byte(trimmed)) {
+		result = json.RawMessage(trimmed)
+	}
+
+	data, _ := json.Marshal(map[string]any{"result": result})
+	return data
 }
 
 func (chat *GoogleChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
@@ -160,7 +288,18 @@ func (chat *GoogleChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 
 	res := make(chan Response)
 	go func() {
+		// toolCalls 已经返回的函数调用，在包含结束原因的响应中一起返回
+		var toolCalls []ToolCall
+		toolCallsSent := false
+
 		defer func() {
+			if !toolCallsSent && len(toolCalls) > 0 {
+				select {
+				case <-ctx.Done():
+				case res <- Response{ToolCalls: toolCalls, FinishReason: FinishReasonToolCalls}:
+				}
+			}
+
 			if req.Model == google.ModelGeminiProVision {
 				select {
 				case res <- Response{Text: "\n\n> 注意：当前模型不支持多轮对话，对话结束"}:
@@ -187,9 +326,42 @@ func (chat *GoogleChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 					return
 				}
 
+				// Gemini 的函数调用在一个响应中完整返回，不需要组装
+				calls := fromGoogleFunctionCalls(data.FunctionCalls(), len(toolCalls))
+				for _, call := range calls {
+					toolCalls = append(toolCalls, call)
+					if !req.StreamToolCalls {
+						continue
+					}
+
+					delta := ToolCallDelta{Index: len(toolCalls) - 1, ID: call.ID, Name: call.Function.Name, ArgumentsDelta: call.Function.Arguments}
+					select {
+					case <-ctx.Done():
+						return
+					case res <- Response{ToolCallDelta: &delta, Interim: true}:
+					}
+				}
+
+				ret := Response{Text: data.String(), FinishReason: googleFinishReason(&data)}
+				if ret.FinishReason != "" && len(toolCalls) > 0 {
+					ret.ToolCalls = toolCalls
+					ret.FinishReason = FinishReasonToolCalls
+					toolCallsSent = true
+				}
+
+				filterErr := geminiContentFilterError(&data)
+				if filterErr != nil {
+					ret = filterErr.Attach(ret)
+				}
+
+				// 只包含函数调用的响应，不需要返回空的文本
+				if ret.Text == "" && ret.FinishReason == "" && len(calls) > 0 && filterErr == nil {
+					continue
+				}
+
 				select {
 				case <-ctx.Done():
-				case res <- Response{Text: data.String()}:
+				case res <- ret:
 				}
 			}
 		}
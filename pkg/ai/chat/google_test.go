@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/google"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// geminiFixtureServer 按照请求顺序依次返回 testdata 中录制的 Gemini 响应，并记录请求体
+func geminiFixtureServer(t *testing.T, requests *[]google.Request, fixtures ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+
+		var req google.Request
+		assert.NoError(t, json.Unmarshal(data, &req))
+		*requests = append(*requests, req)
+
+		fixture, err := os.ReadFile(fixtures[len(*requests)-1])
+		assert.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(fixture)
+	}))
+}
+
+var geminiTools = []Tool{
+	{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        "get_weather",
+			Description: "查询城市的天气",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"city": map[string]any{"type": "string"}},
+			},
+		},
+	},
+	{Type: "function", Function: ToolFunction{Name: "get_time", Description: "查询当前时间"}},
+}
+
+func TestGoogleChat_FunctionCallRoundTrip(t *testing.T) {
+	var requests []google.Request
+	server := geminiFixtureServer(t, &requests, "testdata/gemini_function_call.json", "testdata/gemini_function_answer.json")
+	defer server.Close()
+
+	imp := NewGoogleChat(google.NewGoogleAI(server.URL, "test-key"))
+	messages := Messages{{Role: RoleUser, Content: "北京现在的天气怎么样？现在几点了？"}}
+
+	// 模型发起函数调用
+	res, err := imp.Chat(context.TODO(), Request{Model: google.ModelGeminiPro, Messages: messages, Tools: geminiTools})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(requests[0].Tools[0].FunctionDeclarations))
+	assert.Equal(t, "get_weather", requests[0].Tools[0].FunctionDeclarations[0].Name)
+
+	assert.Equal(t, FinishReasonToolCalls, res.FinishReason)
+	assert.Equal(t, 2, len(res.ToolCalls))
+	assert.Equal(t, "get_weather", res.ToolCalls[0].Function.Name)
+	assert.Equal(t, `{"city": "北京"}`, res.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "get_time", res.ToolCalls[1].Function.Name)
+	assert.Equal(t, "{}", res.ToolCalls[1].Function.Arguments)
+	assert.True(t, res.ToolCalls[0].ID != res.ToolCalls[1].ID)
+
+	// 执行工具调用，get_time 返回的不是 JSON 对象
+	messages = append(messages,
+		Message{Role: RoleAssistant, ToolCalls: res.ToolCalls},
+		Message{Role: RoleTool, ToolCallID: res.ToolCalls[0].ID, Content: `{"temperature": 22, "condition": "晴"}`},
+		Message{Role: RoleTool, ToolCallID: res.ToolCalls[1].ID, Content: "14:30"},
+	)
+
+	// 模型根据工具调用结果回答
+	res, err = imp.Chat(context.TODO(), Request{Model: google.ModelGeminiPro, Messages: messages, Tools: geminiTools})
+	assert.NoError(t, err)
+	assert.Equal(t, FinishReasonStop, res.FinishReason)
+	assert.Equal(t, 0, len(res.ToolCalls))
+	assert.True(t, strings.Contains(res.Text, "14:30"))
+
+	contents := requests[1].Contents
+	assert.Equal(t, 3, len(contents))
+	assert.Equal(t, google.RoleUser, contents[0].Role)
+
+	// 模型消息中只包含函数调用
+	assert.Equal(t, google.RoleModel, contents[1].Role)
+	assert.Equal(t, 2, len(contents[1].Parts))
+	assert.Equal(t, "get_weather", contents[1].Parts[0].FunctionCall.Name)
+	assert.Equal(t, `{"city":"北京"}`, string(contents[1].Parts[0].FunctionCall.Args))
+	assert.Equal(t, "get_time", contents[1].Parts[1].FunctionCall.Name)
+
+	// 函数调用结果按照顺序合并到一条消息中，非 JSON 对象的结果被包装
+	assert.Equal(t, google.RoleFunction, contents[2].Role)
+	assert.Equal(t, 2, len(contents[2].Parts))
+	assert.Equal(t, "get_weather", contents[2].Parts[0].FunctionResponse.Name)
+	assert.Equal(t, `{"temperature":22,"condition":"晴"}`, string(contents[2].Parts[0].FunctionResponse.Response))
+	assert.Equal(t, "get_time", contents[2].Parts[1].FunctionResponse.Name)
+	assert.Equal(t, `{"result":"14:30"}`, string(contents[2].Parts[1].FunctionResponse.Response))
+}
+
+func TestGoogleChat_ChatStreamFunctionCall(t *testing.T) {
+	var requests []google.Request
+	server := geminiFixtureServer(t, &requests, "testdata/gemini_function_call_stream.json")
+	defer server.Close()
+
+	stream, err := NewGoogleChat(google.NewGoogleAI(server.URL, "test-key")).ChatStream(context.TODO(), Request{
+		Model:           google.ModelGeminiPro,
+		Messages:        Messages{{Role: RoleUser, Content: "北京现在的天气怎么样？现在几点了？"}},
+		Tools:           geminiTools,
+		StreamToolCalls: true,
+	})
+	assert.NoError(t, err)
+
+	var responses []Response
+	for res := range stream {
+		responses = append(responses, res)
+	}
+
+	assert.Equal(t, 4, len(responses))
+	assert.Equal(t, "好的，我来查询一下。", responses[0].Text)
+
+	assert.True(t, responses[1].Interim)
+	assert.Equal(t, ToolCallDelta{Index: 0, ID: "call_0_get_weather", Name: "get_weather", ArgumentsDelta: `{"city":"北京"}`}, *responses[1].ToolCallDelta)
+	assert.True(t, responses[2].Interim)
+	assert.Equal(t, 1, responses[2].ToolCallDelta.Index)
+
+	final := responses[3]
+	assert.False(t, final.Interim)
+	assert.Equal(t, FinishReasonToolCalls, final.FinishReason)
+	assert.Equal(t, 2, len(final.ToolCalls))
+	assert.Equal(t, "get_time", final.ToolCalls[1].Function.Name)
+}
+
+func TestGoogleFunctionResponse(t *testing.T) {
+	assert.Equal(t, `{"a":1}`, string(googleFunctionResponse(`{"a":1}`)))
+	assert.Equal(t, `{"result":[1,2]}`, string(googleFunctionResponse(`[1, 2]`)))
+	assert.Equal(t, `{"result":42}`, string(googleFunctionResponse("42")))
+	assert.Equal(t, `{"result":"not json {"}`, string(googleFunctionResponse("not json {")))
+	assert.Equal(t, `{"result":""}`, string(googleFunctionResponse("")))
+}
+
+func TestMessages_FixToolCalls(t *testing.T) {
+	call1 := ToolCall{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"北京"}`}}
+	call2 := ToolCall{ID: "call_2", Type: "function", Function: ToolCallFunction{Name: "get_time", Arguments: `{}`}}
+
+	// 工具调用结果作为最后一轮，不补充用户消息，多条结果全部保留，且与发起工具调用的助手消息相邻
+	messages := Messages{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "hello"},
+		{Role: RoleAssistant, Content: "hi"},
+		{Role: RoleUser, Content: "天气和时间"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{call1, call2}},
+		{Role: RoleTool, ToolCallID: "call_1", Content: "晴"},
+		{Role: RoleTool, ToolCallID: "call_2", Content: "14:30"},
+	}.Fix()
+
+	assert.Equal(t, 7, len(messages))
+	assert.Equal(t, 2, len(messages[4].ToolCalls))
+	assert.Equal(t, "call_1", messages[5].ToolCallID)
+	assert.Equal(t, "call_2", messages[6].ToolCallID)
+
+	// 工具调用之后模型已经回答，继续对话
+	messages = append(messages, Message{Role: RoleAssistant, Content: "晴，14:30"}, Message{Role: RoleUser, Content: "谢谢"}).Fix()
+	assert.Equal(t, 9, len(messages))
+	assert.Equal(t, RoleTool, messages[6].Role)
+	assert.Equal(t, RoleAssistant, messages[7].Role)
+
+	// 工具调用结果被丢弃时（用户直接发送了新的消息），移除助手消息中对应的工具调用
+	messages = Messages{
+		{Role: RoleUser, Content: "天气"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{call1}},
+		{Role: RoleTool, ToolCallID: "call_1", Content: "晴"},
+		{Role: RoleUser, Content: "算了，不用查了"},
+	}.Fix()
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, 0, len(messages[1].ToolCalls))
+	assert.Equal(t, "算了，不用查了", messages[2].Content)
+
+	// 工具调用结果不能作为第一轮
+	messages = Messages{
+		{Role: RoleTool, ToolCallID: "call_1", Content: "晴"},
+		{Role: RoleAssistant, Content: "晴"},
+		{Role: RoleUser, Content: "谢谢"},
+	}.Fix()
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, RoleUser, messages[0].Role)
+}
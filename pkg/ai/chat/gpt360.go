@@ -18,11 +18,11 @@ func NewGPT360Chat(g360 *gpt360.GPT360) *GPT360Chat {
 }
 
 func (ds *GPT360Chat) initRequest(req Request) gpt360.ChatRequest {
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	messages := array.Map(req.Messages, func(item Message, _ int) gpt360.Message {
 		return gpt360.Message{
-			Role:    item.Role,
+			Role:    string(item.Role),
 			Content: item.Content,
 		}
 	})
@@ -53,7 +53,7 @@ func (ds *GPT360Chat) Chat(ctx context.Context, req Request) (*Response, error)
 
 	return &Response{
 		Text:         content,
-		FinishReason: finishReason,
+		FinishReason: NormalizeFinishReason(finishReason),
 		InputTokens:  resp.Usage.PromptTokens,
 		OutputTokens: resp.Usage.CompletionTokens,
 	}, nil
@@ -98,7 +98,7 @@ func (ds *GPT360Chat) ChatStream(ctx context.Context, req Request) (<-chan Respo
 					return
 				case res <- Response{
 					Text:         content,
-					FinishReason: finishReason,
+					FinishReason: NormalizeFinishReason(finishReason),
 					InputTokens:  data.Usage.PromptTokens,
 					OutputTokens: data.Usage.CompletionTokens,
 				}:
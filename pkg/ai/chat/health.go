@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+)
+
+const (
+	// defaultUnhealthyThreshold 连续失败多少次后标记为不健康
+	defaultUnhealthyThreshold = 3
+	// defaultUnhealthyCooldown 标记为不健康后，多久之后重新尝试
+	defaultUnhealthyCooldown = time.Minute
+	// defaultQuotaExceededCooldown 账户额度用完后暂停使用的时长，额度需要人工充值或者调整，不会很快恢复
+	defaultQuotaExceededCooldown = 30 * time.Minute
+)
+
+// ChannelHealth 服务提供商（渠道）的健康状态
+type ChannelHealth interface {
+	// Healthy 服务提供商当前是否可用
+	Healthy(provider repo.ModelProvider) bool
+	// Report 报告请求结果，err 为 nil 时表示请求成功
+	Report(provider repo.ModelProvider, err error)
+	// Sidelined 服务提供商是否因为账户额度用完（参考 ErrQuotaExceeded）被暂停使用
+	Sidelined(provider repo.ModelProvider) bool
+}
+
+// HealthTracker 根据请求结果统计服务提供商的健康状态（只在当前实例的内存中统计）
+//
+// 连续失败 threshold 次后标记为不健康，cooldown 之后恢复为健康状态，允许请求再次尝试；
+// 账户额度用完时立即标记为不健康，并暂停使用 quotaCooldown
+type HealthTracker struct {
+	threshold     int
+	cooldown      time.Duration
+	quotaCooldown time.Duration
+	now           func() time.Time
+
+	lock   sync.Mutex
+	states map[string]*healthState
+}
+
+type healthState struct {
+	failures       int
+	unhealthyUntil time.Time
+	// quotaExceeded 是否因为账户额度用完被标记为不健康
+	quotaExceeded bool
+}
+
+func NewHealthTracker(threshold int, cooldown time.Duration) *HealthTracker {
+	return &HealthTracker{
+		threshold:     threshold,
+		cooldown:      cooldown,
+		quotaCooldown: defaultQuotaExceededCooldown,
+		now:           time.Now,
+		states:        make(map[string]*healthState),
+	}
+}
+
+func (t *HealthTracker) Healthy(provider repo.ModelProvider) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	state, ok := t.states[providerKey(provider)]
+	return !ok || !t.now().Before(state.unhealthyUntil)
+}
+
+func (t *HealthTracker) Report(provider repo.ModelProvider, err error) {
+	// 用户取消请求、内容违规等不是服务提供商的问题
+	if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, ErrContentFilter) || errors.Is(err, ErrContextExceedLimit)) {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := providerKey(provider)
+	if err == nil {
+		delete(t.states, key)
+		return
+	}
+
+	state, ok := t.states[key]
+	if !ok {
+		state = &healthState{}
+		t.states[key] = state
+	}
+
+	if errors.Is(err, ErrQuotaExceeded) {
+		state.failures = 0
+		state.unhealthyUntil = t.now().Add(t.quotaCooldown)
+		state.quotaExceeded = true
+
+		log.F(log.M{"channel_id": provider.ID, "provider": provider.Name, "resume_at": state.unhealthyUntil}).
+			Errorf("服务提供商账户额度已用完，暂停使用 %s，请充值或者调整账户的消费上限：%v", t.quotaCooldown, err)
+		return
+	}
+
+	state.failures++
+	if state.failures >= t.threshold {
+		state.failures = 0
+		state.unhealthyUntil = t.now().Add(t.cooldown)
+		state.quotaExceeded = false
+	}
+}
+
+func (t *HealthTracker) Sidelined(provider repo.ModelProvider) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	state, ok := t.states[providerKey(provider)]
+	return ok && state.quotaExceeded && t.now().Before(state.unhealthyUntil)
+}
+
+// ModelHealthChecker 查询模型当前是否有健康的服务提供商，用于后台任务在上游异常时暂停请求
+type ModelHealthChecker interface {
+	ModelHealthy(ctx context.Context, model string) bool
+}
+
+// ModelHealthy 模型的服务提供商中至少有一个是健康的，模型不存在时返回 false，未统计健康状态时总是返回 true
+func (d *Dispatcher) ModelHealthy(ctx context.Context, model string) bool {
+	mod, err := d.router.Model(ctx, model)
+	if err != nil {
+		return false
+	}
+
+	if d.health == nil || len(mod.Providers) == 0 {
+		return true
+	}
+
+	for _, pro := range mod.Providers {
+		if d.health.Healthy(pro) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// providerKey 服务提供商的唯一标识，渠道使用渠道 ID，配置文件中的服务提供商使用名称
+func providerKey(provider repo.ModelProvider) string {
+	if provider.ID > 0 {
+		return fmt.Sprintf("channel:%d", provider.ID)
+	}
+
+	return "provider:" + provider.Name
+}
+
+// healthReportingChat 将请求结果报告给 ChannelHealth
+type healthReportingChat struct {
+	imp      Chat
+	health   ChannelHealth
+	provider repo.ModelProvider
+}
+
+func (c *healthReportingChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	res, err := c.imp.Chat(ctx, req)
+	c.health.Report(c.provider, err)
+	return res, err
+}
+
+func (c *healthReportingChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	stream, err := c.imp.ChatStream(ctx, req)
+	c.health.Report(c.provider, err)
+	return stream, err
+}
+
+func (c *healthReportingChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
@@ -0,0 +1,74 @@
+package chat
+
+// capConversationImages 限制整个对话中图片的总数量，超过 maxImages 时从最早的消息开始去掉图片，只保留文本内容，
+// 最后一条用户消息中的图片总是保留（即使其本身已经超过限制），maxImages 为 0 时不限制
+func capConversationImages(messages Messages, maxImages int) Messages {
+	if maxImages <= 0 {
+		return messages
+	}
+
+	latestUser := -1
+	total := 0
+	for i, msg := range messages {
+		if msg.Role == RoleUser {
+			latestUser = i
+		}
+
+		total += countImages(msg)
+	}
+
+	excess := total - maxImages
+	if excess <= 0 {
+		return messages
+	}
+
+	capped := make(Messages, len(messages))
+	copy(capped, messages)
+
+	for i, msg := range capped {
+		if excess <= 0 {
+			break
+		}
+
+		if i == latestUser || countImages(msg) == 0 {
+			continue
+		}
+
+		parts := make([]*MultipartContent, 0, len(msg.MultipartContents))
+		for _, part := range msg.MultipartContents {
+			if excess > 0 && isImagePart(part) {
+				excess--
+				continue
+			}
+
+			parts = append(parts, part)
+		}
+
+		if countImages(Message{MultipartContents: parts}) == 0 {
+			// 去掉所有图片后与 stripImages 的处理方式相同，只保留文本内容
+			msg = stripImages(Messages{msg})[0]
+		} else {
+			msg.MultipartContents = parts
+		}
+
+		capped[i] = msg
+	}
+
+	return capped
+}
+
+// countImages 消息中图片的数量
+func countImages(msg Message) int {
+	count := 0
+	for _, part := range msg.MultipartContents {
+		if isImagePart(part) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func isImagePart(part *MultipartContent) bool {
+	return part != nil && part.ImageURL != nil && part.ImageURL.URL != ""
+}
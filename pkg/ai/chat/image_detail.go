@@ -0,0 +1,24 @@
+package chat
+
+import "github.com/mylxsw/aidea-server/pkg/service"
+
+// ImageDetailDefaultedWarning 未指定识别精度的图片按照低精度处理时，在响应中返回的警告信息
+const ImageDetailDefaultedWarning = "对话中的图片未指定识别精度，已按照低精度（low）识别，需要识别图片细节时可以指定高精度（high）重新生成"
+
+// imageDetailDefaulted 请求发送给 providerType 类型的服务提供商时，是否有图片因为未指定识别精度而按照低精度处理（参考 openAIImageDetail），
+// 只有 OpenAI 系列的服务提供商会设置默认值，其它服务提供商忽略识别精度
+func imageDetailDefaulted(providerType string, messages Messages) bool {
+	if providerType != service.ProviderOpenAI && providerType != service.ProviderOneAPI {
+		return false
+	}
+
+	for _, msg := range messages {
+		for _, part := range msg.MultipartContents {
+			if part != nil && part.ImageURL != nil && part.ImageURL.Detail == "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
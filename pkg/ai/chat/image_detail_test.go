@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/dashscope"
+	"github.com/mylxsw/aidea-server/pkg/ai/google"
+	"github.com/mylxsw/aidea-server/pkg/ai/zhipuai"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// imageDetailRequest 包含设置了识别精度的图片消息的请求，imageURL 为空时使用 probeImage
+func imageDetailRequest(model, imageURL, detail string) Request {
+	if imageURL == "" {
+		imageURL = probeImage
+	}
+
+	return Request{
+		Model: model,
+		Messages: Messages{{
+			Role: RoleUser,
+			MultipartContents: []*MultipartContent{
+				{Type: "text", Text: "what is this?"},
+				{Type: "image_url", ImageURL: &ImageURL{URL: imageURL, Detail: detail}},
+			},
+		}},
+	}
+}
+
+// assertNoDetailField 服务提供商的请求中不能包含 detail 字段
+func assertNoDetailField(t *testing.T, req any) {
+	t.Helper()
+
+	data, err := json.Marshal(req)
+	assert.NoError(t, err)
+
+	if strings.Contains(string(data), `"detail"`) {
+		t.Errorf("unexpected detail field in request: %s", string(data))
+	}
+}
+
+func TestImageDetail_NonOpenAIProviders(t *testing.T) {
+	for _, detail := range []string{"", "low", "high", "auto"} {
+		t.Run("detail="+detail, func(t *testing.T) {
+			claudeReq, err := (&AnthropicChat{}).initRequest(imageDetailRequest("claude-3-opus", "", detail))
+			assert.NoError(t, err)
+			assertNoDetailField(t, claudeReq)
+
+			geminiReq, err := (&GoogleChat{}).initRequest(imageDetailRequest(google.ModelGeminiProVision, "", detail))
+			assert.NoError(t, err)
+			assertNoDetailField(t, geminiReq)
+
+			glmReq := (&ZhipuChat{}).initRequest(imageDetailRequest(zhipuai.ModelGLM4V, "https://example.com/a.png", detail))
+			assertNoDetailField(t, glmReq)
+
+			qwenReq := (&DashScopeChat{}).initRequest(imageDetailRequest(dashscope.ModelQWenVLMax, "https://example.com/a.png", detail))
+			assertNoDetailField(t, qwenReq)
+			// 通义千问 VL 只在识别精度为 high 时开启高分辨率模式
+			assert.Equal(t, detail == "high", qwenReq.Parameters.VLHighResolutionImages)
+		})
+	}
+}
+
+func TestImageDetail_OpenAI(t *testing.T) {
+	cases := map[string]string{"": "low", "low": "low", "high": "high", "auto": "auto"}
+	for detail, expected := range cases {
+		openaiReq, err := (&OpenAIChat{}).initRequest(imageDetailRequest("gpt-4o", "", detail))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, openaiReq.Messages[0].MultiContent[1].ImageURL.Detail)
+
+		oneapiReq, err := (&OneAPIChat{}).initRequest(imageDetailRequest("gpt-4o", "", detail))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, oneapiReq.Messages[0].MultiContent[1].ImageURL.Detail)
+	}
+}
+
+func TestDispatcher_ImageDetailDefaulted(t *testing.T) {
+	client := &streamChatClient{}
+	d, factory := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+
+	// 未指定识别精度的图片按照低精度识别，返回警告信息
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageDetailDefaultedWarning, res.Warning)
+	assert.EqualValues(t, []string{ImageDetailDefaultedWarning}, res.Warnings)
+
+	// 已经有其它警告信息时一起返回，warning 为第一条
+	req := Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}}
+	req.warnings = []string{VisionDegradedWarning}
+	res, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, VisionDegradedWarning, res.Warning)
+	assert.EqualValues(t, []string{VisionDegradedWarning, ImageDetailDefaultedWarning}, res.Warnings)
+
+	// 图片中指定了识别精度
+	msg := imageMessage("https://example.com/1.png")
+	msg.MultipartContents[1].ImageURL.Detail = "low"
+	res, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{msg}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.Warning)
+
+	// 请求中指定了默认的识别精度
+	req = Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}, ImageDetail: "high"}
+	res, err = d.Chat(context.TODO(), req.ApplyDefaults())
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.Warning)
+	assert.Equal(t, "high", client.requests[3].Messages[0].MultipartContents[1].ImageURL.Detail)
+
+	// 忽略识别精度的服务提供商
+	factory.typ = service.ProviderGoogle
+	res, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage("https://example.com/1.png")}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.Warning)
+}
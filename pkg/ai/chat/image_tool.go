@@ -0,0 +1,277 @@
+package chat
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/internal/coins"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/aliyun"
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/uploader"
+	"github.com/mylxsw/asteria/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ImageToolName 服务端执行的图片生成工具
+const ImageToolName = "generate_image"
+
+const (
+	// imageToolDefaultTimeout 未配置超时时间时，每张图片生成的超时时间
+	imageToolDefaultTimeout = 90 * time.Second
+	// imageToolMaxPromptRunes 图片描述的最大长度
+	imageToolMaxPromptRunes = 1000
+)
+
+// imageToolDefinition 提供给模型的工具定义
+var imageToolDefinition = Tool{
+	Type: "function",
+	Function: ToolFunction{
+		Name:        ImageToolName,
+		Description: "Generate an image from a text description. Call it when the user asks you to draw, paint or create a picture. The result contains the URL of the generated image, which is shown to the user automatically.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"prompt": map[string]any{
+					"type":        "string",
+					"description": "Detailed description of the image to generate, in English",
+				},
+			},
+			"required": []string{"prompt"},
+		},
+	},
+}
+
+// ImageToolOptions 请求中 generate_image 工具的配置
+type ImageToolOptions struct {
+	// Remaining 本次请求最多可以生成的图片数量（对话中剩余的额度），用完之后模型仍然可以调用工具，但只会得到达到上限的提示
+	Remaining int
+}
+
+// ErrImagePromptUnsafe 图片描述没有通过内容安全检测
+var ErrImagePromptUnsafe = errors.New("image prompt is rejected by content moderation")
+
+// ImageGenerator 根据描述生成图片，返回上传到对象存储之后的图片地址，图片描述没有通过内容安全检测时返回 ErrImagePromptUnsafe
+type ImageGenerator interface {
+	GenerateImage(ctx context.Context, userID int64, prompt string) (string, error)
+}
+
+// PromptDetector 图片描述的内容安全检测（与创作岛相同，参考 service.SecurityService），检测失败时返回 nil
+type PromptDetector interface {
+	PromptDetect(prompt string) *aliyun.CheckResult
+}
+
+// ImageToolUsage 一次请求中通过 generate_image 工具生成图片的数量，用于按照图片生成模型的价格计费
+type ImageToolUsage struct {
+	lock   sync.Mutex
+	model  string
+	images int
+}
+
+type imageToolUsageKey struct{}
+
+// WithImageToolUsage 返回的 ImageToolUsage 记录使用返回的 ctx 发起的请求中生成的图片
+func WithImageToolUsage(ctx context.Context) (context.Context, *ImageToolUsage) {
+	usage := &ImageToolUsage{}
+	return context.WithValue(ctx, imageToolUsageKey{}, usage), usage
+}
+
+// Model 生成图片使用的模型
+func (u *ImageToolUsage) Model() string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.model
+}
+
+// Images 成功生成的图片数量
+func (u *ImageToolUsage) Images() int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.images
+}
+
+// Usage 图片生成的用量，用于统一计费（coins.ComputeFee）
+func (u *ImageToolUsage) Usage() coins.Usage {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return coins.ImageUsage{Model: u.model, Images: u.images}
+}
+
+func (u *ImageToolUsage) add(model string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.model = model
+	u.images++
+}
+
+// CountGeneratedImages 返回对话中助手回复包含的图片数量（即已经生成的图片），用于计算对话中剩余的图片生成额度
+func CountGeneratedImages(messages Messages) int {
+	count := 0
+	for _, msg := range messages {
+		if msg.Role == RoleAssistant {
+			count += countImages(msg)
+		}
+	}
+
+	return count
+}
+
+// CountStoredImages 返回聊天记录中保存的回复多模态内容（chat_messages.parts，JSON 格式）包含的图片数量，
+// 与 CountGeneratedImages 不同，不依赖客户端发送的历史消息，格式错误的内容忽略
+func CountStoredImages(parts []string) int {
+	count := 0
+	for _, data := range parts {
+		var contents []*MultipartContent
+		if err := json.Unmarshal([]byte(data), &contents); err != nil {
+			log.Warningf("invalid stored reply parts, ignored: %v", err)
+			continue
+		}
+
+		count += countImages(Message{MultipartContents: contents})
+	}
+
+	return count
+}
+
+// imageTool 服务端执行的图片生成工具：使用图片生成模型生成图片并上传到对象存储，图片地址作为工具调用的结果返回给模型，
+// 生成的图片通过 Response.Parts 返回给用户。只对设置了 Request.ImageTool 的请求生效
+type imageTool struct {
+	generator ImageGenerator
+	// model 图片生成模型，用于计费
+	model string
+	// timeout 每张图片生成的超时时间
+	timeout time.Duration
+}
+
+func NewImageTool(generator ImageGenerator, model string, timeout time.Duration) ServerTool {
+	if timeout <= 0 {
+		timeout = imageToolDefaultTimeout
+	}
+
+	return &imageTool{generator: generator, model: model, timeout: timeout}
+}
+
+func (t *imageTool) Begin(ctx context.Context, req Request) ServerToolSession {
+	if req.ImageTool == nil {
+		return nil
+	}
+
+	return &imageToolSession{tool: t, userID: req.UserID, remaining: req.ImageTool.Remaining}
+}
+
+// imageToolSession 图片生成工具在一次请求中的状态
+type imageToolSession struct {
+	tool      *imageTool
+	userID    int64
+	remaining int
+}
+
+func (sess *imageToolSession) Definition() Tool {
+	return imageToolDefinition
+}
+
+func (sess *imageToolSession) Execute(ctx context.Context, call ToolCall) ToolResult {
+	var args struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil || strings.TrimSpace(args.Prompt) == "" {
+		return ToolResult{Content: toolResultJSON(map[string]any{"error": "invalid arguments, prompt is required"})}
+	}
+
+	if sess.remaining <= 0 {
+		return ToolResult{Content: toolResultJSON(map[string]any{"error": "the image generation limit of this conversation has been reached, tell the user that no more images can be generated in this conversation"})}
+	}
+
+	prompt := misc.SubStringRaw(strings.TrimSpace(args.Prompt), imageToolMaxPromptRunes)
+
+	genCtx, cancel := context.WithTimeout(ctx, sess.tool.timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	url, err := sess.tool.generator.GenerateImage(genCtx, sess.userID, prompt)
+	if err != nil {
+		log.F(log.M{"user_id": sess.userID, "model": sess.tool.model, "prompt": prompt, "elapse": time.Since(startTime).Seconds()}).Errorf("generate image failed: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ToolResult{Content: toolResultJSON(map[string]any{"error": "image generation timed out"})}
+		}
+
+		if errors.Is(err, ErrImagePromptUnsafe) {
+			return ToolResult{Content: toolResultJSON(map[string]any{"error": "the image description was rejected by content moderation, tell the user that this image cannot be generated"})}
+		}
+
+		return ToolResult{Content: toolResultJSON(map[string]any{"error": "image generation failed"})}
+	}
+
+	sess.remaining--
+	if usage, ok := ctx.Value(imageToolUsageKey{}).(*ImageToolUsage); ok {
+		usage.add(sess.tool.model)
+	}
+
+	return ToolResult{
+		Content: toolResultJSON(map[string]any{"url": url, "prompt": prompt}),
+		Parts:   []*MultipartContent{{Type: "image_url", ImageURL: &ImageURL{URL: url, Caption: misc.SubString(prompt, 100)}}},
+	}
+}
+
+// toolResultJSON 将工具调用的结果编码为 JSON
+func toolResultJSON(data any) string {
+	ret, _ := json.Marshal(data)
+	return string(ret)
+}
+
+// OpenAIImageGenerator 使用 OpenAI 的图片生成接口（与 /v1/images/generations 相同）生成图片，上传到对象存储
+type OpenAIImageGenerator struct {
+	client openai2.Client
+	up     *uploader.Uploader
+	// detector 生成图片之前对图片描述进行内容安全检测，为 nil 时不检测
+	detector PromptDetector
+	// model 图片生成模型，格式与创作岛相同，如 dall-e-3、dall-e-3:hd
+	model string
+}
+
+func NewOpenAIImageGenerator(client openai2.Client, up *uploader.Uploader, detector PromptDetector, model string) *OpenAIImageGenerator {
+	return &OpenAIImageGenerator{client: client, up: up, detector: detector, model: model}
+}
+
+func (g *OpenAIImageGenerator) GenerateImage(ctx context.Context, userID int64, prompt string) (string, error) {
+	if g.detector != nil {
+		if checkRes := g.detector.PromptDetect(prompt); checkRes != nil && checkRes.IsReallyUnSafe() {
+			log.F(log.M{"user_id": userID, "details": checkRes.ReasonDetail(), "content": prompt}).Warningf("用户 %d 违规，违规内容：%s", userID, checkRes.Reason)
+			return "", ErrImagePromptUnsafe
+		}
+	}
+
+	model, quality, _ := strings.Cut(g.model, ":")
+	resp, err := g.client.CreateImage(ctx, openai.ImageRequest{
+		Prompt:         prompt,
+		Model:          model,
+		N:              1,
+		Quality:        quality,
+		Size:           openai.CreateImageSize1024x1024,
+		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
+	})
+	if err != nil {
+		return "", fmt.Errorf("create image failed: %w", err)
+	}
+
+	if len(resp.Data) == 0 {
+		return "", errors.New("no image generated")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	if err != nil {
+		return "", fmt.Errorf("decode image failed: %w", err)
+	}
+
+	return g.up.UploadStream(ctx, int(userID), uploader.DefaultUploadExpireAfterDays, data, "png")
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/aliyun"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// imageToolTestClient 第一次请求时调用 generate_image，之后返回文本，记录收到的请求
+type imageToolTestClient struct {
+	ChatTestClient
+	requests []Request
+}
+
+func (c *imageToolTestClient) response(req Request) Response {
+	if len(c.requests) == 1 {
+		return Response{
+			Text:         "好的，",
+			FinishReason: FinishReasonToolCalls,
+			ToolCalls:    []ToolCall{{Type: "function", Function: ToolCallFunction{Name: ImageToolName, Arguments: `{"prompt": "a cat"}`}}},
+		}
+	}
+
+	return Response{Text: "画好了", FinishReason: FinishReasonStop}
+}
+
+func (c *imageToolTestClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.requests = append(c.requests, req)
+	res := c.response(req)
+	return &res, nil
+}
+
+func (c *imageToolTestClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	c.requests = append(c.requests, req)
+
+	res := c.response(req)
+	ch := make(chan Response, 2)
+	ch <- Response{Text: res.Text}
+	ch <- Response{FinishReason: res.FinishReason, ToolCalls: res.ToolCalls}
+	close(ch)
+
+	return ch, nil
+}
+
+type imageToolTestGenerator struct {
+	delay   time.Duration
+	prompts []string
+}
+
+func (g *imageToolTestGenerator) GenerateImage(ctx context.Context, userID int64, prompt string) (string, error) {
+	g.prompts = append(g.prompts, prompt)
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(g.delay):
+		return "https://example.com/ai-server/1/cat.png", nil
+	}
+}
+
+func TestImageTool_Chat(t *testing.T) {
+	client := &imageToolTestClient{}
+	c := NewServerToolChat(client, NewImageTool(&imageToolTestGenerator{}, "dall-e-3", time.Second))
+
+	ctx, usage := WithImageToolUsage(context.TODO())
+	res, err := c.Chat(ctx, Request{UserID: 1, Messages: Messages{{Role: RoleUser, Content: "画一只猫"}}, ImageTool: &ImageToolOptions{Remaining: 1}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "画好了", res.Text)
+	assert.Equal(t, 1, len(res.Parts))
+	assert.Equal(t, "https://example.com/ai-server/1/cat.png", res.Parts[0].ImageURL.URL)
+	assert.Equal(t, 0, len(res.ToolCalls))
+
+	// 工具调用及其结果追加到第二次请求中
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, ImageToolName, client.requests[0].Tools[0].Function.Name)
+	messages := client.requests[1].Messages
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, 1, len(messages[1].ToolCalls))
+	assert.Equal(t, messages[1].ToolCalls[0].ID, messages[2].ToolCallID)
+	assert.True(t, strings.Contains(messages[2].Content, "https://example.com/ai-server/1/cat.png"))
+
+	assert.Equal(t, "dall-e-3", usage.Model())
+	assert.Equal(t, 1, usage.Images())
+
+	// 没有设置 ImageTool 的请求不提供该工具
+	client = &imageToolTestClient{}
+	c = NewServerToolChat(client, NewImageTool(&imageToolTestGenerator{}, "dall-e-3", time.Second))
+	_, err = c.Chat(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "画一只猫"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(client.requests[0].Tools))
+}
+
+func TestImageTool_Limit(t *testing.T) {
+	client := &imageToolTestClient{}
+	generator := &imageToolTestGenerator{}
+	c := NewServerToolChat(client, NewImageTool(generator, "dall-e-3", time.Second))
+
+	// 额度已经用完时不生成图片，工具调用的结果告知模型达到上限
+	ctx, usage := WithImageToolUsage(context.TODO())
+	res, err := c.Chat(ctx, Request{Messages: Messages{{Role: RoleUser, Content: "画一只猫"}}, ImageTool: &ImageToolOptions{Remaining: 0}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(res.Parts))
+	assert.Equal(t, 0, len(generator.prompts))
+	assert.Equal(t, 0, usage.Images())
+	assert.True(t, strings.Contains(client.requests[1].Messages[2].Content, "limit"))
+}
+
+func TestImageTool_ChatStream(t *testing.T) {
+	client := &imageToolTestClient{}
+	c := NewServerToolChat(client, NewImageTool(&imageToolTestGenerator{delay: 50 * time.Millisecond}, "dall-e-3", time.Second))
+	c.keepAlive = 10 * time.Millisecond
+
+	ctx, usage := WithImageToolUsage(context.TODO())
+	stream, err := c.ChatStream(ctx, Request{Messages: Messages{{Role: RoleUser, Content: "画一只猫"}}, ImageTool: &ImageToolOptions{Remaining: 1}})
+	assert.NoError(t, err)
+
+	var text string
+	var parts []*MultipartContent
+	var interim, finishes int
+	for res := range stream {
+		assert.Equal(t, 0, len(res.ToolCalls))
+		text += res.Text
+		parts = append(parts, res.Parts...)
+		if res.Interim {
+			interim++
+		}
+		if res.FinishReason != "" {
+			finishes++
+			assert.Equal(t, FinishReasonStop, res.FinishReason)
+		}
+	}
+
+	// 只返回最后一轮的结束原因，生成图片期间发送中间状态的响应
+	assert.Equal(t, "好的，画好了", text)
+	assert.Equal(t, 1, finishes)
+	assert.True(t, interim > 0)
+	assert.Equal(t, 1, len(parts))
+	assert.Equal(t, 1, usage.Images())
+}
+
+func TestImageTool_Timeout(t *testing.T) {
+	client := &imageToolTestClient{}
+	c := NewServerToolChat(client, NewImageTool(&imageToolTestGenerator{delay: time.Second}, "dall-e-3", 20*time.Millisecond))
+
+	// 生成图片超时时不中断对话，模型根据工具调用的结果回复
+	ctx, usage := WithImageToolUsage(context.TODO())
+	stream, err := c.ChatStream(ctx, Request{Messages: Messages{{Role: RoleUser, Content: "画一只猫"}}, ImageTool: &ImageToolOptions{Remaining: 1}})
+	assert.NoError(t, err)
+
+	var text string
+	for res := range stream {
+		text += res.Text
+		assert.Equal(t, 0, len(res.Parts))
+	}
+
+	assert.Equal(t, "好的，画好了", text)
+	assert.Equal(t, 0, usage.Images())
+	assert.True(t, strings.Contains(client.requests[1].Messages[2].Content, "timed out"))
+}
+
+func TestCountGeneratedImages(t *testing.T) {
+	image := &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/1.png"}}
+	messages := Messages{
+		{Role: RoleUser, MultipartContents: []*MultipartContent{image}},
+		{Role: RoleAssistant, Content: "画好了", MultipartContents: []*MultipartContent{image, image}},
+	}
+
+	assert.Equal(t, 2, CountGeneratedImages(messages))
+}
+
+func TestCountStoredImages(t *testing.T) {
+	parts := []string{
+		`[{"type": "image_url", "image_url": {"url": "https://example.com/1.png"}, "caption": "a cat"}]`,
+		`[{"type": "image_url", "image_url": {"url": "https://example.com/2.png"}}, {"type": "image_url", "image_url": {"url": "https://example.com/3.png"}}]`,
+		`invalid`,
+	}
+
+	assert.Equal(t, 3, CountStoredImages(parts))
+	assert.Equal(t, 0, CountStoredImages(nil))
+}
+
+// imageToolTestDetector 包含 cat 的图片描述没有通过内容安全检测
+type imageToolTestDetector struct{}
+
+func (imageToolTestDetector) PromptDetect(prompt string) *aliyun.CheckResult {
+	if strings.Contains(prompt, "cat") {
+		return &aliyun.CheckResult{Safe: false, Reason: aliyun.Reason{RiskWords: "cat"}}
+	}
+
+	return &aliyun.CheckResult{Safe: true}
+}
+
+func TestOpenAIImageGenerator_PromptDetect(t *testing.T) {
+	// 没有通过内容安全检测时不请求图片生成接口
+	generator := NewOpenAIImageGenerator(&fakeOpenAIClient{}, nil, imageToolTestDetector{}, "dall-e-3")
+	_, err := generator.GenerateImage(context.TODO(), 1, "a cat")
+	assert.True(t, errors.Is(err, ErrImagePromptUnsafe))
+
+	// 工具调用结果告知模型图片无法生成，不计入生成的图片数量
+	client := &imageToolTestClient{}
+	c := NewServerToolChat(client, NewImageTool(generator, "dall-e-3", time.Second))
+	ctx, usage := WithImageToolUsage(context.TODO())
+	res, err := c.Chat(ctx, Request{UserID: 1, Messages: Messages{{Role: RoleUser, Content: "画一只猫"}}, ImageTool: &ImageToolOptions{Remaining: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(res.Parts))
+	assert.True(t, strings.Contains(client.requests[1].Messages[2].Content, "content moderation"))
+	assert.Equal(t, 0, usage.Images())
+}
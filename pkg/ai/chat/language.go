@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mylxsw/asteria/log"
+)
+
+// languageCheckMinTokens 检测输出语言之前需要累积的 Token 数量（近似值，参考 splitPacingTokens）
+const languageCheckMinTokens = 20
+
+// 检测输出语言时使用的书写系统，拉丁字母书写的语言（英文、法文等）之间无法区分
+const (
+	scriptHan      = "han"
+	scriptKana     = "kana"
+	scriptHangul   = "hangul"
+	scriptCyrillic = "cyrillic"
+	scriptLatin    = "latin"
+)
+
+// languageScripts 语言代码（BCP 47 的主标签）对应的书写系统，不在列表中的语言不做检测
+var languageScripts = map[string]string{
+	"zh": scriptHan,
+	"ja": scriptKana,
+	"ko": scriptHangul,
+	"ru": scriptCyrillic,
+	"uk": scriptCyrillic,
+	"en": scriptLatin,
+	"fr": scriptLatin,
+	"de": scriptLatin,
+	"es": scriptLatin,
+	"it": scriptLatin,
+	"pt": scriptLatin,
+}
+
+// replyLanguageScript 回复语言（如 zh-CN、en_US）对应的书写系统，无法识别时返回空字符串
+func replyLanguageScript(lang string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(lang)), "-")
+	primary, _, _ = strings.Cut(primary, "_")
+
+	return languageScripts[primary]
+}
+
+// detectScript 检测文本主要使用的书写系统，无法判断时（如只有数字、符号）返回空字符串
+//
+// 每个汉字、假名、谚文作为一个单位，拉丁字母和西里尔字母按照单词计算，避免字母文字的权重过高。
+// 出现假名时，汉字也计入日文
+func detectScript(text string) string {
+	counts := make(map[string]int)
+
+	var word string
+	for _, r := range text {
+		var script string
+		switch {
+		case unicode.Is(unicode.Han, r):
+			script = scriptHan
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			script = scriptKana
+		case unicode.Is(unicode.Hangul, r):
+			script = scriptHangul
+		case unicode.Is(unicode.Cyrillic, r):
+			script = scriptCyrillic
+		case unicode.Is(unicode.Latin, r):
+			script = scriptLatin
+		}
+
+		if script == scriptLatin || script == scriptCyrillic {
+			if word != script {
+				counts[script]++
+			}
+			word = script
+			continue
+		}
+
+		word = ""
+		if script != "" {
+			counts[script]++
+		}
+	}
+
+	if counts[scriptKana] > 0 {
+		counts[scriptKana] += counts[scriptHan]
+		delete(counts, scriptHan)
+	}
+
+	var ret string
+	var total, best int
+	for script, count := range counts {
+		total += count
+		if count > best || (count == best && script < ret) {
+			ret, best = script, count
+		}
+	}
+
+	// 没有占多数的书写系统时（如中英文混合），认为无法判断
+	if best*2 <= total {
+		return ""
+	}
+
+	return ret
+}
+
+// replyLanguageRetryPrompt 输出语言不符合要求，重试时使用的系统提示语
+func replyLanguageRetryPrompt(lang string) string {
+	return fmt.Sprintf("You MUST reply in %s only. Your previous reply used another language, which is not acceptable. Do not switch languages, even for explanations.", lang)
+}
+
+// withSystemInstruction 将提示语追加到第一条系统消息之后，没有系统消息时添加到所有消息之前，不修改原始请求
+func withSystemInstruction(req Request, instruction string) Request {
+	messages := make(Messages, 0, len(req.Messages)+1)
+	if len(req.Messages) > 0 && req.Messages[0].Role == RoleSystem {
+		system := req.Messages[0]
+		system.Content = strings.TrimSpace(system.Content + "\n\n" + instruction)
+		messages = append(messages, system)
+		messages = append(messages, req.Messages[1:]...)
+	} else {
+		messages = append(messages, Message{Role: RoleSystem, Content: instruction})
+		messages = append(messages, req.Messages...)
+	}
+
+	req.Messages = messages
+	return req
+}
+
+// replyLanguageChat 检查流式输出的语言是否与回复语言（Request.ReplyLanguage）一致，请求中指定 EnforceReplyLanguage 时启用
+//
+// 输出内容累积到 minTokens 之后检测语言，检测完成之前的内容会被缓存，不会返回给客户端。语言不一致时使用更严格的系统提示语重试一次，
+// 重试成功后取消原来的上游请求，重试的输出不再检测。输出内容不足 minTokens 就结束（或出错）时，直接返回已缓存的内容
+type replyLanguageChat struct {
+	imp       Chat
+	language  string
+	script    string
+	minTokens int
+}
+
+func (c *replyLanguageChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	return c.imp.Chat(ctx, req)
+}
+
+func (c *replyLanguageChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	stream, err := c.imp.ChatStream(upstreamCtx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		defer cancel()
+
+		var pending []Response
+		var text strings.Builder
+		tokens := 0
+		for data := range stream {
+			pending = append(pending, data)
+			if !data.Interim {
+				text.WriteString(data.Text)
+				tokens += len(splitPacingTokens(data.Text))
+			}
+
+			if data.FinishReason != "" || data.ErrorCode != "" {
+				break
+			}
+
+			if tokens < c.minTokens {
+				continue
+			}
+
+			detected := detectScript(text.String())
+			if detected == "" || detected == c.script {
+				break
+			}
+
+			// 语言不一致，使用更严格的提示语重试，重试成功后取消当前请求，丢弃已缓存的内容
+			log.F(log.M{"model": req.Model, "language": c.language, "detected": detected}).Warningf("输出语言与要求的回复语言不一致，使用更严格的提示语重试")
+
+			retry, err := c.imp.ChatStream(ctx, withSystemInstruction(req, replyLanguageRetryPrompt(c.language)))
+			if err != nil {
+				log.F(log.M{"model": req.Model, "language": c.language}).Errorf("retry chat stream failed: %v", err)
+				// 重试失败时，继续返回原始的输出内容
+				break
+			}
+
+			cancel()
+			defer drainStream(retry)
+
+			for data := range retry {
+				if !send(data) {
+					return
+				}
+			}
+
+			return
+		}
+
+		for _, data := range pending {
+			if !send(data) {
+				return
+			}
+		}
+
+		for data := range stream {
+			if !send(data) {
+				return
+			}
+		}
+	}), nil
+}
+
+func (c *replyLanguageChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 按照提示语语言选择服务提供商的结果
+const (
+	// languageTierMatched 使用了为提示语语言标记的服务提供商
+	languageTierMatched = "matched"
+	// languageTierFallback 没有为提示语语言标记的健康的服务提供商，按照原有的规则选择
+	languageTierFallback = "fallback"
+	// languageTierUnknown 无法判断提示语的语言，按照原有的规则选择
+	languageTierUnknown = "unknown"
+)
+
+// newLanguageRouteCounter 创建按照提示语语言选择服务提供商的次数统计，并注册到 registerer
+func newLanguageRouteCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	return registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_language_route_count",
+		Help:      "provider selections of language routed models by detected prompt script and chosen tier",
+	}, []string{"model", "script", "tier"}))
+}
+
+// promptScript 检测最后一条用户消息主要使用的书写系统（参考 detectScript），无法判断时返回空字符串
+func promptScript(messages Messages) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			return detectScript(messages[i].Text())
+		}
+	}
+
+	return ""
+}
+
+// providerSupportsScript 服务提供商是否为使用该书写系统的语言标记
+func providerSupportsScript(pro repo.ModelProvider, script string) bool {
+	for _, lang := range pro.Languages {
+		if replyLanguageScript(lang) == script {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routeByLanguage 为请求选择服务提供商，模型开启了按语言路由时（ModelMeta.LanguageRouting），
+// 优先从为提示语语言标记的健康的服务提供商中选择，没有时按照原有的规则选择。
+// 请求指定了需要避开的渠道时，只在最终的候选范围内避开（参考 avoidChannels），不会因此放弃支持图片或者匹配语言的服务提供商
+func (d *Dispatcher) routeByLanguage(ctx context.Context, mod repo.Model, req Request) repo.ModelProvider {
+	if !mod.Meta.LanguageRouting {
+		return d.router.SelectProvider(ctx, d.avoidChannels(mod, req))
+	}
+
+	script := promptScript(req.Messages)
+	tier := languageTierUnknown
+	if script != "" {
+		tier = languageTierFallback
+
+		matched := array.Filter(mod.Providers, func(item repo.ModelProvider, _ int) bool {
+			return providerSupportsScript(item, script) && (d.health == nil || d.health.Healthy(item))
+		})
+		if len(matched) > 0 {
+			mod.Providers = matched
+			tier = languageTierMatched
+		}
+	}
+
+	pro := d.router.SelectProvider(ctx, d.avoidChannels(mod, req))
+
+	if d.languageRoutes != nil {
+		d.languageRoutes.WithLabelValues(mod.ModelId, script, tier).Inc()
+	}
+
+	log.F(log.M{
+		"model":   mod.ModelId,
+		"script":  script,
+		"tier":    tier,
+		"channel": providerKey(pro),
+	}).Debugf("select provider by prompt language")
+
+	return pro
+}
@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPromptScript(t *testing.T) {
+	// 只检测最后一条用户消息
+	assert.Equal(t, scriptHan, promptScript(Messages{
+		{Role: RoleUser, Content: "hello world"},
+		{Role: RoleAssistant, Content: "hi"},
+		{Role: RoleUser, Content: "请用中文解释一下 TCP 的三次握手"},
+	}))
+	assert.Equal(t, scriptLatin, promptScript(Messages{{Role: RoleUser, Content: "Explain the TCP handshake"}}))
+	assert.Equal(t, "", promptScript(Messages{{Role: RoleUser, Content: "1 + 1 = ?"}}))
+	assert.Equal(t, "", promptScript(Messages{{Role: RoleAssistant, Content: "你好"}}))
+}
+
+func TestDispatcher_LanguageRouting(t *testing.T) {
+	providers := []repo.ModelProvider{
+		{ID: 1},
+		{ID: 2, Languages: []string{"zh-CN"}},
+		{ID: 3, Languages: []string{"en", "fr"}},
+	}
+	router := fakeModelRouter{
+		"auto": {Models: model.Models{ModelId: "auto"}, Providers: providers, Meta: repo.ModelMeta{LanguageRouting: true}},
+		"gpt":  {Models: model.Models{ModelId: "gpt"}, Providers: providers},
+	}
+
+	tracker := NewHealthTracker(1, time.Minute)
+	factory := &fakeClientFactory{client: &streamChatClient{}, typ: service.ProviderOpenAI}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.health = tracker
+	d.languageRoutes = newLanguageRouteCounter(prometheus.NewRegistry())
+
+	chat := func(modelID, content string) int64 {
+		factory.providers = nil
+		_, err := d.Chat(context.TODO(), Request{Model: modelID, Messages: Messages{{Role: RoleUser, Content: content}}})
+		assert.NoError(t, err)
+
+		return factory.providers[0].ID
+	}
+
+	assert.Equal(t, int64(2), chat("auto", "你好，请介绍一下你自己"))
+	assert.Equal(t, int64(3), chat("auto", "Hello, please introduce yourself"))
+	// 无法判断语言，或者模型没有开启按语言路由时，按照原有的规则选择
+	assert.Equal(t, int64(1), chat("auto", "1 + 1 = ?"))
+	assert.Equal(t, int64(1), chat("gpt", "你好，请介绍一下你自己"))
+
+	// 为该语言标记的服务提供商都不健康时，按照原有的规则选择
+	tracker.Report(providers[1], errors.New("upstream unavailable"))
+	assert.Equal(t, int64(1), chat("auto", "你好，请介绍一下你自己"))
+
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.languageRoutes.WithLabelValues("auto", scriptHan, languageTierMatched)))
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.languageRoutes.WithLabelValues("auto", scriptLatin, languageTierMatched)))
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.languageRoutes.WithLabelValues("auto", "", languageTierUnknown)))
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.languageRoutes.WithLabelValues("auto", scriptHan, languageTierFallback)))
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// scriptedStreamClient 每次请求按照顺序使用一组预设的内容返回流式响应
+type scriptedStreamClient struct {
+	ChatTestClient
+	scripts  [][]Response
+	requests []Request
+}
+
+func (c *scriptedStreamClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	chunks := c.scripts[len(c.requests)]
+	c.requests = append(c.requests, req)
+
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+		for _, chunk := range chunks {
+			select {
+			case <-ctx.Done():
+				return
+			case res <- chunk:
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+func TestDetectScript(t *testing.T) {
+	assert.Equal(t, scriptHan, detectScript("你好，我是一个人工智能助手"))
+	assert.Equal(t, scriptLatin, detectScript("Hello, I am an AI assistant."))
+	assert.Equal(t, scriptKana, detectScript("こんにちは、私はAIアシスタントです"))
+	assert.Equal(t, scriptHangul, detectScript("안녕하세요"))
+	assert.Equal(t, scriptCyrillic, detectScript("Привет, как дела?"))
+	// 中文中夹杂少量英文单词
+	assert.Equal(t, scriptHan, detectScript("可以使用 Go 语言的 context 包取消请求"))
+	assert.Equal(t, "", detectScript("123 + 456 = 579"))
+
+	assert.Equal(t, scriptHan, replyLanguageScript("zh-CN"))
+	assert.Equal(t, scriptLatin, replyLanguageScript("en_US"))
+	assert.Equal(t, "", replyLanguageScript("Chinese"))
+}
+
+func TestDispatcher_EnforceReplyLanguage(t *testing.T) {
+	english := []Response{{Text: strings.Repeat("This answer is written in English. ", 5)}, {Text: "Done."}, {FinishReason: "stop"}}
+	chinese := []Response{{Text: "这是中文的回答。"}, {FinishReason: "stop"}}
+
+	client := &scriptedStreamClient{scripts: [][]Response{english, chinese}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	stream, err := d.ChatStream(context.TODO(), Request{
+		Model:                "gpt-4",
+		Messages:             Messages{{Role: RoleUser, Content: "hello"}},
+		ReplyLanguage:        "zh-CN",
+		EnforceReplyLanguage: true,
+	})
+	assert.NoError(t, err)
+
+	// 第一次输出的语言不一致，只返回重试的输出
+	responses := assertFinishReasonConformance(t, stream)
+	assert.Equal(t, "这是中文的回答。", responses[0].Text)
+	assert.Equal(t, FinishReasonStop, responses[len(responses)-1].FinishReason)
+
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, RoleSystem, client.requests[1].Messages[0].Role)
+	assert.True(t, strings.HasPrefix(client.requests[1].Messages[0].Content, replyLanguagePrompt("zh-CN")))
+	assert.True(t, strings.HasSuffix(client.requests[1].Messages[0].Content, replyLanguageRetryPrompt("zh-CN")))
+
+	// 重试仍然不一致时，不再重试
+	client = &scriptedStreamClient{scripts: [][]Response{english, english}}
+	factory.client = client
+
+	stream, err = d.ChatStream(context.TODO(), Request{
+		Model:                "gpt-4",
+		Messages:             Messages{{Role: RoleUser, Content: "hello"}},
+		ReplyLanguage:        "zh-CN",
+		EnforceReplyLanguage: true,
+	})
+	assert.NoError(t, err)
+
+	responses = assertFinishReasonConformance(t, stream)
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, english[0].Text, responses[0].Text)
+
+	// 未启用时不检查
+	client = &scriptedStreamClient{scripts: [][]Response{english}}
+	factory.client = client
+
+	stream, err = d.ChatStream(context.TODO(), Request{
+		Model:         "gpt-4",
+		Messages:      Messages{{Role: RoleUser, Content: "hello"}},
+		ReplyLanguage: "zh-CN",
+	})
+	assert.NoError(t, err)
+
+	responses = assertFinishReasonConformance(t, stream)
+	assert.Equal(t, 1, len(client.requests))
+	assert.Equal(t, 3, len(responses))
+}
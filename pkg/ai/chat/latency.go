@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyMaxSamples 每个渠道最多保留的耗时记录数量，超过时丢弃最早的记录，避免请求量大的渠道占用过多内存
+const latencyMaxSamples = 1024
+
+// LatencyStats 渠道最近一段时间内请求耗时的统计
+type LatencyStats struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	// Count 参与统计的请求数量
+	Count int `json:"count"`
+}
+
+// ChannelLatencyReporter 查询渠道最近一段时间内的请求耗时，用于管理后台查看渠道的响应速度
+type ChannelLatencyReporter interface {
+	LatencyStats(channelID int64) (p50, p90, p99 time.Duration, count int)
+}
+
+// LatencyRecorder 在当前实例的内存中记录每个渠道最近 window 时间内成功请求的耗时，可以并发使用
+//
+// 每个渠道最多保留 maxSamples 条记录，超过时丢弃最早的记录
+type LatencyRecorder struct {
+	window     time.Duration
+	maxSamples int
+	now        func() time.Time
+
+	lock    sync.Mutex
+	samples map[int64][]latencySample
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+func NewLatencyRecorder(window time.Duration, maxSamples int) *LatencyRecorder {
+	return &LatencyRecorder{
+		window:     window,
+		maxSamples: maxSamples,
+		now:        time.Now,
+		samples:    make(map[int64][]latencySample),
+	}
+}
+
+// Record 记录一次请求的耗时
+func (r *LatencyRecorder) Record(channelID int64, latency time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	samples := append(r.expire(r.samples[channelID], now), latencySample{at: now, latency: latency})
+	if len(samples) > r.maxSamples {
+		samples = samples[len(samples)-r.maxSamples:]
+	}
+
+	r.samples[channelID] = samples
+}
+
+// Stats 渠道最近 window 时间内请求耗时的统计，没有记录时返回零值
+func (r *LatencyRecorder) Stats(channelID int64) LatencyStats {
+	r.lock.Lock()
+	samples := r.expire(r.samples[channelID], r.now())
+	if len(samples) == 0 {
+		delete(r.samples, channelID)
+	} else {
+		r.samples[channelID] = samples
+	}
+
+	latencies := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		latencies[i] = s.latency
+	}
+	r.lock.Unlock()
+
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return LatencyStats{
+		P50:   percentile(latencies, 0.5),
+		P90:   percentile(latencies, 0.9),
+		P99:   percentile(latencies, 0.99),
+		Count: len(latencies),
+	}
+}
+
+// expire 去掉超出统计时间范围的记录，记录按照时间顺序保存
+func (r *LatencyRecorder) expire(samples []latencySample, now time.Time) []latencySample {
+	since := now.Add(-r.window)
+	idx := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(since) })
+	return samples[idx:]
+}
+
+// percentile 计算已排序的耗时中的百分位数（最近秩方法）
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// LatencyStats 渠道最近一段时间内（chat-latency-window）成功请求的耗时的百分位数，未启用或者没有记录时返回零值。
+// 流式输出的耗时为收到第一个响应的耗时
+func (d *Dispatcher) LatencyStats(channelID int64) (p50, p90, p99 time.Duration, count int) {
+	if d.latency == nil {
+		return 0, 0, 0, 0
+	}
+
+	stats := d.latency.Stats(channelID)
+	return stats.P50, stats.P90, stats.P99, stats.Count
+}
+
+// latencyRecordingChat 记录渠道每一次成功请求的耗时，需要放在 retryChat 之内，才能记录每一次重试
+type latencyRecordingChat struct {
+	imp       Chat
+	recorder  *LatencyRecorder
+	channelID int64
+}
+
+func (c *latencyRecordingChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	start := time.Now()
+	res, err := c.imp.Chat(ctx, req)
+	if err == nil && res.ErrorCode == "" {
+		c.recorder.Record(c.channelID, time.Since(start))
+	}
+
+	return res, err
+}
+
+func (c *latencyRecordingChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	start := time.Now()
+	stream, err := c.imp.ChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		first := true
+		for data := range stream {
+			if first && !data.Interim {
+				first = false
+				if data.ErrorCode == "" {
+					c.recorder.Record(c.channelID, time.Since(start))
+				}
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	}), nil
+}
+
+func (c *latencyRecordingChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestLatencyRecorder_Stats(t *testing.T) {
+	recorder := NewLatencyRecorder(time.Minute, latencyMaxSamples)
+
+	// 乱序写入 1-100 毫秒
+	for i := 100; i > 0; i-- {
+		recorder.Record(1, time.Duration(i)*time.Millisecond)
+	}
+	recorder.Record(2, 3*time.Second)
+
+	assert.Equal(t, LatencyStats{P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, Count: 100}, recorder.Stats(1))
+	assert.Equal(t, LatencyStats{P50: 3 * time.Second, P90: 3 * time.Second, P99: 3 * time.Second, Count: 1}, recorder.Stats(2))
+	assert.Equal(t, LatencyStats{}, recorder.Stats(3))
+}
+
+func TestLatencyRecorder_Window(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	recorder := NewLatencyRecorder(time.Minute, 5)
+	recorder.now = func() time.Time { return now }
+
+	recorder.Record(1, 10*time.Second)
+	now = now.Add(30 * time.Second)
+	recorder.Record(1, 100*time.Millisecond)
+	recorder.Record(1, 200*time.Millisecond)
+	assert.Equal(t, 3, recorder.Stats(1).Count)
+
+	// 超出时间范围的记录不参与统计
+	now = now.Add(40 * time.Second)
+	assert.Equal(t, LatencyStats{P50: 100 * time.Millisecond, P90: 200 * time.Millisecond, P99: 200 * time.Millisecond, Count: 2}, recorder.Stats(1))
+
+	// 超过最大记录数时丢弃最早的记录
+	for i := 1; i <= 10; i++ {
+		recorder.Record(1, time.Duration(i)*time.Second)
+	}
+	assert.Equal(t, LatencyStats{P50: 8 * time.Second, P90: 10 * time.Second, P99: 10 * time.Second, Count: 5}, recorder.Stats(1))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, LatencyStats{}, recorder.Stats(1))
+	assert.Equal(t, 0, len(recorder.samples))
+}
+
+func TestLatencyRecorder_Concurrent(t *testing.T) {
+	recorder := NewLatencyRecorder(time.Minute, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				recorder.Record(int64(i%2), time.Duration(j)*time.Millisecond)
+				recorder.Stats(int64(i % 2))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, recorder.Stats(0).Count)
+	assert.Equal(t, 100, recorder.Stats(1).Count)
+}
+
+func TestDispatcher_LatencyStats(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "你好"}, {FinishReason: FinishReasonStop}}}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+
+	// 未启用时不记录
+	_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "你好"}}})
+	assert.NoError(t, err)
+	_, _, _, count := d.LatencyStats(1)
+	assert.Equal(t, 0, count)
+
+	d.latency = NewLatencyRecorder(time.Minute, latencyMaxSamples)
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "你好"}}})
+	assert.NoError(t, err)
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "你好"}}})
+	assert.NoError(t, err)
+	collectStream(stream)
+
+	_, _, _, count = d.LatencyStats(1)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,416 @@
+package chat
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// displayMathMaxBytes 独立公式（从开始分隔符之后算起）的最大字节数，超过时仍未找到结束分隔符则不作为公式处理，
+// 避免流式输出时无限等待
+const displayMathMaxBytes = 8192
+
+var (
+	// openingFence 代码块的开始围栏，使用反引号时，围栏之后的信息字符串中不能包含反引号
+	openingFence = regexp.MustCompile("^\\s*(`{3,}[^`]*|~{3,}.*)$")
+	// closingFence 代码块的结束围栏，之后只能是空白字符
+	closingFence = regexp.MustCompile("^\\s*(`{3,}|~{3,})\\s*$")
+)
+
+// spanKind 受保护内容的类型
+type spanKind int
+
+const (
+	// spanLiteral 需要原样保留的文本（转义字符、没有配对的反引号或者公式分隔符），转换器不能重新解释其中的字符
+	spanLiteral spanKind = iota
+	// spanCode 行内代码
+	spanCode
+	// spanInlineMath 行内公式（$...$ 或者 \(...\)）
+	spanInlineMath
+	// spanDisplayMath 独立公式（$$...$$ 或者 \[...\]），可以跨行
+	spanDisplayMath
+)
+
+// textSpan 受保护的内容，open 和 close 为分隔符
+type textSpan struct {
+	kind  spanKind
+	open  string
+	body  string
+	close string
+}
+
+func (s textSpan) String() string {
+	return s.open + s.body + s.close
+}
+
+// markdownTransform 输出内容的转换器，代码块和 textSpan 由 markdownTransformer 识别，转换器只处理其它的文本
+type markdownTransform interface {
+	// fence 处理代码块的围栏行，keep 为 false 时去掉这一行（包括换行符），代码块中的内容总是保持不变
+	fence(line string) (out string, keep bool)
+	// span 输出受保护的内容（可以转换分隔符，不能修改内容）
+	span(s textSpan) string
+}
+
+// inlineTransform 逐段处理文本的转换器，流式输出时不需要等待整行结束
+type inlineTransform interface {
+	markdownTransform
+	// text 处理当前行中剩余的文本（不包含换行符，从受保护的内容之外开始），lineStart 表示 text 是否从行首开始，
+	// complete 为 false 时表示这一行尚未结束。返回处理后的内容和处理的字节数（至少为 1），需要等待后续内容时 hold 为 true
+	text(text string, lineStart, complete bool) (out string, n int, hold bool)
+}
+
+// lineTransform 按整行处理文本的转换器，行中的公式替换为占位符之后再处理，处理完成之后还原，避免公式被修改
+type lineTransform interface {
+	markdownTransform
+	line(masked string) string
+}
+
+// markdownTransformer 将转换器应用到 Markdown 文本上，所有的流式输出后处理都需要使用它，
+// 以保证代码块和公式不会因为被拆分到多个分片中而被错误地转换：
+//
+//   - 跟踪跨行、跨分片的代码块（支持嵌套：结束围栏需要与开始围栏使用相同的字符，并且长度不小于开始围栏），代码块中的内容保持不变
+//   - 识别行内代码、行内公式和独立公式，这些内容只能通过 span 输出
+//   - 无法确定的内容（可能是代码块围栏的行首、被拆开的分隔符、尚未结束的行内代码和公式）等待后续内容，
+//     独立公式在结束之后才输出，到结束时仍未闭合的独立公式不是公式，开始分隔符按照普通文本处理
+//
+// 同一段文本无论如何拆分为分片，处理的结果都与一次处理完整的文本相同
+type markdownTransformer struct {
+	t markdownTransform
+	// fence 未结束的代码块的围栏（如 ``` 或者 ~~~~），不在代码块中时为空
+	fence string
+	// midLine 当前行是否已经处理了一部分（流式输出时，不完整的行可以先输出一部分）
+	midLine bool
+}
+
+// transformMarkdown 使用转换器处理完整的文本
+func transformMarkdown(text string, t markdownTransform) string {
+	out, _ := (&markdownTransformer{t: t}).process(text, true)
+	return out
+}
+
+// process 处理缓存中的文本，返回处理后的内容和需要等待后续内容的部分（需要与后续内容拼接之后再次处理），
+// final 为 true 时表示没有后续内容，处理所有的文本
+func (m *markdownTransformer) process(buf string, final bool) (string, string) {
+	var out strings.Builder
+	for pos := 0; pos < len(buf); {
+		lineEnd := lineEndOf(buf, pos)
+		complete := lineEnd < len(buf) || final
+		line := buf[pos:lineEnd]
+
+		keep, handled := true, false
+		if !m.midLine {
+			// 行首可能是代码块的围栏，需要等待整行结束
+			if trimmed := strings.TrimLeft(line, " \t"); !complete && (trimmed == "" || trimmed[0] == '`' || trimmed[0] == '~') {
+				return out.String(), buf[pos:]
+			}
+
+			if m.fence == "" && openingFence.MatchString(line) {
+				m.fence = strings.TrimSpace(line)
+				m.fence = m.fence[:len(m.fence)-len(strings.TrimLeft(m.fence, m.fence[:1]))]
+				handled = true
+			} else if m.fence != "" && isClosingFence(line, m.fence) {
+				m.fence = ""
+				handled = true
+			}
+
+			if handled {
+				var text string
+				text, keep = m.t.fence(line)
+				if keep {
+					out.WriteString(text)
+				}
+			}
+		}
+
+		if !handled {
+			if m.fence != "" {
+				out.WriteString(line)
+			} else {
+				end, hold := m.inline(&out, buf, pos, final)
+				if hold {
+					m.midLine = m.midLine || end > pos
+					return out.String(), buf[end:]
+				}
+
+				// 独立公式可以跨行，处理到的位置可能在之后的行中
+				lineEnd = end
+			}
+		}
+
+		if lineEnd >= len(buf) {
+			m.midLine = !final
+			break
+		}
+
+		if keep {
+			out.WriteByte('\n')
+		}
+		pos = lineEnd + 1
+		m.midLine = false
+	}
+
+	return out.String(), ""
+}
+
+// isClosingFence 是否为 fence 开始的代码块的结束围栏
+func isClosingFence(line, fence string) bool {
+	if !closingFence.MatchString(line) {
+		return false
+	}
+
+	marker := strings.TrimSpace(line)
+	return marker[0] == fence[0] && len(marker) >= len(fence)
+}
+
+func lineEndOf(buf string, pos int) int {
+	if idx := strings.IndexByte(buf[pos:], '\n'); idx >= 0 {
+		return pos + idx
+	}
+
+	return len(buf)
+}
+
+// inline 处理从 pos 开始的一行中代码块之外的内容，返回处理到的位置（行尾），需要等待后续内容时 hold 为 true，
+// 返回的位置为需要等待的内容的开始位置
+func (m *markdownTransformer) inline(out *strings.Builder, buf string, pos int, final bool) (int, bool) {
+	if lt, ok := m.t.(lineTransform); ok {
+		return m.inlineLine(lt, out, buf, pos, final)
+	}
+
+	t := m.t.(inlineTransform)
+	start := pos
+	lineEnd := lineEndOf(buf, pos)
+	for pos < lineEnd {
+		sp, n, hold := scanSpan(buf, pos, lineEnd, final)
+		if hold {
+			return pos, true
+		}
+
+		if n > 0 {
+			out.WriteString(t.span(sp))
+			if pos += n; pos > lineEnd {
+				lineEnd = lineEndOf(buf, pos)
+			}
+			continue
+		}
+
+		text, n, hold := t.text(buf[pos:lineEnd], !m.midLine && pos == start, lineEnd < len(buf) || final)
+		if hold {
+			return pos, true
+		}
+
+		out.WriteString(text)
+		pos += n
+	}
+
+	return lineEnd, false
+}
+
+// inlineLine 使用按整行处理的转换器处理一行，需要等待整行（包括跨行的独立公式）结束
+func (m *markdownTransformer) inlineLine(t lineTransform, out *strings.Builder, buf string, pos int, final bool) (int, bool) {
+	start := pos
+	lineEnd := lineEndOf(buf, pos)
+	if lineEnd >= len(buf) && !final {
+		return start, true
+	}
+
+	var masked strings.Builder
+	var spans []string
+	for pos < lineEnd {
+		sp, n, hold := scanSpan(buf, pos, lineEnd, final)
+		if hold {
+			return start, true
+		}
+
+		if n == 0 {
+			n = plainRun(buf[pos:lineEnd], "")
+			masked.WriteString(buf[pos : pos+n])
+			pos += n
+			continue
+		}
+
+		if (sp.kind == spanInlineMath || sp.kind == spanDisplayMath) && len(spans) < 0x1900 {
+			masked.WriteRune(rune(0xE000 + len(spans)))
+			spans = append(spans, t.span(sp))
+		} else {
+			masked.WriteString(sp.String())
+		}
+
+		if pos += n; pos > lineEnd {
+			lineEnd = lineEndOf(buf, pos)
+			if lineEnd >= len(buf) && !final {
+				return start, true
+			}
+		}
+	}
+
+	text := t.line(masked.String())
+	for i, s := range spans {
+		text = strings.Replace(text, string(rune(0xE000+i)), s, 1)
+	}
+
+	out.WriteString(text)
+	return lineEnd, false
+}
+
+// applyInline 使用转换器处理一段独立的文本（如链接文本），其中的独立公式不能超出这段文本
+func applyInline(t inlineTransform, text string) string {
+	var out strings.Builder
+	for pos := 0; pos < len(text); {
+		sp, n, _ := scanSpan(text, pos, len(text), true)
+		if n > 0 {
+			out.WriteString(t.span(sp))
+			pos += n
+			continue
+		}
+
+		s, n, _ := t.text(text[pos:], false, true)
+		out.WriteString(s)
+		pos += n
+	}
+
+	return out.String()
+}
+
+// plainRun 返回 text 开头的普通文本的长度（至少为 1），遇到可能是受保护内容开始的字符（\ $ `）以及 stops 中的字符时结束
+func plainRun(text string, stops string) int {
+	if idx := strings.IndexAny(text[1:], "\\$`"+stops); idx >= 0 {
+		return idx + 1
+	}
+
+	return len(text)
+}
+
+// scanSpan 检查 pos 位置是否为受保护内容的开始，是时返回受保护的内容和长度，不是时长度为 0，
+// 需要等待后续内容才能确定时 hold 为 true。行内代码和行内公式不能跨行，独立公式可以跨行
+func scanSpan(buf string, pos, lineEnd int, final bool) (textSpan, int, bool) {
+	complete := lineEnd < len(buf) || final
+	line := buf[:lineEnd]
+
+	switch buf[pos] {
+	case '\\':
+		if pos+1 >= lineEnd {
+			return textSpan{}, 0, !complete
+		}
+
+		switch line[pos+1] {
+		case '[':
+			return scanDisplayMath(buf, pos, `\[`, `\]`, final)
+		case '(':
+			end := strings.Index(line[pos+2:], `\)`)
+			if end < 0 {
+				if !complete {
+					return textSpan{}, 0, true
+				}
+
+				return textSpan{kind: spanLiteral, body: line[pos : pos+2]}, 2, false
+			}
+
+			return textSpan{kind: spanInlineMath, open: `\(`, body: line[pos+2 : pos+2+end], close: `\)`}, end + 4, false
+		}
+
+		// 其它转义字符（包括 \\ 和 \$）保持不变
+		return textSpan{kind: spanLiteral, body: line[pos : pos+2]}, 2, false
+	case '$':
+		if pos+1 >= lineEnd && !complete {
+			return textSpan{}, 0, true
+		}
+
+		if pos+1 < lineEnd && line[pos+1] == '$' {
+			return scanDisplayMath(buf, pos, "$$", "$$", final)
+		}
+
+		end := inlineDollarEnd(line, pos, complete)
+		if end < 0 {
+			return textSpan{}, 0, !complete
+		}
+
+		return textSpan{kind: spanInlineMath, open: "$", body: line[pos+1 : end], close: "$"}, end - pos + 1, false
+	case '`':
+		// 结束的反引号数量与开始的相同，没有配对时整串反引号原样保留
+		run := backtickRun(line, pos)
+		if pos+run >= lineEnd && !complete {
+			return textSpan{}, 0, true
+		}
+
+		end := findBacktickRun(line, pos+run, run)
+		if end < 0 {
+			if !complete {
+				return textSpan{}, 0, true
+			}
+
+			return textSpan{kind: spanLiteral, body: line[pos : pos+run]}, run, false
+		}
+
+		return textSpan{kind: spanCode, open: line[pos : pos+run], body: line[pos+run : end], close: line[end : end+run]}, end + run - pos, false
+	}
+
+	return textSpan{}, 0, false
+}
+
+// scanDisplayMath 查找 pos 位置开始的独立公式的结束分隔符，公式中的转义字符不会作为结束分隔符。
+// 在 displayMathMaxBytes 之内找不到结束分隔符时（或者已经没有后续内容），开始分隔符按照普通文本处理
+func scanDisplayMath(buf string, pos int, open, close string, final bool) (textSpan, int, bool) {
+	bodyStart := pos + len(open)
+	limit := min(len(buf), bodyStart+displayMathMaxBytes)
+	for j := bodyStart; j < limit; j++ {
+		if j+1 >= len(buf) {
+			break
+		}
+
+		if buf[j] == '\\' {
+			if close == `\]` && buf[j+1] == ']' {
+				return textSpan{kind: spanDisplayMath, open: open, body: buf[bodyStart:j], close: close}, j + 2 - pos, false
+			}
+
+			j++
+			continue
+		}
+
+		if close == "$$" && buf[j] == '$' && buf[j+1] == '$' {
+			return textSpan{kind: spanDisplayMath, open: open, body: buf[bodyStart:j], close: close}, j + 2 - pos, false
+		}
+	}
+
+	if !final && len(buf) <= bodyStart+displayMathMaxBytes {
+		return textSpan{}, 0, true
+	}
+
+	return textSpan{kind: spanLiteral, body: open}, len(open), false
+}
+
+// transformMarkdownStream 使用转换器处理流式响应，转换器需要为每个流单独创建（转换器可以保存状态）
+//
+// 可以确定的内容处理之后立即输出，无法确定的内容缓存到后续内容到达之后再处理，结束原因、错误以及工具调用的响应会先输出缓存的内容
+func transformMarkdownStream(ctx context.Context, stream <-chan Response, t markdownTransform) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		m := &markdownTransformer{t: t}
+		var pending string
+		for data := range stream {
+			if data.Interim {
+				if !send(data) {
+					return
+				}
+				continue
+			}
+
+			// 只包含文本的分片（参考 isPlainTextResponse），没有可以输出的内容时不输出；
+			// 包含其它字段（多模态内容、引用来源等）的分片总是输出，文本为空时只输出其它字段
+			plain := isPlainTextResponse(data)
+			final := data.FinishReason != "" || data.ErrorCode != "" || len(data.ToolCalls) > 0 || data.ToolCallDelta != nil
+			data.Text, pending = m.process(pending+data.Text, final)
+
+			if plain && data.Text == "" {
+				continue
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+
+		if text, _ := m.process(pending, true); text != "" {
+			send(Response{Text: text})
+		}
+	})
+}
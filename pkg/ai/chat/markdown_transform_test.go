@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// nestedFenceDocument 包含嵌套的代码块、跨行的独立公式以及需要处理的 HTML 和链接
+const nestedFenceDocument = "开始 <b>粗体</b>\n" +
+	"````md\n```go\nx := a < b && `c` // $y$\n```\n<script>alert(1)</script>\n````\n\n" +
+	"$$\na<b *c*\n$$\n" +
+	"\\[ x<y \\] 和 \\(p<q\\)\n" +
+	"行内 $a<b$ 和 **粗体** [点击 $x$](javascript:alert(1))\n" +
+	"~~~\n$$ 代码块中的 <i>\n~~~\n" +
+	"`code <i>` 尾部 $$ 未闭合 <img src=x>"
+
+// markdownTransforms 所有的流式输出转换器
+var markdownTransforms = map[string]func() markdownTransform{
+	"math-dollar":     func() markdownTransform { return mathNormalizer{style: MathDelimiterDollar} },
+	"math-latex":      func() markdownTransform { return mathNormalizer{style: MathDelimiterLaTeX} },
+	"sanitize-escape": func() markdownTransform { return &markdownSanitizer{policy: SanitizeEscape, maxLinkText: 200} },
+	"sanitize-strip":  func() markdownTransform { return &markdownSanitizer{policy: SanitizeStrip, maxLinkText: 200} },
+	"strip-markdown":  func() markdownTransform { return markdownStripper{} },
+}
+
+// transformChunks 模拟流式输出，依次处理每个分片
+func transformChunks(t markdownTransform, chunks []string) string {
+	m := &markdownTransformer{t: t}
+
+	var out, pending string
+	for _, chunk := range chunks {
+		var text string
+		text, pending = m.process(pending+chunk, false)
+		out += text
+	}
+
+	text, _ := m.process(pending, true)
+	return out + text
+}
+
+func TestTransformMarkdown(t *testing.T) {
+	sanitized := transformMarkdown(nestedFenceDocument, &markdownSanitizer{policy: SanitizeEscape})
+	// 嵌套的代码块中的内容保持不变
+	assert.True(t, strings.Contains(sanitized, "````md\n```go\nx := a < b && `c` // $y$\n```\n<script>alert(1)</script>\n````\n"))
+	assert.True(t, strings.Contains(sanitized, "~~~\n$$ 代码块中的 <i>\n~~~\n"))
+	// 公式中的内容保持不变，公式之外的内容正常处理
+	assert.True(t, strings.Contains(sanitized, "$$\na<b *c*\n$$\n\\[ x<y \\] 和 \\(p<q\\)\n行内 $a<b$ 和 **粗体** [点击 $x$](#)\n"))
+	// 没有闭合的独立公式不是公式
+	assert.True(t, strings.HasSuffix(sanitized, "`code <i>` 尾部 $$ 未闭合 &lt;img src=x&gt;"))
+	assert.True(t, strings.HasPrefix(sanitized, "开始 &lt;b&gt;粗体&lt;/b&gt;\n"))
+
+	stripped := transformMarkdown(nestedFenceDocument, markdownStripper{})
+	// 只去掉最外层代码块的围栏
+	assert.True(t, strings.Contains(stripped, "\n```go\nx := a < b && `c` // $y$\n```\n<script>alert(1)</script>\n\n"))
+	assert.True(t, strings.Contains(stripped, "$$\na<b *c*\n$$\n"))
+	assert.True(t, strings.Contains(stripped, "行内 $a<b$ 和 粗体 点击 $x$ (javascript:alert(1))\n"))
+
+	normalized := transformMarkdown(nestedFenceDocument, mathNormalizer{style: MathDelimiterLaTeX})
+	assert.True(t, strings.Contains(normalized, "x := a < b && `c` // $y$\n"))
+	assert.True(t, strings.Contains(normalized, "\\[\na<b *c*\n\\]\n\\[ x<y \\] 和 \\(p<q\\)\n行内 \\(a<b\\)"))
+	assert.True(t, strings.Contains(normalized, "~~~\n$$ 代码块中的 <i>\n~~~\n"))
+	assert.True(t, strings.HasSuffix(normalized, "尾部 $$ 未闭合 <img src=x>"))
+}
+
+func TestTransformMarkdown_Chunks(t *testing.T) {
+	for name, create := range markdownTransforms {
+		expected := transformMarkdown(nestedFenceDocument, create())
+
+		// 在任意位置拆分为两个分片
+		for i := 0; i <= len(nestedFenceDocument); i++ {
+			chunks := []string{nestedFenceDocument[:i], nestedFenceDocument[i:]}
+			if got := transformChunks(create(), chunks); got != expected {
+				t.Fatalf("%s: split at %d\nexpected: %q\ngot:      %q", name, i, expected, got)
+			}
+		}
+
+		// 逐字节拆分
+		chunks := make([]string, len(nestedFenceDocument))
+		for i := range chunks {
+			chunks[i] = nestedFenceDocument[i : i+1]
+		}
+		assert.Equal(t, expected, transformChunks(create(), chunks))
+	}
+}
+
+func TestTransformMarkdownStream_Meta(t *testing.T) {
+	stream := make(chan Response, 4)
+	// 第二个分片的文本仍然在等待公式闭合，分片中的其它字段不能丢失
+	stream <- Response{Text: "前言 $$\na<b"}
+	stream <- Response{Text: " *c*", Parts: []*MultipartContent{{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/1.png"}}}, Citations: []Citation{{URL: "https://example.com"}}, InputTokens: 10}
+	stream <- Response{Text: "\n$$ 后记 $$ x", ToolCallDelta: &ToolCallDelta{}}
+	stream <- Response{Text: "y", FinishReason: FinishReasonStop}
+	close(stream)
+
+	var text string
+	var merged Response
+	var toolCallText string
+	for res := range transformMarkdownStream(context.TODO(), stream, mathNormalizer{style: MathDelimiterLaTeX}) {
+		text += res.Text
+		merged.Parts = append(merged.Parts, res.Parts...)
+		merged.Citations = append(merged.Citations, res.Citations...)
+		merged.InputTokens += res.InputTokens
+		if res.ToolCallDelta != nil {
+			toolCallText = res.Text
+		}
+	}
+
+	assert.Equal(t, transformMarkdown("前言 $$\na<b *c*\n$$ 后记 $$ xy", mathNormalizer{style: MathDelimiterLaTeX}), text)
+	assert.Equal(t, 1, len(merged.Parts))
+	assert.Equal(t, 1, len(merged.Citations))
+	assert.Equal(t, 10, merged.InputTokens)
+
+	// 工具调用的分片先输出缓存的内容
+	assert.True(t, strings.HasSuffix(toolCallText, "$$ x"))
+}
+
+func TestTransformMarkdown_DisplayMathLimit(t *testing.T) {
+	// 超过最大长度仍未闭合的独立公式不再等待，开始分隔符按照普通文本处理
+	text := "$$ <b>" + strings.Repeat("x", displayMathMaxBytes) + "\n<i"
+	m := &markdownTransformer{t: &markdownSanitizer{policy: SanitizeEscape}}
+	out, pending := m.process(text, false)
+	assert.Equal(t, "$$ &lt;b&gt;"+strings.Repeat("x", displayMathMaxBytes)+"\n", out)
+	assert.Equal(t, "<i", pending)
+}
+
+func FuzzTransformMarkdownChunks(f *testing.F) {
+	f.Add(nestedFenceDocument, uint8(1))
+	f.Add(nestedFenceDocument, uint8(7))
+	f.Add("```\n$$\n```\n$$ <b> $$", uint8(3))
+	f.Add("\\[ a \\] [$x$](javascript:x) `$` ~~~~\n~~~\n~~~~", uint8(2))
+
+	f.Fuzz(func(t *testing.T, text string, size uint8) {
+		// 按照固定大小拆分为多个分片
+		step := int(size%16) + 1
+		var chunks []string
+		for i := 0; i < len(text); i += step {
+			chunks = append(chunks, text[i:min(i+step, len(text))])
+		}
+
+		for name, create := range markdownTransforms {
+			expected := transformMarkdown(text, create())
+			if got := transformChunks(create(), chunks); got != expected {
+				t.Fatalf("%s: chunk size %d\nexpected: %q\ngot:      %q", name, step, expected, got)
+			}
+		}
+	})
+}
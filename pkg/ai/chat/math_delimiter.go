@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+)
+
+// MathDelimiterStyle 输出内容中数学公式的分隔符格式
+//
+// 不同的模型使用不同的分隔符输出公式（\( \)、$$ $$、\[ \] 等），客户端的渲染器只支持其中一种
+type MathDelimiterStyle string
+
+const (
+	// MathDelimiterDollar 行内公式使用 $...$，独立公式使用 $$...$$
+	MathDelimiterDollar MathDelimiterStyle = "dollar"
+	// MathDelimiterLaTeX 行内公式使用 \(...\)，独立公式使用 \[...\]
+	MathDelimiterLaTeX MathDelimiterStyle = "latex"
+)
+
+// Validate 校验分隔符格式是否合法，为空时不转换
+func (s MathDelimiterStyle) Validate() error {
+	switch s {
+	case "", MathDelimiterDollar, MathDelimiterLaTeX:
+		return nil
+	}
+
+	return fmt.Errorf("invalid math delimiter style: %s", s)
+}
+
+// delimiters 返回行内公式和独立公式的开始、结束分隔符
+func (s MathDelimiterStyle) delimiters() (inlineOpen, inlineClose, displayOpen, displayClose string) {
+	if s == MathDelimiterLaTeX {
+		return `\(`, `\)`, `\[`, `\]`
+	}
+
+	return "$", "$", "$$", "$$"
+}
+
+// normalizeMathDelimiters 将文本中数学公式的分隔符统一转换为 style 指定的格式，代码块和行内代码保持不变
+func normalizeMathDelimiters(text string, style MathDelimiterStyle) string {
+	if style == "" {
+		return text
+	}
+
+	return transformMarkdown(text, mathNormalizer{style: style})
+}
+
+// mathNormalizer 转换数学公式的分隔符，公式由 markdownTransformer 识别（参考 scanSpan）
+//
+// 行内公式和行内代码不能跨行；$ 作为行内公式的分隔符时，开始的 $ 之后和结束的 $ 之前不能是空白字符，
+// 结束的 $ 之后不能是数字，避免把金额（如 $5 和 $10）当作公式
+type mathNormalizer struct {
+	style MathDelimiterStyle
+}
+
+func (n mathNormalizer) fence(line string) (string, bool) {
+	return line, true
+}
+
+func (n mathNormalizer) span(s textSpan) string {
+	inlineOpen, inlineClose, displayOpen, displayClose := n.style.delimiters()
+	switch s.kind {
+	case spanInlineMath:
+		return inlineOpen + s.body + inlineClose
+	case spanDisplayMath:
+		return displayOpen + s.body + displayClose
+	}
+
+	return s.String()
+}
+
+func (n mathNormalizer) text(text string, _, _ bool) (string, int, bool) {
+	end := plainRun(text, "")
+	return text[:end], end, false
+}
+
+// inlineDollarEnd 查找 start 位置的 $ 开始的行内公式结束的 $ 的位置，不是行内公式时返回 -1
+//
+// complete 为 false 时，行尾的 $ 之后的内容尚未确定，不作为结束的 $
+func inlineDollarEnd(line string, start int, complete bool) int {
+	if start+1 >= len(line) || isMathSpace(line[start+1]) {
+		return -1
+	}
+
+	for j := start + 1; j < len(line); j++ {
+		switch line[j] {
+		case '\\':
+			j++
+		case '$':
+			if j == start+1 || isMathSpace(line[j-1]) {
+				continue
+			}
+
+			if j+1 >= len(line) {
+				if complete {
+					return j
+				}
+
+				return -1
+			}
+
+			if line[j+1] < '0' || line[j+1] > '9' {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
+
+func isMathSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+// backtickRun 返回 start 位置开始的连续反引号数量
+func backtickRun(line string, start int) int {
+	run := 0
+	for start+run < len(line) && line[start+run] == '`' {
+		run++
+	}
+
+	return run
+}
+
+// findBacktickRun 从 start 位置开始查找数量恰好为 run 的连续反引号，返回其位置，找不到时返回 -1
+func findBacktickRun(line string, start, run int) int {
+	for i := start; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+
+		n := backtickRun(line, i)
+		if n == run {
+			return i
+		}
+
+		i += n
+	}
+
+	return -1
+}
+
+// normalizeMathStream 转换流式响应中数学公式的分隔符
+//
+// 分隔符可能被拆分到多个分片中，无法确定的内容（可能是分隔符的一部分、尚未结束的公式或者行内代码、
+// 行首可能是代码块围栏的内容）会等待后续内容（参考 markdownTransformer），独立公式在结束之后才输出
+func normalizeMathStream(ctx context.Context, stream <-chan Response, style MathDelimiterStyle) <-chan Response {
+	return transformMarkdownStream(ctx, stream, mathNormalizer{style: style})
+}
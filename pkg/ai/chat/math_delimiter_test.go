@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+const mixedMathText = "行内公式 \\(a^2+b^2=c^2\\) 和 $E=mc^2$，价格 $5 和 $10\n" +
+	"$$\n\\int_0^1 x\\,dx\n$$\n" +
+	"\\[ \\frac{1}{2} \\]\n" +
+	"转义 \\$ 和代码 `$x$ \\(y\\)`\n" +
+	"```latex\n\\(x\\) $$y$$\n```\n" +
+	"结束 \\(x\\)"
+
+func TestNormalizeMathDelimiters(t *testing.T) {
+	assert.Equal(t, "行内公式 $a^2+b^2=c^2$ 和 $E=mc^2$，价格 $5 和 $10\n"+
+		"$$\n\\int_0^1 x\\,dx\n$$\n"+
+		"$$ \\frac{1}{2} $$\n"+
+		"转义 \\$ 和代码 `$x$ \\(y\\)`\n"+
+		"```latex\n\\(x\\) $$y$$\n```\n"+
+		"结束 $x$", normalizeMathDelimiters(mixedMathText, MathDelimiterDollar))
+
+	assert.Equal(t, "行内公式 \\(a^2+b^2=c^2\\) 和 \\(E=mc^2\\)，价格 $5 和 $10\n"+
+		"\\[\n\\int_0^1 x\\,dx\n\\]\n"+
+		"\\[ \\frac{1}{2} \\]\n"+
+		"转义 \\$ 和代码 `$x$ \\(y\\)`\n"+
+		"```latex\n\\(x\\) $$y$$\n```\n"+
+		"结束 \\(x\\)", normalizeMathDelimiters(mixedMathText, MathDelimiterLaTeX))
+
+	assert.Equal(t, mixedMathText, normalizeMathDelimiters(mixedMathText, ""))
+}
+
+func TestNormalizeMathStream(t *testing.T) {
+	for _, style := range []MathDelimiterStyle{MathDelimiterDollar, MathDelimiterLaTeX} {
+		expected := normalizeMathDelimiters(mixedMathText, style)
+
+		// 按照不同的长度拆分，覆盖分隔符被拆分到两个分片中的情况
+		for size := 1; size <= 8; size++ {
+			var responses []Response
+			runes := []rune(mixedMathText)
+			for i := 0; i < len(runes); i += size {
+				responses = append(responses, Response{Text: string(runes[i:min(i+size, len(runes))])})
+			}
+			responses = append(responses, Response{FinishReason: FinishReasonStop})
+
+			res := collectStream(normalizeMathStream(context.TODO(), sliceStream(responses, 0), style))
+			assert.Equal(t, expected, concatText(res))
+			assert.Equal(t, FinishReasonStop, res[len(res)-1].FinishReason)
+		}
+	}
+}
+
+func TestNormalizeMathStream_SplitDelimiter(t *testing.T) {
+	res := collectStream(normalizeMathStream(context.TODO(), sliceStream([]Response{
+		{Text: "公式 \\"}, {Text: "(x+1\\"}, {Text: ") 和 $"}, {Text: "$y$"}, {Text: "$ 完成"},
+	}, 0), MathDelimiterDollar))
+	// 独立公式在结束之后才输出
+	assert.EqualValues(t, []Response{{Text: "公式 "}, {Text: "$x+1$ 和 "}, {Text: "$$y$$ 完成"}}, res)
+
+	// 没有结束原因时，流结束后输出缓存的内容
+	res = collectStream(normalizeMathStream(context.TODO(), sliceStream([]Response{
+		{Text: "价格 $"}, {Text: "5"}, {Interim: true, Warning: "warning"},
+	}, 0), MathDelimiterLaTeX))
+	assert.EqualValues(t, []Response{{Text: "价格 "}, {Interim: true, Warning: "warning"}, {Text: "$5"}}, res)
+}
+
+func TestMathDelimiterStyle_Validate(t *testing.T) {
+	assert.NoError(t, MathDelimiterStyle("").Validate())
+	assert.NoError(t, MathDelimiterDollar.Validate())
+	assert.NoError(t, MathDelimiterLaTeX.Validate())
+	assert.True(t, MathDelimiterStyle("katex").Validate() != nil)
+}
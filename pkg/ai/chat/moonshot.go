@@ -26,7 +26,7 @@ func (chat *MoonshotChat) initRequest(req Request) (*openai.ChatCompletionReques
 
 	for _, msg := range req.Messages {
 		m := openai.ChatCompletionMessage{
-			Role:    msg.Role,
+			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
 
@@ -50,9 +50,12 @@ func (chat *MoonshotChat) initRequest(req Request) (*openai.ChatCompletionReques
 	req.Model = oai.SelectBestModel(req.Model, tokenCount)
 
 	return &openai.ChatCompletionRequest{
-		Model:     req.Model,
-		Messages:  messages,
-		MaxTokens: req.MaxTokens,
+		Model:       req.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: float32Value(req.Temperature),
+		TopP:        float32Value(req.TopP),
+		Stop:        req.Stop,
 	}, nil
 }
 
@@ -64,9 +67,9 @@ func (chat *MoonshotChat) Chat(ctx context.Context, req Request) (*Response, err
 
 	res, err := chat.oai.Chat(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
-			log.With(err).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
+			log.With(err).Errorf("违反内容安全策略: %s", filterErr.Detail())
+			return nil, filterErr
 		}
 
 		return nil, err
@@ -82,6 +85,7 @@ func (chat *MoonshotChat) Chat(ctx context.Context, req Request) (*Response, err
 		),
 		InputTokens:  res.Usage.PromptTokens,
 		OutputTokens: res.Usage.CompletionTokens,
+		FinishReason: openAIFinishReason(res.Choices),
 	}, nil
 }
 
@@ -95,14 +99,15 @@ func (chat *MoonshotChat) ChatStream(ctx context.Context, req Request) (<-chan R
 
 	stream, err := chat.oai.ChatStream(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
 			log.WithFields(log.Fields{
 				"error":   err,
+				"detail":  filterErr.Detail(),
 				"message": req.assembleMessage(),
 				"model":   req.Model,
 				"room_id": req.RoomID,
-			}).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+			}).Errorf("违反内容安全策略")
+			return nil, filterErr
 		}
 
 		return nil, err
@@ -137,6 +142,7 @@ func (chat *MoonshotChat) ChatStream(ctx context.Context, req Request) (<-chan R
 						},
 						"",
 					),
+					FinishReason: openAIFinishReason(data.ChatResponse.Choices),
 				}
 			}
 		}
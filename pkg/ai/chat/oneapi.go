@@ -3,6 +3,7 @@ package chat
 import (
 	"context"
 	"github.com/mylxsw/aidea-server/pkg/ai/oneapi"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/uploader"
 	"strings"
@@ -28,7 +29,7 @@ func (chat *OneAPIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 	for _, msg := range req.Messages {
 		m := openai.ChatCompletionMessage{
-			Role:    msg.Role,
+			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
 
@@ -57,7 +58,7 @@ func (chat *OneAPIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 					ret.ImageURL = &openai.ChatMessageImageURL{
 						URL:    url,
-						Detail: item.ImageURL.Detail,
+						Detail: openAIImageDetail(item.ImageURL.Detail),
 					}
 				}
 
@@ -74,9 +75,13 @@ func (chat *OneAPIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 	messages := append(systemMessages, contextMessages...)
 	return &openai.ChatCompletionRequest{
-		Model:     req.Model,
-		Messages:  messages,
-		MaxTokens: req.MaxTokens,
+		Model:       req.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: float32Value(req.Temperature),
+		TopP:        float32Value(req.TopP),
+		Stop:        req.Stop,
+		Seed:        req.Seed,
 	}, nil
 }
 
@@ -86,11 +91,12 @@ func (chat *OneAPIChat) Chat(ctx context.Context, req Request) (*Response, error
 		return nil, err
 	}
 
+	ctx = openai2.WithPassthroughBody(ctx, req.ExtraBody)
 	res, err := chat.oai.Chat(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
-			log.With(err).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
+			log.With(err).Errorf("违反内容安全策略: %s", filterErr.Detail())
+			return nil, filterErr
 		}
 
 		return nil, err
@@ -104,8 +110,10 @@ func (chat *OneAPIChat) Chat(ctx context.Context, req Request) (*Response, error
 			},
 			"",
 		),
-		InputTokens:  res.Usage.PromptTokens,
-		OutputTokens: res.Usage.CompletionTokens,
+		InputTokens:       res.Usage.PromptTokens,
+		OutputTokens:      res.Usage.CompletionTokens,
+		FinishReason:      openAIFinishReason(res.Choices),
+		SystemFingerprint: res.SystemFingerprint,
 	}, nil
 }
 
@@ -116,17 +124,19 @@ func (chat *OneAPIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 	}
 
 	openaiReq.Stream = true
+	ctx = openai2.WithPassthroughBody(ctx, req.ExtraBody)
 
 	stream, err := chat.oai.ChatStream(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
 			log.WithFields(log.Fields{
 				"error":   err,
+				"detail":  filterErr.Detail(),
 				"message": req.assembleMessage(),
 				"model":   req.Model,
 				"room_id": req.RoomID,
-			}).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+			}).Errorf("违反内容安全策略")
+			return nil, filterErr
 		}
 
 		return nil, err
@@ -161,6 +171,7 @@ func (chat *OneAPIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 						},
 						"",
 					),
+					FinishReason: openAIFinishReason(data.ChatResponse.Choices),
 				}
 			}
 		}
@@ -2,6 +2,7 @@ package chat
 
 import (
 	"context"
+	"errors"
 	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/uploader"
@@ -28,8 +29,10 @@ func (chat *OpenAIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 	for _, msg := range req.Messages {
 		m := openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
+			Role:       string(msg.Role),
+			Content:    msg.Content,
+			ToolCalls:  toOpenAIToolCalls(msg.ToolCalls),
+			ToolCallID: msg.ToolCallID,
 		}
 
 		if len(msg.MultipartContents) > 0 {
@@ -57,7 +60,7 @@ func (chat *OpenAIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 					ret.ImageURL = &openai.ChatMessageImageURL{
 						URL:    url,
-						Detail: item.ImageURL.Detail,
+						Detail: openAIImageDetail(item.ImageURL.Detail),
 					}
 				}
 
@@ -74,9 +77,14 @@ func (chat *OpenAIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 	messages := append(systemMessages, contextMessages...)
 	return &openai.ChatCompletionRequest{
-		Model:     req.Model,
-		Messages:  messages,
-		MaxTokens: req.MaxTokens,
+		Model:       req.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: float32Value(req.Temperature),
+		TopP:        float32Value(req.TopP),
+		Stop:        req.Stop,
+		Seed:        req.Seed,
+		Tools:       toOpenAITools(req.Tools),
 	}, nil
 }
 
@@ -86,17 +94,20 @@ func (chat *OpenAIChat) Chat(ctx context.Context, req Request) (*Response, error
 		return nil, err
 	}
 
+	ctx = withOpenAIExtraBody(ctx, req)
+	ctx, refusal := openai2.WithRefusalRecorder(ctx)
+
 	res, err := chat.oai.CreateChatCompletion(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
-			log.With(err).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
+			log.With(err).Errorf("违反内容安全策略: %s", filterErr.Detail())
+			return nil, filterErr
 		}
 
-		return nil, err
+		return nil, wrapOpenAIError("openai", err)
 	}
 
-	return &Response{
+	ret := Response{
 		Text: array.Reduce(
 			res.Choices,
 			func(carry string, item openai.ChatCompletionChoice) string {
@@ -104,9 +115,19 @@ func (chat *OpenAIChat) Chat(ctx context.Context, req Request) (*Response, error
 			},
 			"",
 		),
-		InputTokens:  res.Usage.PromptTokens,
-		OutputTokens: res.Usage.CompletionTokens,
-	}, nil
+		InputTokens:       res.Usage.PromptTokens,
+		OutputTokens:      res.Usage.CompletionTokens,
+		FinishReason:      openAIFinishReason(res.Choices),
+		SystemFingerprint: res.SystemFingerprint,
+	}
+
+	for _, choice := range res.Choices {
+		ret.ToolCalls = append(ret.ToolCalls, fromOpenAIToolCalls(choice.Message.ToolCalls)...)
+	}
+
+	ret = withRefusal(ret, refusal.Text())
+
+	return &ret, nil
 }
 
 func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
@@ -116,52 +137,116 @@ func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 	}
 
 	openaiReq.Stream = true
+	ctx = withOpenAIExtraBody(ctx, req)
+	ctx, refusal := openai2.WithRefusalRecorder(ctx)
 
 	stream, err := chat.oai.ChatStream(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
 			log.WithFields(log.Fields{
 				"error":   err,
+				"detail":  filterErr.Detail(),
 				"message": req.assembleMessage(),
 				"model":   req.Model,
 				"room_id": req.RoomID,
-			}).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+			}).Errorf("违反内容安全策略")
+			return nil, filterErr
 		}
 
-		return nil, err
+		return nil, wrapOpenAIError("openai", err)
 	}
 
 	res := make(chan Response)
 	go func() {
 		defer close(res)
 
+		// 工具调用是以片段的形式返回的，需要组装后才能使用
+		toolCalls := newToolCallAssembler()
+		// 拒绝回答的说明是否已经返回
+		refused := false
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case data, ok := <-stream:
 				if !ok {
+					if !toolCalls.Empty() {
+						select {
+						case <-ctx.Done():
+						case res <- Response{ToolCalls: toolCalls.ToolCalls()}:
+						}
+					}
+
+					// 上游没有返回结束原因时，在流结束时返回拒绝回答的说明
+					if text := refusal.Text(); text != "" && !refused {
+						select {
+						case <-ctx.Done():
+						case res <- withRefusal(Response{}, text):
+						}
+					}
 					return
 				}
 
 				if data.Code != "" {
-					res <- Response{
+					ret := Response{
 						Error:     data.ErrorMessage,
 						ErrorCode: data.Code,
 					}
+
+					var upstreamErr *UpstreamError
+					if errors.As(wrapOpenAIError("openai", data.Err), &upstreamErr) {
+						ret.ErrorCode = data.Code + ":" + upstreamErr.ErrorCode()
+						ret.Upstream = upstreamErr
+					}
+
+					res <- ret
 					return
 				}
 
-				res <- Response{
-					Text: array.Reduce(
-						data.ChatResponse.Choices,
-						func(carry string, item openai.ChatCompletionStreamChoice) string {
-							return carry + item.Delta.Content
-						},
-						"",
-					),
+				var hasToolCall bool
+				for _, choice := range data.ChatResponse.Choices {
+					for i, call := range choice.Delta.ToolCalls {
+						hasToolCall = true
+						index := i
+						if call.Index != nil {
+							index = *call.Index
+						}
+
+						delta := toolCalls.Add(index, call.ID, string(call.Type), call.Function.Name, call.Function.Arguments)
+						if !req.StreamToolCalls {
+							continue
+						}
+
+						select {
+						case <-ctx.Done():
+							return
+						case res <- Response{ToolCallDelta: &delta, Interim: true}:
+						}
+					}
+				}
+
+				text := array.Reduce(
+					data.ChatResponse.Choices,
+					func(carry string, item openai.ChatCompletionStreamChoice) string {
+						return carry + item.Delta.Content
+					},
+					"",
+				)
+				// 只包含工具调用片段的响应，不需要返回空的文本
+				if text == "" && hasToolCall {
+					continue
 				}
+
+				ret := openAIStreamResponse(text, data.ChatResponse.Choices)
+				// 拒绝回答的说明以片段的形式返回，在包含结束原因的响应中返回累积的完整说明以及模型指纹
+				if ret.FinishReason != "" && ret.ErrorCode == "" {
+					ret = withRefusal(ret, refusal.Text())
+					refused = ret.Refusal != ""
+					ret.SystemFingerprint = refusal.SystemFingerprint()
+				}
+
+				res <- ret
 			}
 		}
 
@@ -170,6 +255,40 @@ func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 	return res, nil
 }
 
+// withRefusal 模型拒绝回答时，在响应中附带拒绝的说明，并将结束原因设置为 FinishReasonRefusal
+func withRefusal(res Response, refusal string) Response {
+	if refusal == "" {
+		return res
+	}
+
+	res.Refusal = refusal
+	res.FinishReason = FinishReasonRefusal
+	return res
+}
+
 func (chat *OpenAIChat) MaxContextLength(model string) int {
 	return openai2.ModelMaxContextSize(model)
 }
+
+// ValidateExtraBody 检查额外参数（请求或者渠道配置中的 extra_body）是否包含不允许指定的参数（请求结构体中的参数以及服务端控制的参数，参考 openai.ExtraBodyDeniedKeys）
+func ValidateExtraBody(fields map[string]any) error {
+	return openai2.ValidateExtraBody(fields)
+}
+
+// withOpenAIExtraBody 通过 ctx 在请求体中追加 go-openai 不支持的参数
+//
+//   - 思考预算转换为 reasoning_effort 参数
+//   - temperature 为 0 时 go-openai 会忽略该参数（omitempty），服务端会使用默认值 1，需要明确指定
+//   - 请求中指定的额外参数（Request.ExtraBody），不能覆盖请求体中已有的参数
+func withOpenAIExtraBody(ctx context.Context, req Request) context.Context {
+	fields := make(map[string]any)
+	if effort := req.ReasoningBudget.Effort(); effort != "" {
+		fields["reasoning_effort"] = effort
+	}
+
+	if req.Temperature != nil && *req.Temperature == 0 {
+		fields["temperature"] = 0
+	}
+
+	return openai2.WithPassthroughBody(openai2.WithExtraBody(ctx, fields), req.ExtraBody)
+}
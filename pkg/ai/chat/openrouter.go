@@ -2,6 +2,7 @@ package chat
 
 import (
 	"context"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
 	"github.com/mylxsw/aidea-server/pkg/ai/openrouter"
 	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/go-utils/array"
@@ -25,7 +26,7 @@ func (chat *OpenRouterChat) initRequest(req Request) (*openai.ChatCompletionRequ
 
 	for _, msg := range req.Messages {
 		m := openai.ChatCompletionMessage{
-			Role:    msg.Role,
+			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
 
@@ -38,9 +39,13 @@ func (chat *OpenRouterChat) initRequest(req Request) (*openai.ChatCompletionRequ
 
 	messages := append(systemMessages, contextMessages...)
 	return &openai.ChatCompletionRequest{
-		Model:     req.Model,
-		Messages:  messages,
-		MaxTokens: req.MaxTokens,
+		Model:       req.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: float32Value(req.Temperature),
+		TopP:        float32Value(req.TopP),
+		Stop:        req.Stop,
+		Seed:        req.Seed,
 	}, nil
 }
 
@@ -50,11 +55,12 @@ func (chat *OpenRouterChat) Chat(ctx context.Context, req Request) (*Response, e
 		return nil, err
 	}
 
+	ctx = openai2.WithPassthroughBody(ctx, req.ExtraBody)
 	res, err := chat.oai.Chat(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
-			log.With(err).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
+			log.With(err).Errorf("违反内容安全策略: %s", filterErr.Detail())
+			return nil, filterErr
 		}
 
 		return nil, err
@@ -68,8 +74,10 @@ func (chat *OpenRouterChat) Chat(ctx context.Context, req Request) (*Response, e
 			},
 			"",
 		),
-		InputTokens:  res.Usage.PromptTokens,
-		OutputTokens: res.Usage.CompletionTokens,
+		InputTokens:       res.Usage.PromptTokens,
+		OutputTokens:      res.Usage.CompletionTokens,
+		FinishReason:      openAIFinishReason(res.Choices),
+		SystemFingerprint: res.SystemFingerprint,
 	}, nil
 }
 
@@ -80,17 +88,19 @@ func (chat *OpenRouterChat) ChatStream(ctx context.Context, req Request) (<-chan
 	}
 
 	openaiReq.Stream = true
+	ctx = openai2.WithPassthroughBody(ctx, req.ExtraBody)
 
 	stream, err := chat.oai.ChatStream(ctx, *openaiReq)
 	if err != nil {
-		if strings.Contains(err.Error(), "content management policy") {
+		if filterErr := openAIContentFilterError(err); filterErr != nil {
 			log.WithFields(log.Fields{
 				"error":   err,
+				"detail":  filterErr.Detail(),
 				"message": req.assembleMessage(),
 				"model":   req.Model,
 				"room_id": req.RoomID,
-			}).Errorf("违反 Azure OpenAI 内容管理策略")
-			return nil, ErrContentFilter
+			}).Errorf("违反内容安全策略")
+			return nil, filterErr
 		}
 
 		return nil, err
@@ -125,6 +135,7 @@ func (chat *OpenRouterChat) ChatStream(ctx context.Context, req Request) (<-chan
 						},
 						"",
 					),
+					FinishReason: openAIFinishReason(data.ChatResponse.Choices),
 				}
 			}
 		}
@@ -0,0 +1,188 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultOutputCapBase 请求和模型都没有指定最大输出 Token 数量时，计算流式输出上限使用的基数
+const defaultOutputCapBase = 16384
+
+// OutputLimitWarning 流式输出超过 Token 数量绝对上限被强制终止时，在结束响应中返回给用户的提示
+const OutputLimitWarning = "输出内容超过最大 Token 数量限制，已停止生成"
+
+// outputCapLimit 流式输出的 Token 数量上限：max(请求的 max_tokens, 模型的 max_output) * factor，factor 小于等于 0 时不限制（返回 0）
+func outputCapLimit(maxTokens, maxOutput int, factor float64) int {
+	if factor <= 0 {
+		return 0
+	}
+
+	base := max(maxTokens, maxOutput)
+	if base <= 0 {
+		base = defaultOutputCapBase
+	}
+
+	return int(float64(base) * factor)
+}
+
+// absoluteOutputLimit 流式输出的 Token 数量绝对上限：全局配置与房间配置中较小的非 0 值，都为 0 时不限制（返回 0）
+func absoluteOutputLimit(global, room int) int {
+	if global <= 0 || (room > 0 && room < global) {
+		return max(room, 0)
+	}
+
+	return global
+}
+
+// newOutputCapCounter 创建流式输出超过 Token 数量上限的次数统计（按渠道），并注册到 registerer
+func newOutputCapCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_output_cap_count",
+		Help:      "streams terminated for exceeding the output token cap",
+	}, []string{"channel"})
+
+	if err := registerer.Register(counter); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing
+			}
+		}
+
+		log.Errorf("register output cap metrics failed: %v", err)
+	}
+
+	return counter
+}
+
+// outputCapChat 限制流式输出的 Token 数量，避免上游异常时（流一直不结束、重复输出）产生大量的费用
+//
+// 上限为系数计算的上限（outputCapLimit）与绝对上限（absoluteOutputLimit）中较小的值。
+// 输出内容超过上限时，截断到上限以内，结束原因设置为 FinishReasonLength，同时取消上游请求。
+// Token 数量与计费使用相同的计算方式，因此计费的输出 Token 数量不会超过上限
+type outputCapChat struct {
+	imp   Chat
+	limit int
+	// note 强制终止时在结束响应中返回的提示（Response.Warning），为空时不提示
+	note string
+	// model 计算 Token 数量使用的模型，与计费相同（模型重写之前的名称）
+	model        string
+	provider     repo.ModelProvider
+	providerType string
+	countTokens  func(messages Messages, model string) (int, error)
+	// metrics 超过上限的次数统计，为 nil 时不统计
+	metrics *prometheus.CounterVec
+}
+
+func (c *outputCapChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	return c.imp.Chat(ctx, req)
+}
+
+func (c *outputCapChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	stream, err := c.imp.ChatStream(upstreamCtx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		defer cancel()
+
+		// 空内容的 Token 数量（消息本身的开销），分片的 Token 数量需要减去该值
+		overhead := c.count("")
+
+		var text strings.Builder
+		// tokens 已输出内容的 Token 数量，按照分片累加（近似值），超过上限时重新计算准确值
+		tokens := overhead
+		for data := range stream {
+			if data.Text == "" {
+				if !send(data) {
+					return
+				}
+
+				continue
+			}
+
+			tokens += c.count(data.Text) - overhead
+			if tokens > c.limit {
+				tokens = c.count(text.String() + data.Text)
+			}
+
+			if tokens <= c.limit {
+				text.WriteString(data.Text)
+				if !send(data) {
+					return
+				}
+
+				continue
+			}
+
+			data.Text = c.truncate(text.String(), data.Text)
+			data.FinishReason = FinishReasonLength
+			data.StoppedBy = ""
+			if c.note != "" {
+				data.addWarnings(c.note)
+			}
+			text.WriteString(data.Text)
+
+			c.report(req, c.count(text.String()))
+			send(data)
+			return
+		}
+	}), nil
+}
+
+func (c *outputCapChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
+
+// count 计算输出内容的 Token 数量，与计费的计算方式相同，无法计算时返回 0
+func (c *outputCapChat) count(text string) int {
+	tokens, err := c.countTokens(Messages{{Role: RoleAssistant, Content: text}}, c.model)
+	if err != nil {
+		return 0
+	}
+
+	return tokens
+}
+
+// truncate 返回 chunk 的最长前缀，使 prefix 与该前缀拼接后的 Token 数量不超过上限
+func (c *outputCapChat) truncate(prefix, chunk string) string {
+	runes := []rune(chunk)
+
+	// 二分查找满足条件的最大长度
+	low, high := 0, len(runes)
+	for low < high {
+		mid := (low + high + 1) / 2
+		if c.count(prefix+string(runes[:mid])) <= c.limit {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	return string(runes[:low])
+}
+
+// report 记录超过上限的事件
+func (c *outputCapChat) report(req Request, tokens int) {
+	channel := providerKey(c.provider)
+	if c.metrics != nil {
+		c.metrics.WithLabelValues(channel).Inc()
+	}
+
+	log.F(log.M{
+		"model":         req.Model,
+		"channel":       channel,
+		"provider":      c.providerType,
+		"limit":         c.limit,
+		"output_tokens": tokens,
+	}).Errorf("流式输出超过 Token 数量上限，已强制终止")
+}
@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutputCapLimit(t *testing.T) {
+	assert.Equal(t, 0, outputCapLimit(1000, 4096, 0))
+	assert.Equal(t, 8192, outputCapLimit(1000, 4096, 2))
+	assert.Equal(t, 3000, outputCapLimit(2000, 0, 1.5))
+	assert.Equal(t, defaultOutputCapBase*2, outputCapLimit(0, 0, 2))
+}
+
+func TestDispatcher_OutputCap(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "a b "}, {Text: "c d "}, {Text: "e f g h "}, {Text: "i j "}, {FinishReason: "stop"}}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{ID: 10, ModelRewrite: "gpt-4-turbo"}},
+			Meta:      repo.ModelMeta{MaxOutput: 3},
+		},
+	}
+
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.outputCapFactor = 2
+	d.outputCaps = newOutputCapCounter(prometheus.NewRegistry())
+	// 使用单词数量代替 Token 数量，同时记录计算使用的模型
+	var countModels []string
+	d.countTokens = func(messages Messages, model string) (int, error) {
+		countModels = append(countModels, model)
+		return wordCount(messages, model)
+	}
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	// 上限为 max(0, 3) * 2 = 6，超过上限的分片被截断，并以 length 结束
+	responses := assertFinishReasonConformance(t, stream)
+	assert.Equal(t, 3, len(responses))
+	assert.Equal(t, FinishReasonLength, responses[2].FinishReason)
+
+	var text string
+	for _, res := range responses {
+		text += res.Text
+	}
+	assert.Equal(t, "a b c d e f ", text)
+
+	// 与计费使用相同的模型（模型重写之前）计算 Token 数量
+	assert.True(t, len(countModels) > 0)
+	for _, m := range countModels {
+		assert.Equal(t, "gpt-4", m)
+	}
+
+	assert.EqualValues(t, 1, testutil.ToFloat64(d.outputCaps.WithLabelValues("channel:10")))
+
+	// 请求的 max_tokens 更大时，以请求的为准
+	stream, err = d.ChatStream(context.TODO(), Request{Model: "gpt-4", MaxTokens: 10, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	responses = assertFinishReasonConformance(t, stream)
+	assert.Equal(t, FinishReasonStop, responses[len(responses)-1].FinishReason)
+	assert.Equal(t, 10, len(strings.Fields(responses[0].Text+responses[1].Text+responses[2].Text+responses[3].Text)))
+}
+
+// endlessStreamClient 一直输出内容直到请求被取消，模拟上游异常时无限输出
+type endlessStreamClient struct {
+	ChatTestClient
+	cancelled chan struct{}
+}
+
+func (c *endlessStreamClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+		defer close(c.cancelled)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case res <- Response{Text: "a b "}:
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+func TestAbsoluteOutputLimit(t *testing.T) {
+	assert.Equal(t, 0, absoluteOutputLimit(0, 0))
+	assert.Equal(t, 100, absoluteOutputLimit(100, 0))
+	assert.Equal(t, 50, absoluteOutputLimit(0, 50))
+	assert.Equal(t, 50, absoluteOutputLimit(100, 50))
+	assert.Equal(t, 100, absoluteOutputLimit(100, 200))
+}
+
+func TestDispatcher_OutputKillSwitch(t *testing.T) {
+	client := &endlessStreamClient{cancelled: make(chan struct{})}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{ID: 10}},
+			Meta:      repo.ModelMeta{MaxOutput: 4096},
+		},
+	}
+
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.outputCapFactor = 2
+	d.maxOutputTokens = 100
+	d.countTokens = wordCount
+
+	// 房间配置的上限更小时以房间配置为准，与模型和请求的 max_tokens 无关
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", MaxTokens: 4096, OutputTokenLimit: 9, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	last := responses[len(responses)-1]
+	assert.Equal(t, FinishReasonLength, last.FinishReason)
+	assert.Equal(t, OutputLimitWarning, last.Warning)
+
+	var text string
+	for _, res := range responses {
+		text += res.Text
+	}
+	assert.Equal(t, 9, len(strings.Fields(text)))
+
+	// 上游请求被取消
+	select {
+	case <-client.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream stream is not cancelled")
+	}
+}
+
+func TestDispatcher_OutputKillSwitchWarnings(t *testing.T) {
+	upstream := "上游返回的警告"
+	client := &streamChatClient{chunks: []Response{
+		{Text: "a b "},
+		{Text: "c d e f ", Warning: upstream, Warnings: []string{upstream}},
+		{FinishReason: "stop"},
+	}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{ID: 10}},
+			Meta:      repo.ModelMeta{MaxOutput: 4096},
+		},
+	}
+
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.maxOutputTokens = 3
+	d.countTokens = wordCount
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	// 被截断的分片中已有的警告保留，强制终止的提示追加在后面
+	responses := assertFinishReasonConformance(t, stream)
+	last := responses[len(responses)-1]
+	assert.Equal(t, FinishReasonLength, last.FinishReason)
+	assert.Equal(t, upstream, last.Warning)
+	assert.EqualValues(t, []string{upstream, OutputLimitWarning}, last.Warnings)
+}
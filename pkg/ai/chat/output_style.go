@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OutputStyle 输出内容的格式，部分客户端（短信、语音）无法渲染 Markdown
+type OutputStyle string
+
+const (
+	// OutputStyleMarkdown 使用 Markdown 格式（模型默认的输出格式，不添加提示语）
+	OutputStyleMarkdown OutputStyle = "markdown"
+	// OutputStylePlain 使用纯文本格式
+	OutputStylePlain OutputStyle = "plain"
+)
+
+// Validate 校验输出格式是否合法
+func (s OutputStyle) Validate() error {
+	switch s {
+	case "", OutputStyleMarkdown, OutputStylePlain:
+		return nil
+	}
+
+	return fmt.Errorf("invalid output style: %s", s)
+}
+
+// outputStylePrompt 输出格式的系统提示语，放在用户的系统提示语之前，用户明确要求其它格式时以用户为准
+func outputStylePrompt(style OutputStyle) string {
+	if style != OutputStylePlain {
+		return ""
+	}
+
+	return "Reply in plain text without any Markdown formatting (no headings, bold, list markers, tables or code fences), unless the user explicitly asks for a specific format."
+}
+
+var (
+	markdownHeading   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`)
+	markdownQuote     = regexp.MustCompile(`^\s{0,3}>\s?`)
+	markdownBullet    = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	markdownRule      = regexp.MustCompile(`^\s{0,3}([-*_]\s*){3,}$`)
+	markdownTableSep  = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+	markdownImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	markdownBold      = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	markdownItalic    = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*]*?\S)?)\*`)
+	markdownCode      = regexp.MustCompile("`([^`]+)`")
+	markdownTableCell = regexp.MustCompile(`\s*\|\s*`)
+)
+
+// stripMarkdown 去掉文本中残留的 Markdown 标记，保留文字内容：
+// 标题、引用、分隔线、代码块围栏和表格分隔行被去掉，粗体、斜体和行内代码只保留文字，
+// 链接转换为 "文字 (地址)"，图片只保留描述，* 和 + 开头的列表统一为 "- "，代码块和公式中的内容保持不变
+func stripMarkdown(text string) string {
+	return transformMarkdown(text, markdownStripper{})
+}
+
+// markdownStripper 按行去掉代码块之外的 Markdown 标记，去掉代码块的围栏行
+type markdownStripper struct{}
+
+func (markdownStripper) fence(string) (string, bool) {
+	return "", false
+}
+
+func (markdownStripper) span(s textSpan) string {
+	return s.String()
+}
+
+func (markdownStripper) line(masked string) string {
+	return stripMarkdownLine(masked)
+}
+
+// stripMarkdownLine 去掉一行文本中的 Markdown 标记，分隔线和表格分隔行返回空字符串
+func stripMarkdownLine(line string) string {
+	if markdownTableSep.MatchString(line) && strings.Contains(line, "-") && strings.Contains(line, "|") {
+		return ""
+	}
+
+	if markdownRule.MatchString(line) {
+		return ""
+	}
+
+	line = markdownHeading.ReplaceAllString(line, "")
+	line = markdownQuote.ReplaceAllString(line, "")
+	line = markdownBullet.ReplaceAllString(line, "$1- ")
+
+	if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "|") && strings.HasSuffix(trimmed, "|") {
+		line = strings.Join(markdownTableCell.Split(strings.Trim(trimmed, "|"), -1), "  ")
+		line = strings.TrimSpace(line)
+	}
+
+	line = markdownImage.ReplaceAllString(line, "$1")
+	line = markdownLink.ReplaceAllString(line, "$1 ($2)")
+	line = markdownCode.ReplaceAllString(line, "$1")
+	line = markdownBold.ReplaceAllString(line, "$2")
+	line = markdownItalic.ReplaceAllString(line, "$1$2")
+
+	return line
+}
+
+// stripMarkdownStream 去掉流式响应中残留的 Markdown 标记
+//
+// Markdown 标记可能被拆分到多个分片中，每行完整之后再处理并输出（参考 transformMarkdownStream）
+func stripMarkdownStream(ctx context.Context, stream <-chan Response) <-chan Response {
+	return transformMarkdownStream(ctx, stream, markdownStripper{})
+}
@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+const markdownSample = "# 标题\n\n这是**重点**和*强调*，参考 [文档](https://example.com)，使用 `go test` 运行。\n\n* 第一项\n+ 第二项\n- 第三项\n\n> 引用内容\n\n---\n\n| 名称 | 数量 |\n| --- | ---: |\n| 苹果 | 3 |\n\n```go\nfunc main() { a := x * y * z }\n```\n\n![图片](https://example.com/a.png) snake_case_name"
+
+const plainSample = "标题\n\n这是重点和强调，参考 文档 (https://example.com)，使用 go test 运行。\n\n- 第一项\n- 第二项\n- 第三项\n\n引用内容\n\n\n\n名称  数量\n\n苹果  3\n\nfunc main() { a := x * y * z }\n\n图片 snake_case_name"
+
+func TestStripMarkdown(t *testing.T) {
+	assert.Equal(t, plainSample, stripMarkdown(markdownSample))
+	// 没有 Markdown 标记的内容保持不变
+	assert.Equal(t, "1 * 2 = 2, a_b_c", stripMarkdown("1 * 2 = 2, a_b_c"))
+}
+
+func TestStripMarkdownStream(t *testing.T) {
+	// 按照任意位置拆分为多个分片，Markdown 标记可能被拆开
+	var chunks []Response
+	runes := []rune(markdownSample)
+	for i := 0; i < len(runes); i += 3 {
+		chunks = append(chunks, Response{Text: string(runes[i:min(i+3, len(runes))])})
+	}
+	chunks = append(chunks, Response{FinishReason: FinishReasonStop})
+
+	client := &streamChatClient{chunks: chunks}
+	d := NewDispatcher(fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", OutputStyle: OutputStylePlain, StripMarkdown: true, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	var text string
+	for _, res := range responses {
+		text += res.Text
+	}
+	assert.Equal(t, plainSample, text)
+}
+
+func TestDispatcher_OutputStyle(t *testing.T) {
+	client := &streamChatClient{}
+	d := NewDispatcher(fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	// 纯文本的提示语放在用户的系统提示语之前，用户明确要求的格式优先
+	_, err := d.Chat(context.TODO(), Request{
+		Model:       "gpt-4",
+		OutputStyle: OutputStylePlain,
+		Messages:    Messages{{Role: RoleSystem, Content: "请使用 Markdown 表格输出"}, {Role: RoleUser, Content: "hello"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Messages{
+		{Role: RoleSystem, Content: outputStylePrompt(OutputStylePlain) + systemPromptSeparator + "请使用 Markdown 表格输出"},
+		{Role: RoleUser, Content: "hello"},
+	}, client.requests[0].Messages)
+
+	// markdown 是默认的输出格式，不添加提示语
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4", OutputStyle: OutputStyleMarkdown, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(client.requests[1].Messages))
+
+	assert.True(t, Request{OutputStyle: "html"}.Validate(0, RoleContentLimits{}) != nil)
+}
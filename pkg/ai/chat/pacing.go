@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PaceStream 按照固定的速率（tokensPerSecond）输出流式响应中的文本，使客户端的输出更加平滑
+//
+// 服务提供商的输出通常是突发式的，这里会缓存已经生成的内容，按照固定的间隔逐个 Token 输出，不会输出尚未生成的内容。
+// 流结束（包括出现错误）时，立即输出所有缓存的内容；请求取消时，立即停止输出。tokensPerSecond 小于等于 0 时不做处理。
+// 这里的 Token 只是近似值：连续的 ASCII 字符（单词及其后的空白）或者单个非 ASCII 字符
+func PaceStream(ctx context.Context, stream <-chan Response, tokensPerSecond int) <-chan Response {
+	if tokensPerSecond <= 0 {
+		return stream
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		ticker := time.NewTicker(time.Second / time.Duration(tokensPerSecond))
+		defer ticker.Stop()
+
+		// pending 待输出的响应，文本响应已经按照 Token 拆分，非文本响应（结束原因、工具调用等）保持原样
+		var pending []Response
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case data, ok := <-stream:
+				if !ok {
+					flushPending(pending, send)
+					return
+				}
+
+				pending = append(pending, splitPacingResponse(data)...)
+				if data.ErrorCode != "" {
+					flushPending(pending, send)
+					return
+				}
+			case <-ticker.C:
+				// 每次输出一个 Token，排在 Token 之前的非文本响应同时输出
+				for len(pending) > 0 {
+					item := pending[0]
+					pending = pending[1:]
+					if !send(item) {
+						return
+					}
+
+					if item.Text != "" {
+						break
+					}
+				}
+			}
+		}
+	})
+}
+
+// flushPending 立即输出所有缓存的响应，连续的文本合并为一个响应
+//
+// 只有不包含其它字段的文本响应（参考 isPlainTextResponse）才会合并，非第一个候选回复（ChoiceIndex 不为 0）的响应同样不合并
+func flushPending(pending []Response, send func(Response) bool) {
+	var text strings.Builder
+	for _, item := range pending {
+		if item.Text != "" && isPlainTextResponse(item) {
+			text.WriteString(item.Text)
+			continue
+		}
+
+		if text.Len() > 0 {
+			if !send(Response{Text: text.String()}) {
+				return
+			}
+			text.Reset()
+		}
+
+		if !send(item) {
+			return
+		}
+	}
+
+	if text.Len() > 0 {
+		send(Response{Text: text.String()})
+	}
+}
+
+// splitPacingResponse 将响应中的文本按照 Token 拆分为多个响应（保留候选回复的序号），文本之外的字段保留在最后一个响应中
+func splitPacingResponse(data Response) []Response {
+	if data.Text == "" || data.ErrorCode != "" {
+		return []Response{data}
+	}
+
+	tokens := splitPacingTokens(data.Text)
+	ret := make([]Response, 0, len(tokens)+1)
+	for _, token := range tokens {
+		ret = append(ret, Response{Text: token, ChoiceIndex: data.ChoiceIndex})
+	}
+
+	// 拆分后的文本响应已经包含候选回复的序号，不需要额外的响应
+	meta := data
+	meta.ChoiceIndex = 0
+	if !isPlainTextResponse(meta) {
+		data.Text = ""
+		ret = append(ret, data)
+	}
+
+	return ret
+}
+
+// splitPacingTokens 将文本拆分为近似的 Token：连续的 ASCII 非空白字符及其后的空白，或者单个非 ASCII 字符
+func splitPacingTokens(text string) []string {
+	tokens := make([]string, 0, len(text)/4+1)
+	start := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r >= utf8.RuneSelf {
+			if start < i {
+				tokens = append(tokens, text[start:i])
+			}
+
+			tokens = append(tokens, text[i:i+size])
+			i += size
+			start = i
+			continue
+		}
+
+		// 单词结束：当前为空白字符，下一个字符不是空白字符
+		i += size
+		if unicode.IsSpace(r) && i < len(text) {
+			if next, _ := utf8.DecodeRuneInString(text[i:]); !unicode.IsSpace(next) {
+				tokens = append(tokens, text[start:i])
+				start = i
+			}
+		}
+	}
+
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+
+	return tokens
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestSplitPacingTokens(t *testing.T) {
+	assert.Equal(t, []string{"hello ", "world"}, splitPacingTokens("hello world"))
+	assert.Equal(t, []string{"你", "好", "，", "AI ", "世", "界"}, splitPacingTokens("你好，AI 世界"))
+	assert.Equal(t, []string{" \n", "a  ", "b\n"}, splitPacingTokens(" \na  b\n"))
+	assert.Equal(t, strings.Repeat("x", 10), strings.Join(splitPacingTokens(strings.Repeat("x", 10)), ""))
+}
+
+func TestPaceStream(t *testing.T) {
+	text := strings.Repeat("你好世界", 10)
+
+	stream := make(chan Response)
+	go func() {
+		defer close(stream)
+
+		// 一次性输出所有内容，然后保持一段时间后结束
+		stream <- Response{Text: text}
+		stream <- Response{FinishReason: FinishReasonStop}
+		time.Sleep(300 * time.Millisecond)
+	}()
+
+	var released int
+	var content string
+	var finishReason string
+	timeout := time.After(200 * time.Millisecond)
+	paced := PaceStream(context.TODO(), stream, 50)
+
+	for res := range paced {
+		content += res.Text
+		if res.FinishReason != "" {
+			finishReason = res.FinishReason
+		}
+
+		select {
+		case <-timeout:
+			timeout = nil
+			released = len([]rune(content))
+		default:
+		}
+	}
+
+	// 内容不变，结束时立即输出剩余的内容
+	assert.Equal(t, text, content)
+	assert.Equal(t, FinishReasonStop, finishReason)
+
+	// 200ms 内按照 50 Tokens/秒 输出，大约 10 个 Token
+	if released < 5 || released > 15 {
+		t.Fatalf("expect about 10 tokens released in 200ms, got %d", released)
+	}
+}
+
+func TestPaceStream_Parts(t *testing.T) {
+	stream := make(chan Response, 1)
+	stream <- Response{Text: "图片如下", Parts: []*MultipartContent{{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/1.png"}}}}
+	close(stream)
+
+	var content string
+	var parts []*MultipartContent
+	for res := range PaceStream(context.TODO(), stream, 1000) {
+		content += res.Text
+		parts = append(parts, res.Parts...)
+	}
+
+	// 文本拆分输出，多模态内容保留
+	assert.Equal(t, "图片如下", content)
+	assert.Equal(t, 1, len(parts))
+}
+
+func TestPaceStream_Meta(t *testing.T) {
+	stream := make(chan Response, 3)
+	stream <- Response{Text: "你好", Warning: "warn", Warnings: []string{"warn"}}
+	stream <- Response{Text: "世界", Refusal: "拒绝", Citations: []Citation{{URL: "https://example.com"}}}
+	stream <- Response{Text: "！", SystemFingerprint: "fp"}
+	close(stream)
+
+	var content string
+	var merged Response
+	for res := range PaceStream(context.TODO(), stream, 1000) {
+		content += res.Text
+		merged.Warnings = append(merged.Warnings, res.Warnings...)
+		merged.Refusal += res.Refusal
+		merged.Citations = append(merged.Citations, res.Citations...)
+		merged.SystemFingerprint += res.SystemFingerprint
+	}
+
+	// 文本拆分输出，与文本在同一个响应中的其它字段都保留
+	assert.Equal(t, "你好世界！", content)
+	assert.Equal(t, []string{"warn"}, merged.Warnings)
+	assert.Equal(t, "拒绝", merged.Refusal)
+	assert.Equal(t, 1, len(merged.Citations))
+	assert.Equal(t, "fp", merged.SystemFingerprint)
+}
+
+func TestPaceStream_Choices(t *testing.T) {
+	stream := make(chan Response, 4)
+	stream <- Response{Text: "hello world"}
+	stream <- Response{Text: "你好世界", ChoiceIndex: 1}
+	stream <- Response{Text: "!", FinishReason: FinishReasonStop}
+	stream <- Response{FinishReason: FinishReasonStop, ChoiceIndex: 1}
+	close(stream)
+
+	// 流结束时立即输出缓存的内容，不同候选回复的文本不会合并
+	contents := map[int]string{}
+	var finished []int
+	for res := range PaceStream(context.TODO(), stream, 1) {
+		contents[res.ChoiceIndex] += res.Text
+		if res.FinishReason != "" {
+			finished = append(finished, res.ChoiceIndex)
+		}
+	}
+
+	assert.Equal(t, "hello world!", contents[0])
+	assert.Equal(t, "你好世界", contents[1])
+	assert.Equal(t, []int{0, 1}, finished)
+}
+
+func TestPaceStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	stream := make(chan Response, 1)
+	stream <- Response{Text: strings.Repeat("你好", 100)}
+
+	paced := PaceStream(ctx, stream, 10)
+	<-paced
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range paced {
+		}
+		close(done)
+	}()
+
+	close(stream)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("paced stream is not closed after cancel")
+	}
+}
+
+func TestPaceStream_Disabled(t *testing.T) {
+	stream := make(chan Response)
+	assert.True(t, (<-chan Response)(stream) == PaceStream(context.TODO(), stream, 0))
+}
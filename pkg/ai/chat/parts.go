@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// liftAssistantImages 将助手消息中的图片（参考 Response.Parts）转换为服务提供商支持的格式
+//
+// 服务提供商只支持在用户消息中发送图片，这里将助手消息中的图片按照顺序编号（每条消息从 1 开始），助手消息只保留文本，
+// 并在末尾追加图片的编号和说明，便于用户在后续的问题中引用（如“把第二张图片调暗一些”）：
+//
+//   - vision 为 true 时（模型支持图片），图片作为 image_url 合并到下一条用户消息的开头
+//   - vision 为 false 时，只在助手消息中保留图片的地址
+//
+// 最后一条用户消息之后的助手消息中的图片没有可以合并的用户消息，autoContinue 为 true 时与 Messages.Fix 一样补充一条“继续”，
+// 为 false 时（请求指定了 NoAutoContinue）不补充，这些助手消息按照 vision 为 false 处理
+func liftAssistantImages(messages Messages, vision bool, autoContinue bool) Messages {
+	lastUser := -1
+	for i, msg := range messages {
+		if msg.Role == RoleUser {
+			lastUser = i
+		}
+	}
+
+	ret := make(Messages, 0, len(messages))
+	// pending 等待合并到下一条用户消息的图片
+	var pending []*MultipartContent
+	for i, msg := range messages {
+		if msg.Role == RoleUser && len(pending) > 0 {
+			msg = mergeUserMessage(Message{Role: RoleUser, MultipartContents: pending}, msg)
+			pending = nil
+		}
+
+		if msg.Role != RoleAssistant || len(msg.MultipartContents) == 0 {
+			ret = append(ret, msg)
+			continue
+		}
+
+		texts := make([]string, 0, len(msg.MultipartContents)+1)
+		if text := strings.TrimSpace(msg.Content); text != "" {
+			texts = append(texts, text)
+		}
+
+		var refs []string
+		for _, part := range msg.MultipartContents {
+			if part == nil || part.IsEmpty() {
+				continue
+			}
+
+			if part.ImageURL == nil || strings.TrimSpace(part.ImageURL.URL) == "" {
+				// 与 Message.Text 一致，Content 不为空时忽略多模态内容中的文本
+				if strings.TrimSpace(msg.Content) == "" {
+					texts = append(texts, part.Text)
+				}
+				continue
+			}
+
+			label := fmt.Sprintf("图片 %d", len(refs)+1)
+			if caption := strings.TrimSpace(part.ImageURL.Caption); caption != "" {
+				label += "：" + caption
+			}
+
+			if !vision || (!autoContinue && i > lastUser) {
+				refs = append(refs, fmt.Sprintf("[%s](%s)", label, part.ImageURL.URL))
+				continue
+			}
+
+			refs = append(refs, "["+label+"]")
+			pending = append(pending,
+				&MultipartContent{Type: "text", Text: "上一条回复中的" + label},
+				&MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: part.ImageURL.URL, Detail: part.ImageURL.Detail}},
+			)
+		}
+
+		if len(refs) > 0 {
+			texts = append(texts, strings.Join(refs, "\n"))
+		}
+
+		ret = append(ret, Message{Role: RoleAssistant, Content: strings.Join(texts, "\n\n")})
+	}
+
+	// 最后一条消息是助手消息时，与 Messages.Fix 一样补充一条用户消息
+	if len(pending) > 0 {
+		ret = append(ret, mergeUserMessage(Message{Role: RoleUser, MultipartContents: pending}, Message{Role: RoleUser, Content: "继续"}))
+	}
+
+	return ret
+}
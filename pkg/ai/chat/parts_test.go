@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// assistantImageMessages 助手回复了两张生成的图片，用户针对第二张图片继续提问
+func assistantImageMessages() Messages {
+	return Messages{
+		{Role: RoleUser, Content: "画两只猫"},
+		{
+			Role:    RoleAssistant,
+			Content: "已经为你生成了两张图片",
+			MultipartContents: []*MultipartContent{
+				{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/1.png", Caption: "橘猫"}},
+				{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/2.png", Caption: "黑猫"}},
+			},
+		},
+		{Role: RoleUser, Content: "把第二张图片调暗一些"},
+	}
+}
+
+func TestLiftAssistantImages(t *testing.T) {
+	messages := liftAssistantImages(assistantImageMessages(), true, true)
+	assert.Equal(t, 3, len(messages))
+
+	assert.Equal(t, RoleAssistant, messages[1].Role)
+	assert.Equal(t, "已经为你生成了两张图片\n\n[图片 1：橘猫]\n[图片 2：黑猫]", messages[1].Content)
+	assert.Equal(t, 0, len(messages[1].MultipartContents))
+
+	// 图片合并到下一条用户消息的开头，用户的问题在最后
+	question := messages[2]
+	assert.Equal(t, RoleUser, question.Role)
+	assert.Equal(t, 5, len(question.MultipartContents))
+	assert.Equal(t, "上一条回复中的图片 2：黑猫", question.MultipartContents[2].Text)
+	assert.Equal(t, "https://example.com/2.png", question.MultipartContents[3].ImageURL.URL)
+	assert.Equal(t, "", question.MultipartContents[3].ImageURL.Caption)
+	assert.Equal(t, "把第二张图片调暗一些", question.MultipartContents[4].Text)
+	assert.True(t, Messages(messages).HasImage())
+
+	// 不支持图片的模型，只在助手消息中保留图片地址
+	messages = liftAssistantImages(assistantImageMessages(), false, true)
+	assert.Equal(t, "已经为你生成了两张图片\n\n[图片 1：橘猫](https://example.com/1.png)\n[图片 2：黑猫](https://example.com/2.png)", messages[1].Content)
+	assert.Equal(t, "把第二张图片调暗一些", messages[2].Content)
+	assert.False(t, Messages(messages).HasImage())
+
+	// 最后一条消息是助手消息时，补充一条用户消息
+	messages = liftAssistantImages(assistantImageMessages()[:2], true, true)
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, RoleUser, messages[2].Role)
+	assert.Equal(t, "继续", messages[2].MultipartContents[4].Text)
+
+	// 不自动补充“继续”时，以助手消息结尾，图片只保留地址
+	messages = liftAssistantImages(assistantImageMessages()[:2], true, false)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, RoleAssistant, messages[1].Role)
+	assert.Equal(t, "已经为你生成了两张图片\n\n[图片 1：橘猫](https://example.com/1.png)\n[图片 2：黑猫](https://example.com/2.png)", messages[1].Content)
+	assert.False(t, Messages(messages).HasImage())
+
+	// 之后还有用户消息时，与自动补充时相同
+	messages = liftAssistantImages(assistantImageMessages(), true, false)
+	assert.Equal(t, 5, len(messages[2].MultipartContents))
+}
+
+func TestResponseParts_RoundTrip(t *testing.T) {
+	// 回复中的多模态内容与请求中助手消息的 multipart_content 使用相同的格式
+	data, err := json.Marshal(Response{Text: "好的", Parts: []*MultipartContent{
+		{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/1.png", Caption: "橘猫"}},
+	}})
+	assert.NoError(t, err)
+
+	var res struct {
+		Parts []*MultipartContent `json:"parts"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &res))
+
+	var msg Message
+	assert.NoError(t, json.Unmarshal([]byte(`{"role": "assistant", "content": "好的", "multipart_content": `+mustJSON(t, res.Parts)+`}`), &msg))
+	assert.Equal(t, "橘猫", msg.MultipartContents[0].ImageURL.Caption)
+	assert.Equal(t, "https://example.com/1.png", msg.MultipartContents[0].ImageURL.URL)
+}
+
+func TestDispatcher_ChatStreamAssistantImages(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "好的", Parts: []*MultipartContent{
+		{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/3.png", Caption: "调暗的黑猫"}},
+	}}}}
+	router := fakeModelRouter{
+		"gpt-4o": {
+			Models:    model.Models{ModelId: "gpt-4o"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderOpenAI}},
+			Meta:      repo.ModelMeta{Vision: true},
+		},
+	}
+
+	d := NewDispatcher(router, &fakeClientFactory{client: client}, "", PayloadPolicyReject)
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4o", Messages: assistantImageMessages()})
+	assert.NoError(t, err)
+
+	var parts []*MultipartContent
+	for res := range stream {
+		parts = append(parts, res.Parts...)
+	}
+
+	// 回复中的多模态内容原样返回
+	assert.Equal(t, 1, len(parts))
+	assert.Equal(t, "调暗的黑猫", parts[0].ImageURL.Caption)
+
+	// 服务提供商收到的请求中，助手消息只包含文本，图片在用户消息中
+	req := client.requests[0]
+	assert.Equal(t, 0, len(req.Messages[1].MultipartContents))
+	assert.True(t, req.Messages.HasImage())
+}
+
+func mustJSON(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+
+	return string(data)
+}
@@ -0,0 +1,196 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"sort"
+	"strings"
+
+	// 注册图片解码器
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/asteria/log"
+	"golang.org/x/image/draw"
+)
+
+// PayloadPolicy 请求内容大小超过服务提供商限制时的处理策略
+type PayloadPolicy string
+
+const (
+	// PayloadPolicyReject 直接拒绝请求
+	PayloadPolicyReject PayloadPolicy = "reject"
+	// PayloadPolicyDownscale 缩小请求中的图片（仅限 base64 编码的图片），仍然超过限制时拒绝请求
+	PayloadPolicyDownscale PayloadPolicy = "downscale"
+)
+
+const (
+	// remoteImageEstimatedSize 远程图片（URL）的预估大小，部分服务提供商会下载后以 base64 编码发送
+	remoteImageEstimatedSize = 1024 * 1024
+	// maxDownscaleTimes 单张图片最多缩小的次数，每次长宽各缩小一半
+	maxDownscaleTimes = 3
+	// downscaleJPEGQuality 缩小后的图片使用 JPEG 编码的质量
+	downscaleJPEGQuality = 80
+)
+
+// ErrPayloadTooLarge 请求内容大小超过服务提供商的限制，可以使用 errors.Is 判断 PayloadTooLargeError
+var ErrPayloadTooLarge = errors.New("请求内容过大")
+
+// PayloadTooLargeError 请求内容大小超过服务提供商的限制
+type PayloadTooLargeError struct {
+	Provider string `json:"provider"`
+	// Limit 服务提供商允许的最大内容大小（字节）
+	Limit int64 `json:"limit"`
+	// Size 请求内容的预估大小（字节）
+	Size int64 `json:"size"`
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("请求内容过大（%.1f MB），超过了服务提供商允许的最大限制（%.1f MB），请减少图片数量或缩小图片后重试", float64(e.Size)/1024/1024, float64(e.Limit)/1024/1024)
+}
+
+// Is 兼容 errors.Is(err, ErrPayloadTooLarge)
+func (e *PayloadTooLargeError) Is(target error) bool {
+	return target == ErrPayloadTooLarge
+}
+
+// ErrorData 返回给客户端的结构化错误详情
+func (e *PayloadTooLargeError) ErrorData() any {
+	return e
+}
+
+// estimatePayloadSize 预估消息内容的大小（字节）
+func estimatePayloadSize(messages Messages) int64 {
+	var size int64
+	for _, msg := range messages {
+		size += int64(len(msg.Content))
+		for _, part := range msg.MultipartContents {
+			if part == nil {
+				continue
+			}
+
+			size += int64(len(part.Text))
+			if part.ImageURL != nil {
+				size += imageURLSize(part.ImageURL.URL)
+			}
+		}
+	}
+
+	return size
+}
+
+func imageURLSize(url string) int64 {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return remoteImageEstimatedSize
+	}
+
+	return int64(len(url))
+}
+
+// limitPayload 检查消息内容大小是否超过 limit，超过时根据 policy 缩小图片或者返回 PayloadTooLargeError
+func limitPayload(messages Messages, provider string, limit int64, policy PayloadPolicy) (Messages, error) {
+	size := estimatePayloadSize(messages)
+	if size <= limit {
+		return messages, nil
+	}
+
+	if policy == PayloadPolicyDownscale {
+		messages, size = downscalePayload(messages, limit)
+		if size <= limit {
+			return messages, nil
+		}
+	}
+
+	return nil, &PayloadTooLargeError{Provider: provider, Limit: limit, Size: size}
+}
+
+// downscalePayload 从最大的图片开始依次缩小，直到内容大小不超过 limit，返回缩小后的消息和内容大小
+// 为了避免修改调用方的消息，这里会复制包含图片的消息
+func downscalePayload(messages Messages, limit int64) (Messages, int64) {
+	ret := make(Messages, len(messages))
+	images := make([]*MultipartContent, 0)
+	for i, msg := range messages {
+		if len(msg.MultipartContents) > 0 {
+			parts := make([]*MultipartContent, len(msg.MultipartContents))
+			for j, part := range msg.MultipartContents {
+				if part != nil && part.ImageURL != nil && strings.HasPrefix(part.ImageURL.URL, "data:") {
+					imageURL := *part.ImageURL
+					part = &MultipartContent{Type: part.Type, Text: part.Text, ImageURL: &imageURL}
+					images = append(images, part)
+				}
+
+				parts[j] = part
+			}
+
+			msg.MultipartContents = parts
+		}
+
+		ret[i] = msg
+	}
+
+	sort.SliceStable(images, func(i, j int) bool { return len(images[i].ImageURL.URL) > len(images[j].ImageURL.URL) })
+
+	size := estimatePayloadSize(ret)
+	for _, part := range images {
+		if size <= limit {
+			break
+		}
+
+		original := int64(len(part.ImageURL.URL))
+		downscaled, err := downscaleImage(part.ImageURL.URL, original-(size-limit))
+		if err != nil {
+			log.F(log.M{"size": original}).Warningf("downscale image failed: %v", err)
+			continue
+		}
+
+		part.ImageURL.URL = downscaled
+		size = size - original + int64(len(downscaled))
+	}
+
+	return ret, size
+}
+
+// downscaleImage 缩小 base64 编码的图片，直到大小不超过 target 或者达到最大缩小次数
+func downscaleImage(encoded string, target int64) (string, error) {
+	data, _, err := misc.DecodeBase64ImageWithMime(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	ret := encoded
+	for i := 0; i < maxDownscaleTimes; i++ {
+		bounds := img.Bounds()
+		if bounds.Dx() < 2 || bounds.Dy() < 2 {
+			break
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()/2, bounds.Dy()/2))
+		draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+		img = dst
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: downscaleJPEGQuality}); err != nil {
+			return "", err
+		}
+
+		if encodedJPEG := misc.AddImageBase64Prefix(base64.StdEncoding.EncodeToString(buf.Bytes()), "image/jpeg"); len(encodedJPEG) < len(ret) {
+			ret = encodedJPEG
+		}
+
+		if int64(len(ret)) <= target {
+			break
+		}
+	}
+
+	return ret, nil
+}
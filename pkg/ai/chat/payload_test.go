@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// noiseImage 生成随机噪点的 PNG 图片，噪点图片压缩率很低，方便构造较大的请求内容
+func noiseImage(t *testing.T, width, height int) string {
+	r := rand.New(rand.NewSource(int64(width * height)))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+
+	return misc.AddImageBase64Prefix(base64.StdEncoding.EncodeToString(buf.Bytes()), "image/png")
+}
+
+func multiImageMessages(images ...string) Messages {
+	parts := []*MultipartContent{{Type: "text", Text: "这些图片有什么区别？"}}
+	for _, img := range images {
+		parts = append(parts, &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: img}})
+	}
+
+	return Messages{{Role: RoleUser, MultipartContents: parts}}
+}
+
+func TestEstimatePayloadSize(t *testing.T) {
+	messages := Messages{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, MultipartContents: []*MultipartContent{
+			{Type: "text", Text: "hello"},
+			{Type: "image_url", ImageURL: &ImageURL{URL: "data:image/png;base64,AAAA"}},
+			{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/a.png"}},
+			nil,
+		}},
+	}
+
+	assert.EqualValues(t, len("system")+len("hello")+len("data:image/png;base64,AAAA")+remoteImageEstimatedSize, estimatePayloadSize(messages))
+}
+
+func TestLimitPayload(t *testing.T) {
+	messages := multiImageMessages(noiseImage(t, 256, 256), noiseImage(t, 200, 200), noiseImage(t, 128, 128))
+	size := estimatePayloadSize(messages)
+	limit := size / 2
+
+	// 未超过限制时，原样返回
+	ret, err := limitPayload(messages, "openai", size, PayloadPolicyReject)
+	assert.NoError(t, err)
+	assert.EqualValues(t, size, estimatePayloadSize(ret))
+
+	// 超过限制，直接拒绝
+	_, err = limitPayload(messages, "openai", limit, PayloadPolicyReject)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge))
+
+	var tooLarge *PayloadTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.EqualValues(t, size, tooLarge.Size)
+	assert.EqualValues(t, limit, tooLarge.Limit)
+
+	// 超过限制，缩小图片
+	ret, err = limitPayload(messages, "openai", limit, PayloadPolicyDownscale)
+	assert.NoError(t, err)
+	assert.True(t, estimatePayloadSize(ret) <= limit)
+	assert.Equal(t, 4, len(ret[0].MultipartContents))
+	assert.Equal(t, "这些图片有什么区别？", ret[0].MultipartContents[0].Text)
+
+	// 不修改原始请求
+	assert.EqualValues(t, size, estimatePayloadSize(messages))
+
+	// 缩小后仍然超过限制时，拒绝请求
+	_, err = limitPayload(messages, "openai", 1024, PayloadPolicyDownscale)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge))
+}
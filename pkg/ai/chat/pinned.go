@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PinnedContextExceedError 固定的消息（加上 system 消息以及为输出内容预留的空间）已经超过模型的上下文长度，
+// 无法通过缩减上下文解决，需要用户取消固定部分消息
+type PinnedContextExceedError struct {
+	// MaxContext 模型的最大上下文长度
+	MaxContext int `json:"max_context"`
+	// PinnedTokens 固定的消息的 Token 数量
+	PinnedTokens int `json:"pinned_tokens"`
+	// Overflow 超出的 Token 数量
+	Overflow int `json:"overflow"`
+}
+
+func (e *PinnedContextExceedError) Error() string {
+	return fmt.Sprintf("固定的消息超过模型最大允许的上下文长度限制（固定的消息 %d Tokens，超出 %d Tokens），请取消固定部分消息后再试", e.PinnedTokens, e.Overflow)
+}
+
+// Is 兼容 errors.Is(err, ErrContextExceedLimit)
+func (e *PinnedContextExceedError) Is(target error) bool {
+	return target == ErrContextExceedLimit
+}
+
+// ErrorData 返回给客户端的结构化错误详情
+func (e *PinnedContextExceedError) ErrorData() any {
+	return e
+}
+
+const (
+	// pinnedPrompt 固定的消息合并为一条用户消息时的开头
+	pinnedPrompt = "以下是本次对话中固定的消息，请在之后的对话中始终参考："
+	// pinnedAck 固定的消息之后追加的 assistant 确认消息，保持 user/assistant 交替
+	pinnedAck = "好的，我会在之后的对话中参考这些消息。"
+)
+
+// WithPinned 将房间中固定的消息加入对话上下文（位于开头的 system 消息之后），固定的消息在 Request.Fix 中与 system 消息一样始终保留。
+// 客户端发送的上下文中与固定的消息内容相同的消息（不包括最后一条消息）会被去掉，避免重复
+//
+// 固定的消息合并为一条用户消息（每条消息以发送方开头）并追加一条 assistant 确认消息，作为独立的一轮对话，
+// 避免在 Messages.Fix 中与相邻的同一方消息合并时被丢弃
+func (ms Messages) WithPinned(pinned Messages) Messages {
+	if len(pinned) == 0 || len(ms) == 0 {
+		return ms
+	}
+
+	pinnedKeys := make(map[string]bool, len(pinned))
+	lines := make([]string, 0, len(pinned)+1)
+	lines = append(lines, pinnedPrompt)
+	for _, msg := range pinned {
+		pinnedKeys[pinnedMessageKey(msg)] = true
+		lines = append(lines, fmt.Sprintf("[%s] %s", pinnedRoleName(msg.Role), strings.TrimSpace(msg.Text())))
+	}
+
+	var systems int
+	for systems < len(ms) && ms[systems].Role == RoleSystem {
+		systems++
+	}
+
+	ret := make(Messages, 0, len(ms)+2)
+	ret = append(ret, ms[:systems]...)
+	ret = append(ret,
+		Message{Role: RoleUser, Content: strings.Join(lines, "\n"), Pinned: true},
+		Message{Role: RoleAssistant, Content: pinnedAck, Pinned: true},
+	)
+
+	for i, msg := range ms[systems:] {
+		if systems+i < len(ms)-1 && pinnedKeys[pinnedMessageKey(msg)] {
+			continue
+		}
+
+		ret = append(ret, msg)
+	}
+
+	return ret
+}
+
+func pinnedRoleName(role Role) string {
+	if role == RoleAssistant {
+		return "助手"
+	}
+
+	return "用户"
+}
+
+func pinnedMessageKey(msg Message) string {
+	return string(msg.Role) + ":" + strings.TrimSpace(msg.Text())
+}
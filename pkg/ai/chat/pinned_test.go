@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestMessages_WithPinned(t *testing.T) {
+	messages := Messages{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "always answer in JSON"},
+		{Role: RoleAssistant, Content: "ok"},
+		{Role: RoleUser, Content: "always answer in JSON"},
+	}
+
+	// 固定的消息合并为 system 消息之后的一轮对话，上下文中内容相同的消息被去掉，最后一条消息保留
+	ret := messages.WithPinned(Messages{{Role: RoleUser, Content: "always answer in JSON "}, {Role: RoleAssistant, Content: "ok"}})
+	assert.Equal(t, 4, len(ret))
+	assert.Equal(t, RoleSystem, ret[0].Role)
+	assert.True(t, ret[1].Pinned)
+	assert.Equal(t, RoleUser, ret[1].Role)
+	assert.Equal(t, pinnedPrompt+"\n[用户] always answer in JSON\n[助手] ok", ret[1].Content)
+	assert.True(t, ret[2].Pinned)
+	assert.Equal(t, RoleAssistant, ret[2].Role)
+	assert.Equal(t, pinnedAck, ret[2].Content)
+	assert.False(t, ret[3].Pinned)
+	assert.Equal(t, "always answer in JSON", ret[3].Content)
+
+	// 原始的消息列表不受影响
+	assert.False(t, messages[1].Pinned)
+	assert.Equal(t, 4, len(messages.WithPinned(nil)))
+}
+
+func TestDispatcher_Pinned(t *testing.T) {
+	router := fakeModelRouter{"gpt-4o": {Models: model.Models{ModelId: "gpt-4o"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+	client := &streamChatClient{}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	// 固定的消息与相邻的用户消息、助手消息都不会在修正上下文时合并丢弃
+	for _, history := range []Messages{
+		{{Role: RoleUser, Content: "hello"}},
+		{{Role: RoleUser, Content: "question"}, {Role: RoleAssistant, Content: "answer"}, {Role: RoleUser, Content: "hello"}},
+		{{Role: RoleAssistant, Content: "answer"}, {Role: RoleUser, Content: "hello"}},
+	} {
+		client.requests = nil
+		req := Request{Model: "gpt-4o", Messages: history.WithPinned(Messages{{Role: RoleUser, Content: "always answer in JSON"}})}
+
+		_, err := d.Chat(context.TODO(), req)
+		assert.NoError(t, err)
+
+		sent := client.requests[0].Messages
+		assert.Equal(t, RoleUser, sent[0].Role)
+		assert.True(t, strings.Contains(sent[0].Content, "[用户] always answer in JSON"))
+		assert.Equal(t, RoleAssistant, sent[1].Role)
+		assert.Equal(t, "hello", sent[len(sent)-1].Content)
+	}
+}
+
+func TestRequestFix_Pinned(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	history := Messages{{Role: RoleSystem, Content: "system"}}
+	for i := 0; i < 5; i++ {
+		history = append(history, Message{Role: RoleUser, Content: strings.Repeat("question ", 100)}, Message{Role: RoleAssistant, Content: strings.Repeat("answer ", 100)})
+	}
+	history = append(history, Message{Role: RoleUser, Content: "hello"})
+
+	req := Request{Model: "gpt-3.5-turbo", Messages: history.WithPinned(Messages{{Role: RoleUser, Content: strings.Repeat("always answer in JSON ", 60)}})}.Init()
+	pinned := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Pinned })
+
+	unpinned := Request{Model: "gpt-3.5-turbo", Messages: history}.Init()
+	_, expectedTokens, err := unpinned.Fix(ChatTestClient{}, 10, 100000)
+	assert.NoError(t, err)
+
+	pinnedTokens, err := MessageTokenCount(pinned, req.Model)
+	assert.NoError(t, err)
+
+	// 按照消息数量缩减上下文时不包括固定的消息
+	fixed, inputTokens, err := req.Fix(ChatTestClient{}, 1, 100000)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(fixed.Messages))
+	assert.Equal(t, RoleSystem, fixed.Messages[0].Role)
+	assert.True(t, fixed.Messages[1].Pinned)
+	assert.True(t, fixed.Messages[2].Pinned)
+	assert.Equal(t, "hello", fixed.Messages[5].Content)
+
+	// 固定的消息的 Token 数量从可缩减的上下文长度中扣除，返回的输入 Token 数量包含固定的消息
+	fixed, inputTokens, err = req.Fix(ChatTestClient{}, 10, 100000)
+	assert.NoError(t, err)
+	assert.True(t, fixed.Messages[1].Pinned)
+	assert.True(t, inputTokens < expectedTokens+int64(pinnedTokens-replyPrimingTokens))
+	assert.EqualValues(t, inputTokens, fixed.InputTokenBreakdown.Total()-fixed.InputTokenBreakdown.System)
+	assert.True(t, fixed.InputTokenBreakdown.Total() <= ChatTestClient{}.MaxContextLength(req.Model))
+}
+
+func TestRequestFix_PinnedExceed(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	req := Request{
+		Model: "gpt-3.5-turbo",
+		Messages: Messages{
+			{Role: RoleSystem, Content: "system"},
+			{Role: RoleUser, Content: "hello"},
+		}.WithPinned(Messages{{Role: RoleUser, Content: strings.Repeat("hello ", 3000)}}),
+	}
+
+	// 固定的消息本身超过模型的上下文长度时，无法通过缩减上下文解决
+	_, _, err := req.Fix(ChatTestClient{}, 10, 100000)
+	assert.True(t, errors.Is(err, ErrContextExceedLimit))
+
+	var pinnedErr *PinnedContextExceedError
+	assert.True(t, errors.As(err, &pinnedErr))
+	assert.Equal(t, 2048, pinnedErr.MaxContext)
+	assert.True(t, pinnedErr.PinnedTokens > pinnedErr.MaxContext)
+	assert.True(t, pinnedErr.Overflow > 0)
+}
@@ -0,0 +1,245 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// pipelineCases 随机生成的请求数量
+const pipelineCases = 500
+
+var pipelineContents = []string{"", " ", "\n\t", "继续", "hello", "你好，世界", "🙂🙃 emoji", "​", "ÅÉÎ ünïcödé", strings.Repeat("长文本", 50)}
+
+// randomMessage 随机生成一条消息，包含各种角色、空白内容、多模态内容的组合
+func randomMessage(r *rand.Rand) Message {
+	roles := []Role{RoleSystem, RoleUser, RoleUser, RoleAssistant, RoleAssistant}
+	msg := Message{
+		Role:    roles[r.Intn(len(roles))],
+		Content: pipelineContents[r.Intn(len(pipelineContents))],
+	}
+
+	if r.Intn(3) == 0 {
+		msg.Content = ""
+		for i := r.Intn(4); i > 0; i-- {
+			switch r.Intn(4) {
+			case 0:
+				msg.MultipartContents = append(msg.MultipartContents, &MultipartContent{Type: "text", Text: pipelineContents[r.Intn(len(pipelineContents))]})
+			case 1:
+				msg.MultipartContents = append(msg.MultipartContents, &MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/a.png"}})
+			case 2:
+				msg.MultipartContents = append(msg.MultipartContents, &MultipartContent{Type: "image_url"})
+			default:
+				msg.MultipartContents = append(msg.MultipartContents, nil)
+			}
+		}
+	}
+
+	return msg
+}
+
+func randomRequest(r *rand.Rand) Request {
+	req := Request{Model: "gpt-3.5-turbo"}
+	for i := r.Intn(12); i > 0; i-- {
+		req.Messages = append(req.Messages, randomMessage(r))
+	}
+
+	return req
+}
+
+// assertMessagesInvariants 校验发送给服务提供商的消息序列：
+// 非空，system 消息只出现在开头且最多一条，其它消息的角色交替出现，最后一条为用户消息
+func assertMessagesInvariants(t *testing.T, input Messages, msgs Messages) {
+	t.Helper()
+
+	if len(msgs) == 0 {
+		t.Fatalf("empty messages, input: %v", input)
+	}
+
+	systemCount := 0
+	for i, msg := range msgs {
+		if msg.Role != RoleSystem {
+			continue
+		}
+
+		systemCount++
+		if i != 0 {
+			t.Fatalf("system message must be the first message, input: %v, output: %v", input, msgs)
+		}
+	}
+	if systemCount > 1 {
+		t.Fatalf("more than one system message, input: %v, output: %v", input, msgs)
+	}
+
+	chatMessages := msgs[systemCount:]
+	for i := 1; i < len(chatMessages); i++ {
+		if chatMessages[i].Role == chatMessages[i-1].Role {
+			t.Fatalf("roles must alternate, input: %v, output: %v", input, msgs)
+		}
+	}
+
+	if chatMessages[0].Role != RoleUser || chatMessages[len(chatMessages)-1].Role != RoleUser {
+		t.Fatalf("conversation must start and end with user message, input: %v, output: %v", input, msgs)
+	}
+}
+
+// pipelineRouter 请求使用的模型，支持图片，避免包含图片的请求因为没有支持图片的服务提供商而失败
+var pipelineRouter = fakeModelRouter{
+	"gpt-3.5-turbo": {
+		Models:    model.Models{ModelId: "gpt-3.5-turbo"},
+		Providers: []repo.ModelProvider{{ID: 1}},
+		Meta:      repo.ModelMeta{Vision: true},
+	},
+}
+
+// dispatchMessages 通过 Dispatcher 发送请求，返回实际发送给服务提供商的消息
+func dispatchMessages(t *testing.T, req Request) Messages {
+	t.Helper()
+
+	client := &streamChatClient{}
+	d := NewDispatcher(pipelineRouter, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	if _, err := d.Chat(context.TODO(), req); err != nil {
+		t.Fatalf("dispatch failed: %v, input: %v", err, req.Messages)
+	}
+
+	assert.Equal(t, 1, len(client.requests))
+	return client.requests[0].Messages
+}
+
+func TestMessages_FixEmpty(t *testing.T) {
+	assert.Equal(t, 0, len(Messages{}.Fix()))
+	assert.Equal(t, 0, len(Messages(nil).Fix()))
+
+	// 只有 system 消息时，补充一条用户消息
+	msgs := Messages{{Role: RoleSystem, Content: "system"}}.Fix()
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, RoleUser, msgs[1].Role)
+
+	// 只有一条 assistant 消息
+	msgs = Messages{{Role: RoleAssistant, Content: "hello"}}.Fix()
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, RoleUser, msgs[0].Role)
+}
+
+func TestRequestPipeline_Messages(t *testing.T) {
+	r := rand.New(rand.NewSource(20240522))
+	for i := 0; i < pipelineCases; i++ {
+		input := randomRequest(r)
+		req := input.Init()
+		if len(req.Messages) == 0 {
+			continue
+		}
+
+		assertMessagesInvariants(t, input.Messages, dispatchMessages(t, req))
+	}
+}
+
+func TestRequestPipeline_Fix(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	r := rand.New(rand.NewSource(20240522))
+	for i := 0; i < pipelineCases; i++ {
+		input := randomRequest(r)
+		maxTokenCount := 20 + r.Intn(500)
+
+		fixed, inputTokens, err := input.Init().Fix(&ChatTestClient{}, int64(r.Intn(6)), maxTokenCount)
+		if err != nil {
+			if !errors.Is(err, ErrEmptyMessages) && !errors.Is(err, ErrContextExceedLimit) {
+				t.Fatalf("unexpected error: %v, input: %v", err, input.Messages)
+			}
+
+			continue
+		}
+
+		// 缩减后的上下文不超过限制，且 Token 数量不会超过缩减前
+		assert.True(t, inputTokens <= int64(maxTokenCount))
+		if inputTokens > 0 {
+			before, _ := MessageTokenCount(input.Init().Messages, input.Model)
+			assert.True(t, inputTokens <= int64(before))
+		}
+
+		if len(fixed.Messages) > 0 {
+			assertMessagesInvariants(t, input.Messages, dispatchMessages(t, *fixed))
+		}
+	}
+}
+
+func TestMessages_FixNoAlias(t *testing.T) {
+	// 底层数组有剩余容量时，补充的用户消息不能写入调用方的切片
+	backing := make(Messages, 3)
+	backing[2] = Message{Role: RoleAssistant, Content: "untouched"}
+	msgs := backing[:2]
+	msgs[0] = Message{Role: RoleUser, Content: "hello"}
+	msgs[1] = Message{Role: RoleAssistant, Content: "world"}
+
+	fixed := msgs.Fix()
+	assert.Equal(t, 3, len(fixed))
+	assert.Equal(t, "继续", fixed[2].Content)
+	assert.Equal(t, "untouched", backing[2].Content)
+}
+
+// snapshotRequest 序列化请求内容（包括指针指向的内容），用于比较请求是否被修改
+func snapshotRequest(t *testing.T, req Request) string {
+	t.Helper()
+
+	data, err := json.Marshal(req)
+	assert.NoError(t, err)
+
+	return string(data)
+}
+
+func TestRequestPipeline_NoAlias(t *testing.T) {
+	router := fakeModelRouter{
+		"gpt-3.5-turbo": {
+			Models:    model.Models{ModelId: "gpt-3.5-turbo"},
+			Providers: []repo.ModelProvider{{ID: 1, Prompt: "provider"}},
+			Meta:      repo.ModelMeta{Prompt: "model", Vision: true},
+		},
+	}
+
+	r := rand.New(rand.NewSource(20240522))
+	for i := 0; i < pipelineCases; i++ {
+		input := randomRequest(r).Init()
+		if len(input.Messages) == 0 {
+			continue
+		}
+
+		// 保留剩余容量，覆盖 append 写入原始底层数组的情况
+		input.Messages = append(make(Messages, 0, len(input.Messages)+4), input.Messages...)
+		if r.Intn(2) == 0 {
+			input.PersonaPrompt = "persona"
+		}
+		input.OutputStyle = OutputStylePlain
+		input = input.ApplyDefaults(RequestDefaults{ImageDetail: "low", ReplyLanguage: "en"})
+
+		before := snapshotRequest(t, input)
+
+		// 同一个请求执行两次（如故障转移后重试），发送给上游的请求相同，原始请求保持不变
+		client := &streamChatClient{}
+		d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+		for j := 0; j < 2; j++ {
+			_, err := d.Chat(context.TODO(), input)
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, len(client.requests))
+		assert.Equal(t, snapshotRequest(t, client.requests[0]), snapshotRequest(t, client.requests[1]))
+		assert.Equal(t, before, snapshotRequest(t, input))
+		assertMessagesInvariants(t, input.Messages, client.requests[0].Messages)
+
+		fixed, _, err := input.Fix(&ChatTestClient{}, 10, 100000)
+		assert.NoError(t, err)
+		fixedAgain, _, err := input.Fix(&ChatTestClient{}, 10, 100000)
+		assert.NoError(t, err)
+		assert.Equal(t, snapshotRequest(t, *fixed), snapshotRequest(t, *fixedAgain))
+		assert.Equal(t, before, snapshotRequest(t, input))
+	}
+}
@@ -0,0 +1,343 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/aidea-server/pkg/rate"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+)
+
+var (
+	ErrProbeBillingNotConfigured = errors.New("未配置模型探测计费账号（model-probe-user-id）")
+	ErrProbeRateLimited          = errors.New("模型探测过于频繁，请稍后再试")
+)
+
+// probeImage 1x1 像素的 PNG 图片，用于探测模型是否支持图片输入
+const probeImage = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8BQDwAEhQGAhKmMIQAAAABJRU5ErkJggg=="
+
+// 上下文长度的来源
+const (
+	ContextLengthFromOpenRouter = "openrouter"
+	ContextLengthFromAdapter    = "adapter"
+)
+
+// ProbeResult 模型探测结果，用于后台核对模型配置（ModelMeta）与上游实际支持的能力
+type ProbeResult struct {
+	ModelID   string `json:"model_id"`
+	ChannelID int64  `json:"channel_id,omitempty"`
+	// UpstreamModel 实际请求上游时使用的模型名称
+	UpstreamModel string `json:"upstream_model"`
+	// ContextLength 探测到的上下文长度
+	ContextLength int `json:"context_length"`
+	// ContextLengthSource 上下文长度的来源：openrouter（OpenRouter /models 接口）/adapter（本地适配器配置）
+	ContextLengthSource string `json:"context_length_source"`
+	// Exists 上游是否存在该模型，为 nil 时表示无法确认
+	Exists *bool `json:"exists,omitempty"`
+	// Vision 是否支持图片输入
+	Vision bool `json:"vision"`
+	// ReportedVision 上游元数据接口报告的是否支持图片输入，为 nil 时表示无法确认
+	ReportedVision *bool `json:"reported_vision,omitempty"`
+	// Streaming 是否支持流式输出
+	Streaming bool `json:"streaming"`
+	// InputTokens/OutputTokens 本次探测消耗的 Token 数量
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	// Cost 本次探测消耗的智慧果，计入内部账号
+	Cost int64 `json:"cost"`
+	// Errors 探测过程中上游返回的错误
+	Errors []string `json:"errors,omitempty"`
+	// Warnings 探测的配置问题（如渠道的模型允许列表不包含该模型），不影响探测结果
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ModelProber 模型探测，只能在后台手动触发
+type ModelProber struct {
+	router     ModelRouter
+	clients    ClientFactory
+	conf       *config.Config
+	svc        *service.Service
+	limiter    *rate.RateLimiter
+	quotaRepo  *repo.QuotaRepo
+	secrets    *ChannelSecrets
+	httpClient *http.Client
+}
+
+func NewModelProber(conf *config.Config, router ModelRouter, clients ClientFactory, svc *service.Service, limiter *rate.RateLimiter, quotaRepo *repo.QuotaRepo, secrets *ChannelSecrets) *ModelProber {
+	return &ModelProber{
+		router:     router,
+		clients:    clients,
+		conf:       conf,
+		svc:        svc,
+		limiter:    limiter,
+		quotaRepo:  quotaRepo,
+		secrets:    secrets,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// probeProvider 探测使用的服务提供商，channelID 为 0 时使用模型选择的服务提供商 selected；
+// 指定渠道时使用模型配置中该渠道的服务提供商（包括模型重写），模型没有配置该渠道时直接使用该渠道
+func probeProvider(mod repo.Model, selected repo.ModelProvider, channelID int64) repo.ModelProvider {
+	if channelID <= 0 {
+		return selected
+	}
+
+	for _, item := range mod.Providers {
+		if item.ID == channelID {
+			return item
+		}
+	}
+
+	return repo.ModelProvider{ID: channelID}
+}
+
+// Probe 探测模型在指定渠道下的实际能力，channelID 为 0 时使用模型配置的服务提供商
+func (p *ModelProber) Probe(ctx context.Context, modelID string, channelID int64) (*ProbeResult, error) {
+	if p.conf.ModelProbeUserID <= 0 {
+		return nil, ErrProbeBillingNotConfigured
+	}
+
+	limitKey := fmt.Sprintf("model-probe:%s:%d", modelID, channelID)
+	if err := p.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterChannel, Channel: "model-probe"}, limitKey, rate.MaxRequestsInPeriod(5, 10*time.Minute)); err != nil {
+		if errors.Is(err, rate.ErrRateLimitExceeded) {
+			return nil, ErrProbeRateLimited
+		}
+
+		return nil, err
+	}
+
+	mod, err := p.router.Model(ctx, modelID)
+	if err != nil {
+		return nil, err
+	}
+
+	pro := probeProvider(mod, p.router.SelectProvider(ctx, mod), channelID)
+
+	upstreamModel := mod.ModelId
+	if pro.ModelRewrite != "" {
+		upstreamModel = pro.ModelRewrite
+	}
+
+	imp, _ := p.clients.Client(ctx, pro)
+	ret := probeChat(ctx, imp, upstreamModel)
+	ret.ModelID = modelID
+	ret.ChannelID = channelID
+
+	// 渠道的模型允许列表不包含该模型时，正常的请求不会使用该渠道
+	if pro.ID > 0 {
+		if ch, err := p.svc.Chat.Channel(ctx, pro.ID); err == nil && !ch.Meta.AllowsModel(upstreamModel) {
+			ret.Warnings = append(ret.Warnings, fmt.Sprintf("channel %d does not allow model %s (allowed models: %s)", pro.ID, upstreamModel, strings.Join(ch.Meta.AllowedModels, ", ")))
+		}
+	}
+
+	// 支持元数据接口的渠道，使用上游返回的模型信息
+	if channelID > 0 {
+		ch, err := p.svc.Chat.Channel(ctx, channelID)
+		if err == nil {
+			ch, err = p.secrets.Resolve(ctx, ch)
+		}
+
+		if err != nil {
+			ret.Errors = append(ret.Errors, fmt.Sprintf("query channel failed: %v", err))
+		} else {
+			probeMetadata(ctx, p.httpClient, ch, upstreamModel, ret)
+		}
+	}
+
+	// 探测消耗计入内部账号
+	ret.Cost = coins.ComputeFee(coins.ChatUsage{Model: mod.ToCoinModel(), InputTokens: int64(ret.InputTokens), OutputTokens: int64(ret.OutputTokens)})
+	if ret.Cost > 0 {
+		meta := repo.NewQuotaUsedMeta("model-probe", modelID)
+		meta.InputToken = ret.InputTokens
+		meta.OutputToken = ret.OutputTokens
+
+		if err := p.quotaRepo.QuotaConsume(ctx, p.conf.ModelProbeUserID, ret.Cost, meta); err != nil {
+			log.F(log.M{"model": modelID, "channel_id": channelID}).Errorf("model probe quota consume failed: %v", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// probeMetadata 通过上游的元数据接口查询模型信息
+func probeMetadata(ctx context.Context, client *http.Client, ch *repo.Channel, model string, ret *ProbeResult) {
+	switch ch.Type {
+	case service.ProviderOpenRouter:
+		info, err := fetchOpenRouterModel(ctx, client, ch.Server, ch.Secret, model)
+		if err != nil {
+			ret.Errors = append(ret.Errors, fmt.Sprintf("query openrouter models failed: %v", err))
+			return
+		}
+
+		exists := info != nil
+		ret.Exists = &exists
+		if info != nil && info.ContextLength > 0 {
+			ret.ContextLength = info.ContextLength
+			ret.ContextLengthSource = ContextLengthFromOpenRouter
+		}
+
+		if info != nil {
+			ret.ReportedVision = info.SupportsImageInput()
+		}
+	case service.ProviderOpenAI:
+		if ch.Meta.OpenAIAzure {
+			return
+		}
+
+		exists, err := fetchOpenAIModel(ctx, client, ch.Server, ch.Secret, model)
+		if err != nil {
+			ret.Errors = append(ret.Errors, fmt.Sprintf("query openai model failed: %v", err))
+			return
+		}
+
+		ret.Exists = &exists
+	}
+}
+
+// probeChat 通过实际请求探测模型是否支持流式输出和图片输入
+func probeChat(ctx context.Context, imp Chat, model string) *ProbeResult {
+	ret := &ProbeResult{
+		UpstreamModel:       model,
+		ContextLength:       imp.MaxContextLength(model),
+		ContextLengthSource: ContextLengthFromAdapter,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	// 流式输出探测
+	stream, err := imp.ChatStream(ctx, Request{
+		Model:     model,
+		Messages:  Messages{{Role: RoleUser, Content: "ping"}},
+		MaxTokens: 1,
+	})
+	if err != nil {
+		ret.Errors = append(ret.Errors, fmt.Sprintf("stream: %s", ErrorDetail(err)))
+	} else {
+		for res := range stream {
+			if res.ErrorCode != "" {
+				ret.Errors = append(ret.Errors, fmt.Sprintf("stream: [%s] %s", res.ErrorCode, res.Error))
+				continue
+			}
+
+			ret.Streaming = true
+			ret.InputTokens += res.InputTokens
+			ret.OutputTokens += res.OutputTokens
+		}
+	}
+
+	// 图片输入探测，发送一张 1 像素的图片，上游接受请求即认为支持
+	res, err := imp.Chat(ctx, Request{
+		Model: model,
+		Messages: Messages{{
+			Role: RoleUser,
+			MultipartContents: []*MultipartContent{
+				{Type: "text", Text: "ping"},
+				{Type: "image_url", ImageURL: &ImageURL{URL: probeImage, Detail: "low"}},
+			},
+		}},
+		MaxTokens: 1,
+	})
+	if err != nil {
+		ret.Errors = append(ret.Errors, fmt.Sprintf("vision: %s", ErrorDetail(err)))
+	} else if res.ErrorCode != "" {
+		ret.Errors = append(ret.Errors, fmt.Sprintf("vision: [%s] %s", res.ErrorCode, res.Error))
+	} else {
+		ret.Vision = true
+		ret.InputTokens += res.InputTokens
+		ret.OutputTokens += res.OutputTokens
+	}
+
+	return ret
+}
+
+// OpenRouterModel OpenRouter /models 接口返回的模型信息
+type OpenRouterModel struct {
+	ID            string `json:"id"`
+	ContextLength int    `json:"context_length"`
+	Architecture  struct {
+		// Modality 输入输出的类型，如 text->text、text+image->text
+		Modality string `json:"modality"`
+	} `json:"architecture"`
+}
+
+// SupportsImageInput 根据 modality 判断是否支持图片输入，未返回 modality 时为 nil
+func (m OpenRouterModel) SupportsImageInput() *bool {
+	if m.Architecture.Modality == "" {
+		return nil
+	}
+
+	input, _, _ := strings.Cut(m.Architecture.Modality, "->")
+	supported := strings.Contains(input, "image")
+	return &supported
+}
+
+// fetchOpenRouterModel 查询 OpenRouter 的模型信息，模型不存在时返回 nil
+func fetchOpenRouterModel(ctx context.Context, client *http.Client, server, key, model string) (*OpenRouterModel, error) {
+	var resp struct {
+		Data []OpenRouterModel `json:"data"`
+	}
+
+	if _, err := probeGet(ctx, client, strings.TrimRight(server, "/")+"/models", key, &resp); err != nil {
+		return nil, err
+	}
+
+	for _, item := range resp.Data {
+		if item.ID == model {
+			return &item, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// fetchOpenAIModel 查询 OpenAI 的模型对象，返回模型是否存在
+func fetchOpenAIModel(ctx context.Context, client *http.Client, server, key, model string) (bool, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+
+	status, err := probeGet(ctx, client, strings.TrimRight(server, "/")+"/models/"+model, key, &resp)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return resp.ID != "", nil
+}
+
+func probeGet(ctx context.Context, client *http.Client, url, key string, data any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(data)
+}
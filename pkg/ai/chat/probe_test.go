@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestProbeChat(t *testing.T) {
+	client := &fakeOpenAIClient{
+		chunks: []openai2.ChatStreamResponse{
+			streamChunk(openai.ChatCompletionStreamChoice{Delta: openai.ChatCompletionStreamChoiceDelta{Content: "pong"}}),
+		},
+		response: openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "pong"}}},
+			Usage:   openai.Usage{PromptTokens: 70, CompletionTokens: 1},
+		},
+	}
+
+	ret := probeChat(context.TODO(), NewOpenAIChat(client), "gpt-4-vision-preview")
+	assert.True(t, ret.Streaming)
+	assert.True(t, ret.Vision)
+	assert.Equal(t, 0, len(ret.Errors))
+	assert.Equal(t, 70, ret.InputTokens)
+	assert.Equal(t, ContextLengthFromAdapter, ret.ContextLengthSource)
+
+	// 图片请求中包含 1 像素的测试图片
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, 1, client.requests[1].MaxTokens)
+	assert.Equal(t, probeImage, client.requests[1].Messages[0].MultiContent[1].ImageURL.URL)
+}
+
+func TestProbeChat_VisionRejected(t *testing.T) {
+	client := &fakeOpenAIClient{
+		err: &openai.APIError{Type: "invalid_request_error", Message: "image input is not supported", HTTPStatusCode: http.StatusBadRequest},
+	}
+
+	ret := probeChat(context.TODO(), NewOpenAIChat(client), "gpt-3.5-turbo")
+	assert.False(t, ret.Streaming)
+	assert.False(t, ret.Vision)
+	assert.Equal(t, 1, len(ret.Errors))
+}
+
+func TestFetchUpstreamModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/api/v1/models":
+			_, _ = w.Write([]byte(`{"data":[{"id":"openai/gpt-4","context_length":8192},{"id":"anthropic/claude-2","context_length":100000}]}`))
+		case "/v1/models/gpt-4":
+			_, _ = w.Write([]byte(`{"id":"gpt-4","object":"model","owned_by":"openai"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	info, err := fetchOpenRouterModel(context.TODO(), server.Client(), server.URL+"/api/v1/", "secret", "anthropic/claude-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 100000, info.ContextLength)
+
+	info, err = fetchOpenRouterModel(context.TODO(), server.Client(), server.URL+"/api/v1", "secret", "not-exists")
+	assert.NoError(t, err)
+	assert.True(t, info == nil)
+
+	exists, err := fetchOpenAIModel(context.TODO(), server.Client(), server.URL+"/v1", "secret", "gpt-4")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = fetchOpenAIModel(context.TODO(), server.Client(), server.URL+"/v1", "secret", "gpt-5")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestProbeProvider(t *testing.T) {
+	selected := repo.ModelProvider{ID: 1, ModelRewrite: "gpt-4o-2024-08-06"}
+	mod := repo.Model{
+		Providers: []repo.ModelProvider{
+			selected,
+			{ID: 2, ModelRewrite: "openai/gpt-4o"},
+		},
+	}
+
+	assert.Equal(t, selected, probeProvider(mod, selected, 0))
+
+	// 指定渠道时同样使用该渠道的模型重写
+	assert.Equal(t, "openai/gpt-4o", probeProvider(mod, selected, 2).ModelRewrite)
+
+	// 模型没有配置该渠道
+	assert.Equal(t, repo.ModelProvider{ID: 3}, probeProvider(mod, selected, 3))
+}
@@ -0,0 +1,172 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+
+	"github.com/mylxsw/asteria/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tokenCountCacheSize 消息 Token 数量缓存的最大条目数量，超过后淘汰最早写入的条目
+const tokenCountCacheSize = 10000
+
+// tokenCountCache 按照消息内容的哈希缓存单条消息的 Token 数量
+//
+// 很多房间使用相同的系统提示语（内置角色），每轮对话都需要重新计算这些消息的 Token 数量，
+// 以消息为单位缓存之后，消息列表的公共前缀只需要计算一次
+type tokenCountCache struct {
+	lock    sync.Mutex
+	entries map[[sha256.Size]byte]int
+	// order 按照写入顺序排列的缓存 Key（环形缓冲区），next 为下一个写入位置
+	order [][sha256.Size]byte
+	next  int
+}
+
+func newTokenCountCache(size int) *tokenCountCache {
+	return &tokenCountCache{
+		entries: make(map[[sha256.Size]byte]int, size),
+		order:   make([][sha256.Size]byte, 0, size),
+	}
+}
+
+func (c *tokenCountCache) get(key [sha256.Size]byte) (int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	count, ok := c.entries[key]
+	return count, ok
+}
+
+func (c *tokenCountCache) set(key [sha256.Size]byte, count int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		c.entries[key] = count
+		return
+	}
+
+	if len(c.order) < cap(c.order) {
+		c.order = append(c.order, key)
+	} else {
+		delete(c.entries, c.order[c.next])
+		c.order[c.next] = key
+		c.next = (c.next + 1) % len(c.order)
+	}
+
+	c.entries[key] = count
+}
+
+// messageTokenCache 所有模型共用的消息 Token 数量缓存
+var messageTokenCache = newTokenCountCache(tokenCountCacheSize)
+
+// messageCacheKey 消息 Token 数量缓存的 Key，包含影响计算结果的所有内容（模型、角色、文本和图片的识别精度），
+// 图片只影响固定的 Token 数量，不包含图片地址
+func messageCacheKey(model string, message Message) [sha256.Size]byte {
+	h := sha256.New()
+	write := func(values ...string) {
+		for _, v := range values {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+	}
+
+	write(model, string(message.Role))
+	if len(message.MultipartContents) > 0 {
+		for _, content := range message.MultipartContents {
+			if content == nil {
+				continue
+			}
+
+			if content.ImageURL != nil {
+				write(content.Type, content.Text, content.ImageURL.Detail)
+			} else {
+				write(content.Type, content.Text, "")
+			}
+		}
+	} else {
+		write("", message.Content)
+	}
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// promptCacheMetrics 提示语缓存的统计信息
+type promptCacheMetrics struct {
+	// lookups 消息 Token 数量缓存的查询次数，按照是否命中（hit/miss）区分，用于计算命中率
+	lookups *prometheus.CounterVec
+	// savedTokens 命中缓存而不需要重新计算的 Token 数量
+	savedTokens prometheus.Counter
+	// upstreamTokens 服务提供商返回的提示语缓存 Token 数量，按照服务提供商和类型（read/write）区分
+	upstreamTokens *prometheus.CounterVec
+}
+
+// newPromptCacheMetrics 创建提示语缓存的统计信息，并注册到 registerer
+func newPromptCacheMetrics(registerer prometheus.Registerer) *promptCacheMetrics {
+	return &promptCacheMetrics{
+		lookups: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "aidea",
+			Name:      "chat_token_count_cache_count",
+			Help:      "message token count cache lookups",
+		}, []string{"result"})),
+		savedTokens: registerCollector(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "aidea",
+			Name:      "chat_token_count_cache_saved_tokens",
+			Help:      "tokens served from the message token count cache instead of being re-tokenized",
+		})),
+		upstreamTokens: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "aidea",
+			Name:      "chat_prompt_cache_tokens",
+			Help:      "input tokens read from or written to the upstream prompt cache",
+		}, []string{"provider", "kind"})),
+	}
+}
+
+// registerCollector 注册统计指标，已经注册过时返回已有的指标
+func registerCollector[T prometheus.Collector](registerer prometheus.Registerer, collector T) T {
+	if err := registerer.Register(collector); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+
+		log.Errorf("register prompt cache metrics failed: %v", err)
+	}
+
+	return collector
+}
+
+// defaultPromptCacheMetrics 注册到默认 Registerer 的提示语缓存统计信息
+var defaultPromptCacheMetrics = sync.OnceValue(func() *promptCacheMetrics {
+	return newPromptCacheMetrics(prometheus.DefaultRegisterer)
+})
+
+// observeTokenCountCache 记录消息 Token 数量缓存的查询结果
+func observeTokenCountCache(hit bool, tokens int) {
+	metrics := defaultPromptCacheMetrics()
+	if !hit {
+		metrics.lookups.WithLabelValues("miss").Inc()
+		return
+	}
+
+	metrics.lookups.WithLabelValues("hit").Inc()
+	metrics.savedTokens.Add(float64(tokens))
+}
+
+// observeUpstreamPromptCache 记录服务提供商返回的提示语缓存 Token 数量（read 为命中缓存，write 为写入缓存）
+func observeUpstreamPromptCache(provider string, read, write int) {
+	metrics := defaultPromptCacheMetrics()
+	if read > 0 {
+		metrics.upstreamTokens.WithLabelValues(provider, "read").Add(float64(read))
+	}
+
+	if write > 0 {
+		metrics.upstreamTokens.WithLabelValues(provider, "write").Add(float64(write))
+	}
+}
@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestTokenCountCache(t *testing.T) {
+	cache := newTokenCountCache(2)
+
+	first := messageCacheKey("gpt-4", Message{Role: RoleSystem, Content: "first"})
+	second := messageCacheKey("gpt-4", Message{Role: RoleSystem, Content: "second"})
+	third := messageCacheKey("gpt-4", Message{Role: RoleSystem, Content: "third"})
+
+	cache.set(first, 1)
+	cache.set(second, 2)
+
+	count, ok := cache.get(first)
+	assert.True(t, ok)
+	assert.Equal(t, 1, count)
+
+	// 超过容量时淘汰最早写入的条目
+	cache.set(third, 3)
+	_, ok = cache.get(first)
+	assert.False(t, ok)
+
+	count, ok = cache.get(second)
+	assert.True(t, ok)
+	assert.Equal(t, 2, count)
+
+	count, ok = cache.get(third)
+	assert.True(t, ok)
+	assert.Equal(t, 3, count)
+}
+
+func TestMessageCacheKey(t *testing.T) {
+	message := Message{Role: RoleSystem, Content: "你是一个翻译助手"}
+	assert.Equal(t, messageCacheKey("gpt-4", message), messageCacheKey("gpt-4", message))
+
+	// 模型、角色和内容都会影响 Token 数量
+	assert.True(t, messageCacheKey("gpt-4", message) != messageCacheKey("glm-4v", message))
+	assert.True(t, messageCacheKey("gpt-4", message) != messageCacheKey("gpt-4", Message{Role: RoleUser, Content: message.Content}))
+	assert.True(t, messageCacheKey("gpt-4", message) != messageCacheKey("gpt-4", Message{Role: RoleSystem, Content: message.Content + "。"}))
+
+	// 图片的地址不影响 Token 数量，识别精度会影响
+	image := func(url, detail string) Message {
+		return Message{Role: RoleUser, MultipartContents: []*MultipartContent{
+			{Type: "text", Text: "图片中有什么"},
+			{Type: "image_url", ImageURL: &ImageURL{URL: url, Detail: detail}},
+		}}
+	}
+	assert.Equal(t, messageCacheKey("gpt-4", image("a.png", "low")), messageCacheKey("gpt-4", image("b.png", "low")))
+	assert.True(t, messageCacheKey("gpt-4", image("a.png", "low")) != messageCacheKey("gpt-4", image("a.png", "high")))
+}
+
+func TestMessageTokenCount_Cached(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	messages := Messages{
+		{Role: RoleSystem, Content: strings.Repeat("You are a helpful assistant. ", 50)},
+		{Role: RoleUser, Content: "hello"},
+	}
+
+	uncached := replyPrimingTokens
+	counter := newMessageTokenCounter("gpt-4")
+	for _, message := range messages {
+		tokens, err := counter.count(message)
+		assert.NoError(t, err)
+		assert.Equal(t, counter.tokenize(message), tokens)
+		uncached += tokens
+	}
+
+	cached, err := MessageTokenCount(messages, "gpt-4")
+	assert.NoError(t, err)
+	assert.Equal(t, uncached, cached)
+}
+
+func TestAnthropicChat_PromptCache(t *testing.T) {
+	skipWithoutTiktoken(t)
+
+	long := strings.Repeat("You are a professional translator, translate everything the user says into English. ", 100)
+	for _, c := range []struct {
+		system string
+		cached bool
+	}{
+		{system: long, cached: true},
+		{system: "You are a helpful assistant.", cached: false},
+	} {
+		req, err := (&AnthropicChat{}).initRequest(Request{
+			Model:    "claude-3-opus",
+			Messages: Messages{{Role: RoleSystem, Content: c.system}, {Role: RoleUser, Content: "hello"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(req.System))
+		assert.Equal(t, c.system, req.System[0].Text)
+		assert.Equal(t, c.cached, req.System[0].CacheControl != nil)
+	}
+}
+
+// benchmarkMessages 使用内置角色的长系统提示语的多轮对话
+func benchmarkMessages() Messages {
+	return Messages{
+		{Role: RoleSystem, Content: strings.Repeat("你是一个专业的翻译助手，将用户输入的内容翻译为英文，保持原文的格式。", 100)},
+		{Role: RoleUser, Content: "今天天气怎么样"},
+		{Role: RoleAssistant, Content: "How is the weather today?"},
+		{Role: RoleUser, Content: "我想去公园散步"},
+	}
+}
+
+func BenchmarkMessageTokenCount(b *testing.B) {
+	skipWithoutTiktoken(b)
+
+	messages := benchmarkMessages()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MessageTokenCount(messages, "gpt-4"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMessageTokenCount_Uncached(b *testing.B) {
+	skipWithoutTiktoken(b)
+
+	messages := benchmarkMessages()
+	counter := newMessageTokenCounter("gpt-4")
+	if _, err := counter.count(messages[0]); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, message := range messages {
+			counter.tokenize(message)
+		}
+	}
+}
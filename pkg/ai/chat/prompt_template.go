@@ -0,0 +1,167 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/array"
+)
+
+var (
+	// ErrPromptTemplateNotFound 请求中引用的提示语模板不存在
+	ErrPromptTemplateNotFound = errors.New("提示语模板不存在")
+	// ErrPromptTemplateVariableMissing 请求中没有提供提示语模板需要的变量
+	ErrPromptTemplateVariableMissing = errors.New("提示语模板缺少变量")
+)
+
+// PromptTemplate 服务端保存的提示语模板，内容中可以使用 {{name}} 引用变量
+type PromptTemplate struct {
+	// System 系统提示语，为空时不添加
+	System string
+	// User 用户消息，为空时不添加
+	User string
+}
+
+// PromptTemplateStore 提示语模板的存储，模板不存在时返回 ErrPromptTemplateNotFound
+type PromptTemplateStore interface {
+	PromptTemplate(ctx context.Context, id string) (*PromptTemplate, error)
+}
+
+// MemoryPromptTemplateStore 基于内存的提示语模板存储，key 为模板 ID
+type MemoryPromptTemplateStore map[string]PromptTemplate
+
+func (s MemoryPromptTemplateStore) PromptTemplate(ctx context.Context, id string) (*PromptTemplate, error) {
+	tpl, ok := s[id]
+	if !ok {
+		return nil, ErrPromptTemplateNotFound
+	}
+
+	return &tpl, nil
+}
+
+// RepoPromptTemplateStore 使用系统提示语示例（chat_sys_prompt_example）作为提示语模板，模板 ID 为示例 ID，示例内容作为系统提示语
+type RepoPromptTemplateStore struct {
+	prompts *repo.PromptRepo
+}
+
+func NewRepoPromptTemplateStore(prompts *repo.PromptRepo) *RepoPromptTemplateStore {
+	return &RepoPromptTemplateStore{prompts: prompts}
+}
+
+func (s *RepoPromptTemplateStore) PromptTemplate(ctx context.Context, id string) (*PromptTemplate, error) {
+	exampleID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrPromptTemplateNotFound
+	}
+
+	example, err := s.prompts.ChatSystemPromptExample(ctx, exampleID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, ErrPromptTemplateNotFound
+		}
+
+		return nil, err
+	}
+
+	return &PromptTemplate{System: example.Content}, nil
+}
+
+// promptVariablePattern 提示语模板中的变量引用：{{name}}
+var promptVariablePattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// ExpandPromptTemplate 使用请求中指定的提示语模板（PromptTemplateID）生成系统提示语和用户消息，未指定模板时请求保持不变
+//
+// 模板的系统提示语添加到所有消息之前；模板的用户消息与请求中最后一条用户消息合并（放在前面），
+// 最后一条消息不是用户消息时，作为新的用户消息添加到最后。模板中引用的变量必须在 PromptVariables 中提供
+func ExpandPromptTemplate(ctx context.Context, store PromptTemplateStore, req Request) (Request, error) {
+	if req.PromptTemplateID == "" {
+		return req, nil
+	}
+
+	if store == nil {
+		return req, fmt.Errorf("%w: %s", ErrPromptTemplateNotFound, req.PromptTemplateID)
+	}
+
+	tpl, err := store.PromptTemplate(ctx, req.PromptTemplateID)
+	if err != nil {
+		if errors.Is(err, ErrPromptTemplateNotFound) {
+			return req, fmt.Errorf("%w: %s", ErrPromptTemplateNotFound, req.PromptTemplateID)
+		}
+
+		return req, fmt.Errorf("query prompt template %s failed: %w", req.PromptTemplateID, err)
+	}
+
+	system, err := substitutePromptVariables(tpl.System, req.PromptVariables)
+	if err != nil {
+		return req, err
+	}
+
+	user, err := substitutePromptVariables(tpl.User, req.PromptVariables)
+	if err != nil {
+		return req, err
+	}
+
+	messages := make(Messages, 0, len(req.Messages)+2)
+	if system != "" {
+		messages = append(messages, Message{Role: RoleSystem, Content: system})
+	}
+	messages = append(messages, req.Messages...)
+
+	if user != "" {
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == RoleUser {
+			messages[last] = prependUserContent(messages[last], user)
+		} else {
+			messages = append(messages, Message{Role: RoleUser, Content: user})
+		}
+	}
+
+	req.Messages = messages
+	req.PromptTemplateID = ""
+	req.PromptVariables = nil
+
+	return req, nil
+}
+
+// substitutePromptVariables 替换模板中的变量，缺少变量时返回 ErrPromptTemplateVariableMissing
+func substitutePromptVariables(content string, variables map[string]string) (string, error) {
+	var missing []string
+	ret := promptVariablePattern.ReplaceAllStringFunc(content, func(match string) string {
+		name := promptVariablePattern.FindStringSubmatch(match)[1]
+		if val, ok := variables[name]; ok {
+			return val
+		}
+
+		if !array.In(name, missing) {
+			missing = append(missing, name)
+		}
+
+		return match
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrPromptTemplateVariableMissing, strings.Join(missing, ", "))
+	}
+
+	return ret, nil
+}
+
+// prependUserContent 将模板的用户消息放在用户消息的内容之前
+func prependUserContent(msg Message, content string) Message {
+	if len(msg.MultipartContents) > 0 {
+		msg.MultipartContents = append([]*MultipartContent{{Type: "text", Text: content}}, msg.MultipartContents...)
+		return msg
+	}
+
+	if strings.TrimSpace(msg.Content) == "" {
+		msg.Content = content
+	} else {
+		msg.Content = content + "\n\n" + msg.Content
+	}
+
+	return msg
+}
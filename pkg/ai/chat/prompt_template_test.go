@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+var testPromptTemplates = MemoryPromptTemplateStore{
+	"translate": {
+		System: "你是一名{{ language }}翻译，只输出译文",
+		User:   "请将以下内容翻译为{{language}}：",
+	},
+	"summary": {
+		User: "总结以下内容，不超过 {{words}} 字：{{content}}",
+	},
+}
+
+func TestExpandPromptTemplate(t *testing.T) {
+	// 未指定模板时保持不变
+	req := Request{Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+	expanded, err := ExpandPromptTemplate(context.TODO(), testPromptTemplates, req)
+	assert.NoError(t, err)
+	assert.Equal(t, req.Messages, expanded.Messages)
+
+	// 模板的用户消息与最后一条用户消息合并
+	req = Request{
+		Messages:         Messages{{Role: RoleUser, Content: "hello"}},
+		PromptTemplateID: "translate",
+		PromptVariables:  map[string]string{"language": "英文"},
+	}
+	expanded, err = ExpandPromptTemplate(context.TODO(), testPromptTemplates, req)
+	assert.NoError(t, err)
+	assert.Equal(t, Messages{
+		{Role: RoleSystem, Content: "你是一名英文翻译，只输出译文"},
+		{Role: RoleUser, Content: "请将以下内容翻译为英文：\n\nhello"},
+	}, expanded.Messages)
+	assert.Equal(t, "", expanded.PromptTemplateID)
+	// 不修改原始请求
+	assert.Equal(t, "hello", req.Messages[0].Content)
+
+	// 只提供模板 ID 和变量
+	expanded, err = ExpandPromptTemplate(context.TODO(), testPromptTemplates, Request{
+		PromptTemplateID: "summary",
+		PromptVariables:  map[string]string{"words": "50", "content": "{{words}}"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Messages{{Role: RoleUser, Content: "总结以下内容，不超过 50 字：{{words}}"}}, expanded.Messages)
+
+	// 缺少变量
+	_, err = ExpandPromptTemplate(context.TODO(), testPromptTemplates, Request{PromptTemplateID: "summary", PromptVariables: map[string]string{"words": "50"}})
+	assert.True(t, errors.Is(err, ErrPromptTemplateVariableMissing))
+	assert.Equal(t, "提示语模板缺少变量: content", err.Error())
+}
+
+func TestExpandPromptTemplate_NotFound(t *testing.T) {
+	_, err := ExpandPromptTemplate(context.TODO(), testPromptTemplates, Request{PromptTemplateID: "unknown"})
+	assert.True(t, errors.Is(err, ErrPromptTemplateNotFound))
+	assert.Equal(t, "提示语模板不存在: unknown", err.Error())
+
+	// 没有配置模板存储
+	_, err = ExpandPromptTemplate(context.TODO(), nil, Request{PromptTemplateID: "translate"})
+	assert.True(t, errors.Is(err, ErrPromptTemplateNotFound))
+
+	// 分发时同样检查，不会请求上游
+	client := &streamChatClient{}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "gpt-4", PayloadPolicyReject)
+	d.templates = testPromptTemplates
+
+	_, err = d.ChatStream(context.TODO(), Request{PromptTemplateID: "unknown"})
+	assert.True(t, errors.Is(err, ErrPromptTemplateNotFound))
+	assert.Equal(t, 0, len(client.requests))
+
+	res, err := d.Chat(context.TODO(), Request{PromptTemplateID: "translate", PromptVariables: map[string]string{"language": "英文"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.Equal(t, RoleSystem, client.requests[0].Messages[0].Role)
+	assert.Equal(t, "你是一名英文翻译，只输出译文", client.requests[0].Messages[0].Content)
+}
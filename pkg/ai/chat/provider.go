@@ -1,6 +1,11 @@
 package chat
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/mylxsw/aidea-server/config"
 	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
 	"github.com/mylxsw/aidea-server/pkg/ai/baichuan"
@@ -12,13 +17,16 @@ import (
 	"github.com/mylxsw/aidea-server/pkg/ai/oneapi"
 	"github.com/mylxsw/aidea-server/pkg/ai/openai"
 	"github.com/mylxsw/aidea-server/pkg/ai/openrouter"
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
 	"github.com/mylxsw/aidea-server/pkg/ai/sensenova"
 	"github.com/mylxsw/aidea-server/pkg/ai/sky"
 	"github.com/mylxsw/aidea-server/pkg/ai/tencentai"
 	"github.com/mylxsw/aidea-server/pkg/ai/xfyun"
 	"github.com/mylxsw/aidea-server/pkg/ai/zhipuai"
 	"github.com/mylxsw/aidea-server/pkg/file"
+	"github.com/mylxsw/aidea-server/pkg/repo"
 	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/glacier/infra"
 )
 
@@ -32,8 +40,93 @@ func (Provider) Register(binder infra.Binder) {
 		return &aiProvider
 	})
 	binder.MustSingleton(NewAI)
-	binder.MustSingleton(func(conf *config.Config, resolver infra.Resolver, svc *service.Service, ai *AI) Chat {
-		return NewChat(conf, resolver, svc, ai)
+	binder.MustSingleton(NewModelRouter)
+	binder.MustSingleton(NewChannelSecrets)
+	binder.MustSingleton(NewClientFactory)
+	binder.MustSingleton(func(prompts *repo.PromptRepo) PromptTemplateStore {
+		return NewRepoPromptTemplateStore(prompts)
+	})
+	binder.MustSingleton(NewChat)
+	binder.MustSingleton(func(conf *config.Config, svc *service.Service) *SessionManager {
+		return NewSessionManager(svc.Chat, time.Duration(conf.ChatInputSessionTTL)*time.Second, conf.ChatInputSessionMaxCount, conf.ChatInputSessionMaxRunes)
+	})
+	binder.MustSingleton(NewModelProber)
+	binder.MustSingleton(NewDriftDetector)
+	binder.MustSingleton(NewCompressor)
+	binder.MustSingleton(func(conf *config.Config) (*SandboxChat, error) {
+		var responses []SandboxResponse
+		if conf.ChatSandboxResponses != "" {
+			loaded, err := LoadSandboxResponses(conf.ChatSandboxResponses)
+			if err != nil {
+				return nil, fmt.Errorf("load sandbox responses failed: %w", err)
+			}
+
+			responses = loaded
+		}
+
+		return NewSandboxChat(responses, time.Duration(conf.ChatSandboxStreamInterval)*time.Millisecond), nil
+	})
+	binder.MustSingleton(func(conf *config.Config) (TaskProfiles, error) {
+		if conf.ChatTaskProfiles == "" {
+			return TaskProfiles{}, nil
+		}
+
+		profiles, err := LoadTaskProfiles(conf.ChatTaskProfiles)
+		if err != nil {
+			return nil, fmt.Errorf("load task profiles failed: %w", err)
+		}
+
+		return profiles, nil
+	})
+}
+
+func (Provider) Boot(resolver infra.Resolver) {
+	// 默认聊天模型不存在时无法启动；查询失败（如数据库暂时不可用）时只记录日志，不影响启动
+	resolver.MustResolve(func(conf *config.Config, svc *service.Service) error {
+		err := ValidateDefaultModel(conf.DefaultChatModel, func(modelID string) (*repo.Model, error) {
+			return svc.Chat.QueryModel(context.Background(), modelID)
+		})
+		if err != nil {
+			if errors.Is(err, ErrDefaultModelNotFound) {
+				return err
+			}
+
+			log.Errorf("validate default chat model failed, skipped: %v", err)
+		}
+
+		return nil
+	})
+
+	// 录制请求服务提供商的请求和响应，用于编写测试用例，生产环境不允许开启
+	resolver.MustResolve(func(conf *config.Config) {
+		if conf.DebugRecordProviderDir == "" {
+			return
+		}
+
+		if conf.IsProduction {
+			log.Warningf("provider recording is not allowed in production, ignored")
+			return
+		}
+
+		// 只有 OpenAI 兼容渠道、Anthropic 以及 Gemini 的客户端使用了录制的 Transport，
+		// 其它服务提供商使用默认的 HTTP Client，不会被录制
+		recorder.Enable(conf.DebugRecordProviderDir)
+		log.Warningf("provider recording is enabled, requests and responses are saved to %s", conf.DebugRecordProviderDir)
+	})
+
+	// 模型能力检测，异步执行，不影响启动
+	resolver.MustResolve(func(conf *config.Config, detector *DriftDetector) {
+		if !conf.ChatStartupDriftCheck {
+			return
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+
+			drifts := detector.Detect(ctx)
+			log.Infof("model capability drift check finished, %d mismatches found", len(drifts))
+		}()
 	})
 }
 
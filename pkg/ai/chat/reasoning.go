@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReasoningBudget 统一的思考（推理）预算，由各个服务提供商转换为自己的参数
+//
+// 取值为 off/low/medium/high 或者明确的 Token 数量（如 "8000"，JSON 中也可以直接使用数字），为空时与 off 相同。
+// Anthropic 转换为 thinking.budget_tokens，OpenAI 转换为 reasoning_effort，不支持的服务提供商（如 DeepSeek 等
+// 总是进行推理的模型）忽略该参数。
+type ReasoningBudget string
+
+const (
+	ReasoningBudgetOff    ReasoningBudget = "off"
+	ReasoningBudgetLow    ReasoningBudget = "low"
+	ReasoningBudgetMedium ReasoningBudget = "medium"
+	ReasoningBudgetHigh   ReasoningBudget = "high"
+)
+
+// 各个等级对应的 Token 数量，同时作为 Token 数量转换为等级时的分界
+const (
+	reasoningTokensLow    = 1024
+	reasoningTokensMedium = 4096
+	reasoningTokensHigh   = 16384
+)
+
+func (b *ReasoningBudget) UnmarshalJSON(data []byte) error {
+	var tokens int
+	if err := json.Unmarshal(data, &tokens); err == nil {
+		*b = ReasoningBudget(strconv.Itoa(tokens))
+		return nil
+	}
+
+	var val string
+	if err := json.Unmarshal(data, &val); err != nil {
+		return fmt.Errorf("invalid reasoning budget: %s", string(data))
+	}
+
+	*b = ReasoningBudget(strings.ToLower(strings.TrimSpace(val)))
+	return nil
+}
+
+// Validate 校验取值是否合法
+func (b ReasoningBudget) Validate() error {
+	switch b {
+	case "", ReasoningBudgetOff, ReasoningBudgetLow, ReasoningBudgetMedium, ReasoningBudgetHigh:
+		return nil
+	}
+
+	if tokens, err := strconv.Atoi(string(b)); err != nil || tokens < 0 {
+		return fmt.Errorf("invalid reasoning budget: %s", b)
+	}
+
+	return nil
+}
+
+// Enabled 是否启用思考
+func (b ReasoningBudget) Enabled() bool {
+	return b.Tokens() > 0
+}
+
+// Tokens 思考预算的 Token 数量，未启用或者取值不合法时返回 0
+func (b ReasoningBudget) Tokens() int {
+	switch b {
+	case "", ReasoningBudgetOff:
+		return 0
+	case ReasoningBudgetLow:
+		return reasoningTokensLow
+	case ReasoningBudgetMedium:
+		return reasoningTokensMedium
+	case ReasoningBudgetHigh:
+		return reasoningTokensHigh
+	}
+
+	tokens, err := strconv.Atoi(string(b))
+	if err != nil || tokens < 0 {
+		return 0
+	}
+
+	return tokens
+}
+
+// Effort 思考预算对应的等级（low/medium/high），未启用时返回空字符串，明确的 Token 数量按照所在的区间转换
+func (b ReasoningBudget) Effort() string {
+	tokens := b.Tokens()
+	switch {
+	case tokens <= 0:
+		return ""
+	case tokens < reasoningTokensMedium:
+		return string(ReasoningBudgetLow)
+	case tokens < reasoningTokensHigh:
+		return string(ReasoningBudgetMedium)
+	default:
+		return string(ReasoningBudgetHigh)
+	}
+}
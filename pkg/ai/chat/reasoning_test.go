@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestReasoningBudget_UnmarshalJSON(t *testing.T) {
+	var req Request
+	assert.NoError(t, json.Unmarshal([]byte(`{"reasoning_budget": 8000}`), &req))
+	assert.Equal(t, ReasoningBudget("8000"), req.ReasoningBudget)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"reasoning_budget": " High "}`), &req))
+	assert.Equal(t, ReasoningBudgetHigh, req.ReasoningBudget)
+
+	assert.True(t, json.Unmarshal([]byte(`{"reasoning_budget": true}`), &req) != nil)
+
+	assert.NoError(t, ReasoningBudget("medium").Validate())
+	assert.True(t, ReasoningBudget("max").Validate() != nil)
+	assert.True(t, ReasoningBudget("-1").Validate() != nil)
+}
+
+// reasoningMappingCases 同一个统一的思考预算，分别在 Anthropic 和 OpenAI 中对应的参数
+var reasoningMappingCases = []struct {
+	budget          ReasoningBudget
+	anthropicBudget int
+	openaiEffort    string
+}{
+	{budget: "", anthropicBudget: 0, openaiEffort: ""},
+	{budget: ReasoningBudgetOff, anthropicBudget: 0, openaiEffort: ""},
+	{budget: "0", anthropicBudget: 0, openaiEffort: ""},
+	{budget: ReasoningBudgetLow, anthropicBudget: 1024, openaiEffort: "low"},
+	{budget: ReasoningBudgetMedium, anthropicBudget: 4096, openaiEffort: "medium"},
+	{budget: ReasoningBudgetHigh, anthropicBudget: 16384, openaiEffort: "high"},
+	// 低于 Anthropic 的最小值时，使用最小值
+	{budget: "500", anthropicBudget: 1024, openaiEffort: "low"},
+	{budget: "8000", anthropicBudget: 8000, openaiEffort: "medium"},
+	{budget: "32000", anthropicBudget: 32000, openaiEffort: "high"},
+}
+
+func TestReasoningBudget_Anthropic(t *testing.T) {
+	for _, tc := range reasoningMappingCases {
+		t.Run(string(tc.budget), func(t *testing.T) {
+			req, err := (&AnthropicChat{}).initRequest(Request{
+				Model:           "claude-3-7-sonnet",
+				Messages:        Messages{{Role: RoleUser, Content: "hello"}},
+				MaxTokens:       1000,
+				Temperature:     float64Ptr(0.5),
+				ReasoningBudget: tc.budget,
+			})
+			assert.NoError(t, err)
+
+			if tc.anthropicBudget == 0 {
+				assert.True(t, req.Thinking == nil)
+				assert.Equal(t, 0.5, req.Temperature)
+				return
+			}
+
+			assert.Equal(t, "enabled", req.Thinking.Type)
+			assert.Equal(t, tc.anthropicBudget, req.Thinking.BudgetTokens)
+			// max_tokens 必须大于思考预算
+			assert.Equal(t, tc.anthropicBudget+1000, req.MaxTokens)
+			assert.Equal(t, 0.0, req.Temperature)
+		})
+	}
+}
+
+func TestReasoningBudget_OpenAI(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = nil
+		assert.NoError(t, json.Unmarshal(data, &body))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "ok"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	imp := createOpenAIClient(&repo.Channel{Channels: model.Channels{Server: server.URL, Secret: "sk-test"}}, nil, 0)
+
+	for _, tc := range reasoningMappingCases {
+		t.Run(string(tc.budget), func(t *testing.T) {
+			res, err := imp.Chat(context.Background(), Request{
+				Model:           "o3-mini",
+				Messages:        Messages{{Role: RoleUser, Content: "hello"}},
+				ReasoningBudget: tc.budget,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "ok", res.Text[1:])
+
+			assert.Equal(t, "o3-mini", body["model"])
+			if tc.openaiEffort == "" {
+				_, ok := body["reasoning_effort"]
+				assert.False(t, ok)
+				return
+			}
+
+			assert.Equal(t, tc.openaiEffort, body["reasoning_effort"])
+		})
+	}
+}
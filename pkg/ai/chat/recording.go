@@ -0,0 +1,26 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
+)
+
+// recordingChat 开启录制时（参考 recorder.Enable），在请求的 context 中指定渠道类型，录制的内容按照渠道类型保存，
+// 而不是客户端的类型（如 OneAPI、Moonshot 等渠道使用的都是 OpenAI 的客户端）
+type recordingChat struct {
+	imp          Chat
+	providerType string
+}
+
+func (c *recordingChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	return c.imp.Chat(recorder.WithProvider(ctx, c.providerType), req)
+}
+
+func (c *recordingChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	return c.imp.ChatStream(recorder.WithProvider(ctx, c.providerType), req)
+}
+
+func (c *recordingChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestOpenAIChat_Refusal(t *testing.T) {
+	refuse := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"stream":true`) {
+			w.Header().Set("Content-Type", "application/json")
+			if refuse {
+				_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": null, "refusal": "I'm sorry, I cannot help with that."}, "finish_reason": "stop"}]}`))
+			} else {
+				_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "ok", "refusal": null}, "finish_reason": "stop"}]}`))
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": "", "refusal": "I'm sorry, "}}]}`,
+			`{"choices": [{"index": 0, "delta": {"refusal": "I cannot help with that."}}]}`,
+			`{"choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+		}
+		if !refuse {
+			chunks = []string{
+				`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": "ok", "refusal": null}}]}`,
+				`{"choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+			}
+		}
+
+		for _, chunk := range chunks {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := createOpenAIClient(&repo.Channel{Channels: model.Channels{Type: service.ProviderOpenAI, Server: server.URL, Secret: "sk-test"}}, nil, 0)
+	req := Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+
+	// 模型拒绝回答时返回拒绝的说明，与触发内容安全策略区分
+	res, err := client.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "I'm sorry, I cannot help with that.", res.Refusal)
+	assert.Equal(t, FinishReasonRefusal, res.FinishReason)
+	assert.True(t, res.ContentFilter == nil)
+
+	// 流式输出时累积拒绝说明的所有片段，在结束响应中返回
+	stream, err := client.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), stream))
+	last := responses[len(responses)-1]
+	assert.Equal(t, "I'm sorry, I cannot help with that.", last.Refusal)
+	assert.Equal(t, FinishReasonRefusal, last.FinishReason)
+	assert.Equal(t, "", last.ErrorCode)
+	assert.True(t, last.ContentFilter == nil)
+	for _, item := range responses[:len(responses)-1] {
+		assert.Equal(t, "", item.Refusal)
+	}
+
+	// 正常回答时不受影响
+	refuse = false
+	res, err = client.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.Refusal)
+	assert.Equal(t, FinishReasonStop, res.FinishReason)
+
+	stream, err = client.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	responses = assertFinishReasonConformance(t, stream)
+	assert.Equal(t, "ok", concatText(responses))
+	assert.Equal(t, FinishReasonStop, responses[len(responses)-1].FinishReason)
+	assert.Equal(t, "", responses[len(responses)-1].Refusal)
+}
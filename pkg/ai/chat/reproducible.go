@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+)
+
+// WantsReproducible 请求是否要求可复现的输出：temperature 为 0 且指定了 seed
+//
+// 对于支持的模型（models.meta.reproducible），相同渠道、相同模型的相同请求会返回相同的结果，
+// 不支持的模型仍然正常处理请求，但是会在响应中标记 Reproducible 为 false。
+func (req Request) WantsReproducible() bool {
+	return req.Seed != nil && req.Temperature != nil && *req.Temperature == 0
+}
+
+// forwardsReproducibleParams 服务提供商的实现是否会把 seed 和 temperature=0 原样发送给上游
+//
+// 只有 OpenAI 的实现会通过额外的请求体强制发送 temperature=0，其它基于 go-openai 的实现（OneAPI、OpenRouter 等）
+// 会因为 omitempty 丢弃 temperature=0，上游使用默认温度，无法保证输出可复现
+func forwardsReproducibleParams(providerType string) bool {
+	return providerType == service.ProviderOpenAI
+}
+
+// checkReproducible 请求要求可复现的输出时，返回模型以及实际使用的服务提供商是否支持，未要求时返回 nil
+func checkReproducible(req Request, meta repo.ModelMeta, providerType string) *bool {
+	if !req.WantsReproducible() {
+		return nil
+	}
+
+	supported := meta.Reproducible && forwardsReproducibleParams(providerType)
+	if !supported {
+		log.F(log.M{"model": req.Model, "seed": *req.Seed, "provider": providerType}).Warning("request asks for reproducible output, but the model or provider does not support it")
+	}
+
+	return &supported
+}
@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestRequest_WantsReproducible(t *testing.T) {
+	assert.True(t, Request{Seed: intPtr(42), Temperature: float64Ptr(0)}.WantsReproducible())
+	assert.False(t, Request{Seed: intPtr(42)}.WantsReproducible())
+	assert.False(t, Request{Seed: intPtr(42), Temperature: float64Ptr(0.7)}.WantsReproducible())
+	assert.False(t, Request{Temperature: float64Ptr(0)}.WantsReproducible())
+}
+
+func TestDispatcher_Reproducible(t *testing.T) {
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderOpenAI}},
+			Meta:      repo.ModelMeta{Reproducible: true},
+		},
+		"claude": {
+			Models:    model.Models{ModelId: "claude"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderAnthropic}},
+		},
+	}
+
+	client := &streamChatClient{chunks: []Response{{Text: "ok"}}}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	newRequest := func(model string, seed *int) Request {
+		return Request{
+			Model:       model,
+			Seed:        seed,
+			Temperature: float64Ptr(0),
+			Messages:    Messages{{Role: RoleUser, Content: "hello"}},
+		}
+	}
+
+	// 支持可复现输出的模型
+	res, err := d.Chat(context.TODO(), newRequest("gpt-4", intPtr(42)))
+	assert.NoError(t, err)
+	assert.True(t, res.Reproducible != nil && *res.Reproducible)
+
+	// 不支持可复现输出的模型，正常返回结果，但是明确标记
+	res, err = d.Chat(context.TODO(), newRequest("claude", intPtr(42)))
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.True(t, res.Reproducible != nil && !*res.Reproducible)
+
+	// 未要求可复现输出时不标记
+	res, err = d.Chat(context.TODO(), newRequest("claude", nil))
+	assert.NoError(t, err)
+	assert.True(t, res.Reproducible == nil)
+
+	// 流式输出时，第一个响应为包含标记的中间状态响应
+	stream, err := d.ChatStream(context.TODO(), newRequest("claude", intPtr(42)))
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	assert.True(t, responses[0].Interim)
+	assert.True(t, responses[0].Reproducible != nil && !*responses[0].Reproducible)
+	assert.Equal(t, "ok", responses[1].Text)
+}
+
+func TestDispatcher_ReproducibleProvider(t *testing.T) {
+	router := fakeModelRouter{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderOneAPI}},
+			Meta:      repo.ModelMeta{Reproducible: true},
+		},
+	}
+
+	req := Request{
+		Model:       "gpt-4",
+		Seed:        intPtr(42),
+		Temperature: float64Ptr(0),
+		Messages:    Messages{{Role: RoleUser, Content: "hello"}},
+	}
+
+	// OneAPI、OpenRouter 会丢弃 temperature=0，即使模型支持也无法保证输出可复现
+	for _, typ := range []string{service.ProviderOneAPI, service.ProviderOpenRouter} {
+		client := &streamChatClient{chunks: []Response{{Text: "ok"}}}
+		d := NewDispatcher(router, &fakeClientFactory{client: client, typ: typ}, "", PayloadPolicyReject)
+
+		res, err := d.Chat(context.TODO(), req)
+		assert.NoError(t, err)
+		assert.True(t, res.Reproducible != nil && !*res.Reproducible)
+	}
+}
+
+func TestReproducible_OpenAIPayload(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = nil
+		assert.NoError(t, json.Unmarshal(data, &body))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "ok"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	imp := createOpenAIClient(&repo.Channel{Channels: model.Channels{Server: server.URL, Secret: "sk-test"}}, nil, 0)
+
+	_, err := imp.Chat(context.Background(), Request{
+		Model:       "gpt-4",
+		Messages:    Messages{{Role: RoleUser, Content: "hello"}},
+		Seed:        intPtr(42),
+		Temperature: float64Ptr(0),
+	})
+	assert.NoError(t, err)
+
+	// temperature 为 0 时也需要明确发送，否则服务端使用默认值
+	temperature, ok := body["temperature"]
+	assert.True(t, ok)
+	assert.EqualValues(t, 0, temperature)
+	assert.EqualValues(t, 42, body["seed"])
+
+	// 未指定时不发送
+	_, err = imp.Chat(context.Background(), Request{
+		Model:    "gpt-4",
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+	})
+	assert.NoError(t, err)
+
+	_, ok = body["temperature"]
+	assert.False(t, ok)
+	_, ok = body["seed"]
+	assert.False(t, ok)
+}
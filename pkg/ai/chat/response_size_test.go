@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
+	"github.com/mylxsw/aidea-server/pkg/ai/bodylimit"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// oversizedResponseServer 返回超过 1MB 的响应：/ok 为失控的生成内容，/error 为 HTML 错误页面
+func oversizedResponseServer() *httptest.Server {
+	content := strings.Repeat("a", 2*1024*1024)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/error") {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("<html>" + content + "</html>"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "` + content + `"}, "finish_reason": "stop"}]}`))
+	}))
+}
+
+func TestChat_OversizedResponse(t *testing.T) {
+	server := oversizedResponseServer()
+	defer server.Close()
+
+	req := Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+
+	// 渠道配置的限制（1MB）优先于默认值
+	ch := &repo.Channel{Channels: model.Channels{Server: server.URL + "/ok", Secret: "sk-test"}, Meta: repo.ChannelMeta{MaxResponseSize: 1}}
+	_, err := createOpenAIClient(ch, nil, 0).Chat(context.Background(), req)
+	assert.True(t, errors.Is(err, bodylimit.ErrResponseTooLarge))
+	assert.True(t, len(ErrorDetail(err)) < 1024)
+
+	ch.Server = server.URL + "/error"
+	_, err = createOpenAIClient(ch, nil, 0).Chat(context.Background(), req)
+	assert.True(t, len(ErrorDetail(err)) < 1024)
+
+	// 未超过限制
+	ch.Server = server.URL + "/ok"
+	ch.Meta.MaxResponseSize = 0
+	res, err := createOpenAIClient(ch, nil, 4*1024*1024).Chat(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*1024*1024, len(strings.TrimSpace(res.Text)))
+
+	// Anthropic 错误响应
+	client := &http.Client{Transport: bodylimit.NewTransport(nil, 1024*1024)}
+	_, err = NewAnthropicChat(anthropic.New(server.URL+"/error", "sk-test", client)).Chat(context.Background(), Request{Model: "claude-3-opus", Messages: req.Messages})
+	assert.True(t, errors.Is(err, bodylimit.ErrResponseTooLarge))
+	assert.True(t, len(ErrorDetail(err)) < 1024)
+}
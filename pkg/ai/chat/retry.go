@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/asteria/log"
+)
+
+// defaultRetryDelay 重试之前的等待时间
+const defaultRetryDelay = 500 * time.Millisecond
+
+// retryAllProviders 对所有服务提供商生效的重试规则使用的服务提供商名称
+const retryAllProviders = "*"
+
+// retryableStatusCodes 上游返回这些状态码时，认为是暂时性的错误，可以重试
+var retryableStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPatterns 按照服务提供商类型配置的可重试错误信息的匹配规则
+//
+// 部分服务提供商的暂时性错误只能通过错误信息识别（如 "server is busy, please try again"），
+// 匹配规则支持简单的通配符（* 匹配任意字符，? 匹配单个字符），匹配错误信息中的任意部分，不区分大小写
+type RetryPatterns map[string][]*regexp.Regexp
+
+// ParseRetryPatterns 解析可重试错误信息的匹配规则，每条规则的格式为 "服务提供商类型:匹配规则"，
+// 服务提供商类型为 * 时对所有服务提供商生效，如 "openai:*server is busy*"。没有任何规则时返回 nil（不重试）
+func ParseRetryPatterns(rules []string) (RetryPatterns, error) {
+	var patterns RetryPatterns
+	for _, rule := range rules {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		if patterns == nil {
+			patterns = make(RetryPatterns)
+		}
+
+		provider, pattern, ok := strings.Cut(rule, ":")
+		provider, pattern = strings.TrimSpace(provider), strings.TrimSpace(pattern)
+		if !ok || provider == "" || pattern == "" {
+			return nil, fmt.Errorf("invalid retry pattern %q, expected <provider>:<pattern>", rule)
+		}
+
+		patterns[provider] = append(patterns[provider], compileRetryPattern(pattern))
+	}
+
+	return patterns, nil
+}
+
+// compileRetryPattern 将通配符形式的匹配规则转换为正则表达式
+func compileRetryPattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?is)")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return regexp.MustCompile(expr.String())
+}
+
+// match 错误信息是否匹配服务提供商（或所有服务提供商）的任意一条规则
+func (p RetryPatterns) match(providerType string, message string) bool {
+	if message == "" {
+		return false
+	}
+
+	for _, provider := range []string{providerType, retryAllProviders} {
+		for _, pattern := range p[provider] {
+			if pattern.MatchString(message) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isRetryable 判断请求失败的错误是否为暂时性的错误，可以重试
+//
+// 用户取消请求、内容违规、上下文超长、账户额度用完等错误重试也不会成功，其它错误按照上游返回的状态码判断，
+// 状态码无法判断时，使用错误信息匹配服务提供商配置的规则
+func isRetryable(providerType string, err error, patterns RetryPatterns) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, target := range []error{context.Canceled, context.DeadlineExceeded, ErrContentFilter, ErrContextExceedLimit, ErrPayloadTooLarge, ErrQuotaExceeded} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		for _, code := range retryableStatusCodes {
+			if upstreamErr.StatusCode == code {
+				return true
+			}
+		}
+
+		if patterns.match(providerType, upstreamErr.Message) {
+			return true
+		}
+	}
+
+	return patterns.match(providerType, err.Error())
+}
+
+// retryChat 请求失败并且错误可以重试时（参考 isRetryable），重试一次
+//
+// 流式输出只在建立连接失败，或者输出任何内容之前返回错误响应时重试，已经输出内容之后的错误不再重试
+type retryChat struct {
+	imp          Chat
+	providerType string
+	patterns     RetryPatterns
+	delay        time.Duration
+}
+
+func (c *retryChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	res, err := c.imp.Chat(ctx, req)
+	if !c.retryable(ctx, req, err) {
+		return res, err
+	}
+
+	return c.imp.Chat(ctx, req)
+}
+
+func (c *retryChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	stream, err := c.imp.ChatStream(ctx, req)
+	if err != nil {
+		if !c.retryable(ctx, req, err) {
+			return nil, err
+		}
+
+		return c.imp.ChatStream(ctx, req)
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		// 输出内容之前的中间状态响应先缓存，重试时丢弃
+		var pending []Response
+		for data := range stream {
+			if data.Interim {
+				pending = append(pending, data)
+				continue
+			}
+
+			if data.ErrorCode != "" && data.Text == "" && c.retryable(ctx, req, streamError(data)) {
+				retry, err := c.imp.ChatStream(ctx, req)
+				if err == nil {
+					drainStream(stream)
+					// 切换为重试的响应流，提前退出时同样需要消费完
+					defer drainStream(retry)
+					stream, pending = retry, nil
+					break
+				}
+
+				// 重试失败时，返回原始的错误响应
+				log.F(log.M{"model": req.Model, "provider": c.providerType}).Errorf("retry chat stream failed: %v", err)
+			}
+
+			pending = append(pending, data)
+			break
+		}
+
+		for _, data := range pending {
+			if !send(data) {
+				return
+			}
+		}
+
+		for data := range stream {
+			if !send(data) {
+				return
+			}
+		}
+	}), nil
+}
+
+// retryable 错误是否可以重试，可以重试时等待 delay 之后返回（等待期间请求被取消时不再重试）
+func (c *retryChat) retryable(ctx context.Context, req Request, err error) bool {
+	if !isRetryable(c.providerType, err, c.patterns) {
+		return false
+	}
+
+	log.F(log.M{"model": req.Model, "provider": c.providerType}).Warningf("聊天请求失败，错误可以重试，重新请求：%v", err)
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(c.delay):
+		return true
+	}
+}
+
+// streamError 将流式响应中的错误转换为 error，优先使用上游返回的错误详情
+func streamError(data Response) error {
+	if data.Upstream != nil {
+		return data.Upstream
+	}
+
+	return errors.New(data.Error)
+}
+
+func (c *retryChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
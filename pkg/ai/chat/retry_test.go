@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// flakyChatClient 按照顺序返回预设的错误，预设的错误用完之后请求成功
+type flakyChatClient struct {
+	ChatTestClient
+	errs  []error
+	calls int
+}
+
+func (c *flakyChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return nil, c.errs[c.calls-1]
+	}
+
+	return &Response{Text: "ok", FinishReason: FinishReasonStop}, nil
+}
+
+func TestParseRetryPatterns(t *testing.T) {
+	patterns, err := ParseRetryPatterns([]string{"openai:*server is busy*", "*:rate limit?exceeded", " "})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(patterns["openai"]))
+	assert.Equal(t, 1, len(patterns["*"]))
+
+	// 没有配置任何规则时不重试
+	patterns, err = ParseRetryPatterns(nil)
+	assert.NoError(t, err)
+	assert.True(t, patterns == nil)
+
+	patterns, err = ParseRetryPatterns([]string{" ", ""})
+	assert.NoError(t, err)
+	assert.True(t, patterns == nil)
+
+	_, err = ParseRetryPatterns([]string{"server is busy"})
+	assert.True(t, err != nil)
+
+	_, err = ParseRetryPatterns([]string{"openai:"})
+	assert.True(t, err != nil)
+}
+
+func TestIsRetryable(t *testing.T) {
+	patterns, err := ParseRetryPatterns([]string{"openai:server is busy*try again", "*:rate limit?exceeded"})
+	assert.NoError(t, err)
+
+	// 错误信息匹配服务提供商的规则（匹配任意部分，不区分大小写）
+	assert.True(t, isRetryable(service.ProviderOpenAI, errors.New("error: Server is busy, please try again later"), patterns))
+	assert.False(t, isRetryable(service.ProviderOpenAI, errors.New("invalid api key"), patterns))
+	// 只对配置的服务提供商生效
+	assert.False(t, isRetryable(service.ProviderAnthropic, errors.New("server is busy, please try again"), patterns))
+	// * 对所有服务提供商生效
+	assert.True(t, isRetryable(service.ProviderAnthropic, errors.New("Rate limit exceeded"), patterns))
+
+	// 上游错误按照状态码判断，状态码无法判断时匹配上游返回的错误信息
+	assert.True(t, isRetryable(service.ProviderAnthropic, NewUpstreamError("anthropic", http.StatusBadGateway, "", "", "bad gateway", nil), nil))
+	assert.False(t, isRetryable(service.ProviderAnthropic, NewUpstreamError("anthropic", http.StatusBadRequest, "", "", "bad request", nil), nil))
+	assert.True(t, isRetryable(service.ProviderOpenAI, NewUpstreamError("openai", http.StatusBadRequest, "", "", "server is busy, try again", nil), patterns))
+
+	// 内容违规、用户取消等错误不重试
+	assert.False(t, isRetryable(service.ProviderOpenAI, fmt.Errorf("%w: server is busy, try again", ErrContentFilter), patterns))
+	assert.False(t, isRetryable(service.ProviderOpenAI, context.Canceled, patterns))
+
+	// 账户额度用完时，即使匹配重试规则也不重试
+	quotaErr := NewUpstreamError("openai", http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", "Rate limit exceeded: you exceeded your current quota", nil)
+	assert.False(t, isRetryable(service.ProviderOpenAI, quotaErr, patterns))
+}
+
+func TestDispatcher_RetryErrorPatterns(t *testing.T) {
+	patterns, err := ParseRetryPatterns([]string{"openai:*server is busy*"})
+	assert.NoError(t, err)
+
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+	newDispatcher := func(client Chat) *Dispatcher {
+		d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+		d.retryPatterns = patterns
+		d.retryDelay = 0
+		return d
+	}
+
+	req := Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+
+	// 错误信息匹配时重试
+	client := &flakyChatClient{errs: []error{errors.New("Server is busy, please try again later")}}
+	res, err := newDispatcher(client).Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.Equal(t, 2, client.calls)
+
+	// 错误信息不匹配时不重试
+	client = &flakyChatClient{errs: []error{errors.New("invalid api key")}}
+	_, err = newDispatcher(client).Chat(context.TODO(), req)
+	assert.True(t, err != nil)
+	assert.Equal(t, 1, client.calls)
+
+	// 只重试一次
+	busy := errors.New("server is busy")
+	client = &flakyChatClient{errs: []error{busy, busy}}
+	_, err = newDispatcher(client).Chat(context.TODO(), req)
+	assert.True(t, errors.Is(err, busy))
+	assert.Equal(t, 2, client.calls)
+
+	// 流式输出在输出任何内容之前返回错误响应时重试
+	stream := &scriptedStreamClient{scripts: [][]Response{
+		{{ErrorCode: ErrCodeUpstream, Error: "Server is busy, please try again later"}},
+		{{Text: "ok"}, {FinishReason: FinishReasonStop}},
+	}}
+	ch, err := newDispatcher(stream).ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, ch)
+	assert.Equal(t, 2, len(stream.requests))
+	assert.Equal(t, "ok", responses[0].Text)
+
+	// 错误响应不匹配时不重试
+	stream = &scriptedStreamClient{scripts: [][]Response{
+		{{ErrorCode: ErrCodeUpstream, Error: "invalid api key"}},
+	}}
+	ch, err = newDispatcher(stream).ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	responses = assertFinishReasonConformance(t, ch)
+	assert.Equal(t, 1, len(stream.requests))
+	assert.Equal(t, "invalid api key", responses[0].Error)
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Role 消息角色
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+// AllowedRoles 所有支持的消息角色
+var AllowedRoles = []Role{RoleSystem, RoleUser, RoleAssistant, RoleTool}
+
+// InvalidRoleError 消息角色不合法
+type InvalidRoleError struct {
+	Role string
+}
+
+func (e InvalidRoleError) Error() string {
+	allowed := make([]string, 0, len(AllowedRoles))
+	for _, r := range AllowedRoles {
+		allowed = append(allowed, string(r))
+	}
+
+	return fmt.Sprintf("invalid role %q, allowed values: %s", e.Role, strings.Join(allowed, ", "))
+}
+
+// ParseRole 解析消息角色，忽略大小写，角色不合法时返回 InvalidRoleError
+func ParseRole(role string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(role)))
+	for _, allowed := range AllowedRoles {
+		if r == allowed {
+			return r, nil
+		}
+	}
+
+	return "", InvalidRoleError{Role: role}
+}
+
+func (r Role) String() string {
+	return string(r)
+}
+
+// UnmarshalJSON 请求解码时对角色进行规范化（转为小写）并校验
+func (r *Role) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	role, err := ParseRole(raw)
+	if err != nil {
+		return err
+	}
+
+	*r = role
+	return nil
+}
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestMessage_RoleDecode(t *testing.T) {
+	var msgs Messages
+	assert.NoError(t, json.Unmarshal([]byte(`[{"role":"System","content":"hi"},{"role":" USER ","content":"hello"},{"role":"assistant","content":"ok"}]`), &msgs))
+
+	assert.Equal(t, RoleSystem, msgs[0].Role)
+	assert.Equal(t, RoleUser, msgs[1].Role)
+	assert.Equal(t, RoleAssistant, msgs[2].Role)
+
+	// 输出时仍然使用小写字符串
+	data, err := json.Marshal(msgs[1])
+	assert.NoError(t, err)
+	assert.Equal(t, `{"role":"user","content":"hello"}`, string(data))
+}
+
+func TestMessage_RoleDecodeInvalid(t *testing.T) {
+	var msg Message
+	err := json.Unmarshal([]byte(`{"role":"bot","content":"hello"}`), &msg)
+
+	var roleErr InvalidRoleError
+	assert.True(t, errors.As(err, &roleErr))
+	assert.Equal(t, "bot", roleErr.Role)
+	assert.Equal(t, `invalid role "bot", allowed values: system, user, assistant, tool`, roleErr.Error())
+}
@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/asteria/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrTemporarilyUnavailable 模型信息查询失败（如数据库不可用），并且没有缓存可以使用
+var ErrTemporarilyUnavailable = errors.New("服务暂时不可用，请稍后再试")
+
+// ModelQuerier 查询模型信息
+type ModelQuerier interface {
+	// QueryModel 查询模型信息，模型不存在时返回 repo.ErrNotFound，查询失败时返回其它错误
+	QueryModel(ctx context.Context, modelID string) (*repo.Model, error)
+}
+
+// ModelRouter 模型路由，负责查询模型信息，并为请求选择服务提供商
+type ModelRouter interface {
+	// Model 查询模型信息，模型不存在时，返回使用 OpenAI 作为服务提供商的受限模型，
+	// 查询失败并且没有缓存时返回 ErrTemporarilyUnavailable
+	Model(ctx context.Context, modelID string) (repo.Model, error)
+	// SelectProvider 为模型选择本次请求使用的服务提供商（主备切换等）
+	SelectProvider(ctx context.Context, mod repo.Model) repo.ModelProvider
+}
+
+type modelRouter struct {
+	models ModelQuerier
+	// failures 模型信息查询失败的次数统计，按照处理结果（stale/unavailable）区分，为 nil 时不统计
+	failures *prometheus.CounterVec
+
+	lock sync.RWMutex
+	// cache 最近一次成功查询到的模型信息，查询失败时使用（即使已经过时）
+	cache map[string]repo.Model
+}
+
+func NewModelRouter(svc *service.Service) ModelRouter {
+	return newModelRouter(svc.Chat, newModelQueryFailureCounter(prometheus.DefaultRegisterer))
+}
+
+func newModelRouter(models ModelQuerier, failures *prometheus.CounterVec) *modelRouter {
+	return &modelRouter{models: models, failures: failures, cache: make(map[string]repo.Model)}
+}
+
+// newModelQueryFailureCounter 创建模型信息查询失败的次数统计，并注册到 registerer
+func newModelQueryFailureCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_model_query_failure_count",
+		Help:      "model lookups failed because of repository errors",
+	}, []string{"result"})
+
+	if err := registerer.Register(counter); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing
+			}
+		}
+
+		log.Errorf("register model query metrics failed: %v", err)
+	}
+
+	return counter
+}
+
+func (r *modelRouter) Model(ctx context.Context, modelID string) (repo.Model, error) {
+	mod, err := r.models.QueryModel(ctx, modelID)
+	if err == nil {
+		r.lock.Lock()
+		r.cache[modelID] = *mod
+		r.lock.Unlock()
+
+		return *mod, nil
+	}
+
+	if errors.Is(err, repo.ErrNotFound) {
+		return restrictedModel(modelID), nil
+	}
+
+	// 查询失败时不能使用默认的受限模型，否则会将请求错误地转发给 OpenAI，同时掩盖数据库的故障
+	r.lock.RLock()
+	cached, ok := r.cache[modelID]
+	r.lock.RUnlock()
+
+	result := "unavailable"
+	if ok {
+		result = "stale"
+	}
+
+	if r.failures != nil {
+		r.failures.WithLabelValues(result).Inc()
+	}
+
+	log.F(log.M{"model": modelID, "result": result}).Errorf("query model failed: %v", err)
+
+	if !ok {
+		return repo.Model{}, ErrTemporarilyUnavailable
+	}
+
+	return cached, nil
+}
+
+// restrictedModel 模型不存在时使用的受限模型，使用 OpenAI 作为服务提供商
+func restrictedModel(modelID string) repo.Model {
+	return repo.Model{
+		Providers: []repo.ModelProvider{
+			{Name: service.ProviderOpenAI},
+		},
+		Models: model.Models{
+			ModelId: modelID,
+		},
+		Meta: repo.ModelMeta{
+			Restricted: true,
+			MaxContext: 4000,
+		},
+	}
+}
+
+func (r *modelRouter) SelectProvider(ctx context.Context, mod repo.Model) repo.ModelProvider {
+	return mod.SelectProvider(ctx)
+}
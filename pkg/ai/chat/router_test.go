@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/control"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeModelQuerier 使用固定的模型列表模拟模型查询，err 不为空时模拟数据库故障
+type fakeModelQuerier struct {
+	models map[string]*repo.Model
+	err    error
+}
+
+func (q *fakeModelQuerier) QueryModel(ctx context.Context, modelID string) (*repo.Model, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	if mod, ok := q.models[modelID]; ok {
+		return mod, nil
+	}
+
+	return nil, repo.ErrNotFound
+}
+
+func TestModelRouter_Model(t *testing.T) {
+	router := newModelRouter(&fakeModelQuerier{models: map[string]*repo.Model{
+		"gpt-4": {
+			Models:    model.Models{ModelId: "gpt-4"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderOpenAI}},
+			Meta:      repo.ModelMeta{MaxContext: 8000},
+		},
+	}}, nil)
+
+	mod, err := router.Model(context.TODO(), "gpt-4")
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4", mod.ModelId)
+	assert.Equal(t, 8000, mod.Meta.MaxContext)
+	assert.False(t, mod.Meta.Restricted)
+
+	// 模型不存在时，使用 OpenAI 作为服务提供商的受限模型
+	mod, err = router.Model(context.TODO(), "not-exist")
+	assert.NoError(t, err)
+	assert.Equal(t, "not-exist", mod.ModelId)
+	assert.True(t, mod.Meta.Restricted)
+	assert.Equal(t, 4000, mod.Meta.MaxContext)
+	assert.Equal(t, 1, len(mod.Providers))
+	assert.Equal(t, service.ProviderOpenAI, mod.Providers[0].Name)
+}
+
+func TestModelRouter_ModelRepoFailure(t *testing.T) {
+	querier := &fakeModelQuerier{models: map[string]*repo.Model{
+		"claude": {
+			Models:    model.Models{ModelId: "claude"},
+			Providers: []repo.ModelProvider{{Name: service.ProviderAnthropic}},
+		},
+	}}
+	failures := newModelQueryFailureCounter(prometheus.NewRegistry())
+	router := newModelRouter(querier, failures)
+
+	// 预热缓存
+	_, err := router.Model(context.TODO(), "claude")
+	assert.NoError(t, err)
+
+	querier.err = errors.New("connection refused")
+
+	// 数据库故障时使用缓存的模型信息
+	mod, err := router.Model(context.TODO(), "claude")
+	assert.NoError(t, err)
+	assert.Equal(t, service.ProviderAnthropic, mod.Providers[0].Name)
+	assert.EqualValues(t, 1, testutil.ToFloat64(failures.WithLabelValues("stale")))
+
+	// 没有缓存时返回错误，不使用默认的受限模型
+	_, err = router.Model(context.TODO(), "gpt-4")
+	assert.True(t, errors.Is(err, ErrTemporarilyUnavailable))
+	assert.EqualValues(t, 1, testutil.ToFloat64(failures.WithLabelValues("unavailable")))
+
+	// 请求分发时同样返回错误，不会请求上游
+	client := &streamChatClient{}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	_, err = d.ChatStream(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.True(t, errors.Is(err, ErrTemporarilyUnavailable))
+	assert.Equal(t, 0, len(client.requests))
+}
+
+func TestModelRouter_SelectProvider(t *testing.T) {
+	router := &modelRouter{}
+
+	mod := repo.Model{Providers: []repo.ModelProvider{{Name: service.ProviderOpenAI}, {Name: service.ProviderAnthropic}}}
+	assert.Equal(t, service.ProviderOpenAI, router.SelectProvider(context.TODO(), mod).Name)
+
+	// 优先使用备用服务提供商
+	ctx := control.NewContext(context.TODO(), &control.Control{PreferBackup: true})
+	assert.Equal(t, service.ProviderAnthropic, router.SelectProvider(ctx, mod).Name)
+
+	// 没有配置服务提供商时，使用 OpenAI
+	assert.Equal(t, service.ProviderOpenAI, router.SelectProvider(context.TODO(), repo.Model{}).Name)
+}
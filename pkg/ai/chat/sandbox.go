@@ -0,0 +1,148 @@
+package chat
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"gopkg.in/yaml.v3"
+)
+
+// SandboxResponse 沙箱模式（演示账号）的预置回答
+type SandboxResponse struct {
+	// Models 适用的模型（通配符，* 匹配任意字符，? 匹配单个字符），为空时适用于所有模型
+	Models []string `json:"models,omitempty" yaml:"models,omitempty"`
+	// Responses 预置的回答，相同的问题总是返回同一条回答，{model} 替换为请求的模型
+	Responses []string `json:"responses" yaml:"responses"`
+}
+
+// defaultSandboxResponses 没有配置预置回答时使用的默认回答
+var defaultSandboxResponses = []SandboxResponse{
+	{
+		Responses: []string{
+			"你好！我是 {model}，很高兴为你服务。\n\n这是一个演示账号，当前的回答为预置的示例内容，不会消耗智慧果。正式使用时，我可以帮你：\n\n1. **回答问题**：涵盖科学、历史、技术等各个领域\n2. **写作辅助**：撰写文章、邮件、总结和翻译\n3. **编程帮助**：编写、解释和调试代码\n\n有什么我可以帮你的吗？",
+			"这是一个很好的问题！\n\n在演示模式下，{model} 会返回预置的示例回答。正式使用时，你会得到针对问题的详细解答，例如：\n\n- 对问题背景的分析\n- 分步骤的解决方案\n- 相关的注意事项与延伸阅读\n\n欢迎注册正式账号体验完整的功能。",
+			"下面是一段示例代码，展示了 {model} 对代码的格式化输出：\n\n```go\npackage main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"Hello, AIdea!\")\n}\n```\n\n这是演示账号返回的预置内容，正式使用时会根据你的问题生成代码并解释其中的关键步骤。",
+		},
+	},
+}
+
+// LoadSandboxResponses 从 YAML 文件中加载沙箱模式的预置回答，文件内容为 SandboxResponse 的列表
+func LoadSandboxResponses(file string) ([]SandboxResponse, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []SandboxResponse
+	if err := yaml.Unmarshal(data, &responses); err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+// sandboxChunkRunes 沙箱模式流式输出时每个分片的字符数量
+const sandboxChunkRunes = 4
+
+// SandboxChat 沙箱模式（演示账号）使用的服务提供商：返回与模型匹配的预置回答，不请求任何服务提供商，
+// 流式输出时按照固定的间隔分片返回，模拟真实的输出速度。响应中标记了 Response.Sandbox，客户端可以据此为演示内容添加水印
+type SandboxChat struct {
+	responses []SandboxResponse
+	// interval 流式输出时每个分片之间的间隔
+	interval time.Duration
+}
+
+// NewSandboxChat 创建沙箱模式使用的服务提供商，responses 为空时使用默认的预置回答
+func NewSandboxChat(responses []SandboxResponse, interval time.Duration) *SandboxChat {
+	if len(responses) == 0 {
+		responses = defaultSandboxResponses
+	}
+
+	return &SandboxChat{responses: responses, interval: interval}
+}
+
+// reply 选择与模型匹配的预置回答（使用第一个匹配的规则），根据最后一条用户消息选择规则中的一条回答
+func (s *SandboxChat) reply(req Request) string {
+	candidates := defaultSandboxResponses[0].Responses
+	for _, item := range s.responses {
+		if len(item.Responses) > 0 && repo.ModelAllowed(item.Models, req.Model) {
+			candidates = item.Responses
+			break
+		}
+	}
+
+	var question string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == RoleUser {
+			question = req.Messages[i].Text()
+			break
+		}
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(question))
+
+	return strings.ReplaceAll(candidates[h.Sum32()%uint32(len(candidates))], "{model}", req.Model)
+}
+
+// InputTokens 估算输入的 Token 数量，沙箱模式不加载 Token 编码（编码文件不存在时需要联网下载）
+func (s *SandboxChat) InputTokens(req Request) int {
+	var tokens int
+	for _, msg := range req.Messages {
+		tokens += estimateTextTokens(msg.Text())
+	}
+
+	return tokens
+}
+
+func (s *SandboxChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	text := s.reply(req)
+	return &Response{
+		Text:         text,
+		FinishReason: "stop",
+		InputTokens:  s.InputTokens(req),
+		OutputTokens: estimateTextTokens(text),
+		Sandbox:      true,
+	}, nil
+}
+
+func (s *SandboxChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	text := []rune(s.reply(req))
+	inputTokens := s.InputTokens(req)
+
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+
+		for i := 0; i < len(text); i += sandboxChunkRunes {
+			if i > 0 && s.interval > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(s.interval):
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case res <- Response{Text: string(text[i:min(i+sandboxChunkRunes, len(text))]), Sandbox: i == 0}:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case res <- Response{FinishReason: "stop", InputTokens: inputTokens, OutputTokens: estimateTextTokens(string(text)), Sandbox: true}:
+		}
+	}()
+
+	return res, nil
+}
+
+func (s *SandboxChat) MaxContextLength(model string) int {
+	return 128000
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestSandboxChat(t *testing.T) {
+	s := NewSandboxChat(nil, 5*time.Millisecond)
+	req := Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "你好"}}}
+
+	res, err := s.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.True(t, res.Sandbox)
+	assert.Equal(t, "stop", res.FinishReason)
+	assert.True(t, strings.Contains(res.Text, "gpt-4o"))
+	assert.True(t, res.InputTokens > 0 && res.OutputTokens > 0)
+
+	startAt := time.Now()
+	stream, err := s.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	var responses []Response
+	var text string
+	for data := range stream {
+		responses = append(responses, data)
+		text += data.Text
+	}
+
+	// 流式输出的内容与非流式相同，第一个响应和结束响应中标记为沙箱模式
+	assert.Equal(t, res.Text, text)
+	assert.True(t, responses[0].Sandbox)
+	assert.False(t, responses[1].Sandbox)
+	last := responses[len(responses)-1]
+	assert.True(t, last.Sandbox)
+	assert.Equal(t, "stop", last.FinishReason)
+	assert.Equal(t, res.OutputTokens, last.OutputTokens)
+
+	// 按照固定的间隔输出
+	assert.True(t, time.Since(startAt) >= time.Duration(len(responses)-2)*5*time.Millisecond)
+
+	// 相同的问题总是返回相同的回答
+	again, err := s.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Text, again.Text)
+}
+
+func TestSandboxChat_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	stream, err := NewSandboxChat(nil, time.Hour).ChatStream(ctx, Request{Model: "gpt-4o"})
+	assert.NoError(t, err)
+
+	<-stream
+	cancel()
+
+	// 取消之后不再输出
+	for range stream {
+		t.Fatal("unexpected response after cancel")
+	}
+}
+
+func TestLoadSandboxResponses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sandbox.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+- models: ["claude-*"]
+  responses: ["我是 {model}，来自 Anthropic"]
+- models: ["gpt-4*", "o?-*"]
+  responses: ["我是 {model}，来自 OpenAI"]
+- responses: ["默认回答"]
+`), 0644))
+
+	responses, err := LoadSandboxResponses(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(responses))
+
+	s := NewSandboxChat(responses, 0)
+	assert.Equal(t, "我是 claude-3-opus，来自 Anthropic", s.reply(Request{Model: "claude-3-opus"}))
+	assert.Equal(t, "我是 o1-mini，来自 OpenAI", s.reply(Request{Model: "o1-mini"}))
+	assert.Equal(t, "默认回答", s.reply(Request{Model: "qwen-max"}))
+
+	_, err = LoadSandboxResponses(filepath.Join(t.TempDir(), "not-exist.yaml"))
+	assert.True(t, err != nil)
+}
@@ -0,0 +1,280 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// SanitizePolicy 输出内容中原始 HTML 标签的处理策略
+//
+// 客户端将模型的输出作为 Markdown（包括其中的 HTML）渲染，被提示词注入的回答中可能包含 <script> 标签、
+// javascript: 链接等内容。开启之后会处理代码块之外的 HTML 标签，并替换链接和图片中危险的地址
+type SanitizePolicy string
+
+const (
+	// SanitizeEscape 将 HTML 标签转义为普通文本（如 &lt;script&gt;）
+	SanitizeEscape SanitizePolicy = "escape"
+	// SanitizeStrip 去掉 HTML 标签，只保留标签之间的文本
+	SanitizeStrip SanitizePolicy = "strip"
+)
+
+const (
+	// sanitizedURL 危险的链接地址替换后的地址
+	sanitizedURL = "#"
+	// sanitizeMaxPendingBytes 流式输出时单行中等待后续内容的最大字节数，超过时按照当前内容处理，避免无限缓存
+	sanitizeMaxPendingBytes = 4096
+)
+
+var (
+	// htmlTagPattern 完整的 HTML 标签（开始、结束标签）、注释以及声明
+	htmlTagPattern = regexp.MustCompile(`^(?:</?[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?>|<!--.*?-->|<[!?][^<>]*>)`)
+	// autolinkPattern Markdown 的自动链接，如 <https://example.com>
+	autolinkPattern = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}):[^\s<>]*>`)
+	// linkDefinitionPattern 行首的链接引用定义，如 [id]: https://example.com
+	linkDefinitionPattern = regexp.MustCompile(`^(\s{0,3}\[[^\]]+\]:\s*)(<[^>]*>|\S+)(.*)$`)
+)
+
+// Validate 校验处理策略是否合法，为空时不处理
+func (p SanitizePolicy) Validate() error {
+	switch p {
+	case "", SanitizeEscape, SanitizeStrip:
+		return nil
+	}
+
+	return fmt.Errorf("invalid output sanitize policy: %s", p)
+}
+
+// unsafeURL 链接地址是否使用了可以执行脚本或者内嵌内容的协议（javascript:/vbscript:/data:）
+//
+// 渲染器会解码地址中的 HTML 实体，浏览器会忽略协议中的空白和控制字符，判断之前先做同样的处理
+func unsafeURL(u string) bool {
+	u = html.UnescapeString(strings.Trim(u, "<>"))
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	u = strings.ToLower(u)
+
+	return strings.HasPrefix(u, "javascript:") || strings.HasPrefix(u, "vbscript:") || strings.HasPrefix(u, "data:")
+}
+
+// sanitizeMarkdown 处理文本中代码块之外的 HTML 标签、危险的链接地址以及过长的链接文本，代码块、行内代码和公式保持不变
+func sanitizeMarkdown(text string, policy SanitizePolicy, maxLinkText int) string {
+	if policy == "" {
+		return text
+	}
+
+	return transformMarkdown(text, &markdownSanitizer{policy: policy, maxLinkText: maxLinkText})
+}
+
+// markdownSanitizer 处理代码块、行内代码和公式之外的 HTML 标签和链接
+type markdownSanitizer struct {
+	policy SanitizePolicy
+	// maxLinkText 链接文本的最大字符数，为 0 时不限制
+	maxLinkText int
+}
+
+func (s *markdownSanitizer) fence(line string) (string, bool) {
+	return line, true
+}
+
+func (s *markdownSanitizer) span(sp textSpan) string {
+	return sp.String()
+}
+
+// text 处理标签、链接以及行首的链接引用定义，遇到需要后续内容才能确定的标签或者链接时等待后续内容
+func (s *markdownSanitizer) text(text string, lineStart, complete bool) (string, int, bool) {
+	if lineStart {
+		// 行首可能是链接引用定义，需要等待整行结束
+		if trimmed := strings.TrimLeft(text, " \t"); !complete && strings.HasPrefix(trimmed, "[") {
+			return "", 0, true
+		}
+
+		if m := linkDefinitionPattern.FindStringSubmatch(text); m != nil {
+			dest := m[2]
+			if unsafeURL(dest) {
+				dest = sanitizedURL
+			}
+
+			return m[1] + dest + applyInline(s, m[3]), len(text), false
+		}
+	}
+
+	var out strings.Builder
+	var n int
+	var ok bool
+	switch c := text[0]; {
+	case c == '<':
+		n, ok = s.tag(text, complete, &out)
+	case c == '[' || (c == '!' && (len(text) == 1 || text[1] == '[')):
+		n, ok = s.link(text, complete, &out)
+	default:
+		n = plainRun(text, "<[!")
+		return text[:n], n, false
+	}
+
+	if !ok {
+		return "", 0, true
+	}
+
+	return out.String(), n, false
+}
+
+// tag 处理以 < 开头的内容（HTML 标签或者自动链接），返回处理的字节数，需要等待后续内容时 ok 为 false
+func (s *markdownSanitizer) tag(text string, complete bool, out *strings.Builder) (int, bool) {
+	// 标签或者自动链接在 > 处结束，遇到 > 之前无法确定
+	if !complete && len(text) < sanitizeMaxPendingBytes {
+		if !strings.Contains(text, ">") || (strings.HasPrefix(text, "<!--") && !strings.Contains(text, "-->")) {
+			return 0, false
+		}
+	}
+
+	if m := autolinkPattern.FindString(text); m != "" {
+		if unsafeURL(m) {
+			out.WriteString(html.EscapeString(m))
+		} else {
+			out.WriteString(m)
+		}
+
+		return len(m), true
+	}
+
+	if m := htmlTagPattern.FindString(text); m != "" {
+		if s.policy == SanitizeEscape {
+			out.WriteString("&lt;" + m[1:len(m)-1] + "&gt;")
+		}
+
+		return len(m), true
+	}
+
+	// 不是完整的标签，只转义 < 避免被解析为 HTML（如跨行的标签）
+	if len(text) > 1 && (isASCIILetter(text[1]) || text[1] == '/' || text[1] == '!' || text[1] == '?') {
+		out.WriteString("&lt;")
+	} else {
+		out.WriteByte('<')
+	}
+
+	return 1, true
+}
+
+// link 处理以 [ 或者 ![ 开头的内容（链接或者图片），返回处理的字节数，需要等待后续内容时 ok 为 false
+func (s *markdownSanitizer) link(text string, complete bool, out *strings.Builder) (int, bool) {
+	start := 1
+	if text[0] == '!' {
+		start = 2
+	}
+
+	if start > len(text) {
+		if !complete {
+			return 0, false
+		}
+
+		out.WriteString(text[:1])
+		return 1, true
+	}
+
+	literal := func() (int, bool) {
+		out.WriteString(text[:start])
+		return start, true
+	}
+	wait := func() (int, bool) {
+		if complete || len(text) >= sanitizeMaxPendingBytes {
+			return literal()
+		}
+
+		return 0, false
+	}
+
+	// 链接文本的结束位置，支持嵌套的方括号（如链接中的图片）
+	closeText := matchBracket(text, start-1, '[', ']')
+	if closeText < 0 {
+		return wait()
+	}
+
+	if closeText+1 >= len(text) {
+		return wait()
+	}
+
+	if text[closeText+1] != '(' {
+		return literal()
+	}
+
+	closeURL := matchBracket(text, closeText+1, '(', ')')
+	if closeURL < 0 {
+		return wait()
+	}
+
+	// 先截断再处理，截断之后不完整的标签或者链接会按照普通文本处理
+	label := applyInline(s, truncateLinkText(text[start:closeText], s.maxLinkText))
+
+	dest, title := splitLinkDestination(text[closeText+2 : closeURL])
+	if unsafeURL(dest) {
+		dest = sanitizedURL
+	}
+
+	out.WriteString(text[:start] + label + "](" + dest + title + ")")
+	return closeURL + 1, true
+}
+
+// matchBracket 查找 open 位置的开始括号对应的结束括号的位置，忽略转义的括号，找不到时返回 -1
+func matchBracket(text string, open int, left, right byte) int {
+	depth := 0
+	for i := open; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case left:
+			depth++
+		case right:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// splitLinkDestination 拆分链接括号中的地址和标题（包括地址之前的空白）
+func splitLinkDestination(s string) (string, string) {
+	trimmed := strings.TrimLeft(s, " \t")
+	lead := s[:len(s)-len(trimmed)]
+	if strings.HasPrefix(trimmed, "<") {
+		if end := strings.Index(trimmed, ">"); end > 0 {
+			return lead + trimmed[:end+1], trimmed[end+1:]
+		}
+	}
+
+	if end := strings.IndexAny(trimmed, " \t"); end >= 0 {
+		return lead + trimmed[:end], trimmed[end:]
+	}
+
+	return s, ""
+}
+
+// truncateLinkText 限制链接文本的最大字符数，超过时截断并添加省略号
+func truncateLinkText(text string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	return string([]rune(text)[:max]) + "…"
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// sanitizeStream 处理流式响应中代码块之外的 HTML 标签和危险的链接地址
+//
+// 标签、链接可能被拆分到多个分片中，无法确定的内容会缓存到后续内容到达之后再处理（参考 transformMarkdownStream）
+func sanitizeStream(ctx context.Context, stream <-chan Response, policy SanitizePolicy, maxLinkText int) <-chan Response {
+	return transformMarkdownStream(ctx, stream, &markdownSanitizer{policy: policy, maxLinkText: maxLinkText})
+}
@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+const unsafeMarkdownText = "你好<script>alert(1)</script>，<b>加粗</b> a < b\n" +
+	"[点我](javascript:alert(1)) 和 ![图](data:image/svg+xml;base64,PHN2Zz4= \"标题\")\n" +
+	"[编码](&#106;avascript:alert(1)) [正常](https://example.com/a_(b)) <javascript:alert(1)> <https://example.com>\n" +
+	"<img src=x onerror=alert(1)> <!-- 注释 --> `<script>` 和 \\<b>\n" +
+	"[ref]: javascript:alert(1)\n" +
+	"```html\n<script>alert(1)</script>\n[x](javascript:alert(1))\n```\n" +
+	"[很长很长的链接文本](https://example.com) 结束"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	assert.Equal(t, "你好&lt;script&gt;alert(1)&lt;/script&gt;，&lt;b&gt;加粗&lt;/b&gt; a < b\n"+
+		"[点我](#) 和 ![图](# \"标题\")\n"+
+		"[编码](#) [正常](https://example.com/a_(b)) &lt;javascript:alert(1)&gt; <https://example.com>\n"+
+		"&lt;img src=x onerror=alert(1)&gt; &lt;!-- 注释 --&gt; `<script>` 和 \\<b>\n"+
+		"[ref]: #\n"+
+		"```html\n<script>alert(1)</script>\n[x](javascript:alert(1))\n```\n"+
+		"[很长很长的…](https://example.com) 结束", sanitizeMarkdown(unsafeMarkdownText, SanitizeEscape, 5))
+
+	assert.Equal(t, "你好alert(1)，加粗 a < b\n"+
+		"[点我](#) 和 ![图](# \"标题\")\n"+
+		"[编码](#) [正常](https://example.com/a_(b)) &lt;javascript:alert(1)&gt; <https://example.com>\n"+
+		"  `<script>` 和 \\<b>\n"+
+		"[ref]: #\n"+
+		"```html\n<script>alert(1)</script>\n[x](javascript:alert(1))\n```\n"+
+		"[很长很长的链接文本](https://example.com) 结束", sanitizeMarkdown(unsafeMarkdownText, SanitizeStrip, 0))
+
+	assert.Equal(t, unsafeMarkdownText, sanitizeMarkdown(unsafeMarkdownText, "", 5))
+}
+
+func TestSanitizeStream(t *testing.T) {
+	for _, policy := range []SanitizePolicy{SanitizeEscape, SanitizeStrip} {
+		expected := sanitizeMarkdown(unsafeMarkdownText, policy, 5)
+
+		// 在每一个位置拆分为两个分片，覆盖标签、链接、代码块围栏被拆分的情况
+		for offset := 1; offset < len(unsafeMarkdownText); offset++ {
+			res := collectStream(sanitizeStream(context.TODO(), sliceStream([]Response{
+				{Text: unsafeMarkdownText[:offset]},
+				{Text: unsafeMarkdownText[offset:]},
+				{FinishReason: FinishReasonStop},
+			}, 0), policy, 5))
+			assert.Equal(t, expected, concatText(res))
+			assert.Equal(t, FinishReasonStop, res[len(res)-1].FinishReason)
+		}
+
+		// 按照不同的长度拆分为多个分片
+		for size := 1; size <= 8; size++ {
+			var responses []Response
+			for i := 0; i < len(unsafeMarkdownText); i += size {
+				responses = append(responses, Response{Text: unsafeMarkdownText[i:min(i+size, len(unsafeMarkdownText))]})
+			}
+
+			res := collectStream(sanitizeStream(context.TODO(), sliceStream(responses, 0), policy, 5))
+			assert.Equal(t, expected, concatText(res))
+		}
+	}
+}
+
+func TestSanitizeStream_HoldUntilComplete(t *testing.T) {
+	res := collectStream(sanitizeStream(context.TODO(), sliceStream([]Response{
+		{Text: "看 <scr"}, {Text: "ipt>x"}, {Text: " [a](java"}, {Text: "script:1) 完"}, {Interim: true, Warning: "warning"},
+	}, 0), SanitizeEscape, 0))
+	assert.EqualValues(t, []Response{
+		{Text: "看 "}, {Text: "&lt;script&gt;x"}, {Text: " "}, {Text: "[a](#) 完"}, {Interim: true, Warning: "warning"},
+	}, res)
+}
+
+func TestSanitizePolicy_Validate(t *testing.T) {
+	assert.NoError(t, SanitizePolicy("").Validate())
+	assert.NoError(t, SanitizeEscape.Validate())
+	assert.NoError(t, SanitizeStrip.Validate())
+	assert.True(t, SanitizePolicy("remove").Validate() != nil)
+}
@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/secret"
+)
+
+// ChannelSecrets 渠道密钥的解密，解密结果按照渠道缓存，避免每次请求都需要解密（KMS 时需要远程调用）
+//
+// 缓存同时记录密文，渠道密钥更新后密文变化，缓存自动失效
+type ChannelSecrets struct {
+	envelope *secret.Envelope
+
+	lock  sync.RWMutex
+	cache map[int64]decryptedSecret
+}
+
+type decryptedSecret struct {
+	ciphertext string
+	plaintext  string
+}
+
+func NewChannelSecrets(envelope *secret.Envelope) *ChannelSecrets {
+	return &ChannelSecrets{envelope: envelope, cache: make(map[int64]decryptedSecret)}
+}
+
+// Resolve 返回密钥为明文的渠道信息，不修改原始的渠道信息
+func (s *ChannelSecrets) Resolve(ctx context.Context, ch *repo.Channel) (*repo.Channel, error) {
+	if s == nil || !secret.IsEncrypted(ch.Secret) {
+		return ch, nil
+	}
+
+	s.lock.RLock()
+	cached, ok := s.cache[ch.Id]
+	s.lock.RUnlock()
+
+	if !ok || cached.ciphertext != ch.Secret {
+		plaintext, err := s.envelope.Decrypt(ctx, ch.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		cached = decryptedSecret{ciphertext: ch.Secret, plaintext: plaintext}
+
+		s.lock.Lock()
+		s.cache[ch.Id] = cached
+		s.lock.Unlock()
+	}
+
+	ret := *ch
+	ret.Secret = cached.plaintext
+	return &ret, nil
+}
@@ -19,11 +19,11 @@ func NewSenseNovaChat(sensenova *sensenova.SenseNova) *SenseNovaChat {
 }
 
 func (ds *SenseNovaChat) initRequest(req Request) sensenova.Request {
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	messages := array.Map(req.Messages, func(item Message, _ int) sensenova.Message {
 		return sensenova.Message{
-			Role:    item.Role,
+			Role:    string(item.Role),
 			Content: item.Content,
 		}
 	})
@@ -58,7 +58,7 @@ func (ds *SenseNovaChat) Chat(ctx context.Context, req Request) (*Response, erro
 
 	return &Response{
 		Text:         content,
-		FinishReason: finishReason,
+		FinishReason: NormalizeFinishReason(finishReason),
 		InputTokens:  resp.Data.Usage.PromptTokens,
 		OutputTokens: resp.Data.Usage.CompletionTokens,
 	}, nil
@@ -110,7 +110,7 @@ func (ds *SenseNovaChat) ChatStream(ctx context.Context, req Request) (<-chan Re
 					return
 				case res <- Response{
 					Text:         content,
-					FinishReason: finishReason,
+					FinishReason: NormalizeFinishReason(finishReason),
 					InputTokens:  data.Data.Usage.PromptTokens,
 					OutputTokens: data.Data.Usage.CompletionTokens,
 				}:
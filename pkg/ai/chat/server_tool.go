@@ -0,0 +1,449 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/asteria/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// serverToolMaxRounds 一次请求中最多请求模型的轮数，最后一轮不再提供服务端的工具，
+	// 模型在最后一轮仍然调用服务端的工具时不再执行，直接返回该轮的响应，避免模型反复调用工具
+	serverToolMaxRounds = 4
+	// serverToolKeepAlive 执行工具期间发送中间状态响应的间隔，避免客户端（以及响应间隔超时检测）认为输出已经中断
+	serverToolKeepAlive = 10 * time.Second
+)
+
+// serverToolBudgetPrompt 工具调用循环的耗时预算即将耗尽时，要求模型根据已有的信息直接回答
+const serverToolBudgetPrompt = "The time budget for using tools has been exhausted. Do not call any more tools, answer the question now with the information you already have."
+
+// ServerTool 服务端执行的工具（如 generate_image、web_search），模型调用时由 ServerToolChat 执行，结果作为工具调用的结果再次请求模型
+type ServerTool interface {
+	// Begin 开始处理一个请求，请求没有启用该工具时返回 nil
+	Begin(ctx context.Context, req Request) ServerToolSession
+}
+
+// ServerToolSession 工具在一次请求中的状态（如剩余的调用次数）
+type ServerToolSession interface {
+	// Definition 提供给模型的工具定义
+	Definition() Tool
+	// Execute 执行工具调用，执行失败时同样返回结果（说明失败的原因），由模型告知用户
+	Execute(ctx context.Context, call ToolCall) ToolResult
+}
+
+// ToolResult 服务端工具调用的结果
+type ToolResult struct {
+	// Content 返回给模型的结果
+	Content string
+	// Parts 返回给用户的多模态内容（如生成的图片）
+	Parts []*MultipartContent
+	// Citations 返回给用户的引用来源（如搜索结果）
+	Citations []Citation
+}
+
+// ServerToolChat 为请求提供服务端执行的工具：模型调用这些工具时，在服务端执行并将结果作为工具调用的结果再次请求模型，
+// 工具生成的多模态内容通过 Response.Parts 返回，引用来源通过最终响应的 Response.Citations 返回
+//
+// 模型同时调用了客户端提供的工具时，不执行服务端的工具，只返回客户端提供的工具调用
+type ServerToolChat struct {
+	imp   Chat
+	tools []ServerTool
+	// keepAlive 执行工具期间发送中间状态响应的间隔
+	keepAlive time.Duration
+	// iterations 每个请求完成的工具调用循环次数，按照是否受到耗时预算的限制区分，用于调整预算
+	iterations *prometheus.HistogramVec
+}
+
+func NewServerToolChat(imp Chat, tools ...ServerTool) *ServerToolChat {
+	return &ServerToolChat{imp: imp, tools: tools, keepAlive: serverToolKeepAlive, iterations: newToolLoopIterations(prometheus.DefaultRegisterer)}
+}
+
+// newToolLoopIterations 创建工具调用循环次数的统计，并注册到 registerer
+func newToolLoopIterations(registerer prometheus.Registerer) *prometheus.HistogramVec {
+	return registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "aidea",
+		Name:      "chat_tool_loop_iterations",
+		Help:      "completed server tool loop iterations per request, by whether the loop was cut off by the latency budget",
+		Buckets:   prometheus.LinearBuckets(0, 1, serverToolMaxRounds),
+	}, []string{"budget_limited"}))
+}
+
+// toolLoopBudget 工具调用循环的耗时预算（Request.ToolLoopBudget），为 0 时不限制
+type toolLoopBudget struct {
+	budget time.Duration
+	start  time.Time
+}
+
+// exhausted 完成 iterations 次循环之后，剩余的预算是否不足以再完成一次循环（按照已完成循环的平均耗时估算），
+// 只在两次循环之间检查，不会中断正在进行的请求
+func (b toolLoopBudget) exhausted(iterations int) bool {
+	if b.budget <= 0 || iterations <= 0 {
+		return false
+	}
+
+	elapsed := time.Since(b.start)
+	return elapsed+elapsed/time.Duration(iterations) >= b.budget
+}
+
+// finish 记录请求完成的循环次数
+func (c *ServerToolChat) finish(res *Response, iterations int, limited bool) {
+	res.ToolIterations = iterations
+	res.BudgetLimited = limited
+	if c.iterations != nil {
+		c.iterations.WithLabelValues(strconv.FormatBool(limited)).Observe(float64(iterations))
+	}
+}
+
+// answerNow 预算即将耗尽时，不再提供服务端的工具，并要求模型直接回答
+func answerNow(req Request, clientTools []Tool) Request {
+	req.Tools = clientTools
+	req.Messages = append(req.Messages, Message{Role: RoleUser, Content: serverToolBudgetPrompt})
+	return req
+}
+
+func (c *ServerToolChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
+
+func (c *ServerToolChat) SupportSystemRole(model string) bool {
+	return supportSystemRole(c.imp, model)
+}
+
+// ModelHealthy 转发给下一层的 Chat 实现（参考 ModelHealthChecker），不支持时总是返回 true
+func (c *ServerToolChat) ModelHealthy(ctx context.Context, model string) bool {
+	if checker, ok := c.imp.(ModelHealthChecker); ok {
+		return checker.ModelHealthy(ctx, model)
+	}
+
+	return true
+}
+
+// LatencyStats 转发给下一层的 Chat 实现（参考 ChannelLatencyReporter），不支持时返回零值
+func (c *ServerToolChat) LatencyStats(channelID int64) (p50, p90, p99 time.Duration, count int) {
+	if reporter, ok := c.imp.(ChannelLatencyReporter); ok {
+		return reporter.LatencyStats(channelID)
+	}
+
+	return 0, 0, 0, 0
+}
+
+// begin 返回请求启用的工具，客户端提供了同名工具时以客户端的为准
+func (c *ServerToolChat) begin(ctx context.Context, req Request) map[string]ServerToolSession {
+	clientTools := make(map[string]bool)
+	for _, tool := range req.Tools {
+		clientTools[tool.Function.Name] = true
+	}
+
+	sessions := make(map[string]ServerToolSession)
+	for _, tool := range c.tools {
+		if sess := tool.Begin(ctx, req); sess != nil && !clientTools[sess.Definition().Function.Name] {
+			sessions[sess.Definition().Function.Name] = sess
+		}
+	}
+
+	return sessions
+}
+
+func (c *ServerToolChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	_, res, err := c.ChatWithTrace(ctx, req)
+	return res, err
+}
+
+// traceChat 通过下一层的 Chat 实现发起请求，支持 TraceChat 时返回实际发送给服务提供商的请求，否则返回原始请求
+func (c *ServerToolChat) traceChat(ctx context.Context, req Request) (*Request, *Response, error) {
+	if tracer, ok := c.imp.(TraceChat); ok {
+		return tracer.ChatWithTrace(ctx, req)
+	}
+
+	res, err := c.imp.Chat(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &req, res, nil
+}
+
+// ChatWithTrace 与 Chat 相同，同时返回最后一轮请求中实际发送给服务提供商的请求（包括之前的工具调用以及结果）
+func (c *ServerToolChat) ChatWithTrace(ctx context.Context, req Request) (*Request, *Response, error) {
+	sessions := c.begin(ctx, req)
+	if len(sessions) == 0 {
+		return c.traceChat(ctx, req)
+	}
+
+	clientTools := req.Tools
+	req.Tools = withServerTools(clientTools, sessions)
+
+	budget := toolLoopBudget{budget: req.ToolLoopBudget, start: time.Now()}
+
+	var parts []*MultipartContent
+	var citations []Citation
+	var limited bool
+	for round := 1; ; round++ {
+		if round == serverToolMaxRounds {
+			req.Tools = clientTools
+		}
+
+		sent, res, err := c.traceChat(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		calls, ok := serverToolCalls(res.ToolCalls, sessions)
+		if !ok || round >= serverToolMaxRounds || limited {
+			dropServerToolCalls(res, sessions)
+			res.Parts = append(parts, res.Parts...)
+			res.Citations = append(citations, res.Citations...)
+			c.finish(res, round-1, limited)
+			return sent, res, nil
+		}
+
+		results := make(Messages, 0, len(calls))
+		for _, call := range calls {
+			ret := sessions[call.Function.Name].Execute(ctx, call)
+			parts = append(parts, ret.Parts...)
+			citations = append(citations, ret.Citations...)
+			results = append(results, Message{Role: RoleTool, ToolCallID: call.ID, Content: ret.Content})
+		}
+
+		req.Messages = append(append(req.Messages, Message{Role: RoleAssistant, Content: res.Text, ToolCalls: calls}), results...)
+		if budget.exhausted(round) {
+			req, limited = answerNow(req, clientTools), true
+		}
+	}
+}
+
+func (c *ServerToolChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	sessions := c.begin(ctx, req)
+	if len(sessions) == 0 {
+		return c.imp.ChatStream(ctx, req)
+	}
+
+	budget := toolLoopBudget{budget: req.ToolLoopBudget, start: time.Now()}
+
+	clientTools := req.Tools
+	req.Tools = withServerTools(clientTools, sessions)
+	stream, err := c.imp.ChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		// 每一轮都会切换上游的响应流，提前退出时需要消费完当前的响应流
+		defer func() { drainStream(stream) }()
+
+		// citations 引用来源在最终的响应（包含结束原因）中返回
+		var citations []Citation
+		var limited bool
+		for round := 1; ; round++ {
+			var text strings.Builder
+			var calls []ToolCall
+			for data := range stream {
+				text.WriteString(data.Text)
+
+				// 工具调用的中间状态只返回客户端提供的工具
+				if data.ToolCallDelta != nil && sessions[data.ToolCallDelta.Name] != nil {
+					data.ToolCallDelta = nil
+					if isEmptyStreamResponse(data) {
+						continue
+					}
+				}
+
+				if len(data.ToolCalls) > 0 {
+					if serverCalls, ok := serverToolCalls(data.ToolCalls, sessions); ok && round < serverToolMaxRounds && !limited {
+						// 执行完工具之后继续对话，本轮的结束响应不返回给客户端
+						calls = serverCalls
+						data.ToolCalls = nil
+						data.FinishReason = ""
+						if isEmptyStreamResponse(data) {
+							continue
+						}
+					} else {
+						dropServerToolCalls(&data, sessions)
+					}
+				}
+
+				if data.FinishReason != "" {
+					if len(citations) > 0 {
+						data.Citations = append(citations, data.Citations...)
+						citations = nil
+					}
+
+					c.finish(&data, round-1, limited)
+				}
+
+				if !send(data) {
+					return
+				}
+			}
+
+			if len(calls) == 0 {
+				if len(citations) > 0 {
+					send(Response{Citations: citations})
+				}
+
+				return
+			}
+
+			results := make(Messages, 0, len(calls))
+			for _, call := range calls {
+				ret, ok := c.executeStream(ctx, sessions[call.Function.Name], call, send)
+				if !ok {
+					return
+				}
+
+				if len(ret.Parts) > 0 && !send(Response{Parts: ret.Parts}) {
+					return
+				}
+
+				citations = append(citations, ret.Citations...)
+				results = append(results, Message{Role: RoleTool, ToolCallID: call.ID, Content: ret.Content})
+			}
+
+			req.Messages = append(append(req.Messages, Message{Role: RoleAssistant, Content: text.String(), ToolCalls: calls}), results...)
+			if round+1 == serverToolMaxRounds {
+				req.Tools = clientTools
+			}
+			if budget.exhausted(round) {
+				req, limited = answerNow(req, clientTools), true
+			}
+
+			stream, err = c.imp.ChatStream(ctx, req)
+			if err != nil {
+				log.F(log.M{"user_id": req.UserID, "model": req.Model}).Errorf("continue chat after executing server tools failed: %v", err)
+				send(Response{ErrorCode: "SERVER_TOOL_FAILED", Error: "执行工具之后继续对话失败，请稍后再试", FinishReason: FinishReasonStop, Citations: citations})
+				return
+			}
+		}
+	}), nil
+}
+
+// executeStream 执行工具调用，等待期间按照 keepAlive 间隔发送中间状态的响应，ctx 取消或者响应发送失败时 ok 为 false
+func (c *ServerToolChat) executeStream(ctx context.Context, sess ServerToolSession, call ToolCall, send func(Response) bool) (ToolResult, bool) {
+	done := make(chan ToolResult, 1)
+	go func() {
+		done <- sess.Execute(ctx, call)
+	}()
+
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ret := <-done:
+			return ret, true
+		case <-ticker.C:
+			if !send(Response{Interim: true}) {
+				return ToolResult{}, false
+			}
+		case <-ctx.Done():
+			return ToolResult{}, false
+		}
+	}
+}
+
+// withServerTools 在客户端提供的工具列表之后加入服务端的工具
+func withServerTools(tools []Tool, sessions map[string]ServerToolSession) []Tool {
+	ret := append(make([]Tool, 0, len(tools)+len(sessions)), tools...)
+	for _, sess := range sessions {
+		ret = append(ret, sess.Definition())
+	}
+
+	// 按照名称排序，保证每次请求的工具列表一致
+	serverTools := ret[len(tools):]
+	sort.Slice(serverTools, func(i, j int) bool { return serverTools[i].Function.Name < serverTools[j].Function.Name })
+
+	return ret
+}
+
+// serverToolCalls 返回服务端的工具调用，只有所有的工具调用都是服务端的工具时 ok 为 true
+func serverToolCalls(calls []ToolCall, sessions map[string]ServerToolSession) ([]ToolCall, bool) {
+	if len(calls) == 0 {
+		return nil, false
+	}
+
+	ret := make([]ToolCall, 0, len(calls))
+	for i, call := range calls {
+		if sessions[call.Function.Name] == nil {
+			return nil, false
+		}
+
+		// 部分服务提供商不返回工具调用 ID，这里补充一个，用于关联工具调用的结果
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%s_%d", call.Function.Name, i)
+		}
+
+		ret = append(ret, call)
+	}
+
+	return ret, true
+}
+
+// clientToolCalls 去掉服务端的工具调用，只保留客户端提供的工具
+func clientToolCalls(calls []ToolCall, sessions map[string]ServerToolSession) []ToolCall {
+	var ret []ToolCall
+	for _, call := range calls {
+		if sessions[call.Function.Name] == nil {
+			ret = append(ret, call)
+		}
+	}
+
+	return ret
+}
+
+// dropServerToolCalls 去掉响应中不再执行的服务端工具调用，没有剩余的工具调用时结束原因改为 stop
+func dropServerToolCalls(res *Response, sessions map[string]ServerToolSession) {
+	res.ToolCalls = clientToolCalls(res.ToolCalls, sessions)
+	if len(res.ToolCalls) == 0 && res.FinishReason == FinishReasonToolCalls {
+		res.FinishReason = FinishReasonStop
+	}
+}
+
+// isEmptyStreamResponse 响应中是否没有任何内容
+func isEmptyStreamResponse(data Response) bool {
+	return isPlainTextResponse(data) && data.Text == ""
+}
+
+// ToolLimits 每个请求（或者对话）中最多可以调用服务端工具的次数，可以按照用户类型单独配置，
+// 同样用于按照用户类型配置的工具调用循环的耗时预算（秒）
+type ToolLimits struct {
+	defaultLimit int
+	tiers        map[int64]int
+}
+
+// ParseToolLimits 解析工具调用次数的限制，tiers 中的每一项格式为 "用户类型:次数"，格式错误的配置忽略
+func ParseToolLimits(defaultLimit int, tiers []string) ToolLimits {
+	limits := ToolLimits{defaultLimit: defaultLimit, tiers: make(map[int64]int)}
+	for _, tier := range tiers {
+		segs := strings.SplitN(strings.TrimSpace(tier), ":", 2)
+		if len(segs) != 2 {
+			log.Warningf("按照用户类型的工具配置 %q 格式错误，应为 用户类型:数值", tier)
+			continue
+		}
+
+		userType, err1 := strconv.ParseInt(strings.TrimSpace(segs[0]), 10, 64)
+		limit, err2 := strconv.Atoi(strings.TrimSpace(segs[1]))
+		if err1 != nil || err2 != nil || limit < 0 {
+			log.Warningf("按照用户类型的工具配置 %q 格式错误，应为 用户类型:数值", tier)
+			continue
+		}
+
+		limits.tiers[userType] = limit
+	}
+
+	return limits
+}
+
+// Limit 返回用户类型对应的限制，为 0 时不允许使用该工具
+func (l ToolLimits) Limit(userType int64) int {
+	if limit, ok := l.tiers[userType]; ok {
+		return limit
+	}
+
+	return l.defaultLimit
+}
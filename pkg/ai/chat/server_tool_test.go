@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// loopTestClient 每次请求都调用服务端的工具 loop_test，记录收到的请求
+type loopTestClient struct {
+	ChatTestClient
+	requests []Request
+}
+
+func (c *loopTestClient) response() Response {
+	return Response{
+		Text:         "继续，",
+		FinishReason: FinishReasonToolCalls,
+		ToolCalls:    []ToolCall{{Type: "function", Function: ToolCallFunction{Name: "loop_test", Arguments: `{}`}}},
+	}
+}
+
+func (c *loopTestClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.requests = append(c.requests, req)
+	res := c.response()
+	return &res, nil
+}
+
+func (c *loopTestClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	c.requests = append(c.requests, req)
+
+	res := c.response()
+	ch := make(chan Response, 2)
+	ch <- Response{Text: res.Text}
+	ch <- Response{FinishReason: res.FinishReason, ToolCalls: res.ToolCalls}
+	close(ch)
+
+	return ch, nil
+}
+
+// loopTestTool 记录执行次数的服务端工具，每次执行耗时 delay
+type loopTestTool struct {
+	delay    time.Duration
+	executed int
+}
+
+func (t *loopTestTool) Begin(ctx context.Context, req Request) ServerToolSession {
+	return t
+}
+
+func (t *loopTestTool) Definition() Tool {
+	return Tool{Type: "function", Function: ToolFunction{Name: "loop_test"}}
+}
+
+func (t *loopTestTool) Execute(ctx context.Context, call ToolCall) ToolResult {
+	t.executed++
+	time.Sleep(t.delay)
+	return ToolResult{Content: "ok"}
+}
+
+func TestServerToolChat_MaxRounds(t *testing.T) {
+	client, tool := &loopTestClient{}, &loopTestTool{}
+	c := NewServerToolChat(client, tool)
+
+	// 模型在最后一轮仍然调用服务端的工具时，不再执行，直接返回
+	res, err := c.Chat(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, serverToolMaxRounds, len(client.requests))
+	assert.Equal(t, serverToolMaxRounds-1, tool.executed)
+	assert.Equal(t, 0, len(res.ToolCalls))
+	assert.Equal(t, FinishReasonStop, res.FinishReason)
+	assert.Equal(t, 0, len(client.requests[serverToolMaxRounds-1].Tools))
+	assert.Equal(t, serverToolMaxRounds-1, res.ToolIterations)
+	assert.False(t, res.BudgetLimited)
+}
+
+func TestServerToolChat_MaxRoundsStream(t *testing.T) {
+	client, tool := &loopTestClient{}, &loopTestTool{}
+	c := NewServerToolChat(client, tool)
+
+	stream, err := c.ChatStream(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+
+	var finishes int
+	for res := range stream {
+		assert.Equal(t, 0, len(res.ToolCalls))
+		if res.FinishReason != "" {
+			finishes++
+			assert.Equal(t, FinishReasonStop, res.FinishReason)
+		}
+	}
+
+	assert.Equal(t, 1, finishes)
+	assert.Equal(t, serverToolMaxRounds, len(client.requests))
+	assert.Equal(t, serverToolMaxRounds-1, tool.executed)
+	assert.Equal(t, 0, len(client.requests[serverToolMaxRounds-1].Tools))
+}
+
+func TestServerToolChat_Budget(t *testing.T) {
+	client, tool := &loopTestClient{}, &loopTestTool{delay: 30 * time.Millisecond}
+	c := NewServerToolChat(client, tool)
+
+	// 完成一次循环之后剩余的预算不足以再完成一次循环，不再提供工具，要求模型直接回答
+	req := Request{Messages: Messages{{Role: RoleUser, Content: "hi"}}, ToolLoopBudget: 50 * time.Millisecond}
+	res, err := c.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.True(t, res.BudgetLimited)
+	assert.Equal(t, 1, res.ToolIterations)
+	assert.Equal(t, 0, len(res.ToolCalls))
+	assert.Equal(t, 1, tool.executed)
+
+	assert.Equal(t, 2, len(client.requests))
+	last := client.requests[1]
+	assert.Equal(t, 0, len(last.Tools))
+	assert.Equal(t, serverToolBudgetPrompt, last.Messages[len(last.Messages)-1].Content)
+
+	// 流式输出时在包含结束原因的响应中返回
+	client, tool = &loopTestClient{}, &loopTestTool{delay: 30 * time.Millisecond}
+	c = NewServerToolChat(client, tool)
+
+	stream, err := c.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	var final Response
+	for data := range stream {
+		if data.FinishReason != "" {
+			final = data
+		}
+	}
+
+	assert.True(t, final.BudgetLimited)
+	assert.Equal(t, 1, final.ToolIterations)
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, 1, tool.executed)
+
+	// 预算充足时不受影响
+	client, tool = &loopTestClient{}, &loopTestTool{}
+	c = NewServerToolChat(client, tool)
+	res, err = c.Chat(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "hi"}}, ToolLoopBudget: time.Minute})
+	assert.NoError(t, err)
+	assert.False(t, res.BudgetLimited)
+	assert.Equal(t, serverToolMaxRounds-1, res.ToolIterations)
+}
+
+func TestServerToolChat_ForwardDispatcherInterfaces(t *testing.T) {
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+	d := NewDispatcher(router, &fakeClientFactory{client: &streamChatClient{}, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.health = NewHealthTracker(1, time.Minute)
+	d.latency = NewLatencyRecorder(time.Minute, 10)
+	d.latency.Record(1, 100*time.Millisecond)
+
+	var c Chat = NewServerToolChat(d, NewImageTool(&imageToolTestGenerator{}, "dall-e-3", time.Second))
+
+	checker, ok := c.(ModelHealthChecker)
+	assert.True(t, ok)
+	assert.True(t, checker.ModelHealthy(context.TODO(), "gpt-4"))
+	d.health.Report(repo.ModelProvider{ID: 1}, errors.New("upstream unavailable"))
+	assert.False(t, checker.ModelHealthy(context.TODO(), "gpt-4"))
+
+	reporter, ok := c.(ChannelLatencyReporter)
+	assert.True(t, ok)
+	p50, _, _, count := reporter.LatencyStats(1)
+	assert.Equal(t, 100*time.Millisecond, p50)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+)
+
+const (
+	// DefaultSessionTTL 增量输入会话未提交时的默认过期时间
+	DefaultSessionTTL = 30 * time.Second
+	// DefaultMaxSessions 每个用户最多同时打开的增量输入会话数量（未配置时使用）
+	DefaultMaxSessions = 5
+	// DefaultMaxSessionRunes 增量输入会话中最后一条用户消息的最大字符数量（未配置时使用）
+	DefaultMaxSessionRunes = 20000
+)
+
+var (
+	ErrSessionNotFound    = service.ErrInputSessionNotFound
+	ErrTooManySessions    = service.ErrTooManyInputSessions
+	ErrSessionTooLarge    = service.ErrInputSessionTooLarge
+	ErrSessionInvalidLast = errors.New("会话的最后一条消息必须是用户消息")
+)
+
+// SessionStore 增量输入会话的存储，多个实例之间共享，会话过期后自动删除
+type SessionStore interface {
+	// OpenInputSession 保存会话，替换房间中未提交的会话，用户未提交的会话达到 maxSessions 个时返回 ErrTooManySessions
+	OpenInputSession(ctx context.Context, userID, roomID int64, request []byte, size int, ttl time.Duration, maxSessions int) error
+	// AppendInputSession 追加文本片段并刷新过期时间，总长度超过 maxSize 时返回 ErrSessionTooLarge
+	AppendInputSession(ctx context.Context, userID, roomID int64, fragment string, size int, ttl time.Duration, maxSize int) error
+	// TakeInputSession 取出并删除会话，返回会话的请求内容以及追加的文本片段
+	TakeInputSession(ctx context.Context, userID, roomID int64) ([]byte, []string, error)
+}
+
+// SessionManager 增量输入会话管理
+//
+// 语音客户端会边识别边发送内容，先打开会话（包含用户消息），然后多次追加内容到最后一条用户消息，
+// 最后提交会话，避免客户端反复发送完整的消息。提交时只返回拼接完成的请求，由调用方按照普通的聊天请求处理
+// （参数校验、内容安全检测、计费以及保存聊天记录等）
+type SessionManager struct {
+	store SessionStore
+	ttl   time.Duration
+	// maxSessions 每个用户最多同时打开的会话数量
+	maxSessions int
+	// maxRunes 会话中最后一条用户消息（包括追加的内容）的最大字符数量
+	maxRunes int
+}
+
+// NewSessionManager 创建增量输入会话管理器，ttl 为会话未提交时的过期时间，maxSessions 为每个用户最多同时打开的会话数量，
+// maxRunes 为最后一条用户消息的最大字符数量，小于等于 0 时使用默认值
+func NewSessionManager(store SessionStore, ttl time.Duration, maxSessions int, maxRunes int) *SessionManager {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	if maxSessions <= 0 {
+		maxSessions = DefaultMaxSessions
+	}
+
+	if maxRunes <= 0 {
+		maxRunes = DefaultMaxSessionRunes
+	}
+
+	return &SessionManager{store: store, ttl: ttl, maxSessions: maxSessions, maxRunes: maxRunes}
+}
+
+// Open 为用户的某个房间打开一个增量输入会话，如果已经存在未提交的会话，则会被替换
+func (mgr *SessionManager) Open(ctx context.Context, userID, roomID int64, req Request) error {
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != RoleUser {
+		return ErrSessionInvalidLast
+	}
+
+	size := utf8.RuneCountInString(req.Messages[len(req.Messages)-1].Content)
+	if size > mgr.maxRunes {
+		return ErrSessionTooLarge
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return mgr.store.OpenInputSession(ctx, userID, roomID, data, size, mgr.ttl, mgr.maxSessions)
+}
+
+// Append 追加文本片段到会话的最后一条用户消息，每次追加都会刷新会话的过期时间
+func (mgr *SessionManager) Append(ctx context.Context, userID, roomID int64, fragment string) error {
+	return mgr.store.AppendInputSession(ctx, userID, roomID, fragment, utf8.RuneCountInString(fragment), mgr.ttl, mgr.maxRunes)
+}
+
+// Commit 提交会话，返回追加内容之后的请求，会话被移除
+func (mgr *SessionManager) Commit(ctx context.Context, userID, roomID int64) (*Request, error) {
+	data, fragments, err := mgr.store.TakeInputSession(ctx, userID, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, ErrSessionInvalidLast
+	}
+
+	last := &req.Messages[len(req.Messages)-1]
+	last.Content += strings.Join(fragments, "")
+
+	return &req, nil
+}
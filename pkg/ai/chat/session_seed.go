@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/mylxsw/asteria/log"
+)
+
+// SessionFingerprints 记录会话中每轮对话的上游模型指纹（system_fingerprint），由 service.ChatService 实现
+type SessionFingerprints interface {
+	// RecordSessionFingerprint 记录用户会话的指纹，返回指纹与上一轮相比是否发生变化
+	RecordSessionFingerprint(ctx context.Context, userID int64, sessionID string, model string, fingerprint string) (bool, error)
+}
+
+// sessionSeed 根据会话 ID 生成固定的随机数种子，同一个会话的所有轮次使用相同的种子
+func sessionSeed(sessionID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sessionID))
+
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+// recordSessionFingerprint 记录响应中的指纹，与上一轮不同时标记 FingerprintChanged，
+// 服务提供商不支持（没有返回指纹）时不记录
+func recordSessionFingerprint(ctx context.Context, fingerprints SessionFingerprints, req Request, res *Response) {
+	if fingerprints == nil || req.SessionID == "" || res.SystemFingerprint == "" {
+		return
+	}
+
+	changed, err := fingerprints.RecordSessionFingerprint(ctx, req.UserID, req.SessionID, req.Model, res.SystemFingerprint)
+	if err != nil {
+		log.F(log.M{"user_id": req.UserID, "session_id": req.SessionID, "model": req.Model}).Errorf("record session fingerprint failed: %v", err)
+		return
+	}
+
+	if changed {
+		log.F(log.M{"user_id": req.UserID, "session_id": req.SessionID, "model": req.Model, "fingerprint": res.SystemFingerprint}).Warning("upstream system fingerprint changed within the session")
+	}
+
+	res.FingerprintChanged = changed
+}
+
+// recordStreamFingerprint 流式输出时，在包含结束原因的响应中记录指纹，与上一轮不同时标记 FingerprintChanged，
+// 上游可能只在部分响应中返回指纹，使用目前为止最后一次返回的指纹；多个候选结果（n>1）时只记录一次
+func recordStreamFingerprint(ctx context.Context, stream <-chan Response, fingerprints SessionFingerprints, req Request) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		var fingerprint string
+		var recorded bool
+		for data := range stream {
+			if data.SystemFingerprint != "" {
+				fingerprint = data.SystemFingerprint
+			}
+
+			if !recorded && data.FinishReason != "" && data.ErrorCode == "" && fingerprint != "" {
+				recorded = true
+				data.SystemFingerprint = fingerprint
+				recordSessionFingerprint(ctx, fingerprints, req, &data)
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
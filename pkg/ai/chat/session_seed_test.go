@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// memorySessionFingerprints 基于内存的会话指纹记录，Key 为 "用户 ID:会话 ID"
+type memorySessionFingerprints map[string][]string
+
+func (m memorySessionFingerprints) RecordSessionFingerprint(ctx context.Context, userID int64, sessionID string, model string, fingerprint string) (bool, error) {
+	key := fmt.Sprintf("%d:%s", userID, sessionID)
+	history := m[key]
+	m[key] = append(history, fingerprint)
+
+	return len(history) > 0 && history[len(history)-1] != fingerprint, nil
+}
+
+// fingerprintChatClient 按照顺序返回预设的模型指纹
+type fingerprintChatClient struct {
+	ChatTestClient
+	fingerprints []string
+	requests     []Request
+}
+
+func (c *fingerprintChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	fingerprint := c.fingerprints[len(c.requests)]
+	c.requests = append(c.requests, req)
+
+	return &Response{Text: "ok", SystemFingerprint: fingerprint}, nil
+}
+
+func TestDispatcher_SessionSeed(t *testing.T) {
+	client := &fingerprintChatClient{fingerprints: []string{"fp_1", "fp_1", "fp_2", ""}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+
+	fingerprints := memorySessionFingerprints{}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.fingerprints = fingerprints
+
+	var changed []bool
+	for i := 0; i < 4; i++ {
+		res, err := d.Chat(context.TODO(), Request{Model: "gpt-4", UserID: 1, SessionID: "eval-1", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+		assert.NoError(t, err)
+		changed = append(changed, res.FingerprintChanged)
+	}
+
+	// 同一个会话的所有轮次使用相同的种子
+	for _, req := range client.requests {
+		assert.True(t, req.Seed != nil)
+		assert.Equal(t, sessionSeed("eval-1"), *req.Seed)
+	}
+	assert.True(t, sessionSeed("eval-1") != sessionSeed("eval-2"))
+
+	// 指纹变化时标记，没有返回指纹时不记录
+	assert.Equal(t, []bool{false, false, true, false}, changed)
+	assert.Equal(t, []string{"fp_1", "fp_1", "fp_2"}, fingerprints["1:eval-1"])
+
+	// 请求中指定的种子优先
+	seed := 42
+	client = &fingerprintChatClient{fingerprints: []string{"fp_2"}}
+	factory.client = client
+
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4", UserID: 1, SessionID: "eval-1", Seed: &seed, Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.False(t, res.FingerprintChanged)
+	assert.Equal(t, 42, *client.requests[0].Seed)
+}
+
+func TestDispatcher_SessionFingerprintStream(t *testing.T) {
+	fingerprint := "fp_1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"system_fingerprint": "` + fingerprint + `", "choices": [{"index": 0, "delta": {"role": "assistant", "content": "ok"}}]}`,
+			`{"system_fingerprint": "` + fingerprint + `", "choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+		}
+		for _, chunk := range chunks {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := createOpenAIClient(&repo.Channel{Channels: model.Channels{Type: service.ProviderOpenAI, Server: server.URL, Secret: "sk-test"}}, nil, 0)
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+
+	fingerprints := memorySessionFingerprints{}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.fingerprints = fingerprints
+
+	chatStream := func(userID int64) Response {
+		stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", UserID: userID, SessionID: "eval-1", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+		assert.NoError(t, err)
+
+		responses := assertFinishReasonConformance(t, stream)
+		return responses[len(responses)-1]
+	}
+
+	// 流式输出时在结束响应中记录指纹
+	last := chatStream(1)
+	assert.Equal(t, "fp_1", last.SystemFingerprint)
+	assert.False(t, last.FingerprintChanged)
+
+	fingerprint = "fp_2"
+	last = chatStream(1)
+	assert.Equal(t, "fp_2", last.SystemFingerprint)
+	assert.True(t, last.FingerprintChanged)
+
+	// 不同用户使用相同的会话 ID 时互不影响
+	last = chatStream(2)
+	assert.False(t, last.FingerprintChanged)
+
+	assert.Equal(t, []string{"fp_1", "fp_2"}, fingerprints["1:eval-1"])
+	assert.Equal(t, []string{"fp_2"}, fingerprints["2:eval-1"])
+}
@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// recordChatClient 记录收到的请求
+type recordChatClient struct {
+	ChatTestClient
+	requests []Request
+}
+
+func (c *recordChatClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	c.requests = append(c.requests, req)
+
+	res := make(chan Response)
+	close(res)
+
+	return res, nil
+}
+
+// memorySessionStore 内存中的增量输入会话存储，不处理过期
+type memorySessionStore struct {
+	requests  map[int64][]byte
+	fragments map[int64][]string
+	sizes     map[int64]int
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{requests: map[int64][]byte{}, fragments: map[int64][]string{}, sizes: map[int64]int{}}
+}
+
+func (s *memorySessionStore) OpenInputSession(_ context.Context, _, roomID int64, request []byte, size int, _ time.Duration, maxSessions int) error {
+	if _, ok := s.requests[roomID]; !ok && len(s.requests) >= maxSessions {
+		return ErrTooManySessions
+	}
+
+	s.requests[roomID], s.fragments[roomID], s.sizes[roomID] = request, nil, size
+	return nil
+}
+
+func (s *memorySessionStore) AppendInputSession(_ context.Context, _, roomID int64, fragment string, size int, _ time.Duration, maxSize int) error {
+	if _, ok := s.requests[roomID]; !ok {
+		return ErrSessionNotFound
+	}
+
+	if s.sizes[roomID]+size > maxSize {
+		return ErrSessionTooLarge
+	}
+
+	s.sizes[roomID] += size
+	s.fragments[roomID] = append(s.fragments[roomID], fragment)
+	return nil
+}
+
+func (s *memorySessionStore) TakeInputSession(_ context.Context, _, roomID int64) ([]byte, []string, error) {
+	request, ok := s.requests[roomID]
+	if !ok {
+		return nil, nil, ErrSessionNotFound
+	}
+
+	fragments := s.fragments[roomID]
+	delete(s.requests, roomID)
+	delete(s.fragments, roomID)
+	delete(s.sizes, roomID)
+
+	return request, fragments, nil
+}
+
+func TestSessionManager_Commit(t *testing.T) {
+	ctx := context.TODO()
+	mgr := NewSessionManager(newMemorySessionStore(), time.Minute, 0, 0)
+
+	assert.Equal(t, ErrSessionInvalidLast, mgr.Open(ctx, 1, 100, Request{Messages: Messages{{Role: RoleAssistant, Content: "hi"}}}))
+	assert.NoError(t, mgr.Open(ctx, 1, 100, Request{
+		Model: "openai:gpt-3.5-turbo",
+		N:     100,
+		Messages: Messages{
+			{Role: RoleSystem, Content: "system"},
+			{Role: RoleUser, Content: ""},
+		},
+	}))
+	assert.NoError(t, mgr.Append(ctx, 1, 100, "今天"))
+	assert.NoError(t, mgr.Append(ctx, 1, 100, "天气怎么样"))
+
+	// 其它房间的会话不存在
+	assert.Equal(t, ErrSessionNotFound, mgr.Append(ctx, 1, 101, "hello"))
+
+	req, err := mgr.Commit(ctx, 1, 100)
+	assert.NoError(t, err)
+
+	// 提交后按照普通的聊天请求处理
+	fixed := req.Init()
+	assert.Equal(t, "gpt-3.5-turbo", fixed.Model)
+	assert.EqualValues(t, 100, fixed.RoomID)
+	assert.Equal(t, 2, len(fixed.Messages))
+	assert.Equal(t, "今天天气怎么样", fixed.Messages[1].Content)
+
+	// 提交后会话被移除
+	_, err = mgr.Commit(ctx, 1, 100)
+	assert.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestSessionManager_Limits(t *testing.T) {
+	ctx := context.TODO()
+	mgr := NewSessionManager(newMemorySessionStore(), time.Minute, 2, 10)
+
+	open := func(roomID int64, content string) error {
+		return mgr.Open(ctx, 1, roomID, Request{Messages: Messages{{Role: RoleUser, Content: content}}})
+	}
+
+	// 最后一条用户消息超过长度限制
+	assert.Equal(t, ErrSessionTooLarge, open(100, "一二三四五六七八九十一"))
+
+	assert.NoError(t, open(100, "一二三"))
+	assert.NoError(t, open(101, "hello"))
+	// 替换已经存在的会话不受数量限制
+	assert.NoError(t, open(101, "hi"))
+	assert.Equal(t, ErrTooManySessions, open(102, "hello"))
+
+	// 追加的内容按照字符数量计算，超过长度限制的片段不会被追加
+	assert.NoError(t, mgr.Append(ctx, 1, 100, "四五六七"))
+	assert.Equal(t, ErrSessionTooLarge, mgr.Append(ctx, 1, 100, "八九十一"))
+	assert.NoError(t, mgr.Append(ctx, 1, 100, "八九十"))
+
+	req, err := mgr.Commit(ctx, 1, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "一二三四五六七八九十", req.Messages[0].Content)
+}
@@ -16,7 +16,7 @@ func NewSkyChat(ai *sky.Sky) *SkyChat {
 }
 
 func (ai *SkyChat) initRequest(req Request) sky.Request {
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	messages := array.Map(req.Messages, func(item Message, _ int) sky.Message {
 		if item.Role == "assistant" {
@@ -24,7 +24,7 @@ func (ai *SkyChat) initRequest(req Request) sky.Request {
 		}
 
 		return sky.Message{
-			Role:    item.Role,
+			Role:    string(item.Role),
 			Content: item.Content,
 		}
 	})
@@ -47,10 +47,27 @@ func (ai *SkyChat) Chat(ctx context.Context, req Request) (*Response, error) {
 	}
 
 	return &Response{
-		Text: resp.RespData.Reply,
+		Text:         resp.RespData.Reply,
+		FinishReason: skyFinishReason(resp.RespData),
 	}, nil
 }
 
+// skyFinishReason 天工的结束原因：1 正常结束，2 token 限制，状态为 4 时表示命中敏感词结束
+func skyFinishReason(data sky.RespData) string {
+	if data.IsSensitive() {
+		return FinishReasonContentFilter
+	}
+
+	switch data.FinishReason {
+	case 1:
+		return FinishReasonStop
+	case 2:
+		return FinishReasonLength
+	}
+
+	return ""
+}
+
 func (ai *SkyChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
 	stream, err := ai.ai.ChatStream(ctx, ai.initRequest(req))
 	if err != nil {
@@ -83,7 +100,8 @@ func (ai *SkyChat) ChatStream(ctx context.Context, req Request) (<-chan Response
 				case <-ctx.Done():
 					return
 				case res <- Response{
-					Text: data.RespData.Reply,
+					Text:         data.RespData.Reply,
+					FinishReason: skyFinishReason(data.RespData),
 				}:
 				}
 			}
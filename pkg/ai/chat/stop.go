@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"context"
+	"strings"
+)
+
+// resolveStoppedBy 返回触发结束的停止序列
+//
+// 服务提供商返回了触发的停止序列时（如 Anthropic），转换为请求中对应的取值；否则在正常结束时，根据输出内容的结尾推断，
+// 只有一个停止序列与结尾匹配时才认为是明确的。OpenAI 等服务提供商的输出内容中不包含停止序列，此时无法推断，返回空字符串。
+func resolveStoppedBy(reported string, finishReason string, output string, stops []string) string {
+	if reported != "" {
+		return normalizeStopSequence(reported, stops)
+	}
+
+	if finishReason != FinishReasonStop {
+		return ""
+	}
+
+	var matched string
+	for _, stop := range stops {
+		if stop == "" || !strings.HasSuffix(output, stop) {
+			continue
+		}
+
+		if matched != "" && matched != stop {
+			// 多个停止序列与结尾匹配（如 "END" 和 "D"），无法确定
+			return ""
+		}
+
+		matched = stop
+	}
+
+	return matched
+}
+
+// normalizeStopSequence 将服务提供商返回的停止序列转换为请求中对应的取值，部分服务提供商会去掉首尾的空白字符
+func normalizeStopSequence(reported string, stops []string) string {
+	for _, stop := range stops {
+		if stop == reported {
+			return stop
+		}
+	}
+
+	for _, stop := range stops {
+		if strings.TrimSpace(stop) == strings.TrimSpace(reported) {
+			return stop
+		}
+	}
+
+	return reported
+}
+
+// attachStoppedBy 为流式响应中包含结束原因的响应补充触发结束的停止序列，只保留输出内容的结尾用于推断
+func attachStoppedBy(ctx context.Context, stream <-chan Response, stops []string) <-chan Response {
+	tailSize := 0
+	for _, stop := range stops {
+		tailSize = max(tailSize, len(stop))
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		var tail string
+		for data := range stream {
+			if !data.Interim {
+				tail += data.Text
+				if len(tail) > tailSize {
+					tail = tail[len(tail)-tailSize:]
+				}
+			}
+
+			if data.FinishReason != "" {
+				data.StoppedBy = resolveStoppedBy(data.StoppedBy, data.FinishReason, tail, stops)
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestResolveStoppedBy(t *testing.T) {
+	stops := []string{"</json>", "END", "STOP"}
+
+	// 服务提供商返回的停止序列，转换为请求中的取值
+	assert.Equal(t, "END", resolveStoppedBy("END", FinishReasonStop, "", stops))
+	assert.Equal(t, "</json>", resolveStoppedBy(" </json>", FinishReasonStop, "", stops))
+
+	// 根据输出内容的结尾推断
+	assert.Equal(t, "STOP", resolveStoppedBy("", FinishReasonStop, "answer: 42 STOP", stops))
+	assert.Equal(t, "", resolveStoppedBy("", FinishReasonStop, "answer: 42", stops))
+	assert.Equal(t, "", resolveStoppedBy("", FinishReasonLength, "answer: 42 STOP", stops))
+
+	// 多个停止序列与结尾匹配时无法确定
+	assert.Equal(t, "", resolveStoppedBy("", FinishReasonStop, "the END", []string{"END", "D"}))
+}
+
+func TestStoppedBy_AnthropicReported(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(data, &body))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"type": "message",
+			"role": "assistant",
+			"content": [{"type": "text", "text": "{\"answer\": 42}"}],
+			"stop_reason": "stop_sequence",
+			"stop_sequence": "</json>",
+			"usage": {"input_tokens": 10, "output_tokens": 5}
+		}`))
+	}))
+	defer server.Close()
+
+	factory := &fakeClientFactory{client: NewAnthropicChat(anthropic.New(server.URL, "sk-test", http.DefaultClient)), typ: service.ProviderAnthropic}
+	d := NewDispatcher(fakeModelRouter{}, factory, "", PayloadPolicyReject)
+
+	res, err := d.Chat(context.TODO(), Request{
+		Model:    "claude-3-opus",
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+		Stop:     []string{"</yaml>", "</json>"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []any{"</yaml>", "</json>"}, body["stop_sequences"])
+	assert.Equal(t, FinishReasonStop, res.FinishReason)
+	assert.Equal(t, "</json>", res.StoppedBy)
+}
+
+func TestStoppedBy_InferredFromStream(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "a,b,c"}, {Text: "\nEN"}, {Text: "D"}}}
+	d := NewDispatcher(fakeModelRouter{}, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	stream, err := d.ChatStream(context.TODO(), Request{
+		Model:    "gpt-4",
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+		Stop:     []string{"END", "STOP"},
+	})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	last := responses[len(responses)-1]
+	assert.Equal(t, FinishReasonStop, last.FinishReason)
+	assert.Equal(t, "END", last.StoppedBy)
+
+	// 请求中未指定停止序列时不推断
+	client.chunks = []Response{{Text: "END", FinishReason: FinishReasonStop}}
+	stream, err = d.ChatStream(context.TODO(), Request{
+		Model:    "gpt-4",
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+	})
+	assert.NoError(t, err)
+
+	responses = assertFinishReasonConformance(t, stream)
+	assert.Equal(t, "", responses[len(responses)-1].StoppedBy)
+}
@@ -0,0 +1,32 @@
+package chat
+
+import "context"
+
+// pipeStream 在新的协程中使用 fn 处理上游的响应流 in，fn 通过 send 输出响应，返回新的响应流
+//
+// send 在请求取消时返回 false，此时 fn 应该立即返回；fn 返回之后（包括提前退出）消费完上游剩余的数据，
+// 避免上游协程阻塞，然后关闭输出的响应流。fn 中切换了上游的响应流时（如重试），需要自行消费完新的响应流（参考 drainStream）
+func pipeStream(ctx context.Context, in <-chan Response, fn func(send func(Response) bool)) <-chan Response {
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+		defer drainStream(in)
+
+		fn(func(data Response) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case res <- data:
+				return true
+			}
+		})
+	}()
+
+	return res
+}
+
+// drainStream 消费完响应流中剩余的数据
+func drainStream(stream <-chan Response) {
+	for range stream {
+	}
+}
@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestPipeStream(t *testing.T) {
+	in := make(chan Response)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(in)
+
+		for _, text := range []string{"a", "b", "c"} {
+			in <- Response{Text: text}
+		}
+	}()
+
+	// 处理函数提前退出时，上游的数据被消费完，输出的响应流被关闭
+	res := pipeStream(context.TODO(), in, func(send func(Response) bool) {
+		data := <-in
+		send(data)
+	})
+
+	var texts []string
+	for data := range res {
+		texts = append(texts, data.Text)
+	}
+	assert.Equal(t, []string{"a"}, texts)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("upstream is blocked")
+	}
+}
+
+func TestPipeStream_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	in := make(chan Response, 1)
+	in <- Response{Text: "a"}
+	close(in)
+
+	// 没有读取输出的响应流，请求取消时 send 返回 false，不会阻塞
+	sent := make(chan bool, 1)
+	res := pipeStream(ctx, in, func(send func(Response) bool) {
+		sent <- send(<-in)
+	})
+
+	assert.False(t, <-sent)
+	_, ok := <-res
+	assert.False(t, ok)
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/baidu"
+	"github.com/mylxsw/go-utils/array"
+)
+
+// systemPromptSeparator 合并为一条 system 消息时，各个来源之间的分隔符
+const systemPromptSeparator = "\n"
+
+// systemFoldAck 服务提供商不支持 system 角色时，system 消息转换为 user 消息之后追加的 assistant 确认消息
+const systemFoldAck = "好的"
+
+// SystemPrompts 系统提示语的所有来源
+type SystemPrompts struct {
+	// Model 模型级别的提示语（models.meta.prompt）
+	Model string
+	// Provider 服务提供商级别的默认提示语（models.providers[].prompt）
+	Provider string
+	// Style 输出格式的提示语（Request.OutputStyle），放在用户请求中的 system 消息之前，用户明确要求的格式优先
+	Style string
+	// Persona 角色提示语，如首页模型的设定，设置后会替代用户请求中的 system 消息
+	Persona string
+	// User 用户请求中的 system 消息，保持原始顺序
+	User []string
+	// Notice 请求处理过程中产生的说明（如图片已被移除），模型必须知道这些信息才能正确回答，不会被 Persona 替代
+	Notice string
+	// Language 回复语言的提示语，来自请求参数的默认值（RequestDefaults.ReplyLanguage），始终放在最后
+	Language string
+}
+
+// assembleSystemPrompt 按照固定的优先级合并系统提示语，这是构造系统提示语的唯一入口
+//
+// 顺序为：Model → Provider → Style → Persona/User → Notice → Language，空的来源会被忽略，Persona 不为空时，忽略 User。
+// multi 为 true 时（服务提供商支持多条 system 消息），按照上述顺序返回多条 system 消息，
+// 否则使用 systemPromptSeparator 合并为一条 system 消息。没有任何提示语时返回 nil。
+func assembleSystemPrompt(prompts SystemPrompts, multi bool) Messages {
+	sources := []string{prompts.Model, prompts.Provider, prompts.Style}
+	if strings.TrimSpace(prompts.Persona) != "" {
+		sources = append(sources, prompts.Persona)
+	} else {
+		sources = append(sources, prompts.User...)
+	}
+	sources = append(sources, prompts.Notice, prompts.Language)
+
+	contents := make([]string, 0, len(sources))
+	for _, src := range sources {
+		if strings.TrimSpace(src) != "" {
+			contents = append(contents, src)
+		}
+	}
+
+	if len(contents) == 0 {
+		return nil
+	}
+
+	if !multi {
+		return Messages{{Role: RoleSystem, Content: strings.Join(contents, systemPromptSeparator)}}
+	}
+
+	ret := make(Messages, 0, len(contents))
+	for _, content := range contents {
+		ret = append(ret, Message{Role: RoleSystem, Content: content})
+	}
+
+	return ret
+}
+
+// supportMultiSystemPrompts 服务提供商是否支持多条 system 消息
+func supportMultiSystemPrompts(imp Chat) bool {
+	switch imp.(type) {
+	case *OpenAIChat, *OneAPIChat, *OpenRouterChat:
+		return true
+	}
+
+	return false
+}
+
+// systemRoleSupporter 根据模型选择服务提供商的 Chat 实现（如 Dispatcher），返回实际处理请求的服务提供商是否支持 system 角色
+type systemRoleSupporter interface {
+	SupportSystemRole(model string) bool
+}
+
+// supportSystemRole 服务提供商是否支持 system 角色，不支持时 system 消息会转换为 user 消息（参考 foldSystemMessages）
+func supportSystemRole(imp Chat, model string) bool {
+	switch c := imp.(type) {
+	case *GoogleChat, *DashScopeChat, *XFYunChat:
+		return false
+	case *BaiduAIChat:
+		return baidu.SupportSystemMessage(baidu.Model(strings.TrimPrefix(model, "文心千帆:")))
+	case systemRoleSupporter:
+		return c.SupportSystemRole(model)
+	}
+
+	return true
+}
+
+// foldSystemMessage 将 system 消息转换为 user 消息，并追加一条 assistant 确认消息，保持 user/assistant 交替
+func foldSystemMessage(msg Message) Messages {
+	msg.Role = RoleUser
+	return Messages{msg, {Role: RoleAssistant, Content: systemFoldAck}}
+}
+
+// foldSystemMessages 按照不支持 system 角色的服务提供商实际收到的结构转换消息：所有 system 消息合并后（与 assembleSystemPrompt 相同）
+// 转换为对话开头的 user 消息和 assistant 确认消息，其它消息保持原始顺序，用于计算 Token 数量
+func foldSystemMessages(messages Messages) Messages {
+	systemMessages := array.Filter(messages, func(item Message, _ int) bool { return item.Role == RoleSystem })
+	if len(systemMessages) == 0 {
+		return messages
+	}
+
+	contents := array.Map(systemMessages, func(item Message, _ int) string { return item.Text() })
+	folded := foldSystemMessage(Message{Role: RoleSystem, Content: strings.Join(contents, systemPromptSeparator)})
+
+	return append(folded, array.Filter(messages, func(item Message, _ int) bool { return item.Role != RoleSystem })...)
+}
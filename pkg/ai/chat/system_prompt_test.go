@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/baidu"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestAssembleSystemPrompt(t *testing.T) {
+	// 遍历所有提示语来源存在/不存在的组合
+	for mask := 0; mask < 128; mask++ {
+		prompts := SystemPrompts{}
+		if mask&1 != 0 {
+			prompts.Model = "model"
+		}
+		if mask&2 != 0 {
+			prompts.Provider = "provider"
+		}
+		if mask&4 != 0 {
+			prompts.Persona = "persona"
+		}
+		if mask&8 != 0 {
+			prompts.User = []string{"user #1", "", "user #2"}
+		}
+		if mask&16 != 0 {
+			prompts.Language = "language"
+		}
+		if mask&32 != 0 {
+			prompts.Style = "style"
+		}
+		if mask&64 != 0 {
+			prompts.Notice = "notice"
+		}
+
+		var expected []string
+		for _, src := range []string{prompts.Model, prompts.Provider, prompts.Style, prompts.Persona} {
+			if src != "" {
+				expected = append(expected, src)
+			}
+		}
+		if prompts.Persona == "" && len(prompts.User) > 0 {
+			expected = append(expected, "user #1", "user #2")
+		}
+		if prompts.Notice != "" {
+			expected = append(expected, prompts.Notice)
+		}
+		if prompts.Language != "" {
+			expected = append(expected, prompts.Language)
+		}
+
+		name := fmt.Sprintf("mask=%07b", mask)
+		t.Run(name, func(t *testing.T) {
+			multi := assembleSystemPrompt(prompts, true)
+			single := assembleSystemPrompt(prompts, false)
+
+			if len(expected) == 0 {
+				assert.Equal(t, 0, len(multi))
+				assert.Equal(t, 0, len(single))
+				return
+			}
+
+			assert.Equal(t, len(expected), len(multi))
+			for i, content := range expected {
+				assert.Equal(t, RoleSystem, multi[i].Role)
+				assert.Equal(t, content, multi[i].Content)
+			}
+
+			assert.Equal(t, 1, len(single))
+			assert.Equal(t, RoleSystem, single[0].Role)
+
+			var joined string
+			for i, content := range expected {
+				if i > 0 {
+					joined += systemPromptSeparator
+				}
+				joined += content
+			}
+			assert.Equal(t, joined, single[0].Content)
+		})
+	}
+}
+
+func TestSupportMultiSystemPrompts(t *testing.T) {
+	assert.True(t, supportMultiSystemPrompts(&OpenAIChat{}))
+	assert.True(t, supportMultiSystemPrompts(&OpenRouterChat{}))
+	assert.False(t, supportMultiSystemPrompts(&AnthropicChat{}))
+	assert.False(t, supportMultiSystemPrompts(&TencentAIChat{}))
+}
+
+func TestSupportSystemRole(t *testing.T) {
+	assert.True(t, supportSystemRole(&OpenAIChat{}, "gpt-4o"))
+	assert.True(t, supportSystemRole(&TencentAIChat{}, "hunyuan"))
+	assert.False(t, supportSystemRole(&GoogleChat{}, "gemini-pro"))
+	assert.False(t, supportSystemRole(&DashScopeChat{}, "qwen-max"))
+	assert.True(t, supportSystemRole(&BaiduAIChat{}, "文心千帆:"+string(baidu.ModelErnieBot)))
+	assert.False(t, supportSystemRole(&BaiduAIChat{}, string(baidu.ModelLlama2_70b)))
+
+	// 包装的 Chat 实现按照实际处理请求的服务提供商判断
+	assert.False(t, supportSystemRole(NewServerToolChat(&GoogleChat{}), "gemini-pro"))
+	assert.True(t, supportSystemRole(NewServerToolChat(ChatTestClient{}), "gpt-4o"))
+}
+
+func TestRequestFix_FoldedSystemMessages(t *testing.T) {
+	req := Request{
+		Model: string(baidu.ModelLlama2_70b),
+		Messages: Messages{
+			{Role: RoleSystem, Content: "You are a helpful assistant, answer the questions in detail and cite your sources whenever possible."},
+			{Role: RoleSystem, Content: "Reply in Chinese."},
+			{Role: RoleUser, Content: "What is the capital of France?"},
+			{Role: RoleAssistant, Content: "The capital of France is Paris."},
+			{Role: RoleUser, Content: "And Germany?"},
+		},
+	}
+
+	client := &BaiduAIChat{}
+	fixed, _, err := req.Fix(client, 10, 1000)
+	assert.NoError(t, err)
+
+	// 服务提供商实际收到的消息：system 消息转换为 user 消息和 assistant 确认消息
+	payload := array.Map(client.initRequest(*fixed).Messages, func(item baidu.ChatMessage, _ int) Message {
+		return Message{Role: Role(item.Role), Content: item.Content}
+	})
+	assert.Equal(t, 5, len(payload))
+	assert.Equal(t, RoleUser, payload[0].Role)
+	assert.Equal(t, systemFoldAck, payload[1].Content)
+
+	// 合并后的 system 消息与实际发送的内容相同时，计算的 Token 数量与实际发送的消息一致
+	merged := *fixed
+	merged.Messages = append(Messages{{Role: RoleSystem, Content: req.Messages[0].Content + systemPromptSeparator + req.Messages[1].Content}}, fixed.Messages[2:]...)
+	payload = array.Map(client.initRequest(merged).Messages, func(item baidu.ChatMessage, _ int) Message {
+		return Message{Role: Role(item.Role), Content: item.Content}
+	})
+
+	expected, err := MessageTokenCount(payload, req.Model)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fixed.InputTokenBreakdown.Total())
+	assert.Equal(t, 0, fixed.InputTokenBreakdown.System)
+
+	// 支持 system 角色的服务提供商，按照原始的 system 消息计算
+	fixed, _, err = req.Fix(ChatTestClient{}, 10, 1000)
+	assert.NoError(t, err)
+	assert.True(t, fixed.InputTokenBreakdown.System > 0)
+}
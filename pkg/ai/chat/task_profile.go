@@ -0,0 +1,172 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// taskClassifyRunes 任务分类时只检查最后一条用户消息的前 taskClassifyRunes 个字符，避免长消息的匹配开销
+const taskClassifyRunes = 2000
+
+// TaskProfile 按照任务类型（如 code、translation、creative、factual）调整的采样参数
+//
+// 分类只对最后一条用户消息做关键词和正则匹配，不请求模型：每个命中的关键词或正则计 1 分，
+// 得分最高的配置生效（得分相同时使用排在前面的配置），没有命中任何配置时不调整参数
+type TaskProfile struct {
+	// Name 配置名称（任务类型），记录在响应的 task_profile 字段中，用于对比参数调整的效果
+	Name string `json:"name" yaml:"name"`
+	// Keywords 关键词，不区分大小写
+	Keywords []string `json:"keywords,omitempty" yaml:"keywords,omitempty"`
+	// Patterns 正则表达式（Go RE2 语法），需要不区分大小写时使用 (?i)
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+	// Temperature/TopP 命中该配置时使用的采样参数，为空时不调整
+	Temperature *float64 `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty" yaml:"top_p,omitempty"`
+
+	keywords []string
+	patterns []*regexp.Regexp
+}
+
+// score 消息命中的关键词和正则的数量，text 为转换为小写之前的内容
+func (p *TaskProfile) score(text, lower string) int {
+	var score int
+	for _, kw := range p.keywords {
+		if strings.Contains(lower, kw) {
+			score++
+		}
+	}
+
+	for _, re := range p.patterns {
+		if re.MatchString(text) {
+			score++
+		}
+	}
+
+	return score
+}
+
+// TaskProfiles 任务类型对应的采样参数配置，为 nil 时不做任务分类
+type TaskProfiles []*TaskProfile
+
+// NewTaskProfiles 校验配置并编译正则表达式
+func NewTaskProfiles(profiles []*TaskProfile) (TaskProfiles, error) {
+	names := make(map[string]bool)
+	for i, p := range profiles {
+		if p == nil || p.Name == "" {
+			return nil, fmt.Errorf("task profile #%d: name is required", i)
+		}
+
+		if names[p.Name] {
+			return nil, fmt.Errorf("task profile %s: duplicated name", p.Name)
+		}
+		names[p.Name] = true
+
+		if err := (RequestDefaults{Temperature: p.Temperature, TopP: p.TopP}).Validate(); err != nil {
+			return nil, fmt.Errorf("task profile %s: %w", p.Name, err)
+		}
+
+		p.keywords = make([]string, 0, len(p.Keywords))
+		for _, kw := range p.Keywords {
+			if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+				p.keywords = append(p.keywords, kw)
+			}
+		}
+
+		p.patterns = make([]*regexp.Regexp, 0, len(p.Patterns))
+		for _, pattern := range p.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("task profile %s: invalid pattern %q: %w", p.Name, pattern, err)
+			}
+
+			p.patterns = append(p.patterns, re)
+		}
+	}
+
+	return profiles, nil
+}
+
+// LoadTaskProfiles 从 YAML 文件中加载任务类型对应的采样参数配置，文件内容为 TaskProfile 的列表
+func LoadTaskProfiles(file string) (TaskProfiles, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*TaskProfile
+	if err := yaml.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+
+	return NewTaskProfiles(profiles)
+}
+
+// Classify 根据最后一条用户消息选择任务类型，没有命中任何配置时返回 nil
+func (ps TaskProfiles) Classify(messages Messages) *TaskProfile {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	var text string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			text = messages[i].Text()
+			break
+		}
+	}
+
+	if len(text) > taskClassifyRunes {
+		if runes := []rune(text); len(runes) > taskClassifyRunes {
+			text = string(runes[:taskClassifyRunes])
+		}
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	lower := strings.ToLower(text)
+
+	var matched *TaskProfile
+	var best int
+	for _, p := range ps {
+		if score := p.score(text, lower); score > best {
+			matched, best = p, score
+		}
+	}
+
+	return matched
+}
+
+// Select 为请求选择任务类型对应的采样参数，作为默认值中的一层（优先级低于请求和 higher，即房间的默认值），
+// 返回该层默认值以及生效的配置名称。请求和 higher 已经指定了配置中的所有参数时配置不生效，名称为空
+func (ps TaskProfiles) Select(req Request, higher ...RequestDefaults) (RequestDefaults, string) {
+	p := ps.Classify(req.Messages)
+	if p == nil {
+		return RequestDefaults{}, ""
+	}
+
+	temperature, topP := req.Temperature != nil, req.TopP != nil
+	for _, layer := range higher {
+		temperature = temperature || layer.Temperature != nil
+		topP = topP || layer.TopP != nil
+	}
+
+	var ret RequestDefaults
+	if !temperature {
+		ret.Temperature = p.Temperature
+	}
+	if !topP {
+		ret.TopP = p.TopP
+	}
+
+	if ret.IsEmpty() {
+		return RequestDefaults{}, ""
+	}
+
+	return ret, p.Name
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestTaskProfiles_Classify(t *testing.T) {
+	profiles, err := LoadTaskProfiles("../../../task-profiles.yaml")
+	assert.NoError(t, err)
+
+	cases := map[string]string{
+		"这段 Go 代码为什么报错？\n```go\nfunc main() {}\n```":       "code",
+		"把下面这段话翻译成英文：今天天气很好":                               "translation",
+		"写一首关于秋天的诗":                                        "creative",
+		"光速是多少？":                                           "factual",
+		"你好":                                               "",
+		"let x = 1 为什么不能重新赋值":                              "code",
+		"import pandas as pd\ndf = pd.read_csv(\"a.csv\")": "code",
+		"class Foo:\n    pass":                             "code",
+		// 普通英文中的 let、class、return 等单词不是代码
+		"let me know if you have any questions": "",
+		"what is the class of this ship":        "factual",
+		"can you return the book tomorrow":      "",
+		"import the data into the spreadsheet":  "",
+	}
+
+	for text, expected := range cases {
+		p := profiles.Classify(Messages{{Role: RoleUser, Content: "写一个故事"}, {Role: RoleAssistant, Content: "好的"}, {Role: RoleUser, Content: text}})
+		if expected == "" {
+			assert.True(t, p == nil)
+			continue
+		}
+
+		assert.Equal(t, expected, p.Name)
+	}
+
+	// 没有配置时不分类
+	assert.True(t, TaskProfiles(nil).Classify(Messages{{Role: RoleUser, Content: "写一首诗"}}) == nil)
+}
+
+func TestTaskProfiles_Select(t *testing.T) {
+	temperature, topP := 0.0, 0.95
+	profiles, err := NewTaskProfiles([]*TaskProfile{{Name: "code", Keywords: []string{"代码"}, Temperature: &temperature, TopP: &topP}})
+	assert.NoError(t, err)
+
+	req := Request{Messages: Messages{{Role: RoleUser, Content: "帮我看看这段代码"}}}
+
+	layer, name := profiles.Select(req)
+	assert.Equal(t, "code", name)
+	assert.Equal(t, temperature, *layer.Temperature)
+	assert.Equal(t, topP, *layer.TopP)
+
+	// 请求指定了 temperature，只使用配置中的 top_p
+	custom := 0.7
+	req.Temperature = &custom
+	layer, name = profiles.Select(req)
+	assert.Equal(t, "code", name)
+	assert.True(t, layer.Temperature == nil)
+	assert.Equal(t, topP, *layer.TopP)
+
+	// 请求和房间指定了所有参数时不生效
+	layer, name = profiles.Select(req, RequestDefaults{TopP: &custom})
+	assert.Equal(t, "", name)
+	assert.True(t, layer.IsEmpty())
+
+	// 配置校验
+	_, err = NewTaskProfiles([]*TaskProfile{{Name: "bad", Patterns: []string{"("}}})
+	assert.True(t, err != nil)
+	invalid := 3.0
+	_, err = NewTaskProfiles([]*TaskProfile{{Name: "bad", Temperature: &invalid}})
+	assert.True(t, err != nil)
+}
+
+func TestDispatcher_TaskProfile(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{{Text: "hello"}, {FinishReason: "stop"}}}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+
+	req := Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hi"}}, TaskProfile: "code"}
+	res, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "code", res.TaskProfile)
+
+	stream, err := d.ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	var responses []Response
+	for data := range stream {
+		responses = append(responses, data)
+	}
+	assert.Equal(t, "code", responses[0].TaskProfile)
+}
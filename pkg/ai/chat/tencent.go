@@ -23,7 +23,7 @@ func (chat *TencentAIChat) initRequest(req Request) tencentai.Request {
 
 	for _, msg := range req.Messages {
 		m := tencentai.Message{
-			Role:    msg.Role,
+			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
 
@@ -34,6 +34,7 @@ func (chat *TencentAIChat) initRequest(req Request) tencentai.Request {
 		}
 	}
 
+	// 腾讯混元要求上下文为奇数条并以用户消息结尾，否则请求失败，因此不支持 NoAutoContinue，始终补充“继续”
 	contextMessages = contextMessages.Fix()
 	if len(systemMessages) > 0 {
 		contextMessages = append(tencentai.Messages{systemMessages[0]}, contextMessages...)
@@ -48,16 +49,19 @@ func (chat *TencentAIChat) Chat(ctx context.Context, req Request) (*Response, er
 		return nil, err
 	}
 
-	var content string
+	var content, finishReason string
 	for msg := range stream {
 		if msg.ErrorCode != "" {
 			return nil, fmt.Errorf("%s %s", msg.ErrorCode, msg.Error)
 		}
 
 		content += msg.Text
+		if msg.FinishReason != "" {
+			finishReason = msg.FinishReason
+		}
 	}
 
-	return &Response{Text: content}, nil
+	return &Response{Text: content, FinishReason: finishReason}, nil
 }
 
 func (chat *TencentAIChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
@@ -97,6 +101,7 @@ func (chat *TencentAIChat) ChatStream(ctx context.Context, req Request) (<-chan
 					Text:         data.Choices[0].Delta.Content,
 					InputTokens:  int(data.Usage.PromptTokens),
 					OutputTokens: int(data.Usage.CompletionTokens),
+					FinishReason: NormalizeFinishReason(data.Choices[0].FinishReason),
 				}:
 				}
 			}
@@ -1,11 +1,13 @@
 package chat
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"strings"
+
+	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/go-utils/array"
 	"github.com/pkoukk/tiktoken-go"
-	"strings"
 )
 
 // ReduceMessageContextUpToContextWindow 减少对话上下文到指定的上下文窗口大小
@@ -28,7 +30,7 @@ func ReduceMessageContext(messages Messages, model string, maxTokens int) (reduc
 
 	if num <= maxTokens {
 		// 第一个消息应该是 user 消息
-		if len(messages) > 1 && messages[0].Role == "assistant" {
+		if len(messages) > 1 && messages[0].Role == RoleAssistant {
 			return messages[1:], num, nil
 		}
 
@@ -36,15 +38,101 @@ func ReduceMessageContext(messages Messages, model string, maxTokens int) (reduc
 	}
 
 	if len(messages) <= 1 {
-		return nil, 0, errors.New("对话上下文过长，无法继续生成")
+		return nil, 0, &ContextExceedError{MaxContext: maxTokens, InputTokens: num, Overflow: num - maxTokens}
 	}
 
 	return ReduceMessageContext(messages[1:], model, maxTokens)
 }
 
-// MessageTokenCount 计算对话上下文的 token 数量
+// MessageTokenCount 计算对话上下文的 token 数量，编码不可用时使用估算的 Token 数量（参考 loadTokenEncoding）
 // TODO 不通厂商模型的 Token 计算方式可能不同，需要根据厂商模型进行区分
 func MessageTokenCount(messages Messages, model string) (numTokens int, err error) {
+	counter := newMessageTokenCounter(model)
+	for _, message := range messages {
+		tokens, err := counter.count(message)
+		if err != nil {
+			return 0, err
+		}
+
+		numTokens += tokens
+	}
+	numTokens += replyPrimingTokens
+	return numTokens, nil
+}
+
+// replyPrimingTokens 每个请求的回复都以 <|start|>assistant<|message|> 开头，需要额外计算的 Token 数量
+const replyPrimingTokens = 3
+
+// InputTokenBreakdown 输入 Token 数量按照消息角色的分布，所有字段之和与 MessageTokenCount 的结果相同
+type InputTokenBreakdown struct {
+	System    int `json:"system"`
+	User      int `json:"user"`
+	Assistant int `json:"assistant"`
+	Tool      int `json:"tool"`
+	// Other 不属于任何消息的 Token 数量（回复的起始标记）以及其它角色的消息
+	Other int `json:"other"`
+}
+
+// Total 输入 Token 的总数量
+func (b InputTokenBreakdown) Total() int {
+	return b.System + b.User + b.Assistant + b.Tool + b.Other
+}
+
+// MessageTokenCountByRole 计算对话上下文的 Token 数量，按照消息角色分别统计，计算方式与 MessageTokenCount 相同
+func MessageTokenCountByRole(messages Messages, model string) (InputTokenBreakdown, error) {
+	var ret InputTokenBreakdown
+
+	counter := newMessageTokenCounter(model)
+	for _, message := range messages {
+		tokens, err := counter.count(message)
+		if err != nil {
+			return ret, err
+		}
+
+		switch message.Role {
+		case RoleSystem:
+			ret.System += tokens
+		case RoleUser:
+			ret.User += tokens
+		case RoleAssistant:
+			ret.Assistant += tokens
+		case RoleTool:
+			ret.Tool += tokens
+		default:
+			ret.Other += tokens
+		}
+	}
+	ret.Other += replyPrimingTokens
+
+	return ret, nil
+}
+
+// attachInputTokenBreakdown 为流式响应中包含结束原因的响应补充输入 Token 数量的分布
+func attachInputTokenBreakdown(ctx context.Context, stream <-chan Response, breakdown *InputTokenBreakdown) <-chan Response {
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		for data := range stream {
+			if data.FinishReason != "" {
+				data.InputTokenBreakdown = breakdown
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+	})
+}
+
+// messageTokenCounter 计算单条消息的 Token 数量，计算结果按照消息内容缓存在 messageTokenCache 中
+type messageTokenCounter struct {
+	model string
+	// encoding 使用的编码对应的模型（gpt-3.5-turbo/gpt-4），只在缓存未命中时加载编码
+	encoding         string
+	tkm              *tiktoken.Tiktoken
+	tokensPerMessage int
+}
+
+// newMessageTokenCounter 根据模型选择 Token 的计算方式
+func newMessageTokenCounter(model string) *messageTokenCounter {
 	_model := model
 
 	// 所有非 gpt-3.5-turbo/gpt-4 的模型，都按照 gpt-3.5 的方式处理
@@ -52,11 +140,6 @@ func MessageTokenCount(messages Messages, model string) (numTokens int, err erro
 		_model = "gpt-3.5-turbo"
 	}
 
-	tkm, err := tiktoken.EncodingForModel(_model)
-	if err != nil {
-		return 0, fmt.Errorf("EncodingForModel: %v", err)
-	}
-
 	var tokensPerMessage int
 	if strings.HasPrefix(_model, "gpt-3.5-turbo") {
 		tokensPerMessage = 4
@@ -66,42 +149,101 @@ func MessageTokenCount(messages Messages, model string) (numTokens int, err erro
 		tokensPerMessage = 3
 	}
 
-	for _, message := range messages {
-		numTokens += tokensPerMessage
-		if len(message.MultipartContents) > 0 {
-			for _, content := range message.MultipartContents {
-				if content.Type == "image_url" {
-					// 智谱的 GLM 4V 模型，图片的 token 计算方式不同
-					if model == "glm-4v" {
-						numTokens += 1047
-					} else if strings.HasPrefix(model, "claude-") {
-						// Anthropic 的 claude 系列模型，图片的 token 计算方式不同，这里简单处理
-						// tokens = (width px * height px)/750
-						// https://docs.anthropic.com/claude/docs/vision#image-costs
-						numTokens += 1000
-					} else {
-						if content.ImageURL.Detail == "low" {
-							numTokens += 65
-						} else {
-							// TODO 【价格昂贵，尽量避免】这里可能为 high 或者 auto，简单起见，auto 按照 high 处理
-							// 简单起见，这里假设 high 时大图为 2048x2048，切割为 16 个小图
-							//
-							// high will enable “high res” mode, which first allows the _model to see the low res image
-							// and then creates detailed crops of input images as 512px squares based on the input image size.
-							// Each of the detailed crops uses twice the token budget (65 tokens) for a total of 129 tokens
-							numTokens += 129 * 16
-						}
-					}
+	return &messageTokenCounter{model: model, encoding: _model, tokensPerMessage: tokensPerMessage}
+}
+
+// count 计算单条消息的 Token 数量（包括消息本身的开销和角色），优先使用缓存的结果
+//
+// 编码加载失败时使用估算的 Token 数量，估算的结果不会缓存，编码恢复后重新精确计算
+func (c *messageTokenCounter) count(message Message) (int, error) {
+	key := messageCacheKey(c.model, message)
+	if tokens, ok := messageTokenCache.get(key); ok {
+		observeTokenCountCache(true, tokens)
+		return tokens, nil
+	}
+
+	observeTokenCountCache(false, 0)
+
+	if c.tkm == nil {
+		c.tkm = loadTokenEncoding(c.encoding)
+	}
+
+	tokens, exact := c.safeTokenize(message)
+	if exact {
+		messageTokenCache.set(key, tokens)
+	}
+
+	return tokens, nil
+}
+
+// safeTokenize 计算单条消息的 Token 数量，编码不可用或者编码过程中出现异常时使用估算的结果，exact 表示结果是否精确
+func (c *messageTokenCounter) safeTokenize(message Message) (tokens int, exact bool) {
+	if c.tkm == nil {
+		return c.tokenize(message), false
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			observeTokenizerError(c.encoding, "encode")
+			log.F(log.M{"encoding": c.encoding, "model": c.model}).Errorf("计算 Token 数量失败，使用估算的 Token 数量: %v", err)
 
+			c.tkm = nil
+			tokens, exact = c.tokenize(message), false
+		}
+	}()
+
+	return c.tokenize(message), true
+}
+
+// tokenize 使用编码计算单条消息的 Token 数量，没有编码时使用估算的 Token 数量
+func (c *messageTokenCounter) tokenize(message Message) int {
+	model, tkm := c.model, c.tkm
+
+	encode := estimateTextTokens
+	if tkm != nil {
+		encode = func(text string) int {
+			return len(tkm.Encode(text, nil, nil))
+		}
+	}
+
+	numTokens := c.tokensPerMessage
+	if len(message.MultipartContents) > 0 {
+		for _, content := range message.MultipartContents {
+			if content == nil {
+				continue
+			}
+
+			if content.Type == "image_url" {
+				// 智谱的 GLM 4V 模型，图片的 token 计算方式不同
+				if model == "glm-4v" {
+					numTokens += 1047
+				} else if strings.HasPrefix(model, "claude-") {
+					// Anthropic 的 claude 系列模型，图片的 token 计算方式不同，这里简单处理
+					// tokens = (width px * height px)/750
+					// https://docs.anthropic.com/claude/docs/vision#image-costs
+					numTokens += 1000
 				} else {
-					numTokens += len(tkm.Encode(content.Text, nil, nil))
+					if content.ImageURL == nil || openAIImageDetail(content.ImageURL.Detail) == "low" {
+						numTokens += 65
+					} else {
+						// TODO 【价格昂贵，尽量避免】这里可能为 high 或者 auto，简单起见，auto 按照 high 处理
+						// 简单起见，这里假设 high 时大图为 2048x2048，切割为 16 个小图
+						//
+						// high will enable “high res” mode, which first allows the _model to see the low res image
+						// and then creates detailed crops of input images as 512px squares based on the input image size.
+						// Each of the detailed crops uses twice the token budget (65 tokens) for a total of 129 tokens
+						numTokens += 129 * 16
+					}
 				}
+
+			} else {
+				numTokens += encode(content.Text)
 			}
-		} else {
-			numTokens += len(tkm.Encode(message.Content, nil, nil))
 		}
-		numTokens += len(tkm.Encode(message.Role, nil, nil))
+	} else {
+		numTokens += encode(message.Content)
 	}
-	numTokens += 3
-	return numTokens, nil
+	numTokens += encode(string(message.Role))
+
+	return numTokens
 }
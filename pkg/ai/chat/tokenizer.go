@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mylxsw/asteria/log"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tokenEncodingLoader 加载 tiktoken 编码（编码文件不存在时需要联网下载）
+var tokenEncodingLoader = tiktoken.EncodingForModel
+
+// tokenEncodingRetryInterval 编码加载失败之后，重新加载的间隔时间
+const tokenEncodingRetryInterval = 5 * time.Minute
+
+// tokenEncodings 已经加载的编码，key 为编码对应的模型
+var tokenEncodings sync.Map
+
+// tokenEncoding 编码的加载状态
+type tokenEncoding struct {
+	lock sync.Mutex
+	tkm  *tiktoken.Tiktoken
+	// retryAt 加载失败之后，下次重新加载的时间，为零值时表示没有加载失败过
+	retryAt time.Time
+}
+
+// loadTokenEncoding 加载编码对应模型的 tiktoken 编码，加载失败时返回 nil
+//
+// 加载失败时只在第一次记录错误日志，之后每隔 tokenEncodingRetryInterval 重新加载一次，每次失败都会记录到统计指标中，
+// 避免编码文件缺失时所有请求都无法计算 Token 数量，同时不会掩盖编码不可用的问题
+func loadTokenEncoding(encoding string) *tiktoken.Tiktoken {
+	v, _ := tokenEncodings.LoadOrStore(encoding, &tokenEncoding{})
+	state := v.(*tokenEncoding)
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if state.tkm != nil || time.Now().Before(state.retryAt) {
+		return state.tkm
+	}
+
+	tkm, err := tokenEncodingLoader(encoding)
+	if err != nil {
+		observeTokenizerError(encoding, "load")
+		if state.retryAt.IsZero() {
+			log.F(log.M{"encoding": encoding}).Errorf("加载 Token 编码失败，使用估算的 Token 数量: %v", err)
+		}
+
+		state.retryAt = time.Now().Add(tokenEncodingRetryInterval)
+		return nil
+	}
+
+	if !state.retryAt.IsZero() {
+		log.F(log.M{"encoding": encoding}).Info("Token 编码加载成功，恢复精确计算 Token 数量")
+	}
+
+	state.tkm = tkm
+	return tkm
+}
+
+// estimateTextTokens 估算文本的 Token 数量：ASCII 字符按照每 4 个字符 1 个 Token 计算，其它字符（中文等）每个字符 1 个 Token
+func estimateTextTokens(text string) int {
+	var ascii, others int
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			others++
+		}
+	}
+
+	return (ascii+3)/4 + others
+}
+
+// tokenizerErrors 计算 Token 数量时编码出现错误（load 为加载编码失败，encode 为编码过程中出现异常）的次数统计
+var tokenizerErrors = sync.OnceValue(func() *prometheus.CounterVec {
+	return registerCollector(prometheus.DefaultRegisterer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_tokenizer_error_count",
+		Help:      "tokenizer failures that caused token counts to fall back to estimation",
+	}, []string{"encoding", "stage"}))
+})
+
+// observeTokenizerError 记录编码出现的错误
+func observeTokenizerError(encoding, stage string) {
+	tokenizerErrors().WithLabelValues(encoding, stage).Inc()
+}
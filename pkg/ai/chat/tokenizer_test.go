@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// replaceTokenEncodingLoader 替换编码的加载方式，测试结束后恢复
+func replaceTokenEncodingLoader(t *testing.T, encoding string, loader func(string) (*tiktoken.Tiktoken, error)) {
+	original := tokenEncodingLoader
+	tokenEncodings.Delete(encoding)
+	tokenEncodingLoader = loader
+
+	t.Cleanup(func() {
+		tokenEncodingLoader = original
+		tokenEncodings.Delete(encoding)
+	})
+}
+
+func TestMessageTokenCount_LoadFailure(t *testing.T) {
+	var calls int
+	replaceTokenEncodingLoader(t, "gpt-3.5-turbo", func(string) (*tiktoken.Tiktoken, error) {
+		calls++
+		return nil, errors.New("open cl100k_base.tiktoken: no such file or directory")
+	})
+
+	failures := tokenizerErrors().WithLabelValues("gpt-3.5-turbo", "load")
+	before := testutil.ToFloat64(failures)
+
+	messages := Messages{{Role: RoleUser, Content: "tokenizer load failure: 你好"}}
+	tokens, err := MessageTokenCount(messages, "tokenizer-fallback")
+	assert.NoError(t, err)
+	// 4 (tokensPerMessage) + 6 (ASCII 字符 24 个) + 2 (中文字符) + 1 (角色) + 3 (replyPrimingTokens)
+	assert.Equal(t, 16, tokens)
+	assert.EqualValues(t, before+1, testutil.ToFloat64(failures))
+
+	// 估算的结果不缓存，重新加载的间隔时间内不会重复加载编码
+	_, ok := messageTokenCache.get(messageCacheKey("tokenizer-fallback", messages[0]))
+	assert.False(t, ok)
+
+	tokens, err = MessageTokenCount(messages, "tokenizer-fallback")
+	assert.NoError(t, err)
+	assert.Equal(t, 16, tokens)
+	assert.Equal(t, 1, calls)
+	assert.EqualValues(t, before+1, testutil.ToFloat64(failures))
+
+	// 请求修正不受影响
+	req, inputTokens, err := Request{Model: "tokenizer-fallback", Messages: messages}.Fix(ChatTestClient{}, 0, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(req.Messages))
+	assert.EqualValues(t, 16, inputTokens)
+}
+
+func TestMessageTokenCount_EncodeFailure(t *testing.T) {
+	// 编码数据损坏，编码时出现异常
+	replaceTokenEncodingLoader(t, "gpt-4", func(string) (*tiktoken.Tiktoken, error) {
+		return &tiktoken.Tiktoken{}, nil
+	})
+
+	failures := tokenizerErrors().WithLabelValues("gpt-4", "encode")
+	before := testutil.ToFloat64(failures)
+
+	tokens, err := MessageTokenCount(Messages{{Role: RoleUser, Content: "tokenizer encode failure"}}, "gpt-4")
+	assert.NoError(t, err)
+	// 3 (tokensPerMessage) + 6 (ASCII 字符 24 个) + 1 (角色) + 3 (replyPrimingTokens)
+	assert.Equal(t, 13, tokens)
+	assert.EqualValues(t, before+1, testutil.ToFloat64(failures))
+}
+
+func TestEstimateTextTokens(t *testing.T) {
+	assert.Equal(t, 0, estimateTextTokens(""))
+	assert.Equal(t, 1, estimateTextTokens("user"))
+	assert.Equal(t, 3, estimateTextTokens("hello world"))
+	assert.Equal(t, 4, estimateTextTokens("你好世界"))
+}
@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mylxsw/go-utils/ternary"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Tool 可供模型调用的工具定义（OpenAI 兼容格式）
+type Tool struct {
+	// Type 工具类型，目前只支持 function
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 工具函数定义
+type ToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters 函数参数的 JSON Schema 描述
+	Parameters any `json:"parameters,omitempty"`
+}
+
+// ToolCall 模型发起的工具调用
+type ToolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 工具调用的函数名称和参数（JSON 格式）
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ToolCallDelta 流式输出中，工具调用的中间状态
+type ToolCallDelta struct {
+	// Index 工具调用序号，同一个工具调用的多个片段拥有相同的序号
+	Index int `json:"index"`
+	// ID 工具调用 ID，首个片段中返回
+	ID string `json:"id,omitempty"`
+	// Name 工具名称，一旦确定就会在后续的片段中持续返回
+	Name string `json:"name,omitempty"`
+	// ArgumentsDelta 本次新增的参数片段
+	ArgumentsDelta string `json:"arguments_delta,omitempty"`
+}
+
+func toOpenAITools(tools []Tool) []openai.Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	ret := make([]openai.Tool, 0, len(tools))
+	for _, tool := range tools {
+		ret = append(ret, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionDefinition{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				Parameters:  tool.Function.Parameters,
+			},
+		})
+	}
+
+	return ret
+}
+
+func fromOpenAIToolCalls(calls []openai.ToolCall) []ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	ret := make([]ToolCall, 0, len(calls))
+	for _, call := range calls {
+		ret = append(ret, ToolCall{
+			ID:   call.ID,
+			Type: string(call.Type),
+			Function: ToolCallFunction{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			},
+		})
+	}
+
+	return ret
+}
+
+func toOpenAIToolCalls(calls []ToolCall) []openai.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	ret := make([]openai.ToolCall, 0, len(calls))
+	for _, call := range calls {
+		ret = append(ret, openai.ToolCall{
+			ID:   call.ID,
+			Type: openai.ToolType(ternary.If(call.Type == "", "function", call.Type)),
+			Function: openai.FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			},
+		})
+	}
+
+	return ret
+}
+
+// toolCallAssembler 将流式返回的工具调用片段组装为完整的工具调用
+type toolCallAssembler struct {
+	calls map[int]*ToolCall
+}
+
+func newToolCallAssembler() *toolCallAssembler {
+	return &toolCallAssembler{calls: make(map[int]*ToolCall)}
+}
+
+// Add 添加一个工具调用片段，返回该片段对应的中间状态
+func (asm *toolCallAssembler) Add(index int, id, typ, name, arguments string) ToolCallDelta {
+	call, ok := asm.calls[index]
+	if !ok {
+		call = &ToolCall{Type: "function"}
+		asm.calls[index] = call
+	}
+
+	if id != "" {
+		call.ID = id
+	}
+	if typ != "" {
+		call.Type = typ
+	}
+	if name != "" {
+		call.Function.Name += name
+	}
+
+	call.Function.Arguments += arguments
+
+	return ToolCallDelta{
+		Index:          index,
+		ID:             call.ID,
+		Name:           call.Function.Name,
+		ArgumentsDelta: arguments,
+	}
+}
+
+// Complete 标记工具调用的所有片段已经返回，检查参数是否为合法的 JSON，参数为空时使用空对象
+func (asm *toolCallAssembler) Complete(index int) error {
+	call, ok := asm.calls[index]
+	if !ok {
+		return nil
+	}
+
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		call.Function.Arguments = "{}"
+		return nil
+	}
+
+	if !json.Valid([]byte(call.Function.Arguments)) {
+		return fmt.Errorf("invalid arguments for tool call %s: %s", call.Function.Name, call.Function.Arguments)
+	}
+
+	return nil
+}
+
+// Empty 是否没有任何工具调用
+func (asm *toolCallAssembler) Empty() bool {
+	return len(asm.calls) == 0
+}
+
+// ToolCalls 返回组装完成的工具调用，按照序号排序
+func (asm *toolCallAssembler) ToolCalls() []ToolCall {
+	if len(asm.calls) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, 0, len(asm.calls))
+	for idx := range asm.calls {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	ret := make([]ToolCall, 0, len(indexes))
+	for _, idx := range indexes {
+		ret = append(ret, *asm.calls[idx])
+	}
+
+	return ret
+}
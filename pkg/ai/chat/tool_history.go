@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+)
+
+// hasToolHistory 上下文中是否包含工具调用或者工具调用结果
+func hasToolHistory(messages Messages) bool {
+	for _, msg := range messages {
+		if len(msg.ToolCalls) > 0 || msg.Role == RoleTool {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shouldSummarizeToolHistory 渠道是否需要将上下文中的工具调用转换为纯文本：渠道类型不支持工具调用，
+// 渠道类型要求同时定义工具而请求中没有定义工具（如 Anthropic），
+// 或者渠道配置了 summarize_tool_history（OpenAI 兼容的网关背后的模型不支持工具调用）
+func (d *Dispatcher) shouldSummarizeToolHistory(ctx context.Context, pro repo.ModelProvider, providerType string, req Request) bool {
+	t, ok := service.LookupChannelType(providerType)
+	if !ok || !t.Tools || (t.ToolsRequireDefinitions && len(req.Tools) == 0) {
+		return true
+	}
+
+	if d.channels == nil || pro.ID <= 0 {
+		return false
+	}
+
+	ch, err := d.channels.Channel(ctx, pro.ID)
+	if err != nil {
+		return false
+	}
+
+	return ch.Meta.SummarizeToolHistory
+}
+
+// summarizeToolHistory 将上下文中的工具调用和工具调用结果转换为纯文本，用于不支持工具调用的服务提供商：
+// 助手消息中的工具调用转换为 "[调用工具] 名称(参数)" 追加到助手消息的内容之后，
+// 同一轮中的多条工具调用结果合并为一条用户消息，每个结果以 "[工具 名称 的结果]" 开头。返回新的消息列表，不修改原始消息
+func summarizeToolHistory(messages Messages) Messages {
+	names := make(map[string]string)
+	ret := make(Messages, 0, len(messages))
+	// 上一条消息是否为工具调用结果转换而来的用户消息
+	results := false
+	for _, msg := range messages {
+		merge := results
+		results = msg.Role == RoleTool
+
+		switch {
+		case len(msg.ToolCalls) > 0:
+			lines := make([]string, 0, len(msg.ToolCalls)+1)
+			if text := strings.TrimSpace(msg.Text()); text != "" {
+				lines = append(lines, text)
+			}
+
+			for _, call := range msg.ToolCalls {
+				names[call.ID] = call.Function.Name
+				lines = append(lines, "[调用工具] "+call.Function.Name+"("+call.Function.Arguments+")")
+			}
+
+			ret = append(ret, Message{Role: msg.Role, Content: strings.Join(lines, "\n"), Pinned: msg.Pinned})
+		case msg.Role == RoleTool:
+			name := names[msg.ToolCallID]
+			if name == "" {
+				name = msg.ToolCallID
+			}
+
+			result := "[工具 " + name + " 的结果]\n" + msg.Text()
+			if merge {
+				ret[len(ret)-1].Content += "\n\n" + result
+				continue
+			}
+
+			ret = append(ret, Message{Role: RoleUser, Content: result})
+		default:
+			ret = append(ret, msg)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// toolHistoryMessages 包含两个并行工具调用的对话，最后一轮为新的问题
+var toolHistoryMessages = Messages{
+	{Role: RoleUser, Content: "北京现在的天气和时间？"},
+	{Role: RoleAssistant, Content: "我来查询一下", ToolCalls: []ToolCall{
+		{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"北京"}`}},
+		{ID: "call_2", Type: "function", Function: ToolCallFunction{Name: "get_time", Arguments: `{}`}},
+	}},
+	{Role: RoleTool, ToolCallID: "call_1", Content: "晴"},
+	{Role: RoleTool, ToolCallID: "call_2", Content: "14:30"},
+	{Role: RoleAssistant, Content: "北京现在晴，时间是 14:30"},
+	{Role: RoleUser, Content: "适合出门吗？"},
+}
+
+func TestSummarizeToolHistory(t *testing.T) {
+	assert.Equal(t, Messages{
+		{Role: RoleUser, Content: "北京现在的天气和时间？"},
+		{Role: RoleAssistant, Content: "我来查询一下\n[调用工具] get_weather({\"city\":\"北京\"})\n[调用工具] get_time({})"},
+		{Role: RoleUser, Content: "[工具 get_weather 的结果]\n晴\n\n[工具 get_time 的结果]\n14:30"},
+		{Role: RoleAssistant, Content: "北京现在晴，时间是 14:30"},
+		{Role: RoleUser, Content: "适合出门吗？"},
+	}, summarizeToolHistory(toolHistoryMessages))
+
+	// 原始消息保持不变
+	assert.Equal(t, 2, len(toolHistoryMessages[1].ToolCalls))
+	assert.Equal(t, RoleTool, toolHistoryMessages[2].Role)
+}
+
+func TestDispatcher_ToolHistory(t *testing.T) {
+	req := Request{Model: "gpt-4o", Messages: toolHistoryMessages}
+
+	// 支持工具调用的服务提供商，保留结构化的工具调用
+	client := &streamChatClient{}
+	d, _ := newFlattenTestDispatcher(newTestChannel(1, service.ProviderOpenAI), client)
+	_, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(client.requests[0].Messages))
+	assert.Equal(t, 2, len(client.requests[0].Messages[1].ToolCalls))
+	assert.Equal(t, "call_2", client.requests[0].Messages[3].ToolCallID)
+
+	// 不支持工具调用的服务提供商，工具调用转换为纯文本
+	client = &streamChatClient{}
+	d, factory := newFlattenTestDispatcher(newTestChannel(1, service.ProviderBaiChuan), client)
+	factory.typ = service.ProviderBaiChuan
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, summarizeToolHistory(toolHistoryMessages), client.requests[0].Messages)
+
+	// OpenAI 兼容的渠道配置了 summarize_tool_history
+	client = &streamChatClient{}
+	ch := newTestChannel(1, service.ProviderOpenAI)
+	ch.Meta.SummarizeToolHistory = true
+	d, _ = newFlattenTestDispatcher(ch, client)
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, summarizeToolHistory(toolHistoryMessages), client.requests[0].Messages)
+
+	// Anthropic 支持结构化的工具调用，但是要求请求中同时定义工具
+	client = &streamChatClient{}
+	d, factory = newFlattenTestDispatcher(newTestChannel(1, service.ProviderAnthropic), client)
+	factory.typ = service.ProviderAnthropic
+	_, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, summarizeToolHistory(toolHistoryMessages), client.requests[0].Messages)
+
+	withTools := req
+	withTools.Tools = anthropicWeatherTools
+	_, err = d.Chat(context.TODO(), withTools)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(client.requests[1].Messages[1].ToolCalls))
+	assert.Equal(t, "call_2", client.requests[1].Messages[3].ToolCallID)
+}
@@ -0,0 +1,235 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeOpenAIClient 按照预设的内容返回响应的 OpenAI Client
+type fakeOpenAIClient struct {
+	chunks   []openai2.ChatStreamResponse
+	response openai.ChatCompletionResponse
+	err      error
+	requests []openai.ChatCompletionRequest
+}
+
+func (c *fakeOpenAIClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	c.requests = append(c.requests, request)
+	return c.response, c.err
+}
+
+func (c *fakeOpenAIClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	panic("implement me")
+}
+
+func (c *fakeOpenAIClient) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan openai2.ChatStreamResponse, error) {
+	c.requests = append(c.requests, request)
+
+	res := make(chan openai2.ChatStreamResponse, len(c.chunks))
+	for _, chunk := range c.chunks {
+		res <- chunk
+	}
+	close(res)
+
+	return res, nil
+}
+
+func (c *fakeOpenAIClient) CreateCompletion(ctx context.Context, request openai.CompletionRequest) (openai.CompletionResponse, error) {
+	panic("implement me")
+}
+
+func (c *fakeOpenAIClient) CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan openai2.CompletionStreamResponse, error) {
+	panic("implement me")
+}
+
+func (c *fakeOpenAIClient) CreateImage(ctx context.Context, request openai.ImageRequest) (openai.ImageResponse, error) {
+	panic("implement me")
+}
+
+func (c *fakeOpenAIClient) CreateTranscription(ctx context.Context, request openai.AudioRequest) (openai.AudioResponse, error) {
+	panic("implement me")
+}
+
+func (c *fakeOpenAIClient) CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (io.ReadCloser, error) {
+	panic("implement me")
+}
+
+func (c *fakeOpenAIClient) QuickAsk(ctx context.Context, prompt string, question string, maxTokenCount int) (string, error) {
+	panic("implement me")
+}
+
+func streamChunk(choices ...openai.ChatCompletionStreamChoice) openai2.ChatStreamResponse {
+	return openai2.ChatStreamResponse{
+		ChatResponse: &openai.ChatCompletionStreamResponse{Choices: choices},
+	}
+}
+
+func toolCallChunk(index int, id, name, arguments string) openai2.ChatStreamResponse {
+	return streamChunk(openai.ChatCompletionStreamChoice{
+		Delta: openai.ChatCompletionStreamChoiceDelta{
+			ToolCalls: []openai.ToolCall{
+				{
+					Index:    &index,
+					ID:       id,
+					Type:     openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: name, Arguments: arguments},
+				},
+			},
+		},
+	})
+}
+
+func TestOpenAIChat_ChatStreamToolCalls(t *testing.T) {
+	client := &fakeOpenAIClient{
+		chunks: []openai2.ChatStreamResponse{
+			toolCallChunk(0, "call_1", "search_web", ""),
+			toolCallChunk(0, "", "", `{"query":`),
+			toolCallChunk(0, "", "", `"golang"}`),
+			toolCallChunk(1, "call_2", "get_weather", `{"city":"北京"}`),
+		},
+	}
+
+	req := Request{
+		Model:           "gpt-3.5-turbo",
+		Messages:        Messages{{Role: "user", Content: "hello"}},
+		StreamToolCalls: true,
+		Tools: []Tool{
+			{Type: "function", Function: ToolFunction{Name: "search_web"}},
+			{Type: "function", Function: ToolFunction{Name: "get_weather"}},
+		},
+	}
+
+	stream, err := NewOpenAIChat(client).ChatStream(context.TODO(), req)
+	assert.NoError(t, err)
+
+	var responses []Response
+	for res := range stream {
+		responses = append(responses, res)
+	}
+
+	assert.Equal(t, 2, len(client.requests[0].Tools))
+	assert.Equal(t, 5, len(responses))
+
+	// 中间状态按照顺序返回
+	expectedDeltas := []ToolCallDelta{
+		{Index: 0, ID: "call_1", Name: "search_web"},
+		{Index: 0, ID: "call_1", Name: "search_web", ArgumentsDelta: `{"query":`},
+		{Index: 0, ID: "call_1", Name: "search_web", ArgumentsDelta: `"golang"}`},
+		{Index: 1, ID: "call_2", Name: "get_weather", ArgumentsDelta: `{"city":"北京"}`},
+	}
+	for i, expected := range expectedDeltas {
+		assert.True(t, responses[i].Interim)
+		assert.Equal(t, expected, *responses[i].ToolCallDelta)
+	}
+
+	// 最终组装完成的工具调用
+	final := responses[len(responses)-1]
+	assert.False(t, final.Interim)
+	assert.Equal(t, 2, len(final.ToolCalls))
+	assert.Equal(t, "search_web", final.ToolCalls[0].Function.Name)
+	assert.Equal(t, "get_weather", final.ToolCalls[1].Function.Name)
+
+	var args map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(final.ToolCalls[0].Function.Arguments), &args))
+	assert.Equal(t, "golang", args["query"])
+}
+
+func TestOpenAIChat_ChatStreamToolCallsWithoutInterim(t *testing.T) {
+	client := &fakeOpenAIClient{
+		chunks: []openai2.ChatStreamResponse{
+			toolCallChunk(0, "call_1", "search_web", `{"query":`),
+			toolCallChunk(0, "", "", `"golang"}`),
+		},
+	}
+
+	stream, err := NewOpenAIChat(client).ChatStream(context.TODO(), Request{
+		Model:    "gpt-3.5-turbo",
+		Messages: Messages{{Role: "user", Content: "hello"}},
+	})
+	assert.NoError(t, err)
+
+	var responses []Response
+	for res := range stream {
+		responses = append(responses, res)
+	}
+
+	assert.Equal(t, 1, len(responses))
+	assert.Equal(t, `{"query":"golang"}`, responses[0].ToolCalls[0].Function.Arguments)
+}
+
+func TestDispatcher_ToolCallsOnly(t *testing.T) {
+	client := &fakeOpenAIClient{
+		response: openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{
+					Role:      openai.ChatMessageRoleAssistant,
+					ToolCalls: []openai.ToolCall{{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "get_weather", Arguments: `{"city":"北京"}`}}},
+				},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		},
+		chunks: []openai2.ChatStreamResponse{
+			toolCallChunk(0, "call_1", "get_weather", `{"city":`),
+			toolCallChunk(0, "", "", `"北京"}`),
+			streamChunk(openai.ChatCompletionStreamChoice{FinishReason: openai.FinishReasonToolCalls}),
+		},
+	}
+	d := newDebugTestDispatcher(NewOpenAIChat(client))
+
+	// 只包含工具调用的回答，文本为空，结束原因统一为 tool_calls（即使上游返回 stop）
+	res, err := d.Chat(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, "", res.Text)
+	assert.Equal(t, FinishReasonToolCalls, res.FinishReason)
+	assert.Equal(t, 1, len(res.ToolCalls))
+	assert.Equal(t, "get_weather", res.ToolCalls[0].Function.Name)
+
+	// 流式输出时，组装完成的工具调用在包含结束原因的最后一个响应中返回
+	stream, err := d.ChatStream(context.TODO(), newDebugTestRequest())
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	for _, item := range responses {
+		assert.Equal(t, "", item.Text)
+	}
+
+	last := responses[len(responses)-1]
+	assert.Equal(t, FinishReasonToolCalls, last.FinishReason)
+	assert.Equal(t, 1, len(last.ToolCalls))
+	assert.Equal(t, `{"city":"北京"}`, last.ToolCalls[0].Function.Arguments)
+}
+
+func TestEnsureFinishReason_ToolCallsOnly(t *testing.T) {
+	newStream := func(responses ...Response) <-chan Response {
+		ch := make(chan Response, len(responses))
+		for _, res := range responses {
+			ch <- res
+		}
+		close(ch)
+
+		return ch
+	}
+	calls := []ToolCall{{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "get_weather"}}}
+
+	// 上游没有返回结束原因时，补充 tool_calls
+	responses := assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), newStream(Response{ToolCalls: calls})))
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, FinishReasonToolCalls, responses[1].FinishReason)
+
+	// 上游返回 stop 时，转换为 tool_calls
+	responses = assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), newStream(Response{FinishReason: "stop"}, Response{ToolCalls: calls})))
+	assert.Equal(t, 1, len(responses))
+	assert.Equal(t, FinishReasonToolCalls, responses[0].FinishReason)
+	assert.EqualValues(t, calls, responses[0].ToolCalls)
+
+	// 包含文本内容时不修改结束原因
+	responses = assertFinishReasonConformance(t, ensureFinishReason(context.TODO(), newStream(Response{Text: "hello"}, Response{FinishReason: "stop"}, Response{ToolCalls: calls})))
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, FinishReasonStop, responses[1].FinishReason)
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"strings"
+)
+
+// AssistantTrimPolicy 历史消息中助手消息末尾空白字符的处理策略
+//
+// 部分服务提供商返回的内容末尾带有多余的换行和空格，这些内容作为上下文再次发送时会累积，浪费 Token
+type AssistantTrimPolicy string
+
+const (
+	// AssistantTrimOff 不处理
+	AssistantTrimOff AssistantTrimPolicy = "off"
+	// AssistantTrimAll 去掉每一行以及消息末尾的空白字符，包括代码块中的内容
+	AssistantTrimAll AssistantTrimPolicy = "all"
+	// AssistantTrimProse 只处理代码块之外的内容，代码块中（包括消息末尾未闭合的代码块）的空白字符保持不变
+	AssistantTrimProse AssistantTrimPolicy = "prose"
+)
+
+// trimAssistantMessages 按照策略去掉助手消息中多余的空白字符，返回新的消息列表，不修改原始消息
+func trimAssistantMessages(messages Messages, policy AssistantTrimPolicy) Messages {
+	if policy != AssistantTrimAll && policy != AssistantTrimProse {
+		return messages
+	}
+
+	ret := make(Messages, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == RoleAssistant && len(msg.MultipartContents) == 0 {
+			msg.Content = trimTrailingWhitespace(msg.Content, policy == AssistantTrimProse)
+		}
+
+		ret = append(ret, msg)
+	}
+
+	return ret
+}
+
+// trimTrailingWhitespace 去掉每一行以及内容末尾的空白字符，preserveCode 为 true 时，代码块（``` 包围的内容）中的行保持不变
+func trimTrailingWhitespace(content string, preserveCode bool) string {
+	lines := strings.Split(content, "\n")
+
+	inCode := false
+	for i, line := range lines {
+		fence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		if preserveCode && inCode && !fence {
+			continue
+		}
+
+		if fence {
+			inCode = !inCode
+		}
+
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+
+	// 以未闭合的代码块结尾时，末尾的换行属于代码块的内容
+	if preserveCode && inCode {
+		return strings.Join(lines, "\n")
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), " \t\r\n")
+}
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestTrimAssistantMessages(t *testing.T) {
+	code := "示例代码：  \n```python\ndef main():  \n    pass\n\n```\n\n说明文字  \n\n\n"
+	messages := Messages{
+		{Role: RoleUser, Content: "hello  \n\n"},
+		{Role: RoleAssistant, Content: "你好！有什么可以帮你的吗？\n\n  \n"},
+		{Role: RoleAssistant, Content: code},
+		{Role: RoleAssistant, Content: "```go\nfunc main() {}\n\n"},
+	}
+
+	// 代码块之外的内容去掉行末和末尾的空白，代码块中的内容保持不变
+	trimmed := trimAssistantMessages(messages, AssistantTrimProse)
+	assert.Equal(t, "hello  \n\n", trimmed[0].Content)
+	assert.Equal(t, "你好！有什么可以帮你的吗？", trimmed[1].Content)
+	assert.Equal(t, "示例代码：\n```python\ndef main():  \n    pass\n\n```\n\n说明文字", trimmed[2].Content)
+	// 以未闭合的代码块结尾
+	assert.Equal(t, "```go\nfunc main() {}\n\n", trimmed[3].Content)
+	// 不修改原始消息
+	assert.Equal(t, code, messages[2].Content)
+
+	trimmed = trimAssistantMessages(messages, AssistantTrimAll)
+	assert.Equal(t, "示例代码：\n```python\ndef main():\n    pass\n\n```\n\n说明文字", trimmed[2].Content)
+	assert.Equal(t, "```go\nfunc main() {}", trimmed[3].Content)
+
+	assert.Equal(t, messages, trimAssistantMessages(messages, AssistantTrimOff))
+}
@@ -0,0 +1,204 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrQuotaExceeded 服务提供商账户的额度或者余额已经用完（如达到每月的消费上限、余额不足），
+// 与频率限制不同，充值或者调整额度之前一直失败，不能重试，可以使用 errors.Is 判断 UpstreamError
+var ErrQuotaExceeded = errors.New("服务提供商账户额度已用完")
+
+// quotaExceededCodes 上游返回的表示账户额度或者余额用完的错误类型/错误码
+var quotaExceededCodes = []string{
+	"insufficient_quota",
+	"billing_hard_limit_reached",
+	"billing_not_active",
+	"insufficient_balance",
+	"insufficient_user_quota",
+	"arrearage",
+}
+
+// quotaExceededMessages 上游没有返回明确的错误码时，用于识别账户额度或者余额用完的错误信息（不区分大小写）
+var quotaExceededMessages = []string{
+	"exceeded your current quota",
+	"insufficient balance",
+	"balance exhausted",
+	"credit balance is too low",
+	"余额不足",
+	"额度不足",
+	"额度已用完",
+	"欠费",
+}
+
+// maxErrorBodySize 保存的上游错误响应体最大长度
+const maxErrorBodySize = 4096
+
+// ErrCodeUpstream 上游服务返回错误时的统一错误码
+const ErrCodeUpstream = "UPSTREAM_ERROR"
+
+// UpstreamError 上游服务返回的错误
+//
+// Body 为上游返回的原始错误响应体（已截断，敏感信息已脱敏），只能用于日志和后台管理工具，不能展示给用户
+type UpstreamError struct {
+	Provider   string `json:"provider"`
+	StatusCode int    `json:"status_code,omitempty"`
+	// Type 上游返回的错误类型
+	Type string `json:"type,omitempty"`
+	// Code 上游返回的错误码
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Body    string `json:"body,omitempty"`
+}
+
+// NewUpstreamError 创建上游服务错误，body 会被截断并对敏感信息脱敏
+func NewUpstreamError(provider string, statusCode int, typ, code, message string, body []byte) *UpstreamError {
+	return &UpstreamError{
+		Provider:   provider,
+		StatusCode: statusCode,
+		Type:       typ,
+		Code:       code,
+		Message:    message,
+		Body:       captureErrorBody(body),
+	}
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s upstream error [%d] %s: %s", e.Provider, e.StatusCode, e.ErrorCode(), e.Message)
+}
+
+// Is 上游返回账户额度或者余额用完的错误时，与 ErrQuotaExceeded 匹配
+func (e *UpstreamError) Is(target error) bool {
+	return target == ErrQuotaExceeded && e.quotaExceeded()
+}
+
+// quotaExceeded 是否为账户额度或者余额用完的错误：状态码为 402，或者错误类型、错误码、错误信息表示额度用完，
+// 频率限制（同样返回 429）不包括在内
+func (e *UpstreamError) quotaExceeded() bool {
+	if e.StatusCode == http.StatusPaymentRequired {
+		return true
+	}
+
+	for _, code := range quotaExceededCodes {
+		if strings.EqualFold(e.Type, code) || strings.EqualFold(e.Code, code) {
+			return true
+		}
+	}
+
+	message := strings.ToLower(e.Message)
+	for _, keyword := range quotaExceededMessages {
+		if strings.Contains(message, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrorCode 返回统一错误码，同时附带上游原始的错误类型和错误码，如 UPSTREAM_ERROR:invalid_request_error:content_filter
+func (e *UpstreamError) ErrorCode() string {
+	code := ErrCodeUpstream
+	if e.Type != "" {
+		code += ":" + e.Type
+	}
+	if e.Code != "" {
+		code += ":" + e.Code
+	}
+
+	return code
+}
+
+// Detail 包含原始错误响应体的错误详情，只能用于日志和后台管理工具
+func (e *UpstreamError) Detail() string {
+	if e.Body == "" {
+		return e.Error()
+	}
+
+	return e.Error() + "\n" + e.Body
+}
+
+// ErrorDetail 返回错误详情，如果是上游服务错误，则包含原始错误响应体，如果是所有服务提供商都请求失败，则包含每个服务提供商的失败原因，如果是内容安全策略拦截，则包含命中的内容，只能用于日志和后台管理工具
+func ErrorDetail(err error) string {
+	// 需要在 UpstreamError 之前判断，否则只能得到其中一个服务提供商的错误详情
+	var channelsErr *AllChannelsFailedError
+	if errors.As(err, &channelsErr) {
+		return channelsErr.Detail()
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Detail()
+	}
+
+	var filterErr *ContentFilterError
+	if errors.As(err, &filterErr) {
+		return filterErr.Detail()
+	}
+
+	return err.Error()
+}
+
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{8,}`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9_\-.=]+`),
+	regexp.MustCompile(`(?i)("(?:api[_-]?key|x-api-key|secret|token|authorization)"\s*:\s*")[^"]*(")`),
+}
+
+// captureErrorBody 截断错误响应体，并对其中的敏感信息（密钥等）脱敏
+func captureErrorBody(body []byte) string {
+	if len(body) > maxErrorBodySize {
+		body = body[:maxErrorBodySize]
+	}
+
+	ret := string(body)
+	ret = secretPatterns[0].ReplaceAllString(ret, "sk-***")
+	ret = secretPatterns[1].ReplaceAllString(ret, "${1}***")
+	ret = secretPatterns[2].ReplaceAllString(ret, "${1}***${2}")
+
+	return ret
+}
+
+// wrapOpenAIError 将 OpenAI（包括 Azure OpenAI）返回的错误转换为 UpstreamError，其它错误原样返回
+func wrapOpenAIError(provider string, err error) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		// go-openai 不保留原始响应体，这里重新序列化，保留 Azure 的内容审核分类等详细信息
+		body, _ := json.Marshal(apiErr)
+		code := ""
+		if apiErr.Code != nil {
+			code = fmt.Sprintf("%v", apiErr.Code)
+		}
+
+		return NewUpstreamError(provider, apiErr.HTTPStatusCode, apiErr.Type, code, apiErr.Message, body)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return NewUpstreamError(provider, reqErr.HTTPStatusCode, "", "", reqErr.Error(), []byte(reqErr.Error()))
+	}
+
+	return err
+}
+
+// wrapAnthropicError 将 Anthropic 返回的错误转换为 UpstreamError，其它错误原样返回
+func wrapAnthropicError(err error) error {
+	var httpErr *anthropic.HTTPError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+
+	upstreamErr := NewUpstreamError("anthropic", httpErr.StatusCode, "", "", httpErr.Status, httpErr.Body)
+	if respErr := httpErr.ResponseError(); respErr != nil {
+		upstreamErr.Type = respErr.Type
+		upstreamErr.Message = respErr.Message
+	}
+
+	return upstreamErr
+}
@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/anthropic"
+	openai2 "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCaptureErrorBody(t *testing.T) {
+	body := captureErrorBody([]byte(`{"message":"Incorrect API key provided: sk-abcdefghijklmnop","api_key":"my-secret","header":"Bearer abc.def"}`))
+	assert.False(t, strings.Contains(body, "abcdefghijklmnop"))
+	assert.False(t, strings.Contains(body, "my-secret"))
+	assert.False(t, strings.Contains(body, "abc.def"))
+	assert.True(t, strings.Contains(body, "Incorrect API key provided"))
+
+	assert.Equal(t, maxErrorBodySize, len(captureErrorBody([]byte(strings.Repeat("x", maxErrorBodySize*2)))))
+}
+
+func TestOpenAIChat_ChatUpstreamError(t *testing.T) {
+	param := "messages"
+	client := &fakeOpenAIClient{
+		err: &openai.APIError{
+			Code:           "context_length_exceeded",
+			Message:        "This model's maximum context length is 4097 tokens",
+			Param:          &param,
+			Type:           "invalid_request_error",
+			HTTPStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	_, err := NewOpenAIChat(client).Chat(context.TODO(), Request{
+		Model:    "gpt-3.5-turbo",
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+	})
+
+	var upstreamErr *UpstreamError
+	assert.True(t, errors.As(err, &upstreamErr))
+	assert.Equal(t, "UPSTREAM_ERROR:invalid_request_error:context_length_exceeded", upstreamErr.ErrorCode())
+	assert.True(t, strings.Contains(upstreamErr.Body, `"param":"messages"`))
+
+	// 错误信息中不包含原始响应体，只有详情中包含
+	assert.False(t, strings.Contains(err.Error(), `"param":"messages"`))
+	assert.True(t, strings.Contains(ErrorDetail(err), `"param":"messages"`))
+}
+
+func TestOpenAIChat_ChatStreamUpstreamError(t *testing.T) {
+	apiErr := &openai.APIError{Code: "rate_limit_exceeded", Message: "Rate limit reached", Type: "requests", HTTPStatusCode: http.StatusTooManyRequests}
+	client := &fakeOpenAIClient{
+		chunks: []openai2.ChatStreamResponse{
+			{Code: "READ_STREAM_FAILED", ErrorMessage: "read stream failed", Err: apiErr},
+		},
+	}
+
+	stream, err := NewOpenAIChat(client).ChatStream(context.TODO(), Request{
+		Model:    "gpt-3.5-turbo",
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+	})
+	assert.NoError(t, err)
+
+	res := <-stream
+	assert.Equal(t, "READ_STREAM_FAILED:UPSTREAM_ERROR:requests:rate_limit_exceeded", res.ErrorCode)
+	assert.Equal(t, "read stream failed", res.Error)
+	assert.EqualValues(t, http.StatusTooManyRequests, res.Upstream.StatusCode)
+}
+
+func TestWrapAnthropicError(t *testing.T) {
+	err := wrapAnthropicError(&anthropic.HTTPError{
+		StatusCode: http.StatusBadRequest,
+		Status:     "400 Bad Request",
+		Body:       []byte(`{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: field required"}}`),
+	})
+
+	var upstreamErr *UpstreamError
+	assert.True(t, errors.As(err, &upstreamErr))
+	assert.Equal(t, "invalid_request_error", upstreamErr.Type)
+	assert.Equal(t, "max_tokens: field required", upstreamErr.Message)
+	assert.Equal(t, "UPSTREAM_ERROR:invalid_request_error", upstreamErr.ErrorCode())
+
+	// 其它错误原样返回
+	other := errors.New("network error")
+	assert.Equal(t, other, wrapAnthropicError(other))
+}
+
+func TestUpstreamError_QuotaExceeded(t *testing.T) {
+	// OpenAI 达到消费上限时返回 429，与频率限制的状态码相同
+	_, err := NewOpenAIChat(&fakeOpenAIClient{err: &openai.APIError{
+		Code:           "insufficient_quota",
+		Type:           "insufficient_quota",
+		Message:        "You exceeded your current quota, please check your plan and billing details.",
+		HTTPStatusCode: http.StatusTooManyRequests,
+	}}).Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	// Anthropic 余额不足
+	err = wrapAnthropicError(&anthropic.HTTPError{
+		StatusCode: http.StatusBadRequest,
+		Status:     "400 Bad Request",
+		Body:       []byte(`{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low to access the Anthropic API."}}`),
+	})
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	for _, err := range []error{
+		NewUpstreamError("deepseek", http.StatusPaymentRequired, "", "", "Insufficient Balance", nil),
+		NewUpstreamError("oneapi", http.StatusForbidden, "one_api_error", "insufficient_user_quota", "用户额度不足", nil),
+		NewUpstreamError("openai", http.StatusBadRequest, "", "", "account balance exhausted", nil),
+		NewUpstreamError("openai", http.StatusForbidden, "", "", "账户余额不足，请充值后重试", nil),
+	} {
+		assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	}
+
+	// 频率限制以及其它错误不属于额度用完
+	for _, err := range []error{
+		NewUpstreamError("openai", http.StatusTooManyRequests, "requests", "rate_limit_exceeded", "Rate limit reached for gpt-4o", nil),
+		NewUpstreamError("openai", http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", "maximum context length", nil),
+		errors.New("insufficient_quota"),
+	} {
+		assert.False(t, errors.Is(err, ErrQuotaExceeded))
+	}
+}
@@ -0,0 +1,178 @@
+package chat
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mylxsw/asteria/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Token 数量对账的类型
+const (
+	tokenKindInput  = "input"
+	tokenKindOutput = "output"
+)
+
+// tokenReconciliation 本地估算的 Token 数量与服务提供商返回的实际用量的对比，用于评估和调整 Token 数量的估算方式
+type tokenReconciliation struct {
+	// Model 计算 Token 数量使用的模型（模型重写之前的名称）
+	Model string
+	// Kind 对账的类型：input/output
+	Kind string
+	// Estimated 本地估算的 Token 数量
+	Estimated int
+	// Actual 服务提供商返回的 Token 数量
+	Actual int
+	// Delta 估算值与实际值的差（Estimated - Actual），大于 0 表示估算偏多
+	Delta int
+	// ErrorPercent 估算误差占实际值的百分比（Delta / Actual * 100）
+	ErrorPercent float64
+}
+
+// reconcileTokens 对比估算值与实际值，只返回服务提供商返回了实际用量（大于 0）的类型
+func reconcileTokens(model string, estimatedInput, actualInput, estimatedOutput, actualOutput int) []tokenReconciliation {
+	ret := make([]tokenReconciliation, 0, 2)
+	for _, item := range []struct {
+		kind              string
+		estimated, actual int
+	}{
+		{kind: tokenKindInput, estimated: estimatedInput, actual: actualInput},
+		{kind: tokenKindOutput, estimated: estimatedOutput, actual: actualOutput},
+	} {
+		if item.actual <= 0 {
+			continue
+		}
+
+		delta := item.estimated - item.actual
+		ret = append(ret, tokenReconciliation{
+			Model:        model,
+			Kind:         item.kind,
+			Estimated:    item.estimated,
+			Actual:       item.actual,
+			Delta:        delta,
+			ErrorPercent: float64(delta) / float64(item.actual) * 100,
+		})
+	}
+
+	return ret
+}
+
+// tokenReconcileMetrics Token 数量对账的统计信息
+type tokenReconcileMetrics struct {
+	// errorPercent 估算误差百分比的分布
+	errorPercent *prometheus.HistogramVec
+	// tokens 估算和实际的 Token 数量累计值，两者的比值可以用于调整估算方式
+	tokens *prometheus.CounterVec
+}
+
+// newTokenReconcileMetrics 创建 Token 数量对账的统计信息，并注册到 registerer
+func newTokenReconcileMetrics(registerer prometheus.Registerer) *tokenReconcileMetrics {
+	return &tokenReconcileMetrics{
+		errorPercent: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "aidea",
+			Name:      "chat_token_estimate_error_percent",
+			Help:      "percentage error of locally estimated tokens against provider reported usage",
+			Buckets:   []float64{-50, -25, -10, -5, -1, 1, 5, 10, 25, 50},
+		}, []string{"model", "kind"})),
+		tokens: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "aidea",
+			Name:      "chat_token_reconcile_tokens",
+			Help:      "locally estimated and provider reported tokens of reconciled requests",
+		}, []string{"model", "kind", "source"})),
+	}
+}
+
+// observe 记录对账结果
+func (m *tokenReconcileMetrics) observe(item tokenReconciliation) {
+	m.errorPercent.WithLabelValues(item.Model, item.Kind).Observe(item.ErrorPercent)
+	m.tokens.WithLabelValues(item.Model, item.Kind, "estimated").Add(float64(item.Estimated))
+	m.tokens.WithLabelValues(item.Model, item.Kind, "actual").Add(float64(item.Actual))
+
+	log.F(log.M{
+		"model":         item.Model,
+		"kind":          item.Kind,
+		"estimated":     item.Estimated,
+		"actual":        item.Actual,
+		"delta":         item.Delta,
+		"error_percent": item.ErrorPercent,
+	}).Debugf("token estimate reconciliation")
+}
+
+// usageReconcileChat 服务提供商返回了实际用量时，与本地估算的 Token 数量（与计费相同的计算方式）对账
+type usageReconcileChat struct {
+	imp Chat
+	// model 计算 Token 数量使用的模型，与计费相同（模型重写之前的名称）
+	model       string
+	countTokens func(messages Messages, model string) (int, error)
+	metrics     *tokenReconcileMetrics
+}
+
+func (c *usageReconcileChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	res, err := c.imp.Chat(ctx, req)
+	if err == nil && res != nil && res.ErrorCode == "" {
+		c.reconcile(req, res.Text, res.InputTokens, res.OutputTokens)
+	}
+
+	return res, err
+}
+
+func (c *usageReconcileChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	stream, err := c.imp.ChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeStream(ctx, stream, func(send func(Response) bool) {
+		var text strings.Builder
+		var inputTokens, outputTokens int
+		failed := false
+		for data := range stream {
+			if !data.Interim {
+				text.WriteString(data.Text)
+				failed = failed || data.ErrorCode != ""
+
+				// 部分服务提供商在每个分片中返回累计的用量，这里使用最后一次返回的值
+				if data.InputTokens > 0 {
+					inputTokens = data.InputTokens
+				}
+				if data.OutputTokens > 0 {
+					outputTokens = data.OutputTokens
+				}
+			}
+
+			if !send(data) {
+				return
+			}
+		}
+
+		if !failed {
+			c.reconcile(req, text.String(), inputTokens, outputTokens)
+		}
+	}), nil
+}
+
+func (c *usageReconcileChat) MaxContextLength(model string) int {
+	return c.imp.MaxContextLength(model)
+}
+
+// reconcile 服务提供商返回了实际用量时，计算估算值并记录对账结果
+func (c *usageReconcileChat) reconcile(req Request, text string, actualInput, actualOutput int) {
+	if actualInput <= 0 && actualOutput <= 0 {
+		return
+	}
+
+	// 无法估算时不对账，避免把估算失败记录为误差
+	estimatedInput, err := c.countTokens(req.Messages, c.model)
+	if err != nil {
+		actualInput = 0
+	}
+	estimatedOutput, err := c.countTokens(Messages{{Role: RoleAssistant, Content: text}}, c.model)
+	if err != nil {
+		actualOutput = 0
+	}
+
+	for _, item := range reconcileTokens(c.model, estimatedInput, actualInput, estimatedOutput, actualOutput) {
+		c.metrics.observe(item)
+	}
+}
@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReconcileTokens(t *testing.T) {
+	assert.EqualValues(t, []tokenReconciliation{
+		{Model: "gpt-4", Kind: tokenKindInput, Estimated: 110, Actual: 100, Delta: 10, ErrorPercent: 10},
+		{Model: "gpt-4", Kind: tokenKindOutput, Estimated: 30, Actual: 40, Delta: -10, ErrorPercent: -25},
+	}, reconcileTokens("gpt-4", 110, 100, 30, 40))
+
+	// 只对服务提供商返回了实际用量的类型对账
+	assert.EqualValues(t, []tokenReconciliation{
+		{Model: "gpt-4", Kind: tokenKindOutput, Estimated: 30, Actual: 40, Delta: -10, ErrorPercent: -25},
+	}, reconcileTokens("gpt-4", 110, 0, 30, 40))
+	assert.Equal(t, 0, len(reconcileTokens("gpt-4", 110, 0, 30, 0)))
+}
+
+func TestDispatcher_TokenReconcile(t *testing.T) {
+	client := &streamChatClient{chunks: []Response{
+		{Text: "x y "},
+		{Text: "z", FinishReason: "stop", InputTokens: 5, OutputTokens: 4},
+	}}
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1, ModelRewrite: "gpt-4-turbo"}}}}
+
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.tokenReconcile = newTokenReconcileMetrics(prometheus.NewRegistry())
+	// 使用单词数量代替 Token 数量
+	d.countTokens = wordCount
+
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "a b c d"}}})
+	assert.NoError(t, err)
+	assertFinishReasonConformance(t, stream)
+
+	// 按照计费使用的模型（模型重写之前）记录估算值和实际值
+	assert.EqualValues(t, 4, testutil.ToFloat64(d.tokenReconcile.tokens.WithLabelValues("gpt-4", tokenKindInput, "estimated")))
+	assert.EqualValues(t, 5, testutil.ToFloat64(d.tokenReconcile.tokens.WithLabelValues("gpt-4", tokenKindInput, "actual")))
+	assert.EqualValues(t, 3, testutil.ToFloat64(d.tokenReconcile.tokens.WithLabelValues("gpt-4", tokenKindOutput, "estimated")))
+	assert.EqualValues(t, 4, testutil.ToFloat64(d.tokenReconcile.tokens.WithLabelValues("gpt-4", tokenKindOutput, "actual")))
+	assert.Equal(t, 2, testutil.CollectAndCount(d.tokenReconcile.errorPercent))
+
+	// 服务提供商没有返回用量时不对账
+	client.chunks = []Response{{Text: "x y z"}, {FinishReason: "stop"}}
+	stream, err = d.ChatStream(context.TODO(), Request{Model: "gpt-4", Messages: Messages{{Role: RoleUser, Content: "a b c d"}}})
+	assert.NoError(t, err)
+	assertFinishReasonConformance(t, stream)
+
+	assert.EqualValues(t, 4, testutil.ToFloat64(d.tokenReconcile.tokens.WithLabelValues("gpt-4", tokenKindInput, "estimated")))
+	assert.EqualValues(t, 3, testutil.ToFloat64(d.tokenReconcile.tokens.WithLabelValues("gpt-4", tokenKindOutput, "estimated")))
+}
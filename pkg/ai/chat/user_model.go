@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/asteria/log"
+)
+
+// ErrUserModelNotFound 请求中的用户自定义模型不存在，或者当前用户没有使用权限
+var ErrUserModelNotFound = errors.New("自定义模型不存在或者无权使用")
+
+// UserModelStore 用户自定义模型的存储，模型不存在或者用户没有使用权限时返回 repo.ErrNotFound
+type UserModelStore interface {
+	UserModel(ctx context.Context, code string, userID int64) (*model.UserModels, error)
+}
+
+// MemoryUserModelStore 基于内存的用户自定义模型存储，key 为模型标识
+type MemoryUserModelStore map[string]model.UserModels
+
+func (s MemoryUserModelStore) UserModel(ctx context.Context, code string, userID int64) (*model.UserModels, error) {
+	um, ok := s[code]
+	if !ok || !repo.UserModelAccessible(um, userID) {
+		return nil, repo.ErrNotFound
+	}
+
+	return &um, nil
+}
+
+// ResolveUserModel 将请求中的用户自定义模型（user-model@{code}）替换为基础模型，不是自定义模型时请求保持不变
+//
+// 自定义模型的系统提示语作为角色提示语（PersonaPrompt），与其它系统提示语按照统一的优先级组合（参考 assembleSystemPrompt）；
+// 自定义模型的请求参数作为默认值，优先级低于请求中指定的值，高于房间、模型和部署配置中的默认值，因此需要在 ApplyDefaults 之前调用。
+// 替换之后请求中只有基础模型，计费使用基础模型的价格
+func ResolveUserModel(ctx context.Context, store UserModelStore, req Request) (Request, error) {
+	code, ok := repo.ParseUserModelID(req.Model)
+	if !ok {
+		return req, nil
+	}
+
+	if store == nil {
+		return req, fmt.Errorf("%w: %s", ErrUserModelNotFound, req.Model)
+	}
+
+	um, err := store.UserModel(ctx, code, req.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return req, fmt.Errorf("%w: %s", ErrUserModelNotFound, req.Model)
+		}
+
+		return req, fmt.Errorf("query user model %s failed: %w", req.Model, err)
+	}
+
+	// 保存时已经校验过，这里只在数据异常时忽略默认值
+	defaults, err := ParseRequestDefaults(um.ChatDefaults)
+	if err != nil {
+		log.F(log.M{"model": req.Model}).Warningf("invalid user model chat defaults, ignored: %v", err)
+		defaults = RequestDefaults{}
+	}
+
+	req.Model = um.Model
+	if um.SystemPrompt != "" {
+		req.PersonaPrompt = um.SystemPrompt
+	}
+
+	return req.ApplyDefaults(defaults), nil
+}
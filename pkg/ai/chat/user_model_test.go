@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+var testUserModels = MemoryUserModelStore{
+	"tutor": {
+		UserId:       1,
+		Code:         "tutor",
+		Model:        "gpt-4",
+		SystemPrompt: "你是一位数学老师",
+		ChatDefaults: `{"temperature":0.2,"max_tokens":500}`,
+		Visibility:   repo.UserModelVisibilityPrivate,
+	},
+	"poet": {
+		UserId:       1,
+		Code:         "poet",
+		Model:        "gpt-4",
+		SystemPrompt: "你是一位诗人",
+		Visibility:   repo.UserModelVisibilityShared,
+	},
+}
+
+func TestResolveUserModel(t *testing.T) {
+	// 不是自定义模型时保持不变
+	req, err := ResolveUserModel(context.TODO(), testUserModels, Request{Model: "gpt-4"})
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4", req.Model)
+	assert.Equal(t, "", req.PersonaPrompt)
+
+	// 替换为基础模型，自定义模型的参数作为默认值
+	req, err = ResolveUserModel(context.TODO(), testUserModels, Request{Model: repo.UserModelID("tutor"), UserID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4", req.Model)
+	assert.Equal(t, "你是一位数学老师", req.PersonaPrompt)
+	assert.Equal(t, 0.2, *req.Temperature)
+	assert.Equal(t, 500, req.MaxTokens)
+
+	// 请求中指定的参数优先
+	temperature := 0.9
+	req, err = ResolveUserModel(context.TODO(), testUserModels, Request{Model: repo.UserModelID("tutor"), UserID: 1, Temperature: &temperature})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, *req.Temperature)
+	assert.Equal(t, 500, req.MaxTokens)
+
+	// 私有模型只有创建者可以使用
+	for _, userID := range []int64{0, 2} {
+		_, err = ResolveUserModel(context.TODO(), testUserModels, Request{Model: repo.UserModelID("tutor"), UserID: userID})
+		assert.True(t, errors.Is(err, ErrUserModelNotFound))
+	}
+
+	// 通过链接分享的模型所有用户都可以使用
+	req, err = ResolveUserModel(context.TODO(), testUserModels, Request{Model: repo.UserModelID("poet")})
+	assert.NoError(t, err)
+	assert.Equal(t, "你是一位诗人", req.PersonaPrompt)
+
+	_, err = ResolveUserModel(context.TODO(), testUserModels, Request{Model: repo.UserModelID("missing"), UserID: 1})
+	assert.True(t, errors.Is(err, ErrUserModelNotFound))
+}
+
+func TestDispatcher_UserModel(t *testing.T) {
+	client := &streamChatClient{}
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}}}}
+	d := NewDispatcher(router, &fakeClientFactory{client: client, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+	d.userModels = testUserModels
+
+	_, err := d.Chat(context.TODO(), Request{
+		Model:    repo.UserModelID("tutor"),
+		UserID:   1,
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+	})
+	assert.NoError(t, err)
+
+	// 上游请求使用基础模型，自定义模型的系统提示语作为角色提示语
+	req := client.requests[0]
+	assert.Equal(t, "gpt-4", req.Model)
+	assert.Equal(t, RoleSystem, req.Messages[0].Role)
+	assert.Equal(t, "你是一位数学老师", req.Messages[0].Content)
+
+	_, err = d.Chat(context.TODO(), Request{
+		Model:    repo.UserModelID("tutor"),
+		UserID:   2,
+		Messages: Messages{{Role: RoleUser, Content: "hello"}},
+	})
+	assert.True(t, errors.Is(err, ErrUserModelNotFound))
+}
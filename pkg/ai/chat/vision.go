@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/array"
+)
+
+// 所有支持图片的服务提供商都不可用时，包含图片的请求的处理策略（ModelMeta.VisionDegradation）
+const (
+	// VisionDegradationFail 返回 ErrVisionUnavailable 错误
+	VisionDegradationFail = "fail"
+	// VisionDegradationStrip 去掉请求中的图片，使用纯文本的服务提供商回答
+	VisionDegradationStrip = "strip"
+)
+
+// ErrVisionUnavailable 所有支持图片的服务提供商都不可用
+var ErrVisionUnavailable = errors.New("图片理解暂不可用")
+
+// VisionDegradedWarning 去掉请求中的图片时，在响应中返回的警告信息
+const VisionDegradedWarning = "图片理解暂不可用，本次回答忽略了对话中的图片"
+
+// visionDegradedPrompt 去掉请求中的图片时，追加的系统提示语
+const visionDegradedPrompt = "用户在对话中发送了图片，但图片理解功能暂不可用，图片已被移除。请仅根据文字内容回答，如果问题依赖图片内容，请告知用户暂时无法识别图片。"
+
+// strippedImagePlaceholder 只包含图片的消息去掉图片后的内容
+const strippedImagePlaceholder = "[图片]"
+
+// selectProvider 为请求选择服务提供商
+//
+// 账户额度用完被暂停使用的服务提供商不参与选择（都被暂停时仍然按照原有的规则选择）；
+// 包含图片的请求只使用健康的、支持图片的服务提供商，都不可用时根据模型配置的策略返回错误或者降级为纯文本请求，
+// 降级时返回 degraded 为 true；在满足上述条件的服务提供商中，按照提示语的语言选择，最后在选出的范围内避开请求指定的渠道（参考 routeByLanguage）
+func (d *Dispatcher) selectProvider(ctx context.Context, mod repo.Model, req Request) (pro repo.ModelProvider, degraded bool, err error) {
+	if d.health != nil && len(mod.Providers) > 1 {
+		active := array.Filter(mod.Providers, func(item repo.ModelProvider, _ int) bool { return !d.health.Sidelined(item) })
+		if len(active) > 0 {
+			mod.Providers = active
+		}
+	}
+
+	if d.health == nil || !mod.Meta.Vision || len(mod.Providers) == 0 || !req.Messages.HasImage() {
+		return d.routeByLanguage(ctx, mod, req), false, nil
+	}
+
+	vision := array.Filter(mod.Providers, func(item repo.ModelProvider, _ int) bool {
+		return !item.TextOnly && d.health.Healthy(item)
+	})
+	if len(vision) > 0 {
+		mod.Providers = vision
+		return d.routeByLanguage(ctx, mod, req), false, nil
+	}
+
+	if mod.Meta.VisionDegradation != VisionDegradationStrip {
+		return pro, false, ErrVisionUnavailable
+	}
+
+	// 去掉图片后，优先使用健康的服务提供商（纯文本），都不健康时仍然按照原有的规则选择
+	healthy := array.Filter(mod.Providers, func(item repo.ModelProvider, _ int) bool { return d.health.Healthy(item) })
+	if len(healthy) > 0 {
+		mod.Providers = healthy
+	}
+
+	return d.routeByLanguage(ctx, mod, req), true, nil
+}
+
+// stripImages 去掉消息中的图片，只保留文本内容
+func stripImages(messages Messages) Messages {
+	return array.Map(messages, func(msg Message, _ int) Message {
+		if len(msg.MultipartContents) == 0 {
+			return msg
+		}
+
+		msg.Content = msg.Text()
+		if msg.Content == "" {
+			msg.Content = strippedImagePlaceholder
+		}
+
+		msg.MultipartContents = nil
+		return msg
+	})
+}
@@ -0,0 +1,239 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// failingChatClient 请求总是失败
+type failingChatClient struct {
+	ChatTestClient
+}
+
+func (c failingChatClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	return nil, errors.New("upstream unavailable")
+}
+
+func TestHealthTracker(t *testing.T) {
+	now := time.Now()
+	tracker := NewHealthTracker(2, time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	pro := repo.ModelProvider{ID: 1}
+	tracker.Report(pro, errors.New("upstream unavailable"))
+	assert.True(t, tracker.Healthy(pro))
+
+	// 用户取消请求不计入失败次数
+	tracker.Report(pro, context.Canceled)
+	assert.True(t, tracker.Healthy(pro))
+
+	tracker.Report(pro, errors.New("upstream unavailable"))
+	assert.False(t, tracker.Healthy(pro))
+	// 其它渠道不受影响
+	assert.True(t, tracker.Healthy(repo.ModelProvider{ID: 2}))
+
+	// cooldown 之后恢复
+	now = now.Add(time.Minute)
+	assert.True(t, tracker.Healthy(pro))
+
+	// 请求成功时清除失败次数
+	tracker.Report(pro, errors.New("upstream unavailable"))
+	tracker.Report(pro, nil)
+	tracker.Report(pro, errors.New("upstream unavailable"))
+	assert.True(t, tracker.Healthy(pro))
+}
+
+func TestHealthTracker_QuotaExceeded(t *testing.T) {
+	now := time.Now()
+	tracker := NewHealthTracker(3, time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	// 账户额度用完时立即暂停使用，不需要累计失败次数
+	pro := repo.ModelProvider{ID: 1}
+	tracker.Report(pro, NewUpstreamError("deepseek", http.StatusPaymentRequired, "", "", "Insufficient Balance", nil))
+	assert.False(t, tracker.Healthy(pro))
+	assert.True(t, tracker.Sidelined(pro))
+
+	// 暂停时间比普通的失败更长
+	now = now.Add(time.Minute)
+	assert.False(t, tracker.Healthy(pro))
+
+	now = now.Add(defaultQuotaExceededCooldown)
+	assert.True(t, tracker.Healthy(pro))
+	assert.False(t, tracker.Sidelined(pro))
+
+	// 普通的失败不会暂停使用
+	other := repo.ModelProvider{ID: 2}
+	for i := 0; i < 3; i++ {
+		tracker.Report(other, errors.New("upstream unavailable"))
+	}
+	assert.False(t, tracker.Healthy(other))
+	assert.False(t, tracker.Sidelined(other))
+}
+
+func TestDispatcher_SkipQuotaExceededChannel(t *testing.T) {
+	router := fakeModelRouter{
+		"gpt-4o": {
+			Models:    model.Models{ModelId: "gpt-4o"},
+			Providers: []repo.ModelProvider{{ID: 1}, {ID: 2}},
+		},
+	}
+
+	quotaErr := NewUpstreamError("openai", http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", "You exceeded your current quota", nil)
+	client := &flakyChatClient{errs: []error{quotaErr}}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+
+	patterns, err := ParseRetryPatterns([]string{"*:*quota*"})
+	assert.NoError(t, err)
+
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.health = NewHealthTracker(3, time.Minute)
+	d.retryPatterns = patterns
+	d.retryDelay = 0
+
+	req := Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}}
+
+	// 额度用完的错误不在同一个渠道重试，直接切换到其它渠道
+	res, err := d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.Equal(t, 2, client.calls)
+	assert.EqualValues(t, []int64{1, 2}, []int64{factory.providers[0].ID, factory.providers[1].ID})
+
+	// 之后的请求跳过额度用完的渠道
+	res, err = d.Chat(context.TODO(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.EqualValues(t, 2, factory.providers[2].ID)
+}
+
+func TestDispatcher_ModelHealthy(t *testing.T) {
+	router := fakeModelRouter{"gpt-4": {Models: model.Models{ModelId: "gpt-4"}, Providers: []repo.ModelProvider{{ID: 1}, {ID: 2}}}}
+	d := NewDispatcher(router, &fakeClientFactory{client: failingChatClient{}, typ: service.ProviderOpenAI}, "", PayloadPolicyReject)
+
+	// 未统计健康状态时总是健康
+	assert.True(t, d.ModelHealthy(context.TODO(), "gpt-4"))
+
+	tracker := NewHealthTracker(1, time.Minute)
+	d.health = tracker
+
+	tracker.Report(repo.ModelProvider{ID: 1}, errors.New("upstream unavailable"))
+	assert.True(t, d.ModelHealthy(context.TODO(), "gpt-4"))
+
+	// 所有服务提供商都不健康
+	tracker.Report(repo.ModelProvider{ID: 2}, errors.New("upstream unavailable"))
+	assert.False(t, d.ModelHealthy(context.TODO(), "gpt-4"))
+}
+
+func newVisionTestDispatcher(policy string) (*Dispatcher, *fakeClientFactory, *streamChatClient) {
+	router := fakeModelRouter{
+		"gpt-4o": {
+			Models: model.Models{ModelId: "gpt-4o"},
+			Providers: []repo.ModelProvider{
+				{ID: 1},
+				{ID: 2, ModelRewrite: "gpt-3.5-turbo", TextOnly: true},
+			},
+			Meta: repo.ModelMeta{Vision: true, VisionDegradation: policy},
+		},
+	}
+
+	client := &streamChatClient{}
+	factory := &fakeClientFactory{client: client, typ: service.ProviderOpenAI}
+	d := NewDispatcher(router, factory, "", PayloadPolicyReject)
+	d.health = NewHealthTracker(1, time.Minute)
+
+	return d, factory, client
+}
+
+func TestDispatcher_VisionHealthy(t *testing.T) {
+	d, factory, client := newVisionTestDispatcher(VisionDegradationStrip)
+
+	// 纯文本的服务提供商排在前面时，包含图片的请求也不会使用
+	router := d.router.(fakeModelRouter)
+	mod := router["gpt-4o"]
+	mod.Providers = []repo.ModelProvider{mod.Providers[1], mod.Providers[0]}
+	router["gpt-4o"] = mod
+
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(probeImage)}})
+	assert.NoError(t, err)
+	// 没有去掉图片，只提示图片按照低精度识别
+	assert.Equal(t, ImageDetailDefaultedWarning, res.Warning)
+	assert.EqualValues(t, 1, factory.providers[0].ID)
+	assert.True(t, client.requests[0].Messages.HasImage())
+
+	// 不包含图片的请求不受影响
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, factory.providers[1].ID)
+}
+
+func TestDispatcher_VisionDegradationStrip(t *testing.T) {
+	d, factory, client := newVisionTestDispatcher(VisionDegradationStrip)
+
+	// 请求失败后，支持图片的渠道被标记为不健康
+	factory.client = failingChatClient{}
+	_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(probeImage)}})
+	assert.True(t, err != nil)
+
+	factory.client = client
+	factory.providers = nil
+
+	res, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(probeImage)}})
+	assert.NoError(t, err)
+	assert.Equal(t, VisionDegradedWarning, res.Warning)
+
+	// 使用纯文本的服务提供商，去掉图片，并在系统提示语中说明
+	assert.EqualValues(t, 2, factory.providers[0].ID)
+	req := client.requests[0]
+	assert.Equal(t, "gpt-3.5-turbo", req.Model)
+	assert.False(t, req.Messages.HasImage())
+	assert.Equal(t, RoleSystem, req.Messages[0].Role)
+	assert.True(t, strings.Contains(req.Messages[0].Content, "图片理解功能暂不可用"))
+	assert.Equal(t, "what is this?", req.Messages[1].Content)
+
+	// 设置了角色提示语时，用户的 system 消息被替代，但仍然需要告知模型图片已被移除
+	_, err = d.Chat(context.TODO(), Request{
+		Model:         "gpt-4o",
+		PersonaPrompt: "你是一个翻译助手",
+		Messages:      Messages{{Role: RoleSystem, Content: "user system"}, imageMessage(probeImage)},
+	})
+	assert.NoError(t, err)
+	req = client.requests[1]
+	assert.False(t, req.Messages.HasImage())
+	assert.Equal(t, RoleSystem, req.Messages[0].Role)
+	assert.True(t, strings.Contains(req.Messages[0].Content, "你是一个翻译助手"))
+	assert.False(t, strings.Contains(req.Messages[0].Content, "user system"))
+	assert.True(t, strings.Contains(req.Messages[0].Content, visionDegradedPrompt))
+
+	// 流式输出时，在第一个响应中返回警告信息
+	stream, err := d.ChatStream(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(probeImage)}})
+	assert.NoError(t, err)
+
+	responses := assertFinishReasonConformance(t, stream)
+	assert.True(t, responses[0].Interim)
+	assert.Equal(t, VisionDegradedWarning, responses[0].Warning)
+}
+
+func TestDispatcher_VisionDegradationFail(t *testing.T) {
+	d, factory, client := newVisionTestDispatcher("")
+
+	d.health.Report(repo.ModelProvider{ID: 1}, errors.New("upstream unavailable"))
+
+	_, err := d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{imageMessage(probeImage)}})
+	assert.True(t, errors.Is(err, ErrVisionUnavailable))
+	assert.Equal(t, 0, len(factory.providers))
+	assert.Equal(t, 0, len(client.requests))
+
+	// 纯文本请求仍然可以使用其它服务提供商
+	_, err = d.Chat(context.TODO(), Request{Model: "gpt-4o", Messages: Messages{{Role: RoleUser, Content: "hello"}}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,195 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/search"
+	"github.com/mylxsw/asteria/log"
+)
+
+// WebSearchToolName 服务端执行的网页搜索工具
+const WebSearchToolName = "web_search"
+
+const (
+	// webSearchDefaultTimeout 未配置超时时间时，每次搜索（包括读取网页）的超时时间
+	webSearchDefaultTimeout = 20 * time.Second
+	// webSearchDefaultResults 未配置时每次搜索返回的结果数量
+	webSearchDefaultResults = 5
+	// webSearchMaxQueryRunes 搜索关键词的最大长度
+	webSearchMaxQueryRunes = 200
+)
+
+// webSearchToolDefinition 提供给模型的工具定义
+var webSearchToolDefinition = Tool{
+	Type: "function",
+	Function: ToolFunction{
+		Name:        WebSearchToolName,
+		Description: "Search the web for up-to-date information, such as news, current events, prices or anything after your knowledge cutoff. Cite the results you use with their index in square brackets, e.g. [1].",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{
+					"type":        "string",
+					"description": "Search keywords",
+				},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+// WebSearchOptions 请求中 web_search 工具的配置
+type WebSearchOptions struct {
+	// Remaining 本次请求最多可以搜索的次数，用完之后模型仍然可以调用工具，但只会得到达到上限的提示
+	Remaining int
+}
+
+// PageFetcher 读取网页并提取正文
+type PageFetcher interface {
+	FetchText(ctx context.Context, pageURL string, maxRunes int) (string, error)
+}
+
+// WebSearchToolConfig 网页搜索工具的配置
+type WebSearchToolConfig struct {
+	// Results 每次搜索返回给模型的结果数量
+	Results int
+	// FetchPages 读取排名靠前的网页正文的数量，为 0 时只返回搜索结果的摘要
+	FetchPages int
+	// PageMaxRunes 每个网页正文的最大长度（字符数）
+	PageMaxRunes int
+	// Timeout 每次搜索（包括读取网页）的超时时间
+	Timeout time.Duration
+}
+
+// webSearchTool 服务端执行的网页搜索工具：搜索结果（标题、地址、摘要，可选包含网页正文）作为工具调用的结果返回给模型，
+// 同时作为引用来源通过 Response.Citations 返回给用户。只对设置了 Request.WebSearch 的请求生效
+type webSearchTool struct {
+	backend search.Backend
+	fetcher PageFetcher
+	conf    WebSearchToolConfig
+}
+
+func NewWebSearchTool(backend search.Backend, fetcher PageFetcher, conf WebSearchToolConfig) ServerTool {
+	if conf.Results <= 0 {
+		conf.Results = webSearchDefaultResults
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = webSearchDefaultTimeout
+	}
+	if fetcher == nil {
+		conf.FetchPages = 0
+	}
+
+	return &webSearchTool{backend: backend, fetcher: fetcher, conf: conf}
+}
+
+func (t *webSearchTool) Begin(ctx context.Context, req Request) ServerToolSession {
+	if req.WebSearch == nil {
+		return nil
+	}
+
+	return &webSearchSession{tool: t, userID: req.UserID, remaining: req.WebSearch.Remaining}
+}
+
+// webSearchSession 网页搜索工具在一次请求中的状态
+type webSearchSession struct {
+	tool      *webSearchTool
+	userID    int64
+	remaining int
+	// cited 已经返回的引用来源（地址 -> 编号），多次搜索时相同的网页使用相同的编号
+	cited map[string]int
+}
+
+func (sess *webSearchSession) Definition() Tool {
+	return webSearchToolDefinition
+}
+
+// webSearchResult 返回给模型的一条搜索结果
+type webSearchResult struct {
+	Index   int    `json:"index"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+func (sess *webSearchSession) Execute(ctx context.Context, call ToolCall) ToolResult {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil || strings.TrimSpace(args.Query) == "" {
+		return ToolResult{Content: toolResultJSON(map[string]any{"error": "invalid arguments, query is required"})}
+	}
+
+	if sess.remaining <= 0 {
+		return ToolResult{Content: toolResultJSON(map[string]any{"error": "the search limit of this request has been reached, answer with the information you already have"})}
+	}
+	sess.remaining--
+
+	query := misc.SubStringRaw(strings.TrimSpace(args.Query), webSearchMaxQueryRunes)
+
+	searchCtx, cancel := context.WithTimeout(ctx, sess.tool.conf.Timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	items, err := sess.tool.backend.Search(searchCtx, query, sess.tool.conf.Results)
+	if err != nil {
+		log.F(log.M{"user_id": sess.userID, "query": query, "elapse": time.Since(startTime).Seconds()}).Errorf("web search failed: %v", err)
+		return ToolResult{Content: toolResultJSON(map[string]any{"error": "web search failed, answer with the information you already have"})}
+	}
+
+	if len(items) == 0 {
+		return ToolResult{Content: toolResultJSON(map[string]any{"results": []webSearchResult{}})}
+	}
+
+	contents := sess.fetchPages(searchCtx, items)
+
+	if sess.cited == nil {
+		sess.cited = make(map[string]int)
+	}
+
+	results := make([]webSearchResult, 0, len(items))
+	citations := make([]Citation, 0, len(items))
+	for i, item := range items {
+		index, ok := sess.cited[item.URL]
+		if !ok {
+			index = len(sess.cited) + 1
+			sess.cited[item.URL] = index
+			citations = append(citations, Citation{Index: index, Title: item.Title, URL: item.URL, Snippet: item.Snippet})
+		}
+
+		results = append(results, webSearchResult{Index: index, Title: item.Title, URL: item.URL, Snippet: item.Snippet, Content: contents[i]})
+	}
+
+	return ToolResult{Content: toolResultJSON(map[string]any{"results": results}), Citations: citations}
+}
+
+// fetchPages 并发读取排名靠前的网页正文，读取失败的网页只使用摘要
+func (sess *webSearchSession) fetchPages(ctx context.Context, items []search.Result) []string {
+	contents := make([]string, len(items))
+	n := min(sess.tool.conf.FetchPages, len(items))
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			text, err := sess.tool.fetcher.FetchText(ctx, items[i].URL, sess.tool.conf.PageMaxRunes)
+			if err != nil {
+				log.F(log.M{"user_id": sess.userID, "url": items[i].URL}).Warningf("fetch page failed: %v", err)
+				return
+			}
+
+			contents[i] = text
+		}(i)
+	}
+	wg.Wait()
+
+	return contents
+}
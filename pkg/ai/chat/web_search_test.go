@@ -0,0 +1,209 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/search"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// scriptedToolClient 按照顺序返回预设的响应，记录收到的请求
+type scriptedToolClient struct {
+	ChatTestClient
+	responses []Response
+	requests  []Request
+}
+
+func (c *scriptedToolClient) next(req Request) Response {
+	c.requests = append(c.requests, req)
+	if len(c.requests) > len(c.responses) {
+		return Response{Text: "没有更多的响应", FinishReason: FinishReasonStop}
+	}
+
+	return c.responses[len(c.requests)-1]
+}
+
+func (c *scriptedToolClient) Chat(ctx context.Context, req Request) (*Response, error) {
+	res := c.next(req)
+	return &res, nil
+}
+
+func (c *scriptedToolClient) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	res := c.next(req)
+	ch := make(chan Response, 2)
+	ch <- Response{Text: res.Text}
+	ch <- Response{FinishReason: res.FinishReason, ToolCalls: res.ToolCalls}
+	close(ch)
+
+	return ch, nil
+}
+
+func toolCallResponse(calls ...ToolCall) Response {
+	return Response{FinishReason: FinishReasonToolCalls, ToolCalls: calls}
+}
+
+func searchCall(id, query string) ToolCall {
+	return ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: WebSearchToolName, Arguments: `{"query": "` + query + `"}`}}
+}
+
+type fakeSearchBackend struct {
+	queries []string
+	err     error
+}
+
+func (b *fakeSearchBackend) Search(ctx context.Context, query string, count int) ([]search.Result, error) {
+	b.queries = append(b.queries, query)
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	results := []search.Result{
+		{Title: "新闻 " + query, URL: "https://example.com/" + query, Snippet: "摘要"},
+		{Title: "百科", URL: "https://example.com/wiki", Snippet: "百科摘要"},
+	}
+
+	return results[:min(count, len(results))], nil
+}
+
+type fakePageFetcher map[string]string
+
+func (f fakePageFetcher) FetchText(ctx context.Context, pageURL string, maxRunes int) (string, error) {
+	if text, ok := f[pageURL]; ok {
+		return text, nil
+	}
+
+	return "", errors.New("not found")
+}
+
+func TestWebSearchTool_Chat(t *testing.T) {
+	client := &scriptedToolClient{responses: []Response{
+		toolCallResponse(searchCall("call_1", "today")),
+		toolCallResponse(searchCall("call_2", "tomorrow")),
+		{Text: "今天的新闻 [1]", FinishReason: FinishReasonStop},
+	}}
+	backend := &fakeSearchBackend{}
+	tool := NewWebSearchTool(backend, fakePageFetcher{"https://example.com/today": "正文内容"}, WebSearchToolConfig{Results: 2, FetchPages: 1})
+	c := NewServerToolChat(client, tool)
+
+	res, err := c.Chat(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "今天有什么新闻"}}, WebSearch: &WebSearchOptions{Remaining: 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, "今天的新闻 [1]", res.Text)
+	assert.EqualValues(t, []string{"today", "tomorrow"}, backend.queries)
+
+	// 多次搜索中相同的网页使用相同的编号，只返回一次
+	assert.Equal(t, 3, len(res.Citations))
+	assert.EqualValues(t, Citation{Index: 1, Title: "新闻 today", URL: "https://example.com/today", Snippet: "摘要"}, res.Citations[0])
+	assert.Equal(t, 2, res.Citations[1].Index)
+	assert.Equal(t, "https://example.com/wiki", res.Citations[1].URL)
+	assert.Equal(t, 3, res.Citations[2].Index)
+
+	// 搜索结果（包括读取的网页正文）作为工具调用的结果
+	result := client.requests[1].Messages[2]
+	assert.Equal(t, RoleTool, result.Role)
+	assert.Equal(t, "call_1", result.ToolCallID)
+	assert.True(t, strings.Contains(result.Content, "正文内容"))
+	assert.True(t, strings.Contains(client.requests[2].Messages[4].Content, `"index":2`))
+}
+
+func TestWebSearchTool_Limit(t *testing.T) {
+	client := &scriptedToolClient{responses: []Response{
+		toolCallResponse(searchCall("call_1", "a")),
+		toolCallResponse(searchCall("call_2", "b")),
+		{Text: "回答", FinishReason: FinishReasonStop},
+	}}
+	backend := &fakeSearchBackend{}
+	c := NewServerToolChat(client, NewWebSearchTool(backend, nil, WebSearchToolConfig{Results: 1}))
+
+	// 超过请求的搜索次数时不再搜索，工具调用的结果告知模型达到上限
+	res, err := c.Chat(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "搜索"}}, WebSearch: &WebSearchOptions{Remaining: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "回答", res.Text)
+	assert.Equal(t, 1, len(backend.queries))
+	assert.Equal(t, 1, len(res.Citations))
+	assert.True(t, strings.Contains(client.requests[2].Messages[4].Content, "limit"))
+
+	// 搜索失败时模型根据已有的信息回答
+	client = &scriptedToolClient{responses: []Response{toolCallResponse(searchCall("call_1", "a")), {Text: "回答", FinishReason: FinishReasonStop}}}
+	c = NewServerToolChat(client, NewWebSearchTool(&fakeSearchBackend{err: errors.New("quota exceeded")}, nil, WebSearchToolConfig{}))
+	res, err = c.Chat(context.TODO(), Request{Messages: Messages{{Role: RoleUser, Content: "搜索"}}, WebSearch: &WebSearchOptions{Remaining: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(res.Citations))
+	assert.True(t, strings.Contains(client.requests[1].Messages[2].Content, "failed"))
+}
+
+func TestServerToolChat_Stream(t *testing.T) {
+	client := &scriptedToolClient{responses: []Response{
+		toolCallResponse(
+			searchCall("call_1", "cat"),
+			ToolCall{ID: "call_2", Type: "function", Function: ToolCallFunction{Name: ImageToolName, Arguments: `{"prompt": "a cat"}`}},
+		),
+		{Text: "这是一只猫 [1]", FinishReason: FinishReasonStop},
+	}}
+	c := NewServerToolChat(
+		client,
+		NewWebSearchTool(&fakeSearchBackend{}, nil, WebSearchToolConfig{Results: 1}),
+		NewImageTool(&imageToolTestGenerator{}, "dall-e-3", time.Second),
+	)
+
+	stream, err := c.ChatStream(context.TODO(), Request{
+		Messages:  Messages{{Role: RoleUser, Content: "画一只猫"}},
+		WebSearch: &WebSearchOptions{Remaining: 1},
+		ImageTool: &ImageToolOptions{Remaining: 1},
+	})
+	assert.NoError(t, err)
+
+	// 同一轮中调用的多个服务端工具都会执行，引用来源在最终的响应中返回
+	var text string
+	var parts []*MultipartContent
+	var last Response
+	for res := range stream {
+		text += res.Text
+		parts = append(parts, res.Parts...)
+		if res.FinishReason == "" {
+			assert.Equal(t, 0, len(res.Citations))
+		}
+		last = res
+	}
+
+	assert.Equal(t, "这是一只猫 [1]", text)
+	assert.Equal(t, 1, len(parts))
+	assert.Equal(t, FinishReasonStop, last.FinishReason)
+	assert.Equal(t, 1, len(last.Citations))
+
+	// 两个工具都提供给了模型，工具调用的结果按照调用的顺序追加
+	assert.Equal(t, 2, len(client.requests[0].Tools))
+	messages := client.requests[1].Messages
+	assert.Equal(t, 4, len(messages))
+	assert.Equal(t, "call_1", messages[2].ToolCallID)
+	assert.Equal(t, "call_2", messages[3].ToolCallID)
+}
+
+func TestServerToolChat_ClientTools(t *testing.T) {
+	weather := ToolCall{ID: "call_2", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: `{}`}}
+	client := &scriptedToolClient{responses: []Response{toolCallResponse(searchCall("call_1", "a"), weather)}}
+	backend := &fakeSearchBackend{}
+	c := NewServerToolChat(client, NewWebSearchTool(backend, nil, WebSearchToolConfig{}))
+
+	// 同时调用了客户端提供的工具时，只返回客户端的工具调用，由客户端执行
+	res, err := c.Chat(context.TODO(), Request{
+		Messages:  Messages{{Role: RoleUser, Content: "天气"}},
+		Tools:     []Tool{{Type: "function", Function: ToolFunction{Name: "get_weather"}}},
+		WebSearch: &WebSearchOptions{Remaining: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(backend.queries))
+	assert.EqualValues(t, []ToolCall{weather}, res.ToolCalls)
+	assert.Equal(t, 2, len(client.requests[0].Tools))
+}
+
+func TestParseToolLimits(t *testing.T) {
+	limits := ParseToolLimits(4, []string{"1:20", "2:0", "invalid", "3:-1"})
+	assert.Equal(t, 4, limits.Limit(0))
+	assert.Equal(t, 20, limits.Limit(1))
+	assert.Equal(t, 0, limits.Limit(2))
+	assert.Equal(t, 4, limits.Limit(3))
+}
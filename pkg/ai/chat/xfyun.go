@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/mylxsw/go-utils/array"
+	"github.com/mylxsw/go-utils/ternary"
 	"github.com/sashabaranov/go-openai"
 )
 
@@ -26,7 +27,7 @@ func (chat *XFYunChat) initRequest(req Request) (string, []xfyun.Message, error)
 
 	for _, msg := range req.Messages {
 		m := openai.ChatCompletionMessage{
-			Role:    msg.Role,
+			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
 
@@ -67,16 +68,19 @@ func (chat *XFYunChat) Chat(ctx context.Context, req Request) (*Response, error)
 		return nil, err
 	}
 
-	var content string
+	var content, finishReason string
 	for msg := range stream {
 		if msg.ErrorCode != "" {
 			return nil, fmt.Errorf("%s %s", msg.ErrorCode, msg.Error)
 		}
 
 		content += msg.Text
+		if msg.FinishReason != "" {
+			finishReason = msg.FinishReason
+		}
 	}
 
-	return &Response{Text: content}, nil
+	return &Response{Text: content, FinishReason: finishReason}, nil
 }
 
 func (chat *XFYunChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
@@ -119,6 +123,8 @@ func (chat *XFYunChat) ChatStream(ctx context.Context, req Request) (<-chan Resp
 					Text:         data.Payload.Choices.Text[0].Content,
 					InputTokens:  data.Payload.Usage.Text.PromptTokens,
 					OutputTokens: data.Payload.Usage.Text.CompletionTokens,
+					// 讯飞星火没有返回结束原因，状态为 2 表示最后一个文本结果
+					FinishReason: ternary.If(data.Payload.Choices.Status == 2, FinishReasonStop, ""),
 				}:
 				}
 			}
@@ -20,20 +20,21 @@ func NewZhipuChat(ai *zhipuai.ZhipuAI) *ZhipuChat {
 
 func (ai *ZhipuChat) initRequest(req Request) zhipuai.ChatRequest {
 	req.Model = strings.TrimPrefix(req.Model, "zhipu:")
-	req.Messages = req.Messages.Fix()
+	req.Messages = req.fixMessages(req.Messages)
 
 	log.With(req.Messages).Debug("zhipu chat request")
 
 	messages := array.Map(req.Messages, func(item Message, _ int) any {
 		if req.Model == zhipuai.ModelGLM4V && len(item.MultipartContents) > 0 {
 			return zhipuai.MultipartMessage{
-				Role: item.Role,
+				Role: string(item.Role),
 				Content: array.Map(item.MultipartContents, func(m *MultipartContent, _ int) zhipuai.MultipartContent {
 					res := zhipuai.MultipartContent{
 						Type: m.Type,
 						Text: m.Text,
 					}
-					if m.Type == "image_url" {
+					// GLM-4V 不支持图片识别精度，忽略 ImageURL.Detail
+					if m.Type == "image_url" && m.ImageURL != nil {
 						if strings.HasPrefix(m.ImageURL.URL, "http://") || strings.HasPrefix(m.ImageURL.URL, "https://") {
 							res.ImageURL = &zhipuai.MultipartContentImage{
 								URL: m.ImageURL.URL,
@@ -51,7 +52,7 @@ func (ai *ZhipuChat) initRequest(req Request) zhipuai.ChatRequest {
 		}
 
 		return zhipuai.Message{
-			Role:    item.Role,
+			Role:    string(item.Role),
 			Content: item.Content,
 		}
 	})
@@ -74,7 +75,8 @@ func (ai *ZhipuChat) Chat(ctx context.Context, req Request) (*Response, error) {
 	}
 
 	return &Response{
-		Text: resp.Choices[0].Message.Content,
+		Text:         resp.Choices[0].Message.Content,
+		FinishReason: NormalizeFinishReason(resp.Choices[0].FinishReason),
 	}, nil
 }
 
@@ -110,7 +112,8 @@ func (ai *ZhipuChat) ChatStream(ctx context.Context, req Request) (<-chan Respon
 				case <-ctx.Done():
 					return
 				case res <- Response{
-					Text: data.Choices[0].Delta.Content,
+					Text:         data.Choices[0].Delta.Content,
+					FinishReason: NormalizeFinishReason(data.Choices[0].FinishReason),
 				}:
 				}
 			}
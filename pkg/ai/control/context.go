@@ -4,6 +4,8 @@ import "context"
 
 type Control struct {
 	PreferBackup bool `json:"prefer_backup"`
+	// Debug 调试模式，响应中包含实际发送给上游的请求内容
+	Debug bool `json:"debug"`
 }
 
 const controlContextKey = "chat-control"
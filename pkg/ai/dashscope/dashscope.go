@@ -106,6 +106,8 @@ type ChatParameters struct {
 	// EnableSearch 生成时，是否参考夸克搜索的结果。注意：打开搜索并不意味着一定会使用搜索结果；
 	// 如果打开搜索，模型会将搜索结果作为prompt，进而“自行判断”是否生成结合搜索结果的文本，默认为false
 	EnableSearch bool `json:"enable_search,omitempty"`
+	// VLHighResolutionImages 通义千问 VL 模型是否提高输入图片的默认 Token 上限，开启后图片按照原始分辨率处理，消耗更多的 Token
+	VLHighResolutionImages bool `json:"vl_high_resolution_images,omitempty"`
 }
 
 type ChatHistory struct {
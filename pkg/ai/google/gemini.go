@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"github.com/bcicen/jstream"
 	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/ai/bodylimit"
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/proxy"
 	"github.com/mylxsw/glacier/infra"
@@ -21,6 +23,8 @@ import (
 const (
 	RoleUser  = "user"
 	RoleModel = "model"
+	// RoleFunction 函数调用结果（functionResponse）所在消息的角色
+	RoleFunction = "function"
 )
 
 const (
@@ -40,14 +44,19 @@ func newGoogleAI(resolver infra.Resolver, conf *config.Config) *GoogleAI {
 	client := &http.Client{Timeout: 180 * time.Second}
 	restyClient := misc.RestyClient(2).SetTimeout(180 * time.Second)
 
+	var transport http.RoundTripper
 	if conf.SupportProxy() && conf.GoogleAIAutoProxy {
 		resolver.MustResolve(func(pp *proxy.Proxy) {
-			transport := pp.BuildTransport()
-			client.Transport = transport
-			restyClient.SetTransport(transport)
+			transport = pp.BuildTransport()
 		})
 	}
 
+	transport = recorder.NewTransport(transport, "google")
+	client.Transport = transport
+
+	// 非流式请求限制响应大小
+	restyClient.SetTransport(bodylimit.NewTransport(transport, conf.ChatMaxResponseSizeBytes()))
+
 	return &GoogleAI{
 		serverURL: conf.GoogleAIServer,
 		apiKey:    conf.GoogleAIKey,
@@ -73,6 +82,19 @@ type Request struct {
 	Contents         []Message         `json:"contents,omitempty"`
 	SafetySettings   []SafetySetting   `json:"safetySettings,omitempty"`
 	GenerationConfig *GenerationConfig `json:"generationConfig,omitempty"`
+	// Tools 可供模型调用的函数
+	Tools []Tool `json:"tools,omitempty"`
+}
+
+type Tool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+// FunctionDeclaration 函数定义，Parameters 为 OpenAPI Schema 格式的参数定义
+type FunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
 }
 
 func (req *Request) HasImage() bool {
@@ -108,6 +130,22 @@ type Message struct {
 type MessagePart struct {
 	Text       string                 `json:"text,omitempty"`
 	InlineData *MessagePartInlineData `json:"inlineData,omitempty"`
+	// FunctionCall 模型发起的函数调用，只在 model 角色的消息中出现
+	FunctionCall *FunctionCall `json:"functionCall,omitempty"`
+	// FunctionResponse 函数调用的结果，只在 function 角色的消息中出现
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name"`
+	// Args 函数参数，为 JSON 对象
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type FunctionResponse struct {
+	Name string `json:"name"`
+	// Response 函数调用的结果，必须为 JSON 对象
+	Response json.RawMessage `json:"response"`
 }
 
 type MessagePartInlineData struct {
@@ -131,6 +169,20 @@ func (resp *Response) String() string {
 	}, "")
 }
 
+// FunctionCalls 返回所有候选结果中模型发起的函数调用
+func (resp *Response) FunctionCalls() []FunctionCall {
+	var ret []FunctionCall
+	for _, candidate := range resp.Candidates {
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				ret = append(ret, *part.FunctionCall)
+			}
+		}
+	}
+
+	return ret
+}
+
 type Candidate struct {
 	Content       Message        `json:"content,omitempty"`
 	FinishReason  string         `json:"finishReason,omitempty"`
@@ -141,9 +193,13 @@ type Candidate struct {
 type SafetyRating struct {
 	Category    string `json:"category,omitempty"`
 	Probability string `json:"probability,omitempty"`
+	// Blocked 是否因为该分类被拦截
+	Blocked bool `json:"blocked,omitempty"`
 }
 
 type PromptFeedback struct {
+	// BlockReason 输入内容被拦截的原因，未拦截时为空
+	BlockReason   string         `json:"blockReason,omitempty"`
 	SafetyRatings []SafetyRating `json:"safetyRatings,omitempty"`
 }
 
@@ -26,6 +26,14 @@ func (oa *OneAPI) Chat(ctx context.Context, request openai.ChatCompletionRequest
 	return oa.client.CreateChatCompletion(ctx, oa.translate(request))
 }
 
+func (oa *OneAPI) CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan openai2.CompletionStreamResponse, error) {
+	return oa.client.CompletionStream(ctx, request)
+}
+
+func (oa *OneAPI) Completion(ctx context.Context, request openai.CompletionRequest) (response openai.CompletionResponse, err error) {
+	return oa.client.CreateCompletion(ctx, request)
+}
+
 func (oa *OneAPI) translate(request openai.ChatCompletionRequest) openai.ChatCompletionRequest {
 	// Google PaLM-2 模型不支持中文，需要翻译为英文
 	if oa.trans != nil && request.Model == "PaLM-2" {
@@ -12,6 +12,10 @@ type Client interface {
 	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (response openai.ChatCompletionResponse, err error)
 	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (stream *openai.ChatCompletionStream, err error)
 	ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error)
+	// CreateCompletion 使用旧版的文本补全接口（/completions），用于不支持对话格式的基础模型
+	CreateCompletion(ctx context.Context, request openai.CompletionRequest) (response openai.CompletionResponse, err error)
+	// CompletionStream 以流的方式使用旧版的文本补全接口（/completions）
+	CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan CompletionStreamResponse, error)
 	CreateImage(ctx context.Context, request openai.ImageRequest) (response openai.ImageResponse, err error)
 	CreateTranscription(ctx context.Context, request openai.AudioRequest) (response openai.AudioResponse, err error)
 	CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (response io.ReadCloser, err error)
@@ -98,6 +102,57 @@ func (proxy *ClientImpl) ChatStream(ctx context.Context, request openai.ChatComp
 	return stream, err
 }
 
+func (proxy *ClientImpl) CreateCompletion(ctx context.Context, request openai.CompletionRequest) (response openai.CompletionResponse, err error) {
+	ctl := control.FromContext(ctx)
+	if ctl.PreferBackup && proxy.backup != nil {
+		return proxy.backup.CreateCompletion(ctx, request)
+	}
+
+	if proxy.main != nil {
+		response, err = proxy.main.CreateCompletion(ctx, request)
+		if err == nil {
+			return response, nil
+		}
+	}
+
+	if proxy.backup != nil {
+		log.WithFields(log.Fields{
+			"request": request,
+			"error":   err.Error(),
+		}).Warningf("use control openai client")
+		return proxy.backup.CreateCompletion(ctx, request)
+	}
+
+	return response, err
+}
+
+func (proxy *ClientImpl) CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan CompletionStreamResponse, error) {
+	ctl := control.FromContext(ctx)
+	if ctl.PreferBackup && proxy.backup != nil {
+		return proxy.backup.CompletionStream(ctx, request)
+	}
+
+	var stream <-chan CompletionStreamResponse
+	var err error
+
+	if proxy.main != nil {
+		stream, err = proxy.main.CompletionStream(ctx, request)
+		if err == nil {
+			return stream, nil
+		}
+	}
+
+	if proxy.backup != nil {
+		log.WithFields(log.Fields{
+			"request": request,
+			"error":   err.Error(),
+		}).Warningf("use control openai client")
+		return proxy.backup.CompletionStream(ctx, request)
+	}
+
+	return stream, err
+}
+
 func (proxy *ClientImpl) CreateImage(ctx context.Context, request openai.ImageRequest) (response openai.ImageResponse, err error) {
 	ctl := control.FromContext(ctx)
 	if ctl.PreferBackup && proxy.backup != nil {
@@ -10,6 +10,12 @@ type Config struct {
 	OpenAIServers      []string
 	OpenAIKeys         []string
 	AutoProxy          bool
+	// MaxResponseSize 非流式响应的最大字节数，为 0 时使用默认值
+	MaxResponseSize int64
+	// UserAgent 请求时使用的 User-Agent，为空时使用 DefaultUserAgent
+	UserAgent string
+	// ExtraBody 合并到每个请求体中的额外参数（渠道配置），用于 OpenAI 兼容网关支持的扩展参数，不能覆盖请求体中已有的参数
+	ExtraBody map[string]any
 }
 
 func parseMainConfig(conf *config.Config) *Config {
@@ -21,6 +27,7 @@ func parseMainConfig(conf *config.Config) *Config {
 		OpenAIServers:      conf.OpenAIServers,
 		OpenAIKeys:         conf.OpenAIKeys,
 		AutoProxy:          conf.OpenAIAutoProxy,
+		MaxResponseSize:    conf.ChatMaxResponseSizeBytes(),
 	}
 }
 
@@ -33,6 +40,7 @@ func parseBackupConfig(conf *config.Config) *Config {
 		OpenAIServers:      conf.FallbackOpenAIServers,
 		OpenAIKeys:         conf.FallbackOpenAIKeys,
 		AutoProxy:          conf.FallbackOpenAIAutoProxy,
+		MaxResponseSize:    conf.ChatMaxResponseSizeBytes(),
 	}
 }
 
@@ -46,6 +54,7 @@ func parseDalleConfig(conf *config.Config) *Config {
 			OpenAIServers:      conf.OpenAIServers,
 			OpenAIKeys:         conf.OpenAIKeys,
 			AutoProxy:          conf.OpenAIAutoProxy,
+			MaxResponseSize:    conf.ChatMaxResponseSizeBytes(),
 		}
 	}
 
@@ -57,5 +66,6 @@ func parseDalleConfig(conf *config.Config) *Config {
 		OpenAIServers:      conf.OpenAIDalleServers,
 		OpenAIKeys:         conf.OpenAIDalleKeys,
 		AutoProxy:          conf.OpenAIDalleAutoProxy,
+		MaxResponseSize:    conf.ChatMaxResponseSizeBytes(),
 	}
 }
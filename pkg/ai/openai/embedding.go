@@ -0,0 +1,202 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/proxy"
+	"gopkg.in/resty.v1"
+)
+
+const (
+	// EmbeddingMaxInputs OpenAI 的 Embeddings 接口单次请求最多的输入数量，超过时整个请求失败
+	EmbeddingMaxInputs = 2048
+	// embeddingDefaultConcurrency 输入拆分为多个请求之后，同时发起的请求数量
+	embeddingDefaultConcurrency = 4
+)
+
+// EmbeddingClient OpenAI 的 Embeddings 接口（/embeddings），输入数量超过单次请求的上限时自动拆分为多个请求，
+// 按照原始顺序合并结果并汇总用量，调用方不需要关心上限
+type EmbeddingClient struct {
+	conf *Config
+	http *resty.Client
+	// maxInputs 单次请求最多的输入数量
+	maxInputs int
+	// concurrency 拆分之后同时发起的请求数量
+	concurrency int
+}
+
+func NewEmbeddingClient(conf *Config, pp *proxy.Proxy) *EmbeddingClient {
+	restyClient := misc.RestyClient(2).SetTimeout(180 * time.Second)
+	if pp != nil && conf.AutoProxy {
+		restyClient.SetTransport(pp.BuildTransport())
+	}
+
+	return &EmbeddingClient{conf: conf, http: restyClient, maxInputs: EmbeddingMaxInputs, concurrency: embeddingDefaultConcurrency}
+}
+
+type EmbeddingRequest struct {
+	// Input Input text to embed, each input must not exceed the max input tokens for the model
+	Input []string `json:"input"`
+	// Model ID of the model to use, such as text-embedding-3-small
+	Model string `json:"model"`
+	// Dimensions The number of dimensions the resulting output embeddings should have.
+	// Only supported in text-embedding-3 and later models.
+	Dimensions int `json:"dimensions,omitempty"`
+	// User A unique identifier representing your end-user, which can help OpenAI to monitor and detect abuse
+	User string `json:"user,omitempty"`
+}
+
+type EmbeddingResponse struct {
+	Object string              `json:"object,omitempty"`
+	Data   []EmbeddingData     `json:"data"`
+	Model  string              `json:"model,omitempty"`
+	Usage  EmbeddingUsage      `json:"usage"`
+	Error  *ErrorResponseInner `json:"error,omitempty"`
+}
+
+type EmbeddingData struct {
+	Object string `json:"object,omitempty"`
+	// Index The index of the input in the request
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ToCoinUsage 按照 model 的价格计费的用量（coins.ComputeFee），只有输入的 Token 计费
+func (u EmbeddingUsage) ToCoinUsage(model string) coins.EmbeddingUsage {
+	return coins.EmbeddingUsage{Model: model, Tokens: int64(u.PromptTokens)}
+}
+
+func (client *EmbeddingClient) pickAPIKey() string {
+	return client.conf.OpenAIKeys[rand.Intn(len(client.conf.OpenAIKeys))]
+}
+
+func (client *EmbeddingClient) pickServer() string {
+	return client.conf.OpenAIServers[rand.Intn(len(client.conf.OpenAIServers))]
+}
+
+// CreateEmbeddings 为每一个输入生成向量，返回结果的顺序（以及 Index）与输入的顺序一致
+//
+// 输入数量超过 maxInputs 时按照顺序拆分为多个请求，最多同时发起 concurrency 个请求，
+// 任意一个请求失败时取消其它请求并返回错误，全部成功时合并结果并汇总 Token 用量
+func (client *EmbeddingClient) CreateEmbeddings(ctx context.Context, request EmbeddingRequest) (*EmbeddingResponse, error) {
+	if len(request.Input) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	if len(request.Input) <= client.maxInputs {
+		return client.createEmbeddings(ctx, request)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := (len(request.Input) + client.maxInputs - 1) / client.maxInputs
+	responses := make([]*EmbeddingResponse, batches)
+	errs := make([]error, batches)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(client.concurrency, 1))
+	for i := 0; i < batches; i++ {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			batch := request
+			batch.Input = request.Input[i*client.maxInputs : min((i+1)*client.maxInputs, len(request.Input))]
+
+			responses[i], errs[i] = client.createEmbeddings(ctx, batch)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("embeddings batch %d/%d failed: %w", i+1, batches, err)
+		}
+	}
+
+	ret := EmbeddingResponse{Object: "list", Data: make([]EmbeddingData, 0, len(request.Input))}
+	for i, resp := range responses {
+		if resp == nil {
+			// 其它请求失败之后没有发起的请求，只有 ctx 被取消时出现
+			return nil, ctx.Err()
+		}
+
+		ret.Model = resp.Model
+		ret.Usage.PromptTokens += resp.Usage.PromptTokens
+		ret.Usage.TotalTokens += resp.Usage.TotalTokens
+		for _, item := range resp.Data {
+			item.Index += i * client.maxInputs
+			ret.Data = append(ret.Data, item)
+		}
+	}
+
+	return &ret, nil
+}
+
+// createEmbeddings 发起一次请求，按照 Index 排序返回的结果，结果数量与输入数量不一致时返回错误
+func (client *EmbeddingClient) createEmbeddings(ctx context.Context, request EmbeddingRequest) (*EmbeddingResponse, error) {
+	resp, err := client.http.R().
+		SetContext(ctx).
+		SetHeader("Authorization", "Bearer "+client.pickAPIKey()).
+		SetBody(request).
+		Post(fmt.Sprintf("%s/embeddings", client.pickServer()))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret EmbeddingResponse
+	if err := json.Unmarshal(resp.Body(), &ret); err != nil {
+		return nil, err
+	}
+
+	if resp.IsError() {
+		if ret.Error == nil {
+			return nil, fmt.Errorf("embeddings request failed: %s", resp.Status())
+		}
+
+		return nil, fmt.Errorf("%s: %s", ret.Error.Type, ret.Error.Message)
+	}
+
+	data := make([]EmbeddingData, len(request.Input))
+	filled := make([]bool, len(request.Input))
+	for _, item := range ret.Data {
+		if item.Index < 0 || item.Index >= len(data) || filled[item.Index] {
+			return nil, fmt.Errorf("invalid embedding index %d", item.Index)
+		}
+
+		data[item.Index], filled[item.Index] = item, true
+	}
+
+	if len(ret.Data) != len(request.Input) {
+		return nil, fmt.Errorf("expect %d embeddings, got %d", len(request.Input), len(ret.Data))
+	}
+
+	ret.Data = data
+	return &ret, nil
+}
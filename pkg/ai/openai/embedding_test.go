@@ -0,0 +1,113 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// embeddingTestServer 每个输入的向量为输入文本对应的数字，结果按照倒序返回，用量为输入的数量，
+// 输入数量超过 maxInputs 时返回错误
+func embeddingTestServer(t *testing.T, maxInputs int, requests *atomic.Int32, maxActive *atomic.Int32) *httptest.Server {
+	var active atomic.Int32
+	var lock sync.Mutex
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		current := active.Add(1)
+		defer active.Add(-1)
+
+		lock.Lock()
+		if current > maxActive.Load() {
+			maxActive.Store(current)
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+
+		var req EmbeddingRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(req.Input) > maxInputs {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": {"type": "invalid_request_error", "message": "too many inputs"}}`))
+			return
+		}
+
+		resp := EmbeddingResponse{Object: "list", Model: req.Model, Usage: EmbeddingUsage{PromptTokens: len(req.Input), TotalTokens: len(req.Input)}}
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			value, err := strconv.Atoi(req.Input[i])
+			assert.NoError(t, err)
+			resp.Data = append(resp.Data, EmbeddingData{Object: "embedding", Index: i, Embedding: []float32{float32(value)}})
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestEmbeddingClient_CreateEmbeddings(t *testing.T) {
+	var requests, maxActive atomic.Int32
+	server := embeddingTestServer(t, 3, &requests, &maxActive)
+	defer server.Close()
+
+	client := NewEmbeddingClient(&Config{OpenAIServers: []string{server.URL}, OpenAIKeys: []string{"sk-test"}}, nil)
+	client.maxInputs = 3
+	client.concurrency = 2
+
+	input := make([]string, 10)
+	for i := range input {
+		input[i] = strconv.Itoa(i)
+	}
+
+	resp, err := client.CreateEmbeddings(context.TODO(), EmbeddingRequest{Model: "text-embedding-3-small", Input: input})
+	assert.NoError(t, err)
+
+	// 拆分为 4 个请求，同时发起的请求不超过 2 个
+	assert.EqualValues(t, 4, requests.Load())
+	assert.True(t, maxActive.Load() <= 2)
+
+	// 结果按照原始顺序合并，用量汇总
+	assert.Equal(t, 10, len(resp.Data))
+	for i, item := range resp.Data {
+		assert.Equal(t, i, item.Index)
+		assert.EqualValues(t, []float32{float32(i)}, item.Embedding)
+	}
+	assert.Equal(t, 10, resp.Usage.PromptTokens)
+	assert.Equal(t, 10, resp.Usage.TotalTokens)
+	assert.Equal(t, "text-embedding-3-small", resp.Model)
+
+	// 汇总的用量按照请求的模型计费
+	usage := resp.Usage.ToCoinUsage("text-embedding-3-small")
+	assert.EqualValues(t, 10, usage.Tokens)
+	assert.Equal(t, coins.ComputeFee(coins.EmbeddingUsage{Model: "text-embedding-3-small", Tokens: 10}), coins.ComputeFee(usage))
+
+	// 没有超过上限时只请求一次
+	requests.Store(0)
+	resp, err = client.CreateEmbeddings(context.TODO(), EmbeddingRequest{Model: "text-embedding-3-small", Input: input[:3]})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, requests.Load())
+	assert.EqualValues(t, []float32{2}, resp.Data[2].Embedding)
+}
+
+func TestEmbeddingClient_CreateEmbeddingsFailed(t *testing.T) {
+	var requests, maxActive atomic.Int32
+	server := embeddingTestServer(t, 3, &requests, &maxActive)
+	defer server.Close()
+
+	// 服务提供商的上限比客户端配置的更小，任意一个请求失败时整体失败
+	client := NewEmbeddingClient(&Config{OpenAIServers: []string{server.URL}, OpenAIKeys: []string{"sk-test"}}, nil)
+	client.http.SetRetryCount(0)
+	client.maxInputs = 4
+
+	_, err := client.CreateEmbeddings(context.TODO(), EmbeddingRequest{Model: "text-embedding-3-small", Input: []string{"1", "2", "3", "4", "5"}})
+	assert.True(t, err != nil)
+}
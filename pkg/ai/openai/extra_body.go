@@ -0,0 +1,171 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ExtraBodyDeniedKeys 不允许通过额外参数（渠道配置或者请求中的 extra_body）指定的参数，包括请求结构体中的所有参数
+// （请求体中省略的零值参数也不能通过额外参数补充，避免绕过输出长度限制、计费以及工具调用次数限制），
+// 以及 go-openai 暂不支持、由服务端控制的参数
+var ExtraBodyDeniedKeys = append(requestFieldNames(openai.ChatCompletionRequest{}),
+	"max_completion_tokens", "stream_options", "parallel_tool_calls", "reasoning_effort",
+	"logprobs", "top_logprobs", "service_tier", "store", "metadata", "modalities", "audio", "prediction",
+)
+
+// requestFieldNames 返回请求结构体中所有参数在 JSON 请求体中的名称
+func requestFieldNames(req any) []string {
+	typ := reflect.TypeOf(req)
+	names := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// ValidateExtraBody 检查额外参数中是否包含不允许指定的参数（参考 ExtraBodyDeniedKeys）
+func ValidateExtraBody(fields map[string]any) error {
+	for k := range fields {
+		if extraBodyDenied(k) {
+			return fmt.Errorf("extra body field %q is not allowed", k)
+		}
+	}
+
+	return nil
+}
+
+func extraBodyDenied(key string) bool {
+	for _, denied := range ExtraBodyDeniedKeys {
+		if strings.EqualFold(strings.TrimSpace(key), denied) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type extraBodyKey struct{}
+
+type passthroughBodyKey struct{}
+
+// WithExtraBody 在请求体中追加 go-openai 不支持的参数（如 reasoning_effort），只对使用该 ctx 发起的请求生效
+func WithExtraBody(ctx context.Context, fields map[string]any) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, extraBodyKey{}, fields)
+}
+
+// WithPassthroughBody 在请求体中追加调用方指定的额外参数（如 OpenAI 兼容网关支持的 min_p、guided_json），只对使用该 ctx 发起的请求生效
+//
+// 与 WithExtraBody 不同，这些参数不能覆盖请求体中已有的参数，不允许指定的参数（参考 ExtraBodyDeniedKeys）会被忽略，
+// 同名参数优先于渠道配置的额外参数
+func WithPassthroughBody(ctx context.Context, fields map[string]any) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, passthroughBodyKey{}, fields)
+}
+
+// extraBodyTransport 将渠道配置的额外参数以及 ctx 中的额外参数合并到 JSON 请求体中
+type extraBodyTransport struct {
+	base http.RoundTripper
+	// channel 渠道配置的额外参数
+	channel map[string]any
+}
+
+func newExtraBodyTransport(base http.RoundTripper, channel map[string]any) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &extraBodyTransport{base: base, channel: channel}
+}
+
+func (t *extraBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields, _ := req.Context().Value(extraBodyKey{}).(map[string]any)
+	passthrough, _ := req.Context().Value(passthroughBodyKey{}).(map[string]any)
+	if len(passthrough) > 0 && len(t.channel) > 0 {
+		merged := make(map[string]any, len(t.channel)+len(passthrough))
+		for k, v := range t.channel {
+			merged[k] = v
+		}
+		for k, v := range passthrough {
+			merged[k] = v
+		}
+
+		passthrough = merged
+	} else if len(passthrough) == 0 {
+		passthrough = t.channel
+	}
+
+	if (len(fields) == 0 && len(passthrough) == 0) || req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return t.base.RoundTrip(req)
+	}
+
+	data, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if merged, err := mergeExtraBody(data, fields, passthrough); err == nil {
+		data = merged
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// mergeExtraBody 合并额外参数：fields 会覆盖请求体中已有的同名参数，passthrough 只补充请求体中没有的参数，
+// 并且忽略不允许指定的参数
+func mergeExtraBody(data []byte, fields map[string]any, passthrough map[string]any) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+
+	for k, v := range passthrough {
+		if _, ok := body[k]; ok || extraBodyDenied(k) {
+			continue
+		}
+
+		val, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		body[k] = val
+	}
+
+	for k, v := range fields {
+		val, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		body[k] = val
+	}
+
+	return json.Marshal(body)
+}
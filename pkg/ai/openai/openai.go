@@ -155,6 +155,8 @@ type ChatStreamResponse struct {
 	Code         string `json:"code,omitempty"`
 	ErrorMessage string `json:"error_message,omitempty"`
 	ChatResponse *openai.ChatCompletionStreamResponse
+	// Err 读取流失败时的原始错误，用于获取上游返回的错误详情
+	Err error `json:"-"`
 }
 
 func (client *realClientImpl) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error) {
@@ -185,7 +187,7 @@ func (client *realClientImpl) ChatStream(ctx context.Context, request openai.Cha
 			if err != nil {
 				select {
 				case <-ctx.Done():
-				case res <- ChatStreamResponse{Code: "READ_STREAM_FAILED", ErrorMessage: fmt.Errorf("read stream failed: %v", err).Error()}:
+				case res <- ChatStreamResponse{Code: "READ_STREAM_FAILED", ErrorMessage: fmt.Errorf("read stream failed: %v", err).Error(), Err: err}:
 				}
 				return
 			}
@@ -201,6 +203,57 @@ func (client *realClientImpl) ChatStream(ctx context.Context, request openai.Cha
 	return res, nil
 }
 
+func (client *realClientImpl) CreateCompletion(ctx context.Context, request openai.CompletionRequest) (response openai.CompletionResponse, err error) {
+	return client.client(request.Model).CreateCompletion(ctx, request)
+}
+
+type CompletionStreamResponse struct {
+	Code               string `json:"code,omitempty"`
+	ErrorMessage       string `json:"error_message,omitempty"`
+	CompletionResponse *openai.CompletionResponse
+	// Err 读取流失败时的原始错误，用于获取上游返回的错误详情
+	Err error `json:"-"`
+}
+
+func (client *realClientImpl) CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan CompletionStreamResponse, error) {
+	stream, err := client.client(request.Model).CreateCompletionStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan CompletionStreamResponse)
+
+	go func() {
+		defer func() {
+			close(res)
+			stream.Close()
+		}()
+
+		for {
+			response, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case res <- CompletionStreamResponse{Code: "READ_STREAM_FAILED", ErrorMessage: fmt.Errorf("read stream failed: %v", err).Error(), Err: err}:
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case res <- CompletionStreamResponse{CompletionResponse: &response}:
+			}
+		}
+	}()
+
+	return res, nil
+}
+
 func (client *realClientImpl) CreateImage(ctx context.Context, request openai.ImageRequest) (response openai.ImageResponse, err error) {
 	return client.client("dall-e").CreateImage(ctx, request)
 }
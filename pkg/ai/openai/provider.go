@@ -1,6 +1,8 @@
 package openai
 
 import (
+	"github.com/mylxsw/aidea-server/pkg/ai/bodylimit"
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
 	"github.com/mylxsw/aidea-server/pkg/proxy"
 	"github.com/mylxsw/go-utils/ternary"
 	"net"
@@ -27,6 +29,17 @@ func (Provider) Register(binder infra.Binder) {
 		return NewDalleImageClient(parseDalleConfig(conf), proxyDialer)
 	})
 
+	binder.MustSingleton(func(conf *config.Config, resolver infra.Resolver) *EmbeddingClient {
+		var proxyDialer *proxy.Proxy
+		if conf.SupportProxy() && conf.OpenAIAutoProxy {
+			resolver.MustResolve(func(pp *proxy.Proxy) {
+				proxyDialer = pp
+			})
+		}
+
+		return NewEmbeddingClient(parseMainConfig(conf), proxyDialer)
+	})
+
 	binder.MustSingleton(func(conf *config.Config, resolver infra.Resolver) Client {
 		var proxyDialer *proxy.Proxy
 		if conf.SupportProxy() {
@@ -62,6 +75,9 @@ func NewOpenAIClient(conf *Config, pp *proxy.Proxy) Client {
 				"",
 				conf.OpenAIKeys[i],
 				ternary.If(conf.AutoProxy, pp, nil),
+				conf.MaxResponseSize,
+				conf.UserAgent,
+				conf.ExtraBody,
 			))
 		}
 	} else {
@@ -74,6 +90,9 @@ func NewOpenAIClient(conf *Config, pp *proxy.Proxy) Client {
 					conf.OpenAIOrganization,
 					key,
 					ternary.If(conf.AutoProxy, pp, nil),
+					conf.MaxResponseSize,
+					conf.UserAgent,
+					conf.ExtraBody,
 				))
 			}
 		}
@@ -82,7 +101,7 @@ func NewOpenAIClient(conf *Config, pp *proxy.Proxy) Client {
 	return New(conf, clients)
 }
 
-func createOpenAIClient(isAzure bool, apiVersion string, server, organization, key string, pp *proxy.Proxy) *openai.Client {
+func createOpenAIClient(isAzure bool, apiVersion string, server, organization, key string, pp *proxy.Proxy, maxResponseSize int64, userAgent string, extraBody map[string]any) *openai.Client {
 	openaiConf := openai.DefaultConfig(key)
 	openaiConf.BaseURL = server
 	openaiConf.OrgID = organization
@@ -97,6 +116,11 @@ func createOpenAIClient(isAzure bool, apiVersion string, server, organization, k
 		}
 	}
 
+	openaiConf.HTTPClient.Transport = newUserAgentTransport(
+		newExtraBodyTransport(newRefusalTransport(bodylimit.NewTransport(recorder.NewTransport(openaiConf.HTTPClient.Transport, "openai"), maxResponseSize)), extraBody),
+		userAgent,
+	)
+
 	if isAzure {
 		openaiConf.APIType = openai.APITypeAzure
 		openaiConf.APIVersion = apiVersion
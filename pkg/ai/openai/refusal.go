@@ -0,0 +1,190 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type refusalRecorderKey struct{}
+
+// RefusalRecorder 记录上游响应中模型拒绝回答的说明（refusal 字段，与内容安全策略拦截不同，是模型自身拒绝回答），
+// go-openai 不支持该字段，通过 WithRefusalRecorder 设置后，由 HTTP Transport 从响应体中提取，流式响应时累积所有片段。
+//
+// go-openai 的流式响应同样不包含模型指纹（system_fingerprint），一并在这里记录
+type RefusalRecorder struct {
+	lock        sync.Mutex
+	text        strings.Builder
+	fingerprint string
+}
+
+// WithRefusalRecorder 为使用返回的 ctx 发起的请求记录模型拒绝回答的说明
+func WithRefusalRecorder(ctx context.Context) (context.Context, *RefusalRecorder) {
+	recorder := &RefusalRecorder{}
+	return context.WithValue(ctx, refusalRecorderKey{}, recorder), recorder
+}
+
+// Text 返回目前为止记录的拒绝回答的说明，模型没有拒绝回答时为空
+func (r *RefusalRecorder) Text() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.text.String()
+}
+
+// SystemFingerprint 返回目前为止记录的模型指纹（最后一次返回的值），上游没有返回时为空
+func (r *RefusalRecorder) SystemFingerprint() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.fingerprint
+}
+
+func (r *RefusalRecorder) setFingerprint(fingerprint string) {
+	if fingerprint == "" {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.fingerprint = fingerprint
+}
+
+func (r *RefusalRecorder) add(text string) {
+	if text == "" {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.text.WriteString(text)
+}
+
+// refusalPayload 响应体中与拒绝回答相关的字段，非流式响应为 message.refusal，流式响应为 delta.refusal
+type refusalPayload struct {
+	SystemFingerprint string `json:"system_fingerprint"`
+	Choices           []struct {
+		Message struct {
+			Refusal string `json:"refusal"`
+		} `json:"message"`
+		Delta struct {
+			Refusal string `json:"refusal"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// refusalTransport 请求的 ctx 中设置了 RefusalRecorder 时，从响应体中提取拒绝回答的说明
+type refusalTransport struct {
+	base http.RoundTripper
+}
+
+func newRefusalTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &refusalTransport{base: base}
+}
+
+func (t *refusalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	recorder, ok := req.Context().Value(refusalRecorderKey{}).(*RefusalRecorder)
+	if !ok {
+		return resp, nil
+	}
+
+	resp.Body = &refusalReader{
+		body:     resp.Body,
+		recorder: recorder,
+		stream:   strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"),
+	}
+
+	return resp, nil
+}
+
+// refusalReader 读取响应体的同时提取拒绝回答的说明，流式响应按行处理，非流式响应在读取完成（或者关闭）时处理
+type refusalReader struct {
+	body     io.ReadCloser
+	recorder *RefusalRecorder
+	stream   bool
+
+	buf  bytes.Buffer
+	done bool
+}
+
+func (r *refusalReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.buf.Write(p[:n])
+
+	if r.stream {
+		for {
+			idx := bytes.IndexByte(r.buf.Bytes(), '\n')
+			if idx < 0 {
+				break
+			}
+
+			r.parse(r.buf.Next(idx + 1))
+		}
+	}
+
+	if err != nil {
+		r.finish()
+	}
+
+	return n, err
+}
+
+func (r *refusalReader) Close() error {
+	r.finish()
+	return r.body.Close()
+}
+
+// finish 处理剩余的内容，只处理一次
+func (r *refusalReader) finish() {
+	if r.done {
+		return
+	}
+
+	r.done = true
+	r.parse(r.buf.Bytes())
+	r.buf.Reset()
+}
+
+// parse 解析响应体（非流式响应）或者一行 SSE 数据（流式响应）
+func (r *refusalReader) parse(data []byte) {
+	data = bytes.TrimSpace(data)
+	if r.stream {
+		if !bytes.HasPrefix(data, []byte("data:")) {
+			return
+		}
+
+		data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("data:")))
+	}
+
+	// 快速跳过不包含拒绝回答以及模型指纹的内容，流式响应的每个片段都包含模型指纹，已经记录之后不再解析
+	needFingerprint := r.recorder.SystemFingerprint() == "" && bytes.Contains(data, []byte(`"system_fingerprint"`))
+	if !bytes.Contains(data, []byte(`"refusal"`)) && !needFingerprint {
+		return
+	}
+
+	var payload refusalPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return
+	}
+
+	r.recorder.setFingerprint(payload.SystemFingerprint)
+	for _, choice := range payload.Choices {
+		r.recorder.add(choice.Message.Refusal)
+		r.recorder.add(choice.Delta.Refusal)
+	}
+}
@@ -0,0 +1,38 @@
+package openai
+
+import "net/http"
+
+// DefaultUserAgent 请求服务提供商时默认使用的 User-Agent
+//
+// 部分服务提供商部署了 WAF，会拦截 Go 默认的 User-Agent（Go-http-client/1.1）
+const DefaultUserAgent = "AIdea-Server/1.0"
+
+// userAgentTransport 为请求设置 User-Agent，请求中已经明确指定 User-Agent 时（如自定义请求头）保持不变
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func newUserAgentTransport(base http.RoundTripper, userAgent string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	return &userAgentTransport{base: base, userAgent: userAgent}
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTripper 不允许修改原始请求
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	return t.base.RoundTrip(req)
+}
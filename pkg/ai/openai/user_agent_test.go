@@ -0,0 +1,36 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestUserAgentTransport(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newUserAgentTransport(nil, "")}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, DefaultUserAgent, userAgent)
+	// 不修改原始请求
+	assert.Equal(t, "", req.Header.Get("User-Agent"))
+
+	// 请求中已经明确指定的 User-Agent 优先
+	client = &http.Client{Transport: newUserAgentTransport(nil, "channel-agent")}
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("User-Agent", "custom-header-agent")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "custom-header-agent", userAgent)
+}
@@ -23,3 +23,13 @@ func (oa *OpenRouter) Chat(ctx context.Context, request openai.ChatCompletionReq
 	request.Model = strings.ReplaceAll(request.Model, ".", "/")
 	return oa.client.CreateChatCompletion(ctx, request)
 }
+
+func (oa *OpenRouter) CompletionStream(ctx context.Context, request openai.CompletionRequest) (<-chan openai2.CompletionStreamResponse, error) {
+	request.Model = strings.ReplaceAll(request.Model, ".", "/")
+	return oa.client.CompletionStream(ctx, request)
+}
+
+func (oa *OpenRouter) Completion(ctx context.Context, request openai.CompletionRequest) (response openai.CompletionResponse, err error) {
+	request.Model = strings.ReplaceAll(request.Model, ".", "/")
+	return oa.client.CreateCompletion(ctx, request)
+}
@@ -0,0 +1,308 @@
+// Package recorder 录制请求服务提供商的请求和响应，用于编写服务提供商适配的测试用例（只在开发环境中使用）
+//
+// 开启录制之后（参考 Enable），Transport 将脱敏之后的请求写入 <dir>/<provider>/<scenario>.request.json，
+// 将上游返回的原始响应（流式输出为 SSE 原文）写入 <dir>/<provider>/<scenario>.sse（或者 .json/.txt），
+// 录制的内容可以使用 NewReplayServer 在测试中回放
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mylxsw/asteria/log"
+)
+
+// Redacted 脱敏之后的值
+const Redacted = "[REDACTED]"
+
+var (
+	lock sync.RWMutex
+	// dir 录制内容保存的目录，为空时不录制
+	dir string
+	// sequences 每个场景已经录制的请求数量，同一个场景中的多次请求依次编号
+	sequences = map[string]int{}
+)
+
+// Enable 开启录制，录制的内容保存在 directory 目录中，directory 为空时关闭录制
+func Enable(directory string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	dir = directory
+	sequences = map[string]int{}
+}
+
+// Enabled 是否开启了录制
+func Enabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return dir != ""
+}
+
+// next 返回本次录制的文件路径（不包含扩展名），同一个场景中的第 n 次请求（n > 1）使用 <scenario>-<n> 作为文件名
+func next(provider, scenario string) (string, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if dir == "" {
+		return "", false
+	}
+
+	key := provider + "/" + scenario
+	sequences[key]++
+
+	return filepath.Join(dir, provider, sequenceName(scenario, sequences[key])), true
+}
+
+// sequenceName 场景中第 seq 次请求的文件名
+func sequenceName(scenario string, seq int) string {
+	if seq <= 1 {
+		return scenario
+	}
+
+	return fmt.Sprintf("%s-%d", scenario, seq)
+}
+
+type providerKey struct{}
+type scenarioKey struct{}
+
+// WithProvider 指定录制内容所属的服务提供商（渠道类型），优先于 Transport 的默认服务提供商
+func WithProvider(ctx context.Context, provider string) context.Context {
+	return context.WithValue(ctx, providerKey{}, provider)
+}
+
+// WithScenario 指定录制的场景名称，未指定时使用请求的时间作为场景名称
+func WithScenario(ctx context.Context, scenario string) context.Context {
+	return context.WithValue(ctx, scenarioKey{}, scenario)
+}
+
+// unsafeName 文件名中不允许出现的字符
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func fileName(name string) string {
+	return strings.Trim(unsafeName.ReplaceAllString(name, "_"), "._")
+}
+
+// Request 录制的请求（已脱敏）以及响应的状态
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body 请求体，JSON 格式的请求体原样保存，否则保存为字符串
+	Body json.RawMessage `json:"body,omitempty"`
+	// Status 上游响应的状态码
+	Status int `json:"status"`
+	// ContentType 上游响应的 Content-Type
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Transport 录制请求和响应，未开启录制或者无法确定服务提供商时不做任何处理
+type Transport struct {
+	base     http.RoundTripper
+	provider string
+}
+
+// NewTransport 创建录制请求的 Transport，provider 为默认的服务提供商，请求的 context 中指定了服务提供商（参考 WithProvider）时以 context 为准
+func NewTransport(base http.RoundTripper, provider string) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{base: base, provider: provider}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Enabled() {
+		return t.base.RoundTrip(req)
+	}
+
+	provider := t.provider
+	if p, ok := req.Context().Value(providerKey{}).(string); ok && p != "" {
+		provider = p
+	}
+
+	if provider == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	scenario, _ := req.Context().Value(scenarioKey{}).(string)
+	if scenario == "" {
+		scenario = time.Now().Format("20060102-150405.000")
+	}
+
+	path, ok := next(fileName(provider), fileName(scenario))
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		body = data
+		req.Body = io.NopCloser(bytes.NewReader(data))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	recorded := Request{
+		Method:      req.Method,
+		URL:         scrubURL(req.URL),
+		Headers:     scrubHeaders(req.Header),
+		Body:        scrubBody(body),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Warningf("create recording directory failed: %v", err)
+		return resp, nil
+	}
+
+	data, _ := json.MarshalIndent(recorded, "", "  ")
+	if err := os.WriteFile(path+".request.json", data, 0644); err != nil {
+		log.Warningf("write recorded request failed: %v", err)
+		return resp, nil
+	}
+
+	f, err := os.Create(path + responseExt(recorded.ContentType))
+	if err != nil {
+		log.Warningf("create recorded response failed: %v", err)
+		return resp, nil
+	}
+
+	resp.Body = &teeBody{ReadCloser: resp.Body, file: f}
+	return resp, nil
+}
+
+// responseExt 响应内容文件的扩展名
+func responseExt(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/event-stream":
+		return ".sse"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return ".json"
+	}
+
+	return ".txt"
+}
+
+// teeBody 读取响应内容的同时写入文件，流式输出时逐块写入
+type teeBody struct {
+	io.ReadCloser
+	file *os.File
+	once sync.Once
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = b.file.Write(p[:n])
+	}
+
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	b.once.Do(func() { _ = b.file.Close() })
+	return b.ReadCloser.Close()
+}
+
+// sensitiveName 名称中包含这些内容的请求头、查询参数被认为是密钥
+var sensitiveName = regexp.MustCompile(`(?i)(auth|key|token|secret|signature|cookie|password)`)
+
+// sensitiveFields 请求体中包含用户标识的字段
+var sensitiveFields = map[string]bool{
+	"user":              true,
+	"user_id":           true,
+	"userid":            true,
+	"safety_identifier": true,
+	"api_key":           true,
+	"apikey":            true,
+	"access_token":      true,
+}
+
+func scrubHeaders(headers http.Header) map[string]string {
+	ret := make(map[string]string, len(headers))
+	for name, values := range headers {
+		if sensitiveName.MatchString(name) {
+			ret[name] = Redacted
+		} else {
+			ret[name] = strings.Join(values, ", ")
+		}
+	}
+
+	return ret
+}
+
+func scrubURL(u *url.URL) string {
+	ret := *u
+	ret.User = nil
+
+	query := ret.Query()
+	for name := range query {
+		if sensitiveName.MatchString(name) {
+			query.Set(name, Redacted)
+		}
+	}
+	ret.RawQuery = query.Encode()
+
+	return ret.String()
+}
+
+// scrubBody 去掉请求体中的用户标识和密钥，不是 JSON 格式的请求体保存为字符串
+func scrubBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		encoded, _ := json.Marshal(string(body))
+		return encoded
+	}
+
+	encoded, _ := json.Marshal(scrubValue(data))
+	return encoded
+}
+
+func scrubValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for name, item := range v {
+			if sensitiveFields[strings.ToLower(name)] {
+				v[name] = Redacted
+				continue
+			}
+
+			v[name] = scrubValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = scrubValue(item)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,146 @@
+package recorder_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/recorder"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+const sseTranscript = "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\ndata: [DONE]\n\n"
+
+func upstream(t *testing.T) *httptest.Server {
+	var count int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 录制不影响实际发送的请求
+		assert.Equal(t, "Bearer sk-secret", r.Header.Get("Authorization"))
+
+		count++
+		if count == 1 {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(sseTranscript))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+	}))
+}
+
+func send(t *testing.T, ctx context.Context, client *http.Client, server string) *http.Response {
+	body := `{"model":"gpt-4o","user":"u-1001","metadata":{"user_id":"42"},"messages":[{"role":"user","content":"hi"}]}`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+"/v1/chat/completions?key=abc&stream=true", strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	return resp
+}
+
+func TestTransport_RecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder.Enable(dir)
+	defer recorder.Enable("")
+
+	server := upstream(t)
+	defer server.Close()
+
+	client := &http.Client{Transport: recorder.NewTransport(nil, "openai")}
+	ctx := recorder.WithScenario(context.TODO(), "stream basic")
+	send(t, ctx, client, server.URL)
+	send(t, ctx, client, server.URL)
+
+	// 请求中的密钥和用户标识已经脱敏
+	data, err := os.ReadFile(filepath.Join(dir, "openai", "stream_basic.request.json"))
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "sk-secret"))
+	assert.False(t, strings.Contains(string(data), "u-1001"))
+
+	var req recorder.Request
+	assert.NoError(t, json.Unmarshal(data, &req))
+	assert.Equal(t, recorder.Redacted, req.Headers["Authorization"])
+	assert.Equal(t, "application/json", req.Headers["Content-Type"])
+	assert.True(t, strings.Contains(req.URL, "key=%5BREDACTED%5D"))
+	assert.True(t, strings.Contains(req.URL, "stream=true"))
+	assert.Equal(t, http.StatusOK, req.Status)
+
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(req.Body, &body))
+	assert.Equal(t, recorder.Redacted, body["user"])
+	assert.Equal(t, recorder.Redacted, body["metadata"].(map[string]any)["user_id"])
+	assert.Equal(t, "gpt-4o", body["model"])
+
+	// 响应原样保存，同一个场景中的第二次请求依次编号
+	transcript, err := os.ReadFile(filepath.Join(dir, "openai", "stream_basic.sse"))
+	assert.NoError(t, err)
+	assert.Equal(t, sseTranscript, string(transcript))
+
+	_, err = os.Stat(filepath.Join(dir, "openai", "stream_basic-2.json"))
+	assert.NoError(t, err)
+
+	// 回放录制的内容
+	replay, err := recorder.NewReplayServer(dir, "openai", "stream basic")
+	assert.NoError(t, err)
+	defer replay.Close()
+
+	resp, err := http.Post(replay.URL, "application/json", strings.NewReader(`{"model":"gpt-4o"}`))
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, sseTranscript, string(data))
+
+	resp, err = http.Post(replay.URL, "application/json", nil)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp, err = http.Post(replay.URL, "application/json", nil)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	assert.Equal(t, 3, len(replay.Requests()))
+	assert.Equal(t, `{"model":"gpt-4o"}`, string(replay.Requests()[0]))
+}
+
+func TestTransport_Provider(t *testing.T) {
+	dir := t.TempDir()
+	server := upstream(t)
+	defer server.Close()
+
+	// 未开启录制时不录制
+	client := &http.Client{Transport: recorder.NewTransport(nil, "openai")}
+	send(t, recorder.WithScenario(context.TODO(), "disabled"), client, server.URL)
+
+	recorder.Enable(dir)
+	defer recorder.Enable("")
+
+	// context 中指定的服务提供商优先；无法确定服务提供商时不录制
+	send(t, recorder.WithProvider(recorder.WithScenario(context.TODO(), "basic"), "moonshot"), client, server.URL)
+	send(t, recorder.WithScenario(context.TODO(), "basic"), &http.Client{Transport: recorder.NewTransport(nil, "")}, server.URL)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "moonshot", entries[0].Name())
+
+	_, err = recorder.Load(dir, "moonshot", "basic")
+	assert.NoError(t, err)
+	_, err = recorder.Load(dir, "openai", "disabled")
+	assert.True(t, err != nil)
+}
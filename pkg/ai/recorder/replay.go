@@ -0,0 +1,101 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Exchange 录制的一次请求和对应的响应内容
+type Exchange struct {
+	Request  Request
+	Response []byte
+}
+
+// Load 读取场景中录制的所有请求和响应，按照请求的顺序返回
+func Load(directory, provider, scenario string) ([]Exchange, error) {
+	var exchanges []Exchange
+	for seq := 1; ; seq++ {
+		path := filepath.Join(directory, fileName(provider), sequenceName(fileName(scenario), seq))
+
+		data, err := os.ReadFile(path + ".request.json")
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && seq > 1 {
+				return exchanges, nil
+			}
+
+			return nil, err
+		}
+
+		var ex Exchange
+		if err := json.Unmarshal(data, &ex.Request); err != nil {
+			return nil, fmt.Errorf("invalid recorded request %s: %w", path, err)
+		}
+
+		if ex.Response, err = os.ReadFile(path + responseExt(ex.Request.ContentType)); err != nil {
+			return nil, err
+		}
+
+		exchanges = append(exchanges, ex)
+	}
+}
+
+// ReplayServer 按照录制的顺序返回上游的响应，同时记录收到的请求体
+type ReplayServer struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	exchanges []Exchange
+	requests  [][]byte
+}
+
+// NewReplayServer 启动一个回放录制内容的测试服务器，将服务提供商的服务器地址指向 Server.URL 即可。
+// 第 n 次请求返回场景中录制的第 n 个响应，请求次数超过录制的数量时返回 500 错误
+func NewReplayServer(directory, provider, scenario string) (*ReplayServer, error) {
+	exchanges, err := Load(directory, provider, scenario)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ReplayServer{exchanges: exchanges}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s, nil
+}
+
+func (s *ReplayServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.lock.Lock()
+	s.requests = append(s.requests, body)
+	idx := len(s.requests) - 1
+	s.lock.Unlock()
+
+	if idx >= len(s.exchanges) {
+		http.Error(w, fmt.Sprintf("unexpected request #%d, only %d recorded", idx+1, len(s.exchanges)), http.StatusInternalServerError)
+		return
+	}
+
+	ex := s.exchanges[idx]
+	if ex.Request.ContentType != "" {
+		w.Header().Set("Content-Type", ex.Request.ContentType)
+	}
+	if ex.Request.Status > 0 {
+		w.WriteHeader(ex.Request.Status)
+	}
+	_, _ = w.Write(ex.Response)
+}
+
+// Requests 目前为止收到的所有请求体
+func (s *ReplayServer) Requests() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([][]byte(nil), s.requests...)
+}
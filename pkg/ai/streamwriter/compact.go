@@ -0,0 +1,64 @@
+package streamwriter
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// FormatCompact WebSocket 连接的紧凑帧格式，建立连接时通过查询参数 ws_format=compact 协商，默认使用 JSON 格式
+//
+// 紧凑格式中，只包含文本增量的响应使用二进制帧发送：1 字节的帧类型 + 响应序号（varint）+ UTF-8 编码的文本增量；
+// 其它响应（结束原因、用量、错误等控制消息）仍然使用 JSON 格式的文本帧
+const FormatCompact = "compact"
+
+// FrameTypeTextDelta 紧凑格式中文本增量帧的类型
+const FrameTypeTextDelta byte = 0x01
+
+var (
+	// ErrInvalidCompactFrame 紧凑格式的帧内容无效
+	ErrInvalidCompactFrame = errors.New("invalid compact frame")
+)
+
+// EncodeCompactFrame 编码紧凑格式的帧
+func EncodeCompactFrame(typ byte, index uint64, text string) []byte {
+	data := make([]byte, 0, 1+binary.MaxVarintLen64+len(text))
+	data = append(data, typ)
+	data = binary.AppendUvarint(data, index)
+	return append(data, text...)
+}
+
+// DecodeCompactFrame 解码紧凑格式的帧，返回帧类型、响应序号和文本增量
+func DecodeCompactFrame(data []byte) (typ byte, index uint64, text string, err error) {
+	if len(data) < 2 {
+		return 0, 0, "", ErrInvalidCompactFrame
+	}
+
+	index, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return 0, 0, "", ErrInvalidCompactFrame
+	}
+
+	payload := data[1+n:]
+	if !utf8.Valid(payload) {
+		return 0, 0, "", ErrInvalidCompactFrame
+	}
+
+	return data[0], index, string(payload), nil
+}
+
+// IsCompact 客户端是否协商使用紧凑帧格式
+func (sw *StreamWriter) IsCompact() bool {
+	return sw.ws != nil && sw.compact
+}
+
+// WriteDelta 写入只包含文本增量的响应，使用紧凑帧格式时发送二进制帧，否则与 WriteStream(payload) 相同
+func (sw *StreamWriter) WriteDelta(index int, text string, payload any) error {
+	if !sw.IsCompact() {
+		return sw.WriteStream(payload)
+	}
+
+	return sw.ws.WriteMessage(websocket.BinaryMessage, EncodeCompactFrame(FrameTypeTextDelta, uint64(index), text))
+}
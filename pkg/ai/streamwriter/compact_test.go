@@ -0,0 +1,162 @@
+package streamwriter
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+type testRequest struct {
+	Model string `json:"model"`
+}
+
+func (req testRequest) Init() testRequest {
+	return req
+}
+
+// recordedFrame 录制的 JSON 格式的流式响应
+type recordedFrame struct {
+	raw   string
+	index int
+	text  string
+	delta bool
+}
+
+func loadRecordedStream(t *testing.T, path string) []recordedFrame {
+	t.Helper()
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	var frames []recordedFrame
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var chunk struct {
+			ID      string `json:"id"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+					Role    string `json:"role"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &chunk))
+
+		index, _ := strconv.Atoi(chunk.ID)
+		delta := chunk.Choices[0]
+		frames = append(frames, recordedFrame{
+			raw:   scanner.Text(),
+			index: index,
+			text:  delta.Delta.Content,
+			delta: delta.FinishReason == nil && delta.Delta.Role == "assistant" && delta.Delta.Content != "",
+		})
+	}
+
+	return frames
+}
+
+// replayStream 通过 WebSocket 重放录制的流式响应，返回客户端收到的所有帧的字节数（包括帧头）以及还原的回复内容
+func replayStream(t *testing.T, frames []recordedFrame, query string) (int, string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, _, err := New[testRequest](true, false, r, w)
+		if err != nil {
+			return
+		}
+		defer sw.Close()
+
+		for _, frame := range frames {
+			if frame.delta {
+				assert.NoError(t, sw.WriteDelta(frame.index, frame.text, frame.raw))
+			} else {
+				assert.NoError(t, sw.WriteStream(frame.raw))
+			}
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?ws=true"+query, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"model":"gpt-4o-mini"}`)))
+
+	var size int
+	var text strings.Builder
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		// 服务端发送的帧没有掩码，帧头为 2 字节（长度小于 126）或者 4 字节（长度小于 65536）
+		size += len(data) + 2
+		if len(data) >= 126 {
+			size += 2
+		}
+
+		if typ == websocket.BinaryMessage {
+			frameType, _, delta, err := DecodeCompactFrame(data)
+			assert.NoError(t, err)
+			assert.Equal(t, FrameTypeTextDelta, frameType)
+			text.WriteString(delta)
+			continue
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+					Role    string `json:"role"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		assert.NoError(t, json.Unmarshal(data, &chunk))
+		if chunk.Choices[0].Delta.Role == "assistant" {
+			text.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+
+	return size, text.String()
+}
+
+func TestCompactFrame(t *testing.T) {
+	data := EncodeCompactFrame(FrameTypeTextDelta, 300, "你好")
+	assert.Equal(t, 1+2+len("你好"), len(data))
+
+	typ, index, text, err := DecodeCompactFrame(data)
+	assert.NoError(t, err)
+	assert.Equal(t, FrameTypeTextDelta, typ)
+	assert.EqualValues(t, uint64(300), index)
+	assert.Equal(t, "你好", text)
+
+	_, _, _, err = DecodeCompactFrame([]byte{FrameTypeTextDelta})
+	assert.True(t, err != nil)
+	_, _, _, err = DecodeCompactFrame([]byte{FrameTypeTextDelta, 0x01, 0xff})
+	assert.True(t, err != nil)
+}
+
+func TestStreamWriter_CompactBytesOnWire(t *testing.T) {
+	frames := loadRecordedStream(t, "testdata/chat_stream.jsonl")
+
+	jsonSize, jsonText := replayStream(t, frames, "")
+	compactSize, compactText := replayStream(t, frames, "&ws_format="+FormatCompact)
+
+	// 两种格式还原的回复内容相同，紧凑格式的传输字节数显著减少
+	assert.True(t, jsonText != "")
+	assert.Equal(t, jsonText, compactText)
+	assert.True(t, compactSize*3 < jsonSize)
+
+	t.Logf("bytes on wire: json %d, compact %d, reduction %.1f%%", jsonSize, compactSize, 100*(1-float64(compactSize)/float64(jsonSize)))
+}
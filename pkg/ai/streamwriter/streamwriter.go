@@ -2,6 +2,7 @@ package streamwriter
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"net/http"
@@ -21,6 +22,8 @@ type StreamWriter struct {
 	once      sync.Once
 	sseInited bool
 	debug     bool
+	// compact WebSocket 连接使用紧凑帧格式（参考 FormatCompact）
+	compact bool
 
 	onClosedSync sync.Once
 	onClosed     func()
@@ -54,6 +57,11 @@ type InitRequest[T any] interface {
 	Init() T
 }
 
+// IsWebSocket 客户端是否使用 WebSocket 连接
+func (sw *StreamWriter) IsWebSocket() bool {
+	return sw.ws != nil
+}
+
 func (sw *StreamWriter) SetOnClosed(cb func()) {
 	sw.onClosed = cb
 }
@@ -98,6 +106,7 @@ func New[T InitRequest[T]](enableWs bool, enableCors bool, r *http.Request, w ht
 			return nil, nil, err
 		} else {
 			sw.ws = wsConn
+			sw.compact = r.URL.Query().Get("ws_format") == FormatCompact
 
 			if sw.debug {
 				log.Debugf("websocket connected: %s", wsConn.RemoteAddr())
@@ -227,14 +236,29 @@ func (sw *StreamWriter) writeJSON(payload any, statusCode int) {
 type ErrorResponse struct {
 	Code  int    `json:"code"`
 	Error string `json:"error"`
+	// Data 错误的结构化详情，供客户端展示
+	Data any `json:"data,omitempty"`
+}
+
+// ErrorWithData 包含结构化详情的错误
+type ErrorWithData interface {
+	error
+	ErrorData() any
 }
 
 func NewErrorResponse(err error) ErrorResponse {
-	return ErrorResponse{Error: err.Error(), Code: http.StatusInternalServerError}
+	return NewErrorWithCodeResposne(err, http.StatusInternalServerError)
 }
 
 func NewErrorWithCodeResposne(err error, code int) ErrorResponse {
-	return ErrorResponse{Error: err.Error(), Code: code}
+	ret := ErrorResponse{Error: err.Error(), Code: code}
+
+	var withData ErrorWithData
+	if errors.As(err, &withData) {
+		ret.Data = withData.ErrorData()
+	}
+
+	return ret
 }
 
 func (resp ErrorResponse) ToJSON() []byte {
@@ -21,29 +21,91 @@ func MaxRequestsInPeriod(count int, period time.Duration) redis_rate.Limit {
 	return redis_rate.Limit{Rate: count, Burst: count, Period: period}
 }
 
+// allower 限流算法，redis_rate.Limiter 实现了该接口
+type allower interface {
+	Allow(ctx context.Context, key string, limit redis_rate.Limit) (*redis_rate.Result, error)
+	AllowN(ctx context.Context, key string, limit redis_rate.Limit, n int) (*redis_rate.Result, error)
+}
+
 type RateLimiter struct {
-	limiter *redis_rate.Limiter
+	limiter allower
 	rds     *redis.Client
+	metrics MetricsSink
 }
 
-func New(rds *redis.Client, limiter *redis_rate.Limiter) *RateLimiter {
-	return &RateLimiter{limiter: limiter, rds: rds}
+func New(rds *redis.Client, limiter *redis_rate.Limiter, metrics MetricsSink) *RateLimiter {
+	if metrics == nil {
+		metrics = nopSink{}
+	}
+
+	return &RateLimiter{limiter: limiter, rds: rds, metrics: metrics}
 }
 
 // Allow 检查是否允许访问
 func (rl *RateLimiter) Allow(ctx context.Context, key string, limit redis_rate.Limit) error {
+	return rl.AllowScoped(ctx, Scope{Limiter: LimiterDefault}, key, limit)
+}
+
+// AllowScoped 检查是否允许访问，限流结果会按照 scope 上报到限流事件收集器
+func (rl *RateLimiter) AllowScoped(ctx context.Context, scope Scope, key string, limit redis_rate.Limit) error {
 	res, err := rl.limiter.Allow(ctx, key, limit)
 	if err != nil {
 		return err
 	}
 
 	if res.Remaining <= 0 {
+		rl.metrics.Observe(Event{Scope: scope, Admitted: false})
+		return ErrRateLimitExceeded
+	}
+
+	rl.metrics.Observe(Event{Scope: scope, Admitted: true})
+	return nil
+}
+
+// AllowNScoped 检查是否允许一次消耗 n 个配额（如按照 Token 数量限流），限流结果会按照 scope 上报到限流事件收集器
+func (rl *RateLimiter) AllowNScoped(ctx context.Context, scope Scope, key string, limit redis_rate.Limit, n int) error {
+	res, err := rl.limiter.AllowN(ctx, key, limit, n)
+	if err != nil {
+		return err
+	}
+
+	if res.Allowed <= 0 {
+		rl.metrics.Observe(Event{Scope: scope, Admitted: false})
 		return ErrRateLimitExceeded
 	}
 
+	rl.metrics.Observe(Event{Scope: scope, Admitted: true})
 	return nil
 }
 
+// Wait 检查是否允许访问，超过限制时排队等待，直到被放行或者等待时间超过 maxWait
+func (rl *RateLimiter) Wait(ctx context.Context, scope Scope, key string, limit redis_rate.Limit, maxWait time.Duration) error {
+	startAt := time.Now()
+	for {
+		res, err := rl.limiter.Allow(ctx, key, limit)
+		if err != nil {
+			return err
+		}
+
+		if res.Allowed > 0 {
+			rl.metrics.Observe(Event{Scope: scope, Admitted: true, Wait: time.Since(startAt)})
+			return nil
+		}
+
+		if res.RetryAfter < 0 || time.Since(startAt)+res.RetryAfter > maxWait {
+			rl.metrics.Observe(Event{Scope: scope, Admitted: false, Wait: time.Since(startAt)})
+			return ErrRateLimitExceeded
+		}
+
+		select {
+		case <-ctx.Done():
+			rl.metrics.Observe(Event{Scope: scope, Admitted: false, Wait: time.Since(startAt)})
+			return ctx.Err()
+		case <-time.After(res.RetryAfter):
+		}
+	}
+}
+
 // OperationCount 获取操作次数
 func (rl *RateLimiter) OperationCount(ctx context.Context, key string) (int64, error) {
 	res, err := rl.rds.Get(ctx, key).Result()
@@ -0,0 +1,99 @@
+package rate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/mylxsw/go-utils/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// windowAllower 固定窗口计数的限流算法，用于模拟突发请求
+type windowAllower struct {
+	lock     sync.Mutex
+	windowAt time.Time
+	counts   map[string]int
+}
+
+func (a *windowAllower) Allow(ctx context.Context, key string, limit redis_rate.Limit) (*redis_rate.Result, error) {
+	return a.AllowN(ctx, key, limit, 1)
+}
+
+func (a *windowAllower) AllowN(ctx context.Context, key string, limit redis_rate.Limit, n int) (*redis_rate.Result, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	if a.counts == nil || now.Sub(a.windowAt) >= limit.Period {
+		a.windowAt = now
+		a.counts = make(map[string]int)
+	}
+
+	if a.counts[key]+n > limit.Rate {
+		return &redis_rate.Result{Limit: limit, Allowed: 0, Remaining: 0, RetryAfter: limit.Period - now.Sub(a.windowAt)}, nil
+	}
+
+	a.counts[key] += n
+	return &redis_rate.Result{Limit: limit, Allowed: n, Remaining: limit.Rate - a.counts[key] + 1}, nil
+}
+
+func newTestLimiter(t *testing.T) (*RateLimiter, *PrometheusSink) {
+	sink, err := NewPrometheusSink(prometheus.NewRegistry())
+	assert.NoError(t, err)
+
+	return &RateLimiter{limiter: &windowAllower{}, metrics: sink}, sink
+}
+
+func TestRateLimiter_AllowScopedBurst(t *testing.T) {
+	rl, sink := newTestLimiter(t)
+
+	scope := Scope{Limiter: LimiterUser, Channel: "gpt-4"}
+	for i := 0; i < 8; i++ {
+		_ = rl.AllowScoped(context.TODO(), scope, "user:1", MaxRequestsInPeriod(5, time.Minute))
+	}
+
+	assert.EqualValues(t, 5, testutil.ToFloat64(sink.admissions.WithLabelValues(LimiterUser, "gpt-4")))
+	assert.EqualValues(t, 3, testutil.ToFloat64(sink.rejections.WithLabelValues(LimiterUser, "gpt-4")))
+
+	// 其它渠道的计数不受影响
+	assert.EqualValues(t, 0, testutil.ToFloat64(sink.rejections.WithLabelValues(LimiterUser, "gpt-3.5-turbo")))
+}
+
+func TestRateLimiter_WaitBurst(t *testing.T) {
+	rl, sink := newTestLimiter(t)
+
+	scope := Scope{Limiter: LimiterChannel, Channel: "openai"}
+	limit := MaxRequestsInPeriod(2, 50*time.Millisecond)
+
+	// 前两个请求直接放行，第三个请求排队等待到下一个窗口后放行
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, rl.Wait(context.TODO(), scope, "channel:1", limit, time.Second))
+	}
+
+	// 等待时间不足时直接拒绝
+	assert.NoError(t, rl.Wait(context.TODO(), scope, "channel:1", limit, time.Second))
+	assert.Equal(t, ErrRateLimitExceeded, rl.Wait(context.TODO(), scope, "channel:1", limit, time.Millisecond))
+
+	assert.EqualValues(t, 4, testutil.ToFloat64(sink.admissions.WithLabelValues(LimiterChannel, "openai")))
+	assert.EqualValues(t, 1, testutil.ToFloat64(sink.rejections.WithLabelValues(LimiterChannel, "openai")))
+	assert.True(t, testutil.CollectAndCount(sink.waits) > 0)
+}
+
+func TestRateLimiter_AllowNScoped(t *testing.T) {
+	rl, sink := newTestLimiter(t)
+
+	scope := Scope{Limiter: LimiterAPIKey}
+	limit := redis_rate.PerMinute(1000)
+
+	// 按照 Token 数量限流，剩余额度不足时拒绝，不影响之后额度足够的请求
+	assert.NoError(t, rl.AllowNScoped(context.TODO(), scope, "key:1:tpm", limit, 800))
+	assert.Equal(t, ErrRateLimitExceeded, rl.AllowNScoped(context.TODO(), scope, "key:1:tpm", limit, 300))
+	assert.NoError(t, rl.AllowNScoped(context.TODO(), scope, "key:1:tpm", limit, 200))
+
+	assert.EqualValues(t, 2, testutil.ToFloat64(sink.admissions.WithLabelValues(LimiterAPIKey, "")))
+	assert.EqualValues(t, 1, testutil.ToFloat64(sink.rejections.WithLabelValues(LimiterAPIKey, "")))
+}
@@ -0,0 +1,100 @@
+package rate
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 限流器类型
+const (
+	// LimiterDefault 未指定类型的限流
+	LimiterDefault = "default"
+	// LimiterGlobal 全局限流（如基于客户端 IP 的请求频率限制）
+	LimiterGlobal = "global"
+	// LimiterUser 用户级别限流
+	LimiterUser = "user"
+	// LimiterChannel 渠道级别限流
+	LimiterChannel = "channel"
+	// LimiterAPIKey API Key 级别限流（Key 单独配置的 RPM/TPM）
+	LimiterAPIKey = "api_key"
+	// LimiterConcurrency 聊天请求的并发上限（达到上限时排队等待）
+	LimiterConcurrency = "concurrency"
+)
+
+// Scope 限流事件的标签，用于区分限流器类型和渠道
+type Scope struct {
+	// Limiter 限流器类型：global/user/channel/api_key/concurrency
+	Limiter string
+	// Channel 渠道（或模型），不区分渠道时留空
+	Channel string
+}
+
+// Event 限流事件
+type Event struct {
+	Scope
+	// Admitted 是否放行
+	Admitted bool
+	// Wait 排队等待时间，未排队时为 0
+	Wait time.Duration
+}
+
+// MetricsSink 限流事件收集器，所有的限流器都会将事件上报到这里
+type MetricsSink interface {
+	Observe(evt Event)
+}
+
+// PrometheusSink 基于 Prometheus 的限流事件收集器
+type PrometheusSink struct {
+	admissions *prometheus.CounterVec
+	rejections *prometheus.CounterVec
+	waits      *prometheus.HistogramVec
+}
+
+// NewPrometheusSink 创建基于 Prometheus 的限流事件收集器，并注册到 registerer
+func NewPrometheusSink(registerer prometheus.Registerer) (*PrometheusSink, error) {
+	labels := []string{"limiter", "channel"}
+	sink := &PrometheusSink{
+		admissions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "aidea",
+			Name:      "rate_limit_admission_count",
+			Help:      "rate limiter admission counts",
+		}, labels),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "aidea",
+			Name:      "rate_limit_rejection_count",
+			Help:      "rate limiter rejection counts",
+		}, labels),
+		waits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "aidea",
+			Name:      "rate_limit_queue_wait_seconds",
+			Help:      "rate limiter queue wait time in seconds",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, labels),
+	}
+
+	for _, c := range []prometheus.Collector{sink.admissions, sink.rejections, sink.waits} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+func (sink *PrometheusSink) Observe(evt Event) {
+	if evt.Admitted {
+		sink.admissions.WithLabelValues(evt.Limiter, evt.Channel).Inc()
+	} else {
+		sink.rejections.WithLabelValues(evt.Limiter, evt.Channel).Inc()
+	}
+
+	if evt.Wait > 0 {
+		sink.waits.WithLabelValues(evt.Limiter, evt.Channel).Observe(evt.Wait.Seconds())
+	}
+}
+
+// nopSink 不做任何处理的限流事件收集器
+type nopSink struct{}
+
+func (nopSink) Observe(Event) {}
@@ -1,12 +1,23 @@
 package rate
 
 import (
+	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/glacier/infra"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Provider struct{}
 
 func (Provider) Register(binder infra.Binder) {
 	binder.MustSingleton(NewLimiter)
+	binder.MustSingleton(func() MetricsSink {
+		sink, err := NewPrometheusSink(prometheus.DefaultRegisterer)
+		if err != nil {
+			log.Errorf("register rate limiter metrics failed: %v", err)
+			return nopSink{}
+		}
+
+		return sink
+	})
 	binder.MustSingleton(New)
 }
@@ -0,0 +1,249 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/eloquent/query"
+	"github.com/mylxsw/go-utils/array"
+)
+
+const (
+	UserApiKeyStatusDisabled = 2
+	UserAPiKeyStatusActive   = 1
+)
+
+// APIKeyPrefix API Key 的固定前缀
+const APIKeyPrefix = "sk-"
+
+// APIKey 用户创建的 API Key，不包含 Key 的明文
+type APIKey struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	// Token Key 的掩码形式，只保留开头和结尾的几个字符，用于区分不同的 Key
+	Token string `json:"token"`
+	// AllowedModels 允许使用的模型，支持通配符（参考 ModelAllowed），为空时不限制
+	AllowedModels []string `json:"allowed_models,omitempty"`
+	// RPM 每分钟最大请求数，为 0 时不限制
+	RPM int64 `json:"rpm,omitempty"`
+	// TPM 每分钟最大输入 Token 数，为 0 时不限制
+	TPM         int64     `json:"tpm,omitempty"`
+	ValidBefore time.Time `json:"valid_before"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AllowsModel API Key 是否允许使用指定的模型
+func (k APIKey) AllowsModel(model string) bool {
+	return ModelAllowed(k.AllowedModels, model)
+}
+
+// APIKeyOptions 创建 API Key 时的参数
+type APIKeyOptions struct {
+	Name          string
+	AllowedModels []string
+	RPM           int64
+	TPM           int64
+	ValidBefore   time.Time
+}
+
+// HashAPIKey 计算 API Key 的哈希值，数据库中只保存哈希值
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIKey 生成一个随机的 API Key
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return APIKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+// maskAPIKey 新创建的 Key 的掩码形式，保留前缀之后的 4 个字符和最后 4 个字符
+func maskAPIKey(plain string) string {
+	return plain[:len(APIKeyPrefix)+4] + "****" + plain[len(plain)-4:]
+}
+
+type APIKeyRepo struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepo(db *sql.DB) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+func buildAPIKey(item model.UserApiKeyN) APIKey {
+	key := item.ToUserApiKey()
+	ret := APIKey{
+		ID:          key.Id,
+		UserID:      key.UserId,
+		Name:        key.Name,
+		Token:       key.KeyPrefix,
+		RPM:         key.Rpm,
+		TPM:         key.Tpm,
+		ValidBefore: key.ValidBefore,
+		LastUsedAt:  key.LastUsedAt,
+		CreatedAt:   key.CreatedAt,
+	}
+
+	// 历史数据保存的是明文，展示时隐藏中间部分
+	if key.Hashed == 0 {
+		ret.Token = misc.MaskStr(key.Token, 6)
+	}
+
+	if key.AllowedModels != "" {
+		_ = json.Unmarshal([]byte(key.AllowedModels), &ret.AllowedModels)
+	}
+
+	return ret
+}
+
+// Keys 获取用户的 API Keys
+func (r *APIKeyRepo) Keys(ctx context.Context, userID int64) ([]APIKey, error) {
+	q := query.Builder().
+		Where(model.FieldUserApiKeyUserId, userID).
+		Where(model.FieldUserApiKeyStatus, UserAPiKeyStatusActive).
+		OrderBy(model.FieldUserApiKeyId, "DESC")
+
+	keys, err := model.NewUserApiKeyModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(keys, func(item model.UserApiKeyN, _ int) APIKey { return buildAPIKey(item) }), nil
+}
+
+// Key 获取用户的 API Key
+func (r *APIKeyRepo) Key(ctx context.Context, userID int64, keyID int64) (*APIKey, error) {
+	item, err := model.NewUserApiKeyModel(r.db).First(ctx, query.Builder().
+		Where(model.FieldUserApiKeyUserId, userID).
+		Where(model.FieldUserApiKeyId, keyID).
+		Where(model.FieldUserApiKeyStatus, UserAPiKeyStatusActive),
+	)
+	if err != nil {
+		if errors.Is(err, query.ErrNoResult) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	ret := buildAPIKey(*item)
+	return &ret, nil
+}
+
+// Create 创建一个 API Key，返回 Key 的明文，数据库中只保存哈希值，明文只在创建时返回这一次
+func (r *APIKeyRepo) Create(ctx context.Context, userID int64, opts APIKeyOptions) (*APIKey, string, error) {
+	plain, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	allowedModels, _ := json.Marshal(opts.AllowedModels)
+	if len(opts.AllowedModels) == 0 {
+		allowedModels = nil
+	}
+
+	key := model.UserApiKey{
+		UserId:        userID,
+		Name:          opts.Name,
+		Token:         HashAPIKey(plain),
+		Hashed:        1,
+		KeyPrefix:     maskAPIKey(plain),
+		AllowedModels: string(allowedModels),
+		Rpm:           opts.RPM,
+		Tpm:           opts.TPM,
+		Status:        UserAPiKeyStatusActive,
+		ValidBefore:   opts.ValidBefore,
+		CreatedAt:     time.Now(),
+	}
+
+	allows := []string{
+		model.FieldUserApiKeyUserId,
+		model.FieldUserApiKeyName,
+		model.FieldUserApiKeyToken,
+		model.FieldUserApiKeyHashed,
+		model.FieldUserApiKeyKeyPrefix,
+		model.FieldUserApiKeyAllowedModels,
+		model.FieldUserApiKeyRpm,
+		model.FieldUserApiKeyTpm,
+		model.FieldUserApiKeyStatus,
+	}
+
+	if !opts.ValidBefore.IsZero() {
+		allows = append(allows, model.FieldUserApiKeyValidBefore)
+	}
+
+	id, err := model.NewUserApiKeyModel(r.db).Save(ctx, key.ToUserApiKeyN(allows...))
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.Id = id
+
+	ret := buildAPIKey(key.ToUserApiKeyN())
+	return &ret, plain, nil
+}
+
+// Revoke 吊销用户的 API Key
+func (r *APIKeyRepo) Revoke(ctx context.Context, userID int64, keyID int64) error {
+	q := query.Builder().Where(model.FieldUserApiKeyUserId, userID).Where(model.FieldUserApiKeyId, keyID)
+	update := query.KV{model.FieldUserApiKeyStatus: UserApiKeyStatusDisabled}
+
+	_, err := model.NewUserApiKeyModel(r.db).UpdateFields(ctx, update, q)
+	return err
+}
+
+// Authenticate 根据请求中携带的 Key 查询 API Key，优先按照哈希值查询，兼容历史数据中保存的明文 Key。
+// Key 不存在、已吊销或者已过期时返回 ErrNotFound
+func (r *APIKeyRepo) Authenticate(ctx context.Context, credential string) (*APIKey, error) {
+	item, err := model.NewUserApiKeyModel(r.db).First(ctx, query.Builder().
+		Where(model.FieldUserApiKeyToken, HashAPIKey(credential)).
+		Where(model.FieldUserApiKeyHashed, 1),
+	)
+	if errors.Is(err, query.ErrNoResult) {
+		item, err = model.NewUserApiKeyModel(r.db).First(ctx, query.Builder().
+			Where(model.FieldUserApiKeyToken, credential).
+			WhereRaw("(hashed IS NULL OR hashed = 0)"),
+		)
+	}
+
+	if err != nil {
+		if errors.Is(err, query.ErrNoResult) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	key := buildAPIKey(*item)
+	if item.Status.ValueOrZero() == UserApiKeyStatusDisabled {
+		return nil, ErrNotFound
+	}
+
+	if !key.ValidBefore.IsZero() && key.ValidBefore.Before(time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	return &key, nil
+}
+
+// TouchLastUsed 更新 API Key 的最后使用时间
+func (r *APIKeyRepo) TouchLastUsed(ctx context.Context, keyID int64, usedAt time.Time) error {
+	q := query.Builder().Where(model.FieldUserApiKeyId, keyID)
+	_, err := model.NewUserApiKeyModel(r.db).UpdateFields(ctx, query.KV{model.FieldUserApiKeyLastUsedAt: usedAt}, q)
+	return err
+}
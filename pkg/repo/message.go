@@ -3,9 +3,11 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/repo/model"
 	"github.com/mylxsw/go-utils/array"
+	"github.com/mylxsw/go-utils/ternary"
 	"time"
 
 	"github.com/mylxsw/eloquent"
@@ -21,11 +23,16 @@ func NewMessageRepo(db *sql.DB) *MessageRepo {
 	return &MessageRepo{db: db}
 }
 
+// ErrPinnedMessagesExceed 房间中固定的消息数量已经达到上限
+var ErrPinnedMessagesExceed = errors.New("pinned messages exceed limit")
+
 type MessageRole int64
 
 const (
 	MessageRoleUser      MessageRole = 1
 	MessageRoleAssistant MessageRole = 2
+	// MessageRoleSystem 系统生成的消息（如房间的消息摘要），不参与对话上下文
+	MessageRoleSystem MessageRole = 3
 )
 
 type MessageAddReq struct {
@@ -39,6 +46,16 @@ type MessageAddReq struct {
 	Model         string
 	Status        int64
 	Error         string
+
+	// 实际生效的请求信息，只有回答需要保存，用于排查问题
+	SystemPromptHash string
+	SystemPrompt     string
+	UpstreamModel    string
+	ChannelID        int64
+	Provider         string
+
+	// Parts 回复中的多模态内容（如生成的图片及说明），JSON 格式
+	Parts string
 }
 
 func (r *MessageRepo) Add(ctx context.Context, req MessageAddReq) (int64, error) {
@@ -69,6 +86,24 @@ func (r *MessageRepo) Add(ctx context.Context, req MessageAddReq) (int64, error)
 		kvs[model.FieldChatMessagesError] = req.Error
 	}
 
+	if req.SystemPromptHash != "" {
+		kvs[model.FieldChatMessagesSystemPromptHash] = req.SystemPromptHash
+	}
+
+	if req.SystemPrompt != "" {
+		kvs[model.FieldChatMessagesSystemPrompt] = req.SystemPrompt
+	}
+
+	if req.UpstreamModel != "" {
+		kvs[model.FieldChatMessagesUpstreamModel] = req.UpstreamModel
+		kvs[model.FieldChatMessagesChannelId] = req.ChannelID
+		kvs[model.FieldChatMessagesProvider] = req.Provider
+	}
+
+	if req.Parts != "" {
+		kvs[model.FieldChatMessagesParts] = req.Parts
+	}
+
 	return id, eloquent.Transaction(r.db, func(tx query.Database) error {
 		var err error
 		id, err = model.NewChatMessagesModel(tx).Create(ctx, kvs)
@@ -139,6 +174,151 @@ func (r *MessageRepo) RecentlyMessages(ctx context.Context, userID, roomID int64
 	return array.Map(messages, func(m model.ChatMessagesN, _ int) model.ChatMessages { return m.ToChatMessages() }), nil
 }
 
+// MessagesAfter 查询房间中 ID 大于 afterID 的最近 limit 条用户消息和回答（只包含成功的消息），按照 ID 升序排列
+func (r *MessageRepo) MessagesAfter(ctx context.Context, userID, roomID, afterID int64, limit int64) ([]model.ChatMessages, error) {
+	q := query.Builder().
+		Where(model.FieldChatMessagesUserId, userID).
+		Where(model.FieldChatMessagesRoomId, roomID).
+		Where(model.FieldChatMessagesId, ">", afterID).
+		WhereIn(model.FieldChatMessagesRole, MessageRoleUser, MessageRoleAssistant).
+		Where(model.FieldChatMessagesStatus, MessageStatusSucceed).
+		OrderBy(model.FieldChatMessagesId, "DESC").
+		Limit(limit)
+
+	messages, err := model.NewChatMessagesModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Reverse(array.Map(messages, func(m model.ChatMessagesN, _ int) model.ChatMessages { return m.ToChatMessages() })), nil
+}
+
+// PinnedMessages 查询房间中固定的用户消息和回答（只包含成功的消息），按照 ID 升序排列
+func (r *MessageRepo) PinnedMessages(ctx context.Context, userID, roomID int64) ([]model.ChatMessages, error) {
+	q := query.Builder().
+		Where(model.FieldChatMessagesUserId, userID).
+		Where(model.FieldChatMessagesRoomId, roomID).
+		Where(model.FieldChatMessagesPinned, 1).
+		WhereIn(model.FieldChatMessagesRole, MessageRoleUser, MessageRoleAssistant).
+		Where(model.FieldChatMessagesStatus, MessageStatusSucceed).
+		OrderBy(model.FieldChatMessagesId, "ASC")
+
+	messages, err := model.NewChatMessagesModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(messages, func(m model.ChatMessagesN, _ int) model.ChatMessages { return m.ToChatMessages() }), nil
+}
+
+// SetPinned 固定或者取消固定用户的消息，消息不存在时返回 ErrNotFound；
+// 固定消息时，房间中已经固定的消息数量达到 maxPinned 时返回 ErrPinnedMessagesExceed（maxPinned 小于等于 0 时不限制）
+func (r *MessageRepo) SetPinned(ctx context.Context, userID, messageID int64, pinned bool, maxPinned int64) error {
+	return eloquent.Transaction(r.db, func(tx query.Database) error {
+		msg, err := model.NewChatMessagesModel(tx).First(ctx, query.Builder().
+			Where(model.FieldChatMessagesUserId, userID).
+			Where(model.FieldChatMessagesId, messageID).
+			WhereIn(model.FieldChatMessagesRole, MessageRoleUser, MessageRoleAssistant).
+			Where(model.FieldChatMessagesStatus, MessageStatusSucceed))
+		if err != nil {
+			if errors.Is(err, query.ErrNoResult) {
+				return ErrNotFound
+			}
+
+			return err
+		}
+
+		if pinned == (msg.Pinned.ValueOrZero() == 1) {
+			return nil
+		}
+
+		if pinned && maxPinned > 0 {
+			count, err := model.NewChatMessagesModel(tx).Count(ctx, query.Builder().
+				Where(model.FieldChatMessagesUserId, userID).
+				Where(model.FieldChatMessagesRoomId, msg.RoomId.ValueOrZero()).
+				Where(model.FieldChatMessagesPinned, 1))
+			if err != nil {
+				return err
+			}
+
+			if count >= maxPinned {
+				return ErrPinnedMessagesExceed
+			}
+		}
+
+		_, err = model.NewChatMessagesModel(tx).UpdateFields(
+			ctx,
+			query.KV{model.FieldChatMessagesPinned: ternary.If[int64](pinned, 1, 0)},
+			query.Builder().Where(model.FieldChatMessagesId, messageID),
+		)
+		return err
+	})
+}
+
+// LatestMessageID 查询房间中最新的消息 ID，没有消息时返回 0
+func (r *MessageRepo) LatestMessageID(ctx context.Context, userID, roomID int64) (int64, error) {
+	messages, err := r.RecentlyMessages(ctx, userID, roomID, 0, 1)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	return messages[0].Id, nil
+}
+
+// UserAnswer 查询用户的一条回答（助手消息），不存在时返回 ErrNotFound
+func (r *MessageRepo) UserAnswer(ctx context.Context, userID, messageID int64) (*model.ChatMessages, error) {
+	msg, err := model.NewChatMessagesModel(r.db).First(ctx, query.Builder().
+		Where(model.FieldChatMessagesUserId, userID).
+		Where(model.FieldChatMessagesId, messageID).
+		Where(model.FieldChatMessagesRole, MessageRoleAssistant))
+	if err != nil {
+		if errors.Is(err, query.ErrNoResult) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	ret := msg.ToChatMessages()
+	return &ret, nil
+}
+
+// AnswerParts 查询房间中包含多模态内容（如生成的图片）的回答的 parts 字段（JSON 格式），since 不为零值时只查询该时间之后的回答
+func (r *MessageRepo) AnswerParts(ctx context.Context, userID, roomID int64, since time.Time) ([]string, error) {
+	q := query.Builder().
+		Where(model.FieldChatMessagesUserId, userID).
+		Where(model.FieldChatMessagesRoomId, roomID).
+		Where(model.FieldChatMessagesRole, MessageRoleAssistant).
+		WhereNotNull(model.FieldChatMessagesParts).
+		Where(model.FieldChatMessagesParts, "!=", "")
+
+	if !since.IsZero() {
+		q = q.Where(model.FieldChatMessagesCreatedAt, ">=", since)
+	}
+
+	messages, err := model.NewChatMessagesModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(messages, func(m model.ChatMessagesN, _ int) string { return m.Parts.ValueOrZero() }), nil
+}
+
+// Answers 查询指定问题（请求）的所有回答
+func (r *MessageRepo) Answers(ctx context.Context, questionID int64) ([]model.ChatMessages, error) {
+	q := query.Builder().
+		Where(model.FieldChatMessagesPid, questionID).
+		Where(model.FieldChatMessagesRole, MessageRoleAssistant).
+		OrderBy(model.FieldChatMessagesId, "ASC")
+
+	messages, err := model.NewChatMessagesModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(messages, func(m model.ChatMessagesN, _ int) model.ChatMessages { return m.ToChatMessages() }), nil
+}
+
 func (r *MessageRepo) Messages(ctx context.Context, page, perPage int64, options ...QueryOption) ([]model.ChatMessages, query.PaginateMeta, error) {
 	q := query.Builder().OrderBy(model.FieldChatMessagesId, "DESC")
 	for _, opt := range options {
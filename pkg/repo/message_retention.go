@@ -0,0 +1,252 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/eloquent"
+	"github.com/mylxsw/eloquent/query"
+)
+
+// messageTombstoneTable 聊天记录删除标记表，同步客户端根据删除标记清理本地的聊天记录
+const messageTombstoneTable = "chat_message_tombstones"
+
+const (
+	// TombstoneReasonRetention 超过保留期限被清理
+	TombstoneReasonRetention = "retention"
+	// TombstoneReasonUser 用户主动删除所有聊天记录
+	TombstoneReasonUser = "user"
+)
+
+// MessagePurgeQuery 需要清理的聊天记录的查询条件
+type MessagePurgeQuery struct {
+	// UserID 只清理指定用户的聊天记录，为 0 时不限制
+	UserID int64
+	// Before 只清理该时间之前的聊天记录，为零值时不限制
+	Before time.Time
+	// UserTypes 只清理（ExcludeUserTypes 为 true 时排除）这些用户类型的聊天记录，为空时不限制
+	UserTypes        []int64
+	ExcludeUserTypes bool
+	// Limit 每次最多清理的记录数量
+	Limit int64
+}
+
+// conditions 返回查询条件（表别名 m 为消息表，u 为 users 表）以及对应的参数
+func (q MessagePurgeQuery) conditions(timeField string) (string, []any) {
+	conditions := []string{"1 = 1"}
+	var args []any
+
+	if q.UserID > 0 {
+		conditions = append(conditions, "m.user_id = ?")
+		args = append(args, q.UserID)
+	}
+
+	if !q.Before.IsZero() {
+		conditions = append(conditions, "m."+timeField+" < ?")
+		args = append(args, q.Before)
+	}
+
+	if len(q.UserTypes) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(q.UserTypes)), ", ")
+		// 用户已经不存在时按照普通用户处理
+		if q.ExcludeUserTypes {
+			conditions = append(conditions, "COALESCE(u.user_type, 0) NOT IN ("+placeholders+")")
+		} else {
+			conditions = append(conditions, "COALESCE(u.user_type, 0) IN ("+placeholders+")")
+		}
+
+		for _, typ := range q.UserTypes {
+			args = append(args, typ)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// PurgeMessage 需要清理的聊天记录，只包含清理需要的字段
+type PurgeMessage struct {
+	ID     int64
+	UserID int64
+	RoomID int64
+	// Parts 回复中的多模态内容，用于清理关联的文件
+	Parts string
+}
+
+// PurgeCandidates 按照 ID 升序查询满足条件的聊天记录，每次最多 q.Limit 条
+func (r *MessageRepo) PurgeCandidates(ctx context.Context, q MessagePurgeQuery) ([]PurgeMessage, error) {
+	conditions, args := q.conditions("created_at")
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT m.id, m.user_id, COALESCE(m.room_id, 0), COALESCE(m.parts, '') FROM chat_messages m LEFT JOIN users u ON u.id = m.user_id WHERE "+conditions+" ORDER BY m.id ASC LIMIT ?",
+		append(args, q.Limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]PurgeMessage, 0)
+	for rows.Next() {
+		var msg PurgeMessage
+		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.RoomID, &msg.Parts); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// PurgeTombstone 删除聊天记录时写入的删除标记
+type PurgeTombstone struct {
+	UserID       int64
+	RoomID       int64
+	MaxMessageID int64
+	Reason       string
+}
+
+// PurgeTombstones 按照房间汇总一批需要删除的聊天记录，每个房间一个删除标记（房间中 ID 不大于 MaxMessageID 的消息都已经删除），
+// 按照房间第一次出现的顺序返回
+func PurgeTombstones(messages []PurgeMessage, reason string) []PurgeTombstone {
+	type roomKey struct{ userID, roomID int64 }
+	indexes := make(map[roomKey]int)
+	tombstones := make([]PurgeTombstone, 0)
+	for _, msg := range messages {
+		key := roomKey{userID: msg.UserID, roomID: msg.RoomID}
+		if i, ok := indexes[key]; ok {
+			tombstones[i].MaxMessageID = max(tombstones[i].MaxMessageID, msg.ID)
+			continue
+		}
+
+		indexes[key] = len(tombstones)
+		tombstones = append(tombstones, PurgeTombstone{UserID: msg.UserID, RoomID: msg.RoomID, MaxMessageID: msg.ID, Reason: reason})
+	}
+
+	return tombstones
+}
+
+// PurgeMessages 删除聊天记录以及对应的搜索索引，同时为每个房间写入删除标记（参考 PurgeTombstones）
+func (r *MessageRepo) PurgeMessages(ctx context.Context, messages []PurgeMessage, reason string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]any, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+
+	tombstones := PurgeTombstones(messages, reason)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	return eloquent.Transaction(r.db, func(tx query.Database) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM chat_messages WHERE id IN ("+placeholders+")", ids...); err != nil {
+			return fmt.Errorf("delete messages failed: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+messageSearchTable+" WHERE message_id IN ("+placeholders+")", ids...); err != nil {
+			return fmt.Errorf("delete search index failed: %w", err)
+		}
+
+		values := make([]string, 0, len(tombstones))
+		args := make([]any, 0, len(tombstones)*4)
+		for _, item := range tombstones {
+			values = append(values, "(?, ?, ?, ?)")
+			args = append(args, item.UserID, item.RoomID, item.MaxMessageID, item.Reason)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+messageTombstoneTable+" (user_id, room_id, max_message_id, reason) VALUES "+strings.Join(values, ", "), args...); err != nil {
+			return fmt.Errorf("write tombstones failed: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// PurgeGroupMessages 删除满足条件的群聊消息，每次最多 q.Limit 条，返回删除的数量
+//
+// 群聊消息每次都从服务端加载，不需要写入删除标记
+func (r *MessageRepo) PurgeGroupMessages(ctx context.Context, q MessagePurgeQuery) (int64, error) {
+	conditions, args := q.conditions("created_at")
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT m.id FROM chat_group_message m LEFT JOIN users u ON u.id = m.user_id WHERE "+conditions+" ORDER BY m.id ASC LIMIT ?",
+		append(args, q.Limit)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := make([]any, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM chat_group_message WHERE id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+")", ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ClearRoomDescriptions 清空满足条件（按照房间最后活跃时间）的房间描述，房间描述中保存了最后一条消息的片段
+func (r *MessageRepo) ClearRoomDescriptions(ctx context.Context, q MessagePurgeQuery) error {
+	conditions, args := q.conditions("last_active_time")
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE rooms m LEFT JOIN users u ON u.id = m.user_id SET m.description = NULL WHERE m.description IS NOT NULL AND "+conditions,
+		args...,
+	)
+	return err
+}
+
+// MessageTombstone 聊天记录删除标记：用户房间中 ID 不大于 MaxMessageID 的消息都已经删除
+type MessageTombstone struct {
+	ID           int64     `json:"id"`
+	RoomID       int64     `json:"room_id"`
+	MaxMessageID int64     `json:"max_message_id"`
+	Reason       string    `json:"reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Tombstones 查询用户 ID 大于 afterID 的删除标记，按照 ID 升序排列
+func (r *MessageRepo) Tombstones(ctx context.Context, userID, afterID, limit int64) ([]MessageTombstone, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT id, room_id, max_message_id, reason, created_at FROM "+messageTombstoneTable+" WHERE user_id = ? AND id > ? ORDER BY id ASC LIMIT ?",
+		userID, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tombstones := make([]MessageTombstone, 0)
+	for rows.Next() {
+		var item MessageTombstone
+		if err := rows.Scan(&item.ID, &item.RoomID, &item.MaxMessageID, &item.Reason, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		tombstones = append(tombstones, item)
+	}
+
+	return tombstones, rows.Err()
+}
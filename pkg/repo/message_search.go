@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/eloquent"
+	"github.com/mylxsw/eloquent/query"
+)
+
+// messageSearchTable 聊天记录全文搜索索引表
+const messageSearchTable = "chat_messages_search"
+
+// MessageSearchDoc 聊天记录搜索索引中的一条记录
+type MessageSearchDoc struct {
+	MessageID int64
+	UserID    int64
+	RoomID    int64
+	Role      MessageRole
+	Model     string
+	Content   string
+}
+
+// IndexMessages 将聊天记录写入搜索索引，已经存在的记录忽略
+func (r *MessageRepo) IndexMessages(ctx context.Context, docs ...MessageSearchDoc) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(docs))
+	args := make([]any, 0, len(docs)*6)
+	for _, doc := range docs {
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?)")
+		args = append(args, doc.MessageID, doc.UserID, doc.RoomID, doc.Role, doc.Model, doc.Content)
+	}
+
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT IGNORE INTO "+messageSearchTable+" (message_id, user_id, room_id, role, model, content) VALUES "+strings.Join(placeholders, ", "),
+		args...,
+	)
+	return err
+}
+
+// MessageSearchReq 聊天记录搜索条件
+type MessageSearchReq struct {
+	// Terms 搜索词，所有搜索词都需要匹配
+	Terms []string
+	// RoomID 只搜索指定房间，为 0 时搜索所有房间
+	RoomID int64
+	// Model 只搜索指定模型的聊天记录，为空时不限制
+	Model string
+	// StartAt/EndAt 聊天记录的时间范围，为零值时不限制
+	StartAt time.Time
+	EndAt   time.Time
+	// ExcludePrivate 是否排除私密房间的聊天记录
+	ExcludePrivate bool
+}
+
+// MessageSearchResult 聊天记录搜索结果
+type MessageSearchResult struct {
+	MessageID int64       `json:"message_id"`
+	RoomID    int64       `json:"room_id"`
+	Role      MessageRole `json:"role"`
+	Model     string      `json:"model,omitempty"`
+	Content   string      `json:"content"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// SearchMessages 搜索用户的聊天记录，按照时间倒序排列
+func (r *MessageRepo) SearchMessages(ctx context.Context, userID int64, req MessageSearchReq, page, perPage int64) ([]MessageSearchResult, query.PaginateMeta, error) {
+	q := query.Builder().
+		Table(messageSearchTable).
+		Where("user_id", userID).
+		WhereRaw("MATCH (content) AGAINST (? IN BOOLEAN MODE)", booleanSearchQuery(req.Terms))
+
+	if req.RoomID > 0 {
+		q = q.Where("room_id", req.RoomID)
+	}
+
+	if req.Model != "" {
+		q = q.Where("model", req.Model)
+	}
+
+	if !req.StartAt.IsZero() {
+		q = q.Where("created_at", ">=", req.StartAt)
+	}
+
+	if !req.EndAt.IsZero() {
+		q = q.Where("created_at", "<", req.EndAt)
+	}
+
+	if req.ExcludePrivate {
+		q = q.WhereRaw(
+			"(room_id IS NULL OR room_id NOT IN (SELECT id FROM "+model.RoomsTable()+" WHERE user_id = ? AND private = 1))",
+			userID,
+		)
+	}
+
+	counts, err := eloquent.Query(ctx, r.db, q.Select(query.Raw("COUNT(*)")), func(row eloquent.Scanner) (int64, error) {
+		var count int64
+		err := row.Scan(&count)
+		return count, err
+	})
+	if err != nil {
+		return nil, query.PaginateMeta{}, err
+	}
+
+	meta := query.PaginateMeta{Page: page, PerPage: perPage, Total: counts[0], LastPage: (counts[0] + perPage - 1) / perPage}
+	if counts[0] == 0 {
+		return []MessageSearchResult{}, meta, nil
+	}
+
+	q = q.Select("message_id", "room_id", "role", "model", "content", "created_at").
+		OrderBy("message_id", "DESC").
+		Offset((page - 1) * perPage).
+		Limit(perPage)
+
+	results, err := eloquent.Query(ctx, r.db, q, func(row eloquent.Scanner) (MessageSearchResult, error) {
+		var ret MessageSearchResult
+		var roomID, role sql.NullInt64
+		var mod, content sql.NullString
+		if err := row.Scan(&ret.MessageID, &roomID, &role, &mod, &content, &ret.CreatedAt); err != nil {
+			return ret, err
+		}
+
+		ret.RoomID, ret.Role, ret.Model, ret.Content = roomID.Int64, MessageRole(role.Int64), mod.String, content.String
+		return ret, nil
+	})
+	if err != nil {
+		return nil, query.PaginateMeta{}, err
+	}
+
+	return results, meta, nil
+}
+
+// booleanSearchQuery 将搜索词转换为 BOOLEAN MODE 的查询语句，每个搜索词作为短语（ngram 分词的连续匹配）且必须匹配
+func booleanSearchQuery(terms []string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		// 去掉短语中无法转义的双引号
+		term = strings.TrimSpace(strings.ReplaceAll(term, `"`, " "))
+		if term != "" {
+			parts = append(parts, `+"`+term+`"`)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
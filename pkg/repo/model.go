@@ -9,6 +9,7 @@ import (
 	"github.com/mylxsw/aidea-server/internal/coins"
 	"github.com/mylxsw/aidea-server/pkg/ai/control"
 	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/secret"
 	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/eloquent"
 	"github.com/mylxsw/eloquent/query"
@@ -20,11 +21,12 @@ import (
 )
 
 type ModelRepo struct {
-	db *sql.DB
+	db      *sql.DB
+	secrets *secret.Envelope
 }
 
-func NewModelRepo(db *sql.DB) *ModelRepo {
-	return &ModelRepo{db: db}
+func NewModelRepo(db *sql.DB, secrets *secret.Envelope) *ModelRepo {
+	return &ModelRepo{db: db, secrets: secrets}
 }
 
 type Model struct {
@@ -116,6 +118,42 @@ type ModelMeta struct {
 
 	// Prompt 全局的系统提示语
 	Prompt string `json:"prompt,omitempty"`
+
+	// 请求参数的默认值，请求和房间（数字人）中未指定时使用，优先级高于部署配置
+	// Temperature 采样温度
+	Temperature *float64 `json:"temperature,omitempty"`
+	// TopP 核采样概率
+	TopP *float64 `json:"top_p,omitempty"`
+	// MaxTokens 最大输出 Token 数量
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// ImageDetail 图片的识别精度：low/high/auto
+	ImageDetail string `json:"image_detail,omitempty"`
+	// ReplyLanguage 回复使用的语言
+	ReplyLanguage string `json:"reply_language,omitempty"`
+
+	// Compression 长输入压缩配置，为空时不启用
+	Compression *CompressionMeta `json:"compression,omitempty"`
+	// Reproducible 是否支持可复现的输出：temperature 为 0 且指定了 seed 时，相同的请求返回相同的结果
+	Reproducible bool `json:"reproducible,omitempty"`
+	// VisionDegradation 所有支持图片的服务提供商都不可用时，包含图片的请求的处理策略：
+	// fail（默认，返回“图片理解暂不可用”错误）/strip（去掉图片，使用纯文本的服务提供商回答）
+	VisionDegradation string `json:"vision_degradation,omitempty"`
+	// MaxOutput 模型支持的最大输出 Token 数量，用于计算流式输出的 Token 数量上限
+	MaxOutput int `json:"max_output,omitempty"`
+	// LanguageRouting 是否按照提示语的语言选择服务提供商：优先使用为最后一条用户消息的语言标记的（ModelProvider.Languages）健康的服务提供商
+	LanguageRouting bool `json:"language_routing,omitempty"`
+}
+
+// CompressionMeta 长输入压缩配置，输入超过阈值时，使用辅助模型压缩较早的对话
+type CompressionMeta struct {
+	// Threshold 输入 Token 数量超过该值时启用压缩
+	Threshold int `json:"threshold,omitempty"`
+	// Ratio 压缩后的目标长度占原始长度的比例，默认 0.3
+	Ratio float64 `json:"ratio,omitempty"`
+	// KeepTurns 保持原样的最近对话轮数，默认 2
+	KeepTurns int `json:"keep_turns,omitempty"`
+	// Model 压缩使用的辅助模型，为空时使用配置项 chat-compression-model
+	Model string `json:"model,omitempty"`
 }
 
 type ModelProvider struct {
@@ -125,6 +163,12 @@ type ModelProvider struct {
 	Name string `json:"name,omitempty"`
 	// ModelRewrite 模型名称重写，如果为空，则使用模型的名称
 	ModelRewrite string `json:"model_rewrite,omitempty"`
+	// Prompt 供应商默认的系统提示语
+	Prompt string `json:"prompt,omitempty"`
+	// TextOnly 供应商是否只支持文本（如视觉模型的纯文本备用渠道），包含图片的请求不会使用该供应商
+	TextOnly bool `json:"text_only,omitempty"`
+	// Languages 供应商擅长的语言（如 zh、en），模型开启按语言路由时（ModelMeta.LanguageRouting），优先用于这些语言的请求
+	Languages []string `json:"languages,omitempty"`
 }
 
 // SupportProvider check if the model support the provider
@@ -289,6 +333,72 @@ type ChannelMeta struct {
 	OpenAIAzure bool `json:"openai_azure,omitempty"`
 	// OpenAIAzureAPIVersion OpenAI Azure API 版本
 	OpenAIAzureAPIVersion string `json:"openai_azure_api_version,omitempty"`
+	// MaxResponseSize 非流式响应的最大大小（MB），为 0 时使用配置项 chat-max-response-size
+	MaxResponseSize int `json:"max_response_size,omitempty"`
+	// AllowedModels 渠道允许使用的模型（上游模型名称，即模型重写之后的名称），支持通配符（* 匹配任意字符，? 匹配单个字符），
+	// 为空时允许所有模型
+	AllowedModels []string `json:"allowed_models,omitempty"`
+	// UserAgent 请求服务提供商时使用的 User-Agent，为空时使用默认值（openai.DefaultUserAgent），
+	// 用于绕过部分服务提供商 WAF 对 User-Agent 的限制
+	UserAgent string `json:"user_agent,omitempty"`
+	// ExtraBody 合并到每个请求体中的额外参数，用于 OpenAI 兼容网关（LiteLLM、vLLM 等）支持的扩展参数（如 min_p、guided_json），
+	// 只对 OpenAI 兼容的服务提供商（openai/oneapi/openrouter）生效，不能覆盖请求体中已有的参数，不允许指定 model、messages、stream
+	ExtraBody map[string]any `json:"extra_body,omitempty"`
+	// FlattenMultipart 渠道不支持多模态的消息格式（content 为数组）时，将消息转换为纯文本发送：文本部分依次拼接，图片替换为图片地址，
+	// 渠道返回 invalid content type 错误时也会自动开启（只在当前实例的内存中记录）
+	FlattenMultipart bool `json:"flatten_multipart,omitempty"`
+	// SummarizeToolHistory 渠道不支持工具调用时（如 OpenAI 兼容的网关背后的模型不支持），将上下文中的工具调用和工具调用结果转换为纯文本发送，
+	// 渠道类型本身不支持工具调用时总是转换
+	SummarizeToolHistory bool `json:"summarize_tool_history,omitempty"`
+}
+
+// AllowsModel 渠道是否允许使用指定的模型（上游模型名称），AllowedModels 为空时允许所有模型
+func (meta ChannelMeta) AllowsModel(model string) bool {
+	return ModelAllowed(meta.AllowedModels, model)
+}
+
+// ModelAllowed 模型是否匹配允许列表中的任意一条通配符规则（* 匹配任意字符，? 匹配单个字符），允许列表为空时允许所有模型
+func ModelAllowed(patterns []string, model string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if matchModelPattern(strings.TrimSpace(pattern), model) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchModelPattern 模型名称是否匹配通配符规则（* 匹配任意字符，包括 /；? 匹配单个字符）
+func matchModelPattern(pattern, model string) bool {
+	p, m := []rune(pattern), []rune(model)
+	// star 为最近一个 * 在规则中的位置，mark 为该 * 匹配结束时模型名称中的位置
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(m) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == m[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+
+	return i == len(p)
 }
 
 func NewChannel(ch model.ChannelsN) Channel {
@@ -351,7 +461,16 @@ func (repo *ModelRepo) UpdateChannel(ctx context.Context, id int64, req ChannelU
 	ch.Name = null.StringFrom(req.Name)
 	ch.Type = null.StringFrom(req.Type)
 	ch.Server = null.StringFrom(req.Server)
-	ch.Secret = null.StringFrom(req.Secret)
+
+	// 客户端回传的是脱敏后的密钥时，密钥保持不变
+	if !secret.IsMasked(req.Secret) {
+		encrypted, err := repo.secrets.Encrypt(ctx, req.Secret)
+		if err != nil {
+			return err
+		}
+
+		ch.Secret = null.StringFrom(encrypted)
+	}
 
 	meta, _ := json.Marshal(req.Meta)
 	ch.MetaJson = null.StringFrom(string(meta))
@@ -371,15 +490,58 @@ type ChannelAddReq struct {
 func (repo *ModelRepo) AddChannel(ctx context.Context, req ChannelAddReq) (int64, error) {
 	meta, _ := json.Marshal(req.Meta)
 
+	encrypted, err := repo.secrets.Encrypt(ctx, req.Secret)
+	if err != nil {
+		return 0, err
+	}
+
 	return model.NewChannelsModel(repo.db).Create(ctx, query.KV{
 		model.FieldChannelsName:     req.Name,
 		model.FieldChannelsType:     req.Type,
 		model.FieldChannelsServer:   req.Server,
-		model.FieldChannelsSecret:   req.Secret,
+		model.FieldChannelsSecret:   encrypted,
 		model.FieldChannelsMetaJson: string(meta),
 	})
 }
 
+// EncryptChannelSecrets 加密所有明文存储的渠道密钥（原地更新），返回加密的渠道数量
+func (repo *ModelRepo) EncryptChannelSecrets(ctx context.Context) (int, error) {
+	if !repo.secrets.Enabled() {
+		return 0, secret.ErrMasterKeyNotConfigured
+	}
+
+	channels, err := model.NewChannelsModel(repo.db).Get(ctx, query.Builder())
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, ch := range channels {
+		if ch.Secret.ValueOrZero() == "" || secret.IsEncrypted(ch.Secret.ValueOrZero()) {
+			continue
+		}
+
+		encrypted, err := repo.secrets.Encrypt(ctx, ch.Secret.ValueOrZero())
+		if err != nil {
+			return count, err
+		}
+
+		// 只有密钥未被并发修改时才更新
+		updated, err := model.NewChannelsModel(repo.db).UpdateFields(
+			ctx,
+			query.KV{model.FieldChannelsSecret: encrypted},
+			query.Builder().Where(model.FieldChannelsId, ch.Id.ValueOrZero()).Where(model.FieldChannelsSecret, ch.Secret.ValueOrZero()),
+		)
+		if err != nil {
+			return count, fmt.Errorf("encrypt channel %d secret failed: %w", ch.Id.ValueOrZero(), err)
+		}
+
+		count += int(updated)
+	}
+
+	return count, nil
+}
+
 // DeleteChannel 删除渠道
 func (repo *ModelRepo) DeleteChannel(ctx context.Context, channelID int64) error {
 	models, err := repo.GetModels(ctx)
@@ -20,19 +20,26 @@ type ChatMessagesN struct {
 	original          *chatMessagesOriginal
 	chatMessagesModel *ChatMessagesModel
 
-	Id            null.Int    `json:"id"`
-	UserId        null.Int    `json:"user_id,omitempty"`
-	RoomId        null.Int    `json:"room_id,omitempty"`
-	Message       null.String `json:"message,omitempty"`
-	Role          null.Int    `json:"role,omitempty"`
-	TokenConsumed null.Int    `json:"token_consumed,omitempty"`
-	QuotaConsumed null.Int    `json:"quota_consumed,omitempty"`
-	Pid           null.Int    `json:"pid,omitempty"`
-	Model         null.String `json:"model,omitempty"`
-	Status        null.Int    `json:"status,omitempty"`
-	Error         null.String `json:"error,omitempty"`
-	CreatedAt     null.Time
-	UpdatedAt     null.Time
+	Id               null.Int    `json:"id"`
+	UserId           null.Int    `json:"user_id,omitempty"`
+	RoomId           null.Int    `json:"room_id,omitempty"`
+	Message          null.String `json:"message,omitempty"`
+	Role             null.Int    `json:"role,omitempty"`
+	TokenConsumed    null.Int    `json:"token_consumed,omitempty"`
+	QuotaConsumed    null.Int    `json:"quota_consumed,omitempty"`
+	Pid              null.Int    `json:"pid,omitempty"`
+	Model            null.String `json:"model,omitempty"`
+	Status           null.Int    `json:"status,omitempty"`
+	Error            null.String `json:"error,omitempty"`
+	SystemPromptHash null.String `json:"system_prompt_hash,omitempty"`
+	SystemPrompt     null.String `json:"system_prompt,omitempty"`
+	UpstreamModel    null.String `json:"upstream_model,omitempty"`
+	ChannelId        null.Int    `json:"channel_id,omitempty"`
+	Provider         null.String `json:"provider,omitempty"`
+	Parts            null.String `json:"parts,omitempty"`
+	Pinned           null.Int    `json:"pinned,omitempty"`
+	CreatedAt        null.Time
+	UpdatedAt        null.Time
 }
 
 // As convert object to other type
@@ -48,19 +55,26 @@ func (inst *ChatMessagesN) SetModel(chatMessagesModel *ChatMessagesModel) {
 
 // chatMessagesOriginal is an object which stores original ChatMessages from database
 type chatMessagesOriginal struct {
-	Id            null.Int
-	UserId        null.Int
-	RoomId        null.Int
-	Message       null.String
-	Role          null.Int
-	TokenConsumed null.Int
-	QuotaConsumed null.Int
-	Pid           null.Int
-	Model         null.String
-	Status        null.Int
-	Error         null.String
-	CreatedAt     null.Time
-	UpdatedAt     null.Time
+	Id               null.Int
+	UserId           null.Int
+	RoomId           null.Int
+	Message          null.String
+	Role             null.Int
+	TokenConsumed    null.Int
+	QuotaConsumed    null.Int
+	Pid              null.Int
+	Model            null.String
+	Status           null.Int
+	Error            null.String
+	SystemPromptHash null.String
+	SystemPrompt     null.String
+	UpstreamModel    null.String
+	ChannelId        null.Int
+	Provider         null.String
+	Parts            null.String
+	Pinned           null.Int
+	CreatedAt        null.Time
+	UpdatedAt        null.Time
 }
 
 // Staled identify whether the object has been modified
@@ -104,6 +118,27 @@ func (inst *ChatMessagesN) Staled(onlyFields ...string) bool {
 		if inst.Error != inst.original.Error {
 			return true
 		}
+		if inst.SystemPromptHash != inst.original.SystemPromptHash {
+			return true
+		}
+		if inst.SystemPrompt != inst.original.SystemPrompt {
+			return true
+		}
+		if inst.UpstreamModel != inst.original.UpstreamModel {
+			return true
+		}
+		if inst.ChannelId != inst.original.ChannelId {
+			return true
+		}
+		if inst.Provider != inst.original.Provider {
+			return true
+		}
+		if inst.Parts != inst.original.Parts {
+			return true
+		}
+		if inst.Pinned != inst.original.Pinned {
+			return true
+		}
 		if inst.CreatedAt != inst.original.CreatedAt {
 			return true
 		}
@@ -158,6 +193,34 @@ func (inst *ChatMessagesN) Staled(onlyFields ...string) bool {
 				if inst.Error != inst.original.Error {
 					return true
 				}
+			case "system_prompt_hash":
+				if inst.SystemPromptHash != inst.original.SystemPromptHash {
+					return true
+				}
+			case "system_prompt":
+				if inst.SystemPrompt != inst.original.SystemPrompt {
+					return true
+				}
+			case "upstream_model":
+				if inst.UpstreamModel != inst.original.UpstreamModel {
+					return true
+				}
+			case "channel_id":
+				if inst.ChannelId != inst.original.ChannelId {
+					return true
+				}
+			case "provider":
+				if inst.Provider != inst.original.Provider {
+					return true
+				}
+			case "parts":
+				if inst.Parts != inst.original.Parts {
+					return true
+				}
+			case "pinned":
+				if inst.Pinned != inst.original.Pinned {
+					return true
+				}
 			case "created_at":
 				if inst.CreatedAt != inst.original.CreatedAt {
 					return true
@@ -217,6 +280,27 @@ func (inst *ChatMessagesN) StaledKV(onlyFields ...string) query.KV {
 		if inst.Error != inst.original.Error {
 			kv["error"] = inst.Error
 		}
+		if inst.SystemPromptHash != inst.original.SystemPromptHash {
+			kv["system_prompt_hash"] = inst.SystemPromptHash
+		}
+		if inst.SystemPrompt != inst.original.SystemPrompt {
+			kv["system_prompt"] = inst.SystemPrompt
+		}
+		if inst.UpstreamModel != inst.original.UpstreamModel {
+			kv["upstream_model"] = inst.UpstreamModel
+		}
+		if inst.ChannelId != inst.original.ChannelId {
+			kv["channel_id"] = inst.ChannelId
+		}
+		if inst.Provider != inst.original.Provider {
+			kv["provider"] = inst.Provider
+		}
+		if inst.Parts != inst.original.Parts {
+			kv["parts"] = inst.Parts
+		}
+		if inst.Pinned != inst.original.Pinned {
+			kv["pinned"] = inst.Pinned
+		}
 		if inst.CreatedAt != inst.original.CreatedAt {
 			kv["created_at"] = inst.CreatedAt
 		}
@@ -271,6 +355,34 @@ func (inst *ChatMessagesN) StaledKV(onlyFields ...string) query.KV {
 				if inst.Error != inst.original.Error {
 					kv["error"] = inst.Error
 				}
+			case "system_prompt_hash":
+				if inst.SystemPromptHash != inst.original.SystemPromptHash {
+					kv["system_prompt_hash"] = inst.SystemPromptHash
+				}
+			case "system_prompt":
+				if inst.SystemPrompt != inst.original.SystemPrompt {
+					kv["system_prompt"] = inst.SystemPrompt
+				}
+			case "upstream_model":
+				if inst.UpstreamModel != inst.original.UpstreamModel {
+					kv["upstream_model"] = inst.UpstreamModel
+				}
+			case "channel_id":
+				if inst.ChannelId != inst.original.ChannelId {
+					kv["channel_id"] = inst.ChannelId
+				}
+			case "provider":
+				if inst.Provider != inst.original.Provider {
+					kv["provider"] = inst.Provider
+				}
+			case "parts":
+				if inst.Parts != inst.original.Parts {
+					kv["parts"] = inst.Parts
+				}
+			case "pinned":
+				if inst.Pinned != inst.original.Pinned {
+					kv["pinned"] = inst.Pinned
+				}
 			case "created_at":
 				if inst.CreatedAt != inst.original.CreatedAt {
 					kv["created_at"] = inst.CreatedAt
@@ -378,38 +490,52 @@ func (m *ChatMessagesModel) globalScopeEnabled(name string) bool {
 }
 
 type ChatMessages struct {
-	Id            int64  `json:"id"`
-	UserId        int64  `json:"user_id,omitempty"`
-	RoomId        int64  `json:"room_id,omitempty"`
-	Message       string `json:"message,omitempty"`
-	Role          int64  `json:"role,omitempty"`
-	TokenConsumed int64  `json:"token_consumed,omitempty"`
-	QuotaConsumed int64  `json:"quota_consumed,omitempty"`
-	Pid           int64  `json:"pid,omitempty"`
-	Model         string `json:"model,omitempty"`
-	Status        int64  `json:"status,omitempty"`
-	Error         string `json:"error,omitempty"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	Id               int64  `json:"id"`
+	UserId           int64  `json:"user_id,omitempty"`
+	RoomId           int64  `json:"room_id,omitempty"`
+	Message          string `json:"message,omitempty"`
+	Role             int64  `json:"role,omitempty"`
+	TokenConsumed    int64  `json:"token_consumed,omitempty"`
+	QuotaConsumed    int64  `json:"quota_consumed,omitempty"`
+	Pid              int64  `json:"pid,omitempty"`
+	Model            string `json:"model,omitempty"`
+	Status           int64  `json:"status,omitempty"`
+	Error            string `json:"error,omitempty"`
+	SystemPromptHash string `json:"system_prompt_hash,omitempty"`
+	SystemPrompt     string `json:"system_prompt,omitempty"`
+	UpstreamModel    string `json:"upstream_model,omitempty"`
+	ChannelId        int64  `json:"channel_id,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+	Parts            string `json:"parts,omitempty"`
+	Pinned           int64  `json:"pinned,omitempty"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
 }
 
 func (w ChatMessages) ToChatMessagesN(allows ...string) ChatMessagesN {
 	if len(allows) == 0 {
 		return ChatMessagesN{
 
-			Id:            null.IntFrom(int64(w.Id)),
-			UserId:        null.IntFrom(int64(w.UserId)),
-			RoomId:        null.IntFrom(int64(w.RoomId)),
-			Message:       null.StringFrom(w.Message),
-			Role:          null.IntFrom(int64(w.Role)),
-			TokenConsumed: null.IntFrom(int64(w.TokenConsumed)),
-			QuotaConsumed: null.IntFrom(int64(w.QuotaConsumed)),
-			Pid:           null.IntFrom(int64(w.Pid)),
-			Model:         null.StringFrom(w.Model),
-			Status:        null.IntFrom(int64(w.Status)),
-			Error:         null.StringFrom(w.Error),
-			CreatedAt:     null.TimeFrom(w.CreatedAt),
-			UpdatedAt:     null.TimeFrom(w.UpdatedAt),
+			Id:               null.IntFrom(int64(w.Id)),
+			UserId:           null.IntFrom(int64(w.UserId)),
+			RoomId:           null.IntFrom(int64(w.RoomId)),
+			Message:          null.StringFrom(w.Message),
+			Role:             null.IntFrom(int64(w.Role)),
+			TokenConsumed:    null.IntFrom(int64(w.TokenConsumed)),
+			QuotaConsumed:    null.IntFrom(int64(w.QuotaConsumed)),
+			Pid:              null.IntFrom(int64(w.Pid)),
+			Model:            null.StringFrom(w.Model),
+			Status:           null.IntFrom(int64(w.Status)),
+			Error:            null.StringFrom(w.Error),
+			SystemPromptHash: null.StringFrom(w.SystemPromptHash),
+			SystemPrompt:     null.StringFrom(w.SystemPrompt),
+			UpstreamModel:    null.StringFrom(w.UpstreamModel),
+			ChannelId:        null.IntFrom(int64(w.ChannelId)),
+			Provider:         null.StringFrom(w.Provider),
+			Parts:            null.StringFrom(w.Parts),
+			Pinned:           null.IntFrom(int64(w.Pinned)),
+			CreatedAt:        null.TimeFrom(w.CreatedAt),
+			UpdatedAt:        null.TimeFrom(w.UpdatedAt),
 		}
 	}
 
@@ -439,6 +565,20 @@ func (w ChatMessages) ToChatMessagesN(allows ...string) ChatMessagesN {
 			res.Status = null.IntFrom(int64(w.Status))
 		case "error":
 			res.Error = null.StringFrom(w.Error)
+		case "system_prompt_hash":
+			res.SystemPromptHash = null.StringFrom(w.SystemPromptHash)
+		case "system_prompt":
+			res.SystemPrompt = null.StringFrom(w.SystemPrompt)
+		case "upstream_model":
+			res.UpstreamModel = null.StringFrom(w.UpstreamModel)
+		case "channel_id":
+			res.ChannelId = null.IntFrom(int64(w.ChannelId))
+		case "provider":
+			res.Provider = null.StringFrom(w.Provider)
+		case "parts":
+			res.Parts = null.StringFrom(w.Parts)
+		case "pinned":
+			res.Pinned = null.IntFrom(int64(w.Pinned))
 		case "created_at":
 			res.CreatedAt = null.TimeFrom(w.CreatedAt)
 		case "updated_at":
@@ -459,19 +599,26 @@ func (w ChatMessages) As(dst interface{}) error {
 func (w *ChatMessagesN) ToChatMessages() ChatMessages {
 	return ChatMessages{
 
-		Id:            w.Id.Int64,
-		UserId:        w.UserId.Int64,
-		RoomId:        w.RoomId.Int64,
-		Message:       w.Message.String,
-		Role:          w.Role.Int64,
-		TokenConsumed: w.TokenConsumed.Int64,
-		QuotaConsumed: w.QuotaConsumed.Int64,
-		Pid:           w.Pid.Int64,
-		Model:         w.Model.String,
-		Status:        w.Status.Int64,
-		Error:         w.Error.String,
-		CreatedAt:     w.CreatedAt.Time,
-		UpdatedAt:     w.UpdatedAt.Time,
+		Id:               w.Id.Int64,
+		UserId:           w.UserId.Int64,
+		RoomId:           w.RoomId.Int64,
+		Message:          w.Message.String,
+		Role:             w.Role.Int64,
+		TokenConsumed:    w.TokenConsumed.Int64,
+		QuotaConsumed:    w.QuotaConsumed.Int64,
+		Pid:              w.Pid.Int64,
+		Model:            w.Model.String,
+		Status:           w.Status.Int64,
+		Error:            w.Error.String,
+		SystemPromptHash: w.SystemPromptHash.String,
+		SystemPrompt:     w.SystemPrompt.String,
+		UpstreamModel:    w.UpstreamModel.String,
+		ChannelId:        w.ChannelId.Int64,
+		Provider:         w.Provider.String,
+		Parts:            w.Parts.String,
+		Pinned:           w.Pinned.Int64,
+		CreatedAt:        w.CreatedAt.Time,
+		UpdatedAt:        w.UpdatedAt.Time,
 	}
 }
 
@@ -494,19 +641,26 @@ func ChatMessagesTable() string {
 }
 
 const (
-	FieldChatMessagesId            = "id"
-	FieldChatMessagesUserId        = "user_id"
-	FieldChatMessagesRoomId        = "room_id"
-	FieldChatMessagesMessage       = "message"
-	FieldChatMessagesRole          = "role"
-	FieldChatMessagesTokenConsumed = "token_consumed"
-	FieldChatMessagesQuotaConsumed = "quota_consumed"
-	FieldChatMessagesPid           = "pid"
-	FieldChatMessagesModel         = "model"
-	FieldChatMessagesStatus        = "status"
-	FieldChatMessagesError         = "error"
-	FieldChatMessagesCreatedAt     = "created_at"
-	FieldChatMessagesUpdatedAt     = "updated_at"
+	FieldChatMessagesId               = "id"
+	FieldChatMessagesUserId           = "user_id"
+	FieldChatMessagesRoomId           = "room_id"
+	FieldChatMessagesMessage          = "message"
+	FieldChatMessagesRole             = "role"
+	FieldChatMessagesTokenConsumed    = "token_consumed"
+	FieldChatMessagesQuotaConsumed    = "quota_consumed"
+	FieldChatMessagesPid              = "pid"
+	FieldChatMessagesModel            = "model"
+	FieldChatMessagesStatus           = "status"
+	FieldChatMessagesError            = "error"
+	FieldChatMessagesSystemPromptHash = "system_prompt_hash"
+	FieldChatMessagesSystemPrompt     = "system_prompt"
+	FieldChatMessagesUpstreamModel    = "upstream_model"
+	FieldChatMessagesChannelId        = "channel_id"
+	FieldChatMessagesProvider         = "provider"
+	FieldChatMessagesParts            = "parts"
+	FieldChatMessagesPinned           = "pinned"
+	FieldChatMessagesCreatedAt        = "created_at"
+	FieldChatMessagesUpdatedAt        = "updated_at"
 )
 
 // ChatMessagesFields return all fields in ChatMessages model
@@ -523,6 +677,13 @@ func ChatMessagesFields() []string {
 		"model",
 		"status",
 		"error",
+		"system_prompt_hash",
+		"system_prompt",
+		"upstream_model",
+		"channel_id",
+		"provider",
+		"parts",
+		"pinned",
 		"created_at",
 		"updated_at",
 	}
@@ -666,6 +827,13 @@ func (m *ChatMessagesModel) Get(ctx context.Context, builders ...query.SQLBuilde
 			"model",
 			"status",
 			"error",
+			"system_prompt_hash",
+			"system_prompt",
+			"upstream_model",
+			"channel_id",
+			"provider",
+			"parts",
+			"pinned",
 			"created_at",
 			"updated_at",
 		)
@@ -699,6 +867,20 @@ func (m *ChatMessagesModel) Get(ctx context.Context, builders ...query.SQLBuilde
 			selectFields = append(selectFields, f)
 		case "error":
 			selectFields = append(selectFields, f)
+		case "system_prompt_hash":
+			selectFields = append(selectFields, f)
+		case "system_prompt":
+			selectFields = append(selectFields, f)
+		case "upstream_model":
+			selectFields = append(selectFields, f)
+		case "channel_id":
+			selectFields = append(selectFields, f)
+		case "provider":
+			selectFields = append(selectFields, f)
+		case "parts":
+			selectFields = append(selectFields, f)
+		case "pinned":
+			selectFields = append(selectFields, f)
 		case "created_at":
 			selectFields = append(selectFields, f)
 		case "updated_at":
@@ -735,6 +917,20 @@ func (m *ChatMessagesModel) Get(ctx context.Context, builders ...query.SQLBuilde
 				scanFields = append(scanFields, &chatMessagesVar.Status)
 			case "error":
 				scanFields = append(scanFields, &chatMessagesVar.Error)
+			case "system_prompt_hash":
+				scanFields = append(scanFields, &chatMessagesVar.SystemPromptHash)
+			case "system_prompt":
+				scanFields = append(scanFields, &chatMessagesVar.SystemPrompt)
+			case "upstream_model":
+				scanFields = append(scanFields, &chatMessagesVar.UpstreamModel)
+			case "channel_id":
+				scanFields = append(scanFields, &chatMessagesVar.ChannelId)
+			case "provider":
+				scanFields = append(scanFields, &chatMessagesVar.Provider)
+			case "parts":
+				scanFields = append(scanFields, &chatMessagesVar.Parts)
+			case "pinned":
+				scanFields = append(scanFields, &chatMessagesVar.Pinned)
 			case "created_at":
 				scanFields = append(scanFields, &chatMessagesVar.CreatedAt)
 			case "updated_at":
@@ -20,22 +20,30 @@ type RoomsN struct {
 	original   *roomsOriginal
 	roomsModel *RoomsModel
 
-	Id             null.Int    `json:"id"`
-	UserId         null.Int    `json:"user_id"`
-	AvatarId       null.Int    `json:"avatar_id,omitempty"`
-	AvatarUrl      null.String `json:"avatar_url,omitempty"`
-	Name           null.String `json:"name,omitempty"`
-	Description    null.String `json:"description,omitempty"`
-	Priority       null.Int    `json:"priority,omitempty"`
-	Model          null.String `json:"model,omitempty"`
-	Vendor         null.String `json:"vendor,omitempty"`
-	SystemPrompt   null.String `json:"system_prompt,omitempty"`
-	MaxContext     null.Int    `json:"max_context,omitempty"`
-	RoomType       null.Int    `json:"room_type,omitempty"`
-	InitMessage    null.String `json:"init_message,omitempty"`
-	LastActiveTime null.Time   `json:"last_active_time,omitempty"`
-	CreatedAt      null.Time
-	UpdatedAt      null.Time
+	Id                  null.Int    `json:"id"`
+	UserId              null.Int    `json:"user_id"`
+	AvatarId            null.Int    `json:"avatar_id,omitempty"`
+	AvatarUrl           null.String `json:"avatar_url,omitempty"`
+	Name                null.String `json:"name,omitempty"`
+	Description         null.String `json:"description,omitempty"`
+	Priority            null.Int    `json:"priority,omitempty"`
+	Model               null.String `json:"model,omitempty"`
+	Vendor              null.String `json:"vendor,omitempty"`
+	SystemPrompt        null.String `json:"system_prompt,omitempty"`
+	MaxContext          null.Int    `json:"max_context,omitempty"`
+	RoomType            null.Int    `json:"room_type,omitempty"`
+	InitMessage         null.String `json:"init_message,omitempty"`
+	MergeUserMessages   null.Int    `json:"merge_user_messages,omitempty"`
+	ChatDefaults        null.String `json:"chat_defaults,omitempty"`
+	MaxImages           null.Int    `json:"max_images,omitempty"`
+	MaxOutputTokens     null.Int    `json:"max_output_tokens,omitempty"`
+	Private             null.Int    `json:"private,omitempty"`
+	DigestSchedule      null.String `json:"digest_schedule,omitempty"`
+	LastDigestMessageId null.Int    `json:"-"`
+	LastDigestAt        null.Time   `json:"-"`
+	LastActiveTime      null.Time   `json:"last_active_time,omitempty"`
+	CreatedAt           null.Time
+	UpdatedAt           null.Time
 }
 
 // As convert object to other type
@@ -51,22 +59,30 @@ func (inst *RoomsN) SetModel(roomsModel *RoomsModel) {
 
 // roomsOriginal is an object which stores original Rooms from database
 type roomsOriginal struct {
-	Id             null.Int
-	UserId         null.Int
-	AvatarId       null.Int
-	AvatarUrl      null.String
-	Name           null.String
-	Description    null.String
-	Priority       null.Int
-	Model          null.String
-	Vendor         null.String
-	SystemPrompt   null.String
-	MaxContext     null.Int
-	RoomType       null.Int
-	InitMessage    null.String
-	LastActiveTime null.Time
-	CreatedAt      null.Time
-	UpdatedAt      null.Time
+	Id                  null.Int
+	UserId              null.Int
+	AvatarId            null.Int
+	AvatarUrl           null.String
+	Name                null.String
+	Description         null.String
+	Priority            null.Int
+	Model               null.String
+	Vendor              null.String
+	SystemPrompt        null.String
+	MaxContext          null.Int
+	RoomType            null.Int
+	InitMessage         null.String
+	MergeUserMessages   null.Int
+	ChatDefaults        null.String
+	MaxImages           null.Int
+	MaxOutputTokens     null.Int
+	Private             null.Int
+	DigestSchedule      null.String
+	LastDigestMessageId null.Int
+	LastDigestAt        null.Time
+	LastActiveTime      null.Time
+	CreatedAt           null.Time
+	UpdatedAt           null.Time
 }
 
 // Staled identify whether the object has been modified
@@ -116,6 +132,30 @@ func (inst *RoomsN) Staled(onlyFields ...string) bool {
 		if inst.InitMessage != inst.original.InitMessage {
 			return true
 		}
+		if inst.MergeUserMessages != inst.original.MergeUserMessages {
+			return true
+		}
+		if inst.ChatDefaults != inst.original.ChatDefaults {
+			return true
+		}
+		if inst.MaxImages != inst.original.MaxImages {
+			return true
+		}
+		if inst.MaxOutputTokens != inst.original.MaxOutputTokens {
+			return true
+		}
+		if inst.Private != inst.original.Private {
+			return true
+		}
+		if inst.DigestSchedule != inst.original.DigestSchedule {
+			return true
+		}
+		if inst.LastDigestMessageId != inst.original.LastDigestMessageId {
+			return true
+		}
+		if inst.LastDigestAt != inst.original.LastDigestAt {
+			return true
+		}
 		if inst.LastActiveTime != inst.original.LastActiveTime {
 			return true
 		}
@@ -181,6 +221,38 @@ func (inst *RoomsN) Staled(onlyFields ...string) bool {
 				if inst.InitMessage != inst.original.InitMessage {
 					return true
 				}
+			case "merge_user_messages":
+				if inst.MergeUserMessages != inst.original.MergeUserMessages {
+					return true
+				}
+			case "chat_defaults":
+				if inst.ChatDefaults != inst.original.ChatDefaults {
+					return true
+				}
+			case "max_images":
+				if inst.MaxImages != inst.original.MaxImages {
+					return true
+				}
+			case "max_output_tokens":
+				if inst.MaxOutputTokens != inst.original.MaxOutputTokens {
+					return true
+				}
+			case "private":
+				if inst.Private != inst.original.Private {
+					return true
+				}
+			case "digest_schedule":
+				if inst.DigestSchedule != inst.original.DigestSchedule {
+					return true
+				}
+			case "last_digest_message_id":
+				if inst.LastDigestMessageId != inst.original.LastDigestMessageId {
+					return true
+				}
+			case "last_digest_at":
+				if inst.LastDigestAt != inst.original.LastDigestAt {
+					return true
+				}
 			case "last_active_time":
 				if inst.LastActiveTime != inst.original.LastActiveTime {
 					return true
@@ -250,6 +322,30 @@ func (inst *RoomsN) StaledKV(onlyFields ...string) query.KV {
 		if inst.InitMessage != inst.original.InitMessage {
 			kv["init_message"] = inst.InitMessage
 		}
+		if inst.MergeUserMessages != inst.original.MergeUserMessages {
+			kv["merge_user_messages"] = inst.MergeUserMessages
+		}
+		if inst.ChatDefaults != inst.original.ChatDefaults {
+			kv["chat_defaults"] = inst.ChatDefaults
+		}
+		if inst.MaxImages != inst.original.MaxImages {
+			kv["max_images"] = inst.MaxImages
+		}
+		if inst.MaxOutputTokens != inst.original.MaxOutputTokens {
+			kv["max_output_tokens"] = inst.MaxOutputTokens
+		}
+		if inst.Private != inst.original.Private {
+			kv["private"] = inst.Private
+		}
+		if inst.DigestSchedule != inst.original.DigestSchedule {
+			kv["digest_schedule"] = inst.DigestSchedule
+		}
+		if inst.LastDigestMessageId != inst.original.LastDigestMessageId {
+			kv["last_digest_message_id"] = inst.LastDigestMessageId
+		}
+		if inst.LastDigestAt != inst.original.LastDigestAt {
+			kv["last_digest_at"] = inst.LastDigestAt
+		}
 		if inst.LastActiveTime != inst.original.LastActiveTime {
 			kv["last_active_time"] = inst.LastActiveTime
 		}
@@ -315,6 +411,38 @@ func (inst *RoomsN) StaledKV(onlyFields ...string) query.KV {
 				if inst.InitMessage != inst.original.InitMessage {
 					kv["init_message"] = inst.InitMessage
 				}
+			case "merge_user_messages":
+				if inst.MergeUserMessages != inst.original.MergeUserMessages {
+					kv["merge_user_messages"] = inst.MergeUserMessages
+				}
+			case "chat_defaults":
+				if inst.ChatDefaults != inst.original.ChatDefaults {
+					kv["chat_defaults"] = inst.ChatDefaults
+				}
+			case "max_images":
+				if inst.MaxImages != inst.original.MaxImages {
+					kv["max_images"] = inst.MaxImages
+				}
+			case "max_output_tokens":
+				if inst.MaxOutputTokens != inst.original.MaxOutputTokens {
+					kv["max_output_tokens"] = inst.MaxOutputTokens
+				}
+			case "private":
+				if inst.Private != inst.original.Private {
+					kv["private"] = inst.Private
+				}
+			case "digest_schedule":
+				if inst.DigestSchedule != inst.original.DigestSchedule {
+					kv["digest_schedule"] = inst.DigestSchedule
+				}
+			case "last_digest_message_id":
+				if inst.LastDigestMessageId != inst.original.LastDigestMessageId {
+					kv["last_digest_message_id"] = inst.LastDigestMessageId
+				}
+			case "last_digest_at":
+				if inst.LastDigestAt != inst.original.LastDigestAt {
+					kv["last_digest_at"] = inst.LastDigestAt
+				}
 			case "last_active_time":
 				if inst.LastActiveTime != inst.original.LastActiveTime {
 					kv["last_active_time"] = inst.LastActiveTime
@@ -426,44 +554,60 @@ func (m *RoomsModel) globalScopeEnabled(name string) bool {
 }
 
 type Rooms struct {
-	Id             int64     `json:"id"`
-	UserId         int64     `json:"user_id"`
-	AvatarId       int64     `json:"avatar_id,omitempty"`
-	AvatarUrl      string    `json:"avatar_url,omitempty"`
-	Name           string    `json:"name,omitempty"`
-	Description    string    `json:"description,omitempty"`
-	Priority       int64     `json:"priority,omitempty"`
-	Model          string    `json:"model,omitempty"`
-	Vendor         string    `json:"vendor,omitempty"`
-	SystemPrompt   string    `json:"system_prompt,omitempty"`
-	MaxContext     int64     `json:"max_context,omitempty"`
-	RoomType       int64     `json:"room_type,omitempty"`
-	InitMessage    string    `json:"init_message,omitempty"`
-	LastActiveTime time.Time `json:"last_active_time,omitempty"`
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
+	Id                  int64     `json:"id"`
+	UserId              int64     `json:"user_id"`
+	AvatarId            int64     `json:"avatar_id,omitempty"`
+	AvatarUrl           string    `json:"avatar_url,omitempty"`
+	Name                string    `json:"name,omitempty"`
+	Description         string    `json:"description,omitempty"`
+	Priority            int64     `json:"priority,omitempty"`
+	Model               string    `json:"model,omitempty"`
+	Vendor              string    `json:"vendor,omitempty"`
+	SystemPrompt        string    `json:"system_prompt,omitempty"`
+	MaxContext          int64     `json:"max_context,omitempty"`
+	RoomType            int64     `json:"room_type,omitempty"`
+	InitMessage         string    `json:"init_message,omitempty"`
+	MergeUserMessages   int64     `json:"merge_user_messages,omitempty"`
+	ChatDefaults        string    `json:"chat_defaults,omitempty"`
+	MaxImages           int64     `json:"max_images,omitempty"`
+	MaxOutputTokens     int64     `json:"max_output_tokens,omitempty"`
+	Private             int64     `json:"private,omitempty"`
+	DigestSchedule      string    `json:"digest_schedule,omitempty"`
+	LastDigestMessageId int64     `json:"-"`
+	LastDigestAt        time.Time `json:"-"`
+	LastActiveTime      time.Time `json:"last_active_time,omitempty"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
 }
 
 func (w Rooms) ToRoomsN(allows ...string) RoomsN {
 	if len(allows) == 0 {
 		return RoomsN{
 
-			Id:             null.IntFrom(int64(w.Id)),
-			UserId:         null.IntFrom(int64(w.UserId)),
-			AvatarId:       null.IntFrom(int64(w.AvatarId)),
-			AvatarUrl:      null.StringFrom(w.AvatarUrl),
-			Name:           null.StringFrom(w.Name),
-			Description:    null.StringFrom(w.Description),
-			Priority:       null.IntFrom(int64(w.Priority)),
-			Model:          null.StringFrom(w.Model),
-			Vendor:         null.StringFrom(w.Vendor),
-			SystemPrompt:   null.StringFrom(w.SystemPrompt),
-			MaxContext:     null.IntFrom(int64(w.MaxContext)),
-			RoomType:       null.IntFrom(int64(w.RoomType)),
-			InitMessage:    null.StringFrom(w.InitMessage),
-			LastActiveTime: null.TimeFrom(w.LastActiveTime),
-			CreatedAt:      null.TimeFrom(w.CreatedAt),
-			UpdatedAt:      null.TimeFrom(w.UpdatedAt),
+			Id:                  null.IntFrom(int64(w.Id)),
+			UserId:              null.IntFrom(int64(w.UserId)),
+			AvatarId:            null.IntFrom(int64(w.AvatarId)),
+			AvatarUrl:           null.StringFrom(w.AvatarUrl),
+			Name:                null.StringFrom(w.Name),
+			Description:         null.StringFrom(w.Description),
+			Priority:            null.IntFrom(int64(w.Priority)),
+			Model:               null.StringFrom(w.Model),
+			Vendor:              null.StringFrom(w.Vendor),
+			SystemPrompt:        null.StringFrom(w.SystemPrompt),
+			MaxContext:          null.IntFrom(int64(w.MaxContext)),
+			RoomType:            null.IntFrom(int64(w.RoomType)),
+			InitMessage:         null.StringFrom(w.InitMessage),
+			MergeUserMessages:   null.IntFrom(int64(w.MergeUserMessages)),
+			ChatDefaults:        null.StringFrom(w.ChatDefaults),
+			MaxImages:           null.IntFrom(int64(w.MaxImages)),
+			MaxOutputTokens:     null.IntFrom(int64(w.MaxOutputTokens)),
+			Private:             null.IntFrom(int64(w.Private)),
+			DigestSchedule:      null.StringFrom(w.DigestSchedule),
+			LastDigestMessageId: null.IntFrom(int64(w.LastDigestMessageId)),
+			LastDigestAt:        null.TimeFrom(w.LastDigestAt),
+			LastActiveTime:      null.TimeFrom(w.LastActiveTime),
+			CreatedAt:           null.TimeFrom(w.CreatedAt),
+			UpdatedAt:           null.TimeFrom(w.UpdatedAt),
 		}
 	}
 
@@ -497,6 +641,22 @@ func (w Rooms) ToRoomsN(allows ...string) RoomsN {
 			res.RoomType = null.IntFrom(int64(w.RoomType))
 		case "init_message":
 			res.InitMessage = null.StringFrom(w.InitMessage)
+		case "merge_user_messages":
+			res.MergeUserMessages = null.IntFrom(int64(w.MergeUserMessages))
+		case "chat_defaults":
+			res.ChatDefaults = null.StringFrom(w.ChatDefaults)
+		case "max_images":
+			res.MaxImages = null.IntFrom(int64(w.MaxImages))
+		case "max_output_tokens":
+			res.MaxOutputTokens = null.IntFrom(int64(w.MaxOutputTokens))
+		case "private":
+			res.Private = null.IntFrom(int64(w.Private))
+		case "digest_schedule":
+			res.DigestSchedule = null.StringFrom(w.DigestSchedule)
+		case "last_digest_message_id":
+			res.LastDigestMessageId = null.IntFrom(int64(w.LastDigestMessageId))
+		case "last_digest_at":
+			res.LastDigestAt = null.TimeFrom(w.LastDigestAt)
 		case "last_active_time":
 			res.LastActiveTime = null.TimeFrom(w.LastActiveTime)
 		case "created_at":
@@ -519,22 +679,30 @@ func (w Rooms) As(dst interface{}) error {
 func (w *RoomsN) ToRooms() Rooms {
 	return Rooms{
 
-		Id:             w.Id.Int64,
-		UserId:         w.UserId.Int64,
-		AvatarId:       w.AvatarId.Int64,
-		AvatarUrl:      w.AvatarUrl.String,
-		Name:           w.Name.String,
-		Description:    w.Description.String,
-		Priority:       w.Priority.Int64,
-		Model:          w.Model.String,
-		Vendor:         w.Vendor.String,
-		SystemPrompt:   w.SystemPrompt.String,
-		MaxContext:     w.MaxContext.Int64,
-		RoomType:       w.RoomType.Int64,
-		InitMessage:    w.InitMessage.String,
-		LastActiveTime: w.LastActiveTime.Time,
-		CreatedAt:      w.CreatedAt.Time,
-		UpdatedAt:      w.UpdatedAt.Time,
+		Id:                  w.Id.Int64,
+		UserId:              w.UserId.Int64,
+		AvatarId:            w.AvatarId.Int64,
+		AvatarUrl:           w.AvatarUrl.String,
+		Name:                w.Name.String,
+		Description:         w.Description.String,
+		Priority:            w.Priority.Int64,
+		Model:               w.Model.String,
+		Vendor:              w.Vendor.String,
+		SystemPrompt:        w.SystemPrompt.String,
+		MaxContext:          w.MaxContext.Int64,
+		RoomType:            w.RoomType.Int64,
+		InitMessage:         w.InitMessage.String,
+		MergeUserMessages:   w.MergeUserMessages.Int64,
+		ChatDefaults:        w.ChatDefaults.String,
+		MaxImages:           w.MaxImages.Int64,
+		MaxOutputTokens:     w.MaxOutputTokens.Int64,
+		Private:             w.Private.Int64,
+		DigestSchedule:      w.DigestSchedule.String,
+		LastDigestMessageId: w.LastDigestMessageId.Int64,
+		LastDigestAt:        w.LastDigestAt.Time,
+		LastActiveTime:      w.LastActiveTime.Time,
+		CreatedAt:           w.CreatedAt.Time,
+		UpdatedAt:           w.UpdatedAt.Time,
 	}
 }
 
@@ -557,22 +725,30 @@ func RoomsTable() string {
 }
 
 const (
-	FieldRoomsId             = "id"
-	FieldRoomsUserId         = "user_id"
-	FieldRoomsAvatarId       = "avatar_id"
-	FieldRoomsAvatarUrl      = "avatar_url"
-	FieldRoomsName           = "name"
-	FieldRoomsDescription    = "description"
-	FieldRoomsPriority       = "priority"
-	FieldRoomsModel          = "model"
-	FieldRoomsVendor         = "vendor"
-	FieldRoomsSystemPrompt   = "system_prompt"
-	FieldRoomsMaxContext     = "max_context"
-	FieldRoomsRoomType       = "room_type"
-	FieldRoomsInitMessage    = "init_message"
-	FieldRoomsLastActiveTime = "last_active_time"
-	FieldRoomsCreatedAt      = "created_at"
-	FieldRoomsUpdatedAt      = "updated_at"
+	FieldRoomsId                  = "id"
+	FieldRoomsUserId              = "user_id"
+	FieldRoomsAvatarId            = "avatar_id"
+	FieldRoomsAvatarUrl           = "avatar_url"
+	FieldRoomsName                = "name"
+	FieldRoomsDescription         = "description"
+	FieldRoomsPriority            = "priority"
+	FieldRoomsModel               = "model"
+	FieldRoomsVendor              = "vendor"
+	FieldRoomsSystemPrompt        = "system_prompt"
+	FieldRoomsMaxContext          = "max_context"
+	FieldRoomsRoomType            = "room_type"
+	FieldRoomsInitMessage         = "init_message"
+	FieldRoomsMergeUserMessages   = "merge_user_messages"
+	FieldRoomsChatDefaults        = "chat_defaults"
+	FieldRoomsMaxImages           = "max_images"
+	FieldRoomsMaxOutputTokens     = "max_output_tokens"
+	FieldRoomsPrivate             = "private"
+	FieldRoomsDigestSchedule      = "digest_schedule"
+	FieldRoomsLastDigestMessageId = "last_digest_message_id"
+	FieldRoomsLastDigestAt        = "last_digest_at"
+	FieldRoomsLastActiveTime      = "last_active_time"
+	FieldRoomsCreatedAt           = "created_at"
+	FieldRoomsUpdatedAt           = "updated_at"
 )
 
 // RoomsFields return all fields in Rooms model
@@ -591,6 +767,14 @@ func RoomsFields() []string {
 		"max_context",
 		"room_type",
 		"init_message",
+		"merge_user_messages",
+		"chat_defaults",
+		"max_images",
+		"max_output_tokens",
+		"private",
+		"digest_schedule",
+		"last_digest_message_id",
+		"last_digest_at",
 		"last_active_time",
 		"created_at",
 		"updated_at",
@@ -737,6 +921,14 @@ func (m *RoomsModel) Get(ctx context.Context, builders ...query.SQLBuilder) ([]R
 			"max_context",
 			"room_type",
 			"init_message",
+			"merge_user_messages",
+			"chat_defaults",
+			"max_images",
+			"max_output_tokens",
+			"private",
+			"digest_schedule",
+			"last_digest_message_id",
+			"last_digest_at",
 			"last_active_time",
 			"created_at",
 			"updated_at",
@@ -775,6 +967,22 @@ func (m *RoomsModel) Get(ctx context.Context, builders ...query.SQLBuilder) ([]R
 			selectFields = append(selectFields, f)
 		case "init_message":
 			selectFields = append(selectFields, f)
+		case "merge_user_messages":
+			selectFields = append(selectFields, f)
+		case "chat_defaults":
+			selectFields = append(selectFields, f)
+		case "max_images":
+			selectFields = append(selectFields, f)
+		case "max_output_tokens":
+			selectFields = append(selectFields, f)
+		case "private":
+			selectFields = append(selectFields, f)
+		case "digest_schedule":
+			selectFields = append(selectFields, f)
+		case "last_digest_message_id":
+			selectFields = append(selectFields, f)
+		case "last_digest_at":
+			selectFields = append(selectFields, f)
 		case "last_active_time":
 			selectFields = append(selectFields, f)
 		case "created_at":
@@ -817,6 +1025,22 @@ func (m *RoomsModel) Get(ctx context.Context, builders ...query.SQLBuilder) ([]R
 				scanFields = append(scanFields, &roomsVar.RoomType)
 			case "init_message":
 				scanFields = append(scanFields, &roomsVar.InitMessage)
+			case "merge_user_messages":
+				scanFields = append(scanFields, &roomsVar.MergeUserMessages)
+			case "chat_defaults":
+				scanFields = append(scanFields, &roomsVar.ChatDefaults)
+			case "max_images":
+				scanFields = append(scanFields, &roomsVar.MaxImages)
+			case "max_output_tokens":
+				scanFields = append(scanFields, &roomsVar.MaxOutputTokens)
+			case "private":
+				scanFields = append(scanFields, &roomsVar.Private)
+			case "digest_schedule":
+				scanFields = append(scanFields, &roomsVar.DigestSchedule)
+			case "last_digest_message_id":
+				scanFields = append(scanFields, &roomsVar.LastDigestMessageId)
+			case "last_digest_at":
+				scanFields = append(scanFields, &roomsVar.LastDigestAt)
 			case "last_active_time":
 				scanFields = append(scanFields, &roomsVar.LastActiveTime)
 			case "created_at":
@@ -20,14 +20,20 @@ type UserApiKeyN struct {
 	original        *userApiKeyOriginal
 	userApiKeyModel *UserApiKeyModel
 
-	Id          null.Int    `json:"id"`
-	UserId      null.Int    `json:"user_id"`
-	Name        null.String `json:"name"`
-	Token       null.String `json:"token"`
-	Status      null.Int    `json:"status"`
-	ValidBefore null.Time   `json:"valid_before"`
-	CreatedAt   null.Time
-	UpdatedAt   null.Time
+	Id            null.Int    `json:"id"`
+	UserId        null.Int    `json:"user_id"`
+	Name          null.String `json:"name"`
+	Token         null.String `json:"token"`
+	Status        null.Int    `json:"status"`
+	ValidBefore   null.Time   `json:"valid_before"`
+	Hashed        null.Int    `json:"hashed"`
+	KeyPrefix     null.String `json:"key_prefix"`
+	AllowedModels null.String `json:"allowed_models"`
+	Rpm           null.Int    `json:"rpm"`
+	Tpm           null.Int    `json:"tpm"`
+	LastUsedAt    null.Time   `json:"last_used_at"`
+	CreatedAt     null.Time
+	UpdatedAt     null.Time
 }
 
 // As convert object to other type
@@ -43,14 +49,20 @@ func (inst *UserApiKeyN) SetModel(userApiKeyModel *UserApiKeyModel) {
 
 // userApiKeyOriginal is an object which stores original UserApiKey from database
 type userApiKeyOriginal struct {
-	Id          null.Int
-	UserId      null.Int
-	Name        null.String
-	Token       null.String
-	Status      null.Int
-	ValidBefore null.Time
-	CreatedAt   null.Time
-	UpdatedAt   null.Time
+	Id            null.Int
+	UserId        null.Int
+	Name          null.String
+	Token         null.String
+	Status        null.Int
+	ValidBefore   null.Time
+	Hashed        null.Int
+	KeyPrefix     null.String
+	AllowedModels null.String
+	Rpm           null.Int
+	Tpm           null.Int
+	LastUsedAt    null.Time
+	CreatedAt     null.Time
+	UpdatedAt     null.Time
 }
 
 // Staled identify whether the object has been modified
@@ -79,6 +91,24 @@ func (inst *UserApiKeyN) Staled(onlyFields ...string) bool {
 		if inst.ValidBefore != inst.original.ValidBefore {
 			return true
 		}
+		if inst.Hashed != inst.original.Hashed {
+			return true
+		}
+		if inst.KeyPrefix != inst.original.KeyPrefix {
+			return true
+		}
+		if inst.AllowedModels != inst.original.AllowedModels {
+			return true
+		}
+		if inst.Rpm != inst.original.Rpm {
+			return true
+		}
+		if inst.Tpm != inst.original.Tpm {
+			return true
+		}
+		if inst.LastUsedAt != inst.original.LastUsedAt {
+			return true
+		}
 		if inst.CreatedAt != inst.original.CreatedAt {
 			return true
 		}
@@ -113,6 +143,30 @@ func (inst *UserApiKeyN) Staled(onlyFields ...string) bool {
 				if inst.ValidBefore != inst.original.ValidBefore {
 					return true
 				}
+			case "hashed":
+				if inst.Hashed != inst.original.Hashed {
+					return true
+				}
+			case "key_prefix":
+				if inst.KeyPrefix != inst.original.KeyPrefix {
+					return true
+				}
+			case "allowed_models":
+				if inst.AllowedModels != inst.original.AllowedModels {
+					return true
+				}
+			case "rpm":
+				if inst.Rpm != inst.original.Rpm {
+					return true
+				}
+			case "tpm":
+				if inst.Tpm != inst.original.Tpm {
+					return true
+				}
+			case "last_used_at":
+				if inst.LastUsedAt != inst.original.LastUsedAt {
+					return true
+				}
 			case "created_at":
 				if inst.CreatedAt != inst.original.CreatedAt {
 					return true
@@ -157,6 +211,24 @@ func (inst *UserApiKeyN) StaledKV(onlyFields ...string) query.KV {
 		if inst.ValidBefore != inst.original.ValidBefore {
 			kv["valid_before"] = inst.ValidBefore
 		}
+		if inst.Hashed != inst.original.Hashed {
+			kv["hashed"] = inst.Hashed
+		}
+		if inst.KeyPrefix != inst.original.KeyPrefix {
+			kv["key_prefix"] = inst.KeyPrefix
+		}
+		if inst.AllowedModels != inst.original.AllowedModels {
+			kv["allowed_models"] = inst.AllowedModels
+		}
+		if inst.Rpm != inst.original.Rpm {
+			kv["rpm"] = inst.Rpm
+		}
+		if inst.Tpm != inst.original.Tpm {
+			kv["tpm"] = inst.Tpm
+		}
+		if inst.LastUsedAt != inst.original.LastUsedAt {
+			kv["last_used_at"] = inst.LastUsedAt
+		}
 		if inst.CreatedAt != inst.original.CreatedAt {
 			kv["created_at"] = inst.CreatedAt
 		}
@@ -191,6 +263,30 @@ func (inst *UserApiKeyN) StaledKV(onlyFields ...string) query.KV {
 				if inst.ValidBefore != inst.original.ValidBefore {
 					kv["valid_before"] = inst.ValidBefore
 				}
+			case "hashed":
+				if inst.Hashed != inst.original.Hashed {
+					kv["hashed"] = inst.Hashed
+				}
+			case "key_prefix":
+				if inst.KeyPrefix != inst.original.KeyPrefix {
+					kv["key_prefix"] = inst.KeyPrefix
+				}
+			case "allowed_models":
+				if inst.AllowedModels != inst.original.AllowedModels {
+					kv["allowed_models"] = inst.AllowedModels
+				}
+			case "rpm":
+				if inst.Rpm != inst.original.Rpm {
+					kv["rpm"] = inst.Rpm
+				}
+			case "tpm":
+				if inst.Tpm != inst.original.Tpm {
+					kv["tpm"] = inst.Tpm
+				}
+			case "last_used_at":
+				if inst.LastUsedAt != inst.original.LastUsedAt {
+					kv["last_used_at"] = inst.LastUsedAt
+				}
 			case "created_at":
 				if inst.CreatedAt != inst.original.CreatedAt {
 					kv["created_at"] = inst.CreatedAt
@@ -298,28 +394,40 @@ func (m *UserApiKeyModel) globalScopeEnabled(name string) bool {
 }
 
 type UserApiKey struct {
-	Id          int64     `json:"id"`
-	UserId      int64     `json:"user_id"`
-	Name        string    `json:"name"`
-	Token       string    `json:"token"`
-	Status      int64     `json:"status"`
-	ValidBefore time.Time `json:"valid_before"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	Id            int64     `json:"id"`
+	UserId        int64     `json:"user_id"`
+	Name          string    `json:"name"`
+	Token         string    `json:"token"`
+	Status        int64     `json:"status"`
+	ValidBefore   time.Time `json:"valid_before"`
+	Hashed        int64     `json:"hashed"`
+	KeyPrefix     string    `json:"key_prefix"`
+	AllowedModels string    `json:"allowed_models"`
+	Rpm           int64     `json:"rpm"`
+	Tpm           int64     `json:"tpm"`
+	LastUsedAt    time.Time `json:"last_used_at"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 func (w UserApiKey) ToUserApiKeyN(allows ...string) UserApiKeyN {
 	if len(allows) == 0 {
 		return UserApiKeyN{
 
-			Id:          null.IntFrom(int64(w.Id)),
-			UserId:      null.IntFrom(int64(w.UserId)),
-			Name:        null.StringFrom(w.Name),
-			Token:       null.StringFrom(w.Token),
-			Status:      null.IntFrom(int64(w.Status)),
-			ValidBefore: null.TimeFrom(w.ValidBefore),
-			CreatedAt:   null.TimeFrom(w.CreatedAt),
-			UpdatedAt:   null.TimeFrom(w.UpdatedAt),
+			Id:            null.IntFrom(int64(w.Id)),
+			UserId:        null.IntFrom(int64(w.UserId)),
+			Name:          null.StringFrom(w.Name),
+			Token:         null.StringFrom(w.Token),
+			Status:        null.IntFrom(int64(w.Status)),
+			ValidBefore:   null.TimeFrom(w.ValidBefore),
+			Hashed:        null.IntFrom(int64(w.Hashed)),
+			KeyPrefix:     null.StringFrom(w.KeyPrefix),
+			AllowedModels: null.StringFrom(w.AllowedModels),
+			Rpm:           null.IntFrom(int64(w.Rpm)),
+			Tpm:           null.IntFrom(int64(w.Tpm)),
+			LastUsedAt:    null.TimeFrom(w.LastUsedAt),
+			CreatedAt:     null.TimeFrom(w.CreatedAt),
+			UpdatedAt:     null.TimeFrom(w.UpdatedAt),
 		}
 	}
 
@@ -339,6 +447,18 @@ func (w UserApiKey) ToUserApiKeyN(allows ...string) UserApiKeyN {
 			res.Status = null.IntFrom(int64(w.Status))
 		case "valid_before":
 			res.ValidBefore = null.TimeFrom(w.ValidBefore)
+		case "hashed":
+			res.Hashed = null.IntFrom(int64(w.Hashed))
+		case "key_prefix":
+			res.KeyPrefix = null.StringFrom(w.KeyPrefix)
+		case "allowed_models":
+			res.AllowedModels = null.StringFrom(w.AllowedModels)
+		case "rpm":
+			res.Rpm = null.IntFrom(int64(w.Rpm))
+		case "tpm":
+			res.Tpm = null.IntFrom(int64(w.Tpm))
+		case "last_used_at":
+			res.LastUsedAt = null.TimeFrom(w.LastUsedAt)
 		case "created_at":
 			res.CreatedAt = null.TimeFrom(w.CreatedAt)
 		case "updated_at":
@@ -359,14 +479,20 @@ func (w UserApiKey) As(dst interface{}) error {
 func (w *UserApiKeyN) ToUserApiKey() UserApiKey {
 	return UserApiKey{
 
-		Id:          w.Id.Int64,
-		UserId:      w.UserId.Int64,
-		Name:        w.Name.String,
-		Token:       w.Token.String,
-		Status:      w.Status.Int64,
-		ValidBefore: w.ValidBefore.Time,
-		CreatedAt:   w.CreatedAt.Time,
-		UpdatedAt:   w.UpdatedAt.Time,
+		Id:            w.Id.Int64,
+		UserId:        w.UserId.Int64,
+		Name:          w.Name.String,
+		Token:         w.Token.String,
+		Status:        w.Status.Int64,
+		ValidBefore:   w.ValidBefore.Time,
+		Hashed:        w.Hashed.Int64,
+		KeyPrefix:     w.KeyPrefix.String,
+		AllowedModels: w.AllowedModels.String,
+		Rpm:           w.Rpm.Int64,
+		Tpm:           w.Tpm.Int64,
+		LastUsedAt:    w.LastUsedAt.Time,
+		CreatedAt:     w.CreatedAt.Time,
+		UpdatedAt:     w.UpdatedAt.Time,
 	}
 }
 
@@ -389,14 +515,20 @@ func UserApiKeyTable() string {
 }
 
 const (
-	FieldUserApiKeyId          = "id"
-	FieldUserApiKeyUserId      = "user_id"
-	FieldUserApiKeyName        = "name"
-	FieldUserApiKeyToken       = "token"
-	FieldUserApiKeyStatus      = "status"
-	FieldUserApiKeyValidBefore = "valid_before"
-	FieldUserApiKeyCreatedAt   = "created_at"
-	FieldUserApiKeyUpdatedAt   = "updated_at"
+	FieldUserApiKeyId            = "id"
+	FieldUserApiKeyUserId        = "user_id"
+	FieldUserApiKeyName          = "name"
+	FieldUserApiKeyToken         = "token"
+	FieldUserApiKeyStatus        = "status"
+	FieldUserApiKeyValidBefore   = "valid_before"
+	FieldUserApiKeyHashed        = "hashed"
+	FieldUserApiKeyKeyPrefix     = "key_prefix"
+	FieldUserApiKeyAllowedModels = "allowed_models"
+	FieldUserApiKeyRpm           = "rpm"
+	FieldUserApiKeyTpm           = "tpm"
+	FieldUserApiKeyLastUsedAt    = "last_used_at"
+	FieldUserApiKeyCreatedAt     = "created_at"
+	FieldUserApiKeyUpdatedAt     = "updated_at"
 )
 
 // UserApiKeyFields return all fields in UserApiKey model
@@ -408,6 +540,12 @@ func UserApiKeyFields() []string {
 		"token",
 		"status",
 		"valid_before",
+		"hashed",
+		"key_prefix",
+		"allowed_models",
+		"rpm",
+		"tpm",
+		"last_used_at",
 		"created_at",
 		"updated_at",
 	}
@@ -546,6 +684,12 @@ func (m *UserApiKeyModel) Get(ctx context.Context, builders ...query.SQLBuilder)
 			"token",
 			"status",
 			"valid_before",
+			"hashed",
+			"key_prefix",
+			"allowed_models",
+			"rpm",
+			"tpm",
+			"last_used_at",
 			"created_at",
 			"updated_at",
 		)
@@ -569,6 +713,18 @@ func (m *UserApiKeyModel) Get(ctx context.Context, builders ...query.SQLBuilder)
 			selectFields = append(selectFields, f)
 		case "valid_before":
 			selectFields = append(selectFields, f)
+		case "hashed":
+			selectFields = append(selectFields, f)
+		case "key_prefix":
+			selectFields = append(selectFields, f)
+		case "allowed_models":
+			selectFields = append(selectFields, f)
+		case "rpm":
+			selectFields = append(selectFields, f)
+		case "tpm":
+			selectFields = append(selectFields, f)
+		case "last_used_at":
+			selectFields = append(selectFields, f)
 		case "created_at":
 			selectFields = append(selectFields, f)
 		case "updated_at":
@@ -595,6 +751,18 @@ func (m *UserApiKeyModel) Get(ctx context.Context, builders ...query.SQLBuilder)
 				scanFields = append(scanFields, &userApiKeyVar.Status)
 			case "valid_before":
 				scanFields = append(scanFields, &userApiKeyVar.ValidBefore)
+			case "hashed":
+				scanFields = append(scanFields, &userApiKeyVar.Hashed)
+			case "key_prefix":
+				scanFields = append(scanFields, &userApiKeyVar.KeyPrefix)
+			case "allowed_models":
+				scanFields = append(scanFields, &userApiKeyVar.AllowedModels)
+			case "rpm":
+				scanFields = append(scanFields, &userApiKeyVar.Rpm)
+			case "tpm":
+				scanFields = append(scanFields, &userApiKeyVar.Tpm)
+			case "last_used_at":
+				scanFields = append(scanFields, &userApiKeyVar.LastUsedAt)
 			case "created_at":
 				scanFields = append(scanFields, &userApiKeyVar.CreatedAt)
 			case "updated_at":
@@ -0,0 +1,859 @@
+package model
+
+// !!! DO NOT EDIT THIS FILE
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/iancoleman/strcase"
+	"github.com/mylxsw/eloquent/query"
+	"gopkg.in/guregu/null.v3"
+	"time"
+)
+
+func init() {
+
+}
+
+// UserModelsN is a UserModels object, all fields are nullable
+type UserModelsN struct {
+	original        *userModelsOriginal
+	userModelsModel *UserModelsModel
+
+	Id           null.Int    `json:"id"`
+	UserId       null.Int    `json:"user_id"`
+	Code         null.String `json:"code"`
+	Model        null.String `json:"model"`
+	Name         null.String `json:"name,omitempty"`
+	Description  null.String `json:"description,omitempty"`
+	AvatarUrl    null.String `json:"avatar_url,omitempty"`
+	SystemPrompt null.String `json:"system_prompt,omitempty"`
+	ChatDefaults null.String `json:"chat_defaults,omitempty"`
+	Visibility   null.Int    `json:"visibility"`
+	CreatedAt    null.Time
+	UpdatedAt    null.Time
+}
+
+// As convert object to other type
+// dst must be a pointer to struct
+func (inst *UserModelsN) As(dst interface{}) error {
+	return query.Copy(inst, dst)
+}
+
+// SetModel set model for UserModels
+func (inst *UserModelsN) SetModel(userModelsModel *UserModelsModel) {
+	inst.userModelsModel = userModelsModel
+}
+
+// userModelsOriginal is an object which stores original UserModels from database
+type userModelsOriginal struct {
+	Id           null.Int
+	UserId       null.Int
+	Code         null.String
+	Model        null.String
+	Name         null.String
+	Description  null.String
+	AvatarUrl    null.String
+	SystemPrompt null.String
+	ChatDefaults null.String
+	Visibility   null.Int
+	CreatedAt    null.Time
+	UpdatedAt    null.Time
+}
+
+// Staled identify whether the object has been modified
+func (inst *UserModelsN) Staled(onlyFields ...string) bool {
+	if inst.original == nil {
+		inst.original = &userModelsOriginal{}
+	}
+
+	if len(onlyFields) == 0 {
+
+		if inst.Id != inst.original.Id {
+			return true
+		}
+		if inst.UserId != inst.original.UserId {
+			return true
+		}
+		if inst.Code != inst.original.Code {
+			return true
+		}
+		if inst.Model != inst.original.Model {
+			return true
+		}
+		if inst.Name != inst.original.Name {
+			return true
+		}
+		if inst.Description != inst.original.Description {
+			return true
+		}
+		if inst.AvatarUrl != inst.original.AvatarUrl {
+			return true
+		}
+		if inst.SystemPrompt != inst.original.SystemPrompt {
+			return true
+		}
+		if inst.ChatDefaults != inst.original.ChatDefaults {
+			return true
+		}
+		if inst.Visibility != inst.original.Visibility {
+			return true
+		}
+		if inst.CreatedAt != inst.original.CreatedAt {
+			return true
+		}
+		if inst.UpdatedAt != inst.original.UpdatedAt {
+			return true
+		}
+	} else {
+		for _, f := range onlyFields {
+			switch strcase.ToSnake(f) {
+
+			case "id":
+				if inst.Id != inst.original.Id {
+					return true
+				}
+			case "user_id":
+				if inst.UserId != inst.original.UserId {
+					return true
+				}
+			case "code":
+				if inst.Code != inst.original.Code {
+					return true
+				}
+			case "model":
+				if inst.Model != inst.original.Model {
+					return true
+				}
+			case "name":
+				if inst.Name != inst.original.Name {
+					return true
+				}
+			case "description":
+				if inst.Description != inst.original.Description {
+					return true
+				}
+			case "avatar_url":
+				if inst.AvatarUrl != inst.original.AvatarUrl {
+					return true
+				}
+			case "system_prompt":
+				if inst.SystemPrompt != inst.original.SystemPrompt {
+					return true
+				}
+			case "chat_defaults":
+				if inst.ChatDefaults != inst.original.ChatDefaults {
+					return true
+				}
+			case "visibility":
+				if inst.Visibility != inst.original.Visibility {
+					return true
+				}
+			case "created_at":
+				if inst.CreatedAt != inst.original.CreatedAt {
+					return true
+				}
+			case "updated_at":
+				if inst.UpdatedAt != inst.original.UpdatedAt {
+					return true
+				}
+			default:
+			}
+		}
+	}
+
+	return false
+}
+
+// StaledKV return all fields has been modified
+func (inst *UserModelsN) StaledKV(onlyFields ...string) query.KV {
+	kv := make(query.KV, 0)
+
+	if inst.original == nil {
+		inst.original = &userModelsOriginal{}
+	}
+
+	if len(onlyFields) == 0 {
+
+		if inst.Id != inst.original.Id {
+			kv["id"] = inst.Id
+		}
+		if inst.UserId != inst.original.UserId {
+			kv["user_id"] = inst.UserId
+		}
+		if inst.Code != inst.original.Code {
+			kv["code"] = inst.Code
+		}
+		if inst.Model != inst.original.Model {
+			kv["model"] = inst.Model
+		}
+		if inst.Name != inst.original.Name {
+			kv["name"] = inst.Name
+		}
+		if inst.Description != inst.original.Description {
+			kv["description"] = inst.Description
+		}
+		if inst.AvatarUrl != inst.original.AvatarUrl {
+			kv["avatar_url"] = inst.AvatarUrl
+		}
+		if inst.SystemPrompt != inst.original.SystemPrompt {
+			kv["system_prompt"] = inst.SystemPrompt
+		}
+		if inst.ChatDefaults != inst.original.ChatDefaults {
+			kv["chat_defaults"] = inst.ChatDefaults
+		}
+		if inst.Visibility != inst.original.Visibility {
+			kv["visibility"] = inst.Visibility
+		}
+		if inst.CreatedAt != inst.original.CreatedAt {
+			kv["created_at"] = inst.CreatedAt
+		}
+		if inst.UpdatedAt != inst.original.UpdatedAt {
+			kv["updated_at"] = inst.UpdatedAt
+		}
+	} else {
+		for _, f := range onlyFields {
+			switch strcase.ToSnake(f) {
+
+			case "id":
+				if inst.Id != inst.original.Id {
+					kv["id"] = inst.Id
+				}
+			case "user_id":
+				if inst.UserId != inst.original.UserId {
+					kv["user_id"] = inst.UserId
+				}
+			case "code":
+				if inst.Code != inst.original.Code {
+					kv["code"] = inst.Code
+				}
+			case "model":
+				if inst.Model != inst.original.Model {
+					kv["model"] = inst.Model
+				}
+			case "name":
+				if inst.Name != inst.original.Name {
+					kv["name"] = inst.Name
+				}
+			case "description":
+				if inst.Description != inst.original.Description {
+					kv["description"] = inst.Description
+				}
+			case "avatar_url":
+				if inst.AvatarUrl != inst.original.AvatarUrl {
+					kv["avatar_url"] = inst.AvatarUrl
+				}
+			case "system_prompt":
+				if inst.SystemPrompt != inst.original.SystemPrompt {
+					kv["system_prompt"] = inst.SystemPrompt
+				}
+			case "chat_defaults":
+				if inst.ChatDefaults != inst.original.ChatDefaults {
+					kv["chat_defaults"] = inst.ChatDefaults
+				}
+			case "visibility":
+				if inst.Visibility != inst.original.Visibility {
+					kv["visibility"] = inst.Visibility
+				}
+			case "created_at":
+				if inst.CreatedAt != inst.original.CreatedAt {
+					kv["created_at"] = inst.CreatedAt
+				}
+			case "updated_at":
+				if inst.UpdatedAt != inst.original.UpdatedAt {
+					kv["updated_at"] = inst.UpdatedAt
+				}
+			default:
+			}
+		}
+	}
+
+	return kv
+}
+
+// Save create a new model or update it
+func (inst *UserModelsN) Save(ctx context.Context, onlyFields ...string) error {
+	if inst.userModelsModel == nil {
+		return query.ErrModelNotSet
+	}
+
+	id, _, err := inst.userModelsModel.SaveOrUpdate(ctx, *inst, onlyFields...)
+	if err != nil {
+		return err
+	}
+
+	inst.Id = null.IntFrom(id)
+	return nil
+}
+
+// Delete remove a user_models
+func (inst *UserModelsN) Delete(ctx context.Context) error {
+	if inst.userModelsModel == nil {
+		return query.ErrModelNotSet
+	}
+
+	_, err := inst.userModelsModel.DeleteById(ctx, inst.Id.Int64)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// String convert instance to json string
+func (inst *UserModelsN) String() string {
+	rs, _ := json.Marshal(inst)
+	return string(rs)
+}
+
+type userModelsScope struct {
+	name  string
+	apply func(builder query.Condition)
+}
+
+var userModelsGlobalScopes = make([]userModelsScope, 0)
+var userModelsLocalScopes = make([]userModelsScope, 0)
+
+// AddGlobalScopeForUserModels assign a global scope to a model
+func AddGlobalScopeForUserModels(name string, apply func(builder query.Condition)) {
+	userModelsGlobalScopes = append(userModelsGlobalScopes, userModelsScope{name: name, apply: apply})
+}
+
+// AddLocalScopeForUserModels assign a local scope to a model
+func AddLocalScopeForUserModels(name string, apply func(builder query.Condition)) {
+	userModelsLocalScopes = append(userModelsLocalScopes, userModelsScope{name: name, apply: apply})
+}
+
+func (m *UserModelsModel) applyScope() query.Condition {
+	scopeCond := query.ConditionBuilder()
+	for _, g := range userModelsGlobalScopes {
+		if m.globalScopeEnabled(g.name) {
+			g.apply(scopeCond)
+		}
+	}
+
+	for _, s := range userModelsLocalScopes {
+		if m.localScopeEnabled(s.name) {
+			s.apply(scopeCond)
+		}
+	}
+
+	return scopeCond
+}
+
+func (m *UserModelsModel) localScopeEnabled(name string) bool {
+	for _, n := range m.includeLocalScopes {
+		if name == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *UserModelsModel) globalScopeEnabled(name string) bool {
+	for _, n := range m.excludeGlobalScopes {
+		if name == n {
+			return false
+		}
+	}
+
+	return true
+}
+
+type UserModels struct {
+	Id           int64  `json:"id"`
+	UserId       int64  `json:"user_id"`
+	Code         string `json:"code"`
+	Model        string `json:"model"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+	AvatarUrl    string `json:"avatar_url,omitempty"`
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	ChatDefaults string `json:"chat_defaults,omitempty"`
+	Visibility   int64  `json:"visibility"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (w UserModels) ToUserModelsN(allows ...string) UserModelsN {
+	if len(allows) == 0 {
+		return UserModelsN{
+
+			Id:           null.IntFrom(int64(w.Id)),
+			UserId:       null.IntFrom(int64(w.UserId)),
+			Code:         null.StringFrom(w.Code),
+			Model:        null.StringFrom(w.Model),
+			Name:         null.StringFrom(w.Name),
+			Description:  null.StringFrom(w.Description),
+			AvatarUrl:    null.StringFrom(w.AvatarUrl),
+			SystemPrompt: null.StringFrom(w.SystemPrompt),
+			ChatDefaults: null.StringFrom(w.ChatDefaults),
+			Visibility:   null.IntFrom(int64(w.Visibility)),
+			CreatedAt:    null.TimeFrom(w.CreatedAt),
+			UpdatedAt:    null.TimeFrom(w.UpdatedAt),
+		}
+	}
+
+	res := UserModelsN{}
+	for _, al := range allows {
+		switch strcase.ToSnake(al) {
+
+		case "id":
+			res.Id = null.IntFrom(int64(w.Id))
+		case "user_id":
+			res.UserId = null.IntFrom(int64(w.UserId))
+		case "code":
+			res.Code = null.StringFrom(w.Code)
+		case "model":
+			res.Model = null.StringFrom(w.Model)
+		case "name":
+			res.Name = null.StringFrom(w.Name)
+		case "description":
+			res.Description = null.StringFrom(w.Description)
+		case "avatar_url":
+			res.AvatarUrl = null.StringFrom(w.AvatarUrl)
+		case "system_prompt":
+			res.SystemPrompt = null.StringFrom(w.SystemPrompt)
+		case "chat_defaults":
+			res.ChatDefaults = null.StringFrom(w.ChatDefaults)
+		case "visibility":
+			res.Visibility = null.IntFrom(int64(w.Visibility))
+		case "created_at":
+			res.CreatedAt = null.TimeFrom(w.CreatedAt)
+		case "updated_at":
+			res.UpdatedAt = null.TimeFrom(w.UpdatedAt)
+		default:
+		}
+	}
+
+	return res
+}
+
+// As convert object to other type
+// dst must be a pointer to struct
+func (w UserModels) As(dst interface{}) error {
+	return query.Copy(w, dst)
+}
+
+func (w *UserModelsN) ToUserModels() UserModels {
+	return UserModels{
+
+		Id:           w.Id.Int64,
+		UserId:       w.UserId.Int64,
+		Code:         w.Code.String,
+		Model:        w.Model.String,
+		Name:         w.Name.String,
+		Description:  w.Description.String,
+		AvatarUrl:    w.AvatarUrl.String,
+		SystemPrompt: w.SystemPrompt.String,
+		ChatDefaults: w.ChatDefaults.String,
+		Visibility:   w.Visibility.Int64,
+		CreatedAt:    w.CreatedAt.Time,
+		UpdatedAt:    w.UpdatedAt.Time,
+	}
+}
+
+// UserModelsModel is a model which encapsulates the operations of the object
+type UserModelsModel struct {
+	db        *query.DatabaseWrap
+	tableName string
+
+	excludeGlobalScopes []string
+	includeLocalScopes  []string
+
+	query query.SQLBuilder
+}
+
+var userModelsTableName = "user_models"
+
+// UserModelsTable return table name for UserModels
+func UserModelsTable() string {
+	return userModelsTableName
+}
+
+const (
+	FieldUserModelsId           = "id"
+	FieldUserModelsUserId       = "user_id"
+	FieldUserModelsCode         = "code"
+	FieldUserModelsModel        = "model"
+	FieldUserModelsName         = "name"
+	FieldUserModelsDescription  = "description"
+	FieldUserModelsAvatarUrl    = "avatar_url"
+	FieldUserModelsSystemPrompt = "system_prompt"
+	FieldUserModelsChatDefaults = "chat_defaults"
+	FieldUserModelsVisibility   = "visibility"
+	FieldUserModelsCreatedAt    = "created_at"
+	FieldUserModelsUpdatedAt    = "updated_at"
+)
+
+// UserModelsFields return all fields in UserModels model
+func UserModelsFields() []string {
+	return []string{
+		"id",
+		"user_id",
+		"code",
+		"model",
+		"name",
+		"description",
+		"avatar_url",
+		"system_prompt",
+		"chat_defaults",
+		"visibility",
+		"created_at",
+		"updated_at",
+	}
+}
+
+func SetUserModelsTable(tableName string) {
+	userModelsTableName = tableName
+}
+
+// NewUserModelsModel create a UserModelsModel
+func NewUserModelsModel(db query.Database) *UserModelsModel {
+	return &UserModelsModel{
+		db:                  query.NewDatabaseWrap(db),
+		tableName:           userModelsTableName,
+		excludeGlobalScopes: make([]string, 0),
+		includeLocalScopes:  make([]string, 0),
+		query:               query.Builder(),
+	}
+}
+
+// GetDB return database instance
+func (m *UserModelsModel) GetDB() query.Database {
+	return m.db.GetDB()
+}
+
+func (m *UserModelsModel) clone() *UserModelsModel {
+	return &UserModelsModel{
+		db:                  m.db,
+		tableName:           m.tableName,
+		excludeGlobalScopes: append([]string{}, m.excludeGlobalScopes...),
+		includeLocalScopes:  append([]string{}, m.includeLocalScopes...),
+		query:               m.query,
+	}
+}
+
+// WithoutGlobalScopes remove a global scope for given query
+func (m *UserModelsModel) WithoutGlobalScopes(names ...string) *UserModelsModel {
+	mc := m.clone()
+	mc.excludeGlobalScopes = append(mc.excludeGlobalScopes, names...)
+
+	return mc
+}
+
+// WithLocalScopes add a local scope for given query
+func (m *UserModelsModel) WithLocalScopes(names ...string) *UserModelsModel {
+	mc := m.clone()
+	mc.includeLocalScopes = append(mc.includeLocalScopes, names...)
+
+	return mc
+}
+
+// Condition add query builder to model
+func (m *UserModelsModel) Condition(builder query.SQLBuilder) *UserModelsModel {
+	mm := m.clone()
+	mm.query = mm.query.Merge(builder)
+
+	return mm
+}
+
+// Find retrieve a model by its primary key
+func (m *UserModelsModel) Find(ctx context.Context, id int64) (*UserModelsN, error) {
+	return m.First(ctx, m.query.Where("id", "=", id))
+}
+
+// Exists return whether the records exists for a given query
+func (m *UserModelsModel) Exists(ctx context.Context, builders ...query.SQLBuilder) (bool, error) {
+	count, err := m.Count(ctx, builders...)
+	return count > 0, err
+}
+
+// Count return model count for a given query
+func (m *UserModelsModel) Count(ctx context.Context, builders ...query.SQLBuilder) (int64, error) {
+	sqlStr, params := m.query.
+		Merge(builders...).
+		Table(m.tableName).
+		AppendCondition(m.applyScope()).
+		ResolveCount()
+
+	rows, err := m.db.QueryContext(ctx, sqlStr, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	rows.Next()
+	var res int64
+	if err := rows.Scan(&res); err != nil {
+		return 0, err
+	}
+
+	return res, nil
+}
+
+func (m *UserModelsModel) Paginate(ctx context.Context, page int64, perPage int64, builders ...query.SQLBuilder) ([]UserModelsN, query.PaginateMeta, error) {
+	if page <= 0 {
+		page = 1
+	}
+
+	if perPage <= 0 {
+		perPage = 15
+	}
+
+	meta := query.PaginateMeta{
+		PerPage: perPage,
+		Page:    page,
+	}
+
+	count, err := m.Count(ctx, builders...)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	meta.Total = count
+	meta.LastPage = count / perPage
+	if count%perPage != 0 {
+		meta.LastPage += 1
+	}
+
+	res, err := m.Get(ctx, append([]query.SQLBuilder{query.Builder().Limit(perPage).Offset((page - 1) * perPage)}, builders...)...)
+	if err != nil {
+		return res, meta, err
+	}
+
+	return res, meta, nil
+}
+
+// Get retrieve all results for given query
+func (m *UserModelsModel) Get(ctx context.Context, builders ...query.SQLBuilder) ([]UserModelsN, error) {
+	b := m.query.Merge(builders...).Table(m.tableName).AppendCondition(m.applyScope())
+	if len(b.GetFields()) == 0 {
+		b = b.Select(
+			"id",
+			"user_id",
+			"code",
+			"model",
+			"name",
+			"description",
+			"avatar_url",
+			"system_prompt",
+			"chat_defaults",
+			"visibility",
+			"created_at",
+			"updated_at",
+		)
+	}
+
+	fields := b.GetFields()
+	selectFields := make([]query.Expr, 0)
+
+	for _, f := range fields {
+		switch strcase.ToSnake(f.Value) {
+
+		case "id":
+			selectFields = append(selectFields, f)
+		case "user_id":
+			selectFields = append(selectFields, f)
+		case "code":
+			selectFields = append(selectFields, f)
+		case "model":
+			selectFields = append(selectFields, f)
+		case "name":
+			selectFields = append(selectFields, f)
+		case "description":
+			selectFields = append(selectFields, f)
+		case "avatar_url":
+			selectFields = append(selectFields, f)
+		case "system_prompt":
+			selectFields = append(selectFields, f)
+		case "chat_defaults":
+			selectFields = append(selectFields, f)
+		case "visibility":
+			selectFields = append(selectFields, f)
+		case "created_at":
+			selectFields = append(selectFields, f)
+		case "updated_at":
+			selectFields = append(selectFields, f)
+		}
+	}
+
+	var createScanVar = func(fields []query.Expr) (*UserModelsN, []interface{}) {
+		var userModelsVar UserModelsN
+		scanFields := make([]interface{}, 0)
+
+		for _, f := range fields {
+			switch strcase.ToSnake(f.Value) {
+
+			case "id":
+				scanFields = append(scanFields, &userModelsVar.Id)
+			case "user_id":
+				scanFields = append(scanFields, &userModelsVar.UserId)
+			case "code":
+				scanFields = append(scanFields, &userModelsVar.Code)
+			case "model":
+				scanFields = append(scanFields, &userModelsVar.Model)
+			case "name":
+				scanFields = append(scanFields, &userModelsVar.Name)
+			case "description":
+				scanFields = append(scanFields, &userModelsVar.Description)
+			case "avatar_url":
+				scanFields = append(scanFields, &userModelsVar.AvatarUrl)
+			case "system_prompt":
+				scanFields = append(scanFields, &userModelsVar.SystemPrompt)
+			case "chat_defaults":
+				scanFields = append(scanFields, &userModelsVar.ChatDefaults)
+			case "visibility":
+				scanFields = append(scanFields, &userModelsVar.Visibility)
+			case "created_at":
+				scanFields = append(scanFields, &userModelsVar.CreatedAt)
+			case "updated_at":
+				scanFields = append(scanFields, &userModelsVar.UpdatedAt)
+			}
+		}
+
+		return &userModelsVar, scanFields
+	}
+
+	sqlStr, params := b.Fields(selectFields...).ResolveQuery()
+
+	rows, err := m.db.QueryContext(ctx, sqlStr, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	userModelss := make([]UserModelsN, 0)
+	for rows.Next() {
+		userModelsReal, scanFields := createScanVar(fields)
+		if err := rows.Scan(scanFields...); err != nil {
+			return nil, err
+		}
+
+		userModelsReal.original = &userModelsOriginal{}
+		_ = query.Copy(userModelsReal, userModelsReal.original)
+
+		userModelsReal.SetModel(m)
+		userModelss = append(userModelss, *userModelsReal)
+	}
+
+	return userModelss, nil
+}
+
+// First return first result for given query
+func (m *UserModelsModel) First(ctx context.Context, builders ...query.SQLBuilder) (*UserModelsN, error) {
+	res, err := m.Get(ctx, append(builders, query.Builder().Limit(1))...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) == 0 {
+		return nil, query.ErrNoResult
+	}
+
+	return &res[0], nil
+}
+
+// Create save a new user_models to database
+func (m *UserModelsModel) Create(ctx context.Context, kv query.KV) (int64, error) {
+
+	if _, ok := kv["created_at"]; !ok {
+		kv["created_at"] = time.Now()
+	}
+
+	if _, ok := kv["updated_at"]; !ok {
+		kv["updated_at"] = time.Now()
+	}
+
+	sqlStr, params := m.query.Table(m.tableName).ResolveInsert(kv)
+
+	res, err := m.db.ExecContext(ctx, sqlStr, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// SaveAll save all user_modelss to database
+func (m *UserModelsModel) SaveAll(ctx context.Context, userModelss []UserModelsN) ([]int64, error) {
+	ids := make([]int64, 0)
+	for _, userModels := range userModelss {
+		id, err := m.Save(ctx, userModels)
+		if err != nil {
+			return ids, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Save save a user_models to database
+func (m *UserModelsModel) Save(ctx context.Context, userModels UserModelsN, onlyFields ...string) (int64, error) {
+	return m.Create(ctx, userModels.StaledKV(onlyFields...))
+}
+
+// SaveOrUpdate save a new user_models or update it when it has a id > 0
+func (m *UserModelsModel) SaveOrUpdate(ctx context.Context, userModels UserModelsN, onlyFields ...string) (id int64, updated bool, err error) {
+	if userModels.Id.Int64 > 0 {
+		_, _err := m.UpdateById(ctx, userModels.Id.Int64, userModels, onlyFields...)
+		return userModels.Id.Int64, true, _err
+	}
+
+	_id, _err := m.Save(ctx, userModels, onlyFields...)
+	return _id, false, _err
+}
+
+// UpdateFields update kv for a given query
+func (m *UserModelsModel) UpdateFields(ctx context.Context, kv query.KV, builders ...query.SQLBuilder) (int64, error) {
+	if len(kv) == 0 {
+		return 0, nil
+	}
+
+	kv["updated_at"] = time.Now()
+
+	sqlStr, params := m.query.Merge(builders...).AppendCondition(m.applyScope()).
+		Table(m.tableName).
+		ResolveUpdate(kv)
+
+	res, err := m.db.ExecContext(ctx, sqlStr, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Update update a model for given query
+func (m *UserModelsModel) Update(ctx context.Context, builder query.SQLBuilder, userModels UserModelsN, onlyFields ...string) (int64, error) {
+	return m.UpdateFields(ctx, userModels.StaledKV(onlyFields...), builder)
+}
+
+// UpdateById update a model by id
+func (m *UserModelsModel) UpdateById(ctx context.Context, id int64, userModels UserModelsN, onlyFields ...string) (int64, error) {
+	return m.Condition(query.Builder().Where("id", "=", id)).UpdateFields(ctx, userModels.StaledKV(onlyFields...))
+}
+
+// Delete remove a model
+func (m *UserModelsModel) Delete(ctx context.Context, builders ...query.SQLBuilder) (int64, error) {
+
+	sqlStr, params := m.query.Merge(builders...).AppendCondition(m.applyScope()).Table(m.tableName).ResolveDelete()
+
+	res, err := m.db.ExecContext(ctx, sqlStr, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+
+}
+
+// DeleteById remove a model by id
+func (m *UserModelsModel) DeleteById(ctx context.Context, id int64) (int64, error) {
+	return m.Condition(query.Builder().Where("id", "=", id)).Delete(ctx)
+}
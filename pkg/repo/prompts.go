@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	model2 "github.com/mylxsw/aidea-server/pkg/repo/model"
 
 	"github.com/mylxsw/asteria/log"
@@ -111,6 +112,21 @@ func (r *PromptRepo) ChatSystemPromptExamples(ctx context.Context) ([]model2.Cha
 	}), nil
 }
 
+// ChatSystemPromptExample 根据 ID 获取系统提示语示例
+func (r *PromptRepo) ChatSystemPromptExample(ctx context.Context, id int64) (*model2.ChatSysPromptExample, error) {
+	example, err := model2.NewChatSysPromptExampleModel(r.db).First(ctx, query.Builder().Where(model2.FieldChatSysPromptExampleId, id))
+	if err != nil {
+		if errors.Is(err, query.ErrNoResult) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	ret := example.ToChatSysPromptExample()
+	return &ret, nil
+}
+
 // PromptExample 用户提示语示例
 type PromptExample struct {
 	// Title  标题，返回值必须包含该字段，即使为空字符串（客户端未做兼容）
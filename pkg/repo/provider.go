@@ -6,6 +6,7 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/secret"
 	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/eloquent/query"
 	"time"
@@ -47,6 +48,14 @@ func (Provider) Register(binder infra.Binder) {
 	binder.MustSingleton(NewNotificationRepo)
 	binder.MustSingleton(NewModelRepo)
 	binder.MustSingleton(NewSettingRepo)
+	binder.MustSingleton(NewUserModelRepo)
+	binder.MustSingleton(NewAPIKeyRepo)
+	binder.MustSingleton(NewUsageWebhookRepo)
+
+	// 渠道密钥加密
+	binder.MustSingleton(func(conf *config.Config) *secret.Envelope {
+		return secret.NewEnvelopeWithMasterKey(conf.ChannelSecretMasterKey)
+	})
 
 	// MySQL 数据库连接
 	binder.MustSingleton(func(conf *config.Config) (*sql.DB, error) {
@@ -109,4 +118,7 @@ type Repository struct {
 	Article      *ArticleRepo      `autowire:"@"`
 	Model        *ModelRepo        `autowire:"@"`
 	Setting      *SettingRepo      `autowire:"@"`
+	UserModel    *UserModelRepo    `autowire:"@"`
+	APIKey       *APIKeyRepo       `autowire:"@"`
+	UsageWebhook *UsageWebhookRepo `autowire:"@"`
 }
@@ -151,6 +151,8 @@ type QuotaUsedMeta struct {
 	OutputToken int      `json:"output_token,omitempty"`
 	InputPrice  float64  `json:"input_price,omitempty"`
 	OutputPrice float64  `json:"output_price,omitempty"`
+	// APIKeyID 通过 API Key 调用时使用的 Key，用于按照 Key 统计使用量
+	APIKeyID int64 `json:"api_key_id,omitempty"`
 }
 
 func NewQuotaUsedMeta(tag string, models ...string) QuotaUsedMeta {
@@ -287,3 +289,40 @@ func (repo *QuotaRepo) GetQuotaDetails(ctx context.Context, userId int64, startA
 		}
 	}), nil
 }
+
+// APIKeyUsage API Key 的使用量统计
+type APIKeyUsage struct {
+	Requests     int64 `json:"requests"`
+	Used         int64 `json:"used"`
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// SummarizeAPIKeyUsages 按照 API Key 汇总配额使用情况，不是通过 API Key 产生的使用记录不参与统计
+func SummarizeAPIKeyUsages(usages []QuotaUsage) map[int64]APIKeyUsage {
+	ret := make(map[int64]APIKeyUsage)
+	for _, item := range usages {
+		if item.QuotaMeta.APIKeyID <= 0 {
+			continue
+		}
+
+		usage := ret[item.QuotaMeta.APIKeyID]
+		usage.Requests++
+		usage.Used += item.Used
+		usage.InputTokens += int64(item.QuotaMeta.InputToken)
+		usage.OutputTokens += int64(item.QuotaMeta.OutputToken)
+		ret[item.QuotaMeta.APIKeyID] = usage
+	}
+
+	return ret
+}
+
+// GetAPIKeyUsages 获取用户每个 API Key 在指定时间段内的使用量
+func (repo *QuotaRepo) GetAPIKeyUsages(ctx context.Context, userId int64, startAt, endAt time.Time) (map[int64]APIKeyUsage, error) {
+	usages, err := repo.GetQuotaDetails(ctx, userId, startAt, endAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return SummarizeAPIKeyUsages(usages), nil
+}
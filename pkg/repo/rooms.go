@@ -7,6 +7,8 @@ import (
 	"errors"
 	"github.com/mylxsw/aidea-server/pkg/repo/model"
 	"github.com/mylxsw/go-utils/maps"
+	"github.com/robfig/cron/v3"
+	"strings"
 	"time"
 
 	"github.com/mylxsw/asteria/log"
@@ -156,6 +158,12 @@ func (r *RoomRepo) Create(ctx context.Context, userID int64, room *model.Rooms,
 		model.FieldRoomsMaxContext,
 		model.FieldRoomsRoomType,
 		model.FieldRoomsInitMessage,
+		model.FieldRoomsMergeUserMessages,
+		model.FieldRoomsChatDefaults,
+		model.FieldRoomsMaxImages,
+		model.FieldRoomsMaxOutputTokens,
+		model.FieldRoomsPrivate,
+		model.FieldRoomsDigestSchedule,
 	)
 
 	id, err = model.NewRoomsModel(r.db).Save(ctx, roomN)
@@ -189,6 +197,12 @@ func (r *RoomRepo) Update(ctx context.Context, userID, roomID int64, room *model
 		model.FieldRoomsMaxContext,
 		model.FieldRoomsRoomType,
 		model.FieldRoomsInitMessage,
+		model.FieldRoomsMergeUserMessages,
+		model.FieldRoomsChatDefaults,
+		model.FieldRoomsMaxImages,
+		model.FieldRoomsMaxOutputTokens,
+		model.FieldRoomsPrivate,
+		model.FieldRoomsDigestSchedule,
 	))
 
 	return err
@@ -206,6 +220,63 @@ func (r *RoomRepo) UpdateLastActiveTime(ctx context.Context, userID, roomID int6
 	return err
 }
 
+// DigestRooms 查询开启了消息摘要的房间
+func (r *RoomRepo) DigestRooms(ctx context.Context) ([]model.Rooms, error) {
+	q := query.Builder().
+		WhereNotNull(model.FieldRoomsDigestSchedule).
+		Where(model.FieldRoomsDigestSchedule, "!=", "").
+		OrderBy(model.FieldRoomsId, "ASC")
+
+	rooms, err := model.NewRoomsModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(rooms, func(item model.RoomsN, _ int) model.Rooms { return item.ToRooms() }), nil
+}
+
+// UpdateDigestState 更新房间的消息摘要状态，lastMessageID 为摘要包含的最大消息 ID
+func (r *RoomRepo) UpdateDigestState(ctx context.Context, roomID, lastMessageID int64, digestAt time.Time) error {
+	q := query.Builder().Where(model.FieldRoomsId, roomID)
+
+	_, err := model.NewRoomsModel(r.db).Update(ctx, q, model.RoomsN{
+		LastDigestMessageId: null.IntFrom(lastMessageID),
+		LastDigestAt:        null.TimeFrom(digestAt),
+	})
+
+	return err
+}
+
+// digestScheduleReference 检查摘要定时规则时使用的固定起始时间，从闰年开始检查一整年，
+// 同一个规则无论何时校验结果都相同，并且覆盖只在特定日期（如 2 月 29 日）出现的间隔
+var digestScheduleReference = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ParseDigestSchedule 解析房间的消息摘要定时规则（标准的 5 段 cron 表达式，支持 @daily 等简写），
+// 为了控制成本，任意两次相邻摘要的间隔不能少于 1 小时
+func ParseDigestSchedule(schedule string) (cron.Schedule, error) {
+	sched, err := cron.ParseStandard(strings.TrimSpace(schedule))
+	if err != nil {
+		return nil, err
+	}
+
+	end := digestScheduleReference.AddDate(1, 0, 0)
+	prev := sched.Next(digestScheduleReference)
+	for !prev.IsZero() && prev.Before(end) {
+		next := sched.Next(prev)
+		if next.IsZero() {
+			break
+		}
+
+		if next.Sub(prev) < time.Hour {
+			return nil, errors.New("interval between two digests must be at least 1 hour")
+		}
+
+		prev = next
+	}
+
+	return sched, nil
+}
+
 type GalleryRoom struct {
 	Id          int64    `json:"id"`
 	Name        string   `json:"name,omitempty"`
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// usageWebhookDeadLetterTable 用量通知的死信表，多次重试之后仍然无法送达的事件保存在这里，可以由管理员重新发送
+const usageWebhookDeadLetterTable = "usage_webhook_dead_letters"
+
+// UsageDeadLetter 无法送达的用量通知事件
+type UsageDeadLetter struct {
+	ID       int64  `json:"id"`
+	EventID  string `json:"event_id"`
+	UserID   int64  `json:"user_id"`
+	Endpoint string `json:"endpoint"`
+	// Payload 事件内容（JSON），重新发送时原样发送
+	Payload   []byte `json:"-"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	// OccurredAt 事件发生的时间（请求完成的时间），按照该时间选择需要重新发送的事件
+	OccurredAt time.Time `json:"occurred_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UsageDeadLetterCursor 死信表的分页位置，按照 (occurred_at, id) 排序，查询该位置之后的事件
+type UsageDeadLetterCursor struct {
+	OccurredAt time.Time
+	ID         int64
+}
+
+type UsageWebhookRepo struct {
+	db *sql.DB
+}
+
+func NewUsageWebhookRepo(db *sql.DB) *UsageWebhookRepo {
+	return &UsageWebhookRepo{db: db}
+}
+
+// AddDeadLetter 写入无法送达的事件
+func (r *UsageWebhookRepo) AddDeadLetter(ctx context.Context, item UsageDeadLetter) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO "+usageWebhookDeadLetterTable+" (event_id, user_id, endpoint, payload, attempts, last_error, occurred_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		item.EventID, item.UserID, item.Endpoint, string(item.Payload), item.Attempts, item.LastError, item.OccurredAt,
+	)
+
+	return err
+}
+
+// DeadLetters 查询位置 after 之后、事件发生时间早于 until 的无法送达的事件，按照事件发生的时间排序，最多返回 limit 条。
+// 使用上一页最后一个事件的位置查询下一页，不会因为仍然保留在表中的失败事件重复查询到同一页
+func (r *UsageWebhookRepo) DeadLetters(ctx context.Context, after UsageDeadLetterCursor, until time.Time, limit int64) ([]UsageDeadLetter, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT id, event_id, user_id, endpoint, payload, attempts, last_error, occurred_at, created_at FROM "+usageWebhookDeadLetterTable+" WHERE (occurred_at > ? OR (occurred_at = ? AND id > ?)) AND occurred_at < ? ORDER BY occurred_at ASC, id ASC LIMIT ?",
+		after.OccurredAt, after.OccurredAt, after.ID, until, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]UsageDeadLetter, 0)
+	for rows.Next() {
+		var item UsageDeadLetter
+		var payload string
+		if err := rows.Scan(&item.ID, &item.EventID, &item.UserID, &item.Endpoint, &payload, &item.Attempts, &item.LastError, &item.OccurredAt, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		item.Payload = []byte(payload)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// DeleteDeadLetter 删除已经重新送达的事件
+func (r *UsageWebhookRepo) DeleteDeadLetter(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM "+usageWebhookDeadLetterTable+" WHERE id = ?", id)
+	return err
+}
+
+// UpdateDeadLetter 重新发送仍然失败时，更新重试次数和最后一次的错误信息
+func (r *UsageWebhookRepo) UpdateDeadLetter(ctx context.Context, id int64, attempts int, lastError string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE "+usageWebhookDeadLetterTable+" SET attempts = ?, last_error = ? WHERE id = ?", attempts, lastError, id)
+	return err
+}
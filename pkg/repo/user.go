@@ -9,7 +9,6 @@ import (
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/repo/model"
 	"strings"
-	"time"
 
 	"github.com/mylxsw/aidea-server/config"
 	"github.com/mylxsw/asteria/log"
@@ -41,6 +40,8 @@ const (
 	UserTypeTester = 2
 	// UserTypeExtraPermission 例外用户
 	UserTypeExtraPermission = 3
+	// UserTypeSandbox 演示用户，聊天请求返回预置的回答，不请求服务提供商，不消耗智慧果
+	UserTypeSandbox = 4
 )
 
 type UserRepo struct {
@@ -647,113 +648,6 @@ func (repo *UserRepo) UpdateCustomConfig(ctx context.Context, userID int64, conf
 	})
 }
 
-const (
-	UserApiKeyStatusDisabled = 2
-	UserAPiKeyStatusActive   = 1
-)
-
-// GetUserByAPIKey 根据 API Token 获取用户信息
-func (repo *UserRepo) GetUserByAPIKey(ctx context.Context, token string) (*model.Users, error) {
-	key, err := model.NewUserApiKeyModel(repo.db).First(ctx, query.Builder().Where(model.FieldUserApiKeyToken, token))
-	if err != nil {
-		if errors.Is(err, query.ErrNoResult) {
-			return nil, ErrNotFound
-		}
-
-		return nil, err
-	}
-
-	apiKey := key.ToUserApiKey()
-	if apiKey.Status == UserApiKeyStatusDisabled {
-		return nil, ErrNotFound
-	}
-
-	if !apiKey.ValidBefore.IsZero() && apiKey.ValidBefore.Before(time.Now()) {
-		return nil, ErrNotFound
-	}
-
-	return repo.GetUserByID(ctx, apiKey.UserId)
-}
-
-// GetAPIKeys 获取用户的 API Keys
-func (repo *UserRepo) GetAPIKeys(ctx context.Context, userID int64) ([]model.UserApiKey, error) {
-	q := query.Builder().Where(model.FieldUserApiKeyUserId, userID).
-		Where(model.FieldUserApiKeyStatus, UserAPiKeyStatusActive)
-	keys, err := model.NewUserApiKeyModel(repo.db).Get(ctx, q)
-	if err != nil {
-		return nil, err
-	}
-
-	return array.Map(keys, func(key model.UserApiKeyN, _ int) model.UserApiKey {
-		item := key.ToUserApiKey()
-		item.Token = misc.MaskStr(item.Token, 6)
-		return item
-	}), nil
-}
-
-// GetAPIKey 获取用户的 API Key
-func (repo *UserRepo) GetAPIKey(ctx context.Context, userID int64, keyID int64) (*model.UserApiKey, error) {
-	key, err := model.NewUserApiKeyModel(repo.db).First(ctx, query.Builder().
-		Where(model.FieldUserApiKeyUserId, userID).
-		Where(model.FieldUserApiKeyId, keyID).
-		Where(model.FieldUserApiKeyStatus, UserAPiKeyStatusActive),
-	)
-	if err != nil {
-		if errors.Is(err, query.ErrNoResult) {
-			return nil, ErrNotFound
-		}
-
-		return nil, err
-	}
-
-	ret := key.ToUserApiKey()
-	return &ret, nil
-}
-
-// CreateAPIKey 创建一个 API Token
-func (repo *UserRepo) CreateAPIKey(ctx context.Context, userID int64, name string, validBefore time.Time) (string, error) {
-	key := model.UserApiKey{
-		UserId:      userID,
-		Name:        name,
-		ValidBefore: validBefore,
-		Status:      UserAPiKeyStatusActive,
-		Token:       fmt.Sprintf("sk-%s", misc.GenerateAPIToken(name, userID)),
-	}
-
-	allows := []string{
-		model.FieldUserApiKeyUserId,
-		model.FieldUserApiKeyName,
-		model.FieldUserApiKeyToken,
-		model.FieldUserApiKeyStatus,
-	}
-
-	if !validBefore.IsZero() {
-		allows = append(allows, model.FieldUserApiKeyValidBefore)
-	}
-
-	id, err := model.NewUserApiKeyModel(repo.db).Save(ctx, key.ToUserApiKeyN(allows...))
-	if err != nil {
-		return "", err
-	}
-
-	key.Id = id
-	return key.Token, nil
-}
-
-// DeleteAPIKey 删除一个 API Key
-func (repo *UserRepo) DeleteAPIKey(ctx context.Context, userID int64, keyID int64) error {
-	//_, err := model.NewUserApiKeyModel(repo.db).DeleteModel(ctx, query.Builder().
-	//	Where(model.FieldUserApiKeyUserId, userID).
-	//	Where(model.FieldUserApiKeyId, keyID),
-	//)
-
-	q := query.Builder().Where(model.FieldUserApiKeyUserId, userID).Where(model.FieldUserApiKeyId, keyID)
-	update := query.KV{model.FieldUserApiKeyStatus: UserApiKeyStatusDisabled}
-
-	_, err := model.NewUserApiKeyModel(repo.db).UpdateFields(ctx, update, q)
-	return err
-}
-
 // Users 查询用户列表
 func (repo *UserRepo) Users(ctx context.Context, page, perPage int64, options ...QueryOption) ([]model.Users, query.PaginateMeta, error) {
 	q := query.Builder()
@@ -0,0 +1,176 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/eloquent/query"
+	"github.com/mylxsw/go-utils/array"
+)
+
+// UserModelIDPrefix 用户自定义模型的模型 ID 前缀，完整的模型 ID 为 user-model@{code}
+const UserModelIDPrefix = "user-model@"
+
+const (
+	// UserModelVisibilityPrivate 私有，只有创建者可以使用
+	UserModelVisibilityPrivate = 1
+	// UserModelVisibilityShared 通过链接分享，知道模型 ID 的用户都可以使用，但不会出现在公开的模型列表中
+	UserModelVisibilityShared = 2
+	// UserModelVisibilityPublic 公开，所有用户都可以在模型列表中看到并使用
+	UserModelVisibilityPublic = 3
+)
+
+// UserModelID 返回用户自定义模型的模型 ID
+func UserModelID(code string) string {
+	return UserModelIDPrefix + code
+}
+
+// ParseUserModelID 解析用户自定义模型的模型 ID，返回模型标识，不是用户自定义模型时 ok 为 false
+func ParseUserModelID(modelID string) (code string, ok bool) {
+	if !strings.HasPrefix(modelID, UserModelIDPrefix) {
+		return "", false
+	}
+
+	code = strings.TrimPrefix(modelID, UserModelIDPrefix)
+	return code, code != ""
+}
+
+// UserModelAccessible 用户是否可以使用该自定义模型
+func UserModelAccessible(um model.UserModels, userID int64) bool {
+	if um.Visibility == UserModelVisibilityShared || um.Visibility == UserModelVisibilityPublic {
+		return true
+	}
+
+	return userID > 0 && um.UserId == userID
+}
+
+type UserModelRepo struct {
+	db *sql.DB
+}
+
+func NewUserModelRepo(db *sql.DB) *UserModelRepo {
+	return &UserModelRepo{db: db}
+}
+
+// UserModels 查询用户创建的自定义模型
+func (r *UserModelRepo) UserModels(ctx context.Context, userID int64) ([]model.UserModels, error) {
+	q := query.Builder().
+		Where(model.FieldUserModelsUserId, userID).
+		OrderBy(model.FieldUserModelsId, "DESC")
+
+	items, err := model.NewUserModelsModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(items, func(item model.UserModelsN, _ int) model.UserModels { return item.ToUserModels() }), nil
+}
+
+// PublicModels 查询公开的自定义模型，最新创建的在前
+func (r *UserModelRepo) PublicModels(ctx context.Context, limit int64) ([]model.UserModels, error) {
+	q := query.Builder().
+		Where(model.FieldUserModelsVisibility, UserModelVisibilityPublic).
+		OrderBy(model.FieldUserModelsId, "DESC").
+		Limit(limit)
+
+	items, err := model.NewUserModelsModel(r.db).Get(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return array.Map(items, func(item model.UserModelsN, _ int) model.UserModels { return item.ToUserModels() }), nil
+}
+
+// UserModel 查询自定义模型，模型不存在时返回 ErrNotFound，不检查使用权限
+func (r *UserModelRepo) UserModel(ctx context.Context, code string) (*model.UserModels, error) {
+	item, err := model.NewUserModelsModel(r.db).First(ctx, query.Builder().Where(model.FieldUserModelsCode, code))
+	if err != nil {
+		if errors.Is(err, query.ErrNoResult) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	ret := item.ToUserModels()
+	return &ret, nil
+}
+
+// AccessibleUserModel 查询用户可以使用的自定义模型，模型不存在或者没有使用权限时返回 ErrNotFound
+func (r *UserModelRepo) AccessibleUserModel(ctx context.Context, code string, userID int64) (*model.UserModels, error) {
+	um, err := r.UserModel(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !UserModelAccessible(*um, userID) {
+		return nil, ErrNotFound
+	}
+
+	return um, nil
+}
+
+// Create 创建自定义模型，返回生成的模型标识
+func (r *UserModelRepo) Create(ctx context.Context, userID int64, um *model.UserModels) (string, error) {
+	um.UserId = userID
+	um.Code = misc.ShortUUID()
+
+	_, err := model.NewUserModelsModel(r.db).Save(ctx, um.ToUserModelsN(
+		model.FieldUserModelsUserId,
+		model.FieldUserModelsCode,
+		model.FieldUserModelsModel,
+		model.FieldUserModelsName,
+		model.FieldUserModelsDescription,
+		model.FieldUserModelsAvatarUrl,
+		model.FieldUserModelsSystemPrompt,
+		model.FieldUserModelsChatDefaults,
+		model.FieldUserModelsVisibility,
+	))
+	if err != nil {
+		return "", err
+	}
+
+	return um.Code, nil
+}
+
+// Update 更新用户创建的自定义模型，模型不存在时返回 ErrNotFound
+func (r *UserModelRepo) Update(ctx context.Context, userID int64, code string, um *model.UserModels) error {
+	q := query.Builder().
+		Where(model.FieldUserModelsUserId, userID).
+		Where(model.FieldUserModelsCode, code)
+
+	exist, err := model.NewUserModelsModel(r.db).Exists(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	if !exist {
+		return ErrNotFound
+	}
+
+	_, err = model.NewUserModelsModel(r.db).Update(ctx, q, um.ToUserModelsN(
+		model.FieldUserModelsModel,
+		model.FieldUserModelsName,
+		model.FieldUserModelsDescription,
+		model.FieldUserModelsAvatarUrl,
+		model.FieldUserModelsSystemPrompt,
+		model.FieldUserModelsChatDefaults,
+		model.FieldUserModelsVisibility,
+	))
+
+	return err
+}
+
+// Remove 删除用户创建的自定义模型
+func (r *UserModelRepo) Remove(ctx context.Context, userID int64, code string) error {
+	q := query.Builder().
+		Where(model.FieldUserModelsUserId, userID).
+		Where(model.FieldUserModelsCode, code)
+
+	_, err := model.NewUserModelsModel(r.db).Delete(ctx, q)
+	return err
+}
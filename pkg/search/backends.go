@@ -0,0 +1,142 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Bing Bing Web Search API，参考 https://learn.microsoft.com/en-us/bing/search-apis/bing-web-search/reference/endpoints
+type Bing struct {
+	server string
+	key    string
+	client *http.Client
+}
+
+func NewBing(server, key string, client *http.Client) *Bing {
+	if server == "" {
+		server = "https://api.bing.microsoft.com"
+	}
+
+	return &Bing{server: strings.TrimSuffix(server, "/"), key: key, client: client}
+}
+
+func (b *Bing) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("count", strconv.Itoa(count))
+	params.Set("textDecorations", "false")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.server+"/v7.0/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", b.key)
+
+	var resp struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	if err := doJSON(b.client, req, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(resp.WebPages.Value))
+	for _, item := range resp.WebPages.Value {
+		results = append(results, Result{Title: item.Name, URL: item.URL, Snippet: item.Snippet})
+	}
+
+	return limitResults(results, count), nil
+}
+
+// Serper Serper.dev 提供的 Google 搜索接口，参考 https://serper.dev
+type Serper struct {
+	server string
+	key    string
+	client *http.Client
+}
+
+func NewSerper(server, key string, client *http.Client) *Serper {
+	if server == "" {
+		server = "https://google.serper.dev"
+	}
+
+	return &Serper{server: strings.TrimSuffix(server, "/"), key: key, client: client}
+}
+
+func (s *Serper) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	body, _ := json.Marshal(map[string]any{"q": query, "num": count})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.server+"/search", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-KEY", s.key)
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		Organic []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"organic"`
+	}
+	if err := doJSON(s.client, req, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(resp.Organic))
+	for _, item := range resp.Organic {
+		results = append(results, Result{Title: item.Title, URL: item.Link, Snippet: item.Snippet})
+	}
+
+	return limitResults(results, count), nil
+}
+
+// SearXNG 自部署的 SearXNG 元搜索服务，需要在服务端的配置中开启 json 格式（search.formats）
+type SearXNG struct {
+	server string
+	client *http.Client
+}
+
+func NewSearXNG(server string, client *http.Client) *SearXNG {
+	return &SearXNG{server: strings.TrimSuffix(server, "/"), client: client}
+}
+
+func (s *SearXNG) Search(ctx context.Context, query string, count int) ([]Result, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.server+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := doJSON(s.client, req, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(resp.Results))
+	for _, item := range resp.Results {
+		results = append(results, Result{Title: item.Title, URL: item.URL, Snippet: item.Content})
+	}
+
+	return limitResults(results, count), nil
+}
@@ -0,0 +1,210 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxPageSize 读取网页内容的最大大小
+	maxPageSize = 2 * 1024 * 1024
+	// minMainContentRunes 正文区域（article/main）的最小长度，过短时使用整个页面的内容
+	minMainContentRunes = 200
+)
+
+// ErrPrivateAddress 网页地址指向内网地址，不允许访问
+var ErrPrivateAddress = errors.New("private address is not allowed")
+
+// PageFetcher 读取网页并提取正文，只允许访问公网地址
+type PageFetcher struct {
+	client *http.Client
+}
+
+func NewPageFetcher(timeout time.Duration) *PageFetcher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: DenyPrivateAddress}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &PageFetcher{client: &http.Client{Timeout: timeout, Transport: transport}}
+}
+
+// DenyPrivateAddress 拒绝连接回环、内网以及链路本地地址，避免通过用户提供的地址（搜索结果、图片地址等）访问服务端的内网（SSRF），
+// 用作 net.Dialer 的 Control，重定向之后的连接同样会被检查
+func DenyPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// FetchText 读取网页并提取正文，最多返回 maxRunes 个字符，只支持 HTML 和纯文本
+func (f *PageFetcher) FetchText(ctx context.Context, pageURL string, maxRunes int) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("unsupported url: %s", pageURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; AIdeaBot/1.0)")
+	req.Header.Set("Accept", "text/html,text/plain;q=0.9")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body := io.LimitReader(resp.Body, maxPageSize)
+	contentType := resp.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "text/plain"):
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+
+		return truncateRunes(collapseSpaces(string(data)), maxRunes), nil
+	case contentType == "" || strings.Contains(contentType, "html"):
+		text, err := ExtractText(body)
+		if err != nil {
+			return "", err
+		}
+
+		return truncateRunes(text, maxRunes), nil
+	default:
+		return "", fmt.Errorf("unsupported content type: %s", contentType)
+	}
+}
+
+// ExtractText 提取 HTML 页面的正文：去掉脚本、样式、导航、页眉页脚等内容，优先使用 article/main 区域，按照块级元素分段
+func ExtractText(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+
+	for _, tag := range []atom.Atom{atom.Article, atom.Main} {
+		if node := findElement(doc, tag); node != nil {
+			if text := nodeText(node); utf8.RuneCountInString(text) >= minMainContentRunes {
+				return text, nil
+			}
+		}
+	}
+
+	if body := findElement(doc, atom.Body); body != nil {
+		return nodeText(body), nil
+	}
+
+	return nodeText(doc), nil
+}
+
+// skippedElements 不包含正文的元素
+var skippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Svg: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Form: true,
+	atom.Button: true, atom.Iframe: true, atom.Select: true,
+}
+
+// blockElements 块级元素，前后换行
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true, atom.Br: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Li: true, atom.Tr: true, atom.Pre: true, atom.Blockquote: true, atom.Table: true, atom.Ul: true, atom.Ol: true,
+}
+
+func findElement(node *html.Node, tag atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == tag {
+		return node
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, tag); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func nodeText(node *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if skippedElements[n.DataAtom] {
+				return
+			}
+
+			if blockElements[n.DataAtom] {
+				sb.WriteString("\n")
+				defer sb.WriteString("\n")
+			}
+		case html.CommentNode:
+			return
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	lines := strings.Split(sb.String(), "\n")
+	ret := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = collapseSpaces(line); line != "" {
+			ret = append(ret, line)
+		}
+	}
+
+	return strings.Join(ret, "\n")
+}
+
+// collapseSpaces 连续的空白字符合并为一个空格
+func collapseSpaces(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func truncateRunes(text string, maxRunes int) string {
+	if maxRunes <= 0 || utf8.RuneCountInString(text) <= maxRunes {
+		return text
+	}
+
+	return string([]rune(text)[:maxRunes])
+}
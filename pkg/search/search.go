@@ -0,0 +1,116 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	BackendBing    = "bing"
+	BackendSerper  = "serper"
+	BackendSearXNG = "searxng"
+)
+
+// maxResponseSize 搜索接口响应体的最大大小
+const maxResponseSize = 2 * 1024 * 1024
+
+// Result 一条搜索结果
+type Result struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Backend 网页搜索服务
+type Backend interface {
+	// Search 搜索关键词，最多返回 count 条结果
+	Search(ctx context.Context, query string, count int) ([]Result, error)
+}
+
+// Config 网页搜索服务的配置
+type Config struct {
+	// Backend 搜索服务：bing/serper/searxng
+	Backend string
+	// Server 搜索服务的地址，Bing 和 Serper 为空时使用官方地址，SearXNG 为自部署服务的地址
+	Server string
+	// Key 搜索服务的 API Key，SearXNG 不需要
+	Key string
+}
+
+// New 根据配置创建搜索服务
+func New(conf Config, client *http.Client) (Backend, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	switch strings.ToLower(conf.Backend) {
+	case BackendBing:
+		if conf.Key == "" {
+			return nil, errors.New("bing search requires an api key")
+		}
+
+		return NewBing(conf.Server, conf.Key, client), nil
+	case BackendSerper:
+		if conf.Key == "" {
+			return nil, errors.New("serper search requires an api key")
+		}
+
+		return NewSerper(conf.Server, conf.Key, client), nil
+	case BackendSearXNG:
+		if conf.Server == "" {
+			return nil, errors.New("searxng search requires the server address")
+		}
+
+		return NewSearXNG(conf.Server, client), nil
+	default:
+		return nil, fmt.Errorf("unsupported search backend: %s", conf.Backend)
+	}
+}
+
+// doJSON 发送请求并解析 JSON 响应，响应状态码不是 200 时返回错误
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("read response failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode response failed: %w", err)
+	}
+
+	return nil
+}
+
+// limitResults 去掉没有地址的结果，最多保留 count 条
+func limitResults(results []Result, count int) []Result {
+	ret := make([]Result, 0, len(results))
+	for _, item := range results {
+		if item.URL == "" {
+			continue
+		}
+
+		if count > 0 && len(ret) >= count {
+			break
+		}
+
+		ret = append(ret, item)
+	}
+
+	return ret
+}
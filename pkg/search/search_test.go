@@ -0,0 +1,96 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestBackends(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v7.0/search" && r.Header.Get("Ocp-Apim-Subscription-Key") == "bing-key":
+			_, _ = w.Write([]byte(`{"webPages": {"value": [{"name": "A", "url": "https://a.com", "snippet": "a"}, {"name": "B", "url": "", "snippet": "b"}, {"name": "C", "url": "https://c.com"}]}}`))
+		case r.URL.Path == "/search" && r.Method == http.MethodPost && r.Header.Get("X-API-KEY") == "serper-key":
+			_, _ = w.Write([]byte(`{"organic": [{"title": "A", "link": "https://a.com", "snippet": "a"}]}`))
+		case r.URL.Path == "/search" && r.URL.Query().Get("format") == "json":
+			_, _ = w.Write([]byte(`{"results": [{"title": "A", "url": "https://a.com", "content": "a"}]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`unauthorized`))
+		}
+	}))
+	defer server.Close()
+
+	expected := Result{Title: "A", URL: "https://a.com", Snippet: "a"}
+
+	// 没有地址的结果被忽略
+	bing, err := New(Config{Backend: BackendBing, Server: server.URL, Key: "bing-key"}, nil)
+	assert.NoError(t, err)
+	results, err := bing.Search(context.TODO(), "test", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []Result{expected, {Title: "C", URL: "https://c.com"}}, results)
+
+	results, err = bing.Search(context.TODO(), "test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+
+	serper, err := New(Config{Backend: BackendSerper, Server: server.URL, Key: "serper-key"}, nil)
+	assert.NoError(t, err)
+	results, err = serper.Search(context.TODO(), "test", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []Result{expected}, results)
+
+	searxng, err := New(Config{Backend: BackendSearXNG, Server: server.URL}, nil)
+	assert.NoError(t, err)
+	results, err = searxng.Search(context.TODO(), "test", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []Result{expected}, results)
+
+	// 认证失败时返回错误
+	bing, _ = New(Config{Backend: BackendBing, Server: server.URL, Key: "invalid"}, nil)
+	_, err = bing.Search(context.TODO(), "test", 5)
+	assert.True(t, err != nil)
+
+	_, err = New(Config{Backend: BackendSerper}, nil)
+	assert.True(t, err != nil)
+	_, err = New(Config{Backend: "google"}, nil)
+	assert.True(t, err != nil)
+}
+
+func TestExtractText(t *testing.T) {
+	page := `<html><head><title>标题</title><script>var a = 1;</script></head><body>
+<nav><a href="/">首页</a></nav>
+<div><h1>文章标题</h1><p>第一段   内容</p><p>第二段<br>换行</p></div>
+<footer>版权所有</footer>
+</body></html>`
+
+	text, err := ExtractText(strings.NewReader(page))
+	assert.NoError(t, err)
+	assert.Equal(t, "文章标题\n第一段 内容\n第二段\n换行", text)
+
+	// 正文区域足够长时只使用正文区域
+	article := strings.Repeat("正文", 100)
+	text, err = ExtractText(strings.NewReader(`<body><div>侧边栏</div><article><p>` + article + `</p></article></body>`))
+	assert.NoError(t, err)
+	assert.Equal(t, article, text)
+}
+
+func TestPageFetcher_PrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<p>internal</p>`))
+	}))
+	defer server.Close()
+
+	_, err := NewPageFetcher(time.Second).FetchText(context.TODO(), server.URL, 100)
+	assert.True(t, errors.Is(err, ErrPrivateAddress))
+
+	_, err = NewPageFetcher(time.Second).FetchText(context.TODO(), "file:///etc/passwd", 100)
+	assert.True(t, err != nil)
+}
@@ -0,0 +1,207 @@
+package secret
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrMasterKeyNotConfigured 未配置主密钥，无法解密已加密的内容
+	ErrMasterKeyNotConfigured = errors.New("未配置密钥加密的主密钥（channel-secret-master-key）")
+	// ErrInvalidCiphertext 密文格式不正确
+	ErrInvalidCiphertext = errors.New("密文格式不正确")
+)
+
+// encryptedPrefix 加密内容的前缀，用于区分加密前写入的明文
+const encryptedPrefix = "enc:v1:"
+
+// maskedPrefix 脱敏后的内容前缀
+const maskedPrefix = "****"
+
+// KeyWrapper 数据密钥的加密（包装）与解密，可以使用本地主密钥或者 KMS 实现
+type KeyWrapper interface {
+	// WrapKey 使用主密钥加密数据密钥
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey 使用主密钥解密数据密钥
+	UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error)
+}
+
+// LocalKeyWrapper 使用配置文件中的主密钥加密数据密钥
+type LocalKeyWrapper struct {
+	key []byte
+}
+
+// NewLocalKeyWrapper 创建本地主密钥的 KeyWrapper，主密钥可以是任意字符串，使用其 SHA256 作为 AES-256 密钥
+func NewLocalKeyWrapper(masterKey string) *LocalKeyWrapper {
+	key := sha256.Sum256([]byte(masterKey))
+	return &LocalKeyWrapper{key: key[:]}
+}
+
+func (w *LocalKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return seal(w.key, dataKey)
+}
+
+func (w *LocalKeyWrapper) UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	return open(w.key, wrappedKey)
+}
+
+// Envelope 信封加密：每个密文使用独立的随机数据密钥加密，数据密钥由 KeyWrapper 加密后与密文一起存储
+//
+// 未配置 KeyWrapper 时，Encrypt 原样返回明文，Decrypt 只能处理明文
+type Envelope struct {
+	wrapper KeyWrapper
+}
+
+// NewEnvelope 创建信封加密，wrapper 为 nil 时不加密
+func NewEnvelope(wrapper KeyWrapper) *Envelope {
+	return &Envelope{wrapper: wrapper}
+}
+
+// NewEnvelopeWithMasterKey 使用本地主密钥创建信封加密，masterKey 为空时不加密
+func NewEnvelopeWithMasterKey(masterKey string) *Envelope {
+	if masterKey == "" {
+		return NewEnvelope(nil)
+	}
+
+	return NewEnvelope(NewLocalKeyWrapper(masterKey))
+}
+
+// Enabled 是否配置了主密钥
+func (e *Envelope) Enabled() bool {
+	return e != nil && e.wrapper != nil
+}
+
+// Encrypt 加密，空字符串以及已经加密的内容原样返回
+func (e *Envelope) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	if !e.Enabled() || plaintext == "" || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("generate data key failed: %w", err)
+	}
+
+	wrappedKey, err := e.wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("wrap data key failed: %w", err)
+	}
+
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt 解密，未加密的内容（加密功能启用前写入的明文）原样返回
+func (e *Envelope) Decrypt(ctx context.Context, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	if !e.Enabled() {
+		return "", ErrMasterKeyNotConfigured
+	}
+
+	segments := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(segments) != 2 {
+		return "", ErrInvalidCiphertext
+	}
+
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(segments[0])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	dataKey, err := e.wrapper.UnwrapKey(ctx, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key failed: %w", err)
+	}
+
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// IsEncrypted 判断内容是否已经加密
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Mask 返回脱敏后的明文，只保留最后 4 个字符
+func Mask(plaintext string) string {
+	if plaintext == "" {
+		return ""
+	}
+
+	if len(plaintext) <= 8 {
+		return maskedPrefix
+	}
+
+	return maskedPrefix + plaintext[len(plaintext)-4:]
+}
+
+// IsMasked 判断内容是否为 Mask 脱敏后的结果，用于更新时识别客户端回传的脱敏内容
+func IsMasked(value string) bool {
+	return strings.HasPrefix(value, maskedPrefix)
+}
+
+// seal 使用 AES-GCM 加密，返回 nonce + 密文
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce failed: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open 使用 AES-GCM 解密 seal 的结果
+func open(key []byte, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
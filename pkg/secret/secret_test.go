@@ -0,0 +1,61 @@
+package secret_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/secret"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.TODO()
+	envelope := secret.NewEnvelopeWithMasterKey("master-key")
+
+	encrypted, err := envelope.Encrypt(ctx, "sk-1234567890abcd")
+	assert.NoError(t, err)
+	assert.True(t, secret.IsEncrypted(encrypted))
+	assert.True(t, !strings.Contains(encrypted, "sk-1234567890abcd"))
+
+	// 每次加密使用不同的数据密钥
+	another, err := envelope.Encrypt(ctx, "sk-1234567890abcd")
+	assert.NoError(t, err)
+	assert.True(t, encrypted != another)
+
+	// 已经加密的内容不会重复加密
+	again, err := envelope.Encrypt(ctx, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, again)
+
+	plaintext, err := envelope.Decrypt(ctx, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-1234567890abcd", plaintext)
+
+	// 加密功能启用前写入的明文原样返回
+	plaintext, err = envelope.Decrypt(ctx, "sk-plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-plain", plaintext)
+
+	// 主密钥不正确
+	_, err = secret.NewEnvelopeWithMasterKey("another-key").Decrypt(ctx, encrypted)
+	assert.True(t, err != nil)
+
+	// 未配置主密钥
+	disabled := secret.NewEnvelopeWithMasterKey("")
+	value, err := disabled.Encrypt(ctx, "sk-plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-plain", value)
+
+	_, err = disabled.Decrypt(ctx, encrypted)
+	assert.True(t, errors.Is(err, secret.ErrMasterKeyNotConfigured))
+}
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "****abcd", secret.Mask("sk-1234567890abcd"))
+	assert.Equal(t, "****", secret.Mask("short"))
+	assert.Equal(t, "", secret.Mask(""))
+	assert.True(t, secret.IsMasked(secret.Mask("sk-1234567890abcd")))
+	assert.True(t, !secret.IsMasked("sk-1234567890abcd"))
+}
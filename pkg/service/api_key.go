@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/glacier/infra"
+)
+
+const (
+	// apiKeyUsageDays API Key 列表中统计使用量的天数
+	apiKeyUsageDays = 30
+	// apiKeyTouchInterval 更新 API Key 最后使用时间的最小间隔，避免每次请求都写数据库
+	apiKeyTouchInterval = time.Minute
+)
+
+// APIKeyService 用户自助管理的 API Key，每个 Key 可以单独限制可用的模型与请求频率
+type APIKeyService struct {
+	rep   *repo.Repository `autowire:"@"`
+	users *UserService     `autowire:"@"`
+}
+
+func NewAPIKeyService(resolver infra.Resolver) *APIKeyService {
+	svc := &APIKeyService{}
+	resolver.MustAutoWire(svc)
+
+	return svc
+}
+
+// APIKeyWithUsage API Key 及其最近 30 天的使用量
+type APIKeyWithUsage struct {
+	repo.APIKey
+	Usage repo.APIKeyUsage `json:"usage"`
+}
+
+// Keys 获取用户的 API Key 列表，包含每个 Key 最近 30 天的使用量
+func (svc *APIKeyService) Keys(ctx context.Context, userID int64) ([]APIKeyWithUsage, error) {
+	keys, err := svc.rep.APIKey.Keys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := svc.usages(ctx, userID)
+
+	ret := make([]APIKeyWithUsage, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, APIKeyWithUsage{APIKey: key, Usage: usages[key.ID]})
+	}
+
+	return ret, nil
+}
+
+// Key 获取用户的 API Key 详情，包含最近 30 天的使用量
+func (svc *APIKeyService) Key(ctx context.Context, userID int64, keyID int64) (*APIKeyWithUsage, error) {
+	key, err := svc.rep.APIKey.Key(ctx, userID, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIKeyWithUsage{APIKey: *key, Usage: svc.usages(ctx, userID)[key.ID]}, nil
+}
+
+// usages 用户每个 API Key 最近 30 天的使用量，查询失败时只记录日志，不影响 Key 列表的展示
+func (svc *APIKeyService) usages(ctx context.Context, userID int64) map[int64]repo.APIKeyUsage {
+	endAt := time.Now()
+	usages, err := svc.rep.Quota.GetAPIKeyUsages(ctx, userID, endAt.AddDate(0, 0, -apiKeyUsageDays), endAt)
+	if err != nil {
+		log.F(log.M{"user_id": userID}).Errorf("get api key usages failed: %v", err)
+		return map[int64]repo.APIKeyUsage{}
+	}
+
+	return usages
+}
+
+// Create 创建 API Key，返回的明文 Key 只有这一次，之后无法再次查看
+func (svc *APIKeyService) Create(ctx context.Context, userID int64, opts repo.APIKeyOptions) (*repo.APIKey, string, error) {
+	return svc.rep.APIKey.Create(ctx, userID, opts)
+}
+
+// Revoke 吊销 API Key，吊销后使用该 Key 的请求立即失效
+func (svc *APIKeyService) Revoke(ctx context.Context, userID int64, keyID int64) error {
+	return svc.rep.APIKey.Revoke(ctx, userID, keyID)
+}
+
+// Authenticate 校验请求中携带的 API Key，返回 Key 所属的用户以及 Key 的限制。
+// Key 不存在、已吊销或者已过期时返回 repo.ErrNotFound
+func (svc *APIKeyService) Authenticate(ctx context.Context, credential string) (*model.Users, *repo.APIKey, error) {
+	key, err := svc.rep.APIKey.Authenticate(ctx, credential)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := svc.users.GetUserByID(ctx, key.UserID, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if now := time.Now(); now.Sub(key.LastUsedAt) > apiKeyTouchInterval {
+		if err := svc.rep.APIKey.TouchLastUsed(ctx, key.ID, now); err != nil {
+			log.F(log.M{"user_id": key.UserID, "key_id": key.ID}).Warningf("update api key last used time failed: %v", err)
+		}
+	}
+
+	return user, key, nil
+}
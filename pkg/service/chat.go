@@ -77,28 +77,48 @@ type ChannelType struct {
 	Name    string `json:"name"`
 	Display string `json:"display,omitempty"`
 	Dynamic bool   `json:"dynamic"`
+	// MaxPayloadSize 服务提供商允许的单次请求最大内容大小（字节），为 0 时不限制
+	MaxPayloadSize int64 `json:"max_payload_size,omitempty"`
+	// Tools 是否支持工具调用（包括上下文中的工具调用和工具调用结果），不支持时上下文中的工具调用转换为纯文本发送
+	Tools bool `json:"tools,omitempty"`
+	// ToolsRequireDefinitions 上下文中包含工具调用时，请求中必须同时定义工具（如 Anthropic），没有定义工具时上下文中的工具调用转换为纯文本发送
+	ToolsRequireDefinitions bool `json:"tools_require_definitions,omitempty"`
+}
+
+// channelTypes 支持的渠道类型列表
+var channelTypes = []ChannelType{
+	{Name: ProviderOpenAI, Dynamic: true, Display: "OpenAI", MaxPayloadSize: 20 * 1024 * 1024, Tools: true},
+	{Name: ProviderOneAPI, Dynamic: true, Display: "OneAPI"},
+	{Name: ProviderOpenRouter, Dynamic: true, Display: "OpenRouter"},
+
+	{Name: ProviderXunFei, Dynamic: false, Display: "讯飞星火"},
+	{Name: ProviderWenXin, Dynamic: false, Display: "文心千帆"},
+	{Name: ProviderDashscope, Dynamic: false, Display: "阿里灵积"},
+	{Name: ProviderSenseNova, Dynamic: false, Display: "商汤"},
+	{Name: ProviderTencent, Dynamic: false, Display: "腾讯"},
+	{Name: ProviderBaiChuan, Dynamic: false, Display: "百川"},
+	{Name: Provider360, Dynamic: false, Display: "360"},
+	{Name: ProviderSky, Dynamic: false, Display: "昆仑万维"},
+	{Name: ProviderZhipu, Dynamic: false, Display: "智谱"},
+	{Name: ProviderMoonshot, Dynamic: false, Display: "月之暗面"},
+	{Name: ProviderGoogle, Dynamic: false, Display: "Google", MaxPayloadSize: 20 * 1024 * 1024, Tools: true},
+	{Name: ProviderAnthropic, Dynamic: false, Display: "Anthropic", MaxPayloadSize: 32 * 1024 * 1024, Tools: true, ToolsRequireDefinitions: true},
 }
 
 // ChannelTypes 支持的渠道类型列表
 func (svc *ChatService) ChannelTypes() []ChannelType {
-	return []ChannelType{
-		{Name: ProviderOpenAI, Dynamic: true, Display: "OpenAI"},
-		{Name: ProviderOneAPI, Dynamic: true, Display: "OneAPI"},
-		{Name: ProviderOpenRouter, Dynamic: true, Display: "OpenRouter"},
-
-		{Name: ProviderXunFei, Dynamic: false, Display: "讯飞星火"},
-		{Name: ProviderWenXin, Dynamic: false, Display: "文心千帆"},
-		{Name: ProviderDashscope, Dynamic: false, Display: "阿里灵积"},
-		{Name: ProviderSenseNova, Dynamic: false, Display: "商汤"},
-		{Name: ProviderTencent, Dynamic: false, Display: "腾讯"},
-		{Name: ProviderBaiChuan, Dynamic: false, Display: "百川"},
-		{Name: Provider360, Dynamic: false, Display: "360"},
-		{Name: ProviderSky, Dynamic: false, Display: "昆仑万维"},
-		{Name: ProviderZhipu, Dynamic: false, Display: "智谱"},
-		{Name: ProviderMoonshot, Dynamic: false, Display: "月之暗面"},
-		{Name: ProviderGoogle, Dynamic: false, Display: "Google"},
-		{Name: ProviderAnthropic, Dynamic: false, Display: "Anthropic"},
+	return channelTypes
+}
+
+// LookupChannelType 根据名称查询渠道类型
+func LookupChannelType(name string) (ChannelType, bool) {
+	for _, t := range channelTypes {
+		if t.Name == name {
+			return t, true
+		}
 	}
+
+	return ChannelType{}, false
 }
 
 // TODO 缓存
@@ -147,16 +167,26 @@ func PureModelID(modelID string) string {
 
 // TODO 缓存
 func (svc *ChatService) Model(ctx context.Context, modelID string) *repo.Model {
+	ret, err := svc.QueryModel(ctx, modelID)
+	if err != nil {
+		log.Errorf("get model %s failed: %v", modelID, err)
+		return nil
+	}
+
+	return ret
+}
+
+// QueryModel 查询模型信息，模型不存在时返回 repo.ErrNotFound，查询失败（如数据库不可用）时返回原始错误
+func (svc *ChatService) QueryModel(ctx context.Context, modelID string) (*repo.Model, error) {
 	modelID = PureModelID(modelID)
 
 	ret, err := svc.rep.Model.GetModel(ctx, modelID)
 	if err != nil {
-		log.Errorf("get model %s failed: %v", modelID, err)
-		return nil
+		return nil, err
 	}
 
 	ret.Status = ternary.If(svc.isModelEnabled(*ret), repo.ModelStatusEnabled, repo.ModelStatusDisabled)
-	return ret
+	return ret, nil
 }
 
 // isModelEnabled 判断模型是否启用
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mylxsw/asteria/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// AnswerFeedback 用户对回答的负面反馈
+type AnswerFeedback string
+
+const (
+	// AnswerFeedbackThumbsDown 点踩
+	AnswerFeedbackThumbsDown AnswerFeedback = "thumbs_down"
+	// AnswerFeedbackRegenerate 重新生成
+	AnswerFeedbackRegenerate AnswerFeedback = "regenerate"
+)
+
+// ErrInvalidFeedback 不支持的反馈类型
+var ErrInvalidFeedback = errors.New("不支持的反馈类型")
+
+// answerFeedbackCounter 用户对回答的负面反馈次数统计，avoiding 为反馈时房间中是否已经有需要避开的渠道（即回答是在避开渠道之后生成的），
+// 与 aidea_chat_channel_avoidance_count 一起用于判断避开渠道之后，重复的负面反馈是否减少
+var answerFeedbackCounter = newAnswerFeedbackCounter(prometheus.DefaultRegisterer)
+
+func newAnswerFeedbackCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "aidea",
+		Name:      "chat_answer_feedback_count",
+		Help:      "negative answer feedbacks by kind and whether the room was already avoiding channels",
+	}, []string{"kind", "avoiding"})
+
+	if err := registerer.Register(counter); err != nil {
+		log.Errorf("register answer feedback metrics failed: %v", err)
+	}
+
+	return counter
+}
+
+func avoidChannelsKey(userID, roomID int64) string {
+	return fmt.Sprintf("chat-room:%d:%d:avoid-channels", userID, roomID)
+}
+
+// RecordAnswerFeedback 记录用户对回答的负面反馈（点踩、重新生成），生成该回答的渠道加入房间需要避开的渠道（参考 AvoidedChannels）。
+// 回答不存在时返回 repo.ErrNotFound，回答没有记录渠道（如使用配置文件中的服务提供商）时只统计反馈次数
+func (svc *ChatService) RecordAnswerFeedback(ctx context.Context, userID, messageID int64, kind AnswerFeedback) error {
+	if kind != AnswerFeedbackThumbsDown && kind != AnswerFeedbackRegenerate {
+		return ErrInvalidFeedback
+	}
+
+	msg, err := svc.rep.Message.UserAnswer(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	avoided, err := svc.AvoidedChannels(ctx, userID, msg.RoomId)
+	if err != nil {
+		return err
+	}
+	answerFeedbackCounter.WithLabelValues(string(kind), strconv.FormatBool(len(avoided) > 0)).Inc()
+
+	if msg.ChannelId <= 0 || msg.RoomId <= 0 || svc.conf.ChatAvoidChannelTurns <= 0 {
+		return nil
+	}
+
+	// 同一个渠道再次出现时更新时间，只保留最近 ChatAvoidChannelTurns 个渠道
+	key := avoidChannelsKey(userID, msg.RoomId)
+	pipe := svc.rds.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Unix()), Member: msg.ChannelId})
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-svc.conf.ChatAvoidChannelTurns-1))
+	pipe.Expire(ctx, key, svc.avoidChannelsTTL())
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+// AvoidedChannels 查询房间中需要避开的渠道：最近的 chat-avoid-channel-turns 次负面反馈中生成回答的渠道，
+// 只包含 chat-avoid-channel-ttl 之内的记录，选择服务提供商时作为软性偏好（参考 chat.Request.AvoidChannels）
+func (svc *ChatService) AvoidedChannels(ctx context.Context, userID, roomID int64) ([]int64, error) {
+	if userID <= 0 || roomID <= 0 || svc.conf.ChatAvoidChannelTurns <= 0 {
+		return nil, nil
+	}
+
+	since := time.Now().Add(-svc.avoidChannelsTTL()).Unix()
+	members, err := svc.rds.ZRangeByScore(ctx, avoidChannelsKey(userID, roomID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	channels := make([]int64, 0, len(members))
+	for _, member := range members {
+		if id, err := strconv.ParseInt(member, 10, 64); err == nil {
+			channels = append(channels, id)
+		}
+	}
+
+	return channels, nil
+}
+
+func (svc *ChatService) avoidChannelsTTL() time.Duration {
+	return time.Duration(max(svc.conf.ChatAvoidChannelTTL, 1)) * time.Minute
+}
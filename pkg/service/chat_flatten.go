@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+)
+
+// flattenedChannelsKey 自动开启 flatten_multipart 的渠道集合，成员为渠道标识（如 channel:1、provider:openai）
+const flattenedChannelsKey = "chat-channel:flatten-multipart"
+
+// IsChannelFlattened 渠道是否已经自动开启 flatten_multipart（返回过 invalid content type 错误）
+func (svc *ChatService) IsChannelFlattened(ctx context.Context, key string) (bool, error) {
+	return svc.rds.SIsMember(ctx, flattenedChannelsKey, key).Result()
+}
+
+// FlattenChannel 记录渠道自动开启 flatten_multipart，所有实例共享，已经记录过时返回 false
+func (svc *ChatService) FlattenChannel(ctx context.Context, key string) (bool, error) {
+	added, err := svc.rds.SAdd(ctx, flattenedChannelsKey, key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return added > 0, nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrInputSessionNotFound 增量输入会话不存在或已过期
+	ErrInputSessionNotFound = errors.New("会话不存在或已过期")
+	// ErrTooManyInputSessions 用户未提交的增量输入会话数量超过限制
+	ErrTooManyInputSessions = errors.New("未提交的会话数量过多，请先提交或等待会话过期")
+	// ErrInputSessionTooLarge 增量输入会话中追加的内容超过长度限制
+	ErrInputSessionTooLarge = errors.New("会话内容过长")
+)
+
+func inputSessionKey(userID, roomID int64) string {
+	return fmt.Sprintf("chat-input-session:%d:%d", userID, roomID)
+}
+
+func inputSessionFragmentsKey(userID, roomID int64) string {
+	return fmt.Sprintf("chat-input-session:%d:%d:fragments", userID, roomID)
+}
+
+// inputSessionIndexKey 用户所有未提交的会话，成员为房间 ID，分值为过期时间
+func inputSessionIndexKey(userID int64) string {
+	return fmt.Sprintf("chat-input-session:%d:index", userID)
+}
+
+// OpenInputSession 保存用户在房间中打开的增量输入会话，request 为会话的请求内容，size 为最后一条用户消息的长度。
+// 房间中已经存在未提交的会话时会被替换；用户其它房间中未提交的会话已经达到 maxSessions 个时返回 ErrTooManyInputSessions
+func (svc *ChatService) OpenInputSession(ctx context.Context, userID, roomID int64, request []byte, size int, ttl time.Duration, maxSessions int) error {
+	indexKey := inputSessionIndexKey(userID)
+	now := time.Now()
+	if err := svc.rds.ZRemRangeByScore(ctx, indexKey, "-inf", strconv.FormatInt(now.Unix(), 10)).Err(); err != nil {
+		return err
+	}
+
+	member := strconv.FormatInt(roomID, 10)
+	if maxSessions > 0 {
+		if _, err := svc.rds.ZScore(ctx, indexKey, member).Result(); errors.Is(err, redis.Nil) {
+			count, err := svc.rds.ZCard(ctx, indexKey).Result()
+			if err != nil {
+				return err
+			}
+
+			if count >= int64(maxSessions) {
+				return ErrTooManyInputSessions
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	key := inputSessionKey(userID, roomID)
+	pipe := svc.rds.TxPipeline()
+	pipe.Del(ctx, key, inputSessionFragmentsKey(userID, roomID))
+	pipe.HSet(ctx, key, "request", request, "size", size)
+	pipe.Expire(ctx, key, ttl)
+	pipe.ZAdd(ctx, indexKey, redis.Z{Score: float64(now.Add(ttl).Unix()), Member: member})
+	pipe.Expire(ctx, indexKey, ttl)
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// AppendInputSession 追加文本片段到会话中，size 为片段的长度，每次追加都会刷新会话的过期时间。
+// 会话不存在时返回 ErrInputSessionNotFound，追加后的总长度超过 maxSize 时返回 ErrInputSessionTooLarge（片段不会被追加）
+func (svc *ChatService) AppendInputSession(ctx context.Context, userID, roomID int64, fragment string, size int, ttl time.Duration, maxSize int) error {
+	key := inputSessionKey(userID, roomID)
+	exists, err := svc.rds.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	if exists == 0 {
+		return ErrInputSessionNotFound
+	}
+
+	total, err := svc.rds.HIncrBy(ctx, key, "size", int64(size)).Result()
+	if err != nil {
+		return err
+	}
+
+	if maxSize > 0 && total > int64(maxSize) {
+		if err := svc.rds.HIncrBy(ctx, key, "size", int64(-size)).Err(); err != nil {
+			return err
+		}
+
+		return ErrInputSessionTooLarge
+	}
+
+	fragmentsKey := inputSessionFragmentsKey(userID, roomID)
+	indexKey := inputSessionIndexKey(userID)
+	pipe := svc.rds.TxPipeline()
+	pipe.RPush(ctx, fragmentsKey, fragment)
+	pipe.Expire(ctx, fragmentsKey, ttl)
+	pipe.Expire(ctx, key, ttl)
+	pipe.ZAdd(ctx, indexKey, redis.Z{Score: float64(time.Now().Add(ttl).Unix()), Member: strconv.FormatInt(roomID, 10)})
+	pipe.Expire(ctx, indexKey, ttl)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+// TakeInputSession 取出并删除会话，返回会话的请求内容以及按照顺序追加的文本片段，会话不存在时返回 ErrInputSessionNotFound
+func (svc *ChatService) TakeInputSession(ctx context.Context, userID, roomID int64) ([]byte, []string, error) {
+	key := inputSessionKey(userID, roomID)
+	fragmentsKey := inputSessionFragmentsKey(userID, roomID)
+
+	pipe := svc.rds.TxPipeline()
+	request := pipe.HGet(ctx, key, "request")
+	fragments := pipe.LRange(ctx, fragmentsKey, 0, -1)
+	pipe.Del(ctx, key, fragmentsKey)
+	pipe.ZRem(ctx, inputSessionIndexKey(userID), strconv.FormatInt(roomID, 10))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, err
+	}
+
+	data, err := request.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil, ErrInputSessionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, fragments.Val(), nil
+}
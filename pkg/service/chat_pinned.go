@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+)
+
+// ErrPinnedMessagesExceed 房间中固定的消息数量已经达到上限
+var ErrPinnedMessagesExceed = errors.New("固定的消息数量已达上限，请先取消固定其它消息")
+
+// PinMessage 固定消息，固定的消息始终包含在房间的对话上下文中，每个房间最多固定 chat-max-pinned-messages 条消息
+func (svc *ChatService) PinMessage(ctx context.Context, userID, messageID int64) error {
+	if svc.conf.ChatMaxPinnedMessages <= 0 {
+		return ErrPinnedMessagesExceed
+	}
+
+	if err := svc.rep.Message.SetPinned(ctx, userID, messageID, true, int64(svc.conf.ChatMaxPinnedMessages)); err != nil {
+		if errors.Is(err, repo.ErrPinnedMessagesExceed) {
+			return ErrPinnedMessagesExceed
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// UnpinMessage 取消固定消息
+func (svc *ChatService) UnpinMessage(ctx context.Context, userID, messageID int64) error {
+	return svc.rep.Message.SetPinned(ctx, userID, messageID, false, 0)
+}
+
+// PinnedMessages 查询房间中固定的消息，按照发送的先后顺序排列
+func (svc *ChatService) PinnedMessages(ctx context.Context, userID, roomID int64) ([]model.ChatMessages, error) {
+	if userID <= 0 || roomID <= 0 {
+		return nil, nil
+	}
+
+	messages, err := svc.rep.Message.PinnedMessages(ctx, userID, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query pinned messages failed: %w", err)
+	}
+
+	return messages, nil
+}
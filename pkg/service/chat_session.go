@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// sessionFingerprintTTL 会话指纹记录的保留时间，每次记录时刷新
+	sessionFingerprintTTL = 7 * 24 * time.Hour
+	// maxSessionFingerprints 每个会话最多保留的指纹记录数量，超过后删除最早的记录
+	maxSessionFingerprints = 200
+)
+
+// SessionFingerprint 会话中一轮对话的上游模型指纹（system_fingerprint）
+type SessionFingerprint struct {
+	Model       string    `json:"model"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// sessionFingerprintKey 会话 ID 由客户端指定，不同用户可能使用相同的会话 ID，因此按照用户区分
+func sessionFingerprintKey(userID int64, sessionID string) string {
+	return fmt.Sprintf("chat-session:%d:%s:fingerprints", userID, sessionID)
+}
+
+// RecordSessionFingerprint 记录用户会话中一轮对话的指纹，返回指纹与上一轮相比是否发生变化（第一轮时返回 false）
+func (svc *ChatService) RecordSessionFingerprint(ctx context.Context, userID int64, sessionID string, model string, fingerprint string) (bool, error) {
+	key := sessionFingerprintKey(userID, sessionID)
+
+	var changed bool
+	last, err := svc.rds.LIndex(ctx, key, -1).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	if last != "" {
+		var prev SessionFingerprint
+		if err := json.Unmarshal([]byte(last), &prev); err == nil {
+			changed = prev.Fingerprint != fingerprint
+		}
+	}
+
+	data, err := json.Marshal(SessionFingerprint{Model: model, Fingerprint: fingerprint, CreatedAt: time.Now()})
+	if err != nil {
+		return false, err
+	}
+
+	pipe := svc.rds.TxPipeline()
+	pipe.RPush(ctx, key, string(data))
+	pipe.LTrim(ctx, key, -maxSessionFingerprints, -1)
+	pipe.Expire(ctx, key, sessionFingerprintTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// SessionFingerprints 查询用户会话中每轮对话的指纹记录（最近 maxSessionFingerprints 轮），按照对话顺序排列，会话不存在时返回空
+func (svc *ChatService) SessionFingerprints(ctx context.Context, userID int64, sessionID string) ([]SessionFingerprint, error) {
+	items, err := svc.rds.LRange(ctx, sessionFingerprintKey(userID, sessionID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]SessionFingerprint, 0, len(items))
+	for _, item := range items {
+		var fp SessionFingerprint
+		if err := json.Unmarshal([]byte(item), &fp); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, fp)
+	}
+
+	return ret, nil
+}
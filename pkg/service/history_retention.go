@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/uploader"
+	"github.com/mylxsw/asteria/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// historyTombstonesMaxLimit 每次查询删除标记的最大数量
+	historyTombstonesMaxLimit = 500
+	// historyUserCachesTTL 用户内容缓存索引的过期时间，不小于所有登记的缓存的过期时间
+	historyUserCachesTTL = 7 * 24 * time.Hour
+)
+
+// UserContentCachesKey 记录包含用户聊天内容的缓存（如长输入压缩的结果）的 Redis 集合，
+// 用户删除所有聊天记录时一起删除这些缓存
+func UserContentCachesKey(userID int64) string {
+	return fmt.Sprintf("chat:user-caches:%d", userID)
+}
+
+// TrackUserContentCache 登记包含用户聊天内容的缓存，用户删除所有聊天记录时一起删除
+func TrackUserContentCache(ctx context.Context, rds *redis.Client, userID int64, key string) {
+	if userID <= 0 {
+		return
+	}
+
+	setKey := UserContentCachesKey(userID)
+	if _, err := rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, setKey, key)
+		pipe.Expire(ctx, setKey, historyUserCachesTTL)
+		return nil
+	}); err != nil {
+		log.F(log.M{"user_id": userID}).Warningf("登记用户内容缓存失败: %v", err)
+	}
+}
+
+// fileRemover 删除对象存储中的文件
+type fileRemover interface {
+	RemoveFile(ctx context.Context, pathWithoutURLPrefix string) error
+}
+
+// historyStore 聊天记录的清理以及删除标记（repo.MessageRepo）
+type historyStore interface {
+	PurgeCandidates(ctx context.Context, q repo.MessagePurgeQuery) ([]repo.PurgeMessage, error)
+	PurgeMessages(ctx context.Context, messages []repo.PurgeMessage, reason string) error
+	PurgeGroupMessages(ctx context.Context, q repo.MessagePurgeQuery) (int64, error)
+	ClearRoomDescriptions(ctx context.Context, q repo.MessagePurgeQuery) error
+	Tombstones(ctx context.Context, userID, afterID, limit int64) ([]repo.MessageTombstone, error)
+}
+
+// userCacheStore 包含用户聊天内容的缓存
+type userCacheStore interface {
+	// UserCacheKeys 返回用户的所有内容缓存，查询出错时仍然返回已经查询到的缓存
+	UserCacheKeys(ctx context.Context, userID int64) ([]string, error)
+	// InputSessionRooms 返回用户所有未提交的增量输入会话所在的房间
+	InputSessionRooms(ctx context.Context, userID int64) ([]int64, error)
+	DeleteCaches(ctx context.Context, keys ...string) error
+}
+
+// redisUserCaches 保存在 Redis 中的用户内容缓存：房间信息缓存、登记的内容缓存（参考 TrackUserContentCache）以及增量输入会话
+type redisUserCaches struct {
+	rds *redis.Client
+}
+
+func (c redisUserCaches) UserCacheKeys(ctx context.Context, userID int64) ([]string, error) {
+	keys, err := c.rds.SMembers(ctx, UserContentCachesKey(userID)).Result()
+	if err != nil {
+		err = fmt.Errorf("query user content caches failed: %w", err)
+	}
+
+	keys = append(keys, UserContentCachesKey(userID))
+
+	iter := c.rds.Scan(ctx, 0, fmt.Sprintf("chat-room:%d:*:info", userID), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	if iterErr := iter.Err(); iterErr != nil && err == nil {
+		err = fmt.Errorf("query room info caches failed: %w", iterErr)
+	}
+
+	return keys, err
+}
+
+func (c redisUserCaches) InputSessionRooms(ctx context.Context, userID int64) ([]int64, error) {
+	members, err := c.rds.ZRange(ctx, inputSessionIndexKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("query input sessions failed: %w", err)
+	}
+
+	roomIDs := make([]int64, 0, len(members))
+	for _, member := range members {
+		if roomID, err := strconv.ParseInt(member, 10, 64); err == nil {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+
+	return roomIDs, nil
+}
+
+func (c redisUserCaches) DeleteCaches(ctx context.Context, keys ...string) error {
+	return c.rds.Del(ctx, keys...).Err()
+}
+
+// retentionPolicy 一组用户类型的聊天记录保留天数
+type retentionPolicy struct {
+	userTypes []int64
+	exclude   bool
+	days      int
+}
+
+// HistoryRetentionService 聊天记录的保留策略
+//
+// 超过保留期限的聊天记录（包括搜索索引、群聊消息、房间描述中的消息片段以及回复中生成的文件）由定时任务分批清理，
+// 用户也可以随时删除自己的所有聊天记录。删除时写入删除标记（chat_message_tombstones），同步客户端据此清理本地的聊天记录。
+// 智慧果消耗等统计数据不包含聊天内容，不受影响。
+type HistoryRetentionService struct {
+	conf   *config.Config
+	store  historyStore
+	caches userCacheStore
+	files  fileRemover
+
+	policies []retentionPolicy
+}
+
+func NewHistoryRetentionService(conf *config.Config, messageRepo *repo.MessageRepo, rds *redis.Client, up *uploader.Uploader) *HistoryRetentionService {
+	return newHistoryRetentionService(conf, messageRepo, redisUserCaches{rds: rds}, up)
+}
+
+func newHistoryRetentionService(conf *config.Config, store historyStore, caches userCacheStore, files fileRemover) *HistoryRetentionService {
+	return &HistoryRetentionService{
+		conf:     conf,
+		store:    store,
+		caches:   caches,
+		files:    files,
+		policies: parseRetentionPolicies(conf.ChatHistoryRetentionDays, conf.ChatHistoryRetentionTierDays),
+	}
+}
+
+// parseRetentionPolicies 解析保留策略，tiers 中的每一项格式为 "用户类型:天数"，格式错误的配置忽略
+//
+// 默认策略适用于没有单独配置的用户类型，保留天数为 0 的策略不会清理
+func parseRetentionPolicies(defaultDays int, tiers []string) []retentionPolicy {
+	policies := make([]retentionPolicy, 0, len(tiers)+1)
+	overridden := make([]int64, 0, len(tiers))
+	for _, tier := range tiers {
+		segs := strings.SplitN(strings.TrimSpace(tier), ":", 2)
+		if len(segs) != 2 {
+			log.Warningf("聊天记录保留策略 %q 格式错误，应为 用户类型:天数", tier)
+			continue
+		}
+
+		userType, err1 := strconv.ParseInt(strings.TrimSpace(segs[0]), 10, 64)
+		days, err2 := strconv.Atoi(strings.TrimSpace(segs[1]))
+		if err1 != nil || err2 != nil || days < 0 {
+			log.Warningf("聊天记录保留策略 %q 格式错误，应为 用户类型:天数", tier)
+			continue
+		}
+
+		overridden = append(overridden, userType)
+		if days > 0 {
+			policies = append(policies, retentionPolicy{userTypes: []int64{userType}, days: days})
+		}
+	}
+
+	if defaultDays > 0 {
+		policies = append(policies, retentionPolicy{userTypes: overridden, exclude: true, days: defaultDays})
+	}
+
+	return policies
+}
+
+// PurgeExpired 清理所有超过保留期限的聊天记录，每批之间按照配置的间隔暂停，返回删除的聊天记录数量
+func (svc *HistoryRetentionService) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	var total int64
+	for _, policy := range svc.policies {
+		q := repo.MessagePurgeQuery{
+			Before:           now.AddDate(0, 0, -policy.days),
+			UserTypes:        policy.userTypes,
+			ExcludeUserTypes: policy.exclude,
+			Limit:            svc.batchSize(),
+		}
+
+		count, err := svc.purge(ctx, q, repo.TombstoneReasonRetention, svc.purgeInterval())
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// DeleteAll 立即删除用户的所有聊天记录，同时清理包含聊天内容的缓存
+func (svc *HistoryRetentionService) DeleteAll(ctx context.Context, userID int64) error {
+	if _, err := svc.purge(ctx, repo.MessagePurgeQuery{UserID: userID, Limit: svc.batchSize()}, repo.TombstoneReasonUser, 0); err != nil {
+		return err
+	}
+
+	svc.clearUserCaches(ctx, userID)
+	return nil
+}
+
+// Tombstones 查询用户 ID 大于 afterID 的删除标记，用于客户端同步
+func (svc *HistoryRetentionService) Tombstones(ctx context.Context, userID, afterID, limit int64) ([]repo.MessageTombstone, error) {
+	if limit <= 0 || limit > historyTombstonesMaxLimit {
+		limit = historyTombstonesMaxLimit
+	}
+
+	return svc.store.Tombstones(ctx, userID, afterID, limit)
+}
+
+// purge 分批删除满足条件的聊天记录、群聊消息以及房间描述，返回删除的聊天记录数量
+func (svc *HistoryRetentionService) purge(ctx context.Context, q repo.MessagePurgeQuery, reason string, interval time.Duration) (int64, error) {
+	var total int64
+	for {
+		messages, err := svc.store.PurgeCandidates(ctx, q)
+		if err != nil {
+			return total, fmt.Errorf("query messages failed: %w", err)
+		}
+
+		if len(messages) == 0 {
+			break
+		}
+
+		// 先删除文件，删除失败时只记录日志，避免文件异常导致聊天记录无法清理
+		for _, msg := range messages {
+			for _, key := range svc.messageFileKeys(msg) {
+				if err := svc.files.RemoveFile(ctx, key); err != nil {
+					log.F(log.M{"message_id": msg.ID, "user_id": msg.UserID, "key": key}).Warningf("删除聊天记录关联的文件失败: %v", err)
+				}
+			}
+		}
+
+		if err := svc.store.PurgeMessages(ctx, messages, reason); err != nil {
+			return total, fmt.Errorf("delete messages failed: %w", err)
+		}
+
+		total += int64(len(messages))
+		if int64(len(messages)) < q.Limit {
+			break
+		}
+
+		if err := sleepContext(ctx, interval); err != nil {
+			return total, err
+		}
+	}
+
+	for {
+		count, err := svc.store.PurgeGroupMessages(ctx, q)
+		if err != nil {
+			return total, fmt.Errorf("delete group messages failed: %w", err)
+		}
+
+		if count < q.Limit {
+			break
+		}
+
+		if err := sleepContext(ctx, interval); err != nil {
+			return total, err
+		}
+	}
+
+	if err := svc.store.ClearRoomDescriptions(ctx, q); err != nil {
+		return total, fmt.Errorf("clear room descriptions failed: %w", err)
+	}
+
+	log.F(log.M{"user_id": q.UserID, "before": q.Before, "reason": reason, "count": total}).Infof("清理聊天记录完成")
+
+	return total, nil
+}
+
+// messageFileKeys 返回回复中引用的、属于该用户的对象存储文件（不包含域名的路径）
+func (svc *HistoryRetentionService) messageFileKeys(msg repo.PurgeMessage) []string {
+	if msg.Parts == "" || svc.conf.StorageDomain == "" {
+		return nil
+	}
+
+	return messageFileKeys(msg.Parts, svc.conf.StorageDomain, msg.UserID)
+}
+
+// messageFileKeys 从回复的多模态内容（JSON 格式）中提取存储在对象存储中的文件，只返回该用户目录下的文件，避免误删共享的文件
+func messageFileKeys(parts string, storageDomain string, userID int64) []string {
+	var contents []struct {
+		ImageURL *struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal([]byte(parts), &contents); err != nil {
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(storageDomain, "/") + "/"
+	userDir := fmt.Sprintf("ai-server/%d/", userID)
+
+	keys := make([]string, 0)
+	for _, item := range contents {
+		if item.ImageURL == nil || !strings.HasPrefix(item.ImageURL.URL, prefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(item.ImageURL.URL, prefix)
+		if strings.HasPrefix(key, userDir) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// clearUserCaches 删除包含用户聊天内容的缓存：房间信息缓存、登记的内容缓存以及未提交的增量输入会话
+func (svc *HistoryRetentionService) clearUserCaches(ctx context.Context, userID int64) {
+	keys, err := svc.caches.UserCacheKeys(ctx, userID)
+	if err != nil {
+		log.F(log.M{"user_id": userID}).Warningf("查询用户内容缓存失败: %v", err)
+	}
+
+	roomIDs, err := svc.caches.InputSessionRooms(ctx, userID)
+	if err != nil {
+		log.F(log.M{"user_id": userID}).Warningf("查询用户增量输入会话失败: %v", err)
+	}
+
+	for _, roomID := range roomIDs {
+		keys = append(keys, inputSessionKey(userID, roomID), inputSessionFragmentsKey(userID, roomID))
+	}
+	keys = append(keys, inputSessionIndexKey(userID))
+
+	if len(keys) == 0 {
+		return
+	}
+
+	if err := svc.caches.DeleteCaches(ctx, keys...); err != nil {
+		log.F(log.M{"user_id": userID}).Warningf("删除用户内容缓存失败: %v", err)
+	}
+}
+
+func (svc *HistoryRetentionService) batchSize() int64 {
+	if svc.conf.ChatHistoryPurgeBatchSize <= 0 {
+		return 500
+	}
+
+	return int64(svc.conf.ChatHistoryPurgeBatchSize)
+}
+
+func (svc *HistoryRetentionService) purgeInterval() time.Duration {
+	return time.Duration(svc.conf.ChatHistoryPurgeInterval) * time.Millisecond
+}
+
+// sleepContext 暂停指定的时间，ctx 取消时提前返回
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/array"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestParseRetentionPolicies(t *testing.T) {
+	// 单独配置的用户类型从默认策略中排除，保留天数为 0 时永久保留，格式错误的配置忽略
+	policies := parseRetentionPolicies(30, []string{"1:0", " 2 : 7 ", "invalid", "3:-1"})
+	assert.EqualValues(t, []retentionPolicy{
+		{userTypes: []int64{2}, days: 7},
+		{userTypes: []int64{1, 2}, exclude: true, days: 30},
+	}, policies)
+
+	// 默认永久保留时只清理单独配置的用户类型
+	policies = parseRetentionPolicies(0, []string{"0:90"})
+	assert.EqualValues(t, []retentionPolicy{{userTypes: []int64{0}, days: 90}}, policies)
+
+	assert.Equal(t, 0, len(parseRetentionPolicies(0, nil)))
+}
+
+func TestMessageFileKeys(t *testing.T) {
+	parts := `[
+		{"type": "image_url", "image_url": {"url": "https://cdn.example.com/ai-server/10/20261016/aigc1.png"}},
+		{"type": "image_url", "image_url": {"url": "https://cdn.example.com/ai-server/11/20261016/aigc2.png"}},
+		{"type": "image_url", "image_url": {"url": "https://other.example.com/ai-server/10/20261016/aigc3.png"}},
+		{"type": "text", "text": "a cat"}
+	]`
+
+	// 只返回该用户目录下存储在对象存储中的文件
+	assert.EqualValues(t, []string{"ai-server/10/20261016/aigc1.png"}, messageFileKeys(parts, "https://cdn.example.com/", 10))
+	assert.Equal(t, 0, len(messageFileKeys("not json", "https://cdn.example.com", 10)))
+}
+
+// fakeHistoryMessage 内存中的聊天记录（或群聊消息）
+type fakeHistoryMessage struct {
+	repo.PurgeMessage
+	CreatedAt time.Time
+}
+
+// fakeHistoryStore 保存在内存中的聊天记录，按照 repo.MessagePurgeQuery 的语义查询
+type fakeHistoryStore struct {
+	lock sync.Mutex
+	// userTypes 用户类型，不存在的用户按照普通用户处理
+	userTypes     map[int64]int64
+	messages      []fakeHistoryMessage
+	groupMessages []fakeHistoryMessage
+	tombstones    []repo.PurgeTombstone
+	// quotaUsages 智慧果消耗记录（用户 ID），清理聊天记录时不能删除
+	quotaUsages []int64
+
+	// batches 每批删除的聊天记录 ID 以及删除的时间
+	batches    [][]int64
+	batchTimes []time.Time
+	// groupPurges 每次删除群聊消息的时间
+	groupPurges []time.Time
+	clearedRoom []repo.MessagePurgeQuery
+}
+
+func (s *fakeHistoryStore) match(q repo.MessagePurgeQuery, msg fakeHistoryMessage) bool {
+	if q.UserID > 0 && msg.UserID != q.UserID {
+		return false
+	}
+
+	if !q.Before.IsZero() && !msg.CreatedAt.Before(q.Before) {
+		return false
+	}
+
+	if len(q.UserTypes) > 0 {
+		return array.In(s.userTypes[msg.UserID], q.UserTypes) != q.ExcludeUserTypes
+	}
+
+	return true
+}
+
+func (s *fakeHistoryStore) PurgeCandidates(ctx context.Context, q repo.MessagePurgeQuery) ([]repo.PurgeMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	messages := make([]repo.PurgeMessage, 0)
+	for _, msg := range s.messages {
+		if s.match(q, msg) && int64(len(messages)) < q.Limit {
+			messages = append(messages, msg.PurgeMessage)
+		}
+	}
+
+	return messages, nil
+}
+
+func (s *fakeHistoryStore) PurgeMessages(ctx context.Context, messages []repo.PurgeMessage, reason string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids := array.Map(messages, func(msg repo.PurgeMessage, _ int) int64 { return msg.ID })
+	s.batches = append(s.batches, ids)
+	s.batchTimes = append(s.batchTimes, time.Now())
+	s.messages = array.Filter(s.messages, func(msg fakeHistoryMessage, _ int) bool { return !array.In(msg.ID, ids) })
+	s.tombstones = append(s.tombstones, repo.PurgeTombstones(messages, reason)...)
+
+	return nil
+}
+
+func (s *fakeHistoryStore) PurgeGroupMessages(ctx context.Context, q repo.MessagePurgeQuery) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.groupPurges = append(s.groupPurges, time.Now())
+
+	var count int64
+	s.groupMessages = array.Filter(s.groupMessages, func(msg fakeHistoryMessage, _ int) bool {
+		if count < q.Limit && s.match(q, msg) {
+			count++
+			return false
+		}
+		return true
+	})
+
+	return count, nil
+}
+
+func (s *fakeHistoryStore) ClearRoomDescriptions(ctx context.Context, q repo.MessagePurgeQuery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.clearedRoom = append(s.clearedRoom, q)
+	return nil
+}
+
+func (s *fakeHistoryStore) Tombstones(ctx context.Context, userID, afterID, limit int64) ([]repo.MessageTombstone, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tombstones := make([]repo.MessageTombstone, 0)
+	for i, item := range s.tombstones {
+		id := int64(i + 1)
+		if item.UserID == userID && id > afterID && int64(len(tombstones)) < limit {
+			tombstones = append(tombstones, repo.MessageTombstone{ID: id, RoomID: item.RoomID, MaxMessageID: item.MaxMessageID, Reason: item.Reason})
+		}
+	}
+
+	return tombstones, nil
+}
+
+func (s *fakeHistoryStore) messageIDs() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return array.Map(s.messages, func(msg fakeHistoryMessage, _ int) int64 { return msg.ID })
+}
+
+// fakeFileRemover 记录删除的文件，failKey 删除失败
+type fakeFileRemover struct {
+	removed []string
+	failKey string
+}
+
+func (f *fakeFileRemover) RemoveFile(ctx context.Context, key string) error {
+	f.removed = append(f.removed, key)
+	if key == f.failKey {
+		return errors.New("remove failed")
+	}
+
+	return nil
+}
+
+// fakeUserCaches 内存中的用户内容缓存
+type fakeUserCaches struct {
+	keys          map[int64][]string
+	inputSessions map[int64][]int64
+	deleted       []string
+}
+
+func (c *fakeUserCaches) UserCacheKeys(ctx context.Context, userID int64) ([]string, error) {
+	return c.keys[userID], nil
+}
+
+func (c *fakeUserCaches) InputSessionRooms(ctx context.Context, userID int64) ([]int64, error) {
+	return c.inputSessions[userID], nil
+}
+
+func (c *fakeUserCaches) DeleteCaches(ctx context.Context, keys ...string) error {
+	c.deleted = append(c.deleted, keys...)
+	return nil
+}
+
+func TestHistoryRetention_PurgeExpired(t *testing.T) {
+	now := time.Now()
+	old, recent := now.AddDate(0, 0, -40), now.AddDate(0, 0, -1)
+	parts := `[{"type": "image_url", "image_url": {"url": "https://cdn.example.com/ai-server/10/20261016/aigc1.png"}}]`
+
+	// 用户 10 为普通用户（保留 30 天），用户 11 的类型永久保留
+	store := &fakeHistoryStore{
+		userTypes: map[int64]int64{10: 0, 11: 1},
+		messages: []fakeHistoryMessage{
+			{PurgeMessage: repo.PurgeMessage{ID: 1, UserID: 10, RoomID: 1, Parts: parts}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 2, UserID: 10, RoomID: 1}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 3, UserID: 11, RoomID: 3}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 4, UserID: 10, RoomID: 2}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 5, UserID: 10, RoomID: 2}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 6, UserID: 10, RoomID: 2}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 7, UserID: 10, RoomID: 2}, CreatedAt: recent},
+		},
+		groupMessages: []fakeHistoryMessage{
+			{PurgeMessage: repo.PurgeMessage{ID: 1, UserID: 10}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 2, UserID: 10}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 3, UserID: 11}, CreatedAt: old},
+			{PurgeMessage: repo.PurgeMessage{ID: 4, UserID: 10}, CreatedAt: recent},
+		},
+	}
+	files := &fakeFileRemover{failKey: "ai-server/10/20261016/aigc1.png"}
+
+	interval := 20 * time.Millisecond
+	svc := newHistoryRetentionService(&config.Config{
+		StorageDomain:                "https://cdn.example.com",
+		ChatHistoryRetentionDays:     30,
+		ChatHistoryRetentionTierDays: []string{"1:0"},
+		ChatHistoryPurgeBatchSize:    2,
+		ChatHistoryPurgeInterval:     int(interval / time.Millisecond),
+	}, store, &fakeUserCaches{}, files)
+
+	start := time.Now()
+	count, err := svc.PurgeExpired(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, count)
+
+	// 按照批次大小分批删除，每批之间暂停配置的间隔（群聊消息同样）
+	assert.EqualValues(t, [][]int64{{1, 2}, {4, 5}, {6}}, store.batches)
+	for i := 1; i < len(store.batchTimes); i++ {
+		assert.True(t, store.batchTimes[i].Sub(store.batchTimes[i-1]) >= interval)
+	}
+	assert.Equal(t, 2, len(store.groupPurges))
+	assert.True(t, time.Since(start) >= 3*interval)
+
+	// 未过期以及永久保留的用户类型的记录不删除
+	assert.EqualValues(t, []int64{3, 7}, store.messageIDs())
+	assert.Equal(t, 2, len(store.groupMessages))
+	assert.Equal(t, 1, len(store.clearedRoom))
+	assert.Equal(t, now.AddDate(0, 0, -30), store.clearedRoom[0].Before)
+
+	// 每批为每个房间写入删除标记
+	assert.EqualValues(t, []repo.PurgeTombstone{
+		{UserID: 10, RoomID: 1, MaxMessageID: 2, Reason: repo.TombstoneReasonRetention},
+		{UserID: 10, RoomID: 2, MaxMessageID: 5, Reason: repo.TombstoneReasonRetention},
+		{UserID: 10, RoomID: 2, MaxMessageID: 6, Reason: repo.TombstoneReasonRetention},
+	}, store.tombstones)
+
+	// 文件删除失败不影响聊天记录的清理
+	assert.EqualValues(t, []string{"ai-server/10/20261016/aigc1.png"}, files.removed)
+}
+
+func TestHistoryRetention_DeleteAll(t *testing.T) {
+	now := time.Now()
+	store := &fakeHistoryStore{
+		messages: []fakeHistoryMessage{
+			{PurgeMessage: repo.PurgeMessage{ID: 1, UserID: 10, RoomID: 1}, CreatedAt: now.AddDate(0, 0, -400)},
+			{PurgeMessage: repo.PurgeMessage{ID: 2, UserID: 11, RoomID: 3}, CreatedAt: now},
+			{PurgeMessage: repo.PurgeMessage{ID: 3, UserID: 10, RoomID: 2}, CreatedAt: now},
+			{PurgeMessage: repo.PurgeMessage{ID: 4, UserID: 10, RoomID: 1}, CreatedAt: now},
+		},
+		groupMessages: []fakeHistoryMessage{{PurgeMessage: repo.PurgeMessage{ID: 1, UserID: 10}, CreatedAt: now}},
+		quotaUsages:   []int64{10, 10, 11},
+	}
+	caches := &fakeUserCaches{
+		keys: map[int64][]string{
+			10: {"chat:compress:abc", UserContentCachesKey(10), "chat-room:10:1:info"},
+			11: {UserContentCachesKey(11)},
+		},
+		inputSessions: map[int64][]int64{10: {1, 2}, 11: {3}},
+	}
+
+	// 用户主动删除时立即删除，不按照定时任务的间隔暂停
+	svc := newHistoryRetentionService(&config.Config{
+		ChatHistoryPurgeBatchSize: 2,
+		ChatHistoryPurgeInterval:  int(time.Minute / time.Millisecond),
+	}, store, caches, &fakeFileRemover{})
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, svc.DeleteAll(ctx, 10))
+
+	// 只删除该用户的所有聊天记录，智慧果消耗记录保留
+	assert.EqualValues(t, []int64{2}, store.messageIDs())
+	assert.Equal(t, 0, len(store.groupMessages))
+	assert.EqualValues(t, []int64{10, 10, 11}, store.quotaUsages)
+	assert.Equal(t, int64(10), store.clearedRoom[0].UserID)
+
+	// 登记的内容缓存、房间信息缓存以及未提交的增量输入会话一起删除
+	assert.EqualValues(t, append(caches.keys[10],
+		"chat-input-session:10:1", "chat-input-session:10:1:fragments",
+		"chat-input-session:10:2", "chat-input-session:10:2:fragments",
+		"chat-input-session:10:index",
+	), caches.deleted)
+
+	// 每批为每个房间写入删除标记，客户端按照 ID 增量同步
+	tombstones, err := svc.Tombstones(context.TODO(), 10, 0, 0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []repo.MessageTombstone{
+		{ID: 1, RoomID: 1, MaxMessageID: 1, Reason: repo.TombstoneReasonUser},
+		{ID: 2, RoomID: 2, MaxMessageID: 3, Reason: repo.TombstoneReasonUser},
+		{ID: 3, RoomID: 1, MaxMessageID: 4, Reason: repo.TombstoneReasonUser},
+	}, tombstones)
+
+	tombstones, err = svc.Tombstones(context.TODO(), 10, 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tombstones))
+
+	tombstones, err = svc.Tombstones(context.TODO(), 11, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(tombstones))
+}
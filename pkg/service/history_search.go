@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/eloquent/query"
+	"github.com/mylxsw/glacier/infra"
+)
+
+var (
+	// ErrSearchKeywordInvalid 搜索关键词为空、过短（全文索引按照 2 个字符分词）或者过长
+	ErrSearchKeywordInvalid = errors.New("搜索关键词的每个词需要 2-50 个字符，最多 5 个词")
+)
+
+const (
+	// historyIndexQueueSize 等待写入搜索索引的聊天记录的最大数量，超过时丢弃
+	historyIndexQueueSize = 2000
+	// historyIndexBatchSize 每次写入搜索索引的最大记录数量
+	historyIndexBatchSize = 100
+	// historySnippetRadius 搜索结果摘要中匹配内容前后保留的字符数量
+	historySnippetRadius = 40
+	// historySearchMaxTerms 搜索关键词最多包含的词数量
+	historySearchMaxTerms = 5
+	// historySearchMinTermRunes/historySearchMaxTermRunes 每个搜索词的字符数量范围
+	historySearchMinTermRunes = 2
+	historySearchMaxTermRunes = 50
+)
+
+// HistorySearchService 聊天记录搜索
+//
+// 搜索索引（chat_messages_search）在写入聊天记录之后异步更新，不影响聊天的响应速度
+type HistorySearchService struct {
+	rep  *repo.Repository `autowire:"@"`
+	docs chan repo.MessageSearchDoc
+}
+
+func NewHistorySearchService(resolver infra.Resolver) *HistorySearchService {
+	svc := &HistorySearchService{docs: make(chan repo.MessageSearchDoc, historyIndexQueueSize)}
+	resolver.MustAutoWire(svc)
+
+	go svc.indexLoop()
+	return svc
+}
+
+// Index 将聊天记录加入搜索索引的写入队列，不会阻塞，队列已满时丢弃
+func (svc *HistorySearchService) Index(doc repo.MessageSearchDoc) {
+	if doc.MessageID <= 0 || strings.TrimSpace(doc.Content) == "" {
+		return
+	}
+
+	select {
+	case svc.docs <- doc:
+	default:
+		log.F(log.M{"message_id": doc.MessageID, "user_id": doc.UserID}).Warningf("聊天记录搜索索引写入队列已满，丢弃")
+	}
+}
+
+// indexLoop 批量写入搜索索引
+func (svc *HistorySearchService) indexLoop() {
+	for doc := range svc.docs {
+		batch := []repo.MessageSearchDoc{doc}
+	drain:
+		for len(batch) < historyIndexBatchSize {
+			select {
+			case doc := <-svc.docs:
+				batch = append(batch, doc)
+			default:
+				break drain
+			}
+		}
+
+		func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := svc.rep.Message.IndexMessages(ctx, batch...); err != nil {
+				log.F(log.M{"count": len(batch)}).Errorf("写入聊天记录搜索索引失败: %v", err)
+			}
+		}()
+	}
+}
+
+// HistorySearchReq 聊天记录搜索请求
+type HistorySearchReq struct {
+	// Keyword 搜索关键词，多个词使用空格分隔，所有词都需要匹配
+	Keyword string
+	// RoomID 只搜索指定房间，为 0 时搜索所有房间
+	RoomID int64
+	// Model 只搜索指定模型的聊天记录
+	Model string
+	// StartAt/EndAt 聊天记录的时间范围
+	StartAt time.Time
+	EndAt   time.Time
+	// ExcludePrivate 是否排除私密房间的聊天记录
+	ExcludePrivate bool
+	Page           int64
+	PerPage        int64
+}
+
+// HighlightSpan 摘要中匹配内容的位置（字符偏移，左闭右开）
+type HighlightSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// HistorySearchItem 聊天记录搜索结果
+type HistorySearchItem struct {
+	MessageID int64            `json:"message_id"`
+	RoomID    int64            `json:"room_id"`
+	Role      repo.MessageRole `json:"role"`
+	Model     string           `json:"model,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	// Snippet 匹配内容附近的摘要，省略的部分使用 ... 表示
+	Snippet    string          `json:"snippet"`
+	Highlights []HighlightSpan `json:"highlights,omitempty"`
+}
+
+// Search 搜索用户自己的聊天记录，指定房间时，房间必须属于该用户，否则返回 repo.ErrNotFound
+func (svc *HistorySearchService) Search(ctx context.Context, userID int64, req HistorySearchReq) ([]HistorySearchItem, query.PaginateMeta, error) {
+	terms, err := parseSearchTerms(req.Keyword)
+	if err != nil {
+		return nil, query.PaginateMeta{}, err
+	}
+
+	// 默认房间（ID 为 1）所有用户共用，聊天记录按照用户区分
+	if req.RoomID > 1 {
+		if _, err := svc.rep.Room.Room(ctx, userID, req.RoomID); err != nil {
+			return nil, query.PaginateMeta{}, err
+		}
+	}
+
+	results, meta, err := svc.rep.Message.SearchMessages(ctx, userID, repo.MessageSearchReq{
+		Terms:          terms,
+		RoomID:         req.RoomID,
+		Model:          req.Model,
+		StartAt:        req.StartAt,
+		EndAt:          req.EndAt,
+		ExcludePrivate: req.ExcludePrivate,
+	}, req.Page, req.PerPage)
+	if err != nil {
+		return nil, query.PaginateMeta{}, err
+	}
+
+	items := make([]HistorySearchItem, 0, len(results))
+	for _, res := range results {
+		snippet, highlights := buildSnippet(res.Content, terms, historySnippetRadius)
+		items = append(items, HistorySearchItem{
+			MessageID:  res.MessageID,
+			RoomID:     res.RoomID,
+			Role:       res.Role,
+			Model:      res.Model,
+			CreatedAt:  res.CreatedAt,
+			Snippet:    snippet,
+			Highlights: highlights,
+		})
+	}
+
+	return items, meta, nil
+}
+
+// parseSearchTerms 将搜索关键词按照空白字符拆分为搜索词
+func parseSearchTerms(keyword string) ([]string, error) {
+	terms := strings.Fields(keyword)
+	if len(terms) == 0 || len(terms) > historySearchMaxTerms {
+		return nil, ErrSearchKeywordInvalid
+	}
+
+	for _, term := range terms {
+		if n := utf8.RuneCountInString(term); n < historySearchMinTermRunes || n > historySearchMaxTermRunes {
+			return nil, ErrSearchKeywordInvalid
+		}
+	}
+
+	return terms, nil
+}
+
+// buildSnippet 截取内容中第一个匹配位置前后 radius 个字符作为摘要，并返回摘要中所有匹配内容的位置（不区分大小写）
+func buildSnippet(content string, terms []string, radius int) (string, []HighlightSpan) {
+	runes := []rune(content)
+	// 逐个字符转换为小写，保证与原始内容的字符位置一致
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var spans []HighlightSpan
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		for i := 0; i+len(t) <= len(lower) && len(t) > 0; i++ {
+			if string(lower[i:i+len(t)]) == string(t) {
+				spans = append(spans, HighlightSpan{Start: i, End: i + len(t)})
+				i += len(t) - 1
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	// 没有找到匹配位置时（如全文索引匹配了全半角不同的内容），使用内容的开头
+	start, matched := 0, 0
+	if len(spans) > 0 {
+		start, matched = max(spans[0].Start-radius, 0), spans[0].End-spans[0].Start
+	}
+	end := min(start+2*radius+matched, len(runes))
+
+	var snippet strings.Builder
+	offset := 0
+	if start > 0 {
+		snippet.WriteString("...")
+		offset = 3
+	}
+	snippet.WriteString(string(runes[start:end]))
+	if end < len(runes) {
+		snippet.WriteString("...")
+	}
+
+	highlights := make([]HighlightSpan, 0, len(spans))
+	last := -1
+	for _, span := range spans {
+		// 只保留摘要范围内、互不重叠的匹配位置
+		if span.Start < start || span.End > end || span.Start < last {
+			continue
+		}
+
+		highlights = append(highlights, HighlightSpan{Start: span.Start - start + offset, End: span.End - start + offset})
+		last = span.End
+	}
+
+	return snippet.String(), highlights
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestParseSearchTerms(t *testing.T) {
+	terms, err := parseSearchTerms("  房贷  mortgage ")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"房贷", "mortgage"}, terms)
+
+	for _, keyword := range []string{"", "   ", "房", "a bb", strings.Repeat("长", 51), "aa bb cc dd ee ff"} {
+		_, err := parseSearchTerms(keyword)
+		assert.True(t, errors.Is(err, ErrSearchKeywordInvalid))
+	}
+}
+
+func TestBuildSnippet(t *testing.T) {
+	// 内容较短时返回全部内容，所有匹配位置按照字符偏移返回（不区分大小写）
+	snippet, highlights := buildSnippet("我的房贷利率是多少？Mortgage rate", []string{"房贷", "mortgage"}, 40)
+	assert.Equal(t, "我的房贷利率是多少？Mortgage rate", snippet)
+	assert.EqualValues(t, []HighlightSpan{{Start: 2, End: 4}, {Start: 10, End: 18}}, highlights)
+
+	// 内容较长时截取匹配位置附近的内容
+	content := strings.Repeat("前", 50) + "房贷" + strings.Repeat("后", 50)
+	snippet, highlights = buildSnippet(content, []string{"房贷"}, 10)
+	assert.Equal(t, "..."+strings.Repeat("前", 10)+"房贷"+strings.Repeat("后", 10)+"...", snippet)
+	assert.EqualValues(t, []HighlightSpan{{Start: 13, End: 15}}, highlights)
+
+	runes := []rune(snippet)
+	assert.Equal(t, "房贷", string(runes[highlights[0].Start:highlights[0].End]))
+
+	// 没有找到匹配位置时，使用内容的开头
+	snippet, highlights = buildSnippet(strings.Repeat("无", 30), []string{"房贷"}, 10)
+	assert.Equal(t, strings.Repeat("无", 20)+"...", snippet)
+	assert.Equal(t, 0, len(highlights))
+}
@@ -10,6 +10,9 @@ func (Provider) Register(binder infra.Binder) {
 	binder.MustSingleton(NewGalleryService)
 	binder.MustSingleton(NewChatService)
 	binder.MustSingleton(NewSettingService)
+	binder.MustSingleton(NewHistorySearchService)
+	binder.MustSingleton(NewHistoryRetentionService)
+	binder.MustSingleton(NewAPIKeyService)
 
 	binder.MustSingleton(func(resolver infra.Resolver) *Service {
 		var svc Service
@@ -25,4 +28,10 @@ type Service struct {
 	Gallery  *GalleryService  `autowire:"@"`
 	Chat     *ChatService     `autowire:"@"`
 	Setting  *SettingService  `autowire:"@"`
+	// HistorySearch 聊天记录搜索
+	HistorySearch *HistorySearchService `autowire:"@"`
+	// HistoryRetention 聊天记录的保留策略
+	HistoryRetention *HistoryRetentionService `autowire:"@"`
+	// APIKey 用户自助管理的 API Key
+	APIKey *APIKeyService `autowire:"@"`
 }
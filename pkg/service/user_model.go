@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/go-utils/array"
+)
+
+// publicUserModelsLimit 模型列表中最多展示的公开自定义模型数量
+const publicUserModelsLimit = 100
+
+// UserModel 查询用户可以使用的自定义模型，模型不存在或者没有使用权限时返回 repo.ErrNotFound
+func (svc *ChatService) UserModel(ctx context.Context, code string, userID int64) (*model.UserModels, error) {
+	return svc.rep.UserModel.AccessibleUserModel(ctx, code, userID)
+}
+
+// UserModels 用户在模型列表中可以看到的自定义模型（自己创建的和公开的），模型 ID 为 user-model@{code}
+//
+// 自定义模型的能力和价格与基础模型相同，基础模型不存在时不展示，基础模型不可用时同样不可用
+func (svc *ChatService) UserModels(ctx context.Context, userID int64) []repo.Model {
+	var items []model.UserModels
+	if userID > 0 {
+		owned, err := svc.rep.UserModel.UserModels(ctx, userID)
+		if err != nil {
+			log.F(log.M{"user_id": userID}).Errorf("query user models failed: %v", err)
+		}
+
+		items = append(items, owned...)
+	}
+
+	public, err := svc.rep.UserModel.PublicModels(ctx, publicUserModelsLimit)
+	if err != nil {
+		log.Errorf("query public user models failed: %v", err)
+	}
+
+	items = append(items, array.Filter(public, func(item model.UserModels, _ int) bool { return item.UserId != userID })...)
+	if len(items) == 0 {
+		return nil
+	}
+
+	baseModels := array.ToMap(svc.Models(ctx, true), func(item repo.Model, _ int) string { return item.ModelId })
+
+	ret := make([]repo.Model, 0, len(items))
+	for _, item := range items {
+		base, ok := baseModels[PureModelID(item.Model)]
+		if !ok {
+			continue
+		}
+
+		mod := base
+		mod.ModelId = repo.UserModelID(item.Code)
+		mod.Name = item.Name
+		mod.ShortName = item.Name
+		mod.Description = item.Description
+		if item.AvatarUrl != "" {
+			mod.AvatarUrl = item.AvatarUrl
+		}
+		// 提供商信息只在服务端使用，不对外暴露
+		mod.Providers = nil
+
+		ret = append(ret, mod)
+	}
+
+	return ret
+}
@@ -0,0 +1,23 @@
+package usagehook
+
+import (
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/glacier/infra"
+)
+
+type Provider struct{}
+
+func (Provider) Register(binder infra.Binder) {
+	binder.MustSingleton(func(conf *config.Config, rep *repo.Repository) *Webhook {
+		return NewWebhook(
+			conf.UsageWebhookURLs,
+			conf.UsageWebhookSecret,
+			conf.UsageWebhookMaxAttempts,
+			time.Duration(conf.UsageWebhookBackoff)*time.Second,
+			rep.UsageWebhook,
+		)
+	})
+}
@@ -0,0 +1,315 @@
+// Package usagehook 用量通知：每次请求完成后，将用量事件（不包含请求和回答的内容）异步发送到配置的通知地址，用于企业客户在自己的系统中对账
+//
+// 请求使用 HMAC-SHA256 签名（参考 Sign），发送失败时按照指数退避重试，多次重试之后仍然失败的事件写入死信表，
+// 管理员可以按照时间范围重新发送（参考 Webhook.Replay）。
+//
+// 发送在后台协程中进行，不会阻塞或者影响聊天请求。同一个用户的事件由同一个协程按照发布的顺序依次发送，
+// 但是不保证送达的顺序：写入死信表之后重新发送的事件、服务重启时尚未发送的事件（会丢失）都会打乱顺序，
+// 接收方需要使用事件中的时间排序，使用事件 ID 去重
+package usagehook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/asteria/log"
+)
+
+const (
+	// workers 发送事件的协程数量，事件按照用户分配到协程
+	workers = 4
+	// queueSize 每个协程等待发送的事件的最大数量，队列已满时事件直接写入死信表
+	queueSize = 1024
+	// requestTimeout 每次发送的超时时间
+	requestTimeout = 10 * time.Second
+	// replayPageSize 重新发送时每次从死信表中查询的事件数量
+	replayPageSize = 500
+	// maxErrorLength 死信表中保存的错误信息的最大长度
+	maxErrorLength = 1000
+)
+
+const (
+	// HeaderEventID 事件 ID，与请求体中的 id 相同
+	HeaderEventID = "X-Aidea-Event-Id"
+	// HeaderTimestamp 发送时间（Unix 时间戳，秒），参与签名，接收方可以据此拒绝过期的请求
+	HeaderTimestamp = "X-Aidea-Timestamp"
+	// HeaderSignature 请求签名，格式为 sha256=<十六进制编码的签名>
+	HeaderSignature = "X-Aidea-Signature"
+)
+
+// Event 一次请求完成后的用量事件
+type Event struct {
+	// ID 事件 ID，重新发送时保持不变，接收方用于去重
+	ID        string `json:"id"`
+	RequestID string `json:"request_id"`
+	UserID    int64  `json:"user_id"`
+	// APIKeyID 使用 API Key 请求时的 Key ID
+	APIKeyID int64 `json:"api_key_id,omitempty"`
+	// Model 用户请求的模型
+	Model string `json:"model"`
+	// UpstreamModel 实际请求的上游模型（服务提供商模型重写之后）
+	UpstreamModel string `json:"upstream_model,omitempty"`
+	// Provider 服务提供商类型
+	Provider string `json:"provider,omitempty"`
+	// ChannelID 渠道 ID，使用配置文件中的服务提供商时为 0
+	ChannelID    int64 `json:"channel_id,omitempty"`
+	InputTokens  int   `json:"input_tokens"`
+	OutputTokens int   `json:"output_tokens"`
+	// Cost 本次请求消耗的智慧果（使用免费额度时为 0）
+	Cost         int64  `json:"cost"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Sandbox 是否为演示用户的请求（返回预置的回答，不消耗智慧果）
+	Sandbox bool `json:"sandbox,omitempty"`
+	// Failed 请求是否失败
+	Failed      bool      `json:"failed,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// DeadLetterStore 死信表
+type DeadLetterStore interface {
+	AddDeadLetter(ctx context.Context, item repo.UsageDeadLetter) error
+	DeadLetters(ctx context.Context, after repo.UsageDeadLetterCursor, until time.Time, limit int64) ([]repo.UsageDeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id int64) error
+	UpdateDeadLetter(ctx context.Context, id int64, attempts int, lastError string) error
+}
+
+// Webhook 用量通知，没有配置通知地址时不做任何处理，可以并发使用
+type Webhook struct {
+	endpoints   []string
+	secret      string
+	maxAttempts int
+	backoff     time.Duration
+	store       DeadLetterStore
+	client      *http.Client
+	queues      []chan Event
+	// pageSize 重新发送时每次从死信表中查询的事件数量
+	pageSize int64
+}
+
+// NewWebhook 创建用量通知并启动发送事件的协程，maxAttempts 为每个事件的最大尝试次数（包括第一次），
+// backoff 为第一次重试前的等待时间，之后每次重试等待时间加倍
+func NewWebhook(endpoints []string, secret string, maxAttempts int, backoff time.Duration, store DeadLetterStore) *Webhook {
+	w := &Webhook{
+		endpoints:   endpoints,
+		secret:      secret,
+		maxAttempts: max(maxAttempts, 1),
+		backoff:     backoff,
+		store:       store,
+		client:      &http.Client{Timeout: requestTimeout},
+		pageSize:    replayPageSize,
+	}
+
+	if len(endpoints) == 0 {
+		return w
+	}
+
+	for i := 0; i < workers; i++ {
+		queue := make(chan Event, queueSize)
+		w.queues = append(w.queues, queue)
+		go w.work(queue)
+	}
+
+	return w
+}
+
+// Enabled 是否配置了通知地址
+func (w *Webhook) Enabled() bool {
+	return w != nil && len(w.queues) > 0
+}
+
+// Publish 发布用量事件，事件 ID 为空时自动生成。只将事件放入发送队列，不会阻塞，队列已满时写入死信表
+func (w *Webhook) Publish(ev Event) {
+	if !w.Enabled() {
+		return
+	}
+
+	if ev.ID == "" {
+		ev.ID = misc.UUID()
+	}
+
+	select {
+	case w.queues[uint64(ev.UserID)%uint64(len(w.queues))] <- ev:
+	default:
+		log.F(log.M{"event_id": ev.ID, "user_id": ev.UserID}).Warningf("usage webhook queue is full, event is written to dead letters")
+		go w.deadLetter(ev, "queue is full")
+	}
+}
+
+func (w *Webhook) work(queue <-chan Event) {
+	for ev := range queue {
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			log.F(log.M{"event_id": ev.ID}).Errorf("encode usage event failed: %v", err)
+			continue
+		}
+
+		for _, endpoint := range w.endpoints {
+			attempts, err := w.deliver(context.Background(), endpoint, ev.ID, payload)
+			if err != nil {
+				log.F(log.M{"event_id": ev.ID, "endpoint": endpoint, "attempts": attempts}).Warningf("usage webhook delivery failed, event is written to dead letters: %v", err)
+				w.addDeadLetter(ev, endpoint, payload, attempts, err.Error())
+			}
+		}
+	}
+}
+
+// deliver 发送事件，失败时按照指数退避重试，返回尝试的次数以及最后一次失败的原因。
+// 通知地址返回 4xx 错误（429 除外）时认为请求本身有问题，不再重试
+func (w *Webhook) deliver(ctx context.Context, endpoint, eventID string, payload []byte) (int, error) {
+	var err error
+	delay := w.backoff
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		if retryable, err = w.send(ctx, endpoint, eventID, payload); err == nil {
+			return attempt, nil
+		}
+
+		if !retryable || attempt >= w.maxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// send 发送一次事件，失败时返回是否可以重试
+func (w *Webhook) send(ctx context.Context, endpoint, eventID string, payload []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, eventID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if w.secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(w.secret, timestamp, payload))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return false, nil
+}
+
+// Sign 计算请求签名：HMAC-SHA256(secret, timestamp + "." + body) 的十六进制编码
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	_, _ = mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter 将事件发送到每个通知地址的记录都写入死信表（事件还没有发送过）
+func (w *Webhook) deadLetter(ev Event, reason string) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		log.F(log.M{"event_id": ev.ID}).Errorf("encode usage event failed: %v", err)
+		return
+	}
+
+	for _, endpoint := range w.endpoints {
+		w.addDeadLetter(ev, endpoint, payload, 0, reason)
+	}
+}
+
+func (w *Webhook) addDeadLetter(ev Event, endpoint string, payload []byte, attempts int, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := w.store.AddDeadLetter(ctx, repo.UsageDeadLetter{
+		EventID:    ev.ID,
+		UserID:     ev.UserID,
+		Endpoint:   endpoint,
+		Payload:    payload,
+		Attempts:   attempts,
+		LastError:  misc.SubString(reason, maxErrorLength),
+		OccurredAt: ev.CompletedAt,
+	})
+	if err != nil {
+		log.F(log.M{"event_id": ev.ID, "endpoint": endpoint}).Errorf("write usage webhook dead letter failed: %v", err)
+	}
+}
+
+// ReplayResult 重新发送的结果
+type ReplayResult struct {
+	// Total 本次重新发送的事件数量
+	Total     int `json:"total"`
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+}
+
+// Replay 重新发送死信表中事件发生时间在 [since, until) 之间的事件，按照事件发生的时间依次发送（每个事件只尝试一次），
+// 送达的事件从死信表中删除，仍然失败的事件更新尝试次数和错误信息。按照 (occurred_at, id) 分页查询，
+// 仍然失败的事件不会阻塞之后的事件
+func (w *Webhook) Replay(ctx context.Context, since, until time.Time) (ReplayResult, error) {
+	var res ReplayResult
+	// 事件 ID 从 1 开始，第一页包含发生时间等于 since 的事件
+	cursor := repo.UsageDeadLetterCursor{OccurredAt: since}
+
+	for {
+		items, err := w.store.DeadLetters(ctx, cursor, until, w.pageSize)
+		if err != nil {
+			return res, err
+		}
+
+		for _, item := range items {
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+
+			res.Total++
+			if _, err := w.send(ctx, item.Endpoint, item.EventID, item.Payload); err != nil {
+				res.Failed++
+				if err := w.store.UpdateDeadLetter(ctx, item.ID, item.Attempts+1, misc.SubString(err.Error(), maxErrorLength)); err != nil {
+					return res, err
+				}
+				continue
+			}
+
+			res.Delivered++
+			if err := w.store.DeleteDeadLetter(ctx, item.ID); err != nil {
+				return res, err
+			}
+		}
+
+		if int64(len(items)) < w.pageSize {
+			break
+		}
+
+		last := items[len(items)-1]
+		cursor = repo.UsageDeadLetterCursor{OccurredAt: last.OccurredAt, ID: last.ID}
+	}
+
+	return res, nil
+}
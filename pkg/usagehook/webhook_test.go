@@ -0,0 +1,256 @@
+package usagehook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// memoryStore 保存在内存中的死信表
+type memoryStore struct {
+	lock   sync.Mutex
+	items  []repo.UsageDeadLetter
+	lastID int64
+}
+
+func (s *memoryStore) AddDeadLetter(ctx context.Context, item repo.UsageDeadLetter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastID++
+	item.ID = s.lastID
+	s.items = append(s.items, item)
+	return nil
+}
+
+func (s *memoryStore) DeadLetters(ctx context.Context, after repo.UsageDeadLetterCursor, until time.Time, limit int64) ([]repo.UsageDeadLetter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	items := make([]repo.UsageDeadLetter, 0)
+	for _, item := range s.items {
+		afterCursor := item.OccurredAt.After(after.OccurredAt) || (item.OccurredAt.Equal(after.OccurredAt) && item.ID > after.ID)
+		if afterCursor && item.OccurredAt.Before(until) {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].OccurredAt.Equal(items[j].OccurredAt) {
+			return items[i].OccurredAt.Before(items[j].OccurredAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+
+	return items[:min(int64(len(items)), limit)], nil
+}
+
+func (s *memoryStore) DeleteDeadLetter(ctx context.Context, id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, item := range s.items {
+		if item.ID == id {
+			s.items = append(s.items[:i], s.items[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) UpdateDeadLetter(ctx context.Context, id int64, attempts int, lastError string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, item := range s.items {
+		if item.ID == id {
+			s.items[i].Attempts = attempts
+			s.items[i].LastError = lastError
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Items() []repo.UsageDeadLetter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]repo.UsageDeadLetter{}, s.items...)
+}
+
+// eventually 等待条件满足
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not satisfied in time")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhook_Publish(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 前两次请求失败，之后成功
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != "sha256="+Sign("secret", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var ev Event
+		_ = json.Unmarshal(body, &ev)
+		if r.Header.Get(HeaderEventID) != ev.ID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		received <- ev
+	}))
+	defer server.Close()
+
+	store := &memoryStore{}
+	hook := NewWebhook([]string{server.URL}, "secret", 3, time.Millisecond, store)
+	assert.True(t, hook.Enabled())
+
+	hook.Publish(Event{RequestID: "req-1", UserID: 1, Model: "gpt-4o", InputTokens: 10, OutputTokens: 20, Cost: 3, FinishReason: "stop"})
+
+	select {
+	case ev := <-received:
+		assert.True(t, ev.ID != "")
+		assert.Equal(t, "req-1", ev.RequestID)
+		assert.Equal(t, 20, ev.OutputTokens)
+		assert.Equal(t, "stop", ev.FinishReason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	assert.EqualValues(t, 3, calls.Load())
+	assert.Equal(t, 0, len(store.Items()))
+}
+
+func TestWebhook_DeadLetterAndReplay(t *testing.T) {
+	var calls atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	store := &memoryStore{}
+	hook := NewWebhook([]string{server.URL}, "secret", 3, time.Millisecond, store)
+
+	completedAt := time.Now()
+	hook.Publish(Event{ID: "event-1", UserID: 1, CompletedAt: completedAt})
+	eventually(t, func() bool { return len(store.Items()) == 1 })
+
+	// 达到最大尝试次数之后写入死信表
+	item := store.Items()[0]
+	assert.EqualValues(t, 3, calls.Load())
+	assert.Equal(t, "event-1", item.EventID)
+	assert.Equal(t, server.URL, item.Endpoint)
+	assert.Equal(t, 3, item.Attempts)
+	assert.Equal(t, "unexpected status code 503", item.LastError)
+
+	// 时间范围之外的事件不重新发送
+	res, err := hook.Replay(context.TODO(), completedAt.Add(time.Second), completedAt.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{}, res)
+
+	// 仍然失败时更新尝试次数
+	res, err = hook.Replay(context.TODO(), completedAt.Add(-time.Second), completedAt.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{Total: 1, Failed: 1}, res)
+	assert.Equal(t, 4, store.Items()[0].Attempts)
+
+	// 送达之后从死信表中删除
+	status.Store(http.StatusOK)
+	res, err = hook.Replay(context.TODO(), completedAt.Add(-time.Second), completedAt.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{Total: 1, Delivered: 1}, res)
+	assert.Equal(t, 0, len(store.Items()))
+}
+
+func TestWebhook_ReplayPaging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderEventID) == "failing" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	defer server.Close()
+
+	store := &memoryStore{}
+	hook := NewWebhook(nil, "", 1, time.Millisecond, store)
+	hook.pageSize = 2
+
+	// 超过一页的仍然失败的事件（包括发生时间相同的事件）排在前面，不会阻塞之后的事件
+	occurredAt := time.Now()
+	for i, eventID := range []string{"failing", "failing", "failing", "failing", "failing", "event-1", "event-2"} {
+		assert.NoError(t, store.AddDeadLetter(context.TODO(), repo.UsageDeadLetter{
+			EventID:    eventID,
+			Endpoint:   server.URL,
+			OccurredAt: occurredAt.Add(time.Duration(i/2) * time.Second),
+		}))
+	}
+
+	res, err := hook.Replay(context.TODO(), occurredAt, occurredAt.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{Total: 7, Delivered: 2, Failed: 5}, res)
+
+	items := store.Items()
+	assert.Equal(t, 5, len(items))
+	for _, item := range items {
+		assert.Equal(t, "failing", item.EventID)
+		assert.Equal(t, 1, item.Attempts)
+	}
+}
+
+func TestWebhook_ClientError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	// 4xx 错误不重试，直接写入死信表
+	store := &memoryStore{}
+	hook := NewWebhook([]string{server.URL}, "", 5, time.Millisecond, store)
+	hook.Publish(Event{ID: "event-1", UserID: 1})
+	eventually(t, func() bool { return len(store.Items()) == 1 })
+
+	assert.EqualValues(t, 1, calls.Load())
+	assert.Equal(t, 1, store.Items()[0].Attempts)
+}
+
+func TestWebhook_Disabled(t *testing.T) {
+	hook := NewWebhook(nil, "secret", 3, time.Second, &memoryStore{})
+	assert.False(t, hook.Enabled())
+	// 没有配置通知地址时不做任何处理
+	hook.Publish(Event{UserID: 1})
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// BillingModel 语音合成计费使用的模型（价格表 speech 中的模型），与实际使用的语音合成引擎无关
+const BillingModel = "tts-1"
+
 type Voice struct {
 	conf   *config.Config
 	rdb    *redis.Client
@@ -22,7 +22,9 @@ type User struct {
 	IsSetPassword bool      `json:"is_set_password,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 	UnionID       string    `json:"union_id,omitempty"`
-	withLab       bool
+	// APIKey 通过 API Key 认证时使用的 Key，包含 Key 的模型与频率限制，其它方式认证时为 nil
+	APIKey  *repo.APIKey `json:"-"`
+	withLab bool
 }
 
 func (u User) InternalUser() bool {
@@ -33,6 +35,20 @@ func (u User) ExtraPermissionUser() bool {
 	return u.UserType == repo.UserTypeExtraPermission || u.InternalUser()
 }
 
+// Sandbox 是否为演示用户，聊天请求返回预置的回答（参考 chat.SandboxChat）
+func (u User) Sandbox() bool {
+	return u.UserType == repo.UserTypeSandbox
+}
+
+// APIKeyID 通过 API Key 认证时使用的 Key ID，其它方式认证时为 0
+func (u User) APIKeyID() int64 {
+	if u.APIKey == nil {
+		return 0
+	}
+
+	return u.APIKey.ID
+}
+
 // UserOptional 用户信息，可选，如果用户未登录，则为 User 为 nil
 type UserOptional struct {
 	User *User `json:"user"`
@@ -3,9 +3,12 @@ package admin
 import (
 	"context"
 	"errors"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
 	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/secret"
 	"github.com/mylxsw/aidea-server/pkg/service"
 	"github.com/mylxsw/aidea-server/server/controllers/common"
+	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/glacier/infra"
 	"github.com/mylxsw/glacier/web"
 	"github.com/mylxsw/go-utils/array"
@@ -15,8 +18,10 @@ import (
 )
 
 type ChannelController struct {
-	repo *repo.Repository `autowire:"@"`
-	svc  *service.Service `autowire:"@"`
+	repo    *repo.Repository `autowire:"@"`
+	svc     *service.Service `autowire:"@"`
+	secrets *secret.Envelope `autowire:"@"`
+	chat    chat.Chat        `autowire:"@"`
 }
 
 func NewChannelController(resolver infra.Resolver) web.Controller {
@@ -31,6 +36,7 @@ func (ctl *ChannelController) Register(router web.Router) {
 		router.Get("/", ctl.Channels)
 		router.Post("/", ctl.Add)
 		router.Get("/{channel_id}", ctl.Channel)
+		router.Get("/{channel_id}/latency", ctl.Latency)
 		router.Put("/{channel_id}", ctl.Update)
 		router.Delete("/{channel_id}", ctl.Delete)
 	})
@@ -74,7 +80,7 @@ func (ctl *ChannelController) Channels(ctx context.Context, webCtx web.Context)
 	})
 
 	data := array.Map(channels, func(item repo.Channel, _ int) Channel {
-		item.Secret = ""
+		item.Secret = ctl.maskSecret(ctx, item.Secret)
 		ret := Channel{Channel: item}
 		if ret.Id == 0 {
 			ret.DisplayName = types[item.Name].Display
@@ -105,6 +111,8 @@ func (ctl *ChannelController) Channel(ctx context.Context, webCtx web.Context) w
 		return webCtx.JSONError(err.Error(), http.StatusInternalServerError)
 	}
 
+	channel.Secret = ctl.maskSecret(ctx, channel.Secret)
+
 	data := Channel{Channel: *channel}
 	if data.Id == 0 {
 		types := array.ToMap(ctl.svc.Chat.ChannelTypes(), func(t service.ChannelType, _ int) string {
@@ -116,6 +124,48 @@ func (ctl *ChannelController) Channel(ctx context.Context, webCtx web.Context) w
 	return webCtx.JSON(common.NewDataObj(channel))
 }
 
+// ChannelLatency 渠道最近一段时间内成功请求的耗时（毫秒）
+type ChannelLatency struct {
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+	Count int   `json:"count"`
+}
+
+// Latency Return recent latency percentiles for the specified channel.
+// @Summary Return recent latency percentiles for the specified channel.
+// @Tags Admin:Channel
+// @Accept json
+// @Produce json
+// @Param channel_id path integer true "Channel ID"
+// @Success 200 {object} common.DataObj[ChannelLatency]
+// @Router /v1/admin/channels/{channel_id}/latency [get]
+func (ctl *ChannelController) Latency(ctx context.Context, webCtx web.Context) web.Response {
+	channelID, err := strconv.Atoi(webCtx.PathVar("channel_id"))
+	if err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
+	var data ChannelLatency
+	if reporter, ok := ctl.chat.(chat.ChannelLatencyReporter); ok {
+		p50, p90, p99, count := reporter.LatencyStats(int64(channelID))
+		data = ChannelLatency{P50: p50.Milliseconds(), P90: p90.Milliseconds(), P99: p99.Milliseconds(), Count: count}
+	}
+
+	return webCtx.JSON(common.NewDataObj(data))
+}
+
+// maskSecret 返回脱敏后的渠道密钥，只保留最后 4 个字符
+func (ctl *ChannelController) maskSecret(ctx context.Context, value string) string {
+	plaintext, err := ctl.secrets.Decrypt(ctx, value)
+	if err != nil {
+		log.Errorf("decrypt channel secret failed: %v", err)
+		return secret.Mask(value)
+	}
+
+	return secret.Mask(plaintext)
+}
+
 // Add channel
 // @Summary Add channel
 // @Tags Admin:Channel
@@ -147,6 +197,10 @@ func (ctl *ChannelController) Add(ctx context.Context, webCtx web.Context) web.R
 		return webCtx.JSONError("服务器地址不合法", http.StatusBadRequest)
 	}
 
+	if err := chat.ValidateExtraBody(req.Meta.ExtraBody); err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
 	channelID, err := ctl.repo.Model.AddChannel(ctx, req)
 	if err != nil {
 		return webCtx.JSONError(err.Error(), http.StatusInternalServerError)
@@ -183,6 +237,10 @@ func (ctl *ChannelController) Update(ctx context.Context, webCtx web.Context) we
 		return webCtx.JSONError("服务器地址不合法", http.StatusBadRequest)
 	}
 
+	if err := chat.ValidateExtraBody(req.Meta.ExtraBody); err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
 	if err := ctl.repo.Model.UpdateChannel(ctx, int64(channelID), req); err != nil {
 		return webCtx.JSONError(err.Error(), http.StatusInternalServerError)
 	}
@@ -28,6 +28,7 @@ func (ctl *MessageController) Register(router web.Router) {
 		router.Get("/{user_id}/rooms/{room_id}", ctl.UserRoom)
 		router.Get("/{user_id}/rooms/{room_id}/messages", ctl.RoomMessages)
 		router.Get("/{user_id}/rooms/{room_id}/group-messages", ctl.GroupRoomMessages)
+		router.Get("/requests/{question_id}", ctl.RequestAnswers)
 	})
 
 	router.Group("/recent-messages", func(router web.Router) {
@@ -134,7 +135,34 @@ func (ctl *MessageController) RoomMessages(ctx web.Context) web.Response {
 		return ctx.JSONError(err.Error(), http.StatusInternalServerError)
 	}
 
-	return ctx.JSON(common.NewDataArray(messages))
+	return ctx.JSON(common.NewDataArray(array.Map(messages, withoutSystemPrompt)))
+}
+
+// RequestAnswers Get the answers of the specified chat request, including the effective system prompt, model and channel.
+// @Summary Get the answers of the specified chat request, including the effective system prompt, model and channel.
+// @Tags Admin:Messages
+// @Produce json
+// @Param question_id path integer true "Request ID (the ID of the user message)"
+// @Success 200 {object} common.DataArray[model.ChatMessages]
+// @Router /v1/admin/messages/requests/{question_id} [get]
+func (ctl *MessageController) RequestAnswers(ctx web.Context) web.Response {
+	questionID, err := strconv.Atoi(ctx.PathVar("question_id"))
+	if err != nil {
+		return ctx.JSONError("invalid question_id", http.StatusBadRequest)
+	}
+
+	answers, err := ctl.repo.Message.Answers(ctx, int64(questionID))
+	if err != nil {
+		return ctx.JSONError(err.Error(), http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(common.NewDataArray(answers))
+}
+
+// withoutSystemPrompt 列表中不返回系统提示语全文（只保留哈希），避免响应内容过大，全文通过 RequestAnswers 查询
+func withoutSystemPrompt(item model.ChatMessages, _ int) model.ChatMessages {
+	item.SystemPrompt = ""
+	return item
 }
 
 type ChatGroupMessage struct {
@@ -224,5 +252,5 @@ func (ctl *MessageController) RecentMessages(ctx web.Context) web.Response {
 		return ctx.JSONError(err.Error(), http.StatusInternalServerError)
 	}
 
-	return ctx.JSON(common.NewPagination(items, meta))
+	return ctx.JSON(common.NewPagination(array.Map(items, withoutSystemPrompt), meta))
 }
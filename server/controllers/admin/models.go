@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
 	"github.com/mylxsw/aidea-server/pkg/repo"
 	"github.com/mylxsw/aidea-server/pkg/service"
 	"github.com/mylxsw/aidea-server/server/controllers/common"
@@ -15,8 +16,9 @@ import (
 )
 
 type ModelController struct {
-	repo *repo.Repository `autowire:"@"`
-	svc  *service.Service `autowire:"@"`
+	repo   *repo.Repository  `autowire:"@"`
+	svc    *service.Service  `autowire:"@"`
+	prober *chat.ModelProber `autowire:"@"`
 }
 
 func NewModelController(resolver infra.Resolver) web.Controller {
@@ -33,6 +35,7 @@ func (ctl *ModelController) Register(router web.Router) {
 		router.Get("/{model_id}", ctl.Model)
 		router.Put("/{model_id}", ctl.UpdateModel)
 		router.Delete("/{model_id}", ctl.DeleteModel)
+		router.Post("/{model_id}/probe", ctl.ProbeModel)
 	})
 
 	router.Group("/free-models/daily", func(router web.Router) {
@@ -165,6 +168,30 @@ func (ctl *ModelController) DeleteModel(ctx context.Context, webCtx web.Context)
 	return webCtx.JSON(web.M{})
 }
 
+// ProbeModel Probe the upstream to detect the actual capabilities of the model.
+// @Summary Probe the upstream to detect the actual capabilities of the model, the cost is billed to the internal account.
+// @Tags Admin:Models
+// @Produce json
+// @Param model_id path string true "Model ID"
+// @Param channel_id formData int false "Channel ID, use the model's provider if not specified"
+// @Success 200 {object} common.DataObj[chat.ProbeResult]
+// @Router /v1/admin/models/{model_id}/probe [post]
+func (ctl *ModelController) ProbeModel(ctx context.Context, webCtx web.Context) web.Response {
+	modelID := webCtx.PathVar("model_id")
+	channelID := webCtx.Int64Input("channel_id", 0)
+
+	ret, err := ctl.prober.Probe(ctx, modelID, channelID)
+	if err != nil {
+		if errors.Is(err, chat.ErrProbeRateLimited) {
+			return webCtx.JSONError(err.Error(), http.StatusTooManyRequests)
+		}
+
+		return webCtx.JSONError(err.Error(), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(common.NewDataObj(ret))
+}
+
 // DailyFreeModels Return all the free model listings.
 // @Summary Return all the free model listings.
 // @Tags Admin:FreeModels
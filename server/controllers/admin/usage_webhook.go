@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/usagehook"
+	"github.com/mylxsw/glacier/infra"
+	"github.com/mylxsw/glacier/web"
+)
+
+type UsageWebhookController struct {
+	hook *usagehook.Webhook `autowire:"@"`
+}
+
+func NewUsageWebhookController(resolver infra.Resolver) web.Controller {
+	return infra.Autowire(resolver, &UsageWebhookController{})
+}
+
+func (ctl *UsageWebhookController) Register(router web.Router) {
+	router.Group("/usage-webhooks", func(router web.Router) {
+		router.Post("/replay", ctl.Replay)
+	})
+}
+
+type ReplayUsageWebhookReq struct {
+	// StartAt 开始时间（包含），RFC3339 格式
+	StartAt string `json:"start_at"`
+	// EndAt 结束时间（不包含），RFC3339 格式
+	EndAt string `json:"end_at"`
+}
+
+// Replay Resend undeliverable usage events that occurred in the time range
+// @Summary Resend undeliverable usage events that occurred in the time range
+// @Tags Admin:UsageWebhook
+// @Accept json
+// @Produce json
+// @Param request body ReplayUsageWebhookReq true "ReplayUsageWebhookReq"
+// @Success 200 {object} usagehook.ReplayResult
+// @Router /v1/admin/usage-webhooks/replay [post]
+func (ctl *UsageWebhookController) Replay(ctx context.Context, webCtx web.Context) web.Response {
+	if !ctl.hook.Enabled() {
+		return webCtx.JSONError("usage webhook is not configured", http.StatusBadRequest)
+	}
+
+	var req ReplayUsageWebhookReq
+	if err := webCtx.Unmarshal(&req); err != nil {
+		return webCtx.JSONError("invalid request", http.StatusBadRequest)
+	}
+
+	startAt, err := time.Parse(time.RFC3339, req.StartAt)
+	if err != nil {
+		return webCtx.JSONError("invalid start_at", http.StatusBadRequest)
+	}
+
+	endAt, err := time.Parse(time.RFC3339, req.EndAt)
+	if err != nil || !endAt.After(startAt) {
+		return webCtx.JSONError("invalid end_at", http.StatusBadRequest)
+	}
+
+	res, err := ctl.hook.Replay(ctx, startAt, endAt)
+	if err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(res)
+}
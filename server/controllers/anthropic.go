@@ -0,0 +1,591 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/internal/coins"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/glacier/infra"
+	"github.com/mylxsw/glacier/web"
+	"github.com/mylxsw/go-utils/ternary"
+)
+
+// errAnthropicToolUseNotSupported 暂不支持工具调用
+var errAnthropicToolUseNotSupported = errors.New("tool use is not supported yet")
+
+// Anthropic 错误类型，参考 https://docs.anthropic.com/en/api/errors
+const (
+	anthropicErrInvalidRequest  = "invalid_request_error"
+	anthropicErrBilling         = "billing_error"
+	anthropicErrNotFound        = "not_found_error"
+	anthropicErrPermission      = "permission_error"
+	anthropicErrRequestTooLarge = "request_too_large"
+	anthropicErrRateLimit       = "rate_limit_error"
+	anthropicErrAPI             = "api_error"
+	anthropicErrNotSupported    = "not_supported_error"
+)
+
+// AnthropicController Anthropic Messages API 兼容接口，供使用 Anthropic SDK 的工具调用
+//
+// 请求会被转换为 chat.Request，根据模型配置分发到对应的渠道，响应按照 Anthropic 的格式（包括 SSE 事件）返回
+type AnthropicController struct {
+	conf    *config.Config
+	userSrv *service.UserService `autowire:"@"`
+	chatSrv *service.ChatService `autowire:"@"`
+
+	// openai 复用 OpenAI 兼容接口的流控、计费逻辑以及 Chat 实现（演示用户使用预置的回答）
+	openai *OpenAIController
+}
+
+// NewAnthropicController 创建 Anthropic 兼容接口控制器
+func NewAnthropicController(resolver infra.Resolver, conf *config.Config) web.Controller {
+	ctl := &AnthropicController{conf: conf}
+	resolver.MustAutoWire(ctl)
+
+	ctl.openai = &OpenAIController{conf: conf, apiMode: true}
+	resolver.MustAutoWire(ctl.openai)
+
+	return ctl
+}
+
+// Register AnthropicController 路由注册，路由地址与 Anthropic 保持一致，以兼容 Anthropic SDK
+func (ctl *AnthropicController) Register(router web.Router) {
+	router.Post("/messages", ctl.Messages)
+}
+
+// AnthropicMessageRequest Anthropic Messages API 请求，参考 https://docs.anthropic.com/en/api/messages
+type AnthropicMessageRequest struct {
+	Model     string             `json:"model"`
+	System    AnthropicContent   `json:"system,omitempty"`
+	Messages  []AnthropicMessage `json:"messages"`
+	MaxTokens int                `json:"max_tokens"`
+	Stream    bool               `json:"stream,omitempty"`
+	// Tools 暂不支持工具调用，只用于检查请求中是否包含工具定义
+	Tools []json.RawMessage `json:"tools,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content AnthropicContent `json:"content"`
+}
+
+// AnthropicContent 消息内容，可以是字符串，也可以是内容块数组
+type AnthropicContent []AnthropicContentBlock
+
+func (c *AnthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = AnthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+
+	*c = blocks
+	return nil
+}
+
+// Text 所有文本内容块合并后的文本
+func (c AnthropicContent) Text() string {
+	texts := make([]string, 0, len(c))
+	for _, block := range c {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+type AnthropicContentBlock struct {
+	// Type 内容块类型：text/image，tool_use/tool_result 暂不支持
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *AnthropicImageSource `json:"source,omitempty"`
+}
+
+type AnthropicImageSource struct {
+	// Type 图片来源：base64/url
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// ToChatRequest 转换为 chat.Request
+func (req AnthropicMessageRequest) ToChatRequest() (*chat.Request, error) {
+	if strings.TrimSpace(req.Model) == "" {
+		return nil, errors.New("model: field required")
+	}
+
+	if req.MaxTokens <= 0 {
+		return nil, errors.New("max_tokens: field required")
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, errors.New("messages: at least one message is required")
+	}
+
+	if len(req.Tools) > 0 {
+		return nil, errAnthropicToolUseNotSupported
+	}
+
+	messages := make(chat.Messages, 0, len(req.Messages)+1)
+	if system := req.System.Text(); strings.TrimSpace(system) != "" {
+		messages = append(messages, chat.Message{Role: chat.RoleSystem, Content: system})
+	}
+
+	for i, msg := range req.Messages {
+		role, err := chat.ParseRole(msg.Role)
+		if err != nil || (role != chat.RoleUser && role != chat.RoleAssistant) {
+			return nil, fmt.Errorf("messages.%d.role: unexpected role %q", i, msg.Role)
+		}
+
+		converted, err := msg.Content.toChatMessage(role)
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d.content: %w", i, err)
+		}
+
+		messages = append(messages, converted)
+	}
+
+	ret := chat.Request{
+		Model:     req.Model,
+		Messages:  messages,
+		MaxTokens: req.MaxTokens,
+		Stream:    true,
+	}.Init()
+
+	return &ret, nil
+}
+
+func (c AnthropicContent) toChatMessage(role chat.Role) (chat.Message, error) {
+	msg := chat.Message{Role: role}
+
+	var hasImage bool
+	contents := make([]*chat.MultipartContent, 0, len(c))
+	for _, block := range c {
+		switch block.Type {
+		case "text":
+			contents = append(contents, &chat.MultipartContent{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				return msg, errors.New("image source is required")
+			}
+
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+			}
+
+			hasImage = true
+			contents = append(contents, &chat.MultipartContent{Type: "image_url", ImageURL: &chat.ImageURL{URL: url}})
+		case "tool_use", "tool_result":
+			return msg, errAnthropicToolUseNotSupported
+		default:
+			return msg, fmt.Errorf("unsupported content block type %q", block.Type)
+		}
+	}
+
+	msg.Content = c.Text()
+	if hasImage {
+		msg.MultipartContents = contents
+	}
+
+	return msg, nil
+}
+
+// AnthropicMessageResponse Anthropic Messages API 响应
+type AnthropicMessageResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicStopReason 将统一的结束原因转换为 Anthropic 的 stop_reason
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case chat.FinishReasonLength:
+		return "max_tokens"
+	case chat.FinishReasonToolCalls:
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// writeAnthropicError 以 Anthropic 的错误格式返回错误
+func writeAnthropicError(w http.ResponseWriter, statusCode int, typ string, message string) {
+	data, _ := json.Marshal(web.M{
+		"type":  "error",
+		"error": web.M{"type": typ, "message": message},
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(data)
+}
+
+// AnthropicEventWriter 按照 Anthropic 的 SSE 事件格式输出流式响应
+//
+// 事件顺序为：message_start → content_block_start → ping → content_block_delta... → content_block_stop → message_delta → message_stop，
+// 出错时输出 error 事件后结束
+type AnthropicEventWriter struct {
+	w      http.ResponseWriter
+	inited bool
+}
+
+func NewAnthropicEventWriter(w http.ResponseWriter) *AnthropicEventWriter {
+	return &AnthropicEventWriter{w: w}
+}
+
+func (ew *AnthropicEventWriter) writeEvent(event string, data web.M) error {
+	if !ew.inited {
+		ew.inited = true
+		ew.w.Header().Set("Content-Type", "text/event-stream")
+		ew.w.Header().Set("Cache-Control", "no-cache")
+		ew.w.Header().Set("Connection", "keep-alive")
+	}
+
+	data["type"] = event
+	payload, _ := json.Marshal(data)
+
+	if _, err := fmt.Fprintf(ew.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// Start 输出消息开始以及文本内容块开始事件
+func (ew *AnthropicEventWriter) Start(id, model string, inputTokens int) error {
+	if err := ew.writeEvent("message_start", web.M{
+		"message": AnthropicMessageResponse{
+			ID:      id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   model,
+			Content: []AnthropicContentBlock{},
+			Usage:   AnthropicUsage{InputTokens: inputTokens},
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := ew.writeEvent("content_block_start", web.M{
+		"index":         0,
+		"content_block": web.M{"type": "text", "text": ""},
+	}); err != nil {
+		return err
+	}
+
+	return ew.writeEvent("ping", web.M{})
+}
+
+// Delta 输出文本增量
+func (ew *AnthropicEventWriter) Delta(text string) error {
+	return ew.writeEvent("content_block_delta", web.M{
+		"index": 0,
+		"delta": web.M{"type": "text_delta", "text": text},
+	})
+}
+
+// Finish 输出内容块结束、消息增量（结束原因和 Token 用量）以及消息结束事件
+func (ew *AnthropicEventWriter) Finish(stopReason string, outputTokens int) error {
+	if err := ew.writeEvent("content_block_stop", web.M{"index": 0}); err != nil {
+		return err
+	}
+
+	if err := ew.writeEvent("message_delta", web.M{
+		"delta": web.M{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": web.M{"output_tokens": outputTokens},
+	}); err != nil {
+		return err
+	}
+
+	return ew.writeEvent("message_stop", web.M{})
+}
+
+// Error 输出错误事件
+func (ew *AnthropicEventWriter) Error(typ, message string) error {
+	return ew.writeEvent("error", web.M{"error": web.M{"type": typ, "message": message}})
+}
+
+// Messages 对话接口，接口参数参考 https://docs.anthropic.com/en/api/messages
+func (ctl *AnthropicController) Messages(ctx context.Context, webCtx web.Context, user *auth.User, quotaRepo *repo.QuotaRepo, w http.ResponseWriter, client *auth.ClientInfo) {
+	ctl.messages(ctx, webCtx.Request().Raw().Body, user, quotaRepo, w, client)
+}
+
+// messages 处理请求体为 body 的对话请求，通过 w 返回 Anthropic 格式的响应
+func (ctl *AnthropicController) messages(ctx context.Context, body io.Reader, user *auth.User, quotaRepo *repo.QuotaRepo, w http.ResponseWriter, client *auth.ClientInfo) {
+	var anthropicReq AnthropicMessageRequest
+	if err := json.NewDecoder(body).Decode(&anthropicReq); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, anthropicErrInvalidRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	req, err := anthropicReq.ToChatRequest()
+	if err != nil {
+		if errors.Is(err, errAnthropicToolUseNotSupported) {
+			writeAnthropicError(w, http.StatusNotImplemented, anthropicErrNotSupported, err.Error())
+		} else {
+			writeAnthropicError(w, http.StatusBadRequest, anthropicErrInvalidRequest, err.Error())
+		}
+		return
+	}
+
+	if err := req.Validate(ctl.conf.MaxChatMessages, chatRoleContentLimits(ctl.conf)); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, anthropicErrInvalidRequest, err.Error())
+		return
+	}
+
+	// 流控，避免单一用户过度使用
+	if err := ctl.openai.rateLimitPass(ctx, client, user); err != nil {
+		writeAnthropicError(w, http.StatusTooManyRequests, anthropicErrRateLimit, err.Error())
+		return
+	}
+
+	// 用户自定义模型替换为基础模型之后再检查 API Key 的模型限制
+	req.UserID = user.ID
+	resolved, err := ResolveAPIKeyModel(ctx, ctl.chatSrv, user, *req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAPIKeyModelNotAllowed):
+			writeAnthropicError(w, http.StatusForbidden, anthropicErrPermission, err.Error())
+		case errors.Is(err, chat.ErrUserModelNotFound):
+			writeAnthropicError(w, http.StatusNotFound, anthropicErrNotFound, err.Error())
+		default:
+			log.F(log.M{"user_id": user.ID, "model": req.Model}).Errorf("resolve user model failed: %s", err)
+			writeAnthropicError(w, http.StatusInternalServerError, anthropicErrAPI, "internal error")
+		}
+		return
+	}
+	*req = resolved
+
+	// 演示用户使用预置的回答，不查询模型信息，输入 Token 数量按照预置回答的方式估算（不加载 Token 编码）
+	var mod *repo.Model
+	var inputTokenCount int
+	if user.Sandbox() {
+		inputTokenCount = ctl.openai.sandbox.InputTokens(*req)
+	} else {
+		mod = ctl.chatSrv.Model(ctx, req.Model)
+		if mod == nil || mod.Status == repo.ModelStatusDisabled {
+			writeAnthropicError(w, http.StatusNotFound, anthropicErrNotFound, fmt.Sprintf("model: %s", req.Model))
+			return
+		}
+
+		if inputTokenCount, err = chat.MessageTokenCount(req.Messages, req.Model); err != nil {
+			writeAnthropicError(w, http.StatusBadRequest, anthropicErrInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	if err := ctl.openai.apiKeyTokensPass(ctx, user, int64(inputTokenCount)); err != nil {
+		writeAnthropicError(w, http.StatusTooManyRequests, anthropicErrRateLimit, err.Error())
+		return
+	}
+
+	// 获取当前用户剩余的免费次数，如果不足，则检查智慧果余量，演示用户不消耗智慧果和免费次数
+	leftCount := 1
+	if !user.Sandbox() {
+		leftCount, _ = ctl.chatSrv.FreeChatRequestCounts(ctx, user.ID, req.Model)
+	}
+	if leftCount <= 0 {
+		quota, err := ctl.userSrv.UserQuota(ctx, user.ID)
+		if err != nil {
+			log.F(log.M{"user_id": user.ID}).Errorf("查询用户智慧果余量失败: %s", err)
+			writeAnthropicError(w, http.StatusInternalServerError, anthropicErrAPI, "internal error")
+			return
+		}
+
+		// 假设本次请求将会消耗 500 个输出 Token
+		needCoins := coins.GetTextModelCoins(mod.ToCoinModel(), int64(inputTokenCount), 500)
+		if quota.Rest-quota.Freezed < needCoins {
+			writeAnthropicError(w, http.StatusPaymentRequired, anthropicErrBilling, "quota not enough")
+			return
+		}
+
+		// 冻结本次所需要的智慧果
+		if err := ctl.userSrv.FreezeUserQuota(ctx, user.ID, needCoins); err != nil {
+			log.F(log.M{"user_id": user.ID, "quota": needCoins}).Errorf("freeze user quota failed: %s", err)
+		} else {
+			defer func(ctx context.Context) {
+				// 解冻智慧果
+				if err := ctl.userSrv.UnfreezeUserQuota(ctx, user.ID, needCoins); err != nil {
+					log.F(log.M{"user_id": user.ID, "quota": needCoins}).Errorf("unfreeze user quota failed: %s", err)
+				}
+			}(ctx)
+		}
+	}
+
+	startTime := time.Now()
+	chatCtx, cancel := context.WithTimeout(ctx, 180*time.Second)
+	defer cancel()
+
+	// 记录实际生效的模型和渠道，用于用量通知
+	chatCtx, effective := chat.WithEffectiveRequest(chatCtx)
+
+	req.Priority = ctl.openai.priorities.Priority(user.UserType)
+	stream, err := ctl.openai.chatFor(user).ChatStream(chatCtx, *req)
+	if err != nil {
+		switch {
+		case errors.Is(err, chat.ErrContentFilter):
+			log.WithFields(log.Fields{"user_id": user.ID, "detail": chat.ErrorDetail(err)}).Warningf("聊天请求触发内容安全策略，模型 %s", req.Model)
+			writeAnthropicError(w, http.StatusBadRequest, anthropicErrInvalidRequest, chat.ContentFilterMessage(err))
+		case errors.Is(err, chat.ErrPayloadTooLarge), errors.Is(err, chat.ErrFileTooLarge):
+			writeAnthropicError(w, http.StatusRequestEntityTooLarge, anthropicErrRequestTooLarge, err.Error())
+		case errors.Is(err, chat.ErrFileTypeNotAllowed):
+			writeAnthropicError(w, http.StatusBadRequest, anthropicErrInvalidRequest, err.Error())
+		case errors.Is(err, chat.ErrVisionUnavailable), errors.Is(err, chat.ErrNoAllowedProvider):
+			writeAnthropicError(w, http.StatusServiceUnavailable, anthropicErrAPI, err.Error())
+		default:
+			log.WithFields(log.Fields{"user_id": user.ID, "detail": chat.ErrorDetail(err)}).Errorf("聊天请求失败，模型 %s: %v", req.Model, err)
+			writeAnthropicError(w, http.StatusInternalServerError, anthropicErrAPI, "internal error")
+		}
+		return
+	}
+
+	messageID := "msg_" + strings.ReplaceAll(misc.UUID(), "-", "")
+
+	var ew *AnthropicEventWriter
+	if anthropicReq.Stream {
+		ew = NewAnthropicEventWriter(w)
+		if err := ew.Start(messageID, anthropicReq.Model, inputTokenCount); err != nil {
+			return
+		}
+	}
+
+	var replyText, finishReason, chatErrorMessage string
+	var outputTokenCount int
+	func() {
+		for {
+			select {
+			case <-chatCtx.Done():
+				return
+			case res, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				// 生成的内容触发内容安全策略时，按照正常结束处理（结束原因为 content_filter）
+				if res.ContentFilter != nil {
+					log.WithFields(log.Fields{"user_id": user.ID, "detail": res.ContentFilter.Detail()}).Warningf("聊天响应触发内容安全策略")
+				} else if res.ErrorCode != "" {
+					log.WithFields(log.Fields{"user_id": user.ID, "upstream": res.Upstream}).Errorf("聊天响应失败: %v", res)
+					chatErrorMessage = ternary.If(res.Error != "", res.Error, res.ErrorCode)
+					return
+				}
+
+				if res.FinishReason != "" {
+					finishReason = res.FinishReason
+				}
+
+				if res.OutputTokens > 0 {
+					outputTokenCount = res.OutputTokens
+				}
+
+				if res.Text == "" {
+					continue
+				}
+
+				replyText += res.Text
+				if ew != nil {
+					if err := ew.Delta(res.Text); err != nil {
+						log.F(log.M{"user_id": user.ID}).Warningf("write response failed: %v", err)
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// 演示用户不计费，Token 数量使用预置回答的估算值
+	quotaConsume := QuotaConsume{InputTokens: inputTokenCount, OutputTokens: outputTokenCount}
+	if !user.Sandbox() {
+		quotaConsume = ctl.openai.resolveConsumeQuota(req, replyText, leftCount > 0, mod)
+	}
+
+	if chatErrorMessage != "" {
+		if ew != nil {
+			misc.NoError(ew.Error(anthropicErrAPI, chatErrorMessage))
+		} else {
+			writeAnthropicError(w, http.StatusInternalServerError, anthropicErrAPI, chatErrorMessage)
+		}
+	} else {
+		stopReason := anthropicStopReason(finishReason)
+		if ew != nil {
+			misc.NoError(ew.Finish(stopReason, quotaConsume.OutputTokens))
+		} else {
+			data, _ := json.Marshal(AnthropicMessageResponse{
+				ID:         messageID,
+				Type:       "message",
+				Role:       "assistant",
+				Model:      anthropicReq.Model,
+				Content:    []AnthropicContentBlock{{Type: "text", Text: replyText}},
+				StopReason: &stopReason,
+				Usage:      AnthropicUsage{InputTokens: quotaConsume.InputTokens, OutputTokens: quotaConsume.OutputTokens},
+			})
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data)
+		}
+	}
+
+	effective.FinishReason = finishReason
+	defer ctl.openai.publishUsage(messageID, user, req.Model, quotaConsume, quotaConsume.TotalPrice, effective, chatErrorMessage != "", startTime)
+
+	// 演示用户不消耗免费次数和智慧果
+	if replyText == "" || user.Sandbox() {
+		return
+	}
+
+	// 更新用户免费聊天次数
+	if err := ctl.chatSrv.UpdateFreeChatCount(ctx, user.ID, req.Model); err != nil {
+		log.WithFields(log.Fields{"user_id": user.ID, "model": req.Model}).Errorf("update free chat count failed: %s", err)
+	}
+
+	// 扣除智慧果
+	if leftCount <= 0 && quotaConsume.TotalPrice > 0 {
+		meta := repo.NewQuotaUsedMeta("chat", req.Model)
+		meta.InputToken = quotaConsume.InputTokens
+		meta.OutputToken = quotaConsume.OutputTokens
+		meta.InputPrice = quotaConsume.InputPrice
+		meta.OutputPrice = quotaConsume.OutputPrice
+		meta.APIKeyID = user.APIKeyID()
+
+		if err := quotaRepo.QuotaConsume(ctx, user.ID, quotaConsume.TotalPrice, meta); err != nil {
+			log.Errorf("used quota add failed: %s", err)
+		}
+	}
+}
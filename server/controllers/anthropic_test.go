@@ -0,0 +1,166 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/server/controllers"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestAnthropicMessageRequest_ToChatRequest(t *testing.T) {
+	data := `{
+		"model": "claude-3-opus",
+		"max_tokens": 1024,
+		"stream": true,
+		"system": [{"type": "text", "text": "You are a helpful assistant."}],
+		"messages": [
+			{"role": "user", "content": "Hello"},
+			{"role": "assistant", "content": [{"type": "text", "text": "Hi"}]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}}
+			]}
+		]
+	}`
+
+	var req controllers.AnthropicMessageRequest
+	assert.NoError(t, json.Unmarshal([]byte(data), &req))
+	assert.True(t, req.Stream)
+
+	chatReq, err := req.ToChatRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, "claude-3-opus", chatReq.Model)
+	assert.Equal(t, 1024, chatReq.MaxTokens)
+	assert.Equal(t, 4, len(chatReq.Messages))
+
+	assert.Equal(t, chat.RoleSystem, chatReq.Messages[0].Role)
+	assert.Equal(t, "You are a helpful assistant.", chatReq.Messages[0].Content)
+	assert.Equal(t, "Hello", chatReq.Messages[1].Content)
+	assert.Equal(t, chat.RoleAssistant, chatReq.Messages[2].Role)
+
+	last := chatReq.Messages[3]
+	assert.Equal(t, "What is this?", last.Content)
+	assert.Equal(t, 2, len(last.MultipartContents))
+	assert.Equal(t, "data:image/png;base64,aGVsbG8=", last.MultipartContents[1].ImageURL.URL)
+}
+
+func TestAnthropicMessageRequest_ToChatRequestInvalid(t *testing.T) {
+	cases := map[string]string{
+		"missing max_tokens": `{"model": "claude", "messages": [{"role": "user", "content": "hi"}]}`,
+		"missing model":      `{"max_tokens": 10, "messages": [{"role": "user", "content": "hi"}]}`,
+		"empty messages":     `{"model": "claude", "max_tokens": 10, "messages": []}`,
+		"invalid role":       `{"model": "claude", "max_tokens": 10, "messages": [{"role": "system", "content": "hi"}]}`,
+		"unknown block":      `{"model": "claude", "max_tokens": 10, "messages": [{"role": "user", "content": [{"type": "video"}]}]}`,
+	}
+
+	for name, data := range cases {
+		var req controllers.AnthropicMessageRequest
+		assert.NoError(t, json.Unmarshal([]byte(data), &req))
+
+		_, err := req.ToChatRequest()
+		if err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
+
+func TestAnthropicMessageRequest_ToolUseNotSupported(t *testing.T) {
+	cases := []string{
+		`{"model": "claude", "max_tokens": 10, "tools": [{"name": "search"}], "messages": [{"role": "user", "content": "hi"}]}`,
+		`{"model": "claude", "max_tokens": 10, "messages": [{"role": "user", "content": [{"type": "tool_result"}]}]}`,
+	}
+
+	for _, data := range cases {
+		var req controllers.AnthropicMessageRequest
+		assert.NoError(t, json.Unmarshal([]byte(data), &req))
+
+		_, err := req.ToChatRequest()
+		if err == nil || !strings.Contains(err.Error(), "tool use is not supported") {
+			t.Errorf("expect tool use not supported error, got %v", err)
+		}
+	}
+}
+
+type anthropicEvent struct {
+	Event string
+	Data  map[string]any
+}
+
+func parseAnthropicEvents(t *testing.T, body string) []anthropicEvent {
+	t.Helper()
+
+	var events []anthropicEvent
+	for _, chunk := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		lines := strings.SplitN(chunk, "\n", 2)
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("invalid event: %q", chunk)
+		}
+
+		var data map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data))
+
+		event := strings.TrimPrefix(lines[0], "event: ")
+		// data 中的 type 与事件名称一致
+		assert.Equal(t, event, data["type"])
+
+		events = append(events, anthropicEvent{Event: event, Data: data})
+	}
+
+	return events
+}
+
+func TestAnthropicEventWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	ew := controllers.NewAnthropicEventWriter(w)
+
+	assert.NoError(t, ew.Start("msg_1", "claude-3-opus", 12))
+	assert.NoError(t, ew.Delta("Hello"))
+	assert.NoError(t, ew.Delta(" world"))
+	assert.NoError(t, ew.Finish("end_turn", 5))
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	events := parseAnthropicEvents(t, w.Body.String())
+	names := make([]string, 0, len(events))
+	for _, evt := range events {
+		names = append(names, evt.Event)
+	}
+
+	assert.Equal(t, []string{
+		"message_start", "content_block_start", "ping",
+		"content_block_delta", "content_block_delta",
+		"content_block_stop", "message_delta", "message_stop",
+	}, names)
+
+	message := events[0].Data["message"].(map[string]any)
+	assert.Equal(t, "msg_1", message["id"])
+	assert.Equal(t, "assistant", message["role"])
+	assert.Equal(t, nil, message["stop_reason"])
+	assert.EqualValues(t, 12, message["usage"].(map[string]any)["input_tokens"])
+
+	delta := events[3].Data["delta"].(map[string]any)
+	assert.Equal(t, "text_delta", delta["type"])
+	assert.Equal(t, "Hello", delta["text"])
+
+	messageDelta := events[6].Data
+	assert.Equal(t, "end_turn", messageDelta["delta"].(map[string]any)["stop_reason"])
+	assert.EqualValues(t, 5, messageDelta["usage"].(map[string]any)["output_tokens"])
+}
+
+func TestAnthropicEventWriter_Error(t *testing.T) {
+	w := httptest.NewRecorder()
+	ew := controllers.NewAnthropicEventWriter(w)
+
+	assert.NoError(t, ew.Start("msg_1", "claude-3-opus", 12))
+	assert.NoError(t, ew.Error("api_error", "upstream failed"))
+
+	events := parseAnthropicEvents(t, w.Body.String())
+	last := events[len(events)-1]
+	assert.Equal(t, "error", last.Event)
+	assert.Equal(t, "api_error", last.Data["error"].(map[string]any)["type"])
+	assert.Equal(t, "upstream failed", last.Data["error"].(map[string]any)["message"])
+}
@@ -2,7 +2,13 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/rate"
 	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
 	"github.com/mylxsw/aidea-server/server/auth"
 	"github.com/mylxsw/aidea-server/server/controllers/common"
 	"github.com/mylxsw/asteria/log"
@@ -12,10 +18,21 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+)
+
+// ErrAPIKeyModelNotAllowed 当前 API Key 不允许使用请求的模型
+var ErrAPIKeyModelNotAllowed = errors.New("当前 API Key 不允许使用该模型")
+
+const (
+	// apiKeyDefaultValidDays 未指定有效期时，API Key 的有效天数
+	apiKeyDefaultValidDays = 365
+	// apiKeyMaxAllowedModels 每个 API Key 最多可以配置的模型（规则）数量
+	apiKeyMaxAllowedModels = 50
 )
 
 type APIKeyController struct {
-	repo *repo.Repository `autowire:"@"`
+	svc *service.APIKeyService `autowire:"@"`
 }
 
 func NewAPIKeyController(resolver infra.Resolver) web.Controller {
@@ -34,10 +51,11 @@ func (ctl *APIKeyController) Register(router web.Router) {
 	})
 }
 
-// List API Key 列表
+// List API Key 列表，包含每个 Key 最近 30 天的使用量
 func (ctl *APIKeyController) List(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
-	keys, err := ctl.repo.User.GetAPIKeys(ctx, user.ID)
+	keys, err := ctl.svc.Keys(ctx, user.ID)
 	if err != nil {
+		log.F(log.M{"user_id": user.ID}).Errorf("get api keys failed: %v", err)
 		return webCtx.JSONError(common.ErrInternalError, http.StatusInternalServerError)
 	}
 
@@ -51,40 +69,152 @@ func (ctl *APIKeyController) GetKey(ctx context.Context, webCtx web.Context, use
 		return webCtx.JSONError(common.ErrInvalidRequest, http.StatusBadRequest)
 	}
 
-	key, err := ctl.repo.User.GetAPIKey(ctx, user.ID, int64(keyID))
+	key, err := ctl.svc.Key(ctx, user.ID, int64(keyID))
 	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return webCtx.JSONError(common.ErrNotFound, http.StatusNotFound)
+		}
+
 		return webCtx.JSONError(common.ErrInternalError, http.StatusInternalServerError)
 	}
 
 	return webCtx.JSON(web.M{"data": key})
 }
 
-// Create 创建 API Key
-func (ctl *APIKeyController) Create(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
-	name := strings.TrimSpace(webCtx.Input("name"))
+// APIKeyRequest 创建 API Key 的请求参数
+type APIKeyRequest struct {
+	Name string `json:"name"`
+	// AllowedModels 允许使用的模型，支持通配符（* 匹配任意字符，? 匹配单个字符），为空时不限制
+	AllowedModels []string `json:"allowed_models,omitempty"`
+	// RPM 每分钟最大请求数，为 0 时不限制
+	RPM int64 `json:"rpm,omitempty"`
+	// TPM 每分钟最大输入 Token 数，为 0 时不限制
+	TPM int64 `json:"tpm,omitempty"`
+	// ValidDays 有效天数，为 0 时有效期为一年
+	ValidDays int64 `json:"valid_days,omitempty"`
+}
+
+// Options 校验请求参数，转换为创建 API Key 的参数
+func (req APIKeyRequest) Options(now time.Time) (repo.APIKeyOptions, error) {
+	name := strings.TrimSpace(req.Name)
 	if name == "" {
 		name = "Default"
 	}
 
-	key, err := ctl.repo.User.CreateAPIKey(ctx, user.ID, name, time.Now().AddDate(1, 0, 0))
+	if utf8.RuneCountInString(name) > 50 {
+		return repo.APIKeyOptions{}, errors.New("名称不能超过 50 个字符")
+	}
+
+	models := make([]string, 0, len(req.AllowedModels))
+	for _, m := range req.AllowedModels {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+
+	if len(models) > apiKeyMaxAllowedModels {
+		return repo.APIKeyOptions{}, fmt.Errorf("允许使用的模型不能超过 %d 个", apiKeyMaxAllowedModels)
+	}
+
+	if req.RPM < 0 || req.RPM > 10000 {
+		return repo.APIKeyOptions{}, errors.New("每分钟请求数必须为 0-10000 之间")
+	}
+
+	if req.TPM < 0 || req.TPM > 10000000 {
+		return repo.APIKeyOptions{}, errors.New("每分钟 Token 数必须为 0-10000000 之间")
+	}
+
+	if req.ValidDays < 0 || req.ValidDays > 3650 {
+		return repo.APIKeyOptions{}, errors.New("有效天数必须为 0-3650 之间")
+	}
+
+	validDays := req.ValidDays
+	if validDays == 0 {
+		validDays = apiKeyDefaultValidDays
+	}
+
+	return repo.APIKeyOptions{
+		Name:          name,
+		AllowedModels: models,
+		RPM:           req.RPM,
+		TPM:           req.TPM,
+		ValidBefore:   now.AddDate(0, 0, int(validDays)),
+	}, nil
+}
+
+// Create 创建 API Key，Key 的明文只在这里返回一次
+func (ctl *APIKeyController) Create(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	var req APIKeyRequest
+	if err := webCtx.Unmarshal(&req); err != nil {
+		return webCtx.JSONError(common.ErrInvalidRequest, http.StatusBadRequest)
+	}
+
+	opts, err := req.Options(time.Now())
+	if err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
+	key, plain, err := ctl.svc.Create(ctx, user.ID, opts)
 	if err != nil {
 		log.Errorf("create api key failed: %v", err)
 		return webCtx.JSONError(common.ErrInternalError, http.StatusInternalServerError)
 	}
 
-	return webCtx.JSON(web.M{"key": key})
+	return webCtx.JSON(web.M{"key": plain, "data": key})
 }
 
-// Delete 删除 API Key
+// Delete 吊销 API Key
 func (ctl *APIKeyController) Delete(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
 	keyID, _ := strconv.Atoi(webCtx.PathVar("id"))
 	if keyID <= 0 {
 		return webCtx.JSONError(common.ErrInvalidRequest, http.StatusBadRequest)
 	}
 
-	if err := ctl.repo.User.DeleteAPIKey(ctx, user.ID, int64(keyID)); err != nil {
+	if err := ctl.svc.Revoke(ctx, user.ID, int64(keyID)); err != nil {
 		return webCtx.JSONError(common.ErrInternalError, http.StatusInternalServerError)
 	}
 
 	return webCtx.JSON(web.M{})
 }
+
+// apiKeyModelPass 通过 API Key 调用时，检查 Key 是否允许使用请求的模型
+func apiKeyModelPass(user *auth.User, model string) error {
+	if user.APIKey == nil || user.APIKey.AllowsModel(model) {
+		return nil
+	}
+
+	return ErrAPIKeyModelNotAllowed
+}
+
+// ResolveAPIKeyModel 将请求中的用户自定义模型替换为基础模型，然后检查 API Key 是否允许使用替换之后的模型。
+// 自定义模型可以指向任意基础模型，只检查替换之前的模型名称时，Key 可以通过自定义模型使用限制之外的模型
+func ResolveAPIKeyModel(ctx context.Context, store chat.UserModelStore, user *auth.User, req chat.Request) (chat.Request, error) {
+	resolved, err := chat.ResolveUserModel(ctx, store, req)
+	if err != nil {
+		return req, err
+	}
+
+	if err := apiKeyModelPass(user, resolved.Model); err != nil {
+		return req, err
+	}
+
+	return resolved, nil
+}
+
+// apiKeyTokensPass 通过 API Key 调用时，检查本次请求的输入 Token 是否超过 Key 配置的每分钟 Token 数
+func (ctl *OpenAIController) apiKeyTokensPass(ctx context.Context, user *auth.User, inputTokens int64) error {
+	if user.APIKey == nil || user.APIKey.TPM <= 0 {
+		return nil
+	}
+
+	key := fmt.Sprintf("chat-limit:k:%d:tokens:minute", user.APIKey.ID)
+	if err := ctl.limiter.AllowNScoped(ctx, rate.Scope{Limiter: rate.LimiterAPIKey}, key, redis_rate.PerMinute(int(user.APIKey.TPM)), int(inputTokens)); err != nil {
+		if errors.Is(err, rate.ErrRateLimitExceeded) {
+			return rate.ErrRateLimitExceeded
+		}
+
+		log.F(log.M{"user_id": user.ID, "key_id": user.APIKey.ID}).Errorf("check api key token limit failed: %s", err)
+	}
+
+	return nil
+}
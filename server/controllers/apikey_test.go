@@ -0,0 +1,102 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/aidea-server/server/controllers"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestAPIKeyRequest_Options(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	opts, err := controllers.APIKeyRequest{
+		Name:          " ci ",
+		AllowedModels: []string{"gpt-4o*", " ", "claude-3-?aiku"},
+		RPM:           60,
+		TPM:           100000,
+		ValidDays:     30,
+	}.Options(now)
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", opts.Name)
+	assert.EqualValues(t, []string{"gpt-4o*", "claude-3-?aiku"}, opts.AllowedModels)
+	assert.EqualValues(t, 60, opts.RPM)
+	assert.Equal(t, now.AddDate(0, 0, 30), opts.ValidBefore)
+
+	// 未指定时使用默认的名称和有效期
+	opts, err = controllers.APIKeyRequest{}.Options(now)
+	assert.NoError(t, err)
+	assert.Equal(t, "Default", opts.Name)
+	assert.Equal(t, now.AddDate(1, 0, 0), opts.ValidBefore)
+
+	_, err = controllers.APIKeyRequest{RPM: -1}.Options(now)
+	assert.True(t, err != nil)
+
+	_, err = controllers.APIKeyRequest{ValidDays: 5000}.Options(now)
+	assert.True(t, err != nil)
+}
+
+func TestAPIKey_AllowsModel(t *testing.T) {
+	key := repo.APIKey{AllowedModels: []string{"gpt-4o*", "claude-3-?aiku"}}
+	assert.True(t, key.AllowsModel("gpt-4o-mini"))
+	assert.True(t, key.AllowsModel("claude-3-haiku"))
+	assert.False(t, key.AllowsModel("gpt-4"))
+
+	// 未配置时不限制
+	assert.True(t, repo.APIKey{}.AllowsModel("gpt-4"))
+}
+
+func TestResolveAPIKeyModel(t *testing.T) {
+	store := chat.MemoryUserModelStore{
+		"mini":   {UserId: 1, Code: "mini", Model: "gpt-3.5-turbo"},
+		"bypass": {UserId: 1, Code: "bypass", Model: "gpt-4"},
+	}
+	user := &auth.User{ID: 1, APIKey: &repo.APIKey{AllowedModels: []string{"gpt-3.5*"}}}
+
+	resolved, err := controllers.ResolveAPIKeyModel(context.TODO(), store, user, chat.Request{UserID: 1, Model: "user-model@mini"})
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-3.5-turbo", resolved.Model)
+
+	// 自定义模型指向 Key 限制之外的模型
+	_, err = controllers.ResolveAPIKeyModel(context.TODO(), store, user, chat.Request{UserID: 1, Model: "user-model@bypass"})
+	assert.True(t, errors.Is(err, controllers.ErrAPIKeyModelNotAllowed))
+
+	_, err = controllers.ResolveAPIKeyModel(context.TODO(), store, user, chat.Request{UserID: 1, Model: "gpt-4"})
+	assert.True(t, errors.Is(err, controllers.ErrAPIKeyModelNotAllowed))
+
+	_, err = controllers.ResolveAPIKeyModel(context.TODO(), store, user, chat.Request{UserID: 1, Model: "user-model@missing"})
+	assert.True(t, errors.Is(err, chat.ErrUserModelNotFound))
+
+	// 不是通过 API Key 认证时不限制
+	resolved, err = controllers.ResolveAPIKeyModel(context.TODO(), store, &auth.User{ID: 1}, chat.Request{UserID: 1, Model: "user-model@bypass"})
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4", resolved.Model)
+}
+
+func TestSummarizeAPIKeyUsages(t *testing.T) {
+	usage := func(used int64, keyID int64, input, output int) repo.QuotaUsage {
+		return repo.QuotaUsage{
+			QuotaUsage: model.QuotaUsage{Used: used},
+			QuotaMeta:  repo.QuotaUsedMeta{Tag: "chat", APIKeyID: keyID, InputToken: input, OutputToken: output},
+		}
+	}
+
+	usages := repo.SummarizeAPIKeyUsages([]repo.QuotaUsage{
+		usage(10, 1, 100, 20),
+		usage(5, 2, 50, 10),
+		usage(3, 1, 30, 5),
+		// 不是通过 API Key 产生的使用记录不参与统计
+		usage(100, 0, 1000, 200),
+	})
+
+	assert.Equal(t, 2, len(usages))
+	assert.Equal(t, repo.APIKeyUsage{Requests: 2, Used: 13, InputTokens: 130, OutputTokens: 25}, usages[1])
+	assert.Equal(t, repo.APIKeyUsage{Requests: 1, Used: 5, InputTokens: 50, OutputTokens: 10}, usages[2])
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/ai/streamwriter"
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/rate"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/aidea-server/server/controllers/common"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/glacier/web"
+)
+
+// sessionCommitRequest 提交增量输入会话的请求，请求内容在打开会话时已经提供，这里不需要任何参数
+type sessionCommitRequest struct{}
+
+func (req sessionCommitRequest) Init() sessionCommitRequest {
+	return req
+}
+
+// OpenSession 打开增量输入会话，请求参数与聊天接口相同（n 为房间 ID），最后一条消息必须是用户消息，
+// 之后可以多次追加内容到最后一条用户消息，提交时按照普通的聊天请求处理
+func (ctl *OpenAIController) OpenSession(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	var req chat.Request
+	if err := webCtx.Unmarshal(&req); err != nil {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	if err := req.Validate(ctl.conf.MaxChatMessages, chatRoleContentLimits(ctl.conf)); err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
+	if err := ctl.sessions.Open(ctx, user.ID, int64(req.N), req); err != nil {
+		return ctl.sessionError(webCtx, user, int64(req.N), err)
+	}
+
+	return webCtx.JSON(web.M{})
+}
+
+// AppendSession 追加文本片段（参数 text）到增量输入会话的最后一条用户消息
+func (ctl *OpenAIController) AppendSession(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	roomID, err := strconv.ParseInt(webCtx.PathVar("room_id"), 10, 64)
+	if err != nil {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	if err := ctl.sessions.Append(ctx, user.ID, roomID, webCtx.Input("text")); err != nil {
+		return ctl.sessionError(webCtx, user, roomID, err)
+	}
+
+	return webCtx.JSON(web.M{})
+}
+
+// CommitSession 提交增量输入会话，与聊天接口一样返回 SSE 流（ws=true 时使用 WebSocket，第一条消息为空的 JSON 对象）
+func (ctl *OpenAIController) CommitSession(ctx context.Context, webCtx web.Context, user *auth.User, quotaRepo *repo.QuotaRepo, w http.ResponseWriter, client *auth.ClientInfo) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	roomID, err := strconv.ParseInt(webCtx.PathVar("room_id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": %s}`, strconv.Quote(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest)))))
+		return
+	}
+
+	// 流控，与聊天接口相同
+	if err := ctl.rateLimitPass(ctx, client, user); err != nil {
+		if errors.Is(err, rate.ErrDailyFreeLimitExceeded) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": %s}`, strconv.Quote(err.Error()))))
+		return
+	}
+
+	sw, _, err := streamwriter.New[sessionCommitRequest](
+		webCtx.Input("ws") == "true", ctl.conf.EnableCORS, webCtx.Request().Raw(), w,
+	)
+	if err != nil {
+		log.F(log.M{"user": user.ID, "client": client}).Errorf("create stream writer failed: %s", err)
+		return
+	}
+	defer sw.Close()
+
+	committed, err := ctl.sessions.Commit(ctx, user.ID, roomID)
+	if err != nil {
+		if errors.Is(err, chat.ErrSessionNotFound) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusNotFound))
+		} else {
+			log.F(log.M{"user": user.ID, "room_id": roomID}).Errorf("commit input session failed: %s", err)
+			misc.NoError(sw.WriteErrorStream(errors.New(common.Text(webCtx, ctl.translater, common.ErrInternalError)), http.StatusInternalServerError))
+		}
+		return
+	}
+
+	req := committed.Init()
+	ctl.serveChat(ctx, webCtx, user, quotaRepo, client, sw, &req)
+}
+
+func (ctl *OpenAIController) sessionError(webCtx web.Context, user *auth.User, roomID int64, err error) web.Response {
+	switch {
+	case errors.Is(err, chat.ErrSessionNotFound):
+		return webCtx.JSONError(err.Error(), http.StatusNotFound)
+	case errors.Is(err, chat.ErrTooManySessions):
+		return webCtx.JSONError(err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, chat.ErrSessionTooLarge), errors.Is(err, chat.ErrSessionInvalidLast):
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
+	log.F(log.M{"user": user.ID, "room_id": roomID}).Errorf("update input session failed: %s", err)
+	return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/mylxsw/aidea-server/internal/coins"
+	openaiHelper "github.com/mylxsw/aidea-server/pkg/ai/openai"
+	"github.com/mylxsw/aidea-server/pkg/misc"
+	"github.com/mylxsw/aidea-server/pkg/rate"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/aidea-server/server/controllers/common"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/glacier/web"
+)
+
+// embeddingMaxInputs 一次向量化请求最多的输入数量，超过服务提供商单次请求的上限时由 EmbeddingClient 拆分为多个请求
+const embeddingMaxInputs = 10 * openaiHelper.EmbeddingMaxInputs
+
+// embeddingRequest 向量化接口的请求参数，input 可以是字符串或者字符串数组
+type embeddingRequest struct {
+	Input      any    `json:"input"`
+	Model      string `json:"model"`
+	Dimensions int    `json:"dimensions,omitempty"`
+}
+
+// inputs 返回请求中的所有输入，格式错误或者包含空字符串时返回 nil
+func (req embeddingRequest) inputs() []string {
+	switch input := req.Input.(type) {
+	case string:
+		if input == "" {
+			return nil
+		}
+
+		return []string{input}
+	case []any:
+		ret := make([]string, 0, len(input))
+		for _, item := range input {
+			text, ok := item.(string)
+			if !ok || text == "" {
+				return nil
+			}
+
+			ret = append(ret, text)
+		}
+
+		return ret
+	}
+
+	return nil
+}
+
+// Embeddings 向量化接口，接口参数参考 https://platform.openai.com/docs/api-reference/embeddings/create
+//
+// 输入数量超过服务提供商单次请求的上限（2048）时自动拆分为多个请求，按照原始顺序返回结果，按照汇总的 Token 数量计费
+func (ctl *OpenAIController) Embeddings(ctx context.Context, webCtx web.Context, user *auth.User, quotaRepo *repo.QuotaRepo) web.Response {
+	var req embeddingRequest
+	if err := webCtx.Unmarshal(&req); err != nil {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	inputs := req.inputs()
+	if req.Model == "" || len(inputs) == 0 || len(inputs) > embeddingMaxInputs {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	if ctl.conf.EnableModelRateLimit {
+		if err := ctl.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterUser, Channel: req.Model}, fmt.Sprintf("chat-limit:u:%d:m:%s:minute", user.ID, req.Model), redis_rate.PerMinute(10)); err != nil {
+			if errors.Is(err, rate.ErrRateLimitExceeded) {
+				return webCtx.JSONError("操作频率过高，请稍后再试", http.StatusTooManyRequests)
+			}
+
+			log.F(log.M{"user_id": user.ID, "model": req.Model}).Errorf("check rate limit failed: %s", err)
+		}
+	}
+
+	quota, err := ctl.userSrv.UserQuota(ctx, user.ID)
+	if err != nil {
+		log.F(log.M{"user_id": user.ID}).Errorf("查询用户智慧果余量失败: %s", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	// 按照字数预估 Token 数量，实际按照服务提供商返回的 Token 数量计费
+	var words int64
+	for _, input := range inputs {
+		words += misc.WordCount(input)
+	}
+
+	needCoins := coins.ComputeFee(coins.EmbeddingUsage{Model: req.Model, Tokens: words})
+	if quota.Rest-quota.Freezed < needCoins {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrQuotaNotEnough), http.StatusPaymentRequired)
+	}
+
+	// 冻结本次所需要的智慧果
+	if err := ctl.userSrv.FreezeUserQuota(ctx, user.ID, needCoins); err != nil {
+		log.F(log.M{"user_id": user.ID, "quota": needCoins}).Errorf("freeze user quota failed: %s", err)
+	} else {
+		defer func(ctx context.Context) {
+			// 解冻智慧果
+			if err := ctl.userSrv.UnfreezeUserQuota(ctx, user.ID, needCoins); err != nil {
+				log.F(log.M{"user_id": user.ID, "quota": needCoins}).Errorf("unfreeze user quota failed: %s", err)
+			}
+		}(ctx)
+	}
+
+	resp, err := ctl.embeddings.CreateEmbeddings(ctx, openaiHelper.EmbeddingRequest{
+		Input:      inputs,
+		Model:      req.Model,
+		Dimensions: req.Dimensions,
+	})
+	if err != nil {
+		log.F(log.M{"user_id": user.ID, "model": req.Model, "inputs": len(inputs)}).Errorf("create embeddings failed: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	if err := quotaRepo.QuotaConsume(ctx, user.ID, coins.ComputeFee(resp.Usage.ToCoinUsage(req.Model)), repo.NewQuotaUsedMeta("openai-embedding", req.Model)); err != nil {
+		log.Errorf("used quota add failed: %s", err)
+	}
+
+	return webCtx.JSON(resp)
+}
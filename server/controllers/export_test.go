@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/ai/streamwriter"
+	"github.com/mylxsw/aidea-server/server/auth"
+)
+
+// NewSandboxOpenAIController 只注入演示用户使用的 Chat 实现，其它服务（模型、计费、聊天记录等）均为空，
+// 演示用户的请求访问这些服务时会直接 panic
+func NewSandboxOpenAIController(conf *config.Config, sandbox *chat.SandboxChat) *OpenAIController {
+	return &OpenAIController{conf: conf, sandbox: sandbox}
+}
+
+// NewSandboxAnthropicController 与 NewSandboxOpenAIController 相同，只注入演示用户使用的 Chat 实现
+func NewSandboxAnthropicController(conf *config.Config, sandbox *chat.SandboxChat) *AnthropicController {
+	return &AnthropicController{conf: conf, openai: &OpenAIController{conf: conf, apiMode: true, sandbox: sandbox}}
+}
+
+// ServeChat 以 SSE 的方式处理 r 中的聊天请求
+func (ctl *OpenAIController) ServeChat(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	sw, req, err := streamwriter.New[chat.Request](false, false, r, w)
+	if err != nil {
+		return
+	}
+	defer sw.Close()
+
+	ctl.serveChat(r.Context(), nil, user, nil, &auth.ClientInfo{}, sw, req)
+}
+
+// ServeMessages 处理 r 中的 Anthropic 对话请求
+func (ctl *AnthropicController) ServeMessages(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	ctl.messages(r.Context(), r.Body, user, nil, w, &auth.ClientInfo{})
+}
@@ -1,13 +1,25 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/aidea-server/pkg/youdao"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/aidea-server/server/controllers/common"
+	"github.com/mylxsw/asteria/log"
 	"github.com/mylxsw/glacier/infra"
 	"github.com/mylxsw/glacier/web"
 )
 
 type MessageController struct {
-	// repo *repo.Repository `autowire:"@"`
-	// conf *config.Config   `autowire:"@"`
+	svc        *service.Service  `autowire:"@"`
+	translater youdao.Translater `autowire:"@"`
 }
 
 func NewMessageController(resolver infra.Resolver) web.Controller {
@@ -18,6 +30,177 @@ func NewMessageController(resolver infra.Resolver) web.Controller {
 
 func (ctl *MessageController) Register(router web.Router) {
 	router.Group("/messages", func(router web.Router) {
-
+		router.Get("/search", ctl.Search)
+		router.Delete("/", ctl.DeleteAll)
+		router.Get("/tombstones", ctl.Tombstones)
+		router.Get("/pinned", ctl.PinnedMessages)
+		router.Post("/{id}/pin", ctl.Pin)
+		router.Delete("/{id}/pin", ctl.Unpin)
+		router.Post("/{id}/feedback", ctl.Feedback)
 	})
 }
+
+// Search 搜索当前用户的聊天记录
+//
+// 参数：keyword 搜索关键词（多个词使用空格分隔），room_id 房间，model 模型，
+// start_date/end_date 日期范围（YYYY-MM-DD，包含结束日期），exclude_private 是否排除私密房间（默认排除），page/per_page 分页
+func (ctl *MessageController) Search(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	page := webCtx.Int64Input("page", 1)
+	if page < 1 || page > 100 {
+		page = 1
+	}
+
+	perPage := webCtx.Int64Input("per_page", 20)
+	if perPage < 1 || perPage > 50 {
+		perPage = 20
+	}
+
+	req := service.HistorySearchReq{
+		Keyword:        webCtx.Input("keyword"),
+		RoomID:         webCtx.Int64Input("room_id", 0),
+		Model:          webCtx.Input("model"),
+		ExcludePrivate: webCtx.InputWithDefault("exclude_private", "true") != "false",
+		Page:           page,
+		PerPage:        perPage,
+	}
+
+	if startDate := webCtx.Input("start_date"); startDate != "" {
+		startAt, err := time.ParseInLocation(time.DateOnly, startDate, time.Local)
+		if err != nil {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+		}
+
+		req.StartAt = startAt
+	}
+
+	if endDate := webCtx.Input("end_date"); endDate != "" {
+		endAt, err := time.ParseInLocation(time.DateOnly, endDate, time.Local)
+		if err != nil {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+		}
+
+		req.EndAt = endAt.AddDate(0, 0, 1)
+	}
+
+	items, meta, err := ctl.svc.HistorySearch.Search(ctx, user.ID, req)
+	if err != nil {
+		if errors.Is(err, service.ErrSearchKeywordInvalid) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, err.Error()), http.StatusBadRequest)
+		}
+
+		if errors.Is(err, repo.ErrNotFound) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrNotFound), http.StatusNotFound)
+		}
+
+		log.F(log.M{"user_id": user.ID}).Errorf("搜索聊天记录失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(common.NewPagination(items, meta))
+}
+
+// DeleteAll 立即删除当前用户的所有聊天记录（包括群聊消息和回复中生成的文件），智慧果消耗等统计数据不受影响
+func (ctl *MessageController) DeleteAll(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	if err := ctl.svc.HistoryRetention.DeleteAll(ctx, user.ID); err != nil {
+		log.F(log.M{"user_id": user.ID}).Errorf("删除用户的所有聊天记录失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{})
+}
+
+// Tombstones 查询当前用户的聊天记录删除标记，同步客户端据此清理本地的聊天记录
+//
+// 参数：after_id 上次同步的最大删除标记 ID，limit 返回的最大数量（最多 500）。
+// 每个删除标记表示房间（room_id）中 ID 不大于 max_message_id 的消息都已经删除
+func (ctl *MessageController) Tombstones(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	tombstones, err := ctl.svc.HistoryRetention.Tombstones(ctx, user.ID, webCtx.Int64Input("after_id", 0), webCtx.Int64Input("limit", 100))
+	if err != nil {
+		log.F(log.M{"user_id": user.ID}).Errorf("查询聊天记录删除标记失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{"data": tombstones})
+}
+
+// PinnedMessages 查询房间中固定的消息
+//
+// 参数：room_id 房间
+func (ctl *MessageController) PinnedMessages(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	roomID := webCtx.Int64Input("room_id", 0)
+	if roomID <= 0 {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	messages, err := ctl.svc.Chat.PinnedMessages(ctx, user.ID, roomID)
+	if err != nil {
+		log.F(log.M{"user_id": user.ID, "room_id": roomID}).Errorf("查询房间固定的消息失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{"data": messages})
+}
+
+// Pin 固定消息，固定的消息始终包含在房间的对话上下文中，不会因为上下文缩减被丢弃
+func (ctl *MessageController) Pin(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	return ctl.setPinned(ctx, webCtx, user, true)
+}
+
+// Unpin 取消固定消息
+func (ctl *MessageController) Unpin(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	return ctl.setPinned(ctx, webCtx, user, false)
+}
+
+// Feedback 用户对回答的负面反馈，之后该房间中的请求尽量避开生成该回答的渠道
+//
+// 参数：kind 反馈类型，thumbs_down 点踩，regenerate 重新生成
+func (ctl *MessageController) Feedback(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	messageID, err := strconv.ParseInt(webCtx.PathVar("id"), 10, 64)
+	if err != nil || messageID <= 0 {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	kind := service.AnswerFeedback(webCtx.Input("kind"))
+	if err := ctl.svc.Chat.RecordAnswerFeedback(ctx, user.ID, messageID, kind); err != nil {
+		if errors.Is(err, service.ErrInvalidFeedback) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+		}
+
+		if errors.Is(err, repo.ErrNotFound) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrNotFound), http.StatusNotFound)
+		}
+
+		log.F(log.M{"user_id": user.ID, "message_id": messageID, "kind": kind}).Errorf("记录回答反馈失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{})
+}
+
+func (ctl *MessageController) setPinned(ctx context.Context, webCtx web.Context, user *auth.User, pinned bool) web.Response {
+	messageID, err := strconv.ParseInt(webCtx.PathVar("id"), 10, 64)
+	if err != nil || messageID <= 0 {
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest), http.StatusBadRequest)
+	}
+
+	if pinned {
+		err = ctl.svc.Chat.PinMessage(ctx, user.ID, messageID)
+	} else {
+		err = ctl.svc.Chat.UnpinMessage(ctx, user.ID, messageID)
+	}
+
+	if err != nil {
+		if errors.Is(err, service.ErrPinnedMessagesExceed) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, err.Error()), http.StatusBadRequest)
+		}
+
+		if errors.Is(err, repo.ErrNotFound) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrNotFound), http.StatusNotFound)
+		}
+
+		log.F(log.M{"user_id": user.ID, "message_id": messageID, "pinned": pinned}).Errorf("固定消息失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{})
+}
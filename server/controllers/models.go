@@ -52,7 +52,15 @@ type Model struct {
 
 // Models 获取模型列表
 func (ctl *ModelController) Models(ctx context.Context, webCtx web.Context, client *auth.ClientInfo, user *auth.UserOptional) web.Response {
-	models := array.Map(ctl.svc.Chat.Models(ctx, true), func(item repo.Model, _ int) Model {
+	var userID int64
+	if user.User != nil {
+		userID = user.User.ID
+	}
+
+	// 用户自定义模型（自己创建的和公开的）排在系统模型之后，模型 ID 以 user-model@ 开头
+	allModels := append(ctl.svc.Chat.Models(ctx, true), ctl.svc.Chat.UserModels(ctx, userID)...)
+
+	models := array.Map(allModels, func(item repo.Model, _ int) Model {
 		ret := Model{
 			ID:            item.ModelId,
 			Name:          item.Name,
@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,10 +15,13 @@ import (
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	"github.com/mylxsw/aidea-server/pkg/rate"
 	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
 	"github.com/mylxsw/aidea-server/pkg/service"
 	"github.com/mylxsw/aidea-server/pkg/tencent"
+	"github.com/mylxsw/aidea-server/pkg/usagehook"
 	"github.com/mylxsw/aidea-server/pkg/youdao"
 	"github.com/mylxsw/go-utils/array"
+	"hash"
 	"net/http"
 	"os"
 	"strconv"
@@ -49,6 +55,29 @@ type OpenAIController struct {
 	chatSrv     *service.ChatService     `autowire:"@"`
 	limiter     *rate.RateLimiter        `autowire:"@"`
 	repo        *repo.Repository         `autowire:"@"`
+	compressor  *chat.Compressor         `autowire:"@"`
+	templates   chat.PromptTemplateStore `autowire:"@"`
+	sessions    *chat.SessionManager     `autowire:"@"`
+	// embeddings 向量化接口，输入数量超过服务提供商的上限时自动拆分请求
+	embeddings *openaiHelper.EmbeddingClient `autowire:"@"`
+
+	// historySearch 聊天记录搜索，保存聊天记录之后异步写入搜索索引
+	historySearch *service.HistorySearchService `autowire:"@"`
+	// usageHook 用量通知，请求完成后异步发送用量事件
+	usageHook *usagehook.Webhook `autowire:"@"`
+	// sandbox 演示用户使用的服务提供商，返回预置的回答
+	sandbox *chat.SandboxChat `autowire:"@"`
+	// taskProfiles 按照任务类型调整采样参数的配置，未配置时为空
+	taskProfiles chat.TaskProfiles `autowire:"@"`
+
+	// imageToolLimits 每个对话中最多可以通过图片生成工具生成的图片数量
+	imageToolLimits chat.ToolLimits
+	// webSearchLimits 每个请求中最多可以通过网页搜索工具搜索的次数
+	webSearchLimits chat.ToolLimits
+	// toolLoopBudgets 服务端工具调用循环的耗时预算（秒）
+	toolLoopBudgets chat.ToolLimits
+	// priorities 用户类型对应的请求优先级，达到并发上限时优先处理优先级高的请求
+	priorities chat.Priorities
 
 	upgrader websocket.Upgrader
 
@@ -60,6 +89,11 @@ func NewOpenAIController(resolver infra.Resolver, conf *config.Config, apiMode b
 	ctl := &OpenAIController{conf: conf, apiMode: apiMode}
 	resolver.MustAutoWire(ctl)
 
+	ctl.imageToolLimits = chat.ParseToolLimits(conf.ChatImageToolLimit, conf.ChatImageToolTierLimits)
+	ctl.webSearchLimits = chat.ParseToolLimits(conf.ChatWebSearchLimit, conf.ChatWebSearchTierLimits)
+	ctl.toolLoopBudgets = chat.ParseToolLimits(conf.ChatToolLoopBudget, conf.ChatToolLoopBudgetTiers)
+	ctl.priorities = chat.ParsePriorities(conf.ChatPriorityTiers)
+
 	ctl.upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
@@ -74,6 +108,11 @@ func (ctl *OpenAIController) Register(router web.Router) {
 	// chat 相关接口
 	router.Group("/chat", func(router web.Router) {
 		router.Any("/completions", ctl.Chat)
+
+		// 增量输入会话：打开会话、追加内容，提交后按照普通的聊天请求处理
+		router.Post("/sessions", ctl.OpenSession)
+		router.Post("/sessions/{room_id}/fragments", ctl.AppendSession)
+		router.Any("/sessions/{room_id}/commit", ctl.CommitSession)
 	})
 
 	router.Group("/audio", func(router web.Router) {
@@ -84,6 +123,8 @@ func (ctl *OpenAIController) Register(router web.Router) {
 	router.Group("/images", func(router web.Router) {
 		router.Post("/generations", ctl.Images)
 	})
+
+	router.Post("/embeddings", ctl.Embeddings)
 }
 
 // audioTranscriptions 语音转文本
@@ -93,7 +134,7 @@ func (ctl *OpenAIController) audioTranscriptions(ctx context.Context, webCtx web
 	model := ternary.If(ctl.conf.UseTencentVoiceToText, "tencent", "whisper-1")
 
 	if ctl.conf.EnableModelRateLimit {
-		if err := ctl.limiter.Allow(ctx, fmt.Sprintf("chat-limit:u:%d:m:%s:minute", user.ID, model), redis_rate.PerMinute(5)); err != nil {
+		if err := ctl.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterUser, Channel: model}, fmt.Sprintf("chat-limit:u:%d:m:%s:minute", user.ID, model), redis_rate.PerMinute(5)); err != nil {
 			if errors.Is(err, rate.ErrRateLimitExceeded) {
 				return webCtx.JSONError("操作频率过高，请稍后再试", http.StatusTooManyRequests)
 			}
@@ -211,6 +252,8 @@ type FinalMessage struct {
 	AnswerID      int64  `json:"answer_id,omitempty"`
 	Info          string `json:"info,omitempty"`
 	Error         string `json:"error,omitempty"`
+	// MaxTokensAdjustment 剩余的上下文长度不足时，最大输出 Token 数量的调整记录
+	MaxTokensAdjustment *chat.MaxTokensAdjustment `json:"max_tokens_adjustment,omitempty"`
 }
 
 func (m FinalMessage) ToJSON() string {
@@ -258,20 +301,74 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 	}
 	defer sw.Close()
 
+	ctl.serveChat(ctx, webCtx, user.User, quotaRepo, client, sw, req)
+}
+
+// serveChat 处理已经解析的聊天请求：参数校验、内容安全检测、计费、保存聊天记录，通过 sw 返回响应流
+func (ctl *OpenAIController) serveChat(ctx context.Context, webCtx web.Context, user *auth.User, quotaRepo *repo.QuotaRepo, client *auth.ClientInfo, sw *streamwriter.StreamWriter, req *chat.Request) {
 	subCtx, subCancel := context.WithCancel(ctx)
 	sw.SetOnClosed(subCancel)
 
+	// 消息数量过多或者内容过长时直接拒绝，避免后续处理消耗过多资源
+	if err := req.Validate(ctl.conf.MaxChatMessages, chatRoleContentLimits(ctl.conf)); err != nil {
+		misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
+		return
+	}
+
+	// 演示用户使用预置的回答，不经过模型查询、上下文处理、计费和聊天记录
+	if user.Sandbox() {
+		ctl.serveSandboxChat(subCtx, webCtx, user, sw, req)
+		return
+	}
+
+	// 展开请求中引用的提示语模板，后续的内容检测、Token 计算与计费都基于展开后的消息
+	var err error
+	if *req, err = chat.ExpandPromptTemplate(subCtx, ctl.templates, *req); err != nil {
+		if errors.Is(err, chat.ErrPromptTemplateNotFound) || errors.Is(err, chat.ErrPromptTemplateVariableMissing) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
+		} else {
+			log.F(log.M{"user": user.ID, "template": req.PromptTemplateID}).Errorf("expand prompt template failed: %s", err)
+			misc.NoError(sw.WriteErrorStream(errors.New(common.Text(webCtx, ctl.translater, common.ErrInternalError)), http.StatusInternalServerError))
+		}
+		return
+	}
+
 	// 匿名用户，使用免费模型代替
-	if user.User.ID == 0 && ctl.conf.FreeChatModel != "" {
+	if user.ID == 0 && ctl.conf.FreeChatModel != "" {
 		req.Model = ctl.conf.FreeChatModel
 	}
 
 	// 请求参数预处理
 	var inputTokenCount, maxContextLen int64
 
+	req.UserID = user.ID
+	req.Priority = ctl.priorities.Priority(user.UserType)
+	// 客户端发送的历史消息中已经生成的图片数量，只在没有保存聊天记录时使用，需要在缩减上下文之前统计
+	historyImages := chat.CountGeneratedImages(req.Messages)
+
+	// 原始模式只有内部用户可以使用
+	if req.RawMode && !user.InternalUser() {
+		req.RawMode = false
+	}
+
+	// 额外参数只有内部用户和 API 调用方可以指定
+	if len(req.ExtraBody) > 0 {
+		if !ctl.apiMode && !user.InternalUser() {
+			req.ExtraBody = nil
+		} else if err := chat.ValidateExtraBody(req.ExtraBody); err != nil {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
+			return
+		}
+	}
+
 	if ctl.apiMode {
 		// API 模式下，还原 n 参数原始值（不支持 room 上下文配置）
 		req.N = int(req.RoomID)
+		if err := ctl.resolveUserModel(subCtx, user, req, sw); err != nil {
+			return
+		}
+		*req = ctl.applyRequestDefaults(subCtx, *req, chat.RequestDefaults{})
+
 		icnt, err := chat.MessageTokenCount(req.Messages, req.Model)
 		if err != nil {
 			misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
@@ -279,6 +376,10 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 		}
 
 		inputTokenCount = int64(icnt)
+		if err := ctl.apiKeyTokensPass(subCtx, user, inputTokenCount); err != nil {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusTooManyRequests))
+			return
+		}
 	} else {
 		// 支持 V2 版本的 homeModel 请求
 		// model 格式为 v2@{type}|{id}
@@ -286,17 +387,14 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 			models := array.ToMap(ctl.chatSrv.Models(subCtx, true), func(item repo.Model, _ int) string {
 				return item.ModelId
 			})
-			homeModel, err := ctl.userSrv.QueryHomeModel(subCtx, models, user.User.ID, strings.TrimPrefix(req.Model, "v2@"))
+			homeModel, err := ctl.userSrv.QueryHomeModel(subCtx, models, user.ID, strings.TrimPrefix(req.Model, "v2@"))
 			if err != nil {
 				misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
 				return
 			}
 
 			req.Model = homeModel.ModelID
-			if strings.TrimSpace(homeModel.Prompt) != "" {
-				contextMessages := array.Filter(req.Messages, func(item chat.Message, _ int) bool { return item.Role != "system" })
-				req.Messages = append(chat.Messages{{Role: "system", Content: homeModel.Prompt}}, contextMessages...)
-			}
+			req.PersonaPrompt = homeModel.Prompt
 		}
 
 		// 每次对话用户可以手动选择要使用的模型
@@ -304,9 +402,36 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 			req.Model = req.TempModel
 		}
 
+		if err := ctl.resolveUserModel(subCtx, user, req, sw); err != nil {
+			return
+		}
+
 		// 模型最大上下文长度限制
-		maxContextLen = ctl.loadRoomContextLen(subCtx, req.RoomID, user.User.ID)
-		req, inputTokenCount, err = req.Fix(ctl.chat, maxContextLen, ternary.If(user.User.ID > 0, 1000*200, 1000))
+		roomSettings := ctl.loadRoomSettings(subCtx, req.RoomID, user.ID)
+		maxContextLen = roomSettings.MaxContext
+
+		// 合并连续的用户消息，避免用户连续发送多条消息时，较早的消息被丢弃
+		if roomSettings.MergeUserMessages {
+			req.Messages = req.Messages.MergeUserMessages()
+		}
+
+		// 房间中固定的消息始终包含在上下文中
+		req.Messages = req.Messages.WithPinned(ctl.pinnedMessages(subCtx, user.ID, req.RoomID))
+
+		// 填充请求中未指定的参数，需要在 Fix 之前执行
+		*req = ctl.applyRequestDefaults(subCtx, *req, roomSettings.Defaults)
+		req.MinOutputTokens = max(req.MinOutputTokens, ctl.conf.ChatMinOutputTokens)
+		req.MaxImages = roomSettings.MaxImages
+		req.OutputTokenLimit = roomSettings.MaxOutputTokens
+		// 尽量避开房间中用户点踩或者重新生成的回答使用的渠道
+		req.AvoidChannels = ctl.avoidedChannels(subCtx, user.ID, req.RoomID)
+
+		req, inputTokenCount, err = req.Fix(ctl.chat, maxContextLen, ternary.If(user.ID > 0, 1000*200, 1000))
+		if errors.Is(err, chat.ErrEmptyMessages) {
+			misc.NoError(sw.WriteErrorStream(errors.New(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest)), http.StatusBadRequest))
+			return
+		}
+
 		if err != nil {
 			misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
 			return
@@ -323,8 +448,8 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 	// 免费模型
 	// 获取当前用户剩余的智慧果数量，如果不足，则返回错误
 	var leftCount, maxFreeCount int
-	if user.User.ID > 0 {
-		leftCount, maxFreeCount = ctl.chatSrv.FreeChatRequestCounts(subCtx, user.User.ID, req.Model)
+	if user.ID > 0 {
+		leftCount, maxFreeCount = ctl.chatSrv.FreeChatRequestCounts(subCtx, user.ID, req.Model)
 	} else {
 		// 匿名用户，每次都是免费的，不限制次数，通过流控来限制访问
 		leftCount, maxFreeCount = 1, 0
@@ -338,7 +463,7 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 	}
 
 	if leftCount <= 0 {
-		quota, needCoins, err := ctl.queryChatQuota(subCtx, user.User, sw, webCtx, inputTokenCount, mod)
+		quota, needCoins, err := ctl.queryChatQuota(subCtx, user, sw, webCtx, inputTokenCount, mod)
 		if err != nil {
 			return
 		}
@@ -355,32 +480,46 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 		}
 
 		// 冻结本次所需要的智慧果
-		if err := ctl.userSrv.FreezeUserQuota(ctx, user.User.ID, needCoins); err != nil {
-			log.F(log.M{"user_id": user.User.ID, "quota": needCoins}).Errorf("freeze user quota failed: %s", err)
+		if err := ctl.userSrv.FreezeUserQuota(ctx, user.ID, needCoins); err != nil {
+			log.F(log.M{"user_id": user.ID, "quota": needCoins}).Errorf("freeze user quota failed: %s", err)
 		} else {
 			defer func(ctx context.Context) {
 				// 解冻智慧果
-				if err := ctl.userSrv.UnfreezeUserQuota(ctx, user.User.ID, needCoins); err != nil {
-					log.F(log.M{"user_id": user.User.ID, "quota": needCoins}).Errorf("unfreeze user quota failed: %s", err)
+				if err := ctl.userSrv.UnfreezeUserQuota(ctx, user.ID, needCoins); err != nil {
+					log.F(log.M{"user_id": user.ID, "quota": needCoins}).Errorf("unfreeze user quota failed: %s", err)
 				}
 			}(ctx)
 		}
 	}
 
 	// 内容安全检测
-	if err := ctl.contentSafety(req, user.User, sw); err != nil {
+	if err := ctl.contentSafety(req, user, sw); err != nil {
 		return
 	}
 
+	// 长输入压缩，只对开启了压缩的模型生效
+	compression := ctl.compressRequest(subCtx, req, mod)
+
+	// 图片生成工具，生成的图片按照图片生成模型的价格单独计费
+	req.ImageTool = ctl.imageToolOptions(subCtx, user, req.RoomID, historyImages)
+	// 网页搜索工具，搜索结果作为引用来源返回
+	req.WebSearch = ctl.webSearchOptions(user, req.Model)
+	// 工具调用循环的耗时预算，避免多次调用工具时客户端等待超时
+	req.ToolLoopBudget = time.Duration(ctl.toolLoopBudgets.Limit(user.UserType)) * time.Second
+	subCtx, imageUsage := chat.WithImageToolUsage(subCtx)
+
 	var quotaConsume QuotaConsume
 
+	// 请求 ID，用于关联请求日志和用量通知
+	requestID := misc.UUID()
 	startTime := time.Now()
 	defer func() {
 		log.F(log.M{
-			"user_id": user.User.ID,
-			"client":  client,
-			"room_id": req.RoomID,
-			"elapse":  time.Since(startTime).Seconds(),
+			"user_id":    user.ID,
+			"client":     client,
+			"room_id":    req.RoomID,
+			"elapse":     time.Since(startTime).Seconds(),
+			"request_id": requestID,
 		}).
 			Infof(
 				"接收到聊天请求，模型 %s, 上下文消息数量 %d, 输入 token 数量 %d，输出 token 数量 %d，消耗智慧果 %d",
@@ -393,10 +532,13 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 	}()
 
 	// 写入用户消息
-	questionID := ctl.saveChatQuestion(subCtx, user.User, req)
+	questionID := ctl.saveChatQuestion(subCtx, user, req)
+
+	// 记录实际生效的系统提示语、模型和渠道，随回答一起保存
+	subCtx, effective := chat.WithEffectiveRequest(subCtx)
 
 	// 发起聊天请求并返回 SSE/WS 流
-	replyText, err := ctl.handleChat(subCtx, req, user.User, sw, webCtx, questionID, 0)
+	replyText, replyParts, err := ctl.handleChat(subCtx, req, user, sw, webCtx, questionID, 0)
 	if errors.Is(err, ErrChatResponseHasSent) {
 		return
 	}
@@ -407,9 +549,9 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 	if errors.Is(err, ErrChatResponseEmpty) || (errors.Is(err, ErrChatResponseGapTimeout) && replyText == "") {
 		// 如果用户等待时间超过 60s，则不再重试，避免用户等待时间过长
 		if startTime.Add(60 * time.Second).After(time.Now()) {
-			log.F(log.M{"req": req, "user_id": user.User.ID}).Warningf("聊天响应为空，尝试再次请求，模型：%s", req.Model)
+			log.F(log.M{"req": req, "user_id": user.ID}).Warningf("聊天响应为空，尝试再次请求，模型：%s", req.Model)
 
-			replyText, err = ctl.handleChat(subCtx, req, user.User, sw, webCtx, questionID, 1)
+			replyText, replyParts, err = ctl.handleChat(subCtx, req, user, sw, webCtx, questionID, 1)
 			if errors.Is(err, ErrChatResponseHasSent) {
 				return
 			}
@@ -418,26 +560,33 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 
 	chatErrorMessage := ternary.IfLazy(err == nil, func() string { return "" }, func() string { return err.Error() })
 	if chatErrorMessage != "" {
-		log.F(log.M{"req": req, "user_id": user.User.ID, "reply": replyText, "elapse": time.Since(startTime).Seconds()}).
+		log.F(log.M{"req": req, "user_id": user.ID, "reply": replyText, "elapse": time.Since(startTime).Seconds()}).
 			Errorf("聊天失败，模型：%s，错误：%s", req.Model, chatErrorMessage)
 	}
 
 	// 返回自定义控制信息，告诉客户端当前消耗情况
 	quotaConsume = ctl.resolveConsumeQuota(req, replyText, leftCount > 0, mod)
+	if compression != nil && leftCount <= 0 {
+		// 压缩使用的辅助模型的消耗同样计入本次请求
+		quotaConsume = quotaConsume.Add(ctl.resolveCompressionQuota(subCtx, compression))
+	}
+
+	// 生成图片的消耗不受免费聊天次数的影响
+	imageCoins := coins.ComputeFee(imageUsage.Usage())
 
 	func() {
 		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
 		// 写入用户消息
-		answerID := ctl.saveChatAnswer(ctx, user.User, replyText, quotaConsume.TotalPrice, quotaConsume.TotalTokens(), req, questionID, chatErrorMessage)
+		answerID := ctl.saveChatAnswer(ctx, user, replyText, replyParts, quotaConsume.TotalPrice+imageCoins, quotaConsume.TotalTokens(), req, questionID, chatErrorMessage, effective)
 
 		if errors.Is(ErrChatResponseEmpty, err) {
 			misc.NoError(sw.WriteErrorStream(err, http.StatusInternalServerError))
 		} else {
 			if !ctl.apiMode {
 				// final 消息为定制消息，用于告诉 AIdea 客户端当前的资源消耗情况以及服务端信息
-				finalWord := ctl.buildFinalSystemMessage(questionID, answerID, user.User, quotaConsume.TotalPrice, quotaConsume.TotalTokens(), req, maxContextLen, chatErrorMessage)
+				finalWord := ctl.buildFinalSystemMessage(questionID, answerID, user, quotaConsume.TotalPrice+imageCoins, quotaConsume.TotalTokens(), req, maxContextLen, chatErrorMessage)
 				misc.NoError(sw.WriteStream(finalWord))
 			}
 		}
@@ -449,9 +598,9 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 			defer cancel()
 
-			if err := ctl.chatSrv.UpdateFreeChatCount(ctx, user.User.ID, req.Model); err != nil {
+			if err := ctl.chatSrv.UpdateFreeChatCount(ctx, user.ID, req.Model); err != nil {
 				log.WithFields(log.Fields{
-					"user_id": user.User.ID,
+					"user_id": user.ID,
 					"model":   req.Model,
 				}).Errorf("update free chat count failed: %s", err)
 			}
@@ -469,12 +618,159 @@ func (ctl *OpenAIController) Chat(ctx context.Context, webCtx web.Context, user
 			meta.OutputToken = quotaConsume.OutputTokens
 			meta.InputPrice = quotaConsume.InputPrice
 			meta.OutputPrice = quotaConsume.OutputPrice
+			meta.APIKeyID = user.APIKeyID()
 
-			if err := quotaRepo.QuotaConsume(ctx, user.User.ID, quotaConsume.TotalPrice, meta); err != nil {
+			if err := quotaRepo.QuotaConsume(ctx, user.ID, quotaConsume.TotalPrice, meta); err != nil {
 				log.Errorf("used quota add failed: %s", err)
 			}
 		}()
 	}
+
+	// 扣除生成图片消耗的智慧果，计入图片生成模型
+	if imageCoins > 0 {
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			if err := quotaRepo.QuotaConsume(ctx, user.ID, imageCoins, repo.NewQuotaUsedMeta("chat-image", imageUsage.Model())); err != nil {
+				log.Errorf("used quota add failed: %s", err)
+			}
+		}()
+	}
+
+	ctl.publishUsage(requestID, user, req.Model, quotaConsume, quotaConsume.TotalPrice+imageCoins, effective, chatErrorMessage != "", startTime)
+}
+
+// serveSandboxChat 处理演示用户的聊天请求：返回预置的回答，不查询模型和房间配置，不请求服务提供商，
+// 不消耗智慧果和免费次数，也不保存聊天记录（与 Anthropic 兼容接口一致），只发布费用为 0 的用量事件。演示用户通过流控限制访问
+func (ctl *OpenAIController) serveSandboxChat(ctx context.Context, webCtx web.Context, user *auth.User, sw *streamwriter.StreamWriter, req *chat.Request) {
+	if len(req.Messages) == 0 {
+		misc.NoError(sw.WriteErrorStream(errors.New(common.Text(webCtx, ctl.translater, common.ErrInvalidRequest)), http.StatusBadRequest))
+		return
+	}
+
+	req.UserID = user.ID
+	requestID := misc.UUID()
+	startTime := time.Now()
+
+	ctx, effective := chat.WithEffectiveRequest(ctx)
+	effective.Model, effective.Provider = req.Model, "sandbox"
+
+	_, _, err := ctl.handleChat(ctx, req, user, sw, webCtx, 0, 0)
+	if errors.Is(err, ErrChatResponseHasSent) {
+		return
+	}
+
+	chatErrorMessage := ternary.IfLazy(err == nil, func() string { return "" }, func() string { return err.Error() })
+	if chatErrorMessage != "" {
+		log.F(log.M{"user_id": user.ID, "model": req.Model}).Errorf("演示用户聊天失败：%s", chatErrorMessage)
+	}
+
+	if !ctl.apiMode {
+		// 预置的回答不受上下文长度影响，不提示用户重置上下文
+		misc.NoError(sw.WriteStream(ctl.buildFinalSystemMessage(0, 0, user, 0, 0, req, int64(len(req.Messages)+1), chatErrorMessage)))
+	}
+
+	ctl.publishUsage(requestID, user, req.Model, QuotaConsume{}, 0, effective, chatErrorMessage != "", startTime)
+}
+
+// publishUsage 发布本次请求的用量事件，只放入发送队列，不会阻塞请求
+func (ctl *OpenAIController) publishUsage(requestID string, user *auth.User, model string, quotaConsume QuotaConsume, cost int64, effective *chat.EffectiveRequest, failed bool, startTime time.Time) {
+	ctl.usageHook.Publish(usagehook.Event{
+		RequestID:     requestID,
+		UserID:        user.ID,
+		APIKeyID:      user.APIKeyID(),
+		Model:         model,
+		UpstreamModel: effective.Model,
+		Provider:      effective.Provider,
+		ChannelID:     effective.ChannelID,
+		InputTokens:   quotaConsume.InputTokens,
+		OutputTokens:  quotaConsume.OutputTokens,
+		Cost:          cost,
+		FinishReason:  effective.FinishReason,
+		Sandbox:       user.Sandbox(),
+		Failed:        failed,
+		StartedAt:     startTime,
+		CompletedAt:   time.Now(),
+	})
+}
+
+// imageToolOptions 返回图片生成工具的配置，未启用、API 模式、匿名用户、演示用户以及用户类型不允许生成图片时返回 nil
+//
+// 对话中的额度已经用完时仍然提供该工具，模型可以告知用户原因；智慧果不足时减少本次请求可以生成的图片数量
+func (ctl *OpenAIController) imageToolOptions(ctx context.Context, user *auth.User, roomID int64, historyImages int) *chat.ImageToolOptions {
+	if ctl.conf.ChatImageToolModel == "" || ctl.apiMode || user.ID == 0 || user.Sandbox() {
+		return nil
+	}
+
+	limit := ctl.imageToolLimits.Limit(user.UserType)
+	if limit <= 0 {
+		return nil
+	}
+
+	remaining := max(limit-ctl.generatedImages(ctx, user, roomID, historyImages), 0)
+	if price := int64(coins.GetUnifiedImageGenCoins(ctl.conf.ChatImageToolModel)); price > 0 && remaining > 0 {
+		quota, err := ctl.userSrv.UserQuota(ctx, user.ID)
+		if err != nil {
+			log.F(log.M{"user_id": user.ID}).Errorf("查询用户智慧果余量失败: %s", err)
+			return nil
+		}
+
+		remaining = min(remaining, int(max(quota.Rest-quota.Freezed, 0)/price))
+	}
+
+	return &chat.ImageToolOptions{Remaining: remaining}
+}
+
+// generatedImagesWindow 没有独立房间的对话（RoomID <= 1）统计已经生成的图片数量的时间范围
+const generatedImagesWindow = 24 * time.Hour
+
+// generatedImages 对话中已经生成的图片数量，根据聊天记录中保存的回答（chat_messages.parts）统计，客户端发送的历史消息可以被篡改，不作为依据。
+// 没有独立房间的对话只统计最近 generatedImagesWindow 之内的回答；未开启聊天记录或者查询失败时，使用客户端发送的历史消息中的图片数量 historyImages
+func (ctl *OpenAIController) generatedImages(ctx context.Context, user *auth.User, roomID int64, historyImages int) int {
+	if !ctl.conf.EnableRecordChat {
+		return historyImages
+	}
+
+	var since time.Time
+	if roomID <= 1 {
+		since = time.Now().Add(-generatedImagesWindow)
+	}
+
+	parts, err := ctl.messageRepo.AnswerParts(ctx, user.ID, roomID, since)
+	if err != nil {
+		log.F(log.M{"user_id": user.ID, "room_id": roomID}).Errorf("query generated images failed: %s", err)
+		return historyImages
+	}
+
+	return chat.CountStoredImages(parts)
+}
+
+// webSearchOptions 返回网页搜索工具的配置，未启用、API 模式、匿名用户、演示用户、模型不在允许列表中以及用户类型不允许使用时返回 nil
+func (ctl *OpenAIController) webSearchOptions(user *auth.User, model string) *chat.WebSearchOptions {
+	if ctl.conf.ChatWebSearchBackend == "" || ctl.apiMode || user.ID == 0 || user.Sandbox() {
+		return nil
+	}
+
+	if !repo.ModelAllowed(ctl.conf.ChatWebSearchModels, model) {
+		return nil
+	}
+
+	limit := ctl.webSearchLimits.Limit(user.UserType)
+	if limit <= 0 {
+		return nil
+	}
+
+	return &chat.WebSearchOptions{Remaining: limit}
+}
+
+// chatFor 返回处理用户聊天请求的 Chat 实现，演示用户使用预置的回答，不请求服务提供商
+func (ctl *OpenAIController) chatFor(user *auth.User) chat.Chat {
+	if user.Sandbox() {
+		return ctl.sandbox
+	}
+
+	return ctl.chat
 }
 
 func (ctl *OpenAIController) handleChat(
@@ -485,44 +781,86 @@ func (ctl *OpenAIController) handleChat(
 	webCtx web.Context,
 	questionID int64,
 	retryTimes int,
-) (string, error) {
+) (string, []*chat.MultipartContent, error) {
 	chatCtx, cancel := context.WithTimeout(ctx, 180*time.Second)
 	defer cancel()
 
-	// 如果是重试请求，则优先使用备用模型
-	if retryTimes > 0 {
-		chatCtx = control.NewContext(chatCtx, &control.Control{PreferBackup: true})
+	chatCtl := control.Control{
+		// 如果是重试请求，则优先使用备用模型
+		PreferBackup: retryTimes > 0,
+		// 内部用户可以查看实际发送给上游的请求内容
+		Debug: ctl.conf.ChatDebugRequest && user.InternalUser(),
+	}
+	if chatCtl.PreferBackup || chatCtl.Debug {
+		chatCtx = control.NewContext(chatCtx, &chatCtl)
 	}
 
-	stream, err := ctl.chat.ChatStream(chatCtx, *req)
+	stream, err := ctl.chatFor(user).ChatStream(chatCtx, *req)
 	if err != nil {
 		// 更新问题为失败状态
 		ctl.makeChatQuestionFailed(ctx, questionID, err)
 
-		// 内容违反内容安全策略
+		// 内容违反内容安全策略，只返回拦截的方向和分类，详细原因（命中的内容）只记录在日志中
 		if errors.Is(err, chat.ErrContentFilter) {
-			ctl.sendViolateContentPolicyResp(sw, "")
-			return "", ErrChatResponseHasSent
+			log.WithFields(log.Fields{"user_id": user.ID, "detail": chat.ErrorDetail(err)}).Warningf("聊天请求触发内容安全策略，模型 %s", req.Model)
+			ctl.sendViolateContentPolicyResp(sw, common.Text(webCtx, ctl.translater, chat.ContentFilterMessage(err)))
+			return "", nil, ErrChatResponseHasSent
+		}
+
+		// 请求内容超过服务提供商的限制，提示用户减少图片数量
+		if errors.Is(err, chat.ErrPayloadTooLarge) || errors.Is(err, chat.ErrFileTooLarge) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusRequestEntityTooLarge))
+			return "", nil, ErrChatResponseHasSent
+		}
+
+		// 请求中引用的文件类型不支持
+		if errors.Is(err, chat.ErrFileTypeNotAllowed) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusBadRequest))
+			return "", nil, ErrChatResponseHasSent
+		}
+
+		// 模型的所有服务提供商都请求失败，通常是上游故障，提示用户稍后再试或切换到推荐的模型
+		if errors.Is(err, chat.ErrAllChannelsFailed) {
+			log.WithFields(log.Fields{"user_id": user.ID, "retry_times": retryTimes, "detail": chat.ErrorDetail(err)}).Errorf("模型 %s 的所有服务提供商都请求失败", req.Model)
+			misc.NoError(sw.WriteErrorStream(errors.New(common.Text(webCtx, ctl.translater, chat.AllChannelsFailedMessage(err))), http.StatusServiceUnavailable))
+			return "", nil, ErrChatResponseHasSent
+		}
+
+		// 支持图片的服务提供商都不可用，模型信息暂时无法查询（数据库故障），或者没有允许使用该模型的渠道
+		if errors.Is(err, chat.ErrVisionUnavailable) || errors.Is(err, chat.ErrTemporarilyUnavailable) || errors.Is(err, chat.ErrNoAllowedProvider) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusServiceUnavailable))
+			return "", nil, ErrChatResponseHasSent
 		}
 
-		log.WithFields(log.Fields{"user_id": user.ID, "retry_times": retryTimes}).Errorf("聊天请求失败，模型 %s: %v", req.Model, err)
+		log.WithFields(log.Fields{"user_id": user.ID, "retry_times": retryTimes, "detail": chat.ErrorDetail(err)}).Errorf("聊天请求失败，模型 %s: %v", req.Model, err)
 
 		misc.NoError(sw.WriteErrorStream(errors.New(common.Text(webCtx, ctl.translater, common.ErrInternalError)), http.StatusInternalServerError))
-		return "", ErrChatResponseHasSent
+		return "", nil, ErrChatResponseHasSent
 	}
 
-	replyText, err := ctl.writeChatResponse(chatCtx, req, stream, user, sw)
+	// 平滑输出，按照固定的速率向客户端输出内容
+	stream = chat.PaceStream(chatCtx, stream, ctl.conf.ChatOutputPacingRate)
+
+	// 合并较小的输出片段，减少 SSE 事件或者 WebSocket 帧的数量
+	if !sw.IsWebSocket() {
+		stream = chat.CoalesceStream(chatCtx, stream, time.Duration(ctl.conf.ChatOutputCoalesceInterval)*time.Millisecond, ctl.conf.ChatOutputCoalesceBytes)
+	} else {
+		stream = chat.AggregateStream(chatCtx, stream, time.Duration(ctl.conf.ChatWebSocketAggregateInterval)*time.Millisecond, ctl.conf.ChatWebSocketAggregateTokens)
+	}
+
+	replyText, replyParts, err := ctl.writeChatResponse(chatCtx, req, stream, user, sw)
 	if err != nil {
-		return replyText, err
+		return replyText, replyParts, err
 	}
 
 	replyText = strings.TrimSpace(replyText)
 
-	if replyText == "" {
-		return replyText, ErrChatResponseEmpty
+	// 只包含图片等多模态内容的回复不是空回复
+	if replyText == "" && len(replyParts) == 0 {
+		return replyText, replyParts, ErrChatResponseEmpty
 	}
 
-	return replyText, nil
+	return replyText, replyParts, nil
 }
 
 var (
@@ -531,8 +869,12 @@ var (
 	ErrChatResponseGapTimeout = errors.New("两次响应之间等待时间过长，强制中断")
 )
 
-func (ctl *OpenAIController) writeChatResponse(ctx context.Context, req *chat.Request, stream <-chan chat.Response, user *auth.User, sw *streamwriter.StreamWriter) (string, error) {
+func (ctl *OpenAIController) writeChatResponse(ctx context.Context, req *chat.Request, stream <-chan chat.Response, user *auth.User, sw *streamwriter.StreamWriter) (string, []*chat.MultipartContent, error) {
 	var replyText string
+	var replyParts []*chat.MultipartContent
+
+	// 开启 output_checksum 时，按照实际发送给客户端的文本（包括追加的错误提示）重新计算摘要，每个候选回复分别计算
+	checksums := make(map[int]hash.Hash)
 
 	// 生成 SSE 流
 	timer := time.NewTimer(60 * time.Second)
@@ -546,26 +888,43 @@ func (ctl *OpenAIController) writeChatResponse(ctx context.Context, req *chat.Re
 
 		select {
 		case <-timer.C:
-			return replyText, ErrChatResponseGapTimeout
+			return replyText, replyParts, ErrChatResponseGapTimeout
 		case <-ctx.Done():
-			return replyText, nil
+			return replyText, replyParts, nil
 		case res, ok := <-stream:
 			if !ok {
-				return replyText, nil
+				return replyText, replyParts, nil
 			}
 
 			id++
 
-			if res.ErrorCode != "" {
-				log.WithFields(log.Fields{"req": req, "user_id": user.ID}).Errorf("聊天响应失败: %v", res)
+			if res.ContentFilter != nil {
+				// 生成的内容触发内容安全策略，保留已经生成的内容，并提示拦截原因
+				log.WithFields(log.Fields{"user_id": user.ID, "detail": res.ContentFilter.Detail()}).Warningf("聊天响应触发内容安全策略")
+
+				replyText += res.Text
+				res.Text += fmt.Sprintf("\n\n---\n%s\n", res.Error)
+			} else if res.ErrorCode != "" {
+				log.WithFields(log.Fields{"req": req, "user_id": user.ID, "upstream": res.Upstream}).Errorf("聊天响应失败: %v", res)
 
 				if res.Error != "" {
 					res.Text = fmt.Sprintf("\n\n---\n抱歉，我们遇到了一些错误，以下是错误详情：\n%s\n", res.Error)
 				} else {
-					return replyText, nil
+					return replyText, replyParts, nil
 				}
 			} else {
-				replyText += res.Text
+				// 模型拒绝回答时，拒绝的说明作为回复内容保存，客户端根据 refusal 单独展示
+				replyText += res.Text + res.Refusal
+				replyParts = append(replyParts, res.Parts...)
+			}
+
+			if req.OutputChecksum {
+				h, ok := checksums[res.ChoiceIndex]
+				if !ok {
+					h = sha256.New()
+					checksums[res.ChoiceIndex] = h
+				}
+				h.Write([]byte(res.Text))
 			}
 
 			resp := ChatCompletionStreamResponse{
@@ -578,14 +937,62 @@ func (ctl *OpenAIController) writeChatResponse(ctx context.Context, req *chat.Re
 						Delta: ChatCompletionStreamChoiceDelta{
 							Role:    "assistant",
 							Content: res.Text,
+							Parts:   res.Parts,
+							Refusal: res.Refusal,
 						},
 					},
 				},
 			}
 
-			if err := sw.WriteStream(resp); err != nil {
+			// 调试模式下，返回实际发送给上游的请求内容
+			resp.DebugRequest = res.DebugRequest
+			// 调试模式下，返回请求上游的记录（包括重试）
+			resp.Attempts = res.Attempts
+			// 输出内容引用的参考资料段落
+			resp.UsedSources = res.UsedSources
+			// 回复引用的外部来源（网页搜索结果）
+			resp.Citations = res.Citations
+			// 服务端工具调用循环的次数，以及是否因为耗时预算提前结束
+			resp.ToolIterations = res.ToolIterations
+			resp.BudgetLimited = res.BudgetLimited
+			// 请求要求可复现的输出时，模型是否支持
+			resp.Reproducible = res.Reproducible
+			// 触发结束的停止序列，与结束原因一起返回
+			resp.StoppedBy = res.StoppedBy
+			// 请求被降级处理时的警告信息
+			resp.Warning = res.Warning
+			resp.Warnings = res.Warnings
+			// 根据任务类型调整采样参数时使用的配置
+			resp.TaskProfile = res.TaskProfile
+			// 演示用户的预置回答，客户端据此为回答添加水印
+			resp.Sandbox = res.Sandbox
+			// 错误响应（包括触发内容安全策略）的错误码，错误响应始终以 JSON 帧发送
+			resp.ErrorCode = res.ErrorCode
+
+			// 输出内容的摘要，同时记录到日志中，用于校验输出内容的完整性
+			if res.OutputSHA256 != "" && checksums[res.ChoiceIndex] != nil {
+				resp.OutputSHA256 = hex.EncodeToString(checksums[res.ChoiceIndex].Sum(nil))
+				log.F(log.M{"user_id": user.ID, "model": req.Model, "output_sha256": resp.OutputSHA256}).Infof("聊天响应输出内容摘要，长度 %d", len(replyText))
+			}
+
+			// 结束原因（stop/length/content_filter/tool_calls/refusal），客户端据此判断回答是否完整
+			if res.FinishReason != "" {
+				finishReason := res.FinishReason
+				resp.Choices[0].FinishReason = &finishReason
+				chat.RecordFinishReason(ctx, finishReason)
+			}
+
+			// 只包含文本增量的响应，客户端协商使用紧凑帧格式时以二进制帧发送
+			var err error
+			if resp.IsTextDelta() {
+				err = sw.WriteDelta(id, res.Text, resp)
+			} else {
+				err = sw.WriteStream(resp)
+			}
+
+			if err != nil {
 				log.F(log.M{"req": req, "user_id": user.ID}).Warningf("write response failed: %v", err)
-				return replyText, nil
+				return replyText, replyParts, nil
 			}
 		}
 	}
@@ -597,6 +1004,58 @@ type ChatCompletionStreamResponse struct {
 	Created int64                        `json:"created"`
 	Model   string                       `json:"model"`
 	Choices []ChatCompletionStreamChoice `json:"choices"`
+	// DebugRequest 实际发送给上游的请求内容，只在调试模式下返回
+	DebugRequest *chat.DebugRequest `json:"debug_request,omitempty"`
+	// Attempts 请求上游的记录（渠道、错误分类和耗时），只在调试模式下返回
+	Attempts []chat.AttemptInfo `json:"attempts,omitempty"`
+	// UsedSources 输出内容引用的参考资料段落，只在请求中开启 return_used_sources 时返回
+	UsedSources []chat.UsedSource `json:"used_sources,omitempty"`
+	// Citations 回复引用的外部来源（如网页搜索结果），在包含结束原因的响应中返回，回复中使用 [编号] 引用
+	Citations []chat.Citation `json:"citations,omitempty"`
+	// ToolIterations 服务端工具调用循环完成的次数，在包含结束原因的响应中返回
+	ToolIterations int `json:"tool_iterations,omitempty"`
+	// BudgetLimited 服务端工具调用循环是否因为耗时预算提前结束，此时回答只基于已有的工具调用结果
+	BudgetLimited bool `json:"budget_limited,omitempty"`
+	// Reproducible 请求要求可复现的输出（temperature 为 0 且指定了 seed）时，模型是否支持
+	Reproducible *bool `json:"reproducible,omitempty"`
+	// StoppedBy 触发结束的停止序列，只在请求中指定了 stop 且能够确定时返回
+	StoppedBy string `json:"stopped_by,omitempty"`
+	// Warning 请求被降级处理时（如图片理解不可用时去掉了图片）的警告信息
+	Warning string `json:"warning,omitempty"`
+	// Warnings 全部的警告信息，warning 为其中的第一条
+	Warnings []string `json:"warnings,omitempty"`
+	// TaskProfile 根据任务类型调整了采样参数时使用的配置名称，如 code、creative
+	TaskProfile string `json:"task_profile,omitempty"`
+	// Sandbox 是否为演示用户的预置回答，客户端可以据此为回答添加水印
+	Sandbox bool `json:"sandbox,omitempty"`
+	// ErrorCode 触发内容安全策略时的错误码，如 CONTENT_FILTER:output:hate:azure
+	ErrorCode string `json:"error_code,omitempty"`
+	// OutputSHA256 输出内容的 SHA-256 摘要，只在请求中开启 output_checksum 时，与结束原因一起返回，
+	// 摘要的内容为目前为止所有响应中 choices[0].delta.content 拼接之后的文本（包括服务端追加的错误提示）
+	OutputSHA256 string `json:"output_sha256,omitempty"`
+}
+
+// IsTextDelta 响应是否只包含助手回复的文本增量（choices[0].delta.content），除了 id、object、created、model 之外没有其它字段，
+// 结束原因、错误码等控制信息以及之后新增的字段都会使响应以 JSON 帧发送
+func (resp ChatCompletionStreamResponse) IsTextDelta() bool {
+	if len(resp.Choices) != 1 || resp.Choices[0].Delta.Content == "" {
+		return false
+	}
+
+	// 去掉帧头之后，按照发送的内容与只包含文本增量的响应比较，空的列表字段与未设置相同
+	rest := resp
+	rest.ID, rest.Object, rest.Created, rest.Model = "", "", 0, ""
+	expect := ChatCompletionStreamResponse{
+		Choices: []ChatCompletionStreamChoice{{Delta: ChatCompletionStreamChoiceDelta{Role: "assistant", Content: resp.Choices[0].Delta.Content}}},
+	}
+
+	restData, err := json.Marshal(rest)
+	if err != nil {
+		return false
+	}
+
+	expectData, err := json.Marshal(expect)
+	return err == nil && bytes.Equal(restData, expectData)
 }
 
 type ChatCompletionStreamChoice struct {
@@ -609,6 +1068,10 @@ type ChatCompletionStreamChoiceDelta struct {
 	Content      string               `json:"content"`
 	Role         string               `json:"role,omitempty"`
 	FunctionCall *openai.FunctionCall `json:"function_call,omitempty"`
+	// Parts 回复中文本之外的多模态内容（如生成的图片地址及说明），客户端在后续的请求中作为助手消息的 multipart_content 发送
+	Parts []*chat.MultipartContent `json:"parts,omitempty"`
+	// Refusal 模型拒绝回答时的说明，与结束原因 refusal 一起返回，客户端展示为“助手拒绝了该请求”，与内容安全策略拦截区分
+	Refusal string `json:"refusal,omitempty"`
 }
 
 // buildFinalSystemMessage 构建最后一条消息，该消息为系统消息，用于告诉 AIdea 客户端当前的资源消耗情况以及服务端信息
@@ -628,6 +1091,8 @@ func (*OpenAIController) buildFinalSystemMessage(
 		AnswerID:   answerID,
 		Token:      int64(realTokenConsumed),
 		Error:      chatErrorMessage,
+		// 回复可能因为剩余的上下文长度不足而较短
+		MaxTokensAdjustment: req.MaxTokensAdjustment,
 	}
 
 	if len(req.Messages) >= int(maxContextLen*2)-1 {
@@ -681,8 +1146,19 @@ func (ctl *OpenAIController) queryChatQuota(
 }
 
 func (ctl *OpenAIController) rateLimitPass(ctx context.Context, client *auth.ClientInfo, user *auth.User) error {
+	// API Key 单独配置的每分钟请求数，不受 EnableModelRateLimit 影响
+	if user.APIKey != nil && user.APIKey.RPM > 0 {
+		if err := ctl.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterAPIKey}, fmt.Sprintf("chat-limit:k:%d:minute", user.APIKey.ID), redis_rate.PerMinute(int(user.APIKey.RPM))); err != nil {
+			if errors.Is(err, rate.ErrRateLimitExceeded) {
+				return rate.ErrRateLimitExceeded
+			}
+
+			log.F(log.M{"user_id": user.ID, "key_id": user.APIKey.ID}).Errorf("check api key rate limit failed: %s", err)
+		}
+	}
+
 	if ctl.conf.EnableModelRateLimit {
-		if err := ctl.limiter.Allow(ctx, fmt.Sprintf("chat-limit:u:%d:minute", user.ID), redis_rate.PerMinute(10)); err != nil {
+		if err := ctl.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterUser}, fmt.Sprintf("chat-limit:u:%d:minute", user.ID), redis_rate.PerMinute(10)); err != nil {
 			if errors.Is(err, rate.ErrRateLimitExceeded) {
 				return rate.ErrRateLimitExceeded
 			}
@@ -694,7 +1170,7 @@ func (ctl *OpenAIController) rateLimitPass(ctx context.Context, client *auth.Cli
 	// 匿名用户每日免费次数限制
 	if ctl.conf.FreeChatEnabled && user.ID == 0 {
 		lim := redis_rate.Limit{Rate: ctl.conf.FreeChatDailyLimit, Burst: ctl.conf.FreeChatDailyLimit, Period: time.Hour * 24}
-		if err := ctl.limiter.Allow(ctx, fmt.Sprintf("chat-limit:anonymous:%s:daily", client.IP), lim); err != nil {
+		if err := ctl.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterUser, Channel: "anonymous"}, fmt.Sprintf("chat-limit:anonymous:%s:daily", client.IP), lim); err != nil {
 			log.F(log.M{"ip": client.IP}).Errorf("今日免费次数已用完（IP）: %s", err)
 			return rate.ErrDailyFreeLimitExceeded
 		}
@@ -717,22 +1193,31 @@ func (ctl *OpenAIController) rateLimitPass(ctx context.Context, client *auth.Cli
 	return nil
 }
 
-func (ctl *OpenAIController) saveChatAnswer(ctx context.Context, user *auth.User, replyText string, quotaConsumed int64, realWordCount int, req *chat.Request, questionID int64, chatErrorMessage string) int64 {
+func (ctl *OpenAIController) saveChatAnswer(ctx context.Context, user *auth.User, replyText string, replyParts []*chat.MultipartContent, quotaConsumed int64, realWordCount int, req *chat.Request, questionID int64, chatErrorMessage string, effective *chat.EffectiveRequest) int64 {
 	if ctl.conf.EnableRecordChat && !ctl.apiMode {
 		answerID, err := ctl.messageRepo.Add(ctx, repo.MessageAddReq{
-			UserID:        user.ID,
-			Message:       replyText,
-			Role:          repo.MessageRoleAssistant,
-			QuotaConsumed: quotaConsumed,
-			TokenConsumed: int64(realWordCount),
-			RoomID:        req.RoomID,
-			Model:         req.Model,
-			PID:           questionID,
-			Status:        int64(ternary.If(chatErrorMessage != "", repo.MessageStatusFailed, repo.MessageStatusSucceed)),
-			Error:         chatErrorMessage,
+			UserID:           user.ID,
+			Message:          replyText,
+			Role:             repo.MessageRoleAssistant,
+			QuotaConsumed:    quotaConsumed,
+			TokenConsumed:    int64(realWordCount),
+			RoomID:           req.RoomID,
+			Model:            req.Model,
+			PID:              questionID,
+			Status:           int64(ternary.If(chatErrorMessage != "", repo.MessageStatusFailed, repo.MessageStatusSucceed)),
+			Error:            chatErrorMessage,
+			SystemPromptHash: effective.SystemPromptHash(),
+			// 系统提示语全文可能包含隐私信息，只在开启配置时保存
+			SystemPrompt:  ternary.If(ctl.conf.ChatRecordSystemPrompt, effective.SystemPrompt, ""),
+			UpstreamModel: effective.Model,
+			ChannelID:     effective.ChannelID,
+			Provider:      effective.Provider,
+			Parts:         encodeReplyParts(replyParts),
 		})
 		if err != nil {
 			log.With(req).Errorf("add message failed: %s", err)
+		} else {
+			ctl.historySearch.Index(repo.MessageSearchDoc{MessageID: answerID, UserID: user.ID, RoomID: req.RoomID, Role: repo.MessageRoleAssistant, Model: req.Model, Content: replyText})
 		}
 
 		return answerID
@@ -740,6 +1225,21 @@ func (ctl *OpenAIController) saveChatAnswer(ctx context.Context, user *auth.User
 	return 0
 }
 
+// encodeReplyParts 将回复中的多模态内容编码为 JSON 保存到聊天记录中，没有多模态内容时返回空字符串
+func encodeReplyParts(parts []*chat.MultipartContent) string {
+	if len(parts) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(parts)
+	if err != nil {
+		log.Errorf("encode reply parts failed: %s", err)
+		return ""
+	}
+
+	return string(data)
+}
+
 type QuotaConsume struct {
 	InputTokens  int
 	OutputTokens int
@@ -752,6 +1252,17 @@ func (qc QuotaConsume) TotalTokens() int {
 	return qc.InputTokens + qc.OutputTokens
 }
 
+// Add 合并两次消耗
+func (qc QuotaConsume) Add(other QuotaConsume) QuotaConsume {
+	return QuotaConsume{
+		InputTokens:  qc.InputTokens + other.InputTokens,
+		OutputTokens: qc.OutputTokens + other.OutputTokens,
+		InputPrice:   qc.InputPrice + other.InputPrice,
+		OutputPrice:  qc.OutputPrice + other.OutputPrice,
+		TotalPrice:   qc.TotalPrice + other.TotalPrice,
+	}
+}
+
 func (ctl *OpenAIController) resolveConsumeQuota(req *chat.Request, replyText string, isFreeRequest bool, mod *repo.Model) QuotaConsume {
 	inputTokens, _ := chat.MessageTokenCount(req.Messages, req.Model)
 	outputTokens, _ := chat.MessageTokenCount(
@@ -765,7 +1276,7 @@ func (ctl *OpenAIController) resolveConsumeQuota(req *chat.Request, replyText st
 		InputTokens:  inputTokens,
 		OutputTokens: outputTokens,
 	}
-	ret.InputPrice, ret.OutputPrice, ret.TotalPrice = coins.GetTextModelCoinsDetail(mod.ToCoinModel(), int64(inputTokens), int64(outputTokens))
+	ret.InputPrice, ret.OutputPrice, ret.TotalPrice = coins.ChatUsage{Model: mod.ToCoinModel(), InputTokens: int64(inputTokens), OutputTokens: int64(outputTokens)}.Detail()
 
 	// 免费请求，不扣除智慧果
 	if isFreeRequest || replyText == "" {
@@ -780,7 +1291,8 @@ func (ctl *OpenAIController) makeChatQuestionFailed(ctx context.Context, questio
 	if questionID > 0 {
 		if err := ctl.messageRepo.UpdateMessageStatus(ctx, questionID, repo.MessageUpdateReq{
 			Status: repo.MessageStatusFailed,
-			Error:  err.Error(),
+			// 失败记录只在后台管理中查看，这里保存包含上游原始错误响应的详情
+			Error: chat.ErrorDetail(err),
 		}); err != nil {
 			log.WithFields(log.Fields{
 				"question_id": questionID,
@@ -803,6 +1315,8 @@ func (ctl *OpenAIController) saveChatQuestion(ctx context.Context, user *auth.Us
 		})
 		if err != nil {
 			log.F(log.M{"req": req, "user_id": user.ID}).Errorf("保存用户聊天请求失败（问题部分）: %s", err)
+		} else {
+			ctl.historySearch.Index(repo.MessageSearchDoc{MessageID: qid, UserID: user.ID, RoomID: req.RoomID, Role: repo.MessageRoleUser, Model: req.Model, Content: req.Messages[len(req.Messages)-1].Content})
 		}
 
 		return qid
@@ -811,8 +1325,51 @@ func (ctl *OpenAIController) saveChatQuestion(ctx context.Context, user *auth.Us
 	return 0
 }
 
-func (ctl *OpenAIController) loadRoomContextLen(ctx context.Context, roomID int64, userID int64) int64 {
-	var maxContextLength int64 = 3
+// compressRequest 输入超过模型配置的阈值时，使用辅助模型压缩较早的对话，压缩失败时继续使用原始请求
+func (ctl *OpenAIController) compressRequest(ctx context.Context, req *chat.Request, mod *repo.Model) *chat.CompressionUsage {
+	if mod.Meta.Compression == nil {
+		return nil
+	}
+
+	compressed, usage, err := ctl.compressor.Compress(ctx, *req, mod.Meta.Compression)
+	if err != nil {
+		log.F(log.M{"model": req.Model, "room_id": req.RoomID}).Errorf("compress request failed: %s", err)
+	}
+
+	*req = compressed
+	return usage
+}
+
+// resolveCompressionQuota 计算压缩请求的消耗，按照辅助模型的价格计费
+func (ctl *OpenAIController) resolveCompressionQuota(ctx context.Context, usage *chat.CompressionUsage) QuotaConsume {
+	ret := QuotaConsume{InputTokens: usage.InputTokens, OutputTokens: usage.OutputTokens}
+
+	auxModel := ctl.chatSrv.Model(ctx, usage.Model)
+	if auxModel == nil {
+		log.F(log.M{"model": usage.Model}).Warningf("compression model not found, skip billing")
+		return ret
+	}
+
+	ret.InputPrice, ret.OutputPrice, ret.TotalPrice = usage.ToCoinUsage(auxModel.ToCoinModel()).Detail()
+	return ret
+}
+
+// roomChatSettings 房间（数字人）的对话设置
+type roomChatSettings struct {
+	// MaxContext 最大上下文消息数量
+	MaxContext int64
+	// MergeUserMessages 是否合并连续的用户消息
+	MergeUserMessages bool
+	// MaxImages 整个对话中图片的最大数量，为 0 时不限制
+	MaxImages int
+	// MaxOutputTokens 流式输出的 Token 数量绝对上限，为 0 时只使用全局配置
+	MaxOutputTokens int
+	// Defaults 房间级别的请求参数默认值
+	Defaults chat.RequestDefaults
+}
+
+func (ctl *OpenAIController) loadRoomSettings(ctx context.Context, roomID int64, userID int64) roomChatSettings {
+	settings := roomChatSettings{MaxContext: 3}
 	if roomID > 0 && userID > 0 {
 		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 		defer cancel()
@@ -822,12 +1379,94 @@ func (ctl *OpenAIController) loadRoomContextLen(ctx context.Context, roomID int6
 			log.F(log.M{"room_id": roomID, "user_id": userID}).Errorf("查询 ROOM 信息失败: %s", err)
 		}
 
-		if room != nil && room.MaxContext > 0 {
-			maxContextLength = room.MaxContext
+		if room != nil {
+			if room.MaxContext > 0 {
+				settings.MaxContext = room.MaxContext
+			}
+
+			settings.MergeUserMessages = room.MergeUserMessages == 1
+			settings.MaxImages = int(room.MaxImages)
+			settings.MaxOutputTokens = int(room.MaxOutputTokens)
+
+			defaults, err := chat.ParseRequestDefaults(room.ChatDefaults)
+			if err != nil {
+				log.F(log.M{"room_id": roomID, "user_id": userID}).Errorf("解析 ROOM 请求参数默认值失败: %s", err)
+			} else {
+				settings.Defaults = defaults
+			}
+		}
+	}
+
+	return settings
+}
+
+// avoidedChannels 查询房间中需要避开的渠道，查询失败时不影响对话
+func (ctl *OpenAIController) avoidedChannels(ctx context.Context, userID, roomID int64) []int64 {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	channels, err := ctl.chatSrv.AvoidedChannels(ctx, userID, roomID)
+	if err != nil {
+		log.F(log.M{"room_id": roomID, "user_id": userID}).Errorf("查询房间需要避开的渠道失败: %s", err)
+		return nil
+	}
+
+	return channels
+}
+
+// pinnedMessages 查询房间中固定的消息，查询失败时不影响对话
+func (ctl *OpenAIController) pinnedMessages(ctx context.Context, userID, roomID int64) chat.Messages {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	messages, err := ctl.chatSrv.PinnedMessages(ctx, userID, roomID)
+	if err != nil {
+		log.F(log.M{"room_id": roomID, "user_id": userID}).Errorf("查询房间固定的消息失败: %s", err)
+		return nil
+	}
+
+	return array.Map(messages, func(item model.ChatMessages, _ int) chat.Message {
+		return chat.Message{
+			Role:    ternary.If(repo.MessageRole(item.Role) == repo.MessageRoleAssistant, chat.RoleAssistant, chat.RoleUser),
+			Content: item.Message,
+		}
+	})
+}
+
+// applyRequestDefaults 为请求中未指定的参数填充默认值，优先级为：请求 > 房间 > 任务类型（chat-task-profiles） > 模型（models.meta） > 部署配置
+func (ctl *OpenAIController) applyRequestDefaults(ctx context.Context, req chat.Request, roomDefaults chat.RequestDefaults) chat.Request {
+	layers := []chat.RequestDefaults{roomDefaults}
+	if profile, name := ctl.taskProfiles.Select(req, roomDefaults); name != "" {
+		layers = append(layers, profile)
+		req.TaskProfile = name
+	}
+
+	if mod := ctl.chatSrv.Model(ctx, req.Model); mod != nil {
+		layers = append(layers, chat.ModelRequestDefaults(mod.Meta))
+	}
+
+	return req.ApplyDefaults(append(layers, chat.DeploymentRequestDefaults(ctl.conf))...)
+}
+
+// resolveUserModel 将用户自定义模型替换为基础模型，并检查 API Key 是否允许使用替换之后的模型，
+// 需要在查询模型信息、填充默认值和计费之前调用
+func (ctl *OpenAIController) resolveUserModel(ctx context.Context, user *auth.User, req *chat.Request, sw *streamwriter.StreamWriter) error {
+	resolved, err := ResolveAPIKeyModel(ctx, ctl.chatSrv, user, *req)
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyModelNotAllowed) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusForbidden))
+		} else if errors.Is(err, chat.ErrUserModelNotFound) {
+			misc.NoError(sw.WriteErrorStream(err, http.StatusNotFound))
+		} else {
+			log.F(log.M{"user_id": req.UserID, "model": req.Model}).Errorf("resolve user model failed: %s", err)
+			misc.NoError(sw.WriteErrorStream(errors.New("当前模型暂不可用"), http.StatusInternalServerError))
 		}
+
+		return err
 	}
 
-	return maxContextLength
+	*req = resolved
+	return nil
 }
 
 // 内容安全检测
@@ -844,15 +1483,49 @@ func (ctl *OpenAIController) contentSafety(req *chat.Request, user *auth.User, s
 	content := req.Messages[len(req.Messages)-1].Content
 	if checkRes := ctl.securitySrv.ChatDetect(content); checkRes != nil {
 		if checkRes.IsReallyUnSafe() {
-			log.F(log.M{"user_id": user.ID, "details": checkRes.ReasonDetail(), "content": content}).Warningf("用户 %d 违规，违规内容：%s", user.ID, checkRes.Reason)
-			ctl.sendViolateContentPolicyResp(sw, checkRes.ReasonDetail())
-			return errors.New("违规内容")
+			filterErr := chat.NewKeywordFilterError(chat.ContentFilterInput, contentFilterCategory(checkRes.Label), content, strings.Split(checkRes.Reason.RiskWords, ","))
+			log.F(log.M{"user_id": user.ID, "details": checkRes.ReasonDetail(), "filter": filterErr.Detail(), "content": content}).Warningf("用户 %d 违规，违规内容：%s", user.ID, checkRes.Reason)
+
+			// 不返回命中的敏感词，避免用户借此试探过滤规则
+			ctl.sendViolateContentPolicyResp(sw, filterErr.Message())
+			return filterErr
 		}
 	}
 
 	return nil
 }
 
+// chatRoleContentLimits 配置的每种角色的单条消息内容的最大字符数量，chat-max-content-runes 作为所有角色的限制
+func chatRoleContentLimits(conf *config.Config) chat.RoleContentLimits {
+	return chat.RoleContentLimits{
+		Default: conf.ChatMaxContentRunes,
+		Roles: map[chat.Role]int{
+			chat.RoleSystem:    conf.ChatMaxSystemContentRunes,
+			chat.RoleUser:      conf.ChatMaxUserContentRunes,
+			chat.RoleAssistant: conf.ChatMaxAssistantContentRunes,
+		},
+	}
+}
+
+// contentFilterCategory 将内容安全检测返回的标签转换为统一的内容分类
+func contentFilterCategory(label string) string {
+	label = strings.ToLower(label)
+	switch {
+	case strings.Contains(label, "political"):
+		return chat.ContentFilterCategoryPolitical
+	case strings.Contains(label, "sexual"), strings.Contains(label, "porn"):
+		return chat.ContentFilterCategorySexual
+	case strings.Contains(label, "violen"), strings.Contains(label, "terror"):
+		return chat.ContentFilterCategoryViolence
+	case strings.Contains(label, "abuse"):
+		return chat.ContentFilterCategoryHarassment
+	case strings.Contains(label, "contraband"):
+		return chat.ContentFilterCategoryDangerous
+	}
+
+	return chat.ContentFilterCategoryUnknown
+}
+
 const violateContentPolicyMessage = "抱歉，您的请求因包含违规内容被系统拦截，如果您对此有任何疑问或想进一步了解详情，欢迎通过以下渠道与我们联系：\n\n服务邮箱：support@aicode.cc\n\n微博：@mylxsw\n\n客服微信：x-prometheus\n\n\n---\n\n> 本次请求不扣除智慧果。"
 
 func (ctl *OpenAIController) sendViolateContentPolicyResp(sw *streamwriter.StreamWriter, detail string) {
@@ -894,7 +1567,7 @@ func (ctl *OpenAIController) Images(ctx context.Context, webCtx web.Context, use
 	}
 
 	if ctl.conf.EnableModelRateLimit {
-		if err := ctl.limiter.Allow(ctx, fmt.Sprintf("chat-limit:u:%d:m:%s:minute", user.ID, model), redis_rate.PerMinute(5)); err != nil {
+		if err := ctl.limiter.AllowScoped(ctx, rate.Scope{Limiter: rate.LimiterUser, Channel: model}, fmt.Sprintf("chat-limit:u:%d:m:%s:minute", user.ID, model), redis_rate.PerMinute(5)); err != nil {
 			if errors.Is(err, rate.ErrRateLimitExceeded) {
 				return webCtx.JSONError("操作频率过高，请稍后再试", http.StatusTooManyRequests)
 			}
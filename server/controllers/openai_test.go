@@ -0,0 +1,46 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/server/controllers"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+func TestChatCompletionStreamResponse_IsTextDelta(t *testing.T) {
+	delta := func(content string) controllers.ChatCompletionStreamResponse {
+		return controllers.ChatCompletionStreamResponse{
+			ID:      "1",
+			Object:  "chat.completion",
+			Created: 1700000000,
+			Model:   "gpt-4o",
+			Choices: []controllers.ChatCompletionStreamChoice{
+				{Delta: controllers.ChatCompletionStreamChoiceDelta{Role: "assistant", Content: content, Parts: []*chat.MultipartContent{}}},
+			},
+			Attempts: []chat.AttemptInfo{},
+		}
+	}
+
+	// 只包含文本增量（空的列表字段与未设置相同）
+	assert.True(t, delta("hello").IsTextDelta())
+	assert.False(t, delta("").IsTextDelta())
+
+	// 错误响应以及其它控制信息都不是文本增量
+	resp := delta("\n\n---\n抱歉，我们遇到了一些错误")
+	resp.ErrorCode = "ERR500"
+	assert.False(t, resp.IsTextDelta())
+
+	resp = delta("hello")
+	finishReason := "stop"
+	resp.Choices[0].FinishReason = &finishReason
+	assert.False(t, resp.IsTextDelta())
+
+	resp = delta("hello")
+	resp.Warning = "images removed"
+	assert.False(t, resp.IsTextDelta())
+
+	resp = delta("hello")
+	resp.Choices[0].Index = 1
+	assert.False(t, resp.IsTextDelta())
+}
@@ -3,6 +3,8 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
 	"github.com/mylxsw/aidea-server/pkg/misc"
 	repo "github.com/mylxsw/aidea-server/pkg/repo"
 	"github.com/mylxsw/aidea-server/pkg/repo/model"
@@ -21,6 +23,7 @@ import (
 	"github.com/mylxsw/glacier/infra"
 	"github.com/mylxsw/glacier/web"
 	"github.com/mylxsw/go-utils/array"
+	"github.com/mylxsw/go-utils/ternary"
 )
 
 // RoomController 数字人
@@ -254,6 +257,30 @@ func (ctl *RoomController) CreateRoom(ctx context.Context, webCtx web.Context, u
 		InitMessage:    req.InitMessage,
 	}
 
+	if req.MergeUserMessages != nil && *req.MergeUserMessages {
+		room.MergeUserMessages = 1
+	}
+
+	if req.ChatDefaults != nil {
+		room.ChatDefaults = *req.ChatDefaults
+	}
+
+	if req.MaxImages != nil {
+		room.MaxImages = *req.MaxImages
+	}
+
+	if req.MaxOutputTokens != nil {
+		room.MaxOutputTokens = *req.MaxOutputTokens
+	}
+
+	if req.Private != nil && *req.Private {
+		room.Private = 1
+	}
+
+	if req.DigestSchedule != nil {
+		room.DigestSchedule = *req.DigestSchedule
+	}
+
 	id, err := ctl.roomRepo.Create(ctx, user.ID, &room, true)
 	if err != nil {
 		if errors.Is(err, repo.ErrRoomNameExists) {
@@ -344,6 +371,18 @@ type RoomRequest struct {
 	SystemPrompt string `json:"system_prompt,omitempty"`
 	InitMessage  string `json:"init_message,omitempty"`
 	MaxContext   int64  `json:"max_context,omitempty"`
+	// MergeUserMessages 是否合并连续的用户消息，为 nil 时表示请求中未指定
+	MergeUserMessages *bool `json:"merge_user_messages,omitempty"`
+	// ChatDefaults 请求参数的默认值（JSON 格式），为 nil 时表示请求中未指定，使用 {} 清除
+	ChatDefaults *string `json:"chat_defaults,omitempty"`
+	// MaxImages 整个对话中图片的最大数量，为 nil 时表示请求中未指定，为 0 时不限制
+	MaxImages *int64 `json:"max_images,omitempty"`
+	// MaxOutputTokens 流式输出的 Token 数量绝对上限，为 nil 时表示请求中未指定，为 0 时只使用全局配置
+	MaxOutputTokens *int64 `json:"max_output_tokens,omitempty"`
+	// Private 是否为私密房间（搜索聊天记录时可以排除），为 nil 时表示请求中未指定
+	Private *bool `json:"private,omitempty"`
+	// DigestSchedule 消息摘要的定时规则（cron 表达式），为 nil 时表示请求中未指定，使用 off 关闭
+	DigestSchedule *string `json:"digest_schedule,omitempty"`
 }
 
 func (ctl *RoomController) parseRoomRequest(webCtx web.Context, isUpdate bool) (*RoomRequest, error) {
@@ -404,6 +443,52 @@ func (ctl *RoomController) parseRoomRequest(webCtx web.Context, isUpdate bool) (
 
 	req.SystemPrompt = systemPrompt
 
+	if merge := webCtx.Input("merge_user_messages"); merge != "" {
+		enabled := merge == "true" || merge == "1"
+		req.MergeUserMessages = &enabled
+	}
+
+	if maxImages := webCtx.Input("max_images"); maxImages != "" {
+		count, err := strconv.ParseInt(maxImages, 10, 64)
+		if err != nil || count < 0 || count > 100 {
+			return nil, errors.New("对话中图片的最大数量必须为 0-100 之间")
+		}
+
+		req.MaxImages = &count
+	}
+
+	if maxOutputTokens := webCtx.Input("max_output_tokens"); maxOutputTokens != "" {
+		tokens, err := strconv.ParseInt(maxOutputTokens, 10, 64)
+		if err != nil || tokens < 0 || tokens > 1000000 {
+			return nil, errors.New("最大输出 Token 数量必须为 0-1000000 之间")
+		}
+
+		req.MaxOutputTokens = &tokens
+	}
+
+	if private := webCtx.Input("private"); private != "" {
+		enabled := private == "true" || private == "1"
+		req.Private = &enabled
+	}
+
+	if schedule := strings.TrimSpace(webCtx.Input("digest_schedule")); schedule != "" {
+		if schedule == "off" {
+			schedule = ""
+		} else if _, err := repo.ParseDigestSchedule(schedule); err != nil {
+			return nil, fmt.Errorf("消息摘要定时规则格式错误：%w", err)
+		}
+
+		req.DigestSchedule = &schedule
+	}
+
+	if chatDefaults := strings.TrimSpace(webCtx.Input("chat_defaults")); chatDefaults != "" {
+		if _, err := chat.ParseRequestDefaults(chatDefaults); err != nil {
+			return nil, fmt.Errorf("请求参数默认值格式错误：%w", err)
+		}
+
+		req.ChatDefaults = &chatDefaults
+	}
+
 	avatarId := webCtx.Int64Input("avatar_id", 0)
 	avatarUrl := webCtx.Input("avatar_url")
 
@@ -486,6 +571,34 @@ func (ctl *RoomController) UpdateRoom(ctx context.Context, webCtx web.Context, u
 		changed = true
 	}
 
+	// 合并连续的用户消息属于对话行为设置，不影响房间内容，不需要标记为自定义房间
+	if req.MergeUserMessages != nil {
+		room.MergeUserMessages = int64(ternary.If(*req.MergeUserMessages, 1, 0))
+	}
+
+	if req.ChatDefaults != nil {
+		room.ChatDefaults = *req.ChatDefaults
+	}
+
+	// 图片数量、最大输出 Token 数量限制属于对话行为设置，不需要标记为自定义房间
+	if req.MaxImages != nil {
+		room.MaxImages = *req.MaxImages
+	}
+
+	if req.MaxOutputTokens != nil {
+		room.MaxOutputTokens = *req.MaxOutputTokens
+	}
+
+	// 私密房间只影响聊天记录搜索，不需要标记为自定义房间
+	if req.Private != nil {
+		room.Private = int64(ternary.If(*req.Private, 1, 0))
+	}
+
+	// 消息摘要只影响后台任务，不需要标记为自定义房间
+	if req.DigestSchedule != nil {
+		room.DigestSchedule = *req.DigestSchedule
+	}
+
 	if changed {
 		// 房间内容发生了变化，需要标记为自定义房间
 		room.RoomType = repo.RoomTypePresetCustom
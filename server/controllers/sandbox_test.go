@@ -0,0 +1,103 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mylxsw/aidea-server/config"
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/aidea-server/server/controllers"
+	"github.com/mylxsw/go-utils/assert"
+)
+
+// countingTransport 记录发出的 HTTP 请求数量，所有请求都直接失败
+type countingTransport struct {
+	count atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	t.count.Add(1)
+	return nil, errors.New("outbound request is not allowed")
+}
+
+// withCountingTransport 替换默认的 Transport，测试结束后恢复
+func withCountingTransport(t *testing.T) *countingTransport {
+	transport := &countingTransport{}
+	origin := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = origin })
+
+	return transport
+}
+
+func sandboxReply(t *testing.T, sandbox *chat.SandboxChat, model, question string) string {
+	res, err := sandbox.Chat(context.TODO(), chat.Request{Model: model, Messages: chat.Messages{{Role: chat.RoleUser, Content: question}}})
+	assert.NoError(t, err)
+
+	return res.Text
+}
+
+func TestOpenAIController_SandboxChat(t *testing.T) {
+	transport := withCountingTransport(t)
+	sandbox := chat.NewSandboxChat(nil, 0)
+	ctl := controllers.NewSandboxOpenAIController(&config.Config{}, sandbox)
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`
+	w := httptest.NewRecorder()
+	ctl.ServeChat(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)), &auth.User{ID: 100, UserType: repo.UserTypeSandbox})
+
+	var replyText string
+	var sandboxMarked, finalMessage bool
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+
+		var resp controllers.ChatCompletionStreamResponse
+		assert.NoError(t, json.Unmarshal([]byte(data), &resp))
+		assert.Equal(t, "", resp.ErrorCode)
+		assert.Equal(t, 1, len(resp.Choices))
+
+		sandboxMarked = sandboxMarked || resp.Sandbox
+		if resp.Choices[0].Delta.Role == "system" {
+			finalMessage = true
+			continue
+		}
+
+		replyText += resp.Choices[0].Delta.Content
+	}
+
+	assert.Equal(t, sandboxReply(t, sandbox, "gpt-4o", "hello"), replyText)
+	assert.True(t, sandboxMarked)
+	assert.True(t, finalMessage)
+	assert.Equal(t, int64(0), transport.count.Load())
+}
+
+func TestAnthropicController_SandboxMessages(t *testing.T) {
+	transport := withCountingTransport(t)
+	sandbox := chat.NewSandboxChat(nil, 0)
+	ctl := controllers.NewSandboxAnthropicController(&config.Config{}, sandbox)
+
+	body := `{"model": "claude-3-opus", "max_tokens": 1024, "messages": [{"role": "user", "content": "hello"}]}`
+	w := httptest.NewRecorder()
+	ctl.ServeMessages(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)), &auth.User{ID: 100, UserType: repo.UserTypeSandbox})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp controllers.AnthropicMessageResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, len(resp.Content))
+	assert.Equal(t, sandboxReply(t, sandbox, "claude-3-opus", "hello"), resp.Content[0].Text)
+	assert.Equal(t, "end_turn", *resp.StopReason)
+	assert.True(t, resp.Usage.InputTokens > 0)
+	assert.True(t, resp.Usage.OutputTokens > 0)
+	assert.Equal(t, int64(0), transport.count.Load())
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mylxsw/aidea-server/pkg/ai/chat"
+	"github.com/mylxsw/aidea-server/pkg/repo"
+	"github.com/mylxsw/aidea-server/pkg/repo/model"
+	"github.com/mylxsw/aidea-server/pkg/service"
+	"github.com/mylxsw/aidea-server/pkg/youdao"
+	"github.com/mylxsw/aidea-server/server/auth"
+	"github.com/mylxsw/aidea-server/server/controllers/common"
+	"github.com/mylxsw/asteria/log"
+	"github.com/mylxsw/glacier/infra"
+	"github.com/mylxsw/glacier/web"
+	"github.com/mylxsw/go-utils/array"
+)
+
+// UserModelController 用户自定义模型：基础模型 + 固定的系统提示语和请求参数，在模型列表中与其它模型一样可以选择
+type UserModelController struct {
+	repo        *repo.Repository         `autowire:"@"`
+	svc         *service.Service         `autowire:"@"`
+	securitySrv *service.SecurityService `autowire:"@"`
+	translater  youdao.Translater        `autowire:"@"`
+}
+
+func NewUserModelController(resolver infra.Resolver) web.Controller {
+	ctl := UserModelController{}
+	resolver.MustAutoWire(&ctl)
+
+	return &ctl
+}
+
+func (ctl *UserModelController) Register(router web.Router) {
+	router.Group("/user-models", func(router web.Router) {
+		router.Get("/", ctl.UserModels)
+		router.Post("/", ctl.CreateUserModel)
+		router.Get("/{code}", ctl.UserModel)
+		router.Put("/{code}", ctl.UpdateUserModel)
+		router.Delete("/{code}", ctl.DeleteUserModel)
+	})
+}
+
+// userModelVisibilities 可见范围的名称
+var userModelVisibilities = map[string]int64{
+	"private": repo.UserModelVisibilityPrivate,
+	"shared":  repo.UserModelVisibilityShared,
+	"public":  repo.UserModelVisibilityPublic,
+}
+
+// UserModelResponse 用户自定义模型信息
+type UserModelResponse struct {
+	// ID 模型 ID，聊天请求中作为 model 使用
+	ID          string `json:"id"`
+	Code        string `json:"code"`
+	Model       string `json:"model"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	// SystemPrompt 系统提示语，只返回给创建者
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	ChatDefaults string `json:"chat_defaults,omitempty"`
+	// Visibility 可见范围：private/shared/public
+	Visibility string    `json:"visibility"`
+	Owned      bool      `json:"owned"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func newUserModelResponse(um model.UserModels, userID int64) UserModelResponse {
+	ret := UserModelResponse{
+		ID:          repo.UserModelID(um.Code),
+		Code:        um.Code,
+		Model:       um.Model,
+		Name:        um.Name,
+		Description: um.Description,
+		AvatarURL:   um.AvatarUrl,
+		Visibility:  "private",
+		Owned:       um.UserId == userID,
+		CreatedAt:   um.CreatedAt,
+	}
+
+	for name, visibility := range userModelVisibilities {
+		if visibility == um.Visibility {
+			ret.Visibility = name
+		}
+	}
+
+	if ret.Owned {
+		ret.SystemPrompt = um.SystemPrompt
+		ret.ChatDefaults = um.ChatDefaults
+	}
+
+	return ret
+}
+
+// UserModels 当前用户创建的自定义模型列表
+func (ctl *UserModelController) UserModels(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	items, err := ctl.repo.UserModel.UserModels(ctx, user.ID)
+	if err != nil {
+		log.F(log.M{"user_id": user.ID}).Errorf("查询用户自定义模型列表失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{
+		"data": array.Map(items, func(item model.UserModels, _ int) UserModelResponse { return newUserModelResponse(item, user.ID) }),
+	})
+}
+
+// UserModel 查询自定义模型信息，可以查询自己创建的、通过链接分享的以及公开的模型
+func (ctl *UserModelController) UserModel(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	um, err := ctl.repo.UserModel.AccessibleUserModel(ctx, webCtx.PathVar("code"), user.ID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, "模型不存在"), http.StatusNotFound)
+		}
+
+		log.F(log.M{"user_id": user.ID, "code": webCtx.PathVar("code")}).Errorf("查询用户自定义模型失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(newUserModelResponse(*um, user.ID))
+}
+
+// CreateUserModel 创建自定义模型
+func (ctl *UserModelController) CreateUserModel(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	um, err := ctl.parseUserModelRequest(ctx, webCtx)
+	if err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
+	if resp := ctl.moderate(webCtx, user, um); resp != nil {
+		return resp
+	}
+
+	code, err := ctl.repo.UserModel.Create(ctx, user.ID, um)
+	if err != nil {
+		log.F(log.M{"user_id": user.ID}).Errorf("创建用户自定义模型失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{
+		"id":   repo.UserModelID(code),
+		"code": code,
+	})
+}
+
+// UpdateUserModel 更新自定义模型
+func (ctl *UserModelController) UpdateUserModel(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	um, err := ctl.parseUserModelRequest(ctx, webCtx)
+	if err != nil {
+		return webCtx.JSONError(err.Error(), http.StatusBadRequest)
+	}
+
+	if resp := ctl.moderate(webCtx, user, um); resp != nil {
+		return resp
+	}
+
+	if err := ctl.repo.UserModel.Update(ctx, user.ID, webCtx.PathVar("code"), um); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return webCtx.JSONError(common.Text(webCtx, ctl.translater, "模型不存在"), http.StatusNotFound)
+		}
+
+		log.F(log.M{"user_id": user.ID, "code": webCtx.PathVar("code")}).Errorf("更新用户自定义模型失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{})
+}
+
+// DeleteUserModel 删除自定义模型
+func (ctl *UserModelController) DeleteUserModel(ctx context.Context, webCtx web.Context, user *auth.User) web.Response {
+	if err := ctl.repo.UserModel.Remove(ctx, user.ID, webCtx.PathVar("code")); err != nil {
+		log.F(log.M{"user_id": user.ID, "code": webCtx.PathVar("code")}).Errorf("删除用户自定义模型失败: %v", err)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
+	}
+
+	return webCtx.JSON(web.M{})
+}
+
+// moderate 保存之前检测系统提示语（以及名称、描述）是否违规，违规时返回错误响应
+func (ctl *UserModelController) moderate(webCtx web.Context, user *auth.User, um *model.UserModels) web.Response {
+	content := strings.Join([]string{um.Name, um.Description, um.SystemPrompt}, "\n")
+	if checkRes := ctl.securitySrv.ChatDetect(content); checkRes != nil && checkRes.IsReallyUnSafe() {
+		log.F(log.M{"user_id": user.ID, "details": checkRes.ReasonDetail(), "content": content}).Warningf("用户 %d 违规，违规内容：%s", user.ID, checkRes.Reason)
+		return webCtx.JSONError(common.Text(webCtx, ctl.translater, "内容违规，已被系统拦截"), http.StatusNotAcceptable)
+	}
+
+	return nil
+}
+
+// parseUserModelRequest 解析并校验创建、更新自定义模型的请求
+func (ctl *UserModelController) parseUserModelRequest(ctx context.Context, webCtx web.Context) (*model.UserModels, error) {
+	name := strings.TrimSpace(webCtx.Input("name"))
+	if name == "" {
+		return nil, errors.New("模型名称不能为空")
+	}
+
+	if utf8.RuneCountInString(name) > 30 {
+		return nil, errors.New("模型名称不能超过 30 个字符")
+	}
+
+	description := webCtx.Input("description")
+	if utf8.RuneCountInString(description) > 100 {
+		return nil, errors.New("模型描述不能超过 100 个字符")
+	}
+
+	systemPrompt := strings.TrimSpace(webCtx.Input("system_prompt"))
+	if systemPrompt == "" {
+		return nil, errors.New("系统提示语不能为空")
+	}
+
+	if utf8.RuneCountInString(systemPrompt) > 1000 {
+		return nil, errors.New("系统提示语不能超过 1000 个字符")
+	}
+
+	// 基础模型只能是系统中的模型，不能是其它自定义模型
+	modelID := webCtx.Input("model")
+	if _, ok := repo.ParseUserModelID(modelID); ok || modelID == "" {
+		return nil, errors.New("不支持该模型")
+	}
+
+	if mod := ctl.svc.Chat.Model(ctx, modelID); mod == nil || mod.Status == repo.ModelStatusDisabled {
+		return nil, errors.New("不支持该模型")
+	}
+
+	chatDefaults := strings.TrimSpace(webCtx.Input("chat_defaults"))
+	if _, err := chat.ParseRequestDefaults(chatDefaults); err != nil {
+		return nil, fmt.Errorf("请求参数默认值格式错误：%w", err)
+	}
+
+	visibility, ok := userModelVisibilities[webCtx.InputWithDefault("visibility", "private")]
+	if !ok {
+		return nil, errors.New("可见范围只能是 private/shared/public")
+	}
+
+	return &model.UserModels{
+		Model:        modelID,
+		Name:         name,
+		Description:  description,
+		AvatarUrl:    webCtx.Input("avatar_url"),
+		SystemPrompt: systemPrompt,
+		ChatDefaults: chatDefaults,
+		Visibility:   visibility,
+	}, nil
+}
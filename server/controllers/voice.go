@@ -68,7 +68,8 @@ func (ctl *VoiceController) Text2Voice(ctx context.Context, webCtx web.Context,
 		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrInternalError), http.StatusInternalServerError)
 	}
 
-	if quota.Quota < quota.Used+coins.GetTextToVoiceCoins("tts-1", len(text)) {
+	usage := coins.SpeechUsage{Model: voice.BillingModel, Characters: len(text)}
+	if quota.Quota < quota.Used+coins.ComputeFee(usage) {
 		return webCtx.JSONError(common.Text(webCtx, ctl.translater, common.ErrQuotaNotEnough), http.StatusPaymentRequired)
 	}
 
@@ -96,7 +97,7 @@ func (ctl *VoiceController) Text2Voice(ctx context.Context, webCtx web.Context,
 	wg.Wait()
 
 	// 扣除用户的配额
-	if err := ctl.quotaRepo.QuotaConsume(ctx, user.ID, coins.GetTextToVoiceCoins("tts-1", len(text)), repo.NewQuotaUsedMeta("text2voice", "tts-1")); err != nil {
+	if err := ctl.quotaRepo.QuotaConsume(ctx, user.ID, coins.ComputeFee(usage), repo.NewQuotaUsedMeta("text2voice", usage.Model)); err != nil {
 		log.WithFields(log.Fields{
 			"result":  results,
 			"user_id": user.ID,
@@ -81,6 +81,7 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 	// 需要鉴权的 URLs
 	needAuthPrefix := []string{
 		"/v1/audio",             // OpenAI audio to text
+		"/v1/chat/sessions",     // 增量输入会话
 		"/v1/images",            // OpenAI image generation
 		"/v1/group-chat",        // 群聊
 		"/v1/users",             // 用户管理
@@ -97,6 +98,8 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 		"/v1/auth/bind-phone",   // 绑定手机号码
 		"/v1/auth/bind-wechat",  // 绑定微信
 		"/v1/rooms",             // 数字人管理
+		"/v1/messages",          // 聊天记录搜索
+		"/v1/user-models",       // 用户自定义模型
 		"/v1/room-galleries",    // 数字人 Gallery
 		"/v1/voice",             // 语音合成
 		"/v1/admin",             // 管理员接口
@@ -117,7 +120,7 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 	)
 
 	// 添加 web 中间件
-	resolver.MustResolve(func(tk *token.Token, userSrv *service.UserService, limiter *redis_rate.Limiter, translater youdao.Translater) {
+	resolver.MustResolve(func(tk *token.Token, userSrv *service.UserService, limiter *redis_rate.Limiter, limitMetrics rate.MetricsSink, translater youdao.Translater) {
 		mws = append(mws, func(handler web.WebHandler) web.WebHandler {
 			return func(ctx web.Context) web.Response {
 				ctx.Response().Header("aidea-global-alert-id", "20231204")
@@ -148,6 +151,8 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 				return webCtx.JSONError("rate-limiter: interapi server error", http.StatusInternalServerError)
 			}
 
+			limitMetrics.Observe(rate.Event{Scope: rate.Scope{Limiter: rate.LimiterGlobal}, Admitted: m.Remaining > 0})
+
 			if m.Remaining <= 0 {
 				log.WithFields(log.Fields{"ip": clientIP}).Warningf("client request too frequently")
 				return webCtx.JSONError(common.Text(webCtx, translater, "请求频率过高，请稍后再试"), http.StatusTooManyRequests)
@@ -307,6 +312,8 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 		controllers.NewVoiceController(resolver),
 		controllers.NewNotificationController(resolver),
 		controllers.NewArticleController(resolver),
+		controllers.NewMessageController(resolver),
+		controllers.NewUserModelController(resolver),
 	)
 
 	r.Controllers(
@@ -334,6 +341,7 @@ func routes(resolver infra.Resolver, router web.Router, mw web.RequestMiddleware
 		admin.NewSettingController(resolver),
 		admin.NewPaymentController(resolver),
 		admin.NewMessageController(resolver),
+		admin.NewUsageWebhookController(resolver),
 	)
 
 	// 公开访问信息